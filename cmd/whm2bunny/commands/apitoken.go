@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/apitoken"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// apiTokenRole is the role of the token api-token create creates
+var apiTokenRole string
+
+// APITokenCmd groups the management API token commands
+var APITokenCmd = &cobra.Command{
+	Use:   "api-token",
+	Short: "Manage the role-based tokens of the management API",
+	Long: `Manage the named tokens of the management API. Each token has a role:
+
+  read-only  reads states, reports, settings and incidents
+  operator   also retries provisions, purges caches, changes referrer and
+             access rules and acknowledges incidents
+  admin      also uploads certificates and reads the configuration
+
+Tokens are stored as SHA-256 hashes in api_tokens.json next to the state
+file, which the running server re-reads when it changes, or in api.tokens
+of the configuration. api.token, when set, keeps the admin role.`,
+}
+
+var apiTokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a token and print it once",
+	Example: `  whm2bunny api-token create grafana --role read-only
+  whm2bunny api-token create support-panel --role operator`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAPITokenCreate,
+}
+
+var apiTokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the tokens and their roles",
+	Args:  cobra.NoArgs,
+	RunE:  runAPITokenList,
+}
+
+var apiTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke a token",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAPITokenRevoke,
+}
+
+var apiTokenHashCmd = &cobra.Command{
+	Use:     "hash",
+	Short:   "Print the hash of a token read from standard input, for api.tokens",
+	Example: `  whm2bunny api-token hash < token.txt`,
+	Args:    cobra.NoArgs,
+	RunE:    runAPITokenHash,
+}
+
+func init() {
+	RootCmd.AddCommand(APITokenCmd)
+	APITokenCmd.AddCommand(apiTokenCreateCmd)
+	APITokenCmd.AddCommand(apiTokenListCmd)
+	APITokenCmd.AddCommand(apiTokenRevokeCmd)
+	APITokenCmd.AddCommand(apiTokenHashCmd)
+
+	apiTokenCreateCmd.Flags().StringVar(&apiTokenRole, "role", string(apitoken.RoleReadOnly), "role of the token: read-only, operator or admin")
+}
+
+// openAPITokens opens the token store. The commands also work without a
+// config file, without the tokens of api.tokens
+func openAPITokens() (*apitoken.Store, error) {
+	var static []apitoken.Token
+	if cfg, err := loadConfig(cfgFile); err == nil {
+		static = cfg.API.NamedTokens()
+	}
+	return apitoken.NewStore(dataFilePath("api_tokens.json"), static, nil)
+}
+
+func runAPITokenCreate(cmd *cobra.Command, args []string) error {
+	role, err := apitoken.ParseRole(apiTokenRole)
+	if err != nil {
+		return err
+	}
+	store, err := openAPITokens()
+	if err != nil {
+		return err
+	}
+
+	secret, token, err := store.Create(args[0], role, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("apitoken.created", token.Name, token.Role))
+	fmt.Println()
+	fmt.Println(secret)
+	fmt.Println()
+	fmt.Println(i18n.T("apitoken.shown_once"))
+	return nil
+}
+
+func runAPITokenList(cmd *cobra.Command, args []string) error {
+	store, err := openAPITokens()
+	if err != nil {
+		return err
+	}
+	tokens, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		fmt.Println(i18n.T("apitoken.none"))
+		return nil
+	}
+
+	fmt.Printf("%-24s %-10s %-10s %s\n", i18n.T("apitoken.col_name"), i18n.T("apitoken.col_role"), i18n.T("apitoken.col_source"), i18n.T("apitoken.col_created"))
+	fmt.Println(strings.Repeat("-", 70))
+	for _, t := range tokens {
+		source, created := "file", ""
+		if t.Static {
+			source = "config"
+		}
+		if !t.CreatedAt.IsZero() {
+			created = t.CreatedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-24s %-10s %-10s %s\n", t.Name, t.Role, source, created)
+	}
+	return nil
+}
+
+func runAPITokenRevoke(cmd *cobra.Command, args []string) error {
+	store, err := openAPITokens()
+	if err != nil {
+		return err
+	}
+	if err := store.Revoke(args[0]); err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("apitoken.revoked", args[0]))
+	return nil
+}
+
+func runAPITokenHash(cmd *cobra.Command, args []string) error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret := strings.TrimSpace(line)
+	if secret == "" {
+		if err != nil {
+			return fmt.Errorf("failed to read the token from standard input: %w", err)
+		}
+		return fmt.Errorf("no token on standard input")
+	}
+	fmt.Println(apitoken.Hash(secret))
+	return nil
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/gitops"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+var (
+	// applyDryRun only prints the plan
+	applyDryRun bool
+	// applyOut is the file the plan is written to as JSON
+	applyOut string
+)
+
+// ApplyCmd converges the provisioned domains on a domains.yaml file
+var ApplyCmd = &cobra.Command{
+	Use:   "apply <domains.yaml>",
+	Short: "Provision, update and deprovision domains to match a domains.yaml file",
+	Long: `Compare the domains listed in a domains.yaml file with the provisioning
+state, the per-domain overrides and the pull zones on Bunny, print the plan
+and carry it out:
+
+  + listed domains that are not provisioned are provisioned
+  ~ provisioned domains whose package, overrides or pull zone settings
+    differ are updated, as drift --fix would
+  - domains that are not listed are deprovisioned, only with prune: true
+
+A domain without package keeps its current package. Frozen domains are left
+alone. With --dry-run, only the plan is printed; --out also writes it as
+JSON. Each applied plan is saved with the result of every action in the
+plans directory next to the audit log, which records its file name.`,
+	Example: `  whm2bunny apply domains.yaml --dry-run --out plan.json
+  whm2bunny apply domains.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: runApply,
+}
+
+func init() {
+	RootCmd.AddCommand(ApplyCmd)
+
+	ApplyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "print the plan without changing anything")
+	ApplyCmd.Flags().StringVar(&applyOut, "out", "", "write the plan as JSON to a file")
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	spec, err := gitops.Load(args[0])
+	if err != nil {
+		return err
+	}
+
+	planner, err := loadCLIPlanner()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	plan, err := planner.Plan(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	if err := printPlan(plan, applyOut); err != nil {
+		return err
+	}
+	if plan.Empty() || applyDryRun {
+		return nil
+	}
+
+	fmt.Println()
+	if err := planner.Apply(ctx, plan); err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("apply.done",
+		plan.Count(gitops.ActionCreate), plan.Count(gitops.ActionUpdate), plan.Count(gitops.ActionDelete)))
+	return nil
+}
+
+// printPlan prints a plan, in color on a terminal, and writes it as JSON
+// to out when set
+func printPlan(plan *gitops.Plan, out string) error {
+	plan.Print(os.Stdout, colorOutput())
+	if out == "" {
+		return nil
+	}
+	if err := plan.Save(out); err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("apply.saved", out))
+	return nil
+}
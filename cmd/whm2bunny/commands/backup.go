@@ -0,0 +1,280 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/backup"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+var (
+	// backupOut is the file backup run writes the backup to instead of
+	// uploading it
+	backupOut string
+	// restoreFromRemote restores a backup downloaded from the storage
+	restoreFromRemote bool
+	// restoreZones also imports the DNS zones of the backup into Bunny
+	restoreZones bool
+	// restoreDryRun only lists what the backup holds
+	restoreDryRun bool
+)
+
+// BackupCmd groups the backup commands
+var BackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the state, audit log and DNS zones",
+}
+
+var backupRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Make a backup now",
+	Long: `Archive the provisioning state, the state archive, the audit log, the
+operator changes (overrides, frozen domains, bypasses, token keys,
+certificates, incidents, maintenance, API tokens) and, with backup.zones, a
+zone file of each provisioned domain's DNS zone. The archive is encrypted
+with the key of encryption.key_file and uploaded to backup.storage, after
+which the backups past backup.retention are deleted.
+
+With --out the backup is written to a file instead and nothing is uploaded
+or deleted. The server makes the same backups on backup.schedule.`,
+	Example: `  whm2bunny backup run
+  whm2bunny backup run --out /root/whm2bunny.tar.gz.enc`,
+	Args: cobra.NoArgs,
+	RunE: runBackupRun,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backups in the storage",
+	Args:  cobra.NoArgs,
+	RunE:  runBackupList,
+}
+
+// RestoreCmd restores a backup
+var RestoreCmd = &cobra.Command{
+	Use:   "restore [file | name]",
+	Short: "Restore the state and data files from a backup",
+	Long: `Restore a backup written by backup run: a file given as argument or, with
+--from-remote, a backup downloaded from backup.storage, the latest one
+unless its name is given. The backup is decrypted with the keys of
+encryption.key_file and encryption.previous_key_files.
+
+The state, state archive, audit log and operator changes are written next
+to the state file. The files they replace and the state directory are kept,
+renamed with the suffix .before-restore-<time>. Stop the server first; it
+loads the restored state when started. With --zones the DNS zones of the
+backup are also imported into Bunny, adding and updating records as dns
+import does.`,
+	Example: `  whm2bunny restore --from-remote
+  whm2bunny restore --from-remote whm2bunny-20261015T030000Z.tar.gz.enc --zones
+  whm2bunny restore /root/whm2bunny.tar.gz.enc --dry-run`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	RootCmd.AddCommand(BackupCmd)
+	BackupCmd.AddCommand(backupRunCmd)
+	BackupCmd.AddCommand(backupListCmd)
+	RootCmd.AddCommand(RestoreCmd)
+
+	backupRunCmd.Flags().StringVar(&backupOut, "out", "", "write the backup to a file instead of uploading it")
+	RestoreCmd.Flags().BoolVar(&restoreFromRemote, "from-remote", false, "download the backup from backup.storage")
+	RestoreCmd.Flags().BoolVar(&restoreZones, "zones", false, "also import the DNS zones of the backup into Bunny")
+	RestoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "only list what the backup holds")
+}
+
+// loadBackups builds the backup manager of env. The storage is only
+// required for commands that use it
+func loadBackups(env *cliEnv, withStorage bool) (*backup.Manager, error) {
+	cfg := env.config.Backup
+	if !env.keyring.Encrypting() {
+		return nil, fmt.Errorf("backups require encryption.enabled, they are encrypted with its key")
+	}
+
+	var remote backup.Remote
+	if withStorage {
+		if err := cfg.ValidateStorage(); err != nil {
+			return nil, err
+		}
+		bunnyProxy, err := env.config.Proxy.Proxy(config.ProxyBunny)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Bunny proxy: %w", err)
+		}
+		if remote, err = backup.NewRemote(cfg, bunnyProxy.HTTPClient(backup.DefaultTimeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	var zones backup.ZoneExporter
+	if cfg.Zones {
+		zones = env.provisioner.ExportZone
+	}
+	archiver := backup.NewArchiver(env.states, stateFilePath(), zones, nil)
+	return backup.NewManager(archiver, remote, env.keyring, cfg.Prefix, cfg.Retention, nil), nil
+}
+
+func runBackupRun(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+	manager, err := loadBackups(env, backupOut == "")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if backupOut != "" {
+		data, manifest, err := manager.Build(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(backupOut, data, 0600); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		printManifest(manifest)
+		fmt.Println(i18n.T("backup.saved", backupOut))
+		return nil
+	}
+
+	result, err := manager.Run(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	printManifest(result.Manifest)
+	fmt.Println(i18n.T("backup.uploaded", result.Name, result.Size))
+	for _, name := range result.Pruned {
+		fmt.Println("  - " + i18n.T("backup.pruned", name))
+	}
+	return nil
+}
+
+func runBackupList(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+	manager, err := loadBackups(env, true)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	backups, err := manager.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Println(i18n.T("backup.none"))
+		return nil
+	}
+	fmt.Printf("%-40s %12s\n", i18n.T("backup.col_name"), i18n.T("backup.col_size"))
+	fmt.Println(strings.Repeat("-", 53))
+	for _, b := range backups {
+		fmt.Printf("%-40s %12d\n", b.Name, b.Size)
+	}
+	return nil
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	if !restoreFromRemote && len(args) == 0 {
+		return fmt.Errorf("give the backup file to restore, or --from-remote")
+	}
+
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+	manager, err := loadBackups(env, restoreFromRemote)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var archive *backup.Archive
+	source := ""
+	if restoreFromRemote {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if source, archive, err = manager.Fetch(ctx, name); err != nil {
+			return err
+		}
+	} else {
+		source = args[0]
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		if archive, err = backup.Open(data, env.keyring); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	fmt.Println(i18n.T("restore.title", source))
+	printManifest(archive.Manifest)
+	if restoreDryRun {
+		return nil
+	}
+
+	written, err := archive.Restore(stateFilePath(), time.Now())
+	for _, path := range written {
+		fmt.Println("  + " + i18n.T("restore.written", path))
+	}
+	if err != nil {
+		return err
+	}
+
+	if restoreZones {
+		failed := 0
+		for _, domain := range archive.ZoneDomains() {
+			zone, err := archive.Zone(domain)
+			if err == nil {
+				var result *provisioner.ZoneImport
+				result, err = env.provisioner.ImportZone(ctx, domain, zone, false, false)
+				if err == nil && result.Failed() > 0 {
+					err = fmt.Errorf("%d record(s) failed", result.Failed())
+				}
+				if err == nil {
+					fmt.Println("  + " + i18n.T("restore.zone_imported", domain, len(result.Changes), result.Unchanged))
+					continue
+				}
+			}
+			failed++
+			fmt.Println("  ! " + i18n.T("restore.zone_failed", domain, err))
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d zone(s) failed to import", failed)
+		}
+	}
+
+	fmt.Println("\n" + i18n.T("restore.done"))
+	return nil
+}
+
+// printManifest prints what a backup holds
+func printManifest(m backup.Manifest) {
+	printField("  ", i18n.T("backup.created"), m.CreatedAt.Format(time.RFC3339))
+	printField("  ", i18n.T("backup.domains"), m.Domains)
+	printField("  ", i18n.T("backup.files"), strings.Join(m.Files, ", "))
+	printField("  ", i18n.T("backup.zones"), len(m.Zones))
+	for _, e := range m.ZoneErrors {
+		fmt.Println("  ! " + i18n.T("backup.zone_error", e))
+	}
+}
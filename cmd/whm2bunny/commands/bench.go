@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bench"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+var (
+	// benchDomains is how many synthetic domains are provisioned
+	benchDomains int
+	// benchConcurrency is how many provisions run at once
+	benchConcurrency int
+	// benchLatency delays every response of the fake Bunny API
+	benchLatency time.Duration
+	// benchDir keeps the state of the run instead of a temporary directory
+	benchDir string
+)
+
+// BenchCmd provisions synthetic domains against the fake Bunny API
+var BenchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark provisioning against a built-in fake Bunny API",
+	Long: `Provision synthetic domains against an in-memory fake of the Bunny API and
+report the throughput, the p50/p95 provision time, how long domains waited
+for a free worker, and how many state records each provision wrote. No
+request leaves the host and the configuration file is not read: the run
+uses the built-in defaults and an empty state in a temporary directory.
+
+Use --api-latency to simulate the round trip to the real API, and run the
+same command before and after a change to compare.`,
+	Example: `  whm2bunny bench --domains 500 --concurrency 10
+  whm2bunny bench --domains 200 --concurrency 20 --api-latency 80ms`,
+	Args: cobra.NoArgs,
+	RunE: runBench,
+}
+
+func init() {
+	RootCmd.AddCommand(BenchCmd)
+
+	BenchCmd.Flags().IntVar(&benchDomains, "domains", 100, "number of domains to provision")
+	BenchCmd.Flags().IntVar(&benchConcurrency, "concurrency", 10, "number of provisions run at once")
+	BenchCmd.Flags().DurationVar(&benchLatency, "api-latency", 0, "delay added to every fake API response")
+	BenchCmd.Flags().StringVar(&benchDir, "dir", "", "empty directory to keep the state of the run in")
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	if benchLatency < 0 {
+		return fmt.Errorf("--api-latency must not be negative")
+	}
+
+	dir := benchDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "whm2bunny-bench-")
+		if err != nil {
+			return fmt.Errorf("failed to create state directory: %w", err)
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	logger := zap.NewNop()
+	if verbose {
+		cfg := config.Defaults()
+		l, err := initLogger(&cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize logger: %w", err)
+		}
+		logger = l
+	}
+
+	// Ctrl-C stops the run and reports the domains provisioned so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := bench.Run(ctx, bench.Options{
+		Domains:     benchDomains,
+		Concurrency: benchConcurrency,
+		APILatency:  benchLatency,
+		Dir:         dir,
+		Logger:      logger,
+	})
+	if report == nil {
+		return err
+	}
+	printBench(report)
+	return err
+}
+
+// printBench prints a benchmark report
+func printBench(r *bench.Report) {
+	fmt.Println(i18n.T("bench.title", r.Domains, r.Concurrency, benchDuration(r.Elapsed)))
+	printField("  ", i18n.T("bench.succeeded"), r.Succeeded)
+	printField("  ", i18n.T("bench.failed"), r.Failed)
+	printField("  ", i18n.T("bench.throughput"), i18n.T("bench.per_second", r.Throughput))
+	printField("  ", i18n.T("bench.latency"), benchPercentiles(r.Latency))
+	printField("  ", i18n.T("bench.queue_wait"), benchPercentiles(r.QueueWait))
+	printField("  ", i18n.T("bench.api_calls"), i18n.T("bench.per_domain", r.APICalls, r.PerDomain(int64(r.APICalls))))
+	printField("  ", i18n.T("bench.state_writes"), i18n.T("bench.per_domain", r.StateWrites.Writes, r.PerDomain(r.StateWrites.Writes)))
+	printField("  ", i18n.T("bench.state_bytes"), formatBytes(r.StateWrites.Bytes))
+
+	if len(r.Errors) == 0 {
+		return
+	}
+	msgs := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return r.Errors[msgs[i]] > r.Errors[msgs[j]] })
+
+	fmt.Println("\n" + i18n.T("bench.errors"))
+	for _, msg := range msgs {
+		fmt.Printf("  %6d  %s\n", r.Errors[msg], msg)
+	}
+}
+
+// benchPercentiles formats the p50, p95 and maximum of a set of durations
+func benchPercentiles(p bench.Percentiles) string {
+	return i18n.T("bench.percentiles", benchDuration(p.P50), benchDuration(p.P95), benchDuration(p.Max))
+}
+
+// benchDuration formats a duration to the tenth of a millisecond, as
+// provisions against the fake API take milliseconds
+func benchDuration(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/bulk"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+var (
+	// provisionCSVDryRun only validates the file
+	provisionCSVDryRun bool
+	// provisionCSVOut is the results file
+	provisionCSVOut string
+)
+
+// ProvisionCSVCmd provisions the domains listed in a CSV file
+var ProvisionCSVCmd = &cobra.Command{
+	Use:   "provision-csv <domains.csv>",
+	Short: "Provision the domains listed in a CSV file, with per-row overrides",
+	Long: `Provision the domains listed in a CSV file one after the other, as
+provision would, and write the outcome of each row to a results file.
+
+The first line names the columns, in any order; only domain is required:
+
+  domain       domain or subdomain to provision
+  parent       parent domain of a subdomain, listed on an earlier row or
+               already provisioned
+  user         WHM user owning the domain
+  package      WHM package selecting the CDN profile
+  origin_ip    IP of the server hosting the domain, instead of origin.ip
+  profile      CDN profile, instead of the package's
+  geo_zones    geo zones to serve from: asia, eu, na, sa, af (default asia)
+  mail_preset  MX and SPF records: cpanel (default), google, microsoft, none
+
+The whole file is validated first and every invalid row reported, so
+nothing is provisioned until the file is fixed; --dry-run stops there. The
+overrides of a row are saved as the domain's overrides, as domains.yaml
+overrides are. Domains provisioned before are reported as exists and left
+alone, so a file can be run again after fixing the rows that failed.
+
+The results file lists the outcome of each row (provisioned, exists,
+failed or skipped), the error, the DNS zone and pull zone IDs and the CDN
+hostname. Ctrl-C stops after the current row and skips the rest.`,
+	Example: `  whm2bunny provision-csv customers.csv --dry-run
+  whm2bunny provision-csv customers.csv --out results.csv`,
+	Args: cobra.ExactArgs(1),
+	RunE: runProvisionCSV,
+}
+
+func init() {
+	RootCmd.AddCommand(ProvisionCSVCmd)
+
+	ProvisionCSVCmd.Flags().BoolVar(&provisionCSVDryRun, "dry-run", false, "validate the file without provisioning")
+	ProvisionCSVCmd.Flags().StringVar(&provisionCSVOut, "out", "", "results file (default <file>-results.csv)")
+}
+
+func runProvisionCSV(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(true)
+	if err != nil {
+		return err
+	}
+	svc := app.NewService(env.provisioner, env.states, env.client, nil)
+	runner := bulk.NewRunner(svc, env.overrides, env.config.Profiles, nil)
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	rows, err := runner.Parse(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	fmt.Println(i18n.T("bulk.valid", len(rows)))
+	if provisionCSVDryRun || len(rows) == 0 {
+		return nil
+	}
+
+	out := provisionCSVOut
+	if out == "" {
+		out = strings.TrimSuffix(args[0], ".csv") + "-results.csv"
+	}
+	// Fail before provisioning rather than lose the results
+	results, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	// Ctrl-C stops after the current row
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Println()
+	outcomes := runner.Run(ctx, rows, func(r bulk.Result) {
+		line := fmt.Sprintf("  %-12s %s", i18n.T("bulk.outcome."+string(r.Outcome)), r.Row.Domain)
+		switch {
+		case r.Error != "":
+			line += " - " + r.Error
+		case r.CDNHostname != "":
+			line += " -> " + r.CDNHostname
+		}
+		fmt.Println(line)
+	})
+
+	if err := bulk.WriteResults(results, outcomes); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	fmt.Println()
+	fmt.Println(i18n.T("bulk.done",
+		bulk.Count(outcomes, bulk.OutcomeProvisioned), bulk.Count(outcomes, bulk.OutcomeExists),
+		bulk.Count(outcomes, bulk.OutcomeFailed), bulk.Count(outcomes, bulk.OutcomeSkipped)))
+	fmt.Println(i18n.T("bulk.saved", out))
+
+	if n := bulk.Count(outcomes, bulk.OutcomeFailed); n > 0 {
+		return fmt.Errorf("%d domains failed", n)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// CanonicalHostCmd sets the host a domain's www and apex requests redirect to
+var CanonicalHostCmd = &cobra.Command{
+	Use:   "canonical-host <domain> [apex|www|none]",
+	Short: "Redirect www to the apex of a domain, or the apex to www",
+	Long: `Set the canonical host of a domain, overriding its profile's
+canonical_host. "apex" redirects www.<domain> to <domain> and "www" redirects
+<domain> to www.<domain>, with a 301 from a Bunny edge rule on the pull zone;
+"none" removes the redirect. Without a mode, shows the current canonical host.
+
+The hostname redirected away from must be routed through the CDN:
+"apex" needs dns.record_strategy www_via_cdn or apex_via_cdn and "www" needs
+apex_via_cdn. If the domain is already provisioned the pull zone is updated
+immediately; otherwise the redirect is added when it is provisioned.`,
+	Example: `  whm2bunny canonical-host example.com
+  whm2bunny canonical-host example.com www
+  whm2bunny canonical-host example.com none`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runCanonicalHost,
+}
+
+func init() {
+	RootCmd.AddCommand(CanonicalHostCmd)
+}
+
+func runCanonicalHost(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		host, err := prov.CanonicalHost(domain)
+		if err != nil {
+			return err
+		}
+		fmt.Println(i18n.T("canonicalhost.status", domain, host))
+		return nil
+	}
+
+	err = prov.SetCanonicalHost(context.Background(), domain, args[1])
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		fmt.Println(i18n.T("canonicalhost.saved", domain))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("canonicalhost.now", domain, args[1]))
+	return nil
+}
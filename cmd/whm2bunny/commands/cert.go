@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+var (
+	// certFile is the PEM certificate (chain) to upload
+	certFile string
+	// certKeyFile is the PEM private key to upload
+	certKeyFile string
+	// certHostname is the pull zone hostname the certificate is for
+	certHostname string
+	// certRefresh re-reads managed certificates from Bunny before listing
+	certRefresh bool
+)
+
+// CertCmd manages custom (bring-your-own) certificates
+var CertCmd = &cobra.Command{
+	Use:     "cert",
+	Aliases: []string{"certs"},
+	Short:   "Manage SSL certificates on pull zone hostnames",
+}
+
+var certUploadCmd = &cobra.Command{
+	Use:   "upload <domain>",
+	Short: "Upload a custom certificate for a domain's pull zone hostname",
+	Long: `Validate a PEM certificate and private key and upload them to a hostname of
+the domain's pull zone (the domain itself unless --hostname is given).
+
+The key must match the certificate, the certificate must currently be valid
+and cover the hostname. Its expiry is tracked and a Telegram reminder is
+sent at each of certificates.reminder_days before it expires.`,
+	Example: `  whm2bunny cert upload example.com --cert example.com.crt --key example.com.key
+  whm2bunny cert upload example.com --hostname www.example.com --cert fullchain.pem --key privkey.pem`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCertUpload,
+}
+
+var certListCmd = &cobra.Command{
+	Use:   "list [domain]",
+	Short: "List certificates by soonest expiry",
+	Long: `List the tracked certificates of all pull zone hostnames, or of one domain,
+sorted by soonest expiry. Custom certificates are recorded on upload; the
+Bunny-managed ones are recorded by the server's certificate monitor
+(certificates.monitor) or by --refresh.
+
+A managed certificate inside certificates.alert_days is marked NOT RENEWED:
+Bunny renews well before that, so renewal for the hostname is failing.`,
+	Example: `  whm2bunny certs list
+  whm2bunny certs list example.com --refresh`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCertList,
+}
+
+func init() {
+	RootCmd.AddCommand(CertCmd)
+	CertCmd.AddCommand(certUploadCmd)
+	CertCmd.AddCommand(certListCmd)
+
+	certUploadCmd.Flags().StringVar(&certFile, "cert", "", "PEM certificate file, leaf first followed by the chain")
+	certUploadCmd.Flags().StringVar(&certKeyFile, "key", "", "PEM private key file")
+	certListCmd.Flags().BoolVar(&certRefresh, "refresh", false, "read managed certificates from Bunny before listing")
+	certUploadCmd.Flags().StringVar(&certHostname, "hostname", "", "pull zone hostname (default the domain)")
+	_ = certUploadCmd.MarkFlagRequired("cert")
+	_ = certUploadCmd.MarkFlagRequired("key")
+}
+
+func runCertUpload(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(certKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	entry, err := prov.UploadCertificate(context.Background(), domain, certHostname, certPEM, keyPEM)
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		return fmt.Errorf("%s has no pull zone yet; provision it before uploading a certificate", domain)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("cert.uploaded", entry.Hostname))
+	printCertificate(entry)
+	return nil
+}
+
+func runCertList(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	if certRefresh {
+		n := prov.MonitorCertificates(context.Background())
+		fmt.Println(i18n.T("cert.checked", n))
+	}
+
+	domain := ""
+	if len(args) == 1 {
+		domain = args[0]
+	}
+
+	entries := prov.Certificates(domain)
+	if len(entries) == 0 {
+		fmt.Println(i18n.T("cert.none"))
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].NotAfter.Before(entries[j].NotAfter)
+	})
+
+	now := time.Now()
+	for _, e := range entries {
+		source := certs.SourceCustom
+		if e.Managed() {
+			source = certs.SourceManaged
+		}
+		fmt.Printf("%-40s %-8s %-10s %s  %s\n",
+			e.Hostname,
+			source,
+			e.Status,
+			i18n.T("cert.expires", e.NotAfter.Format("2006-01-02"), e.DaysLeft(now)),
+			certExpiryStatus(e, now, prov.CertificateThresholds(e)),
+		)
+	}
+	return nil
+}
+
+// certExpiryStatus flags certificates inside their reminder thresholds
+func certExpiryStatus(e certs.Entry, now time.Time, thresholds []int) string {
+	left := e.DaysLeft(now)
+	if left < 0 {
+		return i18n.T("cert.status.expired")
+	}
+	for _, t := range thresholds {
+		if left <= t {
+			if e.Managed() {
+				return i18n.T("cert.status.not_renewed")
+			}
+			return i18n.T("cert.status.renew_soon")
+		}
+	}
+	return i18n.T("cert.status.ok")
+}
+
+// printCertificate prints the metadata of an uploaded certificate
+func printCertificate(e certs.Entry) {
+	printField("  ", i18n.T("cert.subject"), e.Subject)
+	printField("  ", i18n.T("cert.issuer"), e.Issuer)
+	printField("  ", i18n.T("cert.names"), joinOrNone(e.DNSNames))
+	printField("  ", i18n.T("cert.valid"), i18n.T("cert.valid_range", e.NotBefore.Format(time.RFC3339), e.NotAfter.Format(time.RFC3339)))
+	printField("  ", i18n.T("cert.fingerprint"), e.Fingerprint)
+}
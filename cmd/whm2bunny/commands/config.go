@@ -9,6 +9,8 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
 )
 
 // ConfigCmd handles configuration management
@@ -34,12 +36,35 @@ var configValidateCmd = &cobra.Command{
 	RunE:  runConfigValidate,
 }
 
+var configTemplatesCmd = &cobra.Command{
+	Use:   "templates <dir>",
+	Short: "Write the built-in message templates for editing",
+	Long: `Write the built-in Telegram message templates to a directory, one
+text/template file per notification and summary type. Existing files are
+left untouched.
+
+The templates are written in --locale, which defaults to the configured
+locale. Set telegram.templates_dir to the directory to use the edited
+templates. Files that are removed fall back to the built-in template.`,
+	Example: `  whm2bunny config templates /etc/whm2bunny/templates
+  whm2bunny config templates --locale id /etc/whm2bunny/templates`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConfigTemplates,
+}
+
 var configShowCmd = &cobra.Command{
 	Use:   "show [config-file]",
 	Short: "Show current configuration",
-	Long:  "Show the configuration that will be used (merges defaults, file, and env vars)",
-	Args:  cobra.MaximumNArgs(1),
-	RunE:  runConfigShow,
+	Long: `Show the configuration that will be used, merging defaults, the config
+file, the conf.d/*.yaml files next to it, the --env overlay and environment
+variables.
+
+With --effective the complete merged configuration is printed as YAML, with
+secrets redacted, preceded by the files it was merged from.`,
+	Example: `  whm2bunny config show
+  whm2bunny --env staging config show --effective`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigShow,
 }
 
 func init() {
@@ -47,6 +72,10 @@ func init() {
 	ConfigCmd.AddCommand(configGenerateCmd)
 	ConfigCmd.AddCommand(configValidateCmd)
 	ConfigCmd.AddCommand(configShowCmd)
+	ConfigCmd.AddCommand(configTemplatesCmd)
+
+	configTemplatesCmd.Flags().String("locale", "", "Locale of the templates (default: configured locale)")
+	configShowCmd.Flags().Bool("effective", false, "Print the complete merged configuration as YAML")
 }
 
 func runConfigGenerate(cmd *cobra.Command, args []string) error {
@@ -61,16 +90,16 @@ func runConfigGenerate(cmd *cobra.Command, args []string) error {
 	// Check if file already exists
 	if _, err := os.Stat(outputPath); err == nil {
 		// File exists
-		fmt.Printf("File already exists: %s\n", outputPath)
-		fmt.Print("Overwrite? (y/N): ")
+		fmt.Println(i18n.T("config.exists", outputPath))
+		fmt.Print(i18n.T("config.overwrite") + " ")
 		var response string
 		if _, err := fmt.Scanln(&response); err != nil {
 			// If there's an error reading input, default to no
-			fmt.Println("\nAborted")
+			fmt.Println("\n" + i18n.T("config.aborted"))
 			return nil
 		}
 		if response != "y" && response != "Y" {
-			fmt.Println("Aborted")
+			fmt.Println(i18n.T("config.aborted"))
 			return nil
 		}
 	}
@@ -112,9 +141,9 @@ func runConfigGenerate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
-	fmt.Printf("Generated config file: %s\n", outputPath)
-	fmt.Println("\nEdit the file to set your configuration.")
-	fmt.Println("Required fields can also be set via environment variables.")
+	fmt.Println(i18n.T("config.generated", outputPath))
+	fmt.Println("\n" + i18n.T("config.edit_hint"))
+	fmt.Println(i18n.T("config.env_hint"))
 
 	return nil
 }
@@ -127,14 +156,19 @@ func runConfigValidate(cmd *cobra.Command, args []string) error {
 	}
 
 	// Try to load the config
-	cfg, err := config.Load(configPath)
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		fmt.Printf("Validation FAILED: %v\n", err)
+		fmt.Println(i18n.T("config.validation_failed", err))
+		return err
+	}
+
+	if _, err := notifier.LoadTemplates(cfg.Locale, cfg.Telegram.TemplatesDir); err != nil {
+		fmt.Println(i18n.T("config.validation_failed", fmt.Errorf("invalid notification templates: %w", err)))
 		return err
 	}
 
-	fmt.Println("Validation PASSED")
-	fmt.Printf("Config loaded from: %s\n", configPath)
+	fmt.Println(i18n.T("config.validation_passed"))
+	fmt.Println(i18n.T("config.loaded_from", configPath))
 	fmt.Printf("Server: %s:%d\n", cfg.Server.Host, cfg.Server.Port)
 	fmt.Printf("Bunny API: %s\n", cfg.Bunny.BaseURL)
 	fmt.Printf("Nameservers: %s, %s\n", cfg.DNS.Nameserver1, cfg.DNS.Nameserver2)
@@ -143,6 +177,31 @@ func runConfigValidate(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runConfigTemplates(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+
+	locale, _ := cmd.Flags().GetString("locale")
+	if locale == "" {
+		if cfg, err := loadConfig(cfgFile); err == nil {
+			locale = cfg.Locale
+		}
+	}
+
+	written, err := notifier.WriteDefaultTemplates(locale, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range written {
+		fmt.Println(i18n.T("config.template_written", filepath.Join(dir, name)))
+	}
+	if skipped := len(notifier.TemplateNames()) - len(written); skipped > 0 {
+		fmt.Println(i18n.T("config.templates_kept", skipped))
+	}
+	fmt.Printf("\n%s\n", i18n.T("config.templates_hint", dir))
+	return nil
+}
+
 func runConfigShow(cmd *cobra.Command, args []string) error {
 	// Determine config path
 	configPath := cfgFile
@@ -151,11 +210,15 @@ func runConfigShow(cmd *cobra.Command, args []string) error {
 	}
 
 	// Load the config
-	cfg, err := config.Load(configPath)
+	cfg, err := loadConfig(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if effective, _ := cmd.Flags().GetBool("effective"); effective {
+		return showEffectiveConfig(cfg)
+	}
+
 	// Display current configuration (masking sensitive values)
 	fmt.Println("Current Configuration:")
 	fmt.Println("=====================")
@@ -169,6 +232,12 @@ func runConfigShow(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Nameserver 1: %s\n", cfg.DNS.Nameserver1)
 	fmt.Printf("  Nameserver 2: %s\n", cfg.DNS.Nameserver2)
 	fmt.Printf("  SOA Email: %s\n", cfg.DNS.SOAEmail)
+	if len(cfg.DNS.SOAEmails) > 0 {
+		fmt.Printf("  Reseller SOA Emails: %v\n", cfg.DNS.SOAEmails)
+	}
+	if cfg.DNS.SOAEmailFromReseller {
+		fmt.Printf("  SOA Email From Reseller: %v\n", cfg.DNS.SOAEmailFromReseller)
+	}
 	fmt.Printf("\nCDN:\n")
 	fmt.Printf("  Origin Shield Region: %s\n", cfg.CDN.OriginShieldRegion)
 	fmt.Printf("  Regions: %v\n", cfg.CDN.Regions)
@@ -181,6 +250,9 @@ func runConfigShow(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Bot Token: %s\n", maskSensitive(cfg.Telegram.BotToken))
 	fmt.Printf("  Chat ID: %s\n", cfg.Telegram.ChatID)
 	fmt.Printf("  Events: %v\n", cfg.Telegram.Events)
+	if cfg.Telegram.TemplatesDir != "" {
+		fmt.Printf("  Templates: %s\n", cfg.Telegram.TemplatesDir)
+	}
 	fmt.Printf("\nLogging:\n")
 	fmt.Printf("  Level: %s\n", cfg.Logging.Level)
 	fmt.Printf("  Format: %s\n", cfg.Logging.Format)
@@ -188,6 +260,28 @@ func runConfigShow(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// showEffectiveConfig prints the merged configuration as YAML, preceded by
+// the files merged into it as comments
+func showEffectiveConfig(cfg *config.Config) error {
+	data, err := cfg.EffectiveYAML()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("# Effective configuration, secrets redacted")
+	layers := cfg.Sources()
+	if len(layers) == 0 {
+		fmt.Println("# Merged from: defaults and environment variables")
+	} else {
+		fmt.Println("# Merged from, in order:")
+		for _, layer := range layers {
+			fmt.Printf("#   %s\n", layer)
+		}
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
 // maskSensitive masks sensitive configuration values
 func maskSensitive(value string) string {
 	if value == "" {
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// convertTier is the tier to convert a pull zone to
+var convertTier string
+
+// ConvertZoneCmd moves a domain's pull zone to another tier
+var ConvertZoneCmd = &cobra.Command{
+	Use:   "convert-zone <domain>",
+	Short: "Convert a domain's pull zone to the standard or volume tier",
+	Long: `Convert a domain's pull zone to the standard or volume (high-bandwidth) tier.
+The tier is recorded for the domain, overriding its profile, so drift checks
+keep it. Conversions Bunny does not allow are reported and not recorded.
+
+If the domain is not provisioned yet, the tier applies when it is.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConvertZone,
+}
+
+func init() {
+	RootCmd.AddCommand(ConvertZoneCmd)
+
+	ConvertZoneCmd.Flags().StringVar(&convertTier, "tier", "", "target tier: standard or volume")
+	_ = ConvertZoneCmd.MarkFlagRequired("tier")
+}
+
+func runConvertZone(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	zoneType, err := bunny.ParsePullZoneType(convertTier)
+	if err != nil {
+		return err
+	}
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	err = prov.ConvertZone(context.Background(), domain, zoneType)
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		fmt.Println(i18n.T("convert.saved", domain, zoneType))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("convert.done", domain, zoneType))
+	return nil
+}
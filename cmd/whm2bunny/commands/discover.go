@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// discoverProvision provisions discovered subdomains instead of only listing them
+var discoverProvision bool
+
+// DiscoverCmd finds cPanel subdomains that were never provisioned
+var DiscoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Find cPanel subdomains of provisioned domains that have no CDN",
+	Long: `Read the cPanel domain list (discovery.userdata_domains, /etc/userdatadomains
+by default) and list the subdomains of provisioned domains that have no
+provisioning state, such as subdomains created before whm2bunny was installed.
+Subdomains backing addon domains are skipped. With --provision, the
+subdomains are provisioned.`,
+	Args: cobra.NoArgs,
+	RunE: runDiscover,
+}
+
+func init() {
+	RootCmd.AddCommand(DiscoverCmd)
+
+	DiscoverCmd.Flags().BoolVar(&discoverProvision, "provision", false, "provision discovered subdomains")
+}
+
+func runDiscover(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(discoverProvision)
+	if err != nil {
+		return err
+	}
+
+	found, err := prov.DiscoverSubdomains(context.Background(), discoverProvision)
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		fmt.Println(i18n.T("discover.none"))
+		return nil
+	}
+
+	for _, sub := range found {
+		status := ""
+		if discoverProvision {
+			status = " [" + i18n.T("discover.provisioned") + "]"
+			if sub.Error != "" {
+				status = " [" + i18n.T("discover.failed", sub.Error) + "]"
+			}
+		}
+		fmt.Printf("%-40s %s%s\n", sub.Domain, i18n.T("discover.owner", sub.Parent, sub.User), status)
+	}
+	return nil
+}
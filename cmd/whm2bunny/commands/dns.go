@@ -0,0 +1,319 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/zonefile"
+)
+
+var (
+	// dnsWait is how long dns check retries until the answers converge
+	dnsWait time.Duration
+	// dnsInterval is how often dns check queries again while waiting
+	dnsInterval time.Duration
+	// dnsReseller is the reseller whose zones dns update-soa updates
+	dnsReseller string
+	// dnsExportOut is the file dns export writes the zone file to
+	dnsExportOut string
+	// dnsImportPrune deletes the records missing from the imported file
+	dnsImportPrune bool
+	// dnsImportDryRun only prints the changes dns import would make
+	dnsImportDryRun bool
+)
+
+// DNSCmd groups DNS utilities
+var DNSCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "DNS utilities",
+}
+
+var dnsCheckCmd = &cobra.Command{
+	Use:   "check <domain>",
+	Short: "Check that public resolvers return a domain's records",
+	Long: `Query Bunny's nameservers (coco.bunny.net, kiki.bunny.net) and the public
+resolvers 1.1.1.1, 8.8.8.8 and 9.9.9.9 for a domain's key records and
+compare the answers with Bunny's:
+
+  - NS, A and MX of the domain
+  - the CNAME of www and of each hostname routed through the CDN
+
+A subdomain only has its CNAME checked. With --wait, the check is repeated
+every --interval until every resolver agrees or --wait passes.`,
+	Example: `  whm2bunny dns check example.com
+  whm2bunny dns check example.com --wait 10m`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDNSCheck,
+}
+
+var dnsUpdateSOACmd = &cobra.Command{
+	Use:   "update-soa",
+	Short: "Set the SOA email of a reseller's DNS zones",
+	Long: `Set the SOA email of the DNS zones of every domain owned by a WHM reseller
+to the one it resolves to: its entry in dns.soa_emails, else its contact
+email in WHM with dns.soa_email_from_reseller, else dns.soa_email.
+
+A reseller's domains are those recorded with it from webhook events and,
+when the WHM API is configured, the domains of the accounts it owns.`,
+	Example: `  whm2bunny dns update-soa --reseller acme`,
+	Args:    cobra.NoArgs,
+	RunE:    runDNSUpdateSOA,
+}
+
+var dnsExportCmd = &cobra.Command{
+	Use:   "export <domain>",
+	Short: "Export a domain's Bunny DNS zone as a zone file",
+	Long: `Write the records of a domain's Bunny DNS zone as an RFC 1035 zone file,
+to standard output or to --out. The file can be restored with dns import
+or imported into another DNS provider.
+
+Disabled records and records of types a zone file cannot hold, such as
+Bunny's pull zone records, are left out. The SOA and Bunny's own NS
+records are managed by Bunny and not exported.`,
+	Example: `  whm2bunny dns export example.com > example.com.zone
+  whm2bunny dns export example.com --out /backup/example.com.zone`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDNSExport,
+}
+
+var dnsImportCmd = &cobra.Command{
+	Use:   "import <domain> <file.zone>",
+	Short: "Apply a zone file to a domain's Bunny DNS zone",
+	Long: `Apply the A, AAAA, CNAME, MX, NS and TXT records of an RFC 1035 zone file
+to a domain's Bunny DNS zone, creating the zone when it does not exist.
+
+Records missing from the zone are added and records differing only in TTL
+or MX preference are updated; a CNAME pointing elsewhere is repointed.
+Other records of the zone are kept unless --prune is given. SOA records
+are ignored and records of other types are listed and skipped.`,
+	Example: `  whm2bunny dns import example.com example.com.zone --dry-run
+  whm2bunny dns import example.com example.com.zone --prune`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDNSImport,
+}
+
+func init() {
+	RootCmd.AddCommand(DNSCmd)
+	DNSCmd.AddCommand(dnsCheckCmd)
+	DNSCmd.AddCommand(dnsUpdateSOACmd)
+	DNSCmd.AddCommand(dnsExportCmd)
+	DNSCmd.AddCommand(dnsImportCmd)
+
+	dnsExportCmd.Flags().StringVar(&dnsExportOut, "out", "", "write the zone file to a file instead of standard output")
+	dnsImportCmd.Flags().BoolVar(&dnsImportPrune, "prune", false, "delete the records of the zone missing from the file")
+	dnsImportCmd.Flags().BoolVar(&dnsImportDryRun, "dry-run", false, "print the changes without applying them")
+
+	dnsUpdateSOACmd.Flags().StringVar(&dnsReseller, "reseller", "", "WHM reseller whose zones are updated")
+	_ = dnsUpdateSOACmd.MarkFlagRequired("reseller")
+
+	dnsCheckCmd.Flags().DurationVar(&dnsWait, "wait", 0, "retry until the records propagated or this long passed")
+	dnsCheckCmd.Flags().DurationVar(&dnsInterval, "interval", 0, "how often to query again with --wait (default dns.propagation.interval)")
+}
+
+func runDNSCheck(cmd *cobra.Command, args []string) error {
+	domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsWait+time.Minute)
+	defer cancel()
+
+	report := prov.CheckPropagation(ctx, domain, dnsWait, dnsInterval)
+
+	fmt.Printf("%s\n", i18n.T("dns.title", domain))
+	for _, result := range report.Results {
+		fmt.Println("\n" + result.Query.String())
+		if !result.Authoritative {
+			fmt.Println("  ! " + i18n.T("dns.no_authoritative"))
+		}
+		for _, a := range result.Answers {
+			name := a.Resolver.Name
+			if a.Resolver.Authoritative {
+				name = i18n.T("dns.authoritative", name)
+			}
+			switch {
+			case a.Err != nil:
+				fmt.Printf("  %-30s %v\n", name, a.Err)
+			case a.Resolver.Authoritative:
+				fmt.Printf("  %-30s %s\n", name, dnsValues(a.Values))
+			case result.Matches(a):
+				fmt.Printf("  %-30s %s [%s]\n", name, dnsValues(a.Values), i18n.T("dns.match"))
+			default:
+				fmt.Printf("  %-30s %s [%s]\n", name, dnsValues(a.Values), i18n.T("dns.mismatch"))
+			}
+		}
+	}
+
+	fmt.Println()
+	if report.Propagated() {
+		fmt.Println(i18n.T("dns.propagated", report.Attempts))
+		return nil
+	}
+	pending := make([]string, 0, len(report.Results))
+	for _, q := range report.Pending() {
+		pending = append(pending, q.String())
+	}
+	fmt.Println(i18n.T("dns.pending", report.Attempts, strings.Join(pending, ", ")))
+	return nil
+}
+
+// dnsValues formats the values of an answer, or (none) for a name that
+// does not exist
+func dnsValues(values []string) string {
+	if len(values) == 0 {
+		return i18n.T("common.none")
+	}
+	return strings.Join(values, ", ")
+}
+
+func runDNSUpdateSOA(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	// The accounts WHM lists under the reseller include domains provisioned
+	// before resellers were recorded
+	var users []string
+	if env.config.WHM.Enabled() {
+		client, err := newWHMClient(env.config)
+		if err != nil {
+			return err
+		}
+		accounts, err := client.ListAccounts(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list WHM accounts: %w", err)
+		}
+		for _, acct := range accounts {
+			if strings.EqualFold(acct.Owner, dnsReseller) {
+				users = append(users, acct.User)
+			}
+		}
+		if env.config.DNS.SOAEmailFromReseller {
+			env.provisioner.SetResellerEmails(client.AccountEmail)
+		}
+	}
+
+	updates := env.provisioner.UpdateResellerSOA(ctx, dnsReseller, users)
+	if len(updates) == 0 {
+		fmt.Println(i18n.T("dns.soa_none", dnsReseller))
+		return nil
+	}
+
+	fmt.Println(i18n.T("dns.soa_title", dnsReseller, updates[0].Email))
+	failed := 0
+	for _, u := range updates {
+		status := i18n.T("dns.soa_updated")
+		if u.Error != "" {
+			failed++
+			status = i18n.T("dns.soa_failed", u.Error)
+		}
+		fmt.Printf("  %-38s [%s]\n", u.Domain, status)
+	}
+	fmt.Println("\n" + i18n.T("dns.soa_summary", len(updates)-failed, failed))
+	if failed > 0 {
+		return fmt.Errorf("%d zone(s) failed", failed)
+	}
+	return nil
+}
+
+func runDNSExport(cmd *cobra.Command, args []string) error {
+	domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	zone, err := prov.ExportZone(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; %s exported from Bunny DNS by whm2bunny on %s\n", domain, time.Now().UTC().Format(time.RFC3339))
+	if err := zonefile.Write(&buf, zone); err != nil {
+		return err
+	}
+
+	if dnsExportOut == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(dnsExportOut, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write zone file: %w", err)
+	}
+	fmt.Println(i18n.T("dns.export_saved", len(zone.Records), dnsExportOut))
+	return nil
+}
+
+func runDNSImport(cmd *cobra.Command, args []string) error {
+	domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to open zone file: %w", err)
+	}
+	defer f.Close()
+	zone, err := zonefile.Parse(f, domain)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	result, err := prov.ImportZone(ctx, domain, zone, dnsImportPrune, dnsImportDryRun)
+	if err != nil {
+		return err
+	}
+
+	title := "dns.import_title"
+	if dnsImportDryRun {
+		title = "dns.import_plan"
+	}
+	fmt.Println(i18n.T(title, domain))
+	if result.Created {
+		fmt.Println("  + " + i18n.T("dns.import_zone_created"))
+	}
+	for _, c := range result.Changes {
+		mark := map[string]string{provisioner.ZoneAdd: "+", provisioner.ZoneUpdate: "~", provisioner.ZoneDelete: "-"}[c.Action]
+		line := fmt.Sprintf("  %s %s", mark, c.Record)
+		if c.Error != "" {
+			line += " [" + i18n.T("dns.import_failed", c.Error) + "]"
+		}
+		fmt.Println(line)
+	}
+	for _, skipped := range zone.Skipped {
+		fmt.Println("  ! " + i18n.T("dns.import_skipped", skipped))
+	}
+
+	failed := result.Failed()
+	fmt.Println("\n" + i18n.T("dns.import_summary", len(result.Changes)-failed, result.Unchanged, failed))
+	if failed > 0 {
+		return fmt.Errorf("%d record(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+var (
+	// dnsRecordType narrows a record given by name to one type
+	dnsRecordType string
+	// dnsDisableReason is recorded in the manifest of disabled records
+	dnsDisableReason string
+)
+
+var dnsRecordsCmd = &cobra.Command{
+	Use:   "records <domain>",
+	Short: "List a domain's DNS records and which are disabled",
+	Long: `List the records of a provisioned domain's Bunny DNS zone with their IDs.
+Records disabled with "dns disable" or by an emergency bypass are shown with
+the reason and when they were disabled.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDNSRecords,
+}
+
+var dnsDisableCmd = &cobra.Command{
+	Use:   "disable <domain> <record>",
+	Short: "Disable a DNS record without deleting it",
+	Long: `Disable one record of a provisioned domain's Bunny DNS zone. Bunny keeps the
+record but stops serving it, so the change is undone with "dns enable"
+instead of recreating the record. The record is given by its ID, or by its
+name (@ for the zone apex) with --type when the name has several records.
+
+Disabled records are kept in the manifest of disabled records, and drift
+enforcement leaves them alone until they are enabled again.`,
+	Example: `  whm2bunny dns disable example.com mail --type A --reason "mail migration"
+  whm2bunny dns disable example.com 123456
+  whm2bunny dns enable example.com mail --type A`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDNSToggle(args, false)
+	},
+}
+
+var dnsEnableCmd = &cobra.Command{
+	Use:   "enable <domain> <record>",
+	Short: "Enable a disabled DNS record again",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDNSToggle(args, true)
+	},
+}
+
+func init() {
+	DNSCmd.AddCommand(dnsRecordsCmd)
+	DNSCmd.AddCommand(dnsDisableCmd)
+	DNSCmd.AddCommand(dnsEnableCmd)
+
+	for _, cmd := range []*cobra.Command{dnsDisableCmd, dnsEnableCmd} {
+		cmd.Flags().StringVar(&dnsRecordType, "type", "", "record type, when the name has several records (A, AAAA, CNAME, MX, TXT, ...)")
+	}
+	dnsDisableCmd.Flags().StringVar(&dnsDisableReason, "reason", "", "why the record is disabled, shown by dns records")
+}
+
+func runDNSRecords(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	records, err := prov.DNSRecords(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println(i18n.T("dnsrecords.none", args[0]))
+		return nil
+	}
+
+	for _, r := range records {
+		status := i18n.T("dnsrecords.enabled")
+		if !r.Enabled {
+			status = i18n.T("dnsrecords.disabled")
+		}
+		fmt.Printf("%-10d %-6s %-24s %-40s %s\n", r.ID, r.Type, r.Name, r.Value, status)
+		if r.Disabled != nil {
+			reason := r.Disabled.Reason
+			if reason == "" {
+				reason = "-"
+			}
+			fmt.Printf("%10s %s\n", "", i18n.T("dnsrecords.since", r.Disabled.DisabledAt.Format(time.RFC3339), reason))
+		}
+	}
+	return nil
+}
+
+func runDNSToggle(args []string, enabled bool) error {
+	domain := args[0]
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	id, err := resolveDNSRecord(ctx, prov, domain, args[1], dnsRecordType)
+	if err != nil {
+		return err
+	}
+
+	reason := ""
+	if !enabled {
+		reason = dnsDisableReason
+	}
+	record, err := prov.SetDNSRecordEnabled(ctx, domain, id, enabled, reason)
+	if err != nil {
+		return err
+	}
+
+	key := "dnsrecords.now_disabled"
+	if enabled {
+		key = "dnsrecords.now_enabled"
+	}
+	fmt.Println(i18n.T(key, record.Type, record.Name, record.Value))
+	return nil
+}
+
+// resolveDNSRecord returns the ID of the record given by ID, or by name and
+// optionally type
+func resolveDNSRecord(ctx context.Context, prov *provisioner.Provisioner, domain, record, recordType string) (int64, error) {
+	if id, err := strconv.ParseInt(record, 10, 64); err == nil {
+		return id, nil
+	}
+
+	records, err := prov.DNSRecords(ctx, domain)
+	if err != nil {
+		return 0, err
+	}
+
+	var matches []provisioner.ManagedRecord
+	for _, r := range records {
+		if strings.EqualFold(r.Name, record) && (recordType == "" || strings.EqualFold(r.Type, recordType)) {
+			matches = append(matches, r)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("%s: no record named %q: %w", domain, record, provisioner.ErrRecordNotFound)
+	case 1:
+		return matches[0].ID, nil
+	}
+
+	described := make([]string, 0, len(matches))
+	for _, r := range matches {
+		described = append(described, fmt.Sprintf("%d (%s %s)", r.ID, r.Type, r.Value))
+	}
+	return 0, fmt.Errorf("%s has %d records named %q, pass --type or an ID: %s", domain, len(matches), record, strings.Join(described, ", "))
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/vhost"
+)
+
+// doctorWindow is how far back error statistics are read
+var doctorWindow time.Duration
+
+// DoctorCmd diagnoses a single domain
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor <domain>",
+	Short: "Diagnose a domain's provisioning, origin, CDN errors and certificates",
+	Long: `Check a domain end to end and explain what looks wrong:
+
+  - provisioning state
+  - pull zone
+  - a live request to the origin, sent the way the pull zone sends it
+  - the site through the CDN and at the origin, compared with the origin's
+    default virtual host
+  - the 3xx/4xx/5xx breakdown and origin requests for the last --window
+  - tracked certificates
+
+A high 5xx rate is attributed to the origin when the origin fails now, or
+when there were no more errors than requests forwarded to the origin.
+It is attributed to the CDN when the edge returned more errors than it
+forwarded.`,
+	Example: `  whm2bunny doctor example.com
+  whm2bunny doctor example.com --window 1h`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDoctor,
+}
+
+func init() {
+	RootCmd.AddCommand(DoctorCmd)
+
+	DoctorCmd.Flags().DurationVar(&doctorWindow, "window", 24*time.Hour, "how far back to read error statistics")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	d := prov.Diagnose(ctx, domain, doctorWindow)
+
+	fmt.Printf("%s\n\n", i18n.T("doctor.title", domain))
+
+	fmt.Println(i18n.T("doctor.state"))
+	if d.StateErr != nil {
+		fmt.Printf("  %v\n", d.StateErr)
+	} else {
+		printField("  ", i18n.T("doctor.status"), i18n.T("doctor.status_step", d.State.Status, d.State.StepName()))
+		printField("  ", i18n.T("doctor.pull_zone"), d.State.PullZoneID)
+		if d.State.Error != "" {
+			printField("  ", i18n.T("doctor.last_error"), d.State.Error)
+		}
+		if c := d.State.VHostCheck; c != nil {
+			printField("  ", i18n.T("doctor.vhost_check"), i18n.T("doctor.vhost_checked", c.Result, c.CheckedAt.Format("2006-01-02 15:04")))
+		}
+	}
+
+	fmt.Println("\n" + i18n.T("doctor.pull_zone"))
+	switch {
+	case d.PullZoneErr != nil:
+		fmt.Printf("  %v\n", d.PullZoneErr)
+	case d.PullZone != nil:
+		printField("  ", i18n.T("doctor.name"), d.PullZone.Name)
+		printField("  ", i18n.T("doctor.origin_url"), d.PullZone.OriginURL)
+		for _, h := range d.PullZone.Hostnames {
+			printField("  ", i18n.T("doctor.hostname"), h.Hostname)
+		}
+	default:
+		fmt.Println("  " + i18n.T("common.none"))
+	}
+
+	fmt.Println("\n" + i18n.T("doctor.origin"))
+	printField("  ", i18n.T("doctor.endpoint"), i18n.T("doctor.endpoint_host", d.Origin.URL(prov.OriginIP(domain)), d.Origin.Host(domain)))
+	if d.OriginErr != nil {
+		printField("  ", i18n.T("doctor.response"), d.OriginErr)
+	} else {
+		printField("  ", i18n.T("doctor.response"), d.OriginStatus)
+	}
+
+	if d.VHost != nil {
+		fmt.Println("\n" + i18n.T("doctor.vhost"))
+		printField("  ", i18n.T("doctor.vhost_cdn"), vhostResponse(d.VHost.CDN, d.VHost.CDNErr))
+		printField("  ", i18n.T("doctor.vhost_origin"), vhostResponse(d.VHost.Origin, d.VHost.OriginErr))
+		printField("  ", i18n.T("doctor.vhost_default"), vhostResponse(d.VHost.Default, d.VHost.DefaultErr))
+		printField("  ", i18n.T("doctor.vhost_result"), d.VHost.Result)
+	}
+
+	fmt.Println("\n" + i18n.T("doctor.traffic", doctorWindow))
+	switch {
+	case d.ErrorsErr != nil:
+		fmt.Printf("  %v\n", d.ErrorsErr)
+	case d.Errors != nil:
+		printField("  ", i18n.T("doctor.requests"), i18n.T("doctor.requests_forwarded", d.Errors.TotalRequests, d.Errors.OriginRequests))
+		printField("  ", "3xx", d.Errors.Status3xx)
+		printField("  ", "4xx", fmt.Sprintf("%d (%.1f%%)", d.Errors.Status4xx, d.Errors.Rate4xx()))
+		printField("  ", "5xx", fmt.Sprintf("%d (%.1f%%)", d.Errors.Status5xx, d.Errors.Rate5xx()))
+		printField("  ", i18n.T("doctor.origin_time"), i18n.T("doctor.origin_time_avg", d.Errors.AverageOriginResponseTime))
+	default:
+		fmt.Println("  " + i18n.T("doctor.no_pull_zone"))
+	}
+
+	if len(d.Certificates) > 0 {
+		fmt.Println("\n" + i18n.T("doctor.certificates"))
+		now := time.Now()
+		for _, c := range d.Certificates {
+			fmt.Printf("  %-36s %s\n", c.Hostname, i18n.T("cert.expires", c.NotAfter.Format("2006-01-02"), c.DaysLeft(now)))
+		}
+	}
+
+	fmt.Println("\n" + i18n.T("doctor.findings"))
+	if len(d.Findings) == 0 {
+		fmt.Println("  " + i18n.T("doctor.no_problems"))
+		return nil
+	}
+	for _, f := range d.Findings {
+		fmt.Printf("  ! %s\n", f)
+	}
+	return nil
+}
+
+// vhostResponse describes a response of the virtual host check
+func vhostResponse(resp *vhost.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%d %s", resp.Status, resp.Fingerprint)
+}
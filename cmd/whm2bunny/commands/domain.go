@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/gitops"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+var (
+	// provisionUser, provisionParent and provisionPackage describe the
+	// domain to provision, as the WHM hook would
+	provisionUser    string
+	provisionParent  string
+	provisionPackage string
+	// provisionDryRun prints the plan of the provision, which provisionOut
+	// also writes as JSON
+	provisionDryRun bool
+	provisionOut    string
+	// reportDays is how many days the traffic report covers
+	reportDays int
+)
+
+// ProvisionCmd provisions a domain without a webhook
+var ProvisionCmd = &cobra.Command{
+	Use:   "provision <domain>",
+	Short: "Provision a domain or subdomain",
+	Long: `Provision a domain as the WHM hook would, waiting for the result. Use
+--parent for a subdomain of a provisioned domain and --package to select the
+CDN profile of the WHM package. With --dry-run, only the plan is printed,
+as whm2bunny apply prints it.
+
+The running server keeps active states in memory; stop it or use the webhook
+for domains it may be provisioning at the same time.`,
+	Example: `  whm2bunny provision example.com --user exampleu
+  whm2bunny provision blog.example.com --parent example.com
+  whm2bunny provision example.com --package gold --dry-run --out plan.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runProvision,
+}
+
+// RetryCmd retries a failed provision
+var RetryCmd = &cobra.Command{
+	Use:   "retry <domain>",
+	Short: "Retry a failed provision and wait for the result",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRetry,
+}
+
+// PurgeCmd purges a domain's CDN cache
+var PurgeCmd = &cobra.Command{
+	Use:   "purge <domain> [url...]",
+	Short: "Purge a domain's CDN cache, or only some URLs",
+	Long: `Purge the whole pull zone cache of a domain, or only the given URLs. URLs
+starting with / are taken relative to https://<domain>.`,
+	Example: `  whm2bunny purge example.com
+  whm2bunny purge example.com /css/app.css /js/app.js`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runPurge,
+}
+
+// ReportCmd shows a domain's traffic
+var ReportCmd = &cobra.Command{
+	Use:   "report <domain>",
+	Short: "Show a domain's bandwidth, requests and cache hit rate",
+	Example: `  whm2bunny report example.com
+  whm2bunny report example.com --days 30`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReport,
+}
+
+func init() {
+	RootCmd.AddCommand(ProvisionCmd)
+	RootCmd.AddCommand(RetryCmd)
+	RootCmd.AddCommand(PurgeCmd)
+	RootCmd.AddCommand(ReportCmd)
+
+	ProvisionCmd.Flags().StringVar(&provisionUser, "user", "", "WHM user owning the domain")
+	ProvisionCmd.Flags().StringVar(&provisionParent, "parent", "", "parent domain of a subdomain")
+	ProvisionCmd.Flags().StringVar(&provisionPackage, "package", "", "WHM package selecting the CDN profile")
+	ProvisionCmd.Flags().BoolVar(&provisionDryRun, "dry-run", false, "print the plan without provisioning")
+	ProvisionCmd.Flags().StringVar(&provisionOut, "out", "", "write the plan as JSON to a file (with --dry-run)")
+	ReportCmd.Flags().IntVar(&reportDays, "days", 7, "number of days to include")
+}
+
+func runProvision(cmd *cobra.Command, args []string) error {
+	if provisionDryRun {
+		return planProvision(args[0])
+	}
+
+	svc, err := loadCLIService(true)
+	if err != nil {
+		return err
+	}
+
+	err = svc.ProvisionDomain(app.ProvisionRequest{
+		Domain:       args[0],
+		ParentDomain: provisionParent,
+		User:         provisionUser,
+		Package:      provisionPackage,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printStatus(svc, args[0])
+}
+
+// planProvision prints what provisioning a domain would do
+func planProvision(domain string) error {
+	planner, err := loadCLIPlanner()
+	if err != nil {
+		return err
+	}
+
+	plan, err := planner.PlanDomain(gitops.DomainSpec{
+		Domain:  domain,
+		Parent:  provisionParent,
+		User:    provisionUser,
+		Package: provisionPackage,
+	})
+	if err != nil {
+		return err
+	}
+	return printPlan(plan, provisionOut)
+}
+
+func runRetry(cmd *cobra.Command, args []string) error {
+	svc, err := loadCLIService(true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := svc.Retry(args[0]); err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("retry.scheduled", args[0]))
+	svc.Wait()
+
+	return printStatus(svc, args[0])
+}
+
+func runPurge(cmd *cobra.Command, args []string) error {
+	svc, err := loadCLIService(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	urls := args[1:]
+	if err := svc.Purge(ctx, args[0], urls); err != nil {
+		return err
+	}
+
+	if len(urls) == 0 {
+		fmt.Println(i18n.T("purge.done", args[0]))
+	} else {
+		fmt.Println(i18n.T("purge.urls_done", len(urls), args[0]))
+	}
+	return nil
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	if reportDays < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+
+	svc, err := loadCLIService(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	to := time.Now()
+	report, err := svc.Report(ctx, args[0], to.AddDate(0, 0, -reportDays), to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("report.title", report.Domain, reportDays))
+	printField("  ", i18n.T("report.bandwidth"), formatBytes(report.Bandwidth))
+	printField("  ", i18n.T("report.requests"), notifier.FormatNumber(report.Requests))
+	printField("  ", i18n.T("report.cache_hit_rate"), fmt.Sprintf("%.1f%%", report.CacheHitRate))
+	if report.DNSQueries != nil {
+		printField("  ", i18n.T("report.dns_queries"), notifier.FormatNumber(*report.DNSQueries))
+	}
+	return nil
+}
+
+// printStatus prints a domain's state after a provisioning run
+func printStatus(svc *app.Service, domain string) error {
+	status, err := svc.GetStatus(domain)
+	if err != nil {
+		return err
+	}
+	printStateDetail(status.ProvisionState)
+	if status.Status == state.StatusFailed {
+		return fmt.Errorf("%s: %s", domain, status.Error)
+	}
+	return nil
+}
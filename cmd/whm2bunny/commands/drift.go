@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// driftFix corrects drift instead of only reporting it
+var driftFix bool
+
+// DriftCmd compares pull zones with their profiles
+var DriftCmd = &cobra.Command{
+	Use:   "drift [domain]",
+	Short: "Check pull zones for settings that drifted from their profile",
+	Long: `Compare the tier, origin endpoint, referrer, hotlink protection, access
+rule, origin resilience, Optimizer, token authentication and Perma-Cache
+settings of pull zones with those derived from each domain's profile and
+overrides, and check that the CDN DNS record still points at the pull zone
+(skipped for domains in emergency bypass). Checks all provisioned domains unless one is given. With --fix,
+drifted settings are corrected. Without it, a pull zone named under none of
+the zone name prefixes is reported too; Bunny cannot rename it.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDrift,
+}
+
+func init() {
+	RootCmd.AddCommand(DriftCmd)
+
+	DriftCmd.Flags().BoolVar(&driftFix, "fix", false, "correct drifted settings")
+}
+
+func runDrift(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	report := make(map[string][]provisioner.Drift)
+	if len(args) == 1 {
+		drifts, err := prov.CheckDrift(ctx, args[0], driftFix)
+		if err != nil {
+			return err
+		}
+		if len(drifts) > 0 {
+			report[args[0]] = drifts
+		}
+	} else {
+		report = prov.EnforceDrift(ctx, driftFix)
+	}
+
+	if len(report) == 0 {
+		fmt.Println(i18n.T("drift.none"))
+		return nil
+	}
+
+	domains := make([]string, 0, len(report))
+	for domain := range report {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		fmt.Println(domain)
+		for _, d := range report[domain] {
+			status := ""
+			if driftFix {
+				status = " [" + i18n.T("drift.fix_failed") + "]"
+				if d.Fixed {
+					status = " [" + i18n.T("drift.fixed") + "]"
+				}
+			}
+			fmt.Printf("  %-26s %s%s\n", d.Field, i18n.T("drift.want_got", d.Want, d.Got), status)
+		}
+	}
+	return nil
+}
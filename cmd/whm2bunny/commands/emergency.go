@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// bypassReason is the reason recorded when bypassing the CDN
+var bypassReason string
+
+// EmergencyCmd groups CDN emergency operations
+var EmergencyCmd = &cobra.Command{
+	Use:   "emergency",
+	Short: "CDN emergency operations",
+	Long: `Route a domain around the CDN when it misbehaves. "bypass" disables the DNS
+records that route the domain through its pull zone and adds records pointing
+directly at the origin IP; "restore" deletes those and enables the original
+records again. The disabled records are listed by "whm2bunny dns records".
+Drift enforcement leaves the DNS of bypassed domains alone.`,
+}
+
+var emergencyBypassCmd = &cobra.Command{
+	Use:   "bypass <domain>",
+	Short: "Point a domain's CDN records directly at the origin",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runEmergencyBypass,
+}
+
+var emergencyRestoreCmd = &cobra.Command{
+	Use:   "restore <domain>",
+	Short: "Route a bypassed domain through the CDN again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runEmergencyRestore,
+}
+
+var emergencyStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List bypassed domains",
+	Args:  cobra.NoArgs,
+	RunE:  runEmergencyStatus,
+}
+
+func init() {
+	RootCmd.AddCommand(EmergencyCmd)
+	EmergencyCmd.AddCommand(emergencyBypassCmd)
+	EmergencyCmd.AddCommand(emergencyRestoreCmd)
+	EmergencyCmd.AddCommand(emergencyStatusCmd)
+
+	emergencyBypassCmd.Flags().StringVar(&bypassReason, "reason", "", "reason recorded and sent in notifications")
+}
+
+func runEmergencyBypass(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(true)
+	if err != nil {
+		return err
+	}
+
+	records, err := prov.EmergencyBypass(context.Background(), args[0], bypassReason)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("emergency.bypassed", args[0]))
+	for _, r := range records {
+		fmt.Printf("  %s\n", i18n.T("emergency.was_cname", r.Name, r.Value))
+	}
+	fmt.Println(i18n.T("emergency.restore_hint", args[0]))
+	return nil
+}
+
+func runEmergencyRestore(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(true)
+	if err != nil {
+		return err
+	}
+
+	if err := prov.RestoreFromBypass(context.Background(), args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("emergency.restored", args[0]))
+	return nil
+}
+
+func runEmergencyStatus(cmd *cobra.Command, args []string) error {
+	store, err := bypass.NewStore(dataFilePath("bypass.json"), nil)
+	if err != nil {
+		return err
+	}
+
+	domains := store.Domains()
+	if len(domains) == 0 {
+		fmt.Println(i18n.T("emergency.none"))
+		return nil
+	}
+
+	for _, domain := range domains {
+		entry, _ := store.Get(domain)
+		reason := entry.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Printf("%-40s %s\n", domain, i18n.T("emergency.since",
+			entry.BypassedAt.Format(time.RFC3339),
+			time.Since(entry.BypassedAt).Round(time.Minute),
+			reason,
+		))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// freezeReason is the reason recorded when freezing a domain
+var freezeReason string
+
+// FreezeCmd suspends automation for a domain
+var FreezeCmd = &cobra.Command{
+	Use:   "freeze [domain]",
+	Short: "Suspend automation for a domain, or list frozen domains",
+	Long: `Freeze a domain while support changes its DNS by hand. Recovery, drift
+enforcement, subdomain discovery, package changes and webhook events skip the
+domain and its subdomains (and log that they did) until it is unfrozen.
+Without a domain, list the frozen domains.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runFreeze,
+}
+
+// UnfreezeCmd resumes automation for a frozen domain
+var UnfreezeCmd = &cobra.Command{
+	Use:   "unfreeze <domain>",
+	Short: "Resume automation for a frozen domain",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUnfreeze,
+}
+
+func init() {
+	RootCmd.AddCommand(FreezeCmd)
+	RootCmd.AddCommand(UnfreezeCmd)
+
+	FreezeCmd.Flags().StringVar(&freezeReason, "reason", "", "reason recorded with the freeze, e.g. a ticket number")
+}
+
+func runFreeze(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return listFrozen()
+	}
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	if err := prov.Freeze(args[0], freezeReason); err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("freeze.frozen", args[0]))
+	fmt.Println(i18n.T("freeze.unfreeze_hint", args[0]))
+	return nil
+}
+
+func runUnfreeze(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	if err := prov.Unfreeze(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("freeze.unfrozen", args[0]))
+	return nil
+}
+
+// listFrozen prints the frozen domains with when and why they were frozen
+func listFrozen() error {
+	store, err := freeze.NewStore(dataFilePath("freeze.json"), nil)
+	if err != nil {
+		return err
+	}
+
+	domains := store.Domains()
+	if len(domains) == 0 {
+		fmt.Println(i18n.T("freeze.none"))
+		return nil
+	}
+
+	for _, domain := range domains {
+		entry, _ := store.Get(domain)
+		reason := entry.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Printf("%-40s %s\n", domain, i18n.T("freeze.since",
+			entry.FrozenAt.Format(time.RFC3339),
+			time.Since(entry.FrozenAt).Round(time.Minute),
+			reason,
+		))
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+	"github.com/mordenhost/whm2bunny/internal/gitops"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/tokenauth"
+)
+
+// configEnv returns the environment overlay selected by --env, or by
+// $WHM2BUNNY_ENV when the flag is not given
+func configEnv() string {
+	if cfgEnv != "" {
+		return cfgEnv
+	}
+	return os.Getenv(config.EnvVar)
+}
+
+// loadConfig loads the configuration and switches messages and CLI output
+// to its locale
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.LoadEnv(path, configEnv())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Locale != "" {
+		if err := i18n.SetLocale(cfg.Locale); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// loadCLIProvisioner builds a provisioner for one-off CLI operations on
+// already provisioned domains. The state file is only read; operations
+// that change settings persist them through the shared overrides, key and
+// bypass files. withNotifier connects Telegram for commands that send
+// notifications.
+func loadCLIProvisioner(withNotifier bool) (*provisioner.Provisioner, *overrides.Manager, error) {
+	env, err := loadCLIEnv(withNotifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env.provisioner, env.overrides, nil
+}
+
+// loadCLIService builds the application service over a CLI provisioner
+func loadCLIService(withNotifier bool) (*app.Service, error) {
+	env, err := loadCLIEnv(withNotifier)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := app.NewService(env.provisioner, env.states, env.client, nil)
+	if snapshots, err := state.NewSnapshotStore(snapshotFilePath(), nil, state.WithKeyring(env.keyring)); err == nil {
+		svc.SetSnapshots(snapshots)
+	}
+	return svc, nil
+}
+
+// loadCLIPlanner builds a domains.yaml planner over a CLI provisioner
+func loadCLIPlanner() (*gitops.Planner, error) {
+	env, err := loadCLIEnv(true)
+	if err != nil {
+		return nil, err
+	}
+	auditLog, err := audit.NewLog(dataFilePath("audit.log"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	planner := gitops.NewPlanner(env.provisioner, env.overrides, env.config.Profiles, nil)
+	planner.SetAudit(auditLog, dataFilePath("plans"))
+	return planner, nil
+}
+
+// cliEnv holds what one-off CLI operations are built from
+type cliEnv struct {
+	config      *config.Config
+	client      *bunny.Client
+	states      *state.Manager
+	overrides   *overrides.Manager
+	provisioner *provisioner.Provisioner
+	// keyring encrypts the state files at rest (optional)
+	keyring *encryption.Keyring
+}
+
+// loadCLIEnv loads the configuration and stores for loadCLIProvisioner and
+// loadCLIService
+func loadCLIEnv(withNotifier bool) (*cliEnv, error) {
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	bunnyProxy, err := cfg.Proxy.Proxy(config.ProxyBunny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bunny proxy: %w", err)
+	}
+	client := bunny.NewClient(cfg.Bunny.APIKey,
+		bunny.WithBaseURL(cfg.Bunny.BaseURL),
+		bunny.WithHTTPClient(bunnyProxy.HTTPClient(bunny.DefaultTimeout)),
+	)
+
+	keyring, err := stateKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	stateMgr, err := state.NewManager(stateFilePath(), nil, state.WithKeyring(keyring), state.WithServer(cfg.InstanceName()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	overrideMgr, err := overrides.NewManager(dataFilePath("overrides.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load overrides: %w", err)
+	}
+
+	tokenKeys, err := tokenauth.NewKeyStore(dataFilePath("token_keys.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token keys: %w", err)
+	}
+
+	bypassStore, err := bypass.NewStore(dataFilePath("bypass.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bypass state: %w", err)
+	}
+
+	certStore, err := certs.NewStore(dataFilePath("certificates.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificates: %w", err)
+	}
+
+	freezeStore, err := freeze.NewStore(dataFilePath("freeze.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load frozen domains: %w", err)
+	}
+
+	disabledRecords, err := dnsrecords.NewStore(dataFilePath("dns_records.json"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load disabled DNS records: %w", err)
+	}
+
+	telegram := &notifier.TelegramNotifier{}
+	if withNotifier {
+		telegramProxy, err := cfg.Proxy.Proxy(config.ProxyTelegram)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram proxy: %w", err)
+		}
+		n, err := notifier.NewTelegramNotifier(
+			cfg.Telegram.BotToken,
+			cfg.Telegram.ChatID,
+			cfg.Telegram.Enabled,
+			cfg.Telegram.Events,
+			zap.NewNop(),
+			notifier.WithHTTPClient(telegramProxy.HTTPClient(0)),
+		)
+		if err != nil {
+			fmt.Printf("Warning: Telegram unavailable, continuing without notifications: %v\n", err)
+		} else {
+			telegram = n
+		}
+
+		templates, err := notifier.LoadTemplates(cfg.Locale, cfg.Telegram.TemplatesDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification templates: %w", err)
+		}
+		telegram.SetTemplates(templates)
+		telegram.SetServer(cfg.InstanceName())
+
+		routes := make(map[notifier.Category]notifier.Route, len(cfg.Telegram.Routes))
+		for category, r := range cfg.Telegram.Routes {
+			route, err := notifier.ParseRoute(r.ChatID, r.ThreadID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid telegram.routes.%s: %w", category, err)
+			}
+			routes[notifier.Category(category)] = route
+		}
+		telegram.SetRoutes(routes)
+	}
+
+	prov := provisioner.NewProvisioner(cfg, client, stateMgr, telegram, nil)
+	prov.SetOverrides(overrideMgr)
+	prov.SetTokenKeys(tokenKeys)
+	prov.SetBypass(bypassStore)
+	prov.SetCertificates(certStore)
+	prov.SetFreeze(freezeStore)
+	prov.SetDisabledRecords(disabledRecords)
+
+	return &cliEnv{
+		config:      cfg,
+		client:      client,
+		states:      stateMgr,
+		overrides:   overrideMgr,
+		provisioner: prov,
+		keyring:     keyring,
+	}, nil
+}
+
+// stateKeyring returns the keyring of the state files configured in cfg
+func stateKeyring(cfg *config.Config) (*encryption.Keyring, error) {
+	keyring, err := encryption.Load(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	return keyring, nil
+}
+
+// openStates opens the state for the state commands. They also work
+// without a config file, in which case encrypted states fail to load with
+// an error naming the missing key
+func openStates() (*state.Manager, error) {
+	var opts []state.Option
+	if cfg, err := loadConfig(cfgFile); err == nil {
+		keyring, err := stateKeyring(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, state.WithKeyring(keyring), state.WithServer(cfg.InstanceName()))
+	}
+
+	stateMgr, err := state.NewManager(stateFilePath(), nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return stateMgr, nil
+}
+
+// colorOutput reports whether stdout is a terminal that takes ANSI colors.
+// Setting $NO_COLOR turns them off
+func colorOutput() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printField prints an indented "label: value" line, padding the label so
+// values line up whatever the locale
+func printField(indent, label string, value any) {
+	fmt.Printf("%s%-20s %v\n", indent, label+":", value)
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/idle"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+var (
+	// idleDays and idleMaxRequests override telegram.summary.idle_days and
+	// idle_max_requests
+	idleDays        int
+	idleMaxRequests int64
+)
+
+// reportIdleCmd lists the provisioned domains without traffic
+var reportIdleCmd = &cobra.Command{
+	Use:   "idle",
+	Short: "List provisioned domains with next to no CDN traffic",
+	Long: `List the provisioned domains whose pull zone served at most --max-requests
+requests over the last --days days: candidates for cleanup, or sites whose
+DNS does not point at the CDN. Days are those of telegram.summary.timezone.
+
+Traffic is summed from the daily snapshots the summaries record; domains
+whose snapshots do not cover every day are read from Bunny. Domains
+provisioned within the window are left out. The defaults come from
+telegram.summary.idle_days and idle_max_requests, which also set the idle
+section of the weekly summary.`,
+	Example: `  whm2bunny report idle
+  whm2bunny report idle --days 30 --max-requests 0`,
+	Args: cobra.NoArgs,
+	RunE: runReportIdle,
+}
+
+func init() {
+	ReportCmd.AddCommand(reportIdleCmd)
+
+	reportIdleCmd.Flags().IntVar(&idleDays, "days", config.DefaultIdleDays, "number of days without traffic")
+	reportIdleCmd.Flags().Int64Var(&idleMaxRequests, "max-requests", config.DefaultIdleMaxRequests, "most requests an idle domain may have served")
+}
+
+func runReportIdle(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+
+	cfg := env.config.Telegram.Summary
+	if !cmd.Flags().Changed("days") && cfg.IdleDays > 0 {
+		idleDays = cfg.IdleDays
+	}
+	if !cmd.Flags().Changed("max-requests") {
+		idleMaxRequests = cfg.IdleMaxRequests
+	}
+	if idleDays < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+	if idleMaxRequests < 0 {
+		return fmt.Errorf("--max-requests must not be negative")
+	}
+
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return fmt.Errorf("invalid telegram.summary.timezone: %w", err)
+		}
+	}
+
+	var snapshots idle.Snapshots
+	if store, err := state.NewSnapshotStore(snapshotFilePath(), nil, state.WithKeyring(env.keyring)); err == nil {
+		snapshots = store
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	from, to := idle.Window(time.Now(), idleDays, loc)
+	domains, err := idle.Find(ctx, env.states.ListAll(), snapshots, env.client.GetPullZoneBandwidth, from, to, idleMaxRequests)
+	if err != nil && len(domains) == 0 {
+		return err
+	}
+
+	fmt.Println(i18n.T("idle.title", idleDays, idleMaxRequests, len(domains)))
+	if len(domains) == 0 {
+		fmt.Println("  " + i18n.T("idle.none"))
+	}
+	for _, d := range domains {
+		user := d.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Printf("  %-40s %-16s %8s %10s  %s  %s\n", d.Domain, user, notifier.FormatNumber(d.Requests),
+			formatBytes(d.Bandwidth), d.ProvisionedAt.Format("2006-01-02"), d.Source)
+	}
+	if err != nil {
+		fmt.Println()
+		fmt.Println(i18n.T("idle.unchecked", err))
+	}
+	return nil
+}
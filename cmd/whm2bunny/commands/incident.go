@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+)
+
+var (
+	// incidentAll lists the resolved incidents too
+	incidentAll bool
+	// incidentBy names who acknowledges an incident
+	incidentBy string
+)
+
+// IncidentCmd lists and acknowledges incidents
+var IncidentCmd = &cobra.Command{
+	Use:     "incident",
+	Aliases: []string{"incidents"},
+	Short:   "List and acknowledge alert incidents",
+	Long: `The server's hourly zone check opens an incident when a pull zone's
+bandwidth spikes, its cache hit rate drops below incidents.hit_rate_threshold
+or its origin answers incidents.origin_error_rate percent of requests with
+5xx. Further alerts about the same problem are counted into the incident
+instead of notified again, and the incident resolves itself once the metric
+recovers. Resolved incidents are kept for 30 days.`,
+}
+
+var incidentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List open incidents, oldest first",
+	Args:  cobra.NoArgs,
+	RunE:  runIncidentList,
+}
+
+var incidentAckCmd = &cobra.Command{
+	Use:   "ack <id>",
+	Short: "Acknowledge an incident",
+	Long: `Acknowledge an incident so other operators know it is being looked at.
+Acknowledged incidents still collect alerts and resolve on recovery; the
+acknowledge button under the Telegram alert, /ack in the bot and the API do
+the same.`,
+	Example: `  whm2bunny incident ack INC-12 --by alice`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runIncidentAck,
+}
+
+func init() {
+	RootCmd.AddCommand(IncidentCmd)
+	IncidentCmd.AddCommand(incidentListCmd)
+	IncidentCmd.AddCommand(incidentAckCmd)
+
+	incidentListCmd.Flags().BoolVar(&incidentAll, "all", false, "include resolved incidents")
+	incidentAckCmd.Flags().StringVar(&incidentBy, "by", "cli", "who acknowledges the incident")
+}
+
+// openIncidentStore opens the incident file shared with the server
+func openIncidentStore() (*incident.Store, error) {
+	return incident.NewStore(dataFilePath("incidents.json"), nil)
+}
+
+func runIncidentList(cmd *cobra.Command, args []string) error {
+	store, err := openIncidentStore()
+	if err != nil {
+		return err
+	}
+
+	list := store.Active()
+	if incidentAll {
+		list = store.List()
+	}
+	if len(list) == 0 {
+		if incidentAll {
+			fmt.Println(i18n.T("incident.none_all"))
+		} else {
+			fmt.Println(i18n.T("incident.none"))
+		}
+		return nil
+	}
+
+	for _, inc := range list {
+		fmt.Printf("%-8s %-14s %s\n", inc.ID, i18n.T("incident.status."+string(inc.Status)), i18n.T("incident.kind."+string(inc.Kind)))
+		printField("  ", i18n.T("incident.zone"), inc.Zone)
+		printField("  ", i18n.T("incident.value"), i18n.T("incident.value_text", inc.Value, inc.Threshold))
+		printField("  ", i18n.T("incident.opened"), inc.OpenedAt.Format(time.RFC3339))
+		printField("  ", i18n.T("incident.alerts"), inc.Alerts)
+		if inc.AckedAt != nil {
+			printField("  ", i18n.T("incident.acked_by"), fmt.Sprintf("%s (%s)", inc.AckedBy, inc.AckedAt.Format(time.RFC3339)))
+		}
+		if inc.ResolvedAt != nil {
+			printField("  ", i18n.T("incident.resolved_at"), inc.ResolvedAt.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func runIncidentAck(cmd *cobra.Command, args []string) error {
+	store, err := openIncidentStore()
+	if err != nil {
+		return err
+	}
+
+	inc, err := store.Ack(args[0], incidentBy)
+	if err != nil {
+		return err
+	}
+	if inc.AckedBy != incidentBy {
+		fmt.Println(i18n.T("incident.already_acked", inc.ID, inc.AckedBy))
+		return nil
+	}
+	fmt.Println(i18n.T("incident.acked", inc.ID))
+	return nil
+}
@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/whm"
+)
+
+var (
+	// inventoryWHM reads the cPanel domains from the WHM API
+	inventoryWHM bool
+	// inventoryProvisionMissing provisions domains without a state
+	inventoryProvisionMissing bool
+	// inventoryDeprovisionOrphans removes domains no longer on the server
+	inventoryDeprovisionOrphans bool
+)
+
+// InventoryCmd compares the cPanel domains with the provisioning states
+var InventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Compare the domains on the cPanel server with the provisioned domains",
+	Long: `Read the cPanel domain list (discovery.userdata_domains, /etc/userdatadomains
+by default, or the WHM API with --whm) and compare it with the provisioning
+states. Domains are reported in three groups:
+
+  present        provisioned and on the cPanel server
+  unprovisioned  on the cPanel server but never provisioned
+  orphaned       provisioned but no longer on the cPanel server
+
+Parked domains and the subdomains backing addon domains are not reported as
+unprovisioned, as the webhook does not provision them either. With
+--provision-missing, unprovisioned domains are provisioned; with
+--deprovision-orphans, orphaned domains are deprovisioned. Frozen domains
+are left alone.`,
+	Example: `  whm2bunny inventory
+  whm2bunny inventory --whm --provision-missing`,
+	Args: cobra.NoArgs,
+	RunE: runInventory,
+}
+
+func init() {
+	RootCmd.AddCommand(InventoryCmd)
+
+	InventoryCmd.Flags().BoolVar(&inventoryWHM, "whm", false, "read the cPanel domains from the WHM API")
+	InventoryCmd.Flags().BoolVar(&inventoryProvisionMissing, "provision-missing", false, "provision domains that are not provisioned")
+	InventoryCmd.Flags().BoolVar(&inventoryDeprovisionOrphans, "deprovision-orphans", false, "deprovision domains no longer on the cPanel server")
+}
+
+func runInventory(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(inventoryProvisionMissing || inventoryDeprovisionOrphans)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var domains []provisioner.CPanelDomain
+	if inventoryWHM {
+		client, err := newWHMClient(env.config)
+		if err != nil {
+			return err
+		}
+		domains, err = whmDomains(ctx, client)
+		if err != nil {
+			return err
+		}
+	} else {
+		domains, err = env.provisioner.CPanelDomains()
+		if err != nil {
+			return err
+		}
+	}
+
+	inv, err := env.provisioner.Inventory(ctx, domains, inventoryProvisionMissing, inventoryDeprovisionOrphans)
+	if err != nil {
+		return err
+	}
+
+	printInventory(i18n.T("inventory.present"), inv.Present, "")
+	printInventory(i18n.T("inventory.unprovisioned"), inv.Unprovisioned, actionStatus(inventoryProvisionMissing, "inventory.provisioned"))
+	printInventory(i18n.T("inventory.orphaned"), inv.Orphaned, actionStatus(inventoryDeprovisionOrphans, "inventory.deprovisioned"))
+	return nil
+}
+
+// actionStatus is the status printed for entries an action succeeded on,
+// or empty when the action did not run
+func actionStatus(ran bool, key string) string {
+	if !ran {
+		return ""
+	}
+	return i18n.T(key)
+}
+
+// printInventory prints one inventory group; done is the status of entries
+// acted on without error
+func printInventory(title string, entries []provisioner.InventoryEntry, done string) {
+	fmt.Printf("%s (%d)\n", title, len(entries))
+	if len(entries) == 0 {
+		fmt.Printf("  %s\n", i18n.T("inventory.none"))
+	}
+	for _, e := range entries {
+		kind := e.Type
+		if kind == "" {
+			kind = "-"
+		}
+		line := fmt.Sprintf("  %-40s %-7s %-16s %s", e.Domain, kind, e.User, e.Status)
+		switch {
+		case e.Error != "":
+			line += " [" + i18n.T("inventory.failed", e.Error) + "]"
+		case done != "":
+			line += " [" + done + "]"
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	fmt.Println()
+}
+
+// whmDomains lists the cPanel domains through the WHM API. The subdomain
+// backing an addon domain shares its document root
+func whmDomains(ctx context.Context, client *whm.Client) ([]provisioner.CPanelDomain, error) {
+	infos, err := client.ListDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subByDocRoot := make(map[string]string)
+	for _, info := range infos {
+		if info.Type == "sub" && info.DocRoot != "" {
+			subByDocRoot[info.User+":"+info.DocRoot] = strings.ToLower(info.Domain)
+		}
+	}
+
+	domains := make([]provisioner.CPanelDomain, 0, len(infos))
+	for _, info := range infos {
+		d := provisioner.CPanelDomain{
+			Domain: strings.ToLower(info.Domain),
+			User:   info.User,
+			Type:   info.Type,
+			Target: strings.ToLower(info.ParentDomain),
+		}
+		if info.Type == "addon" {
+			d.Target = subByDocRoot[info.User+":"+info.DocRoot]
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
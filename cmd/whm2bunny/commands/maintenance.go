@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/maintenance"
+)
+
+// maintenanceReason is the reason recorded when enabling maintenance
+var maintenanceReason string
+
+// MaintenanceCmd toggles maintenance mode
+var MaintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Manage maintenance mode",
+	Long: `Toggle maintenance mode. While maintenance is active, new domains are queued
+but not provisioned; deprovisions and status queries continue. The running
+server picks up the change within 30 seconds.`,
+}
+
+var maintenanceOnCmd = &cobra.Command{
+	Use:   "on",
+	Short: "Enable maintenance mode",
+	Args:  cobra.NoArgs,
+	RunE:  runMaintenanceOn,
+}
+
+var maintenanceOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Disable maintenance mode",
+	Long:  "Disable manual maintenance mode (scheduled windows still apply)",
+	Args:  cobra.NoArgs,
+	RunE:  runMaintenanceOff,
+}
+
+var maintenanceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show maintenance status",
+	Args:  cobra.NoArgs,
+	RunE:  runMaintenanceStatus,
+}
+
+func init() {
+	RootCmd.AddCommand(MaintenanceCmd)
+	MaintenanceCmd.AddCommand(maintenanceOnCmd)
+	MaintenanceCmd.AddCommand(maintenanceOffCmd)
+	MaintenanceCmd.AddCommand(maintenanceStatusCmd)
+
+	maintenanceOnCmd.Flags().StringVar(&maintenanceReason, "reason", "manual maintenance", "reason shown in /health and notifications")
+}
+
+// loadMaintenanceManager loads config and opens the shared maintenance file
+func loadMaintenanceManager() (*maintenance.Manager, error) {
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return maintenance.NewManager(dataFilePath("maintenance.json"), cfg.Maintenance, nil)
+}
+
+func runMaintenanceOn(cmd *cobra.Command, args []string) error {
+	m, err := loadMaintenanceManager()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Enable(maintenanceReason); err != nil {
+		return fmt.Errorf("failed to enable maintenance: %w", err)
+	}
+
+	fmt.Println(i18n.T("maintenance.on", maintenanceReason))
+	fmt.Println(i18n.T("maintenance.queued"))
+	return nil
+}
+
+func runMaintenanceOff(cmd *cobra.Command, args []string) error {
+	m, err := loadMaintenanceManager()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Disable(); err != nil {
+		return fmt.Errorf("failed to disable maintenance: %w", err)
+	}
+
+	fmt.Println(i18n.T("maintenance.off"))
+	if status := m.Status(); status.Active {
+		fmt.Println(i18n.T("maintenance.window_active", status.Reason))
+	}
+	return nil
+}
+
+func runMaintenanceStatus(cmd *cobra.Command, args []string) error {
+	m, err := loadMaintenanceManager()
+	if err != nil {
+		return err
+	}
+
+	status := m.Status()
+	if !status.Active {
+		fmt.Println(i18n.T("maintenance.inactive"))
+		return nil
+	}
+
+	kind := i18n.T("maintenance.scheduled")
+	if status.Manual {
+		kind = i18n.T("maintenance.manual")
+	}
+	fmt.Println(i18n.T("maintenance.active", kind))
+	printField("  ", i18n.T("maintenance.reason"), status.Reason)
+	if !status.Since.IsZero() {
+		printField("  ", i18n.T("maintenance.since"), status.Since.Format("2006-01-02 15:04:05 MST"))
+	}
+	if status.Until != nil {
+		printField("  ", i18n.T("maintenance.until"), status.Until.Format("2006-01-02 15:04:05 MST"))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/observe"
+)
+
+// observedClear clears the observations instead of listing them
+var observedClear bool
+
+// ObservedCmd lists what would have been provisioned while provisioning is
+// disabled
+var ObservedCmd = &cobra.Command{
+	Use:   "observed",
+	Short: "List what would have been provisioned while provisioning is disabled",
+	Long: `With provisioning.enabled set to false, whm2bunny records the domains WHM
+events and cPanel discovery would have provisioned or removed, with the
+profile, pull zone, hostnames and SOA email it would have used, and never
+calls Bunny. List those observations, first seen first, or clear them with
+--clear, e.g. before enabling provisioning.`,
+	Args: cobra.NoArgs,
+	RunE: runObserved,
+}
+
+func init() {
+	RootCmd.AddCommand(ObservedCmd)
+
+	ObservedCmd.Flags().BoolVar(&observedClear, "clear", false, "remove every observation")
+}
+
+func runObserved(cmd *cobra.Command, args []string) error {
+	store, err := observe.NewStore(dataFilePath("observed.json"), nil)
+	if err != nil {
+		return err
+	}
+
+	if observedClear {
+		n, err := store.Clear()
+		if err != nil {
+			return err
+		}
+		fmt.Println(i18n.T("observed.cleared", n))
+		return nil
+	}
+
+	list := store.List()
+	if len(list) == 0 {
+		fmt.Println(i18n.T("observed.none"))
+		return nil
+	}
+
+	for _, ob := range list {
+		fmt.Printf("%-40s %s\n", ob.Domain, i18n.T("observed.action."+string(ob.Action)))
+		if ob.User != "" {
+			printField("  ", i18n.T("observed.user"), ob.User)
+		}
+		if ob.Reseller != "" {
+			printField("  ", i18n.T("observed.reseller"), ob.Reseller)
+		}
+		if ob.Action == observe.ActionProvision {
+			printField("  ", i18n.T("observed.profile"), ob.Profile)
+			printField("  ", i18n.T("observed.pull_zone"), ob.PullZone)
+			printField("  ", i18n.T("observed.hostnames"), strings.Join(ob.Hostnames, ", "))
+			if ob.SOAEmail != "" {
+				printField("  ", i18n.T("observed.soa_email"), ob.SOAEmail)
+			}
+		}
+		printField("  ", i18n.T("observed.source"), ob.Source)
+		printField("  ", i18n.T("observed.seen"), i18n.T("observed.seen_value",
+			ob.FirstSeen.Format(time.RFC3339), ob.LastSeen.Format(time.RFC3339), ob.Events))
+	}
+	fmt.Println()
+	fmt.Println(i18n.T("observed.summary", len(list)))
+	return nil
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// OptimizerCmd toggles Bunny Optimizer for a domain
+var OptimizerCmd = &cobra.Command{
+	Use:   "optimizer <domain> [on|off|inherit]",
+	Short: "Toggle Bunny Optimizer for a domain",
+	Long: `Enable or disable Bunny Optimizer (image optimization, WebP, minification)
+for a domain, overriding its profile. "inherit" removes the override so the
+profile setting applies again. Without a mode, shows the current override.
+
+If the domain is already provisioned the pull zone is updated immediately;
+otherwise the setting applies when it is provisioned.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runOptimizer,
+}
+
+func init() {
+	RootCmd.AddCommand(OptimizerCmd)
+}
+
+func runOptimizer(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	prov, overrideMgr, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		o, _ := overrideMgr.Get(domain)
+		switch {
+		case o.Optimizer == nil:
+			fmt.Println(i18n.T("optimizer.status", domain, i18n.T("common.inherited")))
+		case *o.Optimizer:
+			fmt.Println(i18n.T("optimizer.status", domain, i18n.T("common.on_override")))
+		default:
+			fmt.Println(i18n.T("optimizer.status", domain, i18n.T("common.off_override")))
+		}
+		return nil
+	}
+
+	switch args[1] {
+	case "on", "off":
+		enabled := args[1] == "on"
+		err = overrideMgr.Update(domain, func(o *overrides.Override) {
+			o.Optimizer = overrides.Bool(enabled)
+		})
+	case "inherit":
+		err = overrideMgr.Update(domain, func(o *overrides.Override) {
+			o.Optimizer = nil
+		})
+	default:
+		return fmt.Errorf("invalid mode %q (expected on, off or inherit)", args[1])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save override: %w", err)
+	}
+
+	settings, err := prov.ApplyOptimizer(context.Background(), domain)
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		fmt.Println(i18n.T("common.override_saved", domain))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := i18n.T("common.off")
+	if settings.Enabled {
+		state = i18n.T("common.on")
+	}
+	fmt.Println(i18n.T("optimizer.now", domain, state))
+	return nil
+}
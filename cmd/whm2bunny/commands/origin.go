@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+var (
+	originProtocol   string
+	originPort       int
+	originVerifySSL  string
+	originHostHeader string
+	originInherit    bool
+	originCheckOnly  bool
+	originSkipCheck  bool
+)
+
+// OriginCmd shows or changes how a domain's pull zone reaches the origin
+var OriginCmd = &cobra.Command{
+	Use:   "origin <domain>",
+	Short: "Show or change a domain's origin protocol, port and host header",
+	Long: `Show or change how a domain's pull zone connects to the origin, overriding
+its profile. Without flags, shows the effective settings.
+
+Non-default settings (https, a custom port or host header) are checked with a
+request to the origin before they are saved; use --skip-check when the origin
+is not ready yet. --check only runs the check. --inherit removes all origin
+overrides so the profile applies again.
+
+If the domain is already provisioned the pull zone is updated immediately;
+otherwise the settings apply when it is provisioned.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runOrigin,
+}
+
+func init() {
+	RootCmd.AddCommand(OriginCmd)
+
+	OriginCmd.Flags().StringVar(&originProtocol, "protocol", "", "origin protocol: http or https")
+	OriginCmd.Flags().IntVar(&originPort, "port", 0, "origin port (0 uses the protocol default)")
+	OriginCmd.Flags().StringVar(&originVerifySSL, "verify-ssl", "", "verify the origin certificate: on, off or inherit")
+	OriginCmd.Flags().StringVar(&originHostHeader, "host-header", "", "host header sent to the origin")
+	OriginCmd.Flags().BoolVar(&originInherit, "inherit", false, "remove all origin overrides")
+	OriginCmd.Flags().BoolVar(&originCheckOnly, "check", false, "only check that the origin answers with the current settings")
+	OriginCmd.Flags().BoolVar(&originSkipCheck, "skip-check", false, "save without checking the origin")
+}
+
+func runOrigin(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	if originCheckOnly {
+		endpoint := prov.OriginEndpoint(domain)
+		if err := prov.CheckOrigin(ctx, domain, endpoint); err != nil {
+			return err
+		}
+		fmt.Println(i18n.T("origin.answers", domain, endpoint.URL(prov.OriginIP(domain)), endpoint.Host(domain)))
+		return nil
+	}
+
+	flags := cmd.Flags()
+	changed := originInherit || flags.Changed("protocol") || flags.Changed("port") ||
+		flags.Changed("verify-ssl") || flags.Changed("host-header")
+	if !changed {
+		printOriginEndpoint(domain, prov.OriginEndpoint(domain), prov.OriginIP(domain))
+		return nil
+	}
+
+	var verify *bool
+	switch originVerifySSL {
+	case "", "inherit":
+	case "on", "off":
+		verify = overrides.Bool(originVerifySSL == "on")
+	default:
+		return fmt.Errorf("invalid --verify-ssl %q (expected on, off or inherit)", originVerifySSL)
+	}
+
+	endpoint, err := prov.UpdateOrigin(ctx, domain, func(o *overrides.Override) {
+		if originInherit {
+			o.OriginProtocol = ""
+			o.OriginPort = nil
+			o.OriginVerifySSL = nil
+			o.OriginHostHeader = ""
+		}
+		if flags.Changed("protocol") {
+			o.OriginProtocol = originProtocol
+		}
+		if flags.Changed("port") {
+			o.OriginPort = overrides.Int(originPort)
+		}
+		if flags.Changed("verify-ssl") {
+			o.OriginVerifySSL = verify
+		}
+		if flags.Changed("host-header") {
+			o.OriginHostHeader = originHostHeader
+		}
+	}, originSkipCheck)
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		fmt.Println(i18n.T("common.override_saved", domain))
+		printOriginEndpoint(domain, endpoint, prov.OriginIP(domain))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("origin.updated", domain))
+	printOriginEndpoint(domain, endpoint, prov.OriginIP(domain))
+	return nil
+}
+
+// printOriginEndpoint prints the origin settings of a domain
+func printOriginEndpoint(domain string, endpoint bunny.OriginEndpoint, ip string) {
+	printField("", i18n.T("doctor.origin_url"), endpoint.URL(ip))
+	printField("", i18n.T("origin.host_header"), endpoint.Host(domain))
+	if endpoint.Scheme() == "https" {
+		verify := i18n.T("common.off")
+		if endpoint.VerifySSL {
+			verify = i18n.T("common.on")
+		}
+		printField("", i18n.T("origin.verify_ssl"), verify)
+	}
+}
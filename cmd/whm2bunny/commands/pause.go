@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/pause"
+)
+
+var (
+	// pauseReason is the reason recorded when pausing the pipeline
+	pauseReason string
+	// pauseBy names who paused the pipeline
+	pauseBy string
+	// pauseStatus only shows whether the pipeline is paused
+	pauseStatus bool
+)
+
+// PauseCmd pauses the provisioning pipeline
+var PauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause the provisioning pipeline",
+	Long: `Pause the provisioning pipeline, during a Bunny maintenance window or while
+investigating a bad template. Webhooks are still accepted and queued, but the
+running server starts no queued event, and defers recovery, until "resume";
+events already running finish. Pausing again only updates the reason.
+
+The server reads the pause before starting each event, and notices a resume
+within 5 seconds. POST /api/v1/admin/pause and /admin/resume do the same.`,
+	Example: `  whm2bunny pause --reason "Bunny maintenance"
+  whm2bunny pause --status
+  whm2bunny resume`,
+	Args: cobra.NoArgs,
+	RunE: runPause,
+}
+
+// ResumeCmd resumes the provisioning pipeline
+var ResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume the provisioning pipeline and start the queued events",
+	Args:  cobra.NoArgs,
+	RunE:  runResume,
+}
+
+func init() {
+	RootCmd.AddCommand(PauseCmd)
+	RootCmd.AddCommand(ResumeCmd)
+
+	PauseCmd.Flags().StringVar(&pauseReason, "reason", "", "reason shown in /health and GET /api/v1/admin/pause")
+	PauseCmd.Flags().StringVar(&pauseBy, "by", "cli", "who pauses the pipeline")
+	PauseCmd.Flags().BoolVar(&pauseStatus, "status", false, "only show whether the pipeline is paused")
+}
+
+// loadPauseStore opens the pause file shared with the server
+func loadPauseStore() (*pause.Store, error) {
+	return pause.NewStore(dataFilePath("pause.json"), nil)
+}
+
+func runPause(cmd *cobra.Command, args []string) error {
+	store, err := loadPauseStore()
+	if err != nil {
+		return err
+	}
+
+	if pauseStatus {
+		printPause(store.Status())
+		return nil
+	}
+
+	status, err := store.Pause(pauseReason, pauseBy)
+	if err != nil {
+		return fmt.Errorf("failed to pause provisioning: %w", err)
+	}
+
+	printPause(status)
+	fmt.Println(i18n.T("pause.queued"))
+	return nil
+}
+
+func runResume(cmd *cobra.Command, args []string) error {
+	store, err := loadPauseStore()
+	if err != nil {
+		return err
+	}
+
+	prev, err := store.Resume()
+	if err != nil {
+		return fmt.Errorf("failed to resume provisioning: %w", err)
+	}
+
+	if !prev.Paused {
+		fmt.Println(i18n.T("pause.not_paused"))
+		return nil
+	}
+	fmt.Println(i18n.T("pause.resumed"))
+	return nil
+}
+
+// printPause prints whether the pipeline is paused, and by whom and why
+func printPause(status pause.Status) {
+	if !status.Paused {
+		fmt.Println(i18n.T("pause.not_paused"))
+		return
+	}
+
+	fmt.Println(i18n.T("pause.paused"))
+	if status.Reason != "" {
+		printField("  ", i18n.T("pause.reason"), status.Reason)
+	}
+	if status.By != "" {
+		printField("  ", i18n.T("pause.by"), status.By)
+	}
+	printField("  ", i18n.T("pause.since"), status.Since.Format("2006-01-02 15:04:05 MST"))
+}
@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+var (
+	// referrersAllow adds extra allowed referrers
+	referrersAllow []string
+	// referrersBlock adds extra blocked referrers
+	referrersBlock []string
+	// referrersRemove removes extra referrers from both lists
+	referrersRemove []string
+	// referrersHotlink sets hotlink protection (on, off or inherit)
+	referrersHotlink string
+)
+
+// ReferrersCmd manages a domain's referrer and hotlink protection rules
+var ReferrersCmd = &cobra.Command{
+	Use:   "referrers <domain>",
+	Short: "Manage allowed/blocked referrers and hotlink protection for a domain",
+	Long: `Add or remove per-domain referrers on top of the domain's profile, or
+override its hotlink protection setting ("inherit" removes the override).
+Without flags, shows the current extra referrers and the effective rules.
+
+Hotlink protection allows only the domain itself (and its subdomains) plus
+the allowed referrers to embed content from the CDN.
+
+If the domain is already provisioned the pull zone is updated immediately;
+otherwise the rules apply when it is provisioned.`,
+	Example: `  whm2bunny referrers example.com --allow partner.com --hotlink on
+  whm2bunny referrers example.com --block scraper.example --remove partner.com`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReferrers,
+}
+
+func init() {
+	RootCmd.AddCommand(ReferrersCmd)
+
+	ReferrersCmd.Flags().StringSliceVar(&referrersAllow, "allow", nil, "referrers to allow")
+	ReferrersCmd.Flags().StringSliceVar(&referrersBlock, "block", nil, "referrers to block")
+	ReferrersCmd.Flags().StringSliceVar(&referrersRemove, "remove", nil, "extra referrers to remove")
+	ReferrersCmd.Flags().StringVar(&referrersHotlink, "hotlink", "", "hotlink protection: on, off or inherit")
+}
+
+func runReferrers(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	changed := len(referrersAllow) > 0 || len(referrersBlock) > 0 ||
+		len(referrersRemove) > 0 || referrersHotlink != ""
+	if !changed {
+		settings := prov.ReferrerSettings(domain)
+		printReferrers(domain, prov.DomainOverride(domain), settings.Allowed, settings.Blocked)
+		return nil
+	}
+
+	var hotlink *bool
+	switch referrersHotlink {
+	case "", "inherit":
+	case "on", "off":
+		hotlink = overrides.Bool(referrersHotlink == "on")
+	default:
+		return fmt.Errorf("invalid --hotlink %q (expected on, off or inherit)", referrersHotlink)
+	}
+
+	settings, err := prov.UpdateReferrers(context.Background(), domain, func(o *overrides.Override) {
+		o.AllowedReferrers = withoutReferrers(append(o.AllowedReferrers, referrersAllow...), referrersRemove)
+		o.BlockedReferrers = withoutReferrers(append(o.BlockedReferrers, referrersBlock...), referrersRemove)
+		if referrersHotlink != "" {
+			o.HotlinkProtection = hotlink
+		}
+	})
+	if errors.Is(err, provisioner.ErrNotProvisioned) {
+		fmt.Println(i18n.T("referrers.saved", domain))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	printReferrers(domain, prov.DomainOverride(domain), settings.Allowed, settings.Blocked)
+	return nil
+}
+
+// printReferrers prints a domain's extra referrers and effective rules
+func printReferrers(domain string, o overrides.Override, allowed, blocked []string) {
+	hotlink := i18n.T("common.inherited")
+	if o.HotlinkProtection != nil {
+		hotlink = i18n.T("common.off_override")
+		if *o.HotlinkProtection {
+			hotlink = i18n.T("common.on_override")
+		}
+	}
+
+	fmt.Println(i18n.T("referrers.title", domain))
+	printField("  ", i18n.T("referrers.hotlink"), hotlink)
+	printField("  ", i18n.T("referrers.extra_allowed"), joinOrNone(o.AllowedReferrers))
+	printField("  ", i18n.T("referrers.extra_blocked"), joinOrNone(o.BlockedReferrers))
+	printField("  ", i18n.T("referrers.effective_allowed"), joinOrNone(allowed))
+	printField("  ", i18n.T("referrers.effective_blocked"), joinOrNone(blocked))
+}
+
+// withoutReferrers returns referrers minus the removed ones, de-duplicated
+func withoutReferrers(referrers, removed []string) []string {
+	skip := make(map[string]bool, len(removed)+len(referrers))
+	for _, r := range removed {
+		skip[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+
+	var result []string
+	for _, r := range referrers {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r == "" || skip[r] {
+			continue
+		}
+		skip[r] = true
+		result = append(result, r)
+	}
+	return result
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return i18n.T("common.none")
+	}
+	return strings.Join(values, ", ")
+}
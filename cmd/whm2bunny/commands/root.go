@@ -2,11 +2,15 @@ package commands
 
 import (
 	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/config"
 )
 
 var (
 	// cfgFile is the path to the configuration file
 	cfgFile string
+	// cfgEnv is the environment overlay merged over the configuration file
+	cfgEnv string
 	// verbose enables verbose output
 	verbose bool
 )
@@ -27,5 +31,6 @@ func Execute() error {
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "/etc/whm2bunny/config.yaml", "config file path")
+	RootCmd.PersistentFlags().StringVar(&cfgEnv, "env", "", "environment overlay merged over the config file, e.g. staging for config.staging.yaml (default $"+config.EnvVar+")")
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 }
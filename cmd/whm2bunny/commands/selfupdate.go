@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/proxy"
+	"github.com/mordenhost/whm2bunny/internal/selfupdate"
+)
+
+var (
+	// selfUpdateCheck only reports whether an update is available
+	selfUpdateCheck bool
+	// selfUpdateRestart restarts the systemd service after the update
+	selfUpdateRestart bool
+	// selfUpdateInsecure installs a release without update.public_key
+	selfUpdateInsecure bool
+	// selfUpdateAllowDowngrade installs a release older than this binary
+	selfUpdateAllowDowngrade bool
+)
+
+// SelfUpdateCmd replaces the binary with the latest release
+var SelfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace this binary with the latest release",
+	Long: `Download the release archive of this platform from update.url, check it
+against the release's checksums.sha256 and the checksums against their
+Ed25519 signature, checksums.sha256.sig, made with the key of
+update.public_key. Without a key nothing is installed, unless --insecure
+accepts a release checked against its checksums only.
+
+The new binary must run "version" before it replaces this one; it is
+renamed over it, so the swap is atomic, and the previous binary is kept
+next to it as <binary>.old. Nothing is replaced when this binary is the
+release's, and a release older than this binary needs --allow-downgrade.
+--restart restarts the systemd service (update.service) afterwards, and
+--check only reports whether an update is available.
+
+Downloads go through proxy.url.`,
+	Example: `  whm2bunny self-update --check
+  whm2bunny self-update --restart`,
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+func init() {
+	RootCmd.AddCommand(SelfUpdateCmd)
+
+	SelfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false, "only report whether an update is available")
+	SelfUpdateCmd.Flags().BoolVar(&selfUpdateRestart, "restart", false, "restart the systemd service after updating")
+	SelfUpdateCmd.Flags().BoolVar(&selfUpdateInsecure, "insecure", false, "install a release without update.public_key, checked against its checksums only")
+	SelfUpdateCmd.Flags().BoolVar(&selfUpdateAllowDowngrade, "allow-downgrade", false, "install a release older than this binary")
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	var publicKey ed25519.PublicKey
+	if cfg.Update.PublicKey != "" {
+		if publicKey, err = selfupdate.ParsePublicKey(cfg.Update.PublicKey); err != nil {
+			return fmt.Errorf("invalid update.public_key: %w", err)
+		}
+	} else if !selfUpdateInsecure {
+		return fmt.Errorf("%w: set update.public_key, or pass --insecure to trust the checksums of update.url", selfupdate.ErrUnsigned)
+	}
+	p, err := proxy.New(cfg.Proxy.URL, cfg.Proxy.NoProxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %w", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Update.Timeout)
+	defer cancel()
+
+	fmt.Println(i18n.T("update.checking", cfg.Update.URL))
+	updater := selfupdate.New(cfg.Update.URL, publicKey, p.HTTPClient(cfg.Update.Timeout))
+	if publicKey == nil {
+		fmt.Fprintln(os.Stderr, i18n.T("update.insecure"))
+		updater.AllowUnsigned()
+	}
+	release, err := updater.Fetch(ctx, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("update.verified", release.Asset, release.Checksum))
+	if release.Signed {
+		fmt.Println(i18n.T("update.signed"))
+	}
+
+	installed, err := selfupdate.Installed(exe, release.Binary)
+	if err != nil {
+		return err
+	}
+	if installed {
+		fmt.Println(i18n.T("update.current", Version))
+		return nil
+	}
+
+	version, err := selfupdate.Version(exe, release.Binary)
+	if err != nil {
+		return err
+	}
+	if older, _ := selfupdate.Older(version, Version); older {
+		if !selfUpdateAllowDowngrade {
+			return fmt.Errorf("the release is %s, older than the installed %s; pass --allow-downgrade to install it", version, Version)
+		}
+		fmt.Fprintln(os.Stderr, i18n.T("update.downgrade", Version, version))
+	}
+	if selfUpdateCheck {
+		fmt.Println(i18n.T("update.available", Version))
+		return nil
+	}
+
+	if err := selfupdate.Install(exe, release.Binary); err != nil {
+		return err
+	}
+	fmt.Println(i18n.T("update.installed", exe, exe+".old"))
+
+	if !selfUpdateRestart {
+		fmt.Println(i18n.T("update.restart_hint", cfg.Update.Service))
+		return nil
+	}
+	if out, err := exec.Command("systemctl", "restart", cfg.Update.Service).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restart %s: %w: %s", cfg.Update.Service, err, out)
+	}
+	fmt.Println(i18n.T("update.restarted", cfg.Update.Service))
+	return nil
+}
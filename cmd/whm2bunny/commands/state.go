@@ -0,0 +1,307 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+var (
+	// stateArchiveDays overrides archive.after_days for a manual archive run
+	stateArchiveDays int
+	// stateShowArchived lists archived states instead of active ones
+	stateShowArchived bool
+	// stateServer only lists the states provisioned by this instance
+	stateServer string
+)
+
+// StateCmd inspects and maintains the provisioning state
+var StateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and compact provisioning state",
+	Long: `Inspect and maintain provisioning state. Each provision is stored as its own
+file in the state directory (state.d next to STATE_FILE); a monolithic
+state.json from earlier versions is migrated automatically on first start.`,
+}
+
+var stateArchiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Move old successful provisions to the archive file",
+	Long: `Move successful provisions not updated for archive.after_days (or --days)
+from the state directory into the archive file next to it. Archived domains
+are still found by lookups, deprovisioning and drift checks.
+
+The running server keeps active states in memory, so an archive run while it
+is up is completed by the server's next scheduled compaction.`,
+	Args: cobra.NoArgs,
+	RunE: runStateArchive,
+}
+
+var stateExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Write all active states as a single JSON array",
+	Long: `Write all active states as a single JSON array in the monolithic state.json
+format, to a file or stdout. Useful for inspection with jq and for backups.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runStateExport,
+}
+
+var stateShowCmd = &cobra.Command{
+	Use:   "show [domain]",
+	Short: "Show provisioning states",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runStateShow,
+}
+
+var stateReencryptCmd = &cobra.Command{
+	Use:   "reencrypt",
+	Short: "Rewrite the state files with the current encryption key",
+	Long: `Rewrite the state records, the archive, the bandwidth snapshots and the
+notification queue with the key configured in encryption.key_file (or
+STATE_ENCRYPTION_KEY). Files are read with that key, the keys listed in
+encryption.previous_key_files, or in plain JSON, so the command both
+encrypts existing files once encryption is enabled and moves them to a new
+key. With encryption disabled the files are written back in plain JSON.
+
+To rotate the key, add the old key file to encryption.previous_key_files
+and point encryption.key_file at the new one, stop the server, run this
+command, then remove the old key from the config and start the server.`,
+	Args: cobra.NoArgs,
+	RunE: runStateReencrypt,
+}
+
+func init() {
+	RootCmd.AddCommand(StateCmd)
+	StateCmd.AddCommand(stateArchiveCmd)
+	StateCmd.AddCommand(stateShowCmd)
+	StateCmd.AddCommand(stateExportCmd)
+	StateCmd.AddCommand(stateReencryptCmd)
+
+	stateArchiveCmd.Flags().IntVar(&stateArchiveDays, "days", 0, "archive provisions older than this many days (default archive.after_days)")
+	stateShowCmd.Flags().BoolVar(&stateShowArchived, "archived", false, "list archived states")
+	stateShowCmd.Flags().StringVar(&stateServer, "server", "", "only list the states provisioned by this instance")
+	stateExportCmd.Flags().StringVar(&stateServer, "server", "", "only export the states provisioned by this instance")
+}
+
+func runStateArchive(cmd *cobra.Command, args []string) error {
+	days := stateArchiveDays
+	if days <= 0 {
+		cfg, err := loadConfig(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		days = cfg.Archive.AfterDays
+	}
+	if days <= 0 {
+		return fmt.Errorf("archive age must be at least 1 day")
+	}
+
+	stateMgr, err := openStates()
+	if err != nil {
+		return err
+	}
+
+	n, err := stateMgr.Archive(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("state.archived", n, days, stateMgr.GetArchivePath()))
+	return nil
+}
+
+func runStateReencrypt(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	keyring, err := stateKeyring(cfg)
+	if err != nil {
+		return err
+	}
+
+	stateMgr, err := state.NewManager(stateFilePath(), nil, state.WithKeyring(keyring))
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	n, err := stateMgr.Reencrypt()
+	if err != nil {
+		return err
+	}
+
+	// Files that do not exist yet are not created
+	if pathExists(snapshotFilePath()) {
+		snapshots, err := state.NewSnapshotStore(snapshotFilePath(), nil, state.WithKeyring(keyring))
+		if err != nil {
+			return err
+		}
+		if err := snapshots.Reencrypt(); err != nil {
+			return err
+		}
+	}
+	if queuePath := dataFilePath("telegram_queue.json"); pathExists(queuePath) {
+		queue, err := notifier.NewQueue(queuePath, notifier.QueueConfig{Keyring: keyring}, nil)
+		if err != nil {
+			return err
+		}
+		if err := queue.Reencrypt(); err != nil {
+			return err
+		}
+	}
+
+	if keyring.Encrypting() {
+		fmt.Println(i18n.T("state.reencrypted", n))
+	} else {
+		fmt.Println(i18n.T("state.decrypted", n))
+	}
+	return nil
+}
+
+func runStateShow(cmd *cobra.Command, args []string) error {
+	stateMgr, err := openStates()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		st, err := stateMgr.GetByDomain(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		printStateDetail(st)
+		return nil
+	}
+
+	var states []*state.ProvisionState
+	if stateShowArchived {
+		states, err = stateMgr.ListArchived()
+		if err != nil {
+			return err
+		}
+	} else {
+		states = stateMgr.ListAll()
+		sort.Slice(states, func(i, j int) bool {
+			return states[i].Domain < states[j].Domain
+		})
+	}
+	states = filterServer(states, stateServer)
+
+	if len(states) == 0 {
+		fmt.Println(i18n.T("state.none"))
+		return nil
+	}
+
+	for _, st := range states {
+		fmt.Printf("%-40s %-12s %-11s %s\n",
+			st.Domain,
+			st.Status,
+			st.StepName(),
+			i18n.T("state.updated_at", st.UpdatedAt.Format(time.RFC3339)),
+		)
+	}
+	return nil
+}
+
+func runStateExport(cmd *cobra.Command, args []string) error {
+	stateMgr, err := openStates()
+	if err != nil {
+		return err
+	}
+
+	states := filterServer(stateMgr.ListAll(), stateServer)
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Domain < states[j].Domain
+	})
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if len(args) == 0 {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if err := os.WriteFile(args[0], data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", args[0], err)
+	}
+	fmt.Println(i18n.T("state.exported", len(states), args[0]))
+	return nil
+}
+
+// filterServer returns the states provisioned by server, or all states
+// when server is empty
+func filterServer(states []*state.ProvisionState, server string) []*state.ProvisionState {
+	if server == "" {
+		return states
+	}
+	kept := make([]*state.ProvisionState, 0, len(states))
+	for _, st := range states {
+		if strings.EqualFold(st.Server, server) {
+			kept = append(kept, st)
+		}
+	}
+	return kept
+}
+
+// pathExists reports whether path exists
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// stepsByName returns the names of timed steps, sorted
+func stepsByName(durations map[string]time.Duration) []string {
+	names := make([]string, 0, len(durations))
+	for name := range durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printStateDetail prints every field of one provisioning state
+func printStateDetail(st *state.ProvisionState) {
+	printField("", i18n.T("state.domain"), st.Domain)
+	if st.IsSubdomain() {
+		printField("", i18n.T("state.parent"), st.ParentDomain)
+	}
+	if st.User != "" {
+		printField("", i18n.T("state.user"), st.User)
+	}
+	printField("", "ID", st.ID)
+	if st.Server != "" {
+		printField("", i18n.T("state.server"), st.Server)
+	}
+	printField("", i18n.T("doctor.status"), st.Status)
+	printField("", i18n.T("state.step"), st.StepName())
+	printField("", i18n.T("state.package"), st.Package)
+	printField("", i18n.T("state.dns_zone"), st.ZoneID)
+	printField("", i18n.T("doctor.pull_zone"), st.PullZoneID)
+	printField("", i18n.T("state.cdn_hostname"), st.CDNHostname)
+	if st.StorageZoneID > 0 {
+		printField("", i18n.T("state.storage_zone"), st.StorageZoneID)
+	}
+	if st.Error != "" {
+		printField("", i18n.T("state.error"), st.Error)
+	}
+	printField("", i18n.T("state.retries"), st.Retries)
+	if len(st.StepDurations) > 0 {
+		printField("", i18n.T("state.provision_time"), notifier.FormatDuration(st.ProvisionTime()))
+		for _, step := range stepsByName(st.StepDurations) {
+			printField("  ", step, notifier.FormatDuration(st.StepDurations[step]))
+		}
+	}
+	printField("", i18n.T("state.created"), st.CreatedAt.Format(time.RFC3339))
+	printField("", i18n.T("state.updated"), st.UpdatedAt.Format(time.RFC3339))
+}
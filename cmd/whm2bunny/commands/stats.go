@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/slo"
+)
+
+// statsDays is how many days of statistics are read
+var statsDays int
+
+// StatsCmd groups the traffic statistics commands
+var StatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show CDN traffic statistics",
+}
+
+// statsRegionsCmd shows where a domain's traffic is served from
+var statsRegionsCmd = &cobra.Command{
+	Use:   "regions <domain>",
+	Short: "Show a domain's bandwidth per region and edge location",
+	Long: `Show how much of a domain's bandwidth was served from each region and
+edge location. Pull zones are created with only the Asia+Oceania geo zone
+enabled; a large share outside Asia means that decision should be revisited
+for this customer.`,
+	Example: `  whm2bunny stats regions example.com
+  whm2bunny stats regions example.com --days 30`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStatsRegions,
+}
+
+// statsProvisioningCmd shows how long provisioning takes
+var statsProvisioningCmd = &cobra.Command{
+	Use:   "provisioning",
+	Short: "Show provisioning time percentiles",
+	Long: `Show the p50 and p95 time of successful provisioning runs over the last
+days, compared with the days before, and the p95 of each step. Runs longer
+than slo.target are counted as slow. Times are recorded by the server for
+35 days.`,
+	Example: `  whm2bunny stats provisioning
+  whm2bunny stats provisioning --days 14`,
+	Args: cobra.NoArgs,
+	RunE: runStatsProvisioning,
+}
+
+func init() {
+	RootCmd.AddCommand(StatsCmd)
+	StatsCmd.AddCommand(statsRegionsCmd)
+	StatsCmd.AddCommand(statsProvisioningCmd)
+
+	statsRegionsCmd.Flags().IntVar(&statsDays, "days", 7, "number of days to include")
+	statsProvisioningCmd.Flags().IntVar(&statsDays, "days", 7, "number of days to include")
+}
+
+func runStatsProvisioning(cmd *cobra.Command, args []string) error {
+	if statsDays < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := slo.NewStore(dataFilePath("provision_times.json"), nil)
+	if err != nil {
+		return err
+	}
+
+	window := time.Duration(statsDays) * 24 * time.Hour
+	to := time.Now()
+	from := to.Add(-window)
+	current := store.Stats(from, to, cfg.SLO.Target)
+	previous := store.Stats(from.Add(-window), from, cfg.SLO.Target)
+
+	fmt.Println(i18n.T("stats.provisioning_title", statsDays))
+	if current.Runs == 0 {
+		fmt.Println("  " + i18n.T("stats.no_runs"))
+		return nil
+	}
+
+	printField("  ", i18n.T("stats.runs"), current.Runs)
+	printField("  ", "p50", notifier.FormatDuration(current.P50))
+	p95 := notifier.FormatDuration(current.P95)
+	if previous.Runs > 0 {
+		p95 = i18n.T("stats.p95_change", p95, slo.Change(current.P95, previous.P95), notifier.FormatDuration(previous.P95))
+	}
+	printField("  ", "p95", p95)
+	if cfg.SLO.Target > 0 {
+		printField("  ", i18n.T("stats.slow", notifier.FormatDuration(cfg.SLO.Target)), current.Slow)
+	}
+
+	names := make([]string, 0, len(current.Steps))
+	for name := range current.Steps {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return current.Steps[names[i]] > current.Steps[names[j]] })
+
+	if len(names) > 0 {
+		fmt.Println("\n" + i18n.T("stats.steps"))
+		for _, name := range names {
+			fmt.Printf("  %-24s %8s\n", name, notifier.FormatDuration(current.Steps[name]))
+		}
+	}
+	return nil
+}
+
+func runStatsRegions(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+	if statsDays < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -statsDays)
+	traffic, err := prov.GeoTraffic(ctx, domain, from, to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("stats.regions_title", domain, statsDays))
+	if len(traffic) == 0 {
+		fmt.Println("  " + i18n.T("stats.no_traffic"))
+		return nil
+	}
+
+	var total, outside int64
+	for _, t := range traffic {
+		total += t.Bandwidth
+		if !bunny.IsAsiaRegion(t.Region) {
+			outside += t.Bandwidth
+		}
+	}
+
+	fmt.Println("\n" + i18n.T("stats.regions"))
+	for _, r := range bunny.GroupByRegion(traffic) {
+		fmt.Printf("  %-16s %10s  %5.1f%%\n", r.Region, formatBytes(r.Bandwidth), r.Share)
+	}
+
+	fmt.Println("\n" + i18n.T("stats.locations"))
+	for _, t := range traffic {
+		share := 0.0
+		if total > 0 {
+			share = float64(t.Bandwidth) / float64(total) * 100
+		}
+		fmt.Printf("  %-4s %-28s %10s  %5.1f%%\n", t.Region, t.Location, formatBytes(t.Bandwidth), share)
+	}
+
+	if total > 0 && outside > 0 {
+		fmt.Printf("\n%s\n", i18n.T("stats.outside_asia", float64(outside)/float64(total)*100))
+	}
+	return nil
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
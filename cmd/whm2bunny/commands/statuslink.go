@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/statuspage"
+)
+
+// statusLinkTTL is how long the link status-link prints is valid; zero
+// uses status_links.ttl
+var statusLinkTTL time.Duration
+
+// StatusLinkCmd prints a signed link to a domain's public status page
+var StatusLinkCmd = &cobra.Command{
+	Use:   "status-link <domain>",
+	Short: "Print a signed, expiring link to a domain's public status page",
+	Long: `Print a link to the status page of a domain that can be given to its
+owner. The page shows the domain's provisioning progress and, once it is
+served through the CDN, its traffic over the last 7 days, without the user,
+the Bunny IDs or error messages.
+
+The link is signed with status_links.secret, points at
+status_links.base_url and is valid for status_links.ttl unless --ttl, up to
+status_links.max_ttl, is given. Links cannot be revoked one by one; changing
+the secret invalidates all of them.`,
+	Example: `  whm2bunny status-link example.com
+  whm2bunny status-link example.com --ttl 24h`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStatusLink,
+}
+
+func init() {
+	RootCmd.AddCommand(StatusLinkCmd)
+
+	StatusLinkCmd.Flags().DurationVar(&statusLinkTTL, "ttl", 0, "how long the link is valid (default status_links.ttl)")
+}
+
+func runStatusLink(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+	cfg := env.config.StatusLinks
+	if !cfg.Enabled {
+		return fmt.Errorf("status links are disabled, set status_links.enabled")
+	}
+	ttl, err := cfg.Lifetime(statusLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	st, err := env.states.GetByDomain(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	link, err := statuspage.NewSigner(cfg.Secret).URL(cfg.BaseURL, st.Domain, expires)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(link)
+	fmt.Println(i18n.T("statuslink.expires", expires.Format("2006-01-02 15:04 MST")))
+	return nil
+}
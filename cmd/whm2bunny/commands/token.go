@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// signURLTTL is how long URLs produced by sign-url stay valid
+var signURLTTL time.Duration
+
+// RotateTokenCmd rotates a domain's token authentication key
+var RotateTokenCmd = &cobra.Command{
+	Use:   "rotate-token <domain>",
+	Short: "Rotate the token authentication key of a domain",
+	Long: `Generate a new URL token authentication key for a domain's pull zone,
+store it and send a notification. Signed URLs issued with the previous key
+stop validating immediately.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRotateToken,
+}
+
+// SignURLCmd produces a signed URL for testing token authentication
+var SignURLCmd = &cobra.Command{
+	Use:   "sign-url <domain> <path>",
+	Short: "Produce a signed URL for a domain with token authentication",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runSignURL,
+}
+
+func init() {
+	RootCmd.AddCommand(RotateTokenCmd)
+	RootCmd.AddCommand(SignURLCmd)
+
+	SignURLCmd.Flags().DurationVar(&signURLTTL, "ttl", time.Hour, "how long the signed URL stays valid")
+}
+
+func runRotateToken(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(true)
+	if err != nil {
+		return err
+	}
+
+	if err := prov.RotateTokenKey(context.Background(), args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println(i18n.T("token.rotated", args[0]))
+	return nil
+}
+
+func runSignURL(cmd *cobra.Command, args []string) error {
+	prov, _, err := loadCLIProvisioner(false)
+	if err != nil {
+		return err
+	}
+
+	signed, err := prov.SignURL(args[0], args[1], signURLTTL)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(signed)
+	return nil
+}
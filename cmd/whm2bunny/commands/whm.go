@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/whm"
+)
+
+var (
+	// whmUser lists the domains of one account only
+	whmUser string
+	// whmSubdomains also lists the subdomains of each account
+	whmSubdomains bool
+)
+
+// WHMCmd reads accounts and domains from the WHM API
+var WHMCmd = &cobra.Command{
+	Use:   "whm",
+	Short: "Read accounts and domains from the WHM API",
+}
+
+var whmListDomainsCmd = &cobra.Command{
+	Use:   "list-domains",
+	Short: "List the main domain of every cPanel account",
+	Long: `List the cPanel accounts on the server with their main domain and package,
+read from the WHM API configured under whm. With --subdomains, the
+subdomains of each account are listed below it.`,
+	Example: `  whm2bunny whm list-domains
+  whm2bunny whm list-domains --user alice --subdomains`,
+	Args: cobra.NoArgs,
+	RunE: runWHMListDomains,
+}
+
+func init() {
+	RootCmd.AddCommand(WHMCmd)
+	WHMCmd.AddCommand(whmListDomainsCmd)
+
+	whmListDomainsCmd.Flags().StringVar(&whmUser, "user", "", "list the domains of one account")
+	whmListDomainsCmd.Flags().BoolVar(&whmSubdomains, "subdomains", false, "also list subdomains")
+}
+
+// newWHMClient builds a WHM API client from the configuration
+func newWHMClient(cfg *config.Config) (*whm.Client, error) {
+	if !cfg.WHM.Enabled() {
+		return nil, errors.New("the WHM API is not configured; set whm.url and whm.token")
+	}
+
+	whmProxy, err := cfg.Proxy.Proxy(config.ProxyWHM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WHM proxy: %w", err)
+	}
+
+	opts := []whm.ClientOption{whm.WithHTTPClient(whmProxy.HTTPClient(cfg.WHM.Timeout))}
+	if cfg.WHM.InsecureSkipVerify {
+		opts = append(opts, whm.WithInsecureSkipVerify())
+	}
+	return whm.NewClient(cfg.WHM.URL, cfg.WHM.Username, cfg.WHM.Token, opts...), nil
+}
+
+func runWHMListDomains(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newWHMClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var accounts []whm.Account
+	if whmUser != "" {
+		acct, err := client.GetAccount(ctx, whmUser)
+		if err != nil {
+			return err
+		}
+		accounts = []whm.Account{*acct}
+	} else {
+		accounts, err = client.ListAccounts(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(accounts) == 0 {
+		fmt.Println(i18n.T("whm.none"))
+		return nil
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Domain < accounts[j].Domain
+	})
+
+	for _, acct := range accounts {
+		status := ""
+		if acct.Suspended {
+			status = " [" + i18n.T("whm.suspended") + "]"
+		}
+		fmt.Printf("%-40s %-16s %s%s\n", acct.Domain, acct.User, acct.Plan, status)
+
+		if !whmSubdomains {
+			continue
+		}
+		subs, err := client.ListSubdomains(ctx, acct.User)
+		if err != nil {
+			fmt.Printf("  %s\n", i18n.T("whm.subdomains_failed", err))
+			continue
+		}
+		sort.Slice(subs, func(i, j int) bool {
+			return subs[i].Domain < subs[j].Domain
+		})
+		for _, sub := range subs {
+			fmt.Printf("  %-38s %s\n", sub.Domain, i18n.T("whm.subdomain_of", sub.RootDomain))
+		}
+	}
+	return nil
+}
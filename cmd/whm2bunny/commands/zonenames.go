@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// zoneNamesPrevious lists only the zones under a previous prefix
+var zoneNamesPrevious bool
+
+// ZoneNamesCmd lists pull zones by the prefix they were named with
+var ZoneNamesCmd = &cobra.Command{
+	Use:   "zone-names",
+	Short: "List pull zones named with the current or a previous zone name prefix",
+	Long: `List the pull zones whose name starts with cdn.zone_name_prefix, a prefix in
+cdn.previous_zone_name_prefixes or the original "morden-", with the domain
+whose state references each ("-" when none does).
+
+Run it after changing cdn.zone_name_prefix: Bunny cannot rename a pull zone,
+whose name is also its b-cdn.net hostname, so zones created under an earlier
+prefix keep their name. They are still found when provisioning, deprovisioning
+and checking drift as long as their prefix stays in
+cdn.previous_zone_name_prefixes, and move to the current prefix only when
+their domain is deprovisioned and provisioned again. Zones no state references
+are left over from other servers or earlier installs.`,
+	Example: `  whm2bunny zone-names
+  whm2bunny zone-names --previous`,
+	Args: cobra.NoArgs,
+	RunE: runZoneNames,
+}
+
+func init() {
+	RootCmd.AddCommand(ZoneNamesCmd)
+
+	ZoneNamesCmd.Flags().BoolVar(&zoneNamesPrevious, "previous", false, "list only the zones under a previous prefix")
+}
+
+func runZoneNames(cmd *cobra.Command, args []string) error {
+	env, err := loadCLIEnv(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	names, err := env.provisioner.ZoneNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous := 0
+	for _, zn := range names {
+		if !zn.Current {
+			previous++
+		}
+	}
+	count := len(names)
+	if zoneNamesPrevious {
+		count = previous
+	}
+
+	fmt.Println(i18n.T("zone_names.title", env.config.ZoneNamer().Prefix(), count))
+	if count == 0 {
+		fmt.Println("  " + i18n.T("zone_names.none"))
+	}
+	for _, zn := range names {
+		if zoneNamesPrevious && zn.Current {
+			continue
+		}
+		domain := zn.Domain
+		if domain == "" {
+			domain = "-"
+		}
+		prefix := i18n.T("zone_names.current")
+		if !zn.Current {
+			prefix = i18n.T("zone_names.previous", zn.Prefix)
+		}
+		fmt.Printf("  %-48s %10d  %-40s %s\n", zn.Name, zn.PullZoneID, domain, prefix)
+	}
+	if previous > 0 {
+		fmt.Println()
+		fmt.Println(i18n.T("zone_names.hint", previous))
+	}
+	return nil
+}
@@ -2,34 +2,318 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"github.com/spf13/viper"
+
+	"github.com/mordenhost/whm2bunny/internal/apitoken"
+	"github.com/mordenhost/whm2bunny/internal/flags"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/proxy"
+	"github.com/mordenhost/whm2bunny/internal/selfupdate"
+	"github.com/mordenhost/whm2bunny/internal/zonename"
 )
 
 // Config holds application configuration
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Bunny    BunnyConfig    `mapstructure:"bunny"`
-	DNS      DNSConfig      `mapstructure:"dns"`
-	CDN      CDNConfig      `mapstructure:"cdn"`
-	Origin   OriginConfig   `mapstructure:"origin"`
-	Webhook  WebhookConfig  `mapstructure:"webhook"`
-	Telegram TelegramConfig `mapstructure:"telegram"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Server       ServerConfig       `mapstructure:"server"`
+	Bunny        BunnyConfig        `mapstructure:"bunny"`
+	WHM          WHMConfig          `mapstructure:"whm"`
+	Proxy        ProxyConfig        `mapstructure:"proxy"`
+	DNS          DNSConfig          `mapstructure:"dns"`
+	CDN          CDNConfig          `mapstructure:"cdn"`
+	Origin       OriginConfig       `mapstructure:"origin"`
+	Webhook      WebhookConfig      `mapstructure:"webhook"`
+	Provisioning ProvisioningConfig `mapstructure:"provisioning"`
+	Telegram     TelegramConfig     `mapstructure:"telegram"`
+	Maintenance  MaintenanceConfig  `mapstructure:"maintenance"`
+	Quota        QuotaConfig        `mapstructure:"quota"`
+	Balance      BalanceConfig      `mapstructure:"balance"`
+	Profiles     ProfilesConfig     `mapstructure:"profiles"`
+	API          APIConfig          `mapstructure:"api"`
+	Drift        DriftConfig        `mapstructure:"drift"`
+	ZoneMonitor  ZoneMonitorConfig  `mapstructure:"zone_monitor"`
+	Discovery    DiscoveryConfig    `mapstructure:"discovery"`
+	Archive      ArchiveConfig      `mapstructure:"archive"`
+	State        StateConfig        `mapstructure:"state"`
+	Snapshots    SnapshotsConfig    `mapstructure:"snapshots"`
+	StatusFiles  StatusFilesConfig  `mapstructure:"status_files"`
+	StatusLinks  StatusLinksConfig  `mapstructure:"status_links"`
+	Certificates CertificatesConfig `mapstructure:"certificates"`
+	Hooks        []HookConfig       `mapstructure:"hooks"`
+	SLO          SLOConfig          `mapstructure:"slo"`
+	Incidents    IncidentsConfig    `mapstructure:"incidents"`
+	Encryption   EncryptionConfig   `mapstructure:"encryption"`
+	Backup       BackupConfig       `mapstructure:"backup"`
+	Update       UpdateConfig       `mapstructure:"update"`
+	Logging      LoggingConfig      `mapstructure:"logging"`
+	// Locale selects the language of notifications, summaries and CLI
+	// output; empty means English
+	Locale string `mapstructure:"locale"`
+	// Instance names this server in state records, notifications, summaries
+	// and API responses, so a fleet reporting into one chat can be told
+	// apart; empty uses the hostname
+	Instance string `mapstructure:"instance"`
+	// Notifications holds summary channels besides Telegram
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	// Flags enables or disables feature flags by name; WHM2BUNNY_FLAG_<NAME>
+	// environment variables override them
+	Flags map[string]bool `mapstructure:"flags"`
+
+	// sources are the config files merged by LoadEnv, in order
+	sources []string
+	// defaulted are the keys LoadEnv left at their default value
+	defaulted []string
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
 	Port int    `mapstructure:"port"`
 	Host string `mapstructure:"host"`
+	// Listen is a unix:///path socket to listen on instead of host:port
+	Listen string `mapstructure:"listen"`
+	// SocketMode is the octal permission of the socket, e.g. "0660"
+	SocketMode string `mapstructure:"socket_mode"`
+	// ReadHeaderTimeout bounds how long a client may take to send the
+	// request headers, so slow clients cannot hold connections open
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+}
+
+// UnixSocket returns the socket path of a unix:// listen address
+func (s ServerConfig) UnixSocket() (string, bool) {
+	path, ok := strings.CutPrefix(s.Listen, "unix://")
+	return path, ok && path != ""
+}
+
+// SocketPerm returns the permission of the Unix socket
+func (s ServerConfig) SocketPerm() (os.FileMode, error) {
+	mode := s.SocketMode
+	if mode == "" {
+		mode = DefaultSocketMode
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("server.socket_mode must be an octal permission such as 0660, got %q", s.SocketMode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// validate checks the listen address, socket permission and timeouts
+func (s ServerConfig) validate() error {
+	if s.ReadHeaderTimeout <= 0 {
+		return fmt.Errorf("server.read_header_timeout must be positive")
+	}
+	if s.Listen == "" {
+		return nil
+	}
+	path, ok := s.UnixSocket()
+	if !ok {
+		return fmt.Errorf("server.listen must be a unix:///path socket, got %q", s.Listen)
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("server.listen socket path must be absolute, got %q", path)
+	}
+	_, err := s.SocketPerm()
+	return err
 }
 
 // BunnyConfig holds Bunny.net API configuration
 type BunnyConfig struct {
-	APIKey  string `mapstructure:"api_key"`
-	BaseURL string `mapstructure:"base_url"`
+	APIKey  string            `mapstructure:"api_key"`
+	BaseURL string            `mapstructure:"base_url"`
+	Chaos   ChaosConfig       `mapstructure:"chaos"`
+	Health  BunnyHealthConfig `mapstructure:"health"`
+}
+
+// BunnyHealthConfig holds the availability objective of the Bunny API
+// endpoint families (dns, pullzone, stats, storage). A family whose calls
+// fail more often than its error budget allows over the window opens a
+// bunny_api incident and is reported by /ready
+type BunnyHealthConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"` // How often the families are checked
+	Window   time.Duration `mapstructure:"window"`   // How far back the availability is computed
+	// Availability is the percent of calls that must succeed; network
+	// errors, timeouts, 429 and 5xx answers count against it
+	Availability float64 `mapstructure:"availability"`
+	// MinRequests is how many calls a family needs in the window before it
+	// can be degraded
+	MinRequests int `mapstructure:"min_requests"`
+}
+
+// validate checks the objective when health tracking is enabled
+func (c BunnyHealthConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("bunny.health.interval must not be negative")
+	}
+	if c.Window < time.Minute {
+		return fmt.Errorf("bunny.health.window must be at least 1m, got %s", c.Window)
+	}
+	if c.Availability <= 0 || c.Availability >= 100 {
+		return fmt.Errorf("bunny.health.availability must be greater than 0 and less than 100, got %v", c.Availability)
+	}
+	if c.MinRequests < 1 {
+		return fmt.Errorf("bunny.health.min_requests must be at least 1")
+	}
+	return nil
+}
+
+// ChaosConfig injects failures into Bunny API calls to test retries and
+// recovery in staging; never enable it in production
+type ChaosConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Rate    float64       `mapstructure:"rate"`   // Share of calls that fail, from 0 to 1
+	Faults  []string      `mapstructure:"faults"` // Any of "429", "500" and "timeout"
+	Delay   time.Duration `mapstructure:"delay"`  // How long an injected timeout takes
+}
+
+// chaosFaults are the faults chaos mode can inject
+var chaosFaults = []string{"429", "500", "timeout"}
+
+// validate checks the rate and faults when chaos mode is enabled
+func (c ChaosConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Rate <= 0 || c.Rate > 1 {
+		return fmt.Errorf("bunny.chaos.rate must be greater than 0 and at most 1, got %v", c.Rate)
+	}
+	for _, fault := range c.Faults {
+		if !slices.Contains(chaosFaults, fault) {
+			return fmt.Errorf("bunny.chaos.faults must be any of %s, got %q", strings.Join(chaosFaults, ", "), fault)
+		}
+	}
+	if c.Delay < 0 {
+		return fmt.Errorf("bunny.chaos.delay must not be negative")
+	}
+	return nil
+}
+
+// WHMConfig holds WHM JSON API configuration, for features that read
+// accounts and domains from the cPanel server; they are off while URL is
+// empty
+type WHMConfig struct {
+	URL                string        `mapstructure:"url"`                  // WHM address, such as https://server.example.com:2087
+	Username           string        `mapstructure:"username"`             // User the API token belongs to
+	Token              string        `mapstructure:"token"`                // WHM API token
+	InsecureSkipVerify bool          `mapstructure:"insecure_skip_verify"` // Accept WHM's self-signed certificate
+	Timeout            time.Duration `mapstructure:"timeout"`
+}
+
+// Enabled reports whether the WHM API is configured
+func (c WHMConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// validate checks the address and token when the WHM API is configured
+func (c WHMConfig) validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("whm.url must be an http or https URL, got %q", c.URL)
+	}
+	if c.Token == "" {
+		return fmt.Errorf("whm.token is required with whm.url (set WHM_API_TOKEN env var)")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("whm.timeout must not be negative")
+	}
+	return nil
+}
+
+// ProxyConfig routes outbound connections through an egress proxy
+// Each destination uses its own setting when set and URL otherwise; a
+// setting is an http://, https:// or socks5:// proxy URL with optional
+// user:pass@ credentials, or "direct". When both are empty HTTP requests
+// follow the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+type ProxyConfig struct {
+	URL     string   `mapstructure:"url"`
+	NoProxy []string `mapstructure:"no_proxy"` // Hosts and domains connected to directly
+
+	Bunny    string `mapstructure:"bunny"`    // Bunny API
+	Telegram string `mapstructure:"telegram"` // Telegram Bot API
+	WHM      string `mapstructure:"whm"`      // WHM API
+	DNS      string `mapstructure:"dns"`      // Propagation checks, tunnelled as DNS over TCP
+}
+
+// Proxy destinations
+const (
+	ProxyBunny    = "bunny"
+	ProxyTelegram = "telegram"
+	ProxyWHM      = "whm"
+	ProxyDNS      = "dns"
+)
+
+// For returns the proxy setting of a destination
+func (c ProxyConfig) For(destination string) string {
+	var setting string
+	switch destination {
+	case ProxyBunny:
+		setting = c.Bunny
+	case ProxyTelegram:
+		setting = c.Telegram
+	case ProxyWHM:
+		setting = c.WHM
+	case ProxyDNS:
+		setting = c.DNS
+	}
+	if setting == "" {
+		return c.URL
+	}
+	return setting
+}
+
+// Proxy returns the proxy connections to a destination go through
+func (c ProxyConfig) Proxy(destination string) (*proxy.Proxy, error) {
+	return proxy.New(c.For(destination), c.NoProxy)
+}
+
+// Enabled reports whether any destination uses a configured proxy
+func (c ProxyConfig) Enabled() bool {
+	for _, destination := range []string{ProxyBunny, ProxyTelegram, ProxyWHM, ProxyDNS} {
+		if setting := c.For(destination); setting != "" && setting != proxy.Direct {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the proxy URLs
+func (c ProxyConfig) validate() error {
+	if _, err := proxy.New(c.URL, c.NoProxy); err != nil {
+		return fmt.Errorf("proxy.url: %w", err)
+	}
+	for _, destination := range []string{ProxyBunny, ProxyTelegram, ProxyWHM, ProxyDNS} {
+		if _, err := c.Proxy(destination); err != nil {
+			return fmt.Errorf("proxy.%s: %w", destination, err)
+		}
+	}
+	return nil
+}
+
+// redacted returns the settings with the passwords of proxy URLs masked
+func (c ProxyConfig) redacted() ProxyConfig {
+	for _, setting := range []*string{&c.URL, &c.Bunny, &c.Telegram, &c.WHM, &c.DNS} {
+		if u, err := proxy.Parse(*setting); err == nil {
+			*setting = u.Redacted()
+		}
+	}
+	return c
 }
 
 // DNSConfig holds DNS configuration
@@ -37,22 +321,300 @@ type DNSConfig struct {
 	Nameserver1 string `mapstructure:"nameserver1"`
 	Nameserver2 string `mapstructure:"nameserver2"`
 	SOAEmail    string `mapstructure:"soa_email"`
+	// SOAEmails maps a WHM reseller to the SOA email of the zones of its
+	// accounts, overriding SOAEmail
+	SOAEmails map[string]string `mapstructure:"soa_emails"`
+	// SOAEmailFromReseller uses the contact email of the reseller's WHM
+	// account for resellers not in SOAEmails; requires the WHM API
+	SOAEmailFromReseller bool `mapstructure:"soa_email_from_reseller"`
+	// RecordStrategy selects the hostnames routed through the pull zone;
+	// empty means cdn_subdomain_only
+	RecordStrategy string `mapstructure:"record_strategy"`
+
+	ServiceRecords ServiceRecordsConfig `mapstructure:"service_records"`
+	Propagation    PropagationConfig    `mapstructure:"propagation"`
+}
+
+// PropagationConfig controls the check, after provisioning, that public
+// resolvers return the records Bunny's nameservers serve
+type PropagationConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Timeout  time.Duration `mapstructure:"timeout"`  // How long provisioning waits for convergence
+	Interval time.Duration `mapstructure:"interval"` // How often resolvers are queried again
+}
+
+// DNS record strategies
+const (
+	RecordStrategyCDNSubdomainOnly = "cdn_subdomain_only" // Only cdn.<domain> uses the CDN
+	RecordStrategyWWWViaCDN        = "www_via_cdn"        // www.<domain> and cdn.<domain> use the CDN
+	RecordStrategyApexViaCDN       = "apex_via_cdn"       // <domain>, www.<domain> and cdn.<domain> use the CDN
+)
+
+// CDNRecordNames returns the names of the records the strategy points at
+// the pull zone, "@" being the zone apex
+func (c DNSConfig) CDNRecordNames() []string {
+	switch c.RecordStrategy {
+	case RecordStrategyWWWViaCDN:
+		return []string{"cdn", "www"}
+	case RecordStrategyApexViaCDN:
+		return []string{"cdn", "www", "@"}
+	default:
+		return []string{"cdn"}
+	}
+}
+
+// Canonical hosts a domain's www and apex hostnames can redirect to
+const (
+	CanonicalHostNone = "none" // No redirect
+	CanonicalHostApex = "apex" // www.<domain> redirects to <domain>
+	CanonicalHostWWW  = "www"  // <domain> redirects to www.<domain>
+)
+
+// ValidateCanonicalHost checks a canonical host can be redirected to with
+// the record strategy: the hostname redirected away from must be routed
+// through the pull zone for the edge rule to see its requests
+func (c DNSConfig) ValidateCanonicalHost(host string) error {
+	switch host {
+	case "", CanonicalHostNone:
+		return nil
+	case CanonicalHostApex:
+		if c.RecordStrategy != RecordStrategyWWWViaCDN && c.RecordStrategy != RecordStrategyApexViaCDN {
+			return fmt.Errorf("canonical host apex needs dns.record_strategy %s or %s", RecordStrategyWWWViaCDN, RecordStrategyApexViaCDN)
+		}
+		return nil
+	case CanonicalHostWWW:
+		if c.RecordStrategy != RecordStrategyApexViaCDN {
+			return fmt.Errorf("canonical host www needs dns.record_strategy %s", RecordStrategyApexViaCDN)
+		}
+		return nil
+	default:
+		return fmt.Errorf("canonical host must be %s, %s or %s, got %q", CanonicalHostApex, CanonicalHostWWW, CanonicalHostNone, host)
+	}
+}
+
+// ResellerSOAEmail returns the SOA email mapped to reseller, if any.
+// Viper lowercases map keys, so resellers match case-insensitively
+func (c DNSConfig) ResellerSOAEmail(reseller string) (string, bool) {
+	if reseller == "" {
+		return "", false
+	}
+	email, ok := c.SOAEmails[strings.ToLower(reseller)]
+	return email, ok
+}
+
+// validate checks the record strategy is known and the reseller SOA
+// emails are email addresses
+func (c DNSConfig) validate() error {
+	for reseller, email := range c.SOAEmails {
+		if !strings.Contains(email, "@") {
+			return fmt.Errorf("dns.soa_emails.%s must be an email address, got %q", reseller, email)
+		}
+	}
+	switch c.RecordStrategy {
+	case "", RecordStrategyCDNSubdomainOnly, RecordStrategyWWWViaCDN, RecordStrategyApexViaCDN:
+	default:
+		return fmt.Errorf("dns.record_strategy must be %s, %s or %s, got %q",
+			RecordStrategyCDNSubdomainOnly, RecordStrategyWWWViaCDN, RecordStrategyApexViaCDN, c.RecordStrategy)
+	}
+	if c.Propagation.Timeout < 0 {
+		return fmt.Errorf("dns.propagation.timeout must not be negative")
+	}
+	if c.Propagation.Enabled && c.Propagation.Interval <= 0 {
+		return fmt.Errorf("dns.propagation.interval must be positive")
+	}
+	return c.ServiceRecords.validate()
+}
+
+// ServiceRecordsConfig controls the cPanel service records (mail, webmail,
+// cpanel, autodiscover, ...) added to new DNS zones. They point straight at
+// the origin, never through the CDN
+type ServiceRecordsConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Target  string   `mapstructure:"target"` // IP (A/AAAA) or hostname (CNAME); empty uses origin.ip
+	Names   []string `mapstructure:"names"`
+}
+
+// validate checks the record names do not collide with the records the
+// provisioner manages itself and the target is an IP or hostname
+func (s ServiceRecordsConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if len(s.Names) == 0 {
+		return fmt.Errorf("dns.service_records.names must not be empty when enabled")
+	}
+	for _, name := range s.Names {
+		switch strings.ToLower(name) {
+		case "", "@", "www", "cdn", "_dmarc":
+			return fmt.Errorf("dns.service_records.names must not contain %q", name)
+		}
+		if strings.ContainsAny(name, " /:\t") || strings.HasSuffix(name, ".") {
+			return fmt.Errorf("dns.service_records.names entry %q must be a relative hostname", name)
+		}
+	}
+	if net.ParseIP(s.Target) == nil && strings.ContainsAny(s.Target, " /:\t") {
+		return fmt.Errorf("dns.service_records.target must be an IP or hostname, got %q", s.Target)
+	}
+	return nil
 }
 
 // CDNConfig holds CDN configuration
 type CDNConfig struct {
-	OriginShieldRegion string   `mapstructure:"origin_shield_region"`
-	Regions            []string `mapstructure:"regions"`
+	OriginShieldRegion string                 `mapstructure:"origin_shield_region"`
+	Regions            []string               `mapstructure:"regions"`
+	OriginResilience   OriginResilienceConfig `mapstructure:"origin_resilience"`
+	HTTPS              HTTPSConfig            `mapstructure:"https"`
+	VHostCheck         VHostCheckConfig       `mapstructure:"vhost_check"`
+	// ZoneNamePrefix starts the name of every new pull and storage zone;
+	// "{instance}" is replaced by the instance name
+	ZoneNamePrefix string `mapstructure:"zone_name_prefix"`
+	// PreviousZoneNamePrefixes are prefixes zones were named with before,
+	// still recognized when looking zones up by name
+	PreviousZoneNamePrefixes []string `mapstructure:"previous_zone_name_prefixes"`
+}
+
+// HTTPSConfig controls the free certificates loaded for the hostnames a
+// domain routes through the CDN
+type HTTPSConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`   // Load a free certificate for each CDN hostname
+	ForceSSL bool          `mapstructure:"force_ssl"` // Redirect HTTP to HTTPS once the certificate is issued
+	Timeout  time.Duration `mapstructure:"timeout"`   // How long provisioning waits for issuance
+}
+
+// VHostCheckConfig controls the check, after provisioning, that the origin
+// serves the site for the domain rather than its default virtual host
+type VHostCheckConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Timeout time.Duration `mapstructure:"timeout"` // Bounds each request of the check
+}
+
+// OriginResilienceConfig controls how pull zones behave when the origin is
+// slow or unreachable
+type OriginResilienceConfig struct {
+	StaleWhileOffline  bool `mapstructure:"stale_while_offline"`  // Serve cached content while the origin is down
+	StaleWhileUpdating bool `mapstructure:"stale_while_updating"` // Serve cached content while it is refreshed
+	ConnectTimeout     int  `mapstructure:"connect_timeout"`      // Seconds
+	ResponseTimeout    int  `mapstructure:"response_timeout"`     // Seconds
+	Retries            int  `mapstructure:"retries"`
+}
+
+// OriginResilienceOverride overrides individual origin resilience settings
+// for a profile; nil fields use the cdn.origin_resilience defaults
+type OriginResilienceOverride struct {
+	StaleWhileOffline  *bool `mapstructure:"stale_while_offline"`
+	StaleWhileUpdating *bool `mapstructure:"stale_while_updating"`
+	ConnectTimeout     *int  `mapstructure:"connect_timeout"`
+	ResponseTimeout    *int  `mapstructure:"response_timeout"`
+	Retries            *int  `mapstructure:"retries"`
+}
+
+// Merge returns the settings with the override's non-nil fields applied
+func (o OriginResilienceConfig) Merge(override OriginResilienceOverride) OriginResilienceConfig {
+	if override.StaleWhileOffline != nil {
+		o.StaleWhileOffline = *override.StaleWhileOffline
+	}
+	if override.StaleWhileUpdating != nil {
+		o.StaleWhileUpdating = *override.StaleWhileUpdating
+	}
+	if override.ConnectTimeout != nil {
+		o.ConnectTimeout = *override.ConnectTimeout
+	}
+	if override.ResponseTimeout != nil {
+		o.ResponseTimeout = *override.ResponseTimeout
+	}
+	if override.Retries != nil {
+		o.Retries = *override.Retries
+	}
+	return o
+}
+
+// validate checks the timeouts and retries are within Bunny's limits
+func (o OriginResilienceConfig) validate(field string) error {
+	if o.ConnectTimeout < 1 || o.ConnectTimeout > 60 {
+		return fmt.Errorf("%s.connect_timeout must be between 1 and 60 seconds", field)
+	}
+	if o.ResponseTimeout < 1 || o.ResponseTimeout > 300 {
+		return fmt.Errorf("%s.response_timeout must be between 1 and 300 seconds", field)
+	}
+	if o.Retries < 0 || o.Retries > 5 {
+		return fmt.Errorf("%s.retries must be between 0 and 5", field)
+	}
+	return nil
 }
 
 // OriginConfig holds origin server configuration
 type OriginConfig struct {
-	IP string `mapstructure:"ip"`
+	IP         string `mapstructure:"ip"`
+	Protocol   string `mapstructure:"protocol"`    // http (default) or https
+	Port       int    `mapstructure:"port"`        // 0 uses the protocol's default port
+	VerifySSL  bool   `mapstructure:"verify_ssl"`  // Verify the origin certificate (https only)
+	HostHeader string `mapstructure:"host_header"` // Empty sends the domain itself
+}
+
+// OriginOverride overrides origin connection settings for a profile; nil
+// fields use the origin defaults
+type OriginOverride struct {
+	Protocol   *string `mapstructure:"protocol"`
+	Port       *int    `mapstructure:"port"`
+	VerifySSL  *bool   `mapstructure:"verify_ssl"`
+	HostHeader *string `mapstructure:"host_header"`
+}
+
+// Merge returns the settings with the override's non-nil fields applied
+func (o OriginConfig) Merge(override OriginOverride) OriginConfig {
+	if override.Protocol != nil {
+		o.Protocol = *override.Protocol
+	}
+	if override.Port != nil {
+		o.Port = *override.Port
+	}
+	if override.VerifySSL != nil {
+		o.VerifySSL = *override.VerifySSL
+	}
+	if override.HostHeader != nil {
+		o.HostHeader = *override.HostHeader
+	}
+	return o
+}
+
+// validateConnection checks the protocol, port and host header
+func (o OriginConfig) validateConnection(field string) error {
+	switch strings.ToLower(o.Protocol) {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("%s.protocol must be http or https, got %q", field, o.Protocol)
+	}
+	if o.Port < 0 || o.Port > 65535 {
+		return fmt.Errorf("%s.port must be between 0 and 65535", field)
+	}
+	if strings.ContainsAny(o.HostHeader, " /:\t") {
+		return fmt.Errorf("%s.host_header must be a hostname, got %q", field, o.HostHeader)
+	}
+	return nil
 }
 
 // WebhookConfig holds webhook configuration
 type WebhookConfig struct {
 	Secret string `mapstructure:"secret"`
+	// Debounce drops an event repeating the event type and domain of one
+	// accepted within this window; 0 accepts every event
+	Debounce time.Duration `mapstructure:"debounce"`
+	// MaxBodyBytes is the largest webhook body accepted; larger bodies are
+	// rejected with 413 before they are read into memory
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"`
+	// BacklogThreshold is the number of waiting events past which an
+	// accepted event is answered with its queue position and estimated
+	// start; 0 never includes them
+	BacklogThreshold int `mapstructure:"backlog_threshold"`
+}
+
+// ProvisioningConfig switches automation on or off
+type ProvisioningConfig struct {
+	// Enabled acts on webhook events. When false whm2bunny runs
+	// observe-only: events, and cPanel domains found by discovery, are
+	// recorded and notified as what would be provisioned, and the server
+	// never calls the Bunny API
+	Enabled bool `mapstructure:"enabled"`
 }
 
 // TelegramConfig holds Telegram notification configuration
@@ -62,6 +624,93 @@ type TelegramConfig struct {
 	Enabled  bool                  `mapstructure:"enabled"`
 	Events   []string              `mapstructure:"events"`
 	Summary  TelegramSummaryConfig `mapstructure:"summary"`
+	// TemplatesDir holds message templates (e.g. success.tmpl) that replace
+	// the built-in ones; empty uses only the built-in templates
+	TemplatesDir string `mapstructure:"templates_dir"`
+	// Commands answers /status, /retry, /purge and /report in the chat
+	Commands bool `mapstructure:"commands"`
+	// Delivery controls retries of the messages Telegram did not accept
+	Delivery TelegramDeliveryConfig `mapstructure:"delivery"`
+	// Routes sends notification categories to their own chats or forum
+	// topics, keyed by category; other categories go to ChatID
+	Routes map[string]TelegramRoute `mapstructure:"routes"`
+}
+
+// TelegramCategories are the notification categories telegram.routes can
+// send to their own chats
+var TelegramCategories = []string{"provisioning", "alerts", "summaries", "billing"}
+
+// TelegramRoute is the chat and forum topic of a notification category
+type TelegramRoute struct {
+	// ChatID is the chat; empty uses telegram.chat_id
+	ChatID string `mapstructure:"chat_id"`
+	// ThreadID is the topic of a forum supergroup; 0 sends to the chat
+	ThreadID int `mapstructure:"thread_id"`
+}
+
+// validateRoutes checks the categories and chat IDs of telegram.routes
+func (c TelegramConfig) validateRoutes() error {
+	for category, route := range c.Routes {
+		if !slices.Contains(TelegramCategories, category) {
+			return fmt.Errorf("telegram.routes.%s: category must be one of %s", category, strings.Join(TelegramCategories, ", "))
+		}
+		if route.ChatID != "" {
+			if _, err := strconv.ParseInt(route.ChatID, 10, 64); err != nil {
+				return fmt.Errorf("telegram.routes.%s.chat_id must be a numeric chat ID, got %q", category, route.ChatID)
+			}
+		}
+		if route.ThreadID < 0 {
+			return fmt.Errorf("telegram.routes.%s.thread_id must not be negative", category)
+		}
+		if route.ChatID == "" && route.ThreadID == 0 {
+			return fmt.Errorf("telegram.routes.%s requires a chat_id or thread_id", category)
+		}
+	}
+	return nil
+}
+
+// TelegramDeliveryConfig controls the queue of notifications Telegram did
+// not accept and the channel used during a longer Telegram outage
+type TelegramDeliveryConfig struct {
+	Backoff    time.Duration `mapstructure:"backoff"`     // First retry delay, doubled after each failure
+	MaxBackoff time.Duration `mapstructure:"max_backoff"` // Longest retry delay
+	MaxAge     time.Duration `mapstructure:"max_age"`     // Undelivered messages older than this are dropped
+	// Fallback is email (notifications.email) or webhook (FallbackURL);
+	// empty keeps retrying Telegram only
+	Fallback      string        `mapstructure:"fallback"`
+	FallbackAfter time.Duration `mapstructure:"fallback_after"` // Outage length before the fallback is used
+	FallbackURL   string        `mapstructure:"fallback_url"`
+}
+
+// Telegram delivery fallbacks
+const (
+	FallbackEmail   = "email"
+	FallbackWebhook = "webhook"
+)
+
+// validate checks the retry delays and the fallback channel
+func (d TelegramDeliveryConfig) validate(email EmailConfig) error {
+	if d.Backoff <= 0 || d.MaxBackoff < d.Backoff {
+		return fmt.Errorf("telegram.delivery.backoff must be positive and at most telegram.delivery.max_backoff")
+	}
+	if d.MaxAge < 0 || d.FallbackAfter < 0 {
+		return fmt.Errorf("telegram.delivery.max_age and fallback_after must not be negative")
+	}
+	switch d.Fallback {
+	case "":
+	case FallbackEmail:
+		if !email.Enabled {
+			return fmt.Errorf("telegram.delivery.fallback email requires notifications.email.enabled")
+		}
+	case FallbackWebhook:
+		u, err := url.Parse(d.FallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("telegram.delivery.fallback_url must be an http or https URL, got %q", d.FallbackURL)
+		}
+	default:
+		return fmt.Errorf("telegram.delivery.fallback must be %s or %s, got %q", FallbackEmail, FallbackWebhook, d.Fallback)
+	}
+	return nil
 }
 
 // TelegramSummaryConfig holds Telegram daily summary configuration
@@ -72,12 +721,674 @@ type TelegramSummaryConfig struct {
 	Timezone                string `mapstructure:"timezone"`
 	IncludeTopBandwidth     int    `mapstructure:"include_top_bandwidth"`
 	BandwidthAlertThreshold int    `mapstructure:"bandwidth_alert_threshold"`
+	// Concurrency is how many zones are fetched at once
+	Concurrency int `mapstructure:"concurrency"`
+	// RequestsPerSecond caps the Bunny API calls made while collecting stats
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	// Timeout bounds a whole summary job
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Attachment attaches a report of every zone to the summaries: csv,
+	// json, or empty for none
+	Attachment string `mapstructure:"attachment"`
+	// IdleDays is the window of the weekly summary's idle domains, those
+	// serving at most IdleMaxRequests requests over it; 0 leaves them out
+	IdleDays        int   `mapstructure:"idle_days"`
+	IdleMaxRequests int64 `mapstructure:"idle_max_requests"`
+}
+
+// MaintenanceConfig holds scheduled maintenance window configuration
+type MaintenanceConfig struct {
+	Timezone string                    `mapstructure:"timezone"`
+	Windows  []MaintenanceWindowConfig `mapstructure:"windows"`
+}
+
+// MaintenanceWindowConfig describes a recurring maintenance window
+// Start and End are "HH:MM" in the maintenance timezone; a window whose End
+// is before its Start wraps past midnight
+type MaintenanceWindowConfig struct {
+	Days   []string `mapstructure:"days"`
+	Start  string   `mapstructure:"start"`
+	End    string   `mapstructure:"end"`
+	Reason string   `mapstructure:"reason"`
+}
+
+// QuotaConfig holds provisioning quota configuration
+// A limit of 0 means unlimited
+type QuotaConfig struct {
+	Enabled  bool                   `mapstructure:"enabled"`
+	Timezone string                 `mapstructure:"timezone"`
+	PerUser  QuotaLimits            `mapstructure:"per_user"`
+	Global   QuotaLimits            `mapstructure:"global"`
+	Owners   map[string]QuotaLimits `mapstructure:"owners"`
+}
+
+// QuotaLimits holds daily and monthly provisioning limits
+type QuotaLimits struct {
+	Daily   int `mapstructure:"daily"`
+	Monthly int `mapstructure:"monthly"`
+}
+
+// BalanceConfig holds Bunny account balance guardrail configuration
+type BalanceConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Threshold      float64       `mapstructure:"threshold"`        // Minimum balance in account currency
+	PausePullZones bool          `mapstructure:"pause_pull_zones"` // Stop creating pull zones while low (DNS continues)
+	CheckInterval  time.Duration `mapstructure:"check_interval"`
+}
+
+// APIConfig holds management API configuration
+type APIConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Token   string `mapstructure:"token"` // Bearer token with the admin role; defaults to webhook.secret
+	// Tokens are named tokens with a role, stored as hashes; tokens are
+	// also created with "whm2bunny api-token create"
+	Tokens []APITokenConfig `mapstructure:"tokens"`
+}
+
+// APITokenConfig is a named management API token
+type APITokenConfig struct {
+	Name string `mapstructure:"name"`
+	// Role is read-only, operator or admin
+	Role string `mapstructure:"role"`
+	// Hash is the token's hash as "whm2bunny api-token hash" prints it,
+	// sha256:<hex>
+	Hash string `mapstructure:"hash"`
+}
+
+// NamedTokens returns the named tokens of the configuration
+func (c APIConfig) NamedTokens() []apitoken.Token {
+	tokens := make([]apitoken.Token, 0, len(c.Tokens))
+	for _, t := range c.Tokens {
+		// Roles are checked by Validate
+		role, _ := apitoken.ParseRole(t.Role)
+		tokens = append(tokens, apitoken.Token{Name: t.Name, Role: role, Hash: t.Hash})
+	}
+	return tokens
+}
+
+// validate checks the named API tokens
+func (c APIConfig) validate() error {
+	names := make(map[string]bool, len(c.Tokens))
+	for i, t := range c.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("api.tokens[%d].name is required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("api.tokens has two tokens named %s", t.Name)
+		}
+		names[t.Name] = true
+		if _, err := apitoken.ParseRole(t.Role); err != nil {
+			return fmt.Errorf("api.tokens.%s.role: %w", t.Name, err)
+		}
+		if !apitoken.ValidHash(t.Hash) {
+			return fmt.Errorf("api.tokens.%s.hash must be sha256:<64 hex digits>, as printed by whm2bunny api-token hash", t.Name)
+		}
+	}
+	return nil
+}
+
+// DriftConfig holds pull zone drift enforcement configuration
+type DriftConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	Fix      bool          `mapstructure:"fix"` // Correct drift instead of only reporting it
+}
+
+// ZoneMonitorConfig holds the check of managed DNS zones that Bunny may
+// disable, e.g. after an abuse report or a billing problem
+type ZoneMonitorConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// DiscoveryConfig holds subdomain auto-discovery configuration
+// cPanel subdomains of provisioned domains without a state are provisioned
+type DiscoveryConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Interval        time.Duration `mapstructure:"interval"`
+	UserdataDomains string        `mapstructure:"userdata_domains"` // cPanel domain list
+}
+
+// ArchiveConfig holds state archival configuration
+// Successful provisions untouched for AfterDays move to the archive file
+type ArchiveConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	AfterDays int           `mapstructure:"after_days"`
+	Interval  time.Duration `mapstructure:"interval"`
+}
+
+// StateConfig holds how the server writes state changes to disk
+// With Batching, changes are logged as they are made and written to the
+// state records in batches, every FlushInterval or once FlushMutations are
+// waiting; otherwise each change is written to its record as it is made
+type StateConfig struct {
+	Batching       bool          `mapstructure:"batching"`
+	FlushInterval  time.Duration `mapstructure:"flush_interval"`
+	FlushMutations int           `mapstructure:"flush_mutations"`
+}
+
+// SnapshotsConfig holds bandwidth snapshot retention configuration
+// Snapshots of part of a day are rolled up into daily snapshots after
+// RawDays; daily and longer snapshots are removed after DailyDays
+type SnapshotsConfig struct {
+	RawDays   int           `mapstructure:"raw_days"`
+	DailyDays int           `mapstructure:"daily_days"`
+	Interval  time.Duration `mapstructure:"interval"` // How often snapshots are compacted
+}
+
+// StatusFilesConfig holds the per-domain provisioning status files read by
+// WHM hook scripts, written to <Dir>/<user>/<domain>.json
+type StatusFilesConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+}
+
+// StatusLinksConfig holds the signed, expiring links to a public status
+// page of a single domain, served at /status/<domain>, that support can
+// give a customer instead of API credentials
+type StatusLinksConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Secret signs the links; prefer the STATUS_LINK_SECRET env var.
+	// Changing it invalidates every link given out
+	Secret string `mapstructure:"secret"`
+	// BaseURL is the public address of the server the links point at,
+	// such as https://cdn-status.example.com
+	BaseURL string `mapstructure:"base_url"`
+	// TTL is how long a link is valid unless another period is asked for
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxTTL is the longest a link may be valid
+	MaxTTL time.Duration `mapstructure:"max_ttl"`
+}
+
+// Lifetime returns how long a link asked to be valid for requested is
+// valid; zero asks for TTL
+func (c StatusLinksConfig) Lifetime(requested time.Duration) (time.Duration, error) {
+	switch {
+	case requested == 0:
+		return c.TTL, nil
+	case requested < 0:
+		return 0, fmt.Errorf("link lifetime must be positive")
+	case requested > c.MaxTTL:
+		return 0, fmt.Errorf("link lifetime %s is longer than status_links.max_ttl %s", requested, c.MaxTTL)
+	}
+	return requested, nil
+}
+
+// validate checks the secret, address and lifetimes of the links when
+// they are enabled
+func (c StatusLinksConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Secret) < MinStatusLinkSecretLength {
+		return fmt.Errorf("status_links.secret must be at least %d characters (or set STATUS_LINK_SECRET env var)", MinStatusLinkSecretLength)
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("status_links.base_url must be an http or https URL, got %q", c.BaseURL)
+	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("status_links.ttl must be positive")
+	}
+	if c.MaxTTL < c.TTL {
+		return fmt.Errorf("status_links.max_ttl must be at least status_links.ttl")
+	}
+	return nil
+}
+
+// CertificatesConfig holds certificate expiry monitoring configuration
+type CertificatesConfig struct {
+	ReminderDays []int         `mapstructure:"reminder_days"` // Days before a custom certificate expires to send a reminder
+	Interval     time.Duration `mapstructure:"interval"`      // How often expiry is checked
+	Monitor      bool          `mapstructure:"monitor"`       // Also track Bunny-managed certificates on every pull zone
+	AlertDays    []int         `mapstructure:"alert_days"`    // Days before a managed certificate expires to alert that it was not renewed
+}
+
+// NotificationsConfig holds summary channels besides Telegram
+type NotificationsConfig struct {
+	Email EmailConfig `mapstructure:"email"`
+}
+
+// EmailConfig holds the HTML email summary configuration
+type EmailConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// From is the sender address
+	From string   `mapstructure:"from"`
+	To   []string `mapstructure:"to"`
+	// Schedule and WeeklySchedule are the cron schedules of the daily and
+	// weekly email summaries; empty skips that summary
+	Schedule       string `mapstructure:"schedule"`
+	WeeklySchedule string `mapstructure:"weekly_schedule"`
+	// TopZones is how many zones the summaries list
+	TopZones int `mapstructure:"top_zones"`
+}
+
+// SLOConfig holds provisioning time objectives
+type SLOConfig struct {
+	// Target is how long a provisioning run may take before a slow
+	// provision alert is sent; 0 disables the alert
+	Target time.Duration `mapstructure:"target"`
+	// Degradation is the week-over-week increase of the p95 provisioning
+	// time, in percent, that triggers an alert; 0 disables the alert
+	Degradation float64 `mapstructure:"degradation"`
+}
+
+// IncidentsConfig holds the thresholds of the hourly pull zone checks that
+// open incidents, besides telegram.summary.bandwidth_alert_threshold
+type IncidentsConfig struct {
+	// HitRateThreshold is the cache hit rate, in percent, below which a
+	// zone's day so far opens an incident; 0 disables the check
+	HitRateThreshold float64 `mapstructure:"hit_rate_threshold"`
+	// OriginErrorRate is the share of requests, in percent, the origin
+	// answers with 5xx that opens an incident; 0 disables the check
+	OriginErrorRate float64 `mapstructure:"origin_error_rate"`
+	// MinRequests is how many requests a zone must have served today
+	// before its hit rate and error rate are checked
+	MinRequests int64 `mapstructure:"min_requests"`
+	// Anomaly compares each hour's requests with the same hour of the
+	// days before
+	Anomaly AnomalyConfig `mapstructure:"anomaly"`
+}
+
+// AnomalyConfig holds the statistical traffic anomaly check. A zone's
+// requests in an hour are compared with the median of the same hour of
+// the earlier days kept as snapshots; spikes and drops, such as a site
+// that stopped serving, open a traffic_anomaly incident
+type AnomalyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Threshold is how many standard deviations, estimated from the median
+	// absolute deviation, an hour must be from the median to be anomalous
+	Threshold float64 `mapstructure:"threshold"`
+	// MinSamples is how many earlier days of the same hour are needed
+	// before an hour is checked
+	MinSamples int `mapstructure:"min_samples"`
+	// MinRequests is the median requests an hour below which a zone is
+	// too quiet to check
+	MinRequests int64 `mapstructure:"min_requests"`
+}
+
+// HookEvents are the provisioning events hooks can run on
+var HookEvents = []string{"pre_provision", "post_pull_zone_create", "post_provision", "post_deprovision"}
+
+// Hook failure policies
+const (
+	HookIgnore = "ignore" // Failures are only recorded in the audit log
+	HookWarn   = "warn"   // Failures are logged as warnings
+	HookFail   = "fail"   // Failures fail the provision, which is retried later
+)
+
+// HookConfig is an action run on a provisioning event; exactly one of
+// Command, Script, URL and File is set
+type HookConfig struct {
+	Event   string        `mapstructure:"event"`
+	Command string        `mapstructure:"command"` // Shell command, given the event in WHM2BUNNY_* variables
+	Script  string        `mapstructure:"script"`  // Executable run without a shell, given the event like Command
+	Args    []string      `mapstructure:"args"`    // Arguments for Script
+	URL     string        `mapstructure:"url"`     // Receives the event as a JSON POST
+	File    string        `mapstructure:"file"`    // The event is appended as a JSON line
+	Timeout time.Duration `mapstructure:"timeout"`
+	// OnFailure is ignore, warn or fail; empty means fail for pre_provision
+	// and warn for the other events
+	OnFailure string `mapstructure:"on_failure"`
+	// RunAs is the system user commands and scripts run as; running as
+	// another user requires whm2bunny to run as root
+	RunAs string `mapstructure:"run_as"`
+	// Dir is the working directory of commands and scripts
+	Dir string `mapstructure:"dir"`
+}
+
+// FailurePolicy returns the effective OnFailure policy
+func (h HookConfig) FailurePolicy() string {
+	if h.OnFailure != "" {
+		return h.OnFailure
+	}
+	if h.Event == "pre_provision" {
+		return HookFail
+	}
+	return HookWarn
+}
+
+// validate checks the hook at index i
+func (h HookConfig) validate(i int) error {
+	if !slices.Contains(HookEvents, h.Event) {
+		return fmt.Errorf("hooks[%d].event must be one of %s, got %q", i, strings.Join(HookEvents, ", "), h.Event)
+	}
+	actions := 0
+	for _, action := range []string{h.Command, h.Script, h.URL, h.File} {
+		if action != "" {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("hooks[%d] must set exactly one of command, script, url and file", i)
+	}
+	if h.Script != "" && !filepath.IsAbs(h.Script) {
+		return fmt.Errorf("hooks[%d].script must be an absolute path, got %q", i, h.Script)
+	}
+	if len(h.Args) > 0 && h.Script == "" {
+		return fmt.Errorf("hooks[%d].args requires script", i)
+	}
+	if (h.RunAs != "" || h.Dir != "") && h.Command == "" && h.Script == "" {
+		return fmt.Errorf("hooks[%d].run_as and dir require command or script", i)
+	}
+	if h.URL != "" && !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return fmt.Errorf("hooks[%d].url must be an http or https URL, got %q", i, h.URL)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("hooks[%d].timeout must not be negative", i)
+	}
+	switch h.OnFailure {
+	case "", HookIgnore, HookWarn:
+	case HookFail:
+		if h.Event == "post_deprovision" {
+			return fmt.Errorf("hooks[%d].on_failure cannot be fail for post_deprovision, the domain is already removed", i)
+		}
+	default:
+		return fmt.Errorf("hooks[%d].on_failure must be ignore, warn or fail, got %q", i, h.OnFailure)
+	}
+	return nil
+}
+
+// ProfilesConfig maps WHM packages to CDN profiles
+// Package names are matched case-insensitively
+type ProfilesConfig struct {
+	Default     string                   `mapstructure:"default"`
+	Packages    map[string]string        `mapstructure:"packages"` // WHM package -> profile name
+	Definitions map[string]ProfileConfig `mapstructure:"definitions"`
+}
+
+// ProfileConfig holds optional CDN features applied at provision time
+type ProfileConfig struct {
+	// Rank orders profiles from basic to premium; a package change between
+	// profiles of different ranks is notified as an upgrade or downgrade
+	Rank       int              `mapstructure:"rank"`
+	Tier       string           `mapstructure:"tier"` // Pull zone tier: standard (default) or volume
+	PermaCache PermaCacheConfig `mapstructure:"perma_cache"`
+	Optimizer  OptimizerConfig  `mapstructure:"optimizer"`
+	TokenAuth  TokenAuthConfig  `mapstructure:"token_auth"`
+	Referrers  ReferrerConfig   `mapstructure:"referrers"`
+	// CanonicalHost redirects the other of <domain> and www.<domain> to
+	// it with a 301 at the edge: apex, www or empty for no redirect
+	CanonicalHost string `mapstructure:"canonical_host"`
+	// Logging enables request logs, e.g. for compliance-sensitive packages
+	Logging ZoneLoggingConfig `mapstructure:"logging"`
+
+	Origin           OriginOverride           `mapstructure:"origin"`
+	OriginResilience OriginResilienceOverride `mapstructure:"origin_resilience"`
+}
+
+// PermaCacheConfig holds Perma-Cache storage zone settings
+type PermaCacheConfig struct {
+	Enabled            bool     `mapstructure:"enabled"`
+	Region             string   `mapstructure:"region"`              // Storage zone main region, e.g. "DE", "SG"
+	ReplicationRegions []string `mapstructure:"replication_regions"` // Optional replica regions
+}
+
+// OptimizerConfig holds Bunny Optimizer settings
+type OptimizerConfig struct {
+	Enabled               bool `mapstructure:"enabled"`
+	AutomaticOptimization bool `mapstructure:"automatic_optimization"` // Automatic image optimization
+	WebP                  bool `mapstructure:"webp"`
+	MinifyCSS             bool `mapstructure:"minify_css"`
+	MinifyJavaScript      bool `mapstructure:"minify_js"`
+}
+
+// TokenAuthConfig holds URL token authentication (signed URL) settings
+type TokenAuthConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// ZoneLoggingConfig holds the request logging of pull zones. Bunny logs
+// requests by default, so with Enabled unset pull zones keep whatever
+// logging they have and drift does not check it
+type ZoneLoggingConfig struct {
+	Enabled *bool `mapstructure:"enabled"`
+	// Anonymization of client IPs: none (default), one_digit or drop
+	Anonymization string              `mapstructure:"anonymization"`
+	Forwarding    LogForwardingConfig `mapstructure:"forwarding"`
+}
+
+// LogForwardingConfig holds the syslog endpoint pull zone logs are
+// forwarded to; an empty hostname does not forward them
+type LogForwardingConfig struct {
+	Hostname string `mapstructure:"hostname"`
+	Port     int    `mapstructure:"port"`
+	Token    string `mapstructure:"token"`    // Sent with each line, for hosted log services
+	Protocol string `mapstructure:"protocol"` // udp (default), tcp or tcp_encrypted
+	Format   string `mapstructure:"format"`   // plain (default) or json
+}
+
+// validate checks the anonymization and the forwarding endpoint
+func (l ZoneLoggingConfig) validate(field string) error {
+	switch l.Anonymization {
+	case "", "none", "one_digit", "drop":
+	default:
+		return fmt.Errorf("%s.anonymization must be none, one_digit or drop, got %q", field, l.Anonymization)
+	}
+	f := l.Forwarding
+	if f.Hostname == "" {
+		return nil
+	}
+	if l.Enabled == nil || !*l.Enabled {
+		return fmt.Errorf("%s.forwarding needs %s.enabled", field, field)
+	}
+	if strings.ContainsAny(f.Hostname, " /:\t") {
+		return fmt.Errorf("%s.forwarding.hostname must be a hostname or IP, got %q", field, f.Hostname)
+	}
+	if f.Port < 1 || f.Port > 65535 {
+		return fmt.Errorf("%s.forwarding.port must be between 1 and 65535", field)
+	}
+	switch f.Protocol {
+	case "", "udp", "tcp", "tcp_encrypted":
+	default:
+		return fmt.Errorf("%s.forwarding.protocol must be udp, tcp or tcp_encrypted, got %q", field, f.Protocol)
+	}
+	switch f.Format {
+	case "", "plain", "json":
+	default:
+		return fmt.Errorf("%s.forwarding.format must be plain or json, got %q", field, f.Format)
+	}
+	return nil
+}
+
+// ReferrerConfig holds referrer and hotlink protection settings
+type ReferrerConfig struct {
+	HotlinkProtection bool     `mapstructure:"hotlink_protection"` // Only the domain itself (plus Allowed) may embed content
+	Allowed           []string `mapstructure:"allowed"`
+	Blocked           []string `mapstructure:"blocked"`
+	BlockNoReferrer   bool     `mapstructure:"block_no_referrer"`
+}
+
+// Resolve returns the profile name and settings for a WHM package,
+// falling back to the default profile when the package is unmapped
+func (p ProfilesConfig) Resolve(pkg string) (string, ProfileConfig) {
+	name := p.Default
+	if pkg != "" {
+		for k, v := range p.Packages {
+			if strings.EqualFold(k, pkg) {
+				name = v
+				break
+			}
+		}
+	}
+
+	if name == "" {
+		return "", ProfileConfig{}
+	}
+	if k, v, ok := p.lookup(name); ok {
+		return k, v
+	}
+	return name, ProfileConfig{}
+}
+
+// Named returns the profile definition called name, ignoring case, with
+// its name as defined
+func (p ProfilesConfig) Named(name string) (string, ProfileConfig, bool) {
+	return p.lookup(name)
+}
+
+// lookup finds a profile definition by name, ignoring case
+func (p ProfilesConfig) lookup(name string) (string, ProfileConfig, bool) {
+	for k, v := range p.Definitions {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+	return "", ProfileConfig{}, false
+}
+
+// EncryptionConfig encrypts the state, snapshot and notification queue
+// files at rest with AES-256-GCM
+type EncryptionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Key is the base64 encoded 32-byte key; prefer the
+	// STATE_ENCRYPTION_KEY env var or KeyFile over the config file
+	Key string `mapstructure:"key"`
+	// KeyFile holds the key when Key is empty
+	KeyFile string `mapstructure:"key_file"`
+	// PreviousKeyFiles hold keys files are still decrypted with, while
+	// "whm2bunny state reencrypt" moves them to the current key
+	PreviousKeyFiles []string `mapstructure:"previous_key_files"`
+}
+
+// validate checks that encryption has a key when enabled
+func (e EncryptionConfig) validate() error {
+	if e.Enabled && e.Key == "" && e.KeyFile == "" {
+		return fmt.Errorf("encryption.key_file is required with encryption.enabled (or set STATE_ENCRYPTION_KEY env var)")
+	}
+	return nil
+}
+
+// Backup storages
+const (
+	BackupStorageBunny = "bunny"
+	BackupStorageS3    = "s3"
+)
+
+// BackupConfig holds the scheduled backups of the provisioning state, the
+// audit log and the DNS zones, uploaded encrypted to a Bunny Storage zone
+// or an S3-compatible bucket
+type BackupConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Schedule is the cron schedule of the backups
+	Schedule string `mapstructure:"schedule"`
+	// Retention is how many backups are kept; older ones are deleted
+	// after each backup
+	Retention int `mapstructure:"retention"`
+	// Prefix is the directory backups are stored in
+	Prefix string `mapstructure:"prefix"`
+	// Zones adds a zone file of each provisioned domain's DNS zone
+	Zones bool `mapstructure:"zones"`
+	// Storage is where backups are uploaded: bunny or s3
+	Storage      string                   `mapstructure:"storage"`
+	BunnyStorage BackupBunnyStorageConfig `mapstructure:"bunny_storage"`
+	S3           BackupS3Config           `mapstructure:"s3"`
+}
+
+// BackupBunnyStorageConfig is the Bunny Storage zone backups are uploaded to
+type BackupBunnyStorageConfig struct {
+	Zone string `mapstructure:"zone"`
+	// Password is the storage zone password; prefer the
+	// BACKUP_STORAGE_PASSWORD env var
+	Password string `mapstructure:"password"`
+	// Endpoint is the storage API of the zone's region, such as
+	// https://ny.storage.bunnycdn.com
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// BackupS3Config is the S3-compatible bucket backups are uploaded to,
+// addressed path-style as Endpoint/Bucket/key
+type BackupS3Config struct {
+	Endpoint    string `mapstructure:"endpoint"`
+	Region      string `mapstructure:"region"`
+	Bucket      string `mapstructure:"bucket"`
+	AccessKeyID string `mapstructure:"access_key_id"`
+	// SecretAccessKey is best set with the BACKUP_S3_SECRET_ACCESS_KEY
+	// env var
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+}
+
+// ValidateStorage checks that the selected backup storage is complete
+func (b BackupConfig) ValidateStorage() error {
+	switch b.Storage {
+	case BackupStorageBunny:
+		if b.BunnyStorage.Zone == "" || b.BunnyStorage.Password == "" {
+			return fmt.Errorf("backup.bunny_storage.zone and backup.bunny_storage.password are required (or set BACKUP_STORAGE_PASSWORD env var)")
+		}
+		if _, err := url.ParseRequestURI(b.BunnyStorage.Endpoint); err != nil {
+			return fmt.Errorf("backup.bunny_storage.endpoint must be a URL: %w", err)
+		}
+	case BackupStorageS3:
+		s3 := b.S3
+		if s3.Bucket == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			return fmt.Errorf("backup.s3.bucket, backup.s3.access_key_id and backup.s3.secret_access_key are required (or set BACKUP_S3_SECRET_ACCESS_KEY env var)")
+		}
+		if _, err := url.ParseRequestURI(s3.Endpoint); err != nil {
+			return fmt.Errorf("backup.s3.endpoint must be a URL: %w", err)
+		}
+	default:
+		return fmt.Errorf("backup.storage must be %s or %s, got %q", BackupStorageBunny, BackupStorageS3, b.Storage)
+	}
+	return nil
+}
+
+// validate checks the backup schedule and storage when backups are enabled
+func (b BackupConfig) validate(encryption EncryptionConfig) error {
+	if !b.Enabled {
+		return nil
+	}
+	if !encryption.Enabled {
+		return fmt.Errorf("backup.enabled requires encryption.enabled, backups are uploaded encrypted with its key")
+	}
+	if _, err := cron.ParseStandard(b.Schedule); err != nil {
+		return fmt.Errorf("backup.schedule is not a valid cron schedule: %w", err)
+	}
+	if b.Retention < 1 {
+		return fmt.Errorf("backup.retention must be at least 1")
+	}
+	return b.ValidateStorage()
+}
+
+// UpdateConfig holds where "whm2bunny self-update" gets releases from
+// URL holds the release archives and their checksums; with PublicKey, an
+// Ed25519 key, the checksums must be signed with it. Service is the systemd
+// unit --restart restarts
+type UpdateConfig struct {
+	URL       string        `mapstructure:"url"`
+	PublicKey string        `mapstructure:"public_key"`
+	Service   string        `mapstructure:"service"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// validate checks the release URL and public key
+func (u UpdateConfig) validate() error {
+	parsed, err := url.Parse(u.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("update.url must be an http(s) URL, got %q", u.URL)
+	}
+	if u.PublicKey != "" {
+		if _, err := selfupdate.ParsePublicKey(u.PublicKey); err != nil {
+			return fmt.Errorf("update.public_key: %w", err)
+		}
+	}
+	if u.Timeout <= 0 {
+		return fmt.Errorf("update.timeout must be positive")
+	}
+	return nil
 }
 
 // LoggingConfig holds logging configuration
+// RunLogs is how many provisioning runs of each domain are kept in memory,
+// with up to RunLogEntries log entries each, for the API; 0 keeps none
 type LoggingConfig struct {
-	Level  string `mapstructure:"level"`
-	Format string `mapstructure:"format"`
+	Level         string `mapstructure:"level"`
+	Format        string `mapstructure:"format"`
+	RunLogs       int    `mapstructure:"run_logs"`
+	RunLogEntries int    `mapstructure:"run_log_entries"`
 }
 
 // Load loads configuration from file and environment variables
@@ -86,9 +1397,27 @@ type LoggingConfig struct {
 // - BUNNY_API_KEY: Bunny.net API key
 // - ORIGIN_IP: Origin server IP address (WHM/cPanel server)
 // - WHM_HOOK_SECRET: Webhook HMAC secret
+// - API_TOKEN: Management API bearer token (optional)
+// - WHM_API_TOKEN: WHM API token (optional)
 // - TELEGRAM_BOT_TOKEN: Telegram bot token (optional)
 // - TELEGRAM_CHAT_ID: Telegram chat ID (optional)
+// - STATE_ENCRYPTION_KEY: Key encrypting the state files (optional)
+// - BACKUP_STORAGE_PASSWORD: Bunny Storage password for backups (optional)
+// - BACKUP_S3_SECRET_ACCESS_KEY: S3 secret key for backups (optional)
+// - STATUS_LINK_SECRET: Key signing the status page links (optional)
+// - INSTANCE_NAME: Name of this server in a fleet (optional)
 func Load(path string) (*Config, error) {
+	return LoadEnv(path, "")
+}
+
+// LoadEnv loads configuration like Load, merging the files returned by
+// Layers for path and env over each other in order
+func LoadEnv(path, env string) (*Config, error) {
+	layers, err := Layers(path, env)
+	if err != nil {
+		return nil, err
+	}
+
 	v := viper.New()
 
 	// Set defaults
@@ -120,12 +1449,26 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	// Merge the conf.d files and the environment overlay over the base file
+	for _, layer := range layers {
+		if layer == path {
+			continue
+		}
+		v.SetConfigFile(layer)
+		if err := v.MergeInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to merge config file %s: %w", layer, err)
+		}
+	}
+
 	// Unmarshal into config struct
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	cfg.sources = layers
+	cfg.defaulted = defaultedKeys(v)
+
 	// Substitute environment variables in string values
 	substituteEnvVars(&cfg)
 
@@ -140,12 +1483,36 @@ func Load(path string) (*Config, error) {
 	if secret := os.Getenv("WHM_HOOK_SECRET"); secret != "" {
 		cfg.Webhook.Secret = secret
 	}
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		cfg.API.Token = token
+	}
+	if token := os.Getenv("WHM_API_TOKEN"); token != "" {
+		cfg.WHM.Token = token
+	}
 	if botToken := os.Getenv("TELEGRAM_BOT_TOKEN"); botToken != "" {
 		cfg.Telegram.BotToken = botToken
 	}
 	if chatID := os.Getenv("TELEGRAM_CHAT_ID"); chatID != "" {
 		cfg.Telegram.ChatID = chatID
 	}
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		cfg.Notifications.Email.Password = password
+	}
+	if key := os.Getenv("STATE_ENCRYPTION_KEY"); key != "" {
+		cfg.Encryption.Key = key
+	}
+	if password := os.Getenv("BACKUP_STORAGE_PASSWORD"); password != "" {
+		cfg.Backup.BunnyStorage.Password = password
+	}
+	if secret := os.Getenv("BACKUP_S3_SECRET_ACCESS_KEY"); secret != "" {
+		cfg.Backup.S3.SecretAccessKey = secret
+	}
+	if secret := os.Getenv("STATUS_LINK_SECRET"); secret != "" {
+		cfg.StatusLinks.Secret = secret
+	}
+	if instance := os.Getenv("INSTANCE_NAME"); instance != "" {
+		cfg.Instance = instance
+	}
 
 	// Validate required fields
 	if err := cfg.Validate(); err != nil {
@@ -155,9 +1522,68 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// Layers returns the config files merged for path, in order: the base
+// file, the *.yaml files of the conf.d directory next to it sorted by name,
+// and, when env is set, the overlay named after the base file and env, e.g.
+// config.staging.yaml. A missing conf.d directory is not an error, a missing
+// overlay is
+func Layers(path, env string) ([]string, error) {
+	if path == "" {
+		if env != "" {
+			return nil, fmt.Errorf("environment %q needs a config file path", env)
+		}
+		return nil, nil
+	}
+
+	layers := []string{path}
+
+	confd, err := filepath.Glob(filepath.Join(filepath.Dir(path), ConfDir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", ConfDir, err)
+	}
+	slices.Sort(confd)
+	layers = append(layers, confd...)
+
+	if env == "" {
+		return layers, nil
+	}
+	if strings.ContainsAny(env, `/\.`) {
+		return nil, fmt.Errorf("invalid environment %q", env)
+	}
+	ext := filepath.Ext(path)
+	overlay := strings.TrimSuffix(path, ext) + "." + env + ext
+	if _, err := os.Stat(overlay); err != nil {
+		return nil, fmt.Errorf("environment %q: %w", env, err)
+	}
+	return append(layers, overlay), nil
+}
+
+// FeatureFlags returns the feature flags set by the flags section,
+// overridden by their WHM2BUNNY_FLAG_<NAME> environment variables
+func (c *Config) FeatureFlags() (*flags.Set, error) {
+	return flags.Resolve(c.Flags, os.LookupEnv)
+}
+
+// validateFlags checks the feature flags and that the behaviors they gate
+// are only configured while their flag is enabled
+func (c *Config) validateFlags() error {
+	ff, err := c.FeatureFlags()
+	if err != nil {
+		return fmt.Errorf("flags: %w", err)
+	}
+	if c.DNS.RecordStrategy == RecordStrategyApexViaCDN && !ff.Enabled(flags.ApexOnCDN) {
+		return fmt.Errorf("dns.record_strategy %s needs the %s feature flag", RecordStrategyApexViaCDN, flags.ApexOnCDN)
+	}
+	if c.Drift.Enabled && c.Drift.Fix && !ff.Enabled(flags.DriftAutoRepair) {
+		return fmt.Errorf("drift.fix needs the %s feature flag; set drift.fix false to only report drift", flags.DriftAutoRepair)
+	}
+	return nil
+}
+
 // Validate checks if all required configuration fields are set
 func (c *Config) Validate() error {
-	if c.Bunny.APIKey == "" {
+	// Observe-only servers never call Bunny, so a trial needs no API key
+	if c.Bunny.APIKey == "" && c.Provisioning.Enabled {
 		return fmt.Errorf("bunny.api_key is required (set BUNNY_API_KEY env var)")
 	}
 	if c.Origin.IP == "" {
@@ -166,30 +1592,352 @@ func (c *Config) Validate() error {
 	if c.Webhook.Secret == "" {
 		return fmt.Errorf("webhook.secret is required (set WHM_HOOK_SECRET env var)")
 	}
+	if c.Webhook.Debounce < 0 {
+		return fmt.Errorf("webhook.debounce must not be negative")
+	}
+	if c.Webhook.MaxBodyBytes <= 0 {
+		return fmt.Errorf("webhook.max_body_bytes must be positive")
+	}
+	if c.Webhook.BacklogThreshold < 0 {
+		return fmt.Errorf("webhook.backlog_threshold must not be negative")
+	}
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
+	if err := c.Bunny.Chaos.validate(); err != nil {
+		return err
+	}
+	if err := c.Bunny.Health.validate(); err != nil {
+		return err
+	}
+	if err := c.Encryption.validate(); err != nil {
+		return err
+	}
+	if err := c.WHM.validate(); err != nil {
+		return err
+	}
+	if err := c.Proxy.validate(); err != nil {
+		return err
+	}
+	if err := c.DNS.validate(); err != nil {
+		return err
+	}
+	if c.DNS.SOAEmailFromReseller && !c.WHM.Enabled() {
+		return fmt.Errorf("dns.soa_email_from_reseller requires the WHM API; set whm.url and whm.token")
+	}
+	if err := c.Origin.validateConnection("origin"); err != nil {
+		return err
+	}
+	if err := c.CDN.OriginResilience.validate("cdn.origin_resilience"); err != nil {
+		return err
+	}
+	if c.CDN.HTTPS.Timeout < 0 {
+		return fmt.Errorf("cdn.https.timeout must not be negative")
+	}
+	if c.CDN.VHostCheck.Enabled && c.CDN.VHostCheck.Timeout <= 0 {
+		return fmt.Errorf("cdn.vhost_check.timeout must be positive")
+	}
+	if prefix := c.ZoneNamer().Prefix(); !zonePrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("cdn.zone_name_prefix must be lowercase letters, digits and dashes, got %q", prefix)
+	}
+	for _, prefix := range c.CDN.PreviousZoneNamePrefixes {
+		if !zonePrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("cdn.previous_zone_name_prefixes must be lowercase letters, digits and dashes, got %q", prefix)
+		}
+	}
+	if c.Locale != "" && !i18n.IsSupported(c.Locale) {
+		return fmt.Errorf("locale must be one of %s, got %q", strings.Join(i18n.Supported(), ", "), c.Locale)
+	}
+	if c.Instance != "" && !instancePattern.MatchString(c.Instance) {
+		return fmt.Errorf("instance must be 1-64 letters, digits, dots, dashes or underscores, got %q", c.Instance)
+	}
+	if c.Archive.Enabled && c.Archive.AfterDays < 1 {
+		return fmt.Errorf("archive.after_days must be at least 1")
+	}
+	if c.Logging.RunLogs < 0 {
+		return fmt.Errorf("logging.run_logs must not be negative")
+	}
+	if c.Logging.RunLogs > 0 && c.Logging.RunLogEntries < 1 {
+		return fmt.Errorf("logging.run_log_entries must be at least 1")
+	}
+	if c.State.Batching && c.State.FlushInterval <= 0 {
+		return fmt.Errorf("state.flush_interval must be positive")
+	}
+	if c.State.Batching && c.State.FlushMutations < 1 {
+		return fmt.Errorf("state.flush_mutations must be at least 1")
+	}
+	if c.Snapshots.RawDays < 1 {
+		return fmt.Errorf("snapshots.raw_days must be at least 1")
+	}
+	if c.Snapshots.DailyDays < c.Snapshots.RawDays {
+		return fmt.Errorf("snapshots.daily_days must be at least snapshots.raw_days (%d), got %d", c.Snapshots.RawDays, c.Snapshots.DailyDays)
+	}
+	if c.StatusFiles.Enabled && !filepath.IsAbs(c.StatusFiles.Dir) {
+		return fmt.Errorf("status_files.dir must be an absolute path, got %q", c.StatusFiles.Dir)
+	}
+	for _, days := range c.Certificates.ReminderDays {
+		if days < 1 {
+			return fmt.Errorf("certificates.reminder_days must be at least 1, got %d", days)
+		}
+	}
+	for _, days := range c.Certificates.AlertDays {
+		if days < 1 {
+			return fmt.Errorf("certificates.alert_days must be at least 1, got %d", days)
+		}
+	}
+	for i, hook := range c.Hooks {
+		if err := hook.validate(i); err != nil {
+			return err
+		}
+	}
+	if c.Telegram.Summary.Concurrency < 0 {
+		return fmt.Errorf("telegram.summary.concurrency must not be negative")
+	}
+	if c.Telegram.Summary.RequestsPerSecond < 0 {
+		return fmt.Errorf("telegram.summary.requests_per_second must not be negative")
+	}
+	if c.Telegram.Summary.Timeout < 0 {
+		return fmt.Errorf("telegram.summary.timeout must not be negative")
+	}
+	switch c.Telegram.Summary.Attachment {
+	case "", "csv", "json":
+	default:
+		return fmt.Errorf("telegram.summary.attachment must be csv or json, got %q", c.Telegram.Summary.Attachment)
+	}
+	if c.Telegram.Summary.IdleDays < 0 {
+		return fmt.Errorf("telegram.summary.idle_days must not be negative")
+	}
+	if c.Telegram.Summary.IdleMaxRequests < 0 {
+		return fmt.Errorf("telegram.summary.idle_max_requests must not be negative")
+	}
+	if err := c.Telegram.validateRoutes(); err != nil {
+		return err
+	}
+	if c.Telegram.Enabled {
+		if err := c.Telegram.Delivery.validate(c.Notifications.Email); err != nil {
+			return err
+		}
+	}
+	if email := c.Notifications.Email; email.Enabled {
+		if email.Host == "" {
+			return fmt.Errorf("notifications.email.host is required")
+		}
+		if _, err := mail.ParseAddress(email.From); err != nil {
+			return fmt.Errorf("notifications.email.from is not a valid address: %w", err)
+		}
+		if len(email.To) == 0 {
+			return fmt.Errorf("notifications.email.to requires at least one address")
+		}
+		for i, to := range email.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("notifications.email.to[%d] is not a valid address: %w", i, err)
+			}
+		}
+		if email.Port <= 0 || email.Port > 65535 {
+			return fmt.Errorf("notifications.email.port must be between 1 and 65535")
+		}
+	}
+	if c.SLO.Target < 0 {
+		return fmt.Errorf("slo.target must not be negative")
+	}
+	if c.SLO.Degradation < 0 {
+		return fmt.Errorf("slo.degradation must not be negative")
+	}
+	if c.Incidents.HitRateThreshold < 0 || c.Incidents.HitRateThreshold > 100 {
+		return fmt.Errorf("incidents.hit_rate_threshold must be between 0 and 100")
+	}
+	if c.Incidents.OriginErrorRate < 0 || c.Incidents.OriginErrorRate > 100 {
+		return fmt.Errorf("incidents.origin_error_rate must be between 0 and 100")
+	}
+	if c.Incidents.MinRequests < 0 {
+		return fmt.Errorf("incidents.min_requests must not be negative")
+	}
+	if a := c.Incidents.Anomaly; a.Enabled {
+		if a.Threshold <= 0 {
+			return fmt.Errorf("incidents.anomaly.threshold must be positive")
+		}
+		if a.MinSamples < 3 {
+			return fmt.Errorf("incidents.anomaly.min_samples must be at least 3")
+		}
+		if a.MinRequests < 0 {
+			return fmt.Errorf("incidents.anomaly.min_requests must not be negative")
+		}
+	}
+	if err := c.API.validate(); err != nil {
+		return err
+	}
+	if err := c.StatusLinks.validate(); err != nil {
+		return err
+	}
+	if err := c.Backup.validate(c.Encryption); err != nil {
+		return err
+	}
+	if err := c.Update.validate(); err != nil {
+		return err
+	}
+	if err := c.validateFlags(); err != nil {
+		return err
+	}
+	for name, profile := range c.Profiles.Definitions {
+		switch strings.ToLower(profile.Tier) {
+		case "", "standard", "volume":
+		default:
+			return fmt.Errorf("profiles.definitions.%s.tier must be standard or volume, got %q", name, profile.Tier)
+		}
+		if err := c.Origin.Merge(profile.Origin).validateConnection("profiles.definitions." + name + ".origin"); err != nil {
+			return err
+		}
+		merged := c.CDN.OriginResilience.Merge(profile.OriginResilience)
+		if err := merged.validate("profiles.definitions." + name + ".origin_resilience"); err != nil {
+			return err
+		}
+		if err := profile.Logging.validate("profiles.definitions." + name + ".logging"); err != nil {
+			return err
+		}
+		if err := c.DNS.ValidateCanonicalHost(profile.CanonicalHost); err != nil {
+			return fmt.Errorf("profiles.definitions.%s.canonical_host: %w", name, err)
+		}
+	}
+	for pkg, name := range c.Profiles.Packages {
+		if _, _, ok := c.Profiles.lookup(name); !ok {
+			return fmt.Errorf("profiles.packages.%s refers to undefined profile %q", pkg, name)
+		}
+	}
+	if c.Profiles.Default != "" {
+		if _, _, ok := c.Profiles.lookup(c.Profiles.Default); !ok {
+			return fmt.Errorf("profiles.default refers to undefined profile %q", c.Profiles.Default)
+		}
+	}
 	return nil
 }
 
+// instancePattern matches instance names, which are used as filters in
+// queries and must survive being a header value or label
+var instancePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// zonePrefixPattern matches zone name prefixes; Bunny names may only hold
+// lowercase letters, digits and dashes
+var zonePrefixPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ZoneNamer returns the namer of the pull and storage zones of this server,
+// with the instance name expanded in cdn.zone_name_prefix
+func (c *Config) ZoneNamer() *zonename.Namer {
+	prefix := c.CDN.ZoneNamePrefix
+	if prefix == "" {
+		prefix = DefaultZoneNamePrefix
+	}
+	return zonename.New(zonename.Expand(prefix, c.InstanceName()), c.CDN.PreviousZoneNamePrefixes...)
+}
+
+// InstanceName returns the name of this server: instance, or else the
+// hostname
+func (c *Config) InstanceName() string {
+	if c.Instance != "" {
+		return c.Instance
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// APIToken returns the management API bearer token, falling back to the webhook secret
+func (c *Config) APIToken() string {
+	if c.API.Token != "" {
+		return c.API.Token
+	}
+	return c.Webhook.Secret
+}
+
 // setDefaults sets default configuration values
 func setDefaults(v *viper.Viper) {
+	v.SetDefault("locale", DefaultLocale)
+	v.SetDefault("instance", "")
+
 	// Server defaults
 	v.SetDefault("server.port", DefaultPort)
 	v.SetDefault("server.host", DefaultHost)
+	v.SetDefault("server.listen", "")
+	v.SetDefault("server.socket_mode", DefaultSocketMode)
+	v.SetDefault("server.read_header_timeout", DefaultReadHeaderTimeout)
 
 	// Bunny defaults
 	v.SetDefault("bunny.base_url", DefaultBunnyBaseURL)
+	v.SetDefault("bunny.chaos.enabled", false)
+	v.SetDefault("bunny.chaos.rate", DefaultChaosRate)
+	v.SetDefault("bunny.chaos.faults", chaosFaults)
+	v.SetDefault("bunny.chaos.delay", DefaultChaosDelay)
+	v.SetDefault("bunny.health.enabled", true)
+	v.SetDefault("bunny.health.interval", DefaultBunnyHealthInterval)
+	v.SetDefault("bunny.health.window", DefaultBunnyHealthWindow)
+	v.SetDefault("bunny.health.availability", DefaultBunnyHealthAvailability)
+	v.SetDefault("bunny.health.min_requests", DefaultBunnyHealthMinRequests)
+
+	// Webhook defaults
+	v.SetDefault("webhook.debounce", DefaultWebhookDebounce)
+	v.SetDefault("webhook.max_body_bytes", DefaultWebhookMaxBodyBytes)
+	v.SetDefault("webhook.backlog_threshold", DefaultWebhookBacklogThreshold)
+
+	// Provisioning defaults
+	v.SetDefault("provisioning.enabled", true)
+
+	// WHM API defaults
+	v.SetDefault("whm.url", "")
+	v.SetDefault("whm.username", DefaultWHMUsername)
+	v.SetDefault("whm.token", "")
+	v.SetDefault("whm.insecure_skip_verify", false)
+	v.SetDefault("whm.timeout", DefaultWHMTimeout)
+
+	// Proxy defaults
+	v.SetDefault("proxy.url", "")
+	v.SetDefault("proxy.no_proxy", []string{})
+	v.SetDefault("proxy.bunny", "")
+	v.SetDefault("proxy.telegram", "")
+	v.SetDefault("proxy.whm", "")
+	v.SetDefault("proxy.dns", "")
 
 	// DNS defaults
 	v.SetDefault("dns.nameserver1", DefaultNameserver1)
 	v.SetDefault("dns.nameserver2", DefaultNameserver2)
 	v.SetDefault("dns.soa_email", DefaultSOAEmail)
+	v.SetDefault("dns.soa_email_from_reseller", false)
+	v.SetDefault("dns.record_strategy", RecordStrategyCDNSubdomainOnly)
+	v.SetDefault("dns.service_records.enabled", false)
+	v.SetDefault("dns.service_records.target", "")
+	v.SetDefault("dns.service_records.names", DefaultServiceRecordNames)
+	v.SetDefault("dns.propagation.enabled", false)
+	v.SetDefault("dns.propagation.timeout", DefaultPropagationTimeout)
+	v.SetDefault("dns.propagation.interval", DefaultPropagationInterval)
 
 	// CDN defaults
 	v.SetDefault("cdn.origin_shield_region", DefaultOriginShieldRegion)
+
+	// Origin connection defaults
+	v.SetDefault("origin.protocol", DefaultOriginProtocol)
+	v.SetDefault("origin.verify_ssl", true)
 	v.SetDefault("cdn.regions", []string{"asia"})
+	v.SetDefault("cdn.origin_resilience.stale_while_offline", true)
+	v.SetDefault("cdn.origin_resilience.stale_while_updating", true)
+	v.SetDefault("cdn.origin_resilience.connect_timeout", DefaultOriginConnectTimeout)
+	v.SetDefault("cdn.origin_resilience.response_timeout", DefaultOriginResponseTimeout)
+	v.SetDefault("cdn.origin_resilience.retries", DefaultOriginRetries)
+	v.SetDefault("cdn.https.enabled", true)
+	v.SetDefault("cdn.https.force_ssl", true)
+	v.SetDefault("cdn.https.timeout", DefaultCertificateTimeout)
+	v.SetDefault("cdn.vhost_check.enabled", false)
+	v.SetDefault("cdn.vhost_check.timeout", DefaultVHostCheckTimeout)
+	v.SetDefault("cdn.zone_name_prefix", DefaultZoneNamePrefix)
+
+	// Encryption defaults
+	v.SetDefault("encryption.enabled", false)
+	v.SetDefault("encryption.key_file", "")
 
 	// Logging defaults
 	v.SetDefault("logging.level", DefaultLogLevel)
 	v.SetDefault("logging.format", DefaultLogFormat)
+	v.SetDefault("logging.run_logs", DefaultRunLogs)
+	v.SetDefault("logging.run_log_entries", DefaultRunLogEntries)
 
 	// Telegram defaults
 	v.SetDefault("telegram.enabled", false)
@@ -200,13 +1948,149 @@ func setDefaults(v *viper.Viper) {
 		"bandwidth_alert",
 		"deprovisioned",
 		"subdomain_provisioned",
+		"maintenance",
+		"balance",
+		"token_rotated",
+		"drift",
+		"emergency",
+		"certificate_expiry",
+		"slow_provision",
+		"slo_degraded",
+		"package_changed",
+		"zone_disabled",
+		"observed",
 	})
+	v.SetDefault("telegram.templates_dir", "")
+	v.SetDefault("telegram.commands", false)
+	v.SetDefault("telegram.delivery.backoff", DefaultTelegramBackoff)
+	v.SetDefault("telegram.delivery.max_backoff", DefaultTelegramMaxBackoff)
+	v.SetDefault("telegram.delivery.max_age", DefaultTelegramMaxAge)
+	v.SetDefault("telegram.delivery.fallback", "")
+	v.SetDefault("telegram.delivery.fallback_after", DefaultTelegramFallbackAfter)
+	v.SetDefault("telegram.delivery.fallback_url", "")
 	v.SetDefault("telegram.summary.enabled", true)
 	v.SetDefault("telegram.summary.schedule", "0 9 * * *")
 	v.SetDefault("telegram.summary.weekly_schedule", "0 9 * * 1")
 	v.SetDefault("telegram.summary.timezone", "Asia/Jakarta")
 	v.SetDefault("telegram.summary.include_top_bandwidth", 20)
 	v.SetDefault("telegram.summary.bandwidth_alert_threshold", 50)
+	v.SetDefault("telegram.summary.concurrency", DefaultSummaryConcurrency)
+	v.SetDefault("telegram.summary.requests_per_second", DefaultSummaryRequestsPerSecond)
+	v.SetDefault("telegram.summary.timeout", DefaultSummaryTimeout)
+	v.SetDefault("telegram.summary.attachment", "")
+	v.SetDefault("telegram.summary.idle_days", DefaultIdleDays)
+	v.SetDefault("telegram.summary.idle_max_requests", DefaultIdleMaxRequests)
+
+	// Maintenance defaults
+	v.SetDefault("maintenance.timezone", "Asia/Jakarta")
+
+	// Quota defaults
+	v.SetDefault("quota.enabled", false)
+	v.SetDefault("quota.timezone", "Asia/Jakarta")
+
+	// API defaults
+	v.SetDefault("api.enabled", true)
+
+	// Drift defaults
+	v.SetDefault("drift.enabled", false)
+	v.SetDefault("drift.interval", DefaultDriftInterval)
+	v.SetDefault("drift.fix", true)
+
+	// Zone monitor defaults
+	v.SetDefault("zone_monitor.enabled", true)
+	v.SetDefault("zone_monitor.interval", DefaultZoneMonitorInterval)
+
+	// Discovery defaults
+	v.SetDefault("discovery.enabled", false)
+	v.SetDefault("discovery.interval", DefaultDiscoveryInterval)
+	v.SetDefault("discovery.userdata_domains", DefaultUserdataDomainsPath)
+
+	// Archive defaults
+	v.SetDefault("archive.enabled", true)
+	v.SetDefault("archive.after_days", DefaultArchiveAfterDays)
+	v.SetDefault("archive.interval", DefaultArchiveInterval)
+	v.SetDefault("state.batching", false)
+	v.SetDefault("state.flush_interval", DefaultStateFlushInterval)
+	v.SetDefault("state.flush_mutations", DefaultStateFlushMutations)
+
+	// Snapshot retention defaults
+	v.SetDefault("snapshots.raw_days", DefaultSnapshotRawDays)
+	v.SetDefault("snapshots.daily_days", DefaultSnapshotDailyDays)
+	v.SetDefault("snapshots.interval", DefaultSnapshotInterval)
+
+	// Status file defaults
+	v.SetDefault("status_files.enabled", false)
+	v.SetDefault("status_files.dir", DefaultStatusFilesDir)
+
+	// Status link defaults
+	v.SetDefault("status_links.enabled", false)
+	v.SetDefault("status_links.ttl", DefaultStatusLinkTTL)
+	v.SetDefault("status_links.max_ttl", DefaultStatusLinkMaxTTL)
+
+	// Custom certificate defaults
+	v.SetDefault("certificates.reminder_days", DefaultCertificateReminderDays)
+	v.SetDefault("certificates.interval", DefaultCertificateCheckInterval)
+	v.SetDefault("certificates.monitor", true)
+	v.SetDefault("certificates.alert_days", DefaultCertificateAlertDays)
+
+	// Provisioning SLO defaults
+	v.SetDefault("notifications.email.enabled", false)
+	v.SetDefault("notifications.email.port", DefaultEmailPort)
+	v.SetDefault("notifications.email.schedule", DefaultEmailSchedule)
+	v.SetDefault("notifications.email.weekly_schedule", DefaultEmailWeeklySchedule)
+	v.SetDefault("notifications.email.top_zones", DefaultEmailTopZones)
+	v.SetDefault("slo.target", DefaultSLOTarget)
+	v.SetDefault("slo.degradation", DefaultSLODegradation)
+
+	// Incident defaults
+	v.SetDefault("incidents.hit_rate_threshold", DefaultIncidentHitRate)
+	v.SetDefault("incidents.origin_error_rate", DefaultIncidentOriginErrorRate)
+	v.SetDefault("incidents.min_requests", DefaultIncidentMinRequests)
+	v.SetDefault("incidents.anomaly.enabled", true)
+	v.SetDefault("incidents.anomaly.threshold", DefaultAnomalyThreshold)
+	v.SetDefault("incidents.anomaly.min_samples", DefaultAnomalyMinSamples)
+	v.SetDefault("incidents.anomaly.min_requests", DefaultAnomalyMinRequests)
+
+	// Backup defaults
+	v.SetDefault("backup.enabled", false)
+	v.SetDefault("backup.schedule", DefaultBackupSchedule)
+	v.SetDefault("backup.retention", DefaultBackupRetention)
+	v.SetDefault("backup.prefix", DefaultBackupPrefix)
+	v.SetDefault("backup.zones", true)
+	v.SetDefault("backup.storage", BackupStorageBunny)
+	v.SetDefault("backup.bunny_storage.endpoint", DefaultBackupBunnyStorageEndpoint)
+	v.SetDefault("backup.s3.region", DefaultBackupS3Region)
+
+	// Self-update defaults
+	v.SetDefault("update.url", DefaultUpdateURL)
+	v.SetDefault("update.service", DefaultUpdateService)
+	v.SetDefault("update.timeout", DefaultUpdateTimeout)
+
+	// Balance guardrail defaults
+	v.SetDefault("balance.enabled", false)
+	v.SetDefault("balance.threshold", DefaultBalanceThreshold)
+	v.SetDefault("balance.pause_pull_zones", true)
+	v.SetDefault("balance.check_interval", DefaultBalanceCheckInterval)
+}
+
+// defaultedKeys returns the keys with a default that v read from neither a
+// config file nor an environment variable, sorted
+func defaultedKeys(v *viper.Viper) []string {
+	d := viper.New()
+	setDefaults(d)
+
+	var keys []string
+	for _, key := range d.AllKeys() {
+		if v.InConfig(key) {
+			continue
+		}
+		if _, ok := os.LookupEnv(strings.ToUpper(strings.ReplaceAll(key, ".", "_"))); ok {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
 }
 
 // substituteEnvVars replaces ${VAR} patterns with environment variable values
@@ -215,8 +2099,18 @@ func substituteEnvVars(cfg *Config) {
 	cfg.Bunny.BaseURL = envSubstitute(cfg.Bunny.BaseURL)
 	cfg.Origin.IP = envSubstitute(cfg.Origin.IP)
 	cfg.Webhook.Secret = envSubstitute(cfg.Webhook.Secret)
+	cfg.API.Token = envSubstitute(cfg.API.Token)
 	cfg.Telegram.BotToken = envSubstitute(cfg.Telegram.BotToken)
 	cfg.Telegram.ChatID = envSubstitute(cfg.Telegram.ChatID)
+	for category, route := range cfg.Telegram.Routes {
+		route.ChatID = envSubstitute(route.ChatID)
+		cfg.Telegram.Routes[category] = route
+	}
+	cfg.Notifications.Email.Password = envSubstitute(cfg.Notifications.Email.Password)
+	for name, profile := range cfg.Profiles.Definitions {
+		profile.Logging.Forwarding.Token = envSubstitute(profile.Logging.Forwarding.Token)
+		cfg.Profiles.Definitions[name] = profile
+	}
 }
 
 // envSubstitute replaces ${VAR} with the value of the environment variable VAR
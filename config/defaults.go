@@ -1,15 +1,72 @@
 package config
 
+import "time"
+
 const (
+	// ConfDir is the directory next to the config file whose *.yaml files
+	// are merged over it
+	ConfDir = "conf.d"
+
+	// EnvVar selects the environment overlay when --env is not given
+	EnvVar = "WHM2BUNNY_ENV"
+
 	// DefaultPort is the default HTTP server port
 	DefaultPort = 9090
 
 	// DefaultHost is the default HTTP server host
 	DefaultHost = "127.0.0.1"
 
+	// DefaultSocketMode lets the owner and group, such as the web server's,
+	// use the Unix socket
+	DefaultSocketMode = "0660"
+
+	// DefaultReadHeaderTimeout is how long clients may take to send the
+	// request headers
+	DefaultReadHeaderTimeout = 5 * time.Second
+
 	// DefaultBunnyBaseURL is the default Bunny.net API base URL
 	DefaultBunnyBaseURL = "https://api.bunny.net"
 
+	// DefaultChaosRate is the share of Bunny API calls failed in chaos mode
+	DefaultChaosRate = 0.1
+
+	// DefaultChaosDelay is how long an injected Bunny API timeout takes
+	DefaultChaosDelay = 5 * time.Second
+
+	// DefaultBunnyHealthInterval is how often the availability of the Bunny
+	// API endpoint families is checked
+	DefaultBunnyHealthInterval = time.Minute
+
+	// DefaultBunnyHealthWindow is how far back the availability of an
+	// endpoint family is computed
+	DefaultBunnyHealthWindow = 15 * time.Minute
+
+	// DefaultBunnyHealthAvailability is the percent of Bunny API calls of
+	// a family that must succeed, leaving an error budget of 5%
+	DefaultBunnyHealthAvailability = 95.0
+
+	// DefaultBunnyHealthMinRequests is how many calls an endpoint family
+	// needs in the window before it can be degraded
+	DefaultBunnyHealthMinRequests = 20
+
+	// DefaultWebhookDebounce is how long a repeated webhook event for the
+	// same domain is dropped
+	DefaultWebhookDebounce = 10 * time.Second
+
+	// DefaultWebhookMaxBodyBytes is the largest webhook body accepted;
+	// payloads are a few hundred bytes
+	DefaultWebhookMaxBodyBytes = 64 << 10
+
+	// DefaultWebhookBacklogThreshold is the number of waiting webhook
+	// events past which callers are told their queue position
+	DefaultWebhookBacklogThreshold = 10
+
+	// DefaultWHMUsername is the WHM user API tokens belong to
+	DefaultWHMUsername = "root"
+
+	// DefaultWHMTimeout is the HTTP timeout of WHM API calls
+	DefaultWHMTimeout = 30 * time.Second
+
 	// DefaultNameserver1 is the default primary nameserver
 	DefaultNameserver1 = "ns1.mordenhost.com"
 
@@ -22,31 +79,288 @@ const (
 	// DefaultOriginShieldRegion is the default CDN origin shield region
 	DefaultOriginShieldRegion = "SG"
 
+	// DefaultZoneNamePrefix starts the names of new pull and storage zones
+	DefaultZoneNamePrefix = "morden-"
+
+	// DefaultOriginConnectTimeout is how long (seconds) Bunny waits to connect to the origin
+	DefaultOriginConnectTimeout = 10
+
+	// DefaultOriginResponseTimeout is how long (seconds) Bunny waits for an origin response
+	DefaultOriginResponseTimeout = 60
+
+	// DefaultOriginRetries is how many times Bunny retries a failed origin request
+	DefaultOriginRetries = 1
+
+	// DefaultCertificateTimeout is how long provisioning waits for Bunny to
+	// issue the free certificate of a CDN hostname
+	DefaultCertificateTimeout = 2 * time.Minute
+
+	// DefaultVHostCheckTimeout bounds each request of the check that the
+	// origin serves a new domain rather than its default virtual host
+	DefaultVHostCheckTimeout = 15 * time.Second
+
+	// DefaultTelegramBackoff is the first delay before a notification
+	// Telegram did not accept is retried
+	DefaultTelegramBackoff = 30 * time.Second
+
+	// DefaultTelegramMaxBackoff is the longest delay between retries
+	DefaultTelegramMaxBackoff = 10 * time.Minute
+
+	// DefaultTelegramMaxAge is how long an undelivered notification is kept
+	DefaultTelegramMaxAge = 24 * time.Hour
+
+	// DefaultTelegramFallbackAfter is how long Telegram must be unreachable
+	// before the fallback channel is used
+	DefaultTelegramFallbackAfter = 15 * time.Minute
+
+	// DefaultPropagationTimeout is how long provisioning waits for public
+	// resolvers to return a new domain's records
+	DefaultPropagationTimeout = 10 * time.Minute
+
+	// DefaultPropagationInterval is how often propagation is checked again
+	DefaultPropagationInterval = 30 * time.Second
+
+	// DefaultLocale is the default language of messages and CLI output
+	DefaultLocale = "en"
+
 	// DefaultLogLevel is the default logging level
 	DefaultLogLevel = "info"
 
 	// DefaultLogFormat is the default log format (json or text)
 	DefaultLogFormat = "json"
+
+	// DefaultRunLogs is how many provisioning runs of each domain keep
+	// their log entries
+	DefaultRunLogs = 5
+
+	// DefaultRunLogEntries is how many log entries a provisioning run keeps
+	DefaultRunLogEntries = 500
+
+	// DefaultBalanceThreshold is the default minimum Bunny account balance
+	DefaultBalanceThreshold = 10.0
+
+	// DefaultBalanceCheckInterval is how often the Bunny balance is refreshed
+	DefaultBalanceCheckInterval = 15 * time.Minute
+
+	// DefaultOriginProtocol is the protocol pull zones use to reach the origin
+	DefaultOriginProtocol = "http"
+
+	// DefaultDriftInterval is how often pull zones are checked for drift
+	DefaultDriftInterval = 6 * time.Hour
+
+	// DefaultZoneMonitorInterval is how often managed DNS zones are checked
+	// for being disabled on Bunny
+	DefaultZoneMonitorInterval = 10 * time.Minute
+
+	// DefaultDiscoveryInterval is how often cPanel is checked for unprovisioned subdomains
+	DefaultDiscoveryInterval = 24 * time.Hour
+
+	// DefaultUserdataDomainsPath is the cPanel list of every domain on the server
+	DefaultUserdataDomainsPath = "/etc/userdatadomains"
+
+	// DefaultArchiveAfterDays is how long a successful provision stays in the active state file
+	DefaultArchiveAfterDays = 90
+
+	// DefaultArchiveInterval is how often the state file is compacted
+	DefaultArchiveInterval = 24 * time.Hour
+
+	// DefaultStateFlushInterval is how long state changes wait to be written
+	// to their records
+	DefaultStateFlushInterval = 250 * time.Millisecond
+
+	// DefaultStateFlushMutations is how many state changes are written
+	// together without waiting for the flush interval
+	DefaultStateFlushMutations = 100
+
+	// DefaultSnapshotRawDays is how long snapshots of part of a day are kept before being rolled up
+	DefaultSnapshotRawDays = 7
+
+	// DefaultSnapshotDailyDays is how long daily bandwidth snapshots are kept
+	DefaultSnapshotDailyDays = 90
+
+	// DefaultSnapshotInterval is how often bandwidth snapshots are compacted
+	DefaultSnapshotInterval = 6 * time.Hour
+
+	// DefaultStatusFilesDir is where per-domain provisioning status files are written
+	DefaultStatusFilesDir = "/var/cpanel/whm2bunny/status"
+
+	// DefaultStatusLinkTTL is how long a status page link is valid
+	DefaultStatusLinkTTL = 72 * time.Hour
+
+	// DefaultStatusLinkMaxTTL is the longest a status page link may be valid
+	DefaultStatusLinkMaxTTL = 30 * 24 * time.Hour
+
+	// MinStatusLinkSecretLength is the shortest secret status page links
+	// are signed with
+	MinStatusLinkSecretLength = 32
+
+	// DefaultCertificateCheckInterval is how often custom certificates are checked for expiry
+	DefaultCertificateCheckInterval = 12 * time.Hour
+
+	// DefaultHookTimeout is how long a hook may run
+	DefaultHookTimeout = 30 * time.Second
+
+	// DefaultSLOTarget is how long a provisioning run may take before it is reported as slow
+	DefaultSLOTarget = 2 * time.Minute
+
+	// DefaultSLODegradation is the week-over-week p95 increase, in percent, that is reported
+	DefaultSLODegradation = 25.0
+
+	// DefaultIncidentHitRate is the cache hit rate, in percent, below which a zone opens an incident
+	DefaultIncidentHitRate = 50.0
+
+	// DefaultIncidentOriginErrorRate is the origin 5xx share, in percent, that opens an incident
+	DefaultIncidentOriginErrorRate = 5.0
+
+	// DefaultIncidentMinRequests is how many requests a zone must serve before its rates are checked
+	DefaultIncidentMinRequests = 1000
+
+	// DefaultAnomalyThreshold is how many standard deviations from the
+	// usual an hour's requests must be to open an anomaly incident
+	DefaultAnomalyThreshold = 4.0
+
+	// DefaultAnomalyMinSamples is how many earlier days of an hour the
+	// anomaly baseline needs
+	DefaultAnomalyMinSamples = 5
+
+	// DefaultAnomalyMinRequests is the usual requests an hour below which a
+	// zone is not checked for anomalies
+	DefaultAnomalyMinRequests = 100
+
+	// DefaultBackupSchedule is when backups are made
+	DefaultBackupSchedule = "0 3 * * *"
+
+	// DefaultBackupRetention is how many backups are kept
+	DefaultBackupRetention = 7
+
+	// DefaultBackupPrefix is the directory backups are stored in
+	DefaultBackupPrefix = "whm2bunny"
+
+	// DefaultUpdateURL holds the archives and checksums of the latest release
+	DefaultUpdateURL = "https://github.com/mordenhost/whm2bunny/releases/latest/download"
+
+	// DefaultUpdateService is the systemd unit install.sh creates
+	DefaultUpdateService = "whm2bunny"
+
+	// DefaultUpdateTimeout bounds the download of a release
+	DefaultUpdateTimeout = 5 * time.Minute
+
+	// DefaultBackupBunnyStorageEndpoint is the storage API of Bunny's main region
+	DefaultBackupBunnyStorageEndpoint = "https://storage.bunnycdn.com"
+
+	// DefaultBackupS3Region is the region S3 requests are signed for
+	DefaultBackupS3Region = "us-east-1"
+
+	// DefaultEmailPort is the SMTP submission port; STARTTLS is used when
+	// the server offers it
+	DefaultEmailPort = 587
+
+	// DefaultEmailSchedule is when the daily email summary is sent
+	DefaultEmailSchedule = "0 8 * * *"
+
+	// DefaultEmailWeeklySchedule is when the weekly email summary is sent
+	DefaultEmailWeeklySchedule = "0 8 * * 1"
+
+	// DefaultEmailTopZones is how many zones the email summaries list
+	DefaultEmailTopZones = 10
+
+	// DefaultSummaryConcurrency is how many zones a summary job fetches at once
+	DefaultSummaryConcurrency = 8
+
+	// DefaultSummaryRequestsPerSecond caps the Bunny API calls of a summary job
+	DefaultSummaryRequestsPerSecond = 10.0
+
+	// DefaultSummaryTimeout bounds a whole summary job
+	DefaultSummaryTimeout = 10 * time.Minute
+
+	// DefaultIdleDays is the window over which domains without traffic are
+	// listed as idle
+	DefaultIdleDays = 30
+
+	// DefaultIdleMaxRequests is the most requests an idle domain may serve
+	// over the window; bots and uptime checks rarely leave a zone at zero
+	DefaultIdleMaxRequests = 100
 )
 
+// DefaultCertificateReminderDays are the days before a custom certificate
+// expires that a reminder is sent
+var DefaultCertificateReminderDays = []int{30, 14, 7, 1}
+
+// DefaultCertificateAlertDays are the days before a Bunny-managed certificate
+// expires that an alert is sent; Bunny renews well before the first one, so
+// reaching it means renewal is failing
+var DefaultCertificateAlertDays = []int{30, 14, 3}
+
+// DefaultServiceRecordNames are the hostnames a cPanel account expects next
+// to the domain itself
+var DefaultServiceRecordNames = []string{"mail", "webmail", "cpanel", "whm", "webdisk", "autodiscover", "autoconfig"}
+
 // Defaults returns a Config struct with all default values set
 func Defaults() Config {
 	return Config{
 		Server: ServerConfig{
-			Port: DefaultPort,
-			Host: DefaultHost,
+			Port:              DefaultPort,
+			Host:              DefaultHost,
+			SocketMode:        DefaultSocketMode,
+			ReadHeaderTimeout: DefaultReadHeaderTimeout,
 		},
 		Bunny: BunnyConfig{
 			BaseURL: DefaultBunnyBaseURL,
+			Chaos: ChaosConfig{
+				Rate:   DefaultChaosRate,
+				Faults: []string{"429", "500", "timeout"},
+				Delay:  DefaultChaosDelay,
+			},
+			Health: BunnyHealthConfig{
+				Enabled:      true,
+				Interval:     DefaultBunnyHealthInterval,
+				Window:       DefaultBunnyHealthWindow,
+				Availability: DefaultBunnyHealthAvailability,
+				MinRequests:  DefaultBunnyHealthMinRequests,
+			},
+		},
+		Webhook: WebhookConfig{
+			Debounce:         DefaultWebhookDebounce,
+			MaxBodyBytes:     DefaultWebhookMaxBodyBytes,
+			BacklogThreshold: DefaultWebhookBacklogThreshold,
+		},
+		Provisioning: ProvisioningConfig{Enabled: true},
+		WHM: WHMConfig{
+			Username: DefaultWHMUsername,
+			Timeout:  DefaultWHMTimeout,
 		},
 		DNS: DNSConfig{
-			Nameserver1: DefaultNameserver1,
-			Nameserver2: DefaultNameserver2,
-			SOAEmail:    DefaultSOAEmail,
+			Nameserver1:    DefaultNameserver1,
+			Nameserver2:    DefaultNameserver2,
+			SOAEmail:       DefaultSOAEmail,
+			RecordStrategy: RecordStrategyCDNSubdomainOnly,
+			ServiceRecords: ServiceRecordsConfig{
+				Names: DefaultServiceRecordNames,
+			},
+			Propagation: PropagationConfig{
+				Timeout:  DefaultPropagationTimeout,
+				Interval: DefaultPropagationInterval,
+			},
 		},
 		CDN: CDNConfig{
 			OriginShieldRegion: DefaultOriginShieldRegion,
 			Regions:            []string{"asia"},
+			OriginResilience: OriginResilienceConfig{
+				StaleWhileOffline:  true,
+				StaleWhileUpdating: true,
+				ConnectTimeout:     DefaultOriginConnectTimeout,
+				ResponseTimeout:    DefaultOriginResponseTimeout,
+				Retries:            DefaultOriginRetries,
+			},
+			HTTPS: HTTPSConfig{
+				Enabled:  true,
+				ForceSSL: true,
+				Timeout:  DefaultCertificateTimeout,
+			},
+			VHostCheck: VHostCheckConfig{
+				Timeout: DefaultVHostCheckTimeout,
+			},
+			ZoneNamePrefix: DefaultZoneNamePrefix,
 		},
 		Telegram: TelegramConfig{
 			Enabled: false,
@@ -57,6 +371,17 @@ func Defaults() Config {
 				"bandwidth_alert",
 				"deprovisioned",
 				"subdomain_provisioned",
+				"maintenance",
+				"balance",
+				"token_rotated",
+				"drift",
+				"emergency",
+				"certificate_expiry",
+				"slow_provision",
+				"slo_degraded",
+				"package_changed",
+				"zone_disabled",
+				"observed",
 			},
 			Summary: TelegramSummaryConfig{
 				Enabled:                 true,
@@ -65,11 +390,116 @@ func Defaults() Config {
 				Timezone:                "Asia/Jakarta",
 				IncludeTopBandwidth:     20,
 				BandwidthAlertThreshold: 50,
+				Concurrency:             DefaultSummaryConcurrency,
+				RequestsPerSecond:       DefaultSummaryRequestsPerSecond,
+				Timeout:                 DefaultSummaryTimeout,
+				IdleDays:                DefaultIdleDays,
+				IdleMaxRequests:         DefaultIdleMaxRequests,
+			},
+			Delivery: TelegramDeliveryConfig{
+				Backoff:       DefaultTelegramBackoff,
+				MaxBackoff:    DefaultTelegramMaxBackoff,
+				MaxAge:        DefaultTelegramMaxAge,
+				FallbackAfter: DefaultTelegramFallbackAfter,
 			},
 		},
+		Origin: OriginConfig{
+			Protocol:  DefaultOriginProtocol,
+			VerifySSL: true,
+		},
+		Maintenance: MaintenanceConfig{
+			Timezone: "Asia/Jakarta",
+		},
+		Balance: BalanceConfig{
+			Threshold:      DefaultBalanceThreshold,
+			PausePullZones: true,
+			CheckInterval:  DefaultBalanceCheckInterval,
+		},
+		API: APIConfig{
+			Enabled: true,
+		},
+		Drift: DriftConfig{
+			Interval: DefaultDriftInterval,
+			Fix:      true,
+		},
+		ZoneMonitor: ZoneMonitorConfig{
+			Enabled:  true,
+			Interval: DefaultZoneMonitorInterval,
+		},
+		Discovery: DiscoveryConfig{
+			Interval:        DefaultDiscoveryInterval,
+			UserdataDomains: DefaultUserdataDomainsPath,
+		},
+		Archive: ArchiveConfig{
+			Enabled:   true,
+			AfterDays: DefaultArchiveAfterDays,
+			Interval:  DefaultArchiveInterval,
+		},
+		State: StateConfig{
+			FlushInterval:  DefaultStateFlushInterval,
+			FlushMutations: DefaultStateFlushMutations,
+		},
+		Snapshots: SnapshotsConfig{
+			RawDays:   DefaultSnapshotRawDays,
+			DailyDays: DefaultSnapshotDailyDays,
+			Interval:  DefaultSnapshotInterval,
+		},
+		StatusFiles: StatusFilesConfig{
+			Dir: DefaultStatusFilesDir,
+		},
+		StatusLinks: StatusLinksConfig{
+			TTL:    DefaultStatusLinkTTL,
+			MaxTTL: DefaultStatusLinkMaxTTL,
+		},
+		Certificates: CertificatesConfig{
+			ReminderDays: DefaultCertificateReminderDays,
+			Interval:     DefaultCertificateCheckInterval,
+			Monitor:      true,
+			AlertDays:    DefaultCertificateAlertDays,
+		},
+		Notifications: NotificationsConfig{
+			Email: EmailConfig{
+				Port:           DefaultEmailPort,
+				Schedule:       DefaultEmailSchedule,
+				WeeklySchedule: DefaultEmailWeeklySchedule,
+				TopZones:       DefaultEmailTopZones,
+			},
+		},
+		SLO: SLOConfig{
+			Target:      DefaultSLOTarget,
+			Degradation: DefaultSLODegradation,
+		},
+		Incidents: IncidentsConfig{
+			HitRateThreshold: DefaultIncidentHitRate,
+			OriginErrorRate:  DefaultIncidentOriginErrorRate,
+			MinRequests:      DefaultIncidentMinRequests,
+			Anomaly: AnomalyConfig{
+				Enabled:     true,
+				Threshold:   DefaultAnomalyThreshold,
+				MinSamples:  DefaultAnomalyMinSamples,
+				MinRequests: DefaultAnomalyMinRequests,
+			},
+		},
+		Backup: BackupConfig{
+			Schedule:     DefaultBackupSchedule,
+			Retention:    DefaultBackupRetention,
+			Prefix:       DefaultBackupPrefix,
+			Zones:        true,
+			Storage:      BackupStorageBunny,
+			BunnyStorage: BackupBunnyStorageConfig{Endpoint: DefaultBackupBunnyStorageEndpoint},
+			S3:           BackupS3Config{Region: DefaultBackupS3Region},
+		},
+		Update: UpdateConfig{
+			URL:     DefaultUpdateURL,
+			Service: DefaultUpdateService,
+			Timeout: DefaultUpdateTimeout,
+		},
 		Logging: LoggingConfig{
-			Level:  DefaultLogLevel,
-			Format: DefaultLogFormat,
+			Level:         DefaultLogLevel,
+			Format:        DefaultLogFormat,
+			RunLogs:       DefaultRunLogs,
+			RunLogEntries: DefaultRunLogEntries,
 		},
+		Locale: DefaultLocale,
 	}
 }
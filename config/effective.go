@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces set secrets in the effective configuration output
+const Redacted = "<redacted>"
+
+// secrets returns the configuration values that must not be shown
+func (c *Config) secrets() []*string {
+	return []*string{
+		&c.Bunny.APIKey,
+		&c.WHM.Token,
+		&c.Webhook.Secret,
+		&c.API.Token,
+		&c.Telegram.BotToken,
+		&c.Notifications.Email.Password,
+		&c.Backup.BunnyStorage.Password,
+		&c.Backup.S3.SecretAccessKey,
+		&c.StatusLinks.Secret,
+		&c.Encryption.Key,
+	}
+}
+
+// Summary describes the effective configuration of a running daemon
+type Summary struct {
+	// Sources are the config files merged, in order
+	Sources []string `json:"sources"`
+	// Features reports which optional features are enabled
+	Features map[string]bool `json:"features"`
+	// Defaults are the keys no config file or environment variable set
+	Defaults []string `json:"defaults"`
+	// Config is the effective configuration with secrets redacted
+	Config map[string]any `json:"config"`
+}
+
+// Sources returns the config files the configuration was merged from, in
+// order
+func (c *Config) Sources() []string {
+	return c.sources
+}
+
+// Defaulted returns the keys left at their default value, sorted
+func (c *Config) Defaulted() []string {
+	return c.defaulted
+}
+
+// Features reports which optional features the configuration enables
+func (c *Config) Features() map[string]bool {
+	return map[string]bool{
+		"telegram":         c.Telegram.Enabled,
+		"telegram_summary": c.Telegram.Enabled && c.Telegram.Summary.Enabled,
+		"email_summary":    c.Notifications.Email.Enabled,
+		"api":              c.API.Enabled,
+		"whm_api":          c.WHM.URL != "",
+		"proxy":            c.Proxy.Enabled(),
+		"https":            c.CDN.HTTPS.Enabled,
+		"dns_propagation":  c.DNS.Propagation.Enabled,
+		"vhost_check":      c.CDN.VHostCheck.Enabled,
+		"drift":            c.Drift.Enabled,
+		"zone_monitor":     c.ZoneMonitor.Enabled,
+		"discovery":        c.Discovery.Enabled,
+		"archive":          c.Archive.Enabled,
+		"state_batching":   c.State.Batching,
+		"run_logs":         c.Logging.RunLogs > 0,
+		"status_files":     c.StatusFiles.Enabled,
+		"status_links":     c.StatusLinks.Enabled,
+		"quota":            c.Quota.Enabled,
+		"balance":          c.Balance.Enabled,
+		"hooks":            len(c.Hooks) > 0,
+		"chaos":            c.Bunny.Chaos.Enabled,
+		"bunny_health":     c.Bunny.Health.Enabled,
+		"backup":           c.Backup.Enabled,
+	}
+}
+
+// Summary returns the effective configuration, its sources, the enabled
+// features and the defaults that were applied
+func (c *Config) Summary() (Summary, error) {
+	var values map[string]any
+	if err := c.redactedNode().Decode(&values); err != nil {
+		return Summary{}, fmt.Errorf("failed to convert config: %w", err)
+	}
+	return Summary{
+		Sources:  c.Sources(),
+		Features: c.Features(),
+		Defaults: c.Defaulted(),
+		Config:   values,
+	}, nil
+}
+
+// EffectiveYAML returns the configuration as YAML with the keys of the
+// config file, so the merged result of all layers can be reviewed or saved
+// as a single file. Secrets are redacted
+func (c *Config) EffectiveYAML() ([]byte, error) {
+	data, err := yaml.Marshal(c.redactedNode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return data, nil
+}
+
+// redactedNode returns the configuration as a YAML node with set secrets
+// replaced by Redacted
+func (c *Config) redactedNode() *yaml.Node {
+	redacted := *c
+	for _, secret := range redacted.secrets() {
+		if *secret != "" {
+			*secret = Redacted
+		}
+	}
+	redacted.Proxy = redacted.Proxy.redacted()
+	// The profiles map is shared with c, so it is copied before redacting
+	profiles := make(map[string]ProfileConfig, len(c.Profiles.Definitions))
+	for name, profile := range c.Profiles.Definitions {
+		if profile.Logging.Forwarding.Token != "" {
+			profile.Logging.Forwarding.Token = Redacted
+		}
+		profiles[name] = profile
+	}
+	redacted.Profiles.Definitions = profiles
+	return yamlNode(reflect.ValueOf(redacted))
+}
+
+// yamlNode converts a configuration value to a YAML node, naming struct
+// fields by their mapstructure key and keeping their declaration order
+func yamlNode(v reflect.Value) *yaml.Node {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return scalarNode(time.Duration(v.Int()).String())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		return yamlNode(v.Elem())
+
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			node.Content = append(node.Content, scalarNode(key), yamlNode(v.Field(i)))
+		}
+		return node
+
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			node.Content = append(node.Content, scalarNode(fmt.Sprint(k.Interface())), yamlNode(v.MapIndex(k)))
+		}
+		return node
+
+	case reflect.Slice, reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			node.Content = append(node.Content, yamlNode(v.Index(i)))
+		}
+		return node
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(v.Interface()); err != nil {
+		return scalarNode(fmt.Sprint(v.Interface()))
+	}
+	return node
+}
+
+// scalarNode returns a YAML string node
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
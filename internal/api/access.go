@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// AccessRulesRequest replaces a domain's blocked countries and IPs
+type AccessRulesRequest struct {
+	BlockedCountries []string `json:"blocked_countries"`
+	BlockedIPs       []string `json:"blocked_ips"`
+}
+
+// AccessRulesResponse shows a domain's access rules
+type AccessRulesResponse struct {
+	Domain string `json:"domain"`
+	bunny.AccessRules
+	Applied bool `json:"applied"`
+}
+
+// getAccessRules handles GET /domains/{domain}/access-rules
+func (h *Handler) getAccessRules(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	writeJSON(w, http.StatusOK, AccessRulesResponse{
+		Domain:      d,
+		AccessRules: h.provisioner.AccessRules(d),
+	})
+}
+
+// putAccessRules handles PUT /domains/{domain}/access-rules
+func (h *Handler) putAccessRules(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var req AccessRulesRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid request body",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	rules, err := provisioner.NormalizeAccessRules(bunny.AccessRules{
+		BlockedCountries: req.BlockedCountries,
+		BlockedIPs:       req.BlockedIPs,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid access rules",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	rules, err = h.provisioner.UpdateAccessRules(r.Context(), d, rules)
+	applied := err == nil
+	if err != nil && !errors.Is(err, provisioner.ErrNotProvisioned) {
+		h.logger.Error("failed to update access rules", zap.String("domain", d), zap.Error(err))
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "failed to update access rules",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	h.logger.Info("access rules updated via API",
+		zap.String("domain", d),
+		zap.Int("blocked_countries", len(rules.BlockedCountries)),
+		zap.Int("blocked_ips", len(rules.BlockedIPs)),
+		zap.Bool("applied", applied),
+	)
+	h.record(r, "access_rules.update", d, rules)
+
+	writeJSON(w, http.StatusOK, AccessRulesResponse{
+		Domain:      d,
+		AccessRules: rules,
+		Applied:     applied,
+	})
+}
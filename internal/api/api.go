@@ -0,0 +1,298 @@
+// Package api implements the authenticated management API used by control
+// panels and scripts to change per-domain CDN settings
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/apitoken"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/balance"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/flags"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/pause"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/runlog"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/statuspage"
+	"github.com/mordenhost/whm2bunny/internal/validator"
+)
+
+// actorHeader optionally names the person or system making an API change
+const actorHeader = "X-Whm2bunny-Actor"
+
+// Provisioner defines the per-domain operations exposed by the API
+type Provisioner interface {
+	DomainOverride(domain string) overrides.Override
+	ReferrerSettings(domain string) bunny.ReferrerSettings
+	UpdateReferrers(ctx context.Context, domain string, fn func(o *overrides.Override)) (bunny.ReferrerSettings, error)
+	AccessRules(domain string) bunny.AccessRules
+	UpdateAccessRules(ctx context.Context, domain string, rules bunny.AccessRules) (bunny.AccessRules, error)
+	Certificates(domain string) []certs.Entry
+	UploadCertificate(ctx context.Context, domain, hostname string, certPEM, keyPEM []byte) (certs.Entry, error)
+	DomainState(domain string) (*state.ProvisionState, error)
+	UserDomains(user string) ([]*state.ProvisionState, error)
+	DNSRecords(ctx context.Context, domain string) ([]provisioner.ManagedRecord, error)
+	SetDNSRecordEnabled(ctx context.Context, domain string, recordID int64, enabled bool, reason string) (provisioner.ManagedRecord, error)
+	DisabledZones() []provisioner.DisabledZone
+}
+
+// Auditor records changes made through the API
+type Auditor interface {
+	Record(entry audit.Entry) error
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// Handler serves the management API
+type Handler struct {
+	provisioner Provisioner
+	token       string
+	tokens      *apitoken.Store
+	validator   *validator.Validator
+	audit       Auditor
+	service     Service
+	incidents   *incident.Store
+	runLogs     *runlog.Store
+	config      *config.Config
+	flags       *flags.Set
+	statusLinks *config.StatusLinksConfig
+	signer      *statuspage.Signer
+	pause       *pause.Store
+	balance     *balance.Guard
+	resumeQueue func()
+	queued      func() int
+	server      string
+	logger      *zap.Logger
+}
+
+// NewHandler creates a management API handler that accepts requests
+// carrying token, with the admin role, as a Bearer token
+func NewHandler(provisioner Provisioner, token string, logger *zap.Logger) *Handler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Handler{
+		provisioner: provisioner,
+		token:       token,
+		validator:   validator.NewValidatorWithConfig(&validator.ValidatorConfig{}, logger),
+		logger:      logger,
+	}
+}
+
+// SetTokens accepts the named tokens of store, with their roles
+func (h *Handler) SetTokens(store *apitoken.Store) {
+	h.tokens = store
+}
+
+// SetAudit enables audit logging of changes
+func (h *Handler) SetAudit(a Auditor) {
+	h.audit = a
+}
+
+// Routes returns the API router, to be mounted under /api/v1
+func (h *Handler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/openapi.json", h.getOpenAPI)
+
+	r.Group(func(r chi.Router) {
+		r.Use(h.authenticate)
+
+		// Each endpoint requires a role; reads need read-only, operations
+		// on a domain operator and changes exposing secrets admin
+		read, operate, admin := h.require(apitoken.RoleReadOnly), h.require(apitoken.RoleOperator), h.require(apitoken.RoleAdmin)
+
+		r.Route("/domains/{domain}", func(r chi.Router) {
+			r.Use(h.domainParam)
+			r.With(read).Get("/", h.getDomain)
+			if h.service != nil {
+				r.With(read).Get("/status", h.getDomainStatus)
+				r.With(read).Get("/report", h.getDomainReport)
+				r.With(operate).Post("/retry", h.retryDomain)
+				r.With(operate).Post("/purge", h.purgeDomain)
+			}
+			r.With(read).Get("/referrers", h.getReferrers)
+			r.With(operate).Put("/referrers", h.putReferrers)
+			r.With(read).Get("/access-rules", h.getAccessRules)
+			r.With(operate).Put("/access-rules", h.putAccessRules)
+			r.With(read).Get("/certificates", h.getCertificates)
+			r.With(admin).Put("/certificates", h.putCertificate)
+			if h.runLogs != nil {
+				r.With(read).Get("/logs", h.getDomainLogs)
+			}
+			r.With(read).Get("/dns-records", h.getDNSRecords)
+			r.With(operate).Post("/dns-records/{id}/disable", h.disableDNSRecord)
+			r.With(operate).Post("/dns-records/{id}/enable", h.enableDNSRecord)
+			if h.statusLinks != nil {
+				r.With(operate).Post("/status-link", h.createStatusLink)
+			}
+		})
+		r.With(read).Get("/users/{user}/domains", h.getUserDomains)
+		r.With(read).Get("/dns-zones/disabled", h.getDisabledZones)
+		if h.incidents != nil {
+			r.With(read).Get("/incidents", h.getIncidents)
+			r.With(operate).Post("/incidents/{id}/ack", h.ackIncident)
+		}
+		if h.config != nil {
+			r.With(admin).Get("/config", h.getConfig)
+		}
+		if h.flags != nil {
+			r.With(read).Get("/admin/flags", h.getFlags)
+		}
+		if h.pause != nil {
+			r.With(read).Get("/admin/pause", h.getPause)
+			r.With(admin).Post("/admin/pause", h.pausePipeline)
+			r.With(admin).Post("/admin/resume", h.resumePipeline)
+		}
+		if h.balance != nil {
+			r.With(read).Get("/admin/balance", h.getBalance)
+		}
+	})
+
+	return r
+}
+
+// legacyTokenName names the api.token in the audit log
+const legacyTokenName = "api"
+
+// tokenKey is the context key of the token a request authenticated with
+type tokenKey struct{}
+
+// authenticate rejects requests without a valid Bearer token: api.token,
+// which has the admin role, or a named token
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var token apitoken.Token
+		switch {
+		case !ok || secret == "":
+			ok = false
+		case h.token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(h.token)) == 1:
+			token = apitoken.Token{Name: legacyTokenName, Role: apitoken.RoleAdmin}
+		case h.tokens != nil:
+			token, ok = h.tokens.Authenticate(secret)
+		default:
+			ok = false
+		}
+		if !ok {
+			h.logger.Warn("unauthorized API request",
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, token)))
+	})
+}
+
+// require rejects requests whose token does not have role
+func (h *Handler) require(role apitoken.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := requestToken(r)
+			if !token.Role.Allows(role) {
+				h.logger.Warn("forbidden API request",
+					zap.String("path", r.URL.Path),
+					zap.String("token", token.Name),
+					zap.String("role", string(token.Role)),
+					zap.String("required", string(role)),
+				)
+				writeJSON(w, http.StatusForbidden, ErrorResponse{
+					Error:   "forbidden",
+					Details: fmt.Sprintf("requires the %s role, token %s has %s", role, token.Name, token.Role),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestToken returns the token r authenticated with
+func requestToken(r *http.Request) apitoken.Token {
+	token, _ := r.Context().Value(tokenKey{}).(apitoken.Token)
+	return token
+}
+
+// domainParam validates the {domain} URL parameter
+func (h *Handler) domainParam(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.validator.ValidateDomain(chi.URLParam(r, "domain")); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid domain",
+				Details: err.Error(),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// record writes an audit entry for a change made by r
+// Audit failures are logged but do not fail the request
+func (h *Handler) record(r *http.Request, action, domain string, details interface{}) {
+	if h.audit == nil {
+		return
+	}
+
+	token := requestToken(r).Name
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		actor = token
+	}
+
+	err := h.audit.Record(audit.Entry{
+		Actor:      actor,
+		Token:      token,
+		RemoteAddr: r.RemoteAddr,
+		Action:     action,
+		Domain:     domain,
+		Details:    details,
+	})
+	if err != nil {
+		h.logger.Error("failed to write audit entry",
+			zap.String("action", action),
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// domain returns the normalized {domain} URL parameter
+func domain(r *http.Request) string {
+	return domainname.Normalize(chi.URLParam(r, "domain"))
+}
+
+// decodeJSON decodes a JSON request body, rejecting unknown fields
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,966 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/apitoken"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/balance"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/flags"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/pause"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/runlog"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/statuspage"
+)
+
+const testToken = "test-api-token"
+
+// mockProvisioner keeps overrides in memory
+type mockProvisioner struct {
+	overrides   map[string]overrides.Override
+	certs       map[string][]certs.Entry
+	states      []*state.ProvisionState
+	records     map[string][]provisioner.ManagedRecord
+	zones       []provisioner.DisabledZone
+	provisioned bool
+	updateErr   error
+}
+
+func newMockProvisioner() *mockProvisioner {
+	return &mockProvisioner{
+		overrides:   make(map[string]overrides.Override),
+		certs:       make(map[string][]certs.Entry),
+		records:     make(map[string][]provisioner.ManagedRecord),
+		provisioned: true,
+	}
+}
+
+func (m *mockProvisioner) DomainOverride(domain string) overrides.Override {
+	return m.overrides[domain]
+}
+
+func (m *mockProvisioner) ReferrerSettings(domain string) bunny.ReferrerSettings {
+	o := m.overrides[domain]
+	return bunny.ReferrerSettings{Allowed: o.AllowedReferrers, Blocked: o.BlockedReferrers}
+}
+
+func (m *mockProvisioner) UpdateReferrers(ctx context.Context, domain string, fn func(o *overrides.Override)) (bunny.ReferrerSettings, error) {
+	if m.updateErr != nil {
+		return bunny.ReferrerSettings{}, m.updateErr
+	}
+	o := m.overrides[domain]
+	fn(&o)
+	m.overrides[domain] = o
+	if !m.provisioned {
+		return m.ReferrerSettings(domain), fmt.Errorf("%s: %w", domain, provisioner.ErrNotProvisioned)
+	}
+	return m.ReferrerSettings(domain), nil
+}
+
+func (m *mockProvisioner) AccessRules(domain string) bunny.AccessRules {
+	o := m.overrides[domain]
+	return bunny.AccessRules{BlockedCountries: o.BlockedCountries, BlockedIPs: o.BlockedIPs}
+}
+
+func (m *mockProvisioner) UpdateAccessRules(ctx context.Context, domain string, rules bunny.AccessRules) (bunny.AccessRules, error) {
+	if m.updateErr != nil {
+		return bunny.AccessRules{}, m.updateErr
+	}
+	o := m.overrides[domain]
+	o.BlockedCountries = rules.BlockedCountries
+	o.BlockedIPs = rules.BlockedIPs
+	m.overrides[domain] = o
+	if !m.provisioned {
+		return rules, fmt.Errorf("%s: %w", domain, provisioner.ErrNotProvisioned)
+	}
+	return rules, nil
+}
+
+func (m *mockProvisioner) Certificates(domain string) []certs.Entry {
+	return m.certs[domain]
+}
+
+func (m *mockProvisioner) UploadCertificate(ctx context.Context, domain, hostname string, certPEM, keyPEM []byte) (certs.Entry, error) {
+	if m.updateErr != nil {
+		return certs.Entry{}, m.updateErr
+	}
+	if string(certPEM) != "valid-cert" {
+		return certs.Entry{}, fmt.Errorf("%w: not a certificate", certs.ErrInvalid)
+	}
+	if !m.provisioned {
+		return certs.Entry{}, fmt.Errorf("%s: %w", domain, provisioner.ErrNotProvisioned)
+	}
+	if hostname == "" {
+		hostname = domain
+	}
+	entry := certs.Entry{Domain: domain, Hostname: hostname, Fingerprint: "abc"}
+	m.certs[domain] = append(m.certs[domain], entry)
+	return entry, nil
+}
+
+func (m *mockProvisioner) DomainState(domain string) (*state.ProvisionState, error) {
+	for _, st := range m.states {
+		if st.Domain == domain {
+			return st, nil
+		}
+	}
+	return nil, state.ErrStateNotFound
+}
+
+func (m *mockProvisioner) UserDomains(user string) ([]*state.ProvisionState, error) {
+	var result []*state.ProvisionState
+	for _, st := range m.states {
+		if st.User == user {
+			result = append(result, st)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockProvisioner) DNSRecords(ctx context.Context, domain string) ([]provisioner.ManagedRecord, error) {
+	records, ok := m.records[domain]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", domain, provisioner.ErrNotProvisioned)
+	}
+	return records, nil
+}
+
+func (m *mockProvisioner) SetDNSRecordEnabled(ctx context.Context, domain string, recordID int64, enabled bool, reason string) (provisioner.ManagedRecord, error) {
+	if m.updateErr != nil {
+		return provisioner.ManagedRecord{}, m.updateErr
+	}
+	for i, r := range m.records[domain] {
+		if r.ID != recordID {
+			continue
+		}
+		r.Enabled = enabled
+		r.Disabled = nil
+		if !enabled {
+			r.Disabled = &dnsrecords.Disabled{ID: r.ID, Type: r.Type, Name: r.Name, Value: r.Value, Reason: reason}
+		}
+		m.records[domain][i] = r
+		return r, nil
+	}
+	return provisioner.ManagedRecord{}, fmt.Errorf("%s record %d: %w", domain, recordID, provisioner.ErrRecordNotFound)
+}
+
+func (m *mockProvisioner) DisabledZones() []provisioner.DisabledZone {
+	return append([]provisioner.DisabledZone{}, m.zones...)
+}
+
+// recordingAuditor keeps audit entries in memory
+type recordingAuditor struct {
+	entries []audit.Entry
+}
+
+func (a *recordingAuditor) Record(entry audit.Entry) error {
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+func doRequest(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+
+	t.Run("missing token", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/referrers", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/referrers", "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/referrers", testToken, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("empty configured token rejects everything", func(t *testing.T) {
+		open := NewHandler(newMockProvisioner(), "", zap.NewNop()).Routes()
+		w := doRequest(open, http.MethodGet, "/domains/example.com/referrers", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	tokens, err := apitoken.NewStore(filepath.Join(t.TempDir(), "api_tokens.json"), []apitoken.Token{
+		{Name: "grafana", Role: apitoken.RoleReadOnly, Hash: apitoken.Hash("read-secret")},
+		{Name: "panel", Role: apitoken.RoleOperator, Hash: apitoken.Hash("operator-secret")},
+	}, nil)
+	require.NoError(t, err)
+
+	auditor := &recordingAuditor{}
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetTokens(tokens)
+	h.SetAudit(auditor)
+	h.SetConfig(&config.Config{})
+	routes := h.Routes()
+
+	tests := []struct {
+		token, method, path, body string
+		want                      int
+	}{
+		{"read-secret", http.MethodGet, "/domains/example.com/referrers", "", http.StatusOK},
+		{"read-secret", http.MethodPut, "/domains/example.com/referrers", `{}`, http.StatusForbidden},
+		{"operator-secret", http.MethodPut, "/domains/example.com/referrers", `{}`, http.StatusOK},
+		{"operator-secret", http.MethodPut, "/domains/example.com/certificates", `{"certificate":"valid-cert","private_key":"key"}`, http.StatusForbidden},
+		{"operator-secret", http.MethodGet, "/config", "", http.StatusForbidden},
+		{testToken, http.MethodGet, "/config", "", http.StatusOK},
+		{"unknown", http.MethodGet, "/domains/example.com/referrers", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := doRequest(routes, tt.method, tt.path, tt.token, tt.body)
+		assert.Equal(t, tt.want, w.Code, "%s %s with %s", tt.method, tt.path, tt.token)
+	}
+
+	// Changes are attributed to the token
+	require.Len(t, auditor.entries, 1)
+	assert.Equal(t, "panel", auditor.entries[0].Actor)
+	assert.Equal(t, "panel", auditor.entries[0].Token)
+}
+
+func TestReferrers(t *testing.T) {
+	t.Run("invalid domain", func(t *testing.T) {
+		routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodGet, "/domains/not_a_domain/referrers", testToken, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("put replaces extra referrers", func(t *testing.T) {
+		prov := newMockProvisioner()
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+		body := `{"allowed_referrers":["partner.com"],"blocked_referrers":["bad.example"],"hotlink_protection":true}`
+		w := doRequest(routes, http.MethodPut, "/domains/Example.com/referrers", testToken, body)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp ReferrersResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "example.com", resp.Domain)
+		assert.Equal(t, []string{"partner.com"}, resp.AllowedReferrers)
+		assert.Equal(t, []string{"bad.example"}, resp.BlockedReferrers)
+		require.NotNil(t, resp.HotlinkProtection)
+		assert.True(t, *resp.HotlinkProtection)
+		assert.True(t, resp.Applied)
+
+		o := prov.overrides["example.com"]
+		assert.Equal(t, []string{"partner.com"}, o.AllowedReferrers)
+	})
+
+	t.Run("not provisioned is saved but not applied", func(t *testing.T) {
+		prov := newMockProvisioner()
+		prov.provisioned = false
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/referrers", testToken, `{"allowed_referrers":["partner.com"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp ReferrersResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.False(t, resp.Applied)
+		assert.Nil(t, resp.HotlinkProtection)
+	})
+
+	t.Run("bunny failure", func(t *testing.T) {
+		prov := newMockProvisioner()
+		prov.updateErr = fmt.Errorf("bunny unavailable")
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/referrers", testToken, `{}`)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/referrers", testToken, `{"allow":["x.com"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestAccessRules(t *testing.T) {
+	t.Run("put normalizes and audits", func(t *testing.T) {
+		prov := newMockProvisioner()
+		auditor := &recordingAuditor{}
+		h := NewHandler(prov, testToken, zap.NewNop())
+		h.SetAudit(auditor)
+		routes := h.Routes()
+
+		req := httptest.NewRequest(http.MethodPut, "/domains/example.com/access-rules",
+			strings.NewReader(`{"blocked_countries":["cn","RU","cn"],"blocked_ips":["203.0.113.7","198.51.100.0/24"]}`))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set(actorHeader, "abuse-team")
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp AccessRulesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, []string{"CN", "RU"}, resp.BlockedCountries)
+		assert.Equal(t, []string{"198.51.100.0/24", "203.0.113.7"}, resp.BlockedIPs)
+		assert.True(t, resp.Applied)
+
+		require.Len(t, auditor.entries, 1)
+		assert.Equal(t, "abuse-team", auditor.entries[0].Actor)
+		assert.Equal(t, "access_rules.update", auditor.entries[0].Action)
+		assert.Equal(t, "example.com", auditor.entries[0].Domain)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		prov := newMockProvisioner()
+		prov.overrides["example.com"] = overrides.Override{BlockedCountries: []string{"CN"}}
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/access-rules", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp AccessRulesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, []string{"CN"}, resp.BlockedCountries)
+	})
+
+	t.Run("invalid country", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+		h.SetAudit(auditor)
+
+		w := doRequest(h.Routes(), http.MethodPut, "/domains/example.com/access-rules", testToken, `{"blocked_countries":["China"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, auditor.entries)
+	})
+
+	t.Run("invalid ip", func(t *testing.T) {
+		routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/access-rules", testToken, `{"blocked_ips":["999.1.1.1"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestCertificates(t *testing.T) {
+	t.Run("put uploads and audits metadata only", func(t *testing.T) {
+		prov := newMockProvisioner()
+		auditor := &recordingAuditor{}
+		h := NewHandler(prov, testToken, zap.NewNop())
+		h.SetAudit(auditor)
+		routes := h.Routes()
+
+		body := `{"hostname":"www.example.com","certificate":"valid-cert","private_key":"secret-key"}`
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/certificates", testToken, body)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var entry certs.Entry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+		assert.Equal(t, "www.example.com", entry.Hostname)
+
+		require.Len(t, auditor.entries, 1)
+		assert.Equal(t, "certificate.upload", auditor.entries[0].Action)
+		details, err := json.Marshal(auditor.entries[0].Details)
+		require.NoError(t, err)
+		assert.NotContains(t, string(details), "secret-key")
+
+		w = doRequest(routes, http.MethodGet, "/domains/example.com/certificates", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp CertificatesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Certificates, 1)
+		assert.Equal(t, "www.example.com", resp.Certificates[0].Hostname)
+	})
+
+	t.Run("get without certificates returns an empty list", func(t *testing.T) {
+		routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/certificates", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"certificates":[]`)
+	})
+
+	t.Run("invalid certificate", func(t *testing.T) {
+		routes := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/certificates", testToken, `{"certificate":"garbage","private_key":"key"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("not provisioned", func(t *testing.T) {
+		prov := newMockProvisioner()
+		prov.provisioned = false
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/certificates", testToken, `{"certificate":"valid-cert","private_key":"key"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("bunny failure", func(t *testing.T) {
+		prov := newMockProvisioner()
+		prov.updateErr = fmt.Errorf("bunny unavailable")
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodPut, "/domains/example.com/certificates", testToken, `{"certificate":"valid-cert","private_key":"key"}`)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
+
+func TestDomains(t *testing.T) {
+	prov := newMockProvisioner()
+	prov.states = []*state.ProvisionState{
+		{ID: "1", Domain: "example.com", User: "exampleu", Status: state.StatusSuccess},
+		{ID: "2", Domain: "blog.example.com", ParentDomain: "example.com", User: "exampleu", Status: state.StatusSuccess},
+		{ID: "3", Domain: "other.com", User: "otheru", Status: state.StatusFailed},
+	}
+	routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+	t.Run("get domain", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var st state.ProvisionState
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+		assert.Equal(t, "exampleu", st.User)
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/unknown.com", testToken, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("user domains", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/users/exampleu/domains", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp UserDomainsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "exampleu", resp.User)
+		require.Len(t, resp.Domains, 2)
+		assert.Equal(t, "example.com", resp.Domains[0].Domain)
+		assert.Equal(t, "blog.example.com", resp.Domains[1].Domain)
+	})
+
+	t.Run("user without domains returns an empty list", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/users/nobody/domains", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"domains":[]`)
+	})
+}
+
+func TestUserDomains_FilterByServer(t *testing.T) {
+	prov := newMockProvisioner()
+	prov.states = []*state.ProvisionState{
+		{ID: "1", Domain: "example.com", User: "exampleu", Server: "cpanel-07"},
+		{ID: "2", Domain: "moved.com", User: "exampleu", Server: "cpanel-03"},
+		{ID: "3", Domain: "old.com", User: "exampleu"},
+	}
+	h := NewHandler(prov, testToken, zap.NewNop())
+	h.SetServer("cpanel-07")
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodGet, "/users/exampleu/domains?server=cpanel-07", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp UserDomainsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "cpanel-07", resp.Server)
+	require.Len(t, resp.Domains, 2)
+	assert.Equal(t, "example.com", resp.Domains[0].Domain)
+	assert.Equal(t, "old.com", resp.Domains[1].Domain, "states without a server belong to this one")
+
+	w = doRequest(routes, http.MethodGet, "/users/exampleu/domains?server=cpanel-03", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Domains, 1)
+	assert.Equal(t, "moved.com", resp.Domains[0].Domain)
+}
+
+// fakeService serves the service endpoints from the mock provisioner
+type fakeService struct {
+	prov    *mockProvisioner
+	purged  map[string][]string
+	retried []string
+}
+
+func (f *fakeService) GetStatus(domain string) (*app.Status, error) {
+	st, err := f.prov.DomainState(domain)
+	if err != nil {
+		return nil, err
+	}
+	return &app.Status{ProvisionState: st, Trend: &app.DomainTrend{}}, nil
+}
+
+func (f *fakeService) Retry(domain string) (*state.ProvisionState, error) {
+	st, err := f.prov.DomainState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if st.Status != state.StatusFailed {
+		return nil, fmt.Errorf("%s: %w", domain, app.ErrNotFailed)
+	}
+	f.retried = append(f.retried, domain)
+	return st, nil
+}
+
+func (f *fakeService) Purge(ctx context.Context, domain string, urls []string) error {
+	if _, err := f.prov.DomainState(domain); err != nil {
+		return err
+	}
+	f.purged[domain] = urls
+	return nil
+}
+
+func (f *fakeService) Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error) {
+	if _, err := f.prov.DomainState(domain); err != nil {
+		return nil, err
+	}
+	return &app.Report{Domain: domain, From: from, To: to, Bandwidth: 1024}, nil
+}
+
+func TestServiceEndpoints(t *testing.T) {
+	prov := newMockProvisioner()
+	prov.states = []*state.ProvisionState{
+		{ID: "1", Domain: "example.com", User: "exampleu", Status: state.StatusSuccess, PullZoneID: 42},
+		{ID: "2", Domain: "broken.com", User: "exampleu", Status: state.StatusFailed},
+	}
+
+	t.Run("not routed without a service", func(t *testing.T) {
+		routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/status", testToken, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	svc := &fakeService{prov: prov, purged: make(map[string][]string)}
+	auditor := &recordingAuditor{}
+	h := NewHandler(prov, testToken, zap.NewNop())
+	h.SetService(svc)
+	h.SetAudit(auditor)
+	routes := h.Routes()
+
+	t.Run("status", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/status", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var status app.Status
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		assert.Equal(t, "exampleu", status.User)
+		assert.NotNil(t, status.Trend)
+
+		w = doRequest(routes, http.MethodGet, "/domains/unknown.com/status", testToken, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		w := doRequest(routes, http.MethodPost, "/domains/broken.com/retry", testToken, "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, []string{"broken.com"}, svc.retried)
+
+		w = doRequest(routes, http.MethodPost, "/domains/example.com/retry", testToken, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		w := doRequest(routes, http.MethodPost, "/domains/example.com/purge", testToken, "")
+		require.Equal(t, http.StatusNoContent, w.Code)
+		assert.Contains(t, svc.purged, "example.com")
+		assert.Empty(t, svc.purged["example.com"])
+
+		w = doRequest(routes, http.MethodPost, "/domains/example.com/purge", testToken, `{"urls":["/index.html"]}`)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, []string{"/index.html"}, svc.purged["example.com"])
+
+		w = doRequest(routes, http.MethodPost, "/domains/example.com/purge", testToken, `{`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		w := doRequest(routes, http.MethodGet, "/domains/example.com/report?days=30", testToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var report app.Report
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, int64(1024), report.Bandwidth)
+		assert.InDelta(t, 30*24*time.Hour, report.To.Sub(report.From), float64(2*time.Hour))
+
+		w = doRequest(routes, http.MethodGet, "/domains/example.com/report?days=0", testToken, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("changes are audited", func(t *testing.T) {
+		var actions []string
+		for _, e := range auditor.entries {
+			actions = append(actions, e.Action)
+		}
+		assert.Equal(t, []string{"domain.retry", "domain.purge", "domain.purge"}, actions)
+	})
+}
+
+func TestConfigEndpoint(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Bunny.APIKey = "bunny-api-key"
+	cfg.Webhook.Secret = "webhook-secret"
+	cfg.Telegram.Enabled = true
+
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetConfig(&cfg)
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodGet, "/config", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doRequest(routes, http.MethodGet, "/config", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "bunny-api-key")
+	assert.NotContains(t, w.Body.String(), "webhook-secret")
+
+	var summary config.Summary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	assert.True(t, summary.Features["telegram"])
+	assert.False(t, summary.Features["balance"])
+	bunnyCfg, ok := summary.Config["bunny"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, config.Redacted, bunnyCfg["api_key"])
+
+	without := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+	w = doRequest(without, http.MethodGet, "/config", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestFlagsEndpoint(t *testing.T) {
+	set, err := flags.Resolve(map[string]bool{flags.ApexOnCDN: false}, nil)
+	require.NoError(t, err)
+
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetFlags(set)
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodGet, "/admin/flags", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doRequest(routes, http.MethodGet, "/admin/flags", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp FlagsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Flags, len(flags.Known()))
+	for _, flag := range resp.Flags {
+		if flag.Name == flags.ApexOnCDN {
+			assert.False(t, flag.Enabled)
+			assert.Equal(t, flags.SourceConfig, flag.Source)
+		}
+	}
+
+	without := NewHandler(newMockProvisioner(), testToken, zap.NewNop()).Routes()
+	w = doRequest(without, http.MethodGet, "/admin/flags", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// newIncidentStore returns an empty incident store in a temporary directory
+func newIncidentStore(t *testing.T) *incident.Store {
+	t.Helper()
+	store, err := incident.NewStore(filepath.Join(t.TempDir(), "incidents.json"), zap.NewNop())
+	require.NoError(t, err)
+	return store
+}
+
+func TestIncidentEndpoints(t *testing.T) {
+	store := newIncidentStore(t)
+	_, _, err := store.Fire(incident.KindOriginHealth, 42, "morden-example-com", 12, 5)
+	require.NoError(t, err)
+	_, _, err = store.Fire(incident.KindHitRate, 42, "morden-example-com", 30, 50)
+	require.NoError(t, err)
+	_, _, err = store.Resolve(incident.KindHitRate, 42)
+	require.NoError(t, err)
+
+	auditor := &recordingAuditor{}
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetAudit(auditor)
+	h.SetIncidents(store)
+	routes := h.Routes()
+
+	var resp IncidentsResponse
+	w := doRequest(routes, http.MethodGet, "/incidents", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Incidents, 1)
+	assert.Equal(t, "INC-1", resp.Incidents[0].ID)
+
+	w = doRequest(routes, http.MethodGet, "/incidents?all=true", testToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Incidents, 2)
+
+	req := httptest.NewRequest(http.MethodPost, "/incidents/inc-1/ack", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set(actorHeader, "alice")
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var inc incident.Incident
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inc))
+	assert.Equal(t, incident.StatusAcknowledged, inc.Status)
+	assert.Equal(t, "alice", inc.AckedBy)
+
+	w = doRequest(routes, http.MethodPost, "/incidents/INC-2/ack", testToken, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(routes, http.MethodPost, "/incidents/INC-9/ack", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	require.Len(t, auditor.entries, 1)
+	assert.Equal(t, "incident.ack", auditor.entries[0].Action)
+	assert.Equal(t, "alice", auditor.entries[0].Actor)
+}
+
+func TestDomainLogsEndpoint(t *testing.T) {
+	store := runlog.NewStore(5, 100)
+	logger := zap.New(store.Core(zapcore.InfoLevel))
+	for _, id := range []string{"run-1", "run-2"} {
+		end := store.Begin("example.com", id)
+		logger.Info("provisioning domain", zap.String("tracking_id", id))
+		end()
+	}
+
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetRunLogs(store)
+	routes := h.Routes()
+
+	var resp RunLogsResponse
+	w := doRequest(routes, http.MethodGet, "/domains/example.com/logs?run=latest", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Runs, 1)
+	assert.Equal(t, "run-2", resp.Runs[0].ID)
+	require.Len(t, resp.Runs[0].Entries, 1)
+	assert.Equal(t, "provisioning domain", resp.Runs[0].Entries[0].Message)
+
+	w = doRequest(routes, http.MethodGet, "/domains/example.com/logs?run=run-1", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "run-1", resp.Runs[0].ID)
+
+	w = doRequest(routes, http.MethodGet, "/domains/example.com/logs?run=all", testToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Runs, 2)
+
+	w = doRequest(routes, http.MethodGet, "/domains/example.com/logs?run=run-9", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(routes, http.MethodGet, "/domains/other.com/logs", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestStatusLinkEndpoint(t *testing.T) {
+	prov := newMockProvisioner()
+	prov.states = []*state.ProvisionState{{ID: "1", Domain: "example.com", Status: state.StatusProvisioning}}
+	auditor := &recordingAuditor{}
+	h := NewHandler(prov, testToken, zap.NewNop())
+	h.SetAudit(auditor)
+	h.SetStatusLinks(config.StatusLinksConfig{
+		Enabled: true,
+		Secret:  strings.Repeat("s", config.MinStatusLinkSecretLength),
+		BaseURL: "https://cdn-status.example.net",
+		TTL:     72 * time.Hour,
+		MaxTTL:  7 * 24 * time.Hour,
+	})
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodPost, "/domains/Example.com/status-link", testToken, "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var resp StatusLinkResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, strings.HasPrefix(resp.URL, "https://cdn-status.example.net/status/example.com?"), resp.URL)
+	assert.WithinDuration(t, time.Now().Add(72*time.Hour), resp.ExpiresAt, time.Minute)
+
+	link, err := url.Parse(resp.URL)
+	require.NoError(t, err)
+	_, err = statuspage.NewSigner(strings.Repeat("s", config.MinStatusLinkSecretLength)).
+		Verify("example.com", link.Query().Get("expires"), link.Query().Get("sig"), time.Now())
+	assert.NoError(t, err, "the server verifies the link with the same secret")
+
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/status-link", testToken, `{"ttl":"2h"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), resp.ExpiresAt, time.Minute)
+
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/status-link", testToken, `{"ttl":"720h"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "past max_ttl")
+	w = doRequest(routes, http.MethodPost, "/domains/other.com/status-link", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	require.Len(t, auditor.entries, 2)
+	assert.Equal(t, "domain.status_link", auditor.entries[0].Action)
+
+	without := NewHandler(prov, testToken, zap.NewNop()).Routes()
+	w = doRequest(without, http.MethodPost, "/domains/example.com/status-link", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func newPauseStore(t *testing.T) *pause.Store {
+	t.Helper()
+	store, err := pause.NewStore(filepath.Join(t.TempDir(), "pause.json"), zap.NewNop())
+	require.NoError(t, err)
+	return store
+}
+
+func TestPauseEndpoints(t *testing.T) {
+	store := newPauseStore(t)
+	auditor := &recordingAuditor{}
+	queued, resumed := 3, 0
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetAudit(auditor)
+	h.SetPause(store, func() { resumed++ }, func() int { return queued })
+	routes := h.Routes()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/pause", strings.NewReader(`{"reason":"Bunny maintenance"}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set(actorHeader, "alice")
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp PauseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Paused)
+	assert.Equal(t, "Bunny maintenance", resp.Reason)
+	assert.Equal(t, "alice", resp.By)
+	assert.Equal(t, 3, resp.Queued)
+	assert.True(t, store.Paused())
+
+	w = doRequest(routes, http.MethodGet, "/admin/pause", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Paused)
+
+	w = doRequest(routes, http.MethodPost, "/admin/pause", testToken, `{"reason":1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doRequest(routes, http.MethodPost, "/admin/resume", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = PauseResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Paused)
+	assert.Equal(t, 3, resp.Queued)
+	assert.Equal(t, 1, resumed)
+	assert.False(t, store.Paused())
+
+	require.Len(t, auditor.entries, 2)
+	assert.Equal(t, "pipeline.pause", auditor.entries[0].Action)
+	assert.Equal(t, "alice", auditor.entries[0].Actor)
+	assert.Equal(t, "pipeline.resume", auditor.entries[1].Action)
+}
+
+// balanceFetcher returns a fixed Bunny balance
+type balanceFetcher float64
+
+func (f balanceFetcher) GetAccountStatistics(ctx context.Context) (*bunny.AccountStats, error) {
+	return &bunny.AccountStats{Balance: float64(f)}, nil
+}
+
+func TestBalanceEndpoint(t *testing.T) {
+	guard := balance.NewGuard(balanceFetcher(4.5), config.BalanceConfig{Enabled: true, Threshold: 10, PausePullZones: true}, nil)
+	guard.Refresh(context.Background())
+
+	h := NewHandler(newMockProvisioner(), testToken, zap.NewNop())
+	h.SetBalance(guard)
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodGet, "/admin/balance", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status balance.Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 4.5, status.Balance)
+	assert.Equal(t, 10.0, status.Threshold)
+	assert.True(t, status.Low)
+	assert.True(t, status.Paused)
+
+	w = doRequest(routes, http.MethodGet, "/admin/balance", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDNSRecordEndpoints(t *testing.T) {
+	prov := newMockProvisioner()
+	prov.records["example.com"] = []provisioner.ManagedRecord{
+		{ID: 7, Type: "MX", Name: "", Value: "mail.example.com", TTL: 300, Enabled: true},
+		{ID: 8, Type: "CNAME", Name: "cdn", Value: "example-com.b-cdn.net", TTL: 300, Enabled: true},
+	}
+	auditor := &recordingAuditor{}
+	h := NewHandler(prov, testToken, zap.NewNop())
+	h.SetAudit(auditor)
+	routes := h.Routes()
+
+	w := doRequest(routes, http.MethodGet, "/domains/example.com/dns-records", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list DNSRecordsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, "example.com", list.Domain)
+	assert.Len(t, list.Records, 2)
+
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/dns-records/7/disable", testToken, `{"reason":"mail migration"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var record provisioner.ManagedRecord
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &record))
+	assert.False(t, record.Enabled)
+	require.NotNil(t, record.Disabled)
+	assert.Equal(t, "mail migration", record.Disabled.Reason)
+
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/dns-records/7/enable", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	record = provisioner.ManagedRecord{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &record))
+	assert.True(t, record.Enabled)
+	assert.Nil(t, record.Disabled)
+
+	require.Len(t, auditor.entries, 2)
+	assert.Equal(t, "dns_record.disable", auditor.entries[0].Action)
+	assert.Equal(t, "mail migration", auditor.entries[0].Details.(map[string]string)["reason"])
+	assert.Equal(t, "dns_record.enable", auditor.entries[1].Action)
+
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/dns-records/99/disable", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/dns-records/mail/disable", testToken, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(routes, http.MethodGet, "/domains/other.com/dns-records", testToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	prov.updateErr = fmt.Errorf("bunny unavailable")
+	w = doRequest(routes, http.MethodPost, "/domains/example.com/dns-records/8/disable", testToken, "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestDisabledZonesEndpoint(t *testing.T) {
+	prov := newMockProvisioner()
+	routes := NewHandler(prov, testToken, zap.NewNop()).Routes()
+
+	w := doRequest(routes, http.MethodGet, "/dns-zones/disabled", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"zones":[]}`, w.Body.String())
+
+	since := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	prov.zones = []provisioner.DisabledZone{{Domain: "example.com", ZoneID: 123456, Reason: provisioner.ZoneReasonDisabled, Since: since}}
+	w = doRequest(routes, http.MethodGet, "/dns-zones/disabled", testToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list DisabledZonesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, prov.zones, list.Zones)
+
+	w = doRequest(routes, http.MethodGet, "/dns-zones/disabled", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
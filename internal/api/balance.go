@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/mordenhost/whm2bunny/internal/balance"
+)
+
+// SetBalance enables the endpoint showing the last Bunny balance check
+func (h *Handler) SetBalance(guard *balance.Guard) {
+	h.balance = guard
+}
+
+// getBalance handles GET /admin/balance
+func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.balance.Status())
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// CertificateRequest uploads a custom certificate for one of a domain's
+// hostnames; an empty hostname means the domain itself
+type CertificateRequest struct {
+	Hostname    string `json:"hostname"`
+	Certificate string `json:"certificate"` // PEM, leaf first, optionally followed by the chain
+	PrivateKey  string `json:"private_key"` // PEM
+}
+
+// CertificatesResponse lists the tracked certificates of a domain's hostnames
+type CertificatesResponse struct {
+	Domain       string        `json:"domain"`
+	Certificates []certs.Entry `json:"certificates"`
+}
+
+// getCertificates handles GET /domains/{domain}/certificates
+func (h *Handler) getCertificates(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	entries := h.provisioner.Certificates(d)
+	if entries == nil {
+		entries = []certs.Entry{}
+	}
+	writeJSON(w, http.StatusOK, CertificatesResponse{Domain: d, Certificates: entries})
+}
+
+// putCertificate handles PUT /domains/{domain}/certificates
+func (h *Handler) putCertificate(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var req CertificateRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid request body",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	entry, err := h.provisioner.UploadCertificate(r.Context(), d, req.Hostname, []byte(req.Certificate), []byte(req.PrivateKey))
+	switch {
+	case err == nil:
+	case errors.Is(err, certs.ErrInvalid), errors.Is(err, provisioner.ErrUnknownHostname):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid certificate",
+			Details: err.Error(),
+		})
+		return
+	case errors.Is(err, provisioner.ErrNotProvisioned):
+		writeJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "domain is not provisioned",
+			Details: err.Error(),
+		})
+		return
+	default:
+		h.logger.Error("failed to upload certificate", zap.String("domain", d), zap.Error(err))
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "failed to upload certificate",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	h.logger.Info("custom certificate uploaded via API",
+		zap.String("domain", d),
+		zap.String("hostname", entry.Hostname),
+	)
+	// Only the metadata is audited, never the key
+	h.record(r, "certificate.upload", d, entry)
+	writeJSON(w, http.StatusOK, entry)
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// SetConfig enables the endpoint showing the effective configuration
+func (h *Handler) SetConfig(cfg *config.Config) {
+	h.config = cfg
+}
+
+// getConfig returns the effective configuration with secrets redacted, the
+// files it was merged from, the enabled features and the applied defaults
+func (h *Handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.config.Summary()
+	if err != nil {
+		h.logger.Error("failed to summarize config", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to summarize config", Details: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// DNSRecordsResponse lists the records of a domain's DNS zone
+type DNSRecordsResponse struct {
+	Domain  string                      `json:"domain"`
+	Records []provisioner.ManagedRecord `json:"records"`
+}
+
+// DisabledZonesResponse lists the managed DNS zones Bunny stopped serving
+type DisabledZonesResponse struct {
+	Zones []provisioner.DisabledZone `json:"zones"`
+}
+
+// DisableDNSRecordRequest optionally says why a record is disabled
+type DisableDNSRecordRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// getDNSRecords handles GET /domains/{domain}/dns-records
+func (h *Handler) getDNSRecords(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	records, err := h.provisioner.DNSRecords(r.Context(), d)
+	switch {
+	case err == nil:
+	case errors.Is(err, provisioner.ErrNotProvisioned):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "domain is not provisioned"})
+		return
+	default:
+		h.logger.Error("failed to list DNS records", zap.String("domain", d), zap.Error(err))
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "failed to list DNS records", Details: err.Error()})
+		return
+	}
+	if records == nil {
+		records = []provisioner.ManagedRecord{}
+	}
+	writeJSON(w, http.StatusOK, DNSRecordsResponse{Domain: d, Records: records})
+}
+
+// getDisabledZones handles GET /dns-zones/disabled
+func (h *Handler) getDisabledZones(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, DisabledZonesResponse{Zones: h.provisioner.DisabledZones()})
+}
+
+// disableDNSRecord handles POST /domains/{domain}/dns-records/{id}/disable
+func (h *Handler) disableDNSRecord(w http.ResponseWriter, r *http.Request) {
+	var req DisableDNSRecordRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body", Details: err.Error()})
+			return
+		}
+	}
+	h.toggleDNSRecord(w, r, false, strings.TrimSpace(req.Reason))
+}
+
+// enableDNSRecord handles POST /domains/{domain}/dns-records/{id}/enable
+func (h *Handler) enableDNSRecord(w http.ResponseWriter, r *http.Request) {
+	h.toggleDNSRecord(w, r, true, "")
+}
+
+// toggleDNSRecord disables or enables the record of the request's path
+func (h *Handler) toggleDNSRecord(w http.ResponseWriter, r *http.Request, enabled bool, reason string) {
+	d := domain(r)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid record ID"})
+		return
+	}
+
+	record, err := h.provisioner.SetDNSRecordEnabled(r.Context(), d, id, enabled, reason)
+	switch {
+	case err == nil:
+	case errors.Is(err, provisioner.ErrNotProvisioned), errors.Is(err, provisioner.ErrRecordNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "DNS record not found", Details: err.Error()})
+		return
+	default:
+		h.logger.Error("failed to toggle DNS record",
+			zap.String("domain", d),
+			zap.Int64("record_id", id),
+			zap.Error(err),
+		)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "failed to update DNS record", Details: err.Error()})
+		return
+	}
+
+	action := "dns_record.disable"
+	if enabled {
+		action = "dns_record.enable"
+	}
+	h.record(r, action, d, map[string]string{
+		"record": record.Type + " " + record.Name + " " + record.Value,
+		"reason": reason,
+	})
+	writeJSON(w, http.StatusOK, record)
+}
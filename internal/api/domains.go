@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// UserDomainsResponse lists the domains owned by a WHM user on the server
+// answering
+type UserDomainsResponse struct {
+	User    string                  `json:"user"`
+	Server  string                  `json:"server,omitempty"`
+	Domains []*state.ProvisionState `json:"domains"`
+}
+
+// SetServer names this instance in responses, and as the server of states
+// recorded without one
+func (h *Handler) SetServer(name string) {
+	h.server = name
+}
+
+// getDomain handles GET /domains/{domain}
+func (h *Handler) getDomain(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	st, err := h.provisioner.DomainState(d)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, st)
+	case errors.Is(err, state.ErrStateNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "domain not found"})
+	default:
+		h.logger.Error("failed to read domain state",
+			zap.String("domain", d),
+			zap.Error(err),
+		)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to read domain state"})
+	}
+}
+
+// getUserDomains handles GET /users/{user}/domains
+func (h *Handler) getUserDomains(w http.ResponseWriter, r *http.Request) {
+	user := strings.TrimSpace(chi.URLParam(r, "user"))
+	if user == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "user is required"})
+		return
+	}
+
+	states, err := h.provisioner.UserDomains(user)
+	if err != nil {
+		h.logger.Error("failed to list user domains",
+			zap.String("user", user),
+			zap.Error(err),
+		)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list domains"})
+		return
+	}
+	if server := strings.TrimSpace(r.URL.Query().Get("server")); server != "" {
+		states = h.filterServer(states, server)
+	}
+	if states == nil {
+		states = []*state.ProvisionState{}
+	}
+	writeJSON(w, http.StatusOK, UserDomainsResponse{User: user, Server: h.server, Domains: states})
+}
+
+// filterServer returns the states provisioned by server; states without a
+// server count as this instance's
+func (h *Handler) filterServer(states []*state.ProvisionState, server string) []*state.ProvisionState {
+	var kept []*state.ProvisionState
+	for _, st := range states {
+		owner := st.Server
+		if owner == "" {
+			owner = h.server
+		}
+		if strings.EqualFold(owner, server) {
+			kept = append(kept, st)
+		}
+	}
+	return kept
+}
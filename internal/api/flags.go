@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/mordenhost/whm2bunny/internal/flags"
+)
+
+// FlagsResponse lists the feature flags of the server, sorted by name
+type FlagsResponse struct {
+	Flags []flags.State `json:"flags"`
+}
+
+// SetFlags enables the endpoint showing the feature flags
+func (h *Handler) SetFlags(set *flags.Set) {
+	h.flags = set
+}
+
+// getFlags handles GET /admin/flags
+func (h *Handler) getFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, FlagsResponse{Flags: h.flags.States()})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/incident"
+)
+
+// IncidentsResponse lists incidents, oldest first
+type IncidentsResponse struct {
+	Incidents []incident.Incident `json:"incidents"`
+}
+
+// SetIncidents enables the endpoints listing and acknowledging incidents
+func (h *Handler) SetIncidents(store *incident.Store) {
+	h.incidents = store
+}
+
+// getIncidents handles GET /incidents; ?all=true includes the resolved
+// incidents still kept
+func (h *Handler) getIncidents(w http.ResponseWriter, r *http.Request) {
+	list := h.incidents.Active()
+	if r.URL.Query().Get("all") == "true" {
+		list = h.incidents.List()
+	}
+	writeJSON(w, http.StatusOK, IncidentsResponse{Incidents: list})
+}
+
+// ackIncident handles POST /incidents/{id}/ack; the actor header, or else
+// the token's name, names who acknowledged it
+func (h *Handler) ackIncident(w http.ResponseWriter, r *http.Request) {
+	by := strings.TrimSpace(r.Header.Get(actorHeader))
+	if by == "" {
+		by = requestToken(r).Name
+	}
+
+	inc, err := h.incidents.Ack(chi.URLParam(r, "id"), by)
+	switch {
+	case err == nil:
+	case errors.Is(err, incident.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "incident not found", Details: err.Error()})
+		return
+	case errors.Is(err, incident.ErrResolved):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "incident is already resolved", Details: err.Error()})
+		return
+	default:
+		h.logger.Error("failed to acknowledge incident", zap.String("incident", chi.URLParam(r, "id")), zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to acknowledge incident", Details: err.Error()})
+		return
+	}
+
+	h.logger.Info("incident acknowledged via API",
+		zap.String("incident", inc.ID),
+		zap.String("by", inc.AckedBy),
+	)
+	h.record(r, "incident.ack", inc.Zone, map[string]string{"incident": inc.ID, "kind": string(inc.Kind)})
+	writeJSON(w, http.StatusOK, inc)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the routes of Handler. It is maintained by hand;
+// the tests check it against the router
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders openapi.json with Swagger UI
+//
+//go:embed docs.html
+var docsPage []byte
+
+// getOpenAPI handles GET /openapi.json, which needs no token so that
+// integrators can explore the API
+func (h *Handler) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// DocsHandler serves Swagger UI for the API mounted at /api/v1, to be
+// mounted at /api/docs
+func DocsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	})
+}
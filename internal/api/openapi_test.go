@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/balance"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/flags"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/runlog"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// openAPIDoc is the part of the document the tests check
+type openAPIDoc struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+	return doc
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	h := NewHandler(newMockProvisioner(), testToken, nil)
+
+	// The document needs no token
+	w := doRequest(h.Routes(), http.MethodGet, "/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(openAPISpec), w.Body.String())
+
+	w = httptest.NewRecorder()
+	DocsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/docs", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"v1/openapi.json"`)
+}
+
+func TestOpenAPI_MatchesRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+
+	h := NewHandler(newMockProvisioner(), testToken, nil)
+	h.SetService(&fakeService{})
+	h.SetConfig(&config.Config{})
+	h.SetIncidents(newIncidentStore(t))
+	h.SetStatusLinks(config.StatusLinksConfig{})
+	h.SetPause(newPauseStore(t), func() {}, func() int { return 0 })
+	h.SetRunLogs(runlog.NewStore(1, 1))
+	h.SetBalance(balance.NewGuard(nil, config.BalanceConfig{}, nil))
+	flagSet, err := flags.Resolve(nil, nil)
+	require.NoError(t, err)
+	h.SetFlags(flagSet)
+
+	var routed []string
+	err = chi.Walk(h.Routes().(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var documented []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+
+	sort.Strings(routed)
+	sort.Strings(documented)
+	assert.Equal(t, routed, documented, "openapi.json documents every route")
+}
+
+func TestOpenAPI_Refs(t *testing.T) {
+	doc := loadOpenAPI(t)
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(openAPISpec, &raw))
+
+	refs := regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(openAPISpec), -1)
+	require.NotEmpty(t, refs)
+	components := raw["components"].(map[string]any)
+	for _, ref := range refs {
+		group, ok := components[ref[1]].(map[string]any)
+		require.True(t, ok, "components/%s", ref[1])
+		assert.Contains(t, group, ref[2], "%s is defined", ref[0])
+	}
+	assert.NotEmpty(t, doc.Components.Schemas)
+}
+
+func TestOpenAPI_SchemasMatchTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	types := map[string]any{
+		"ErrorResponse":           ErrorResponse{},
+		"ProvisionState":          state.ProvisionState{},
+		"VHostCheck":              state.VHostCheck{},
+		"DomainTrend":             app.DomainTrend{},
+		"Trend":                   app.Trend{},
+		"Report":                  app.Report{},
+		"RetryResponse":           RetryResponse{},
+		"PurgeRequest":            PurgeRequest{},
+		"ReferrerSettings":        bunny.ReferrerSettings{},
+		"ReferrersRequest":        ReferrersRequest{},
+		"ReferrersResponse":       ReferrersResponse{},
+		"AccessRulesRequest":      AccessRulesRequest{},
+		"AccessRulesResponse":     AccessRulesResponse{},
+		"CertificateRequest":      CertificateRequest{},
+		"Certificate":             certs.Entry{},
+		"CertificatesResponse":    CertificatesResponse{},
+		"UserDomainsResponse":     UserDomainsResponse{},
+		"ConfigSummary":           config.Summary{},
+		"Incident":                incident.Incident{},
+		"IncidentsResponse":       IncidentsResponse{},
+		"StatusLinkRequest":       StatusLinkRequest{},
+		"StatusLinkResponse":      StatusLinkResponse{},
+		"PauseRequest":            PauseRequest{},
+		"PauseResponse":           PauseResponse{},
+		"BalanceStatus":           balance.Status{},
+		"FlagsResponse":           FlagsResponse{},
+		"FeatureFlag":             flags.State{},
+		"ManagedRecord":           provisioner.ManagedRecord{},
+		"DisabledRecord":          dnsrecords.Disabled{},
+		"DNSRecordsResponse":      DNSRecordsResponse{},
+		"DisabledZonesResponse":   DisabledZonesResponse{},
+		"DisabledZone":            provisioner.DisabledZone{},
+		"DisableDNSRecordRequest": DisableDNSRecordRequest{},
+		"RunLogsResponse":         RunLogsResponse{},
+		"RunLog":                  runlog.Run{},
+		"RunLogEntry":             runlog.Entry{},
+	}
+	for name, v := range types {
+		schema, ok := doc.Components.Schemas[name]
+		if !assert.True(t, ok, "schema %s", name) {
+			continue
+		}
+		var documented []string
+		for field := range schema.Properties {
+			documented = append(documented, field)
+		}
+		sort.Strings(documented)
+		assert.Equal(t, jsonFields(reflect.TypeOf(v)), documented, "fields of %s", name)
+	}
+}
+
+// jsonFields returns the sorted JSON names of a struct's fields, including
+// those of embedded structs
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			fields = append(fields, jsonFields(embedded)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
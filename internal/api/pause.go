@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/pause"
+)
+
+// PauseRequest optionally says why the pipeline is paused
+type PauseRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// PauseResponse is whether the pipeline is paused, with the number of
+// accepted webhook events waiting for it
+type PauseResponse struct {
+	pause.Status
+	Queued int `json:"queued"`
+}
+
+// SetPause enables the endpoints pausing and resuming the pipeline; resume
+// starts the events queued meanwhile and queued counts them
+func (h *Handler) SetPause(store *pause.Store, resume func(), queued func() int) {
+	h.pause = store
+	h.resumeQueue = resume
+	h.queued = queued
+}
+
+// getPause handles GET /admin/pause
+func (h *Handler) getPause(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, PauseResponse{Status: h.pause.Status(), Queued: h.queued()})
+}
+
+// pausePipeline handles POST /admin/pause: webhooks are still accepted,
+// but queued events wait until the pipeline is resumed. The actor header,
+// or else the token's name, names who paused it
+func (h *Handler) pausePipeline(w http.ResponseWriter, r *http.Request) {
+	var req PauseRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body", Details: err.Error()})
+			return
+		}
+	}
+	by := strings.TrimSpace(r.Header.Get(actorHeader))
+	if by == "" {
+		by = requestToken(r).Name
+	}
+
+	status, err := h.pause.Pause(strings.TrimSpace(req.Reason), by)
+	if err != nil {
+		h.logger.Error("failed to pause provisioning", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to pause provisioning", Details: err.Error()})
+		return
+	}
+
+	h.record(r, "pipeline.pause", "", map[string]string{"reason": status.Reason})
+	writeJSON(w, http.StatusOK, PauseResponse{Status: status, Queued: h.queued()})
+}
+
+// resumePipeline handles POST /admin/resume and starts the queued events
+func (h *Handler) resumePipeline(w http.ResponseWriter, r *http.Request) {
+	prev, err := h.pause.Resume()
+	if err != nil {
+		h.logger.Error("failed to resume provisioning", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to resume provisioning", Details: err.Error()})
+		return
+	}
+	queued := h.queued()
+	h.resumeQueue()
+
+	if prev.Paused {
+		h.record(r, "pipeline.resume", "", map[string]string{"paused_since": prev.Since.UTC().Format(time.RFC3339)})
+	}
+	writeJSON(w, http.StatusOK, PauseResponse{Status: h.pause.Status(), Queued: queued})
+}
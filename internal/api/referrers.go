@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+)
+
+// ReferrersRequest replaces a domain's extra referrers
+// A null hotlink_protection falls back to the domain's profile
+type ReferrersRequest struct {
+	AllowedReferrers  []string `json:"allowed_referrers"`
+	BlockedReferrers  []string `json:"blocked_referrers"`
+	HotlinkProtection *bool    `json:"hotlink_protection"`
+}
+
+// ReferrersResponse shows a domain's extra referrers and the effective rules
+type ReferrersResponse struct {
+	Domain            string                 `json:"domain"`
+	AllowedReferrers  []string               `json:"allowed_referrers"`
+	BlockedReferrers  []string               `json:"blocked_referrers"`
+	HotlinkProtection *bool                  `json:"hotlink_protection"`
+	Effective         bunny.ReferrerSettings `json:"effective"`
+	Applied           bool                   `json:"applied"`
+}
+
+// getReferrers handles GET /domains/{domain}/referrers
+func (h *Handler) getReferrers(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	writeJSON(w, http.StatusOK, h.referrersResponse(d, h.provisioner.ReferrerSettings(d), false))
+}
+
+// putReferrers handles PUT /domains/{domain}/referrers
+func (h *Handler) putReferrers(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var req ReferrersRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid request body",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	settings, err := h.provisioner.UpdateReferrers(r.Context(), d, func(o *overrides.Override) {
+		o.AllowedReferrers = req.AllowedReferrers
+		o.BlockedReferrers = req.BlockedReferrers
+		o.HotlinkProtection = req.HotlinkProtection
+	})
+	applied := err == nil
+	if err != nil && !errors.Is(err, provisioner.ErrNotProvisioned) {
+		h.logger.Error("failed to update referrers", zap.String("domain", d), zap.Error(err))
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "failed to update referrers",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	h.logger.Info("referrers updated via API",
+		zap.String("domain", d),
+		zap.Bool("applied", applied),
+	)
+	h.record(r, "referrers.update", d, req)
+	writeJSON(w, http.StatusOK, h.referrersResponse(d, settings, applied))
+}
+
+func (h *Handler) referrersResponse(d string, effective bunny.ReferrerSettings, applied bool) ReferrersResponse {
+	o := h.provisioner.DomainOverride(d)
+	return ReferrersResponse{
+		Domain:            d,
+		AllowedReferrers:  nonNil(o.AllowedReferrers),
+		BlockedReferrers:  nonNil(o.BlockedReferrers),
+		HotlinkProtection: o.HotlinkProtection,
+		Effective:         effective,
+		Applied:           applied,
+	}
+}
+
+// nonNil returns an empty slice for nil so lists encode as []
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
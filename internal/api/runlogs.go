@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/runlog"
+)
+
+// RunLogsResponse lists the log entries of provisioning runs of a domain,
+// newest run first
+type RunLogsResponse struct {
+	Domain string       `json:"domain"`
+	Runs   []runlog.Run `json:"runs"`
+}
+
+// SetRunLogs enables the endpoint reading the logs of provisioning runs
+func (h *Handler) SetRunLogs(store *runlog.Store) {
+	h.runLogs = store
+}
+
+// getDomainLogs handles GET /domains/{domain}/logs; ?run= is latest, the
+// default, a tracking ID or all
+func (h *Handler) getDomainLogs(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var runs []runlog.Run
+	switch id := strings.TrimSpace(r.URL.Query().Get("run")); id {
+	case "all":
+		runs = h.runLogs.Runs(d)
+	case "", "latest":
+		if run, ok := h.runLogs.Latest(d); ok {
+			runs = append(runs, run)
+		}
+	default:
+		if run, ok := h.runLogs.Get(d, id); ok {
+			runs = append(runs, run)
+		}
+	}
+	if len(runs) == 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "no run logs kept for this domain"})
+		return
+	}
+	writeJSON(w, http.StatusOK, RunLogsResponse{Domain: d, Runs: runs})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// maxReportDays bounds the period of a traffic report
+const maxReportDays = 90
+
+// Service performs the operations shared with the CLI and the Telegram bot
+type Service interface {
+	GetStatus(domain string) (*app.Status, error)
+	Retry(domain string) (*state.ProvisionState, error)
+	Purge(ctx context.Context, domain string, urls []string) error
+	Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error)
+}
+
+// PurgeRequest lists the URLs to purge; none purges the whole cache
+type PurgeRequest struct {
+	URLs []string `json:"urls,omitempty"`
+}
+
+// RetryResponse reports a queued retry
+type RetryResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+	Domain  string `json:"domain"`
+}
+
+// SetService enables the status, retry, purge and report endpoints
+func (h *Handler) SetService(s Service) {
+	h.service = s
+}
+
+// writeServiceError maps errors of the service to responses
+func (h *Handler) writeServiceError(w http.ResponseWriter, d, action string, err error) {
+	switch {
+	case errors.Is(err, state.ErrStateNotFound):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "domain not found"})
+	case errors.Is(err, app.ErrNotFailed), errors.Is(err, app.ErrNoPullZone):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		h.logger.Error("failed to "+action,
+			zap.String("domain", d),
+			zap.Error(err),
+		)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to " + action, Details: err.Error()})
+	}
+}
+
+// getDomainStatus handles GET /domains/{domain}/status
+func (h *Handler) getDomainStatus(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	status, err := h.service.GetStatus(d)
+	if err != nil {
+		h.writeServiceError(w, d, "read domain state", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// retryDomain handles POST /domains/{domain}/retry
+func (h *Handler) retryDomain(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+	st, err := h.service.Retry(d)
+	if err != nil {
+		h.writeServiceError(w, d, "retry provision", err)
+		return
+	}
+
+	h.record(r, "domain.retry", d, nil)
+	writeJSON(w, http.StatusAccepted, RetryResponse{Message: "retry scheduled", ID: st.ID, Domain: st.Domain})
+}
+
+// purgeDomain handles POST /domains/{domain}/purge
+func (h *Handler) purgeDomain(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var req PurgeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body", Details: err.Error()})
+			return
+		}
+	}
+
+	if err := h.service.Purge(r.Context(), d, req.URLs); err != nil {
+		h.writeServiceError(w, d, "purge cache", err)
+		return
+	}
+
+	h.record(r, "domain.purge", d, map[string]string{"urls": strconv.Itoa(len(req.URLs))})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDomainReport handles GET /domains/{domain}/report?days=N
+func (h *Handler) getDomainReport(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReportDays {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "days must be between 1 and " + strconv.Itoa(maxReportDays)})
+			return
+		}
+		days = n
+	}
+
+	to := time.Now()
+	report, err := h.service.Report(r.Context(), d, to.AddDate(0, 0, -days), to)
+	if err != nil {
+		h.writeServiceError(w, d, "build report", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/statuspage"
+)
+
+// StatusLinkRequest asks for a status page link; an empty TTL uses
+// status_links.ttl
+type StatusLinkRequest struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+// StatusLinkResponse is a signed status page link
+type StatusLinkResponse struct {
+	Domain    string    `json:"domain"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SetStatusLinks enables the endpoint creating status page links
+func (h *Handler) SetStatusLinks(cfg config.StatusLinksConfig) {
+	h.statusLinks = &cfg
+	h.signer = statuspage.NewSigner(cfg.Secret)
+}
+
+// createStatusLink handles POST /domains/{domain}/status-link
+func (h *Handler) createStatusLink(w http.ResponseWriter, r *http.Request) {
+	d := domain(r)
+
+	var req StatusLinkRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body", Details: err.Error()})
+			return
+		}
+	}
+	var requested time.Duration
+	if req.TTL != "" {
+		var err error
+		if requested, err = time.ParseDuration(req.TTL); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid ttl", Details: err.Error()})
+			return
+		}
+	}
+	ttl, err := h.statusLinks.Lifetime(requested)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid ttl", Details: err.Error()})
+		return
+	}
+
+	st, err := h.provisioner.DomainState(d)
+	if err != nil {
+		if errors.Is(err, state.ErrStateNotFound) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "domain not found"})
+			return
+		}
+		h.logger.Error("failed to read domain state", zap.String("domain", d), zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to read domain state", Details: err.Error()})
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	link, err := h.signer.URL(h.statusLinks.BaseURL, st.Domain, expires)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to sign status link", Details: err.Error()})
+		return
+	}
+
+	h.record(r, "domain.status_link", st.Domain, map[string]string{"expires_at": expires.UTC().Format(time.RFC3339)})
+	writeJSON(w, http.StatusCreated, StatusLinkResponse{Domain: st.Domain, URL: link, ExpiresAt: expires})
+}
@@ -0,0 +1,234 @@
+// Package apitoken manages the role-based tokens of the management API.
+// Tokens are stored as SHA-256 hashes, in the configuration or in a file
+// shared between the server and the CLI
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Role is what a token may do. Each role may also do what the roles
+// before it may
+type Role string
+
+// Roles, from the least to the most privileged
+const (
+	// RoleReadOnly reads states, reports, settings and incidents
+	RoleReadOnly Role = "read-only"
+	// RoleOperator also retries provisions, purges caches, changes
+	// referrer and access rules and acknowledges incidents
+	RoleOperator Role = "operator"
+	// RoleAdmin also uploads certificates and reads the configuration
+	RoleAdmin Role = "admin"
+)
+
+// rank orders the roles
+var rank = map[Role]int{RoleReadOnly: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Roles lists the roles from the least privileged
+var Roles = []Role{RoleReadOnly, RoleOperator, RoleAdmin}
+
+// ParseRole returns the role named s
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := rank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, must be read-only, operator or admin", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether a token of role r may do what required may
+func (r Role) Allows(required Role) bool {
+	return rank[r] > 0 && rank[r] >= rank[required]
+}
+
+// hashPrefix marks the hash algorithm of a stored token
+const hashPrefix = "sha256:"
+
+// secretPrefix starts the generated tokens so they are recognized in
+// logs and secret scanners
+const secretPrefix = "w2b_"
+
+// Hash returns the stored form of a token
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// ValidHash reports whether hash is in the form Hash returns
+func ValidHash(hash string) bool {
+	digest, ok := strings.CutPrefix(hash, hashPrefix)
+	if !ok || len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil && strings.ToLower(digest) == digest
+}
+
+// Generate returns a new random token
+func Generate() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Token is a named API token. Only its hash is kept
+type Token struct {
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// Static is set for tokens defined in the configuration, which the
+	// CLI cannot revoke
+	Static bool `json:"-"`
+}
+
+// ErrNotFound is returned when no token has the name given
+var ErrNotFound = errors.New("API token not found")
+
+// Store holds the tokens of the configuration and of a tokens file
+// The file is written with 0600 permissions
+type Store struct {
+	file   *filestore.File[[]Token]
+	static []Token
+	tokens []Token
+	mu     sync.Mutex
+	logger *zap.Logger
+}
+
+// NewStore creates a store of the static tokens and those of filePath
+func NewStore(filePath string, static []Token, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "tokens", 0600, func() []Token { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{file: file, logger: logger}
+	for _, t := range static {
+		t.Static = true
+		s.static = append(s.static, t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.tokens); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// all returns the static tokens followed by those of the file
+// Caller must hold s.mu
+func (s *Store) all() []Token {
+	return append(append([]Token(nil), s.static...), s.tokens...)
+}
+
+// Authenticate returns the token secret hashes to
+func (s *Store) Authenticate(secret string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.tokens); err != nil {
+		s.logger.Warn("Failed to reload API tokens, using cached copy", zap.Error(err))
+	}
+
+	hash := []byte(Hash(secret))
+	for _, t := range s.all() {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// List returns the tokens sorted by name
+func (s *Store) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.tokens); err != nil {
+		return nil, err
+	}
+	tokens := s.all()
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+// Create adds a token named name to the file and returns its secret,
+// which is not kept
+func (s *Store) Create(name string, role Role, now time.Time) (string, Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Token{}, fmt.Errorf("token name is required")
+	}
+	if _, ok := rank[role]; !ok {
+		return "", Token{}, fmt.Errorf("unknown role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.tokens); err != nil {
+		return "", Token{}, err
+	}
+	for _, t := range s.all() {
+		if t.Name == name {
+			return "", Token{}, fmt.Errorf("an API token named %s already exists", name)
+		}
+	}
+
+	secret, err := Generate()
+	if err != nil {
+		return "", Token{}, err
+	}
+	token := Token{Name: name, Role: role, Hash: Hash(secret), CreatedAt: now.UTC()}
+	s.tokens = append(s.tokens, token)
+	if err := s.file.Save(s.tokens); err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		return "", Token{}, err
+	}
+	return secret, token, nil
+}
+
+// Revoke removes the token named name from the file. Tokens of the
+// configuration are removed from it instead
+func (s *Store) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.tokens); err != nil {
+		return err
+	}
+	for _, t := range s.static {
+		if t.Name == name {
+			return fmt.Errorf("API token %s is defined in api.tokens, remove it from the configuration", name)
+		}
+	}
+	for i, t := range s.tokens {
+		if t.Name == name {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return s.file.Save(s.tokens)
+		}
+	}
+	return fmt.Errorf("%s: %w", name, ErrNotFound)
+}
@@ -0,0 +1,92 @@
+package apitoken
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleAllows(t *testing.T) {
+	assert.True(t, RoleAdmin.Allows(RoleOperator))
+	assert.True(t, RoleOperator.Allows(RoleReadOnly))
+	assert.True(t, RoleOperator.Allows(RoleOperator))
+	assert.False(t, RoleReadOnly.Allows(RoleOperator))
+	assert.False(t, RoleOperator.Allows(RoleAdmin))
+	assert.False(t, Role("").Allows(RoleReadOnly))
+
+	role, err := ParseRole(" Operator ")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOperator, role)
+	_, err = ParseRole("root")
+	assert.Error(t, err)
+}
+
+func TestHash(t *testing.T) {
+	hash := Hash("secret")
+	assert.Equal(t, "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", hash)
+	assert.True(t, ValidHash(hash))
+	assert.False(t, ValidHash("secret"))
+	assert.False(t, ValidHash("sha256:abc"))
+	assert.False(t, ValidHash(strings.ToUpper(hash)))
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_tokens.json")
+	static := []Token{{Name: "grafana", Role: RoleReadOnly, Hash: Hash("grafana-secret")}}
+	store, err := NewStore(path, static, nil)
+	require.NoError(t, err)
+
+	secret, token, err := store.Create("panel", RoleOperator, time.Now())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "w2b_"))
+	assert.Equal(t, Hash(secret), token.Hash)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret, "only the hash is stored")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, _, err = store.Create("grafana", RoleAdmin, time.Now())
+	assert.Error(t, err, "names are unique across the config and the file")
+
+	got, ok := store.Authenticate(secret)
+	require.True(t, ok)
+	assert.Equal(t, "panel", got.Name)
+	assert.Equal(t, RoleOperator, got.Role)
+	got, ok = store.Authenticate("grafana-secret")
+	require.True(t, ok)
+	assert.True(t, got.Static)
+	_, ok = store.Authenticate("wrong")
+	assert.False(t, ok)
+
+	// A token created by another process is seen
+	other, err := NewStore(path, nil, nil)
+	require.NoError(t, err)
+	otherSecret, _, err := other.Create("ci", RoleAdmin, time.Now())
+	require.NoError(t, err)
+	// Let the modification time differ on coarse filesystems
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, future, future))
+	_, ok = store.Authenticate(otherSecret)
+	assert.True(t, ok)
+
+	require.NoError(t, store.Revoke("panel"))
+	_, ok = store.Authenticate(secret)
+	assert.False(t, ok)
+	assert.Error(t, store.Revoke("grafana"), "config tokens are not revoked from the CLI")
+	assert.True(t, errors.Is(store.Revoke("panel"), ErrNotFound))
+
+	tokens, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "ci", tokens[0].Name)
+	assert.Equal(t, "grafana", tokens[1].Name)
+}
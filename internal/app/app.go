@@ -0,0 +1,287 @@
+// Package app holds the operations offered by the CLI, the management API
+// and the Telegram bot, so each of them is a thin adapter over the same
+// logic
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+var (
+	// ErrNotFailed is returned when retrying a provision that has not failed
+	ErrNotFailed = errors.New("provision has not failed")
+	// ErrNoPullZone is returned for operations on a domain without a pull zone
+	ErrNoPullZone = errors.New("domain has no pull zone")
+)
+
+// Provisioner provisions domains and reads their state
+type Provisioner interface {
+	Provision(domain, user string) error
+	ProvisionSubdomain(subdomain, parentDomain, user string) error
+	AssignPackage(domain, pkg string) error
+	Reprovision(st *state.ProvisionState) error
+	DomainState(domain string) (*state.ProvisionState, error)
+}
+
+// States updates provisioning states
+type States interface {
+	MarkPending(id, reason string) error
+}
+
+// CDN purges pull zone caches and reads pull zone and DNS zone statistics
+type CDN interface {
+	PurgePullZoneCache(ctx context.Context, zoneID int64) error
+	PurgePullZoneCacheByURL(ctx context.Context, zoneID int64, urls []string) error
+	GetPullZoneBandwidth(ctx context.Context, pullZoneID int64, from, to time.Time) (*bunny.PullZoneStats, error)
+	GetDNSQueryStats(ctx context.Context, zoneID int64, from, to time.Time) (*bunny.DNSQueryStats, error)
+}
+
+// Snapshots reads the daily bandwidth snapshots recorded by the summaries
+type Snapshots interface {
+	GetSnapshotsByZone(zoneID int64, since time.Time) []state.BandwidthSnapshot
+}
+
+// RunLog records the log entries of each provisioning run under its
+// tracking ID
+type RunLog interface {
+	Begin(domain, id string) (end func())
+}
+
+// Service performs the application operations
+type Service struct {
+	provisioner Provisioner
+	states      States
+	cdn         CDN
+	snapshots   Snapshots
+	runLog      RunLog
+	logger      *zap.Logger
+
+	// retries tracks reprovisions started by Retry
+	retries sync.WaitGroup
+}
+
+// NewService creates a service over its dependencies
+func NewService(prov Provisioner, states States, cdn CDN, logger *zap.Logger) *Service {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Service{
+		provisioner: prov,
+		states:      states,
+		cdn:         cdn,
+		logger:      logger,
+	}
+}
+
+// SetSnapshots adds bandwidth and cache hit rate trends to the status
+func (s *Service) SetSnapshots(snapshots Snapshots) {
+	s.snapshots = snapshots
+}
+
+// SetRunLog records the log entries of the retries started by Retry for
+// their domain
+func (s *Service) SetRunLog(r RunLog) {
+	s.runLog = r
+}
+
+// ProvisionRequest describes a domain to provision
+type ProvisionRequest struct {
+	// Domain is the full domain name
+	Domain string
+	// ParentDomain is set for subdomains of a provisioned domain
+	ParentDomain string
+	User         string
+	// Package is the WHM package selecting the domain's CDN profile
+	Package string
+}
+
+// ProvisionDomain provisions a domain or subdomain and waits for the result
+func (s *Service) ProvisionDomain(req ProvisionRequest) error {
+	domain := strings.ToLower(strings.TrimSpace(req.Domain))
+	parent := strings.ToLower(strings.TrimSpace(req.ParentDomain))
+	if domain == "" {
+		return errors.New("domain is required")
+	}
+
+	if req.Package != "" {
+		if err := s.provisioner.AssignPackage(domain, req.Package); err != nil {
+			return fmt.Errorf("failed to record package: %w", err)
+		}
+	}
+
+	if parent == "" {
+		return s.provisioner.Provision(domain, req.User)
+	}
+	subdomain, ok := strings.CutSuffix(domain, "."+parent)
+	if !ok || subdomain == "" {
+		return fmt.Errorf("%s is not a subdomain of %s", domain, parent)
+	}
+	return s.provisioner.ProvisionSubdomain(subdomain, parent, req.User)
+}
+
+// Status is a domain's provisioning state with its usage trends
+type Status struct {
+	*state.ProvisionState
+	// Trend is only set when snapshots are available and the domain has a
+	// pull zone
+	Trend *DomainTrend `json:"trend,omitempty"`
+}
+
+// GetStatus returns the provisioning state and usage trends of a domain
+func (s *Service) GetStatus(domain string) (*Status, error) {
+	st, err := s.provisioner.DomainState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{ProvisionState: st}
+	if s.snapshots != nil && st.PullZoneID > 0 {
+		status.Trend = domainTrend(s.snapshots, st.PullZoneID, time.Now())
+	}
+	return status, nil
+}
+
+// Retry queues a failed provision again and reprovisions it in the
+// background; Wait waits for it to finish
+func (s *Service) Retry(domain string) (*state.ProvisionState, error) {
+	st, err := s.provisioner.DomainState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if st.Status != state.StatusFailed {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotFailed)
+	}
+
+	if err := s.states.MarkPending(st.ID, st.Error); err != nil {
+		return nil, fmt.Errorf("failed to update state: %w", err)
+	}
+
+	s.retries.Add(1)
+	go func() {
+		defer s.retries.Done()
+		trackingID := uuid.New().String()
+		if s.runLog != nil {
+			defer s.runLog.Begin(st.Domain, trackingID)()
+		}
+		s.logger.Info("Triggering retry",
+			zap.String("id", st.ID),
+			zap.String("domain", st.Domain),
+			zap.String("tracking_id", trackingID),
+		)
+		if err := s.provisioner.Reprovision(st); err != nil {
+			s.logger.Error("Retry failed",
+				zap.String("id", st.ID),
+				zap.String("domain", st.Domain),
+				zap.Error(err),
+			)
+			return
+		}
+		s.logger.Info("Retry succeeded",
+			zap.String("id", st.ID),
+			zap.String("domain", st.Domain),
+		)
+	}()
+
+	return st, nil
+}
+
+// Wait waits for the reprovisions started by Retry
+func (s *Service) Wait() {
+	s.retries.Wait()
+}
+
+// Purge purges a domain's pull zone cache, or only the given URLs. URLs
+// may be paths, which are taken relative to https://domain
+func (s *Service) Purge(ctx context.Context, domain string, urls []string) error {
+	st, err := s.provisioner.DomainState(domain)
+	if err != nil {
+		return err
+	}
+	if st.PullZoneID == 0 {
+		return fmt.Errorf("%s: %w", domain, ErrNoPullZone)
+	}
+
+	if len(urls) == 0 {
+		return s.cdn.PurgePullZoneCache(ctx, st.PullZoneID)
+	}
+
+	full := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if strings.HasPrefix(u, "/") {
+			u = "https://" + st.Domain + u
+		}
+		full = append(full, u)
+	}
+	return s.cdn.PurgePullZoneCacheByURL(ctx, st.PullZoneID, full)
+}
+
+// Report is a domain's traffic over a period
+type Report struct {
+	Domain       string    `json:"domain"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Bandwidth    int64     `json:"bandwidth"`
+	Requests     int64     `json:"requests"`
+	CacheHits    int64     `json:"cache_hits"`
+	CacheMisses  int64     `json:"cache_misses"`
+	CacheHitRate float64   `json:"cache_hit_rate"`
+	// DNSQueries are the queries the domain's DNS zone answered; nil for
+	// subdomains, which share their parent's zone, and when Bunny did not
+	// report them
+	DNSQueries *int64 `json:"dns_queries,omitempty"`
+}
+
+// Report returns a domain's traffic between from and to
+func (s *Service) Report(ctx context.Context, domain string, from, to time.Time) (*Report, error) {
+	st, err := s.provisioner.DomainState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if st.PullZoneID == 0 {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNoPullZone)
+	}
+
+	stats, err := s.cdn.GetPullZoneBandwidth(ctx, st.PullZoneID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statistics: %w", err)
+	}
+
+	report := &Report{
+		Domain:      st.Domain,
+		From:        from,
+		To:          to,
+		Bandwidth:   stats.TotalBandwidth,
+		Requests:    stats.TotalRequests,
+		CacheHits:   stats.TotalCacheHits,
+		CacheMisses: stats.TotalCacheMisses,
+	}
+	if total := stats.TotalCacheHits + stats.TotalCacheMisses; total > 0 {
+		report.CacheHitRate = float64(stats.TotalCacheHits) / float64(total) * 100
+	}
+
+	// DNS statistics are secondary; the report stands without them
+	if st.ZoneID > 0 && st.ParentDomain == "" {
+		dns, err := s.cdn.GetDNSQueryStats(ctx, st.ZoneID, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get DNS query statistics",
+				zap.String("domain", st.Domain),
+				zap.Int64("zone_id", st.ZoneID),
+				zap.Error(err),
+			)
+		} else {
+			report.DNSQueries = &dns.TotalQueries
+		}
+	}
+	return report, nil
+}
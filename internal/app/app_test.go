@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// fakeProvisioner keeps states in memory and records what it was asked to do
+type fakeProvisioner struct {
+	states      []*state.ProvisionState
+	provisioned []string
+	packages    map[string]string
+	failWith    error
+}
+
+func (f *fakeProvisioner) Provision(domain, user string) error {
+	f.provisioned = append(f.provisioned, domain+"/"+user)
+	return f.failWith
+}
+
+func (f *fakeProvisioner) ProvisionSubdomain(subdomain, parentDomain, user string) error {
+	f.provisioned = append(f.provisioned, subdomain+"."+parentDomain+"/"+user)
+	return f.failWith
+}
+
+func (f *fakeProvisioner) AssignPackage(domain, pkg string) error {
+	f.packages[domain] = pkg
+	return nil
+}
+
+func (f *fakeProvisioner) Reprovision(st *state.ProvisionState) error {
+	f.provisioned = append(f.provisioned, st.Domain)
+	return f.failWith
+}
+
+func (f *fakeProvisioner) DomainState(domain string) (*state.ProvisionState, error) {
+	for _, st := range f.states {
+		if st.Domain == domain {
+			return st, nil
+		}
+	}
+	return nil, state.ErrStateNotFound
+}
+
+// fakeStates records states marked pending
+type fakeStates struct {
+	pending []string
+}
+
+func (f *fakeStates) MarkPending(id, reason string) error {
+	f.pending = append(f.pending, id)
+	return nil
+}
+
+// fakeCDN records purges and returns fixed statistics
+type fakeCDN struct {
+	purged  map[int64][]string
+	stats   bunny.PullZoneStats
+	queries map[int64]int64
+}
+
+func (f *fakeCDN) PurgePullZoneCache(ctx context.Context, zoneID int64) error {
+	f.purged[zoneID] = nil
+	return nil
+}
+
+func (f *fakeCDN) PurgePullZoneCacheByURL(ctx context.Context, zoneID int64, urls []string) error {
+	f.purged[zoneID] = urls
+	return nil
+}
+
+func (f *fakeCDN) GetPullZoneBandwidth(ctx context.Context, pullZoneID int64, from, to time.Time) (*bunny.PullZoneStats, error) {
+	stats := f.stats
+	stats.PullZoneID = pullZoneID
+	return &stats, nil
+}
+
+func (f *fakeCDN) GetDNSQueryStats(ctx context.Context, zoneID int64, from, to time.Time) (*bunny.DNSQueryStats, error) {
+	queries, ok := f.queries[zoneID]
+	if !ok {
+		return nil, errors.New("zone not found")
+	}
+	return &bunny.DNSQueryStats{ZoneID: zoneID, TotalQueries: queries}, nil
+}
+
+// fakeSnapshots returns fixed snapshots
+type fakeSnapshots []state.BandwidthSnapshot
+
+func (f fakeSnapshots) GetSnapshotsByZone(zoneID int64, since time.Time) []state.BandwidthSnapshot {
+	var result []state.BandwidthSnapshot
+	for _, snap := range f {
+		if snap.ZoneID == zoneID && !snap.Timestamp.Before(since) {
+			result = append(result, snap)
+		}
+	}
+	return result
+}
+
+func newTestService() (*Service, *fakeProvisioner, *fakeStates, *fakeCDN) {
+	prov := &fakeProvisioner{
+		states: []*state.ProvisionState{
+			{ID: "1", Domain: "example.com", User: "exampleu", Status: state.StatusSuccess, ZoneID: 7, PullZoneID: 42},
+			{ID: "2", Domain: "broken.com", User: "exampleu", Status: state.StatusFailed, Error: "boom"},
+			{ID: "3", Domain: "blog.example.com", ParentDomain: "example.com", User: "exampleu", Status: state.StatusSuccess, ZoneID: 7, PullZoneID: 43},
+		},
+		packages: make(map[string]string),
+	}
+	states := &fakeStates{}
+	cdn := &fakeCDN{purged: make(map[int64][]string), queries: make(map[int64]int64)}
+	return NewService(prov, states, cdn, nil), prov, states, cdn
+}
+
+func TestProvisionDomain(t *testing.T) {
+	svc, prov, _, _ := newTestService()
+
+	require.NoError(t, svc.ProvisionDomain(ProvisionRequest{Domain: "New.com", User: "newu", Package: "gold"}))
+	require.NoError(t, svc.ProvisionDomain(ProvisionRequest{Domain: "blog.new.com", ParentDomain: "new.com", User: "newu"}))
+	assert.Equal(t, []string{"new.com/newu", "blog.new.com/newu"}, prov.provisioned)
+	assert.Equal(t, "gold", prov.packages["new.com"])
+
+	assert.Error(t, svc.ProvisionDomain(ProvisionRequest{Domain: "blog.other.com", ParentDomain: "new.com"}))
+	assert.Error(t, svc.ProvisionDomain(ProvisionRequest{}))
+}
+
+func TestRetry(t *testing.T) {
+	svc, prov, states, _ := newTestService()
+
+	st, err := svc.Retry("broken.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2", st.ID)
+	svc.Wait()
+	assert.Equal(t, []string{"2"}, states.pending)
+	assert.Equal(t, []string{"broken.com"}, prov.provisioned)
+
+	_, err = svc.Retry("example.com")
+	assert.ErrorIs(t, err, ErrNotFailed)
+
+	_, err = svc.Retry("unknown.com")
+	assert.ErrorIs(t, err, state.ErrStateNotFound)
+}
+
+func TestPurge(t *testing.T) {
+	svc, prov, _, cdn := newTestService()
+
+	require.NoError(t, svc.Purge(context.Background(), "example.com", nil))
+	assert.Contains(t, cdn.purged, int64(42))
+
+	require.NoError(t, svc.Purge(context.Background(), "example.com", []string{"/app.css", "https://example.com/a.js"}))
+	assert.Equal(t, []string{"https://example.com/app.css", "https://example.com/a.js"}, cdn.purged[42])
+
+	prov.states = append(prov.states, &state.ProvisionState{ID: "3", Domain: "nozone.com", Status: state.StatusPending})
+	err := svc.Purge(context.Background(), "nozone.com", nil)
+	assert.True(t, errors.Is(err, ErrNoPullZone))
+}
+
+func TestReport(t *testing.T) {
+	svc, _, _, cdn := newTestService()
+	cdn.stats = bunny.PullZoneStats{TotalBandwidth: 2048, TotalRequests: 10, TotalCacheHits: 9, TotalCacheMisses: 1}
+
+	to := time.Now()
+	report, err := svc.Report(context.Background(), "example.com", to.AddDate(0, 0, -7), to)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", report.Domain)
+	assert.Equal(t, int64(2048), report.Bandwidth)
+	assert.InDelta(t, 90.0, report.CacheHitRate, 0.01)
+	assert.Nil(t, report.DNSQueries, "DNS statistics unavailable")
+
+	cdn.queries[7] = 5000
+	report, err = svc.Report(context.Background(), "example.com", to.AddDate(0, 0, -7), to)
+	require.NoError(t, err)
+	require.NotNil(t, report.DNSQueries)
+	assert.Equal(t, int64(5000), *report.DNSQueries)
+
+	// Subdomains share the parent's zone, whose queries are not theirs
+	report, err = svc.Report(context.Background(), "blog.example.com", to.AddDate(0, 0, -7), to)
+	require.NoError(t, err)
+	assert.Nil(t, report.DNSQueries)
+
+	_, err = svc.Report(context.Background(), "broken.com", to.AddDate(0, 0, -7), to)
+	assert.ErrorIs(t, err, ErrNoPullZone)
+}
+
+func TestGetStatus(t *testing.T) {
+	svc, _, _, _ := newTestService()
+
+	status, err := svc.GetStatus("example.com")
+	require.NoError(t, err)
+	assert.Nil(t, status.Trend, "no trend without snapshots")
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	day := func(offset int, bandwidth, hits, misses int64) state.BandwidthSnapshot {
+		from := today.AddDate(0, 0, offset)
+		return state.BandwidthSnapshot{
+			ZoneID:      42,
+			Timestamp:   from.AddDate(0, 0, 1),
+			From:        from,
+			To:          from.Add(24*time.Hour - time.Second),
+			Bandwidth:   bandwidth,
+			CacheHits:   hits,
+			CacheMisses: misses,
+		}
+	}
+	svc.SetSnapshots(fakeSnapshots{
+		day(-1, 100, 3, 1),
+		day(-7, 70, 0, 0),
+		day(-20, 20, 1, 1),
+		// A weekly period is not a day of the trend
+		{ZoneID: 42, Timestamp: today, From: today.AddDate(0, 0, -7), To: today.Add(-time.Second), Bandwidth: 999},
+	})
+
+	status, err = svc.GetStatus("example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "exampleu", status.User)
+	require.NotNil(t, status.Trend)
+
+	week := status.Trend.Week
+	assert.Equal(t, today.AddDate(0, 0, -7).Format("2006-01-02"), week.From)
+	require.Len(t, week.Bandwidth, 7)
+	require.Len(t, week.CacheHitRate, 7)
+	require.NotNil(t, week.Bandwidth[0])
+	assert.Equal(t, int64(70), *week.Bandwidth[0])
+	assert.Nil(t, week.CacheHitRate[0], "no requests has no hit rate")
+	assert.Nil(t, week.Bandwidth[1])
+	require.NotNil(t, week.Bandwidth[6])
+	assert.Equal(t, int64(100), *week.Bandwidth[6])
+	assert.InDelta(t, 75.0, *week.CacheHitRate[6], 0.01)
+
+	month := status.Trend.Month
+	require.Len(t, month.Bandwidth, 30)
+	require.NotNil(t, month.Bandwidth[10])
+	assert.Equal(t, int64(20), *month.Bandwidth[10])
+
+	_, err = svc.GetStatus("unknown.com")
+	assert.ErrorIs(t, err, state.ErrStateNotFound)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// trendDays are the lengths of the trends reported for a domain
+const (
+	weekTrendDays  = 7
+	monthTrendDays = 30
+)
+
+// DomainTrend holds a domain's usage over the last 7 and 30 days
+type DomainTrend struct {
+	Week  Trend `json:"7d"`
+	Month Trend `json:"30d"`
+}
+
+// Trend is one value per day from From up to yesterday, oldest first;
+// days without data are null
+type Trend struct {
+	From         string     `json:"from"`
+	Bandwidth    []*int64   `json:"bandwidth"`
+	CacheHitRate []*float64 `json:"cache_hit_rate"`
+}
+
+// domainTrend builds the 7 and 30 day trends of a pull zone from its daily
+// snapshots. Days are those of the snapshots' time zone, so the trends line
+// up with the daily summaries
+func domainTrend(snapshots Snapshots, zoneID int64, now time.Time) *DomainTrend {
+	daily := make(map[string]state.BandwidthSnapshot)
+	loc := time.UTC
+	for _, snap := range snapshots.GetSnapshotsByZone(zoneID, now.AddDate(0, 0, -monthTrendDays-2)) {
+		// Only snapshots of a single day; weekly periods would not fit and
+		// hours would replace their day
+		if !snap.Daily() {
+			continue
+		}
+		daily[snap.From.Format("2006-01-02")] = snap
+		loc = snap.From.Location()
+	}
+
+	return &DomainTrend{
+		Week:  trendFor(daily, now.In(loc), weekTrendDays),
+		Month: trendFor(daily, now.In(loc), monthTrendDays),
+	}
+}
+
+// trendFor returns the trend of the days days before today
+func trendFor(daily map[string]state.BandwidthSnapshot, today time.Time, days int) Trend {
+	trend := Trend{
+		From:         today.AddDate(0, 0, -days).Format("2006-01-02"),
+		Bandwidth:    make([]*int64, days),
+		CacheHitRate: make([]*float64, days),
+	}
+	for i := 0; i < days; i++ {
+		snap, ok := daily[today.AddDate(0, 0, i-days).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		bandwidth := snap.Bandwidth
+		trend.Bandwidth[i] = &bandwidth
+		if total := snap.CacheHits + snap.CacheMisses; total > 0 {
+			rate := float64(snap.CacheHits) / float64(total) * 100
+			trend.CacheHitRate[i] = &rate
+		}
+	}
+	return trend
+}
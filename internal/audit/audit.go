@@ -0,0 +1,118 @@
+// Package audit records changes made through the management API so abuse
+// and support teams can see who changed what on which domain
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Entry is one audited change
+type Entry struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	// Token names the API token a change was made with
+	Token      string      `json:"token,omitempty"`
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+	Action     string      `json:"action"`
+	Domain     string      `json:"domain,omitempty"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+// Log appends entries as JSON lines to a file
+type Log struct {
+	filePath string
+	mu       sync.Mutex
+	logger   *zap.Logger
+}
+
+// NewLog creates an audit log backed by filePath
+func NewLog(filePath string, logger *zap.Logger) (*Log, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	return &Log{
+		filePath: filePath,
+		logger:   logger,
+	}, nil
+}
+
+// Record appends an entry, stamping the time if unset
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	l.logger.Info("audit",
+		zap.String("actor", entry.Actor),
+		zap.String("action", entry.Action),
+		zap.String("domain", entry.Domain),
+	)
+	return nil
+}
+
+// Entries returns the most recent entries, oldest first; limit <= 0 returns all
+// Details are returned as decoded JSON
+func (l *Log) Entries(limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			l.logger.Warn("Skipping malformed audit entry", zap.Error(err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLog_RecordAndEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := NewLog(path, zap.NewNop())
+	require.NoError(t, err)
+
+	entries, err := l.Entries(0)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for _, action := range []string{"first", "second", "third"} {
+		require.NoError(t, l.Record(Entry{
+			Actor:   "abuse-team",
+			Action:  action,
+			Domain:  "example.com",
+			Details: map[string]interface{}{"blocked_countries": []string{"CN"}},
+		}))
+	}
+
+	entries, err = l.Entries(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "first", entries[0].Action)
+	assert.Equal(t, "abuse-team", entries[0].Actor)
+	assert.False(t, entries[0].Time.IsZero())
+
+	entries, err = l.Entries(2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "second", entries[0].Action)
+	assert.Equal(t, "third", entries[1].Action)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestLog_SkipsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0640))
+
+	l, err := NewLog(path, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, l.Record(Entry{Actor: "api", Action: "update"}))
+
+	entries, err := l.Entries(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "update", entries[0].Action)
+}
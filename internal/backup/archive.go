@@ -0,0 +1,281 @@
+// Package backup archives the provisioning state, the audit log and the
+// DNS zones of provisioned domains, and keeps the archives, encrypted, in
+// a Bunny Storage zone or an S3-compatible bucket
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/zonefile"
+)
+
+// Entries of an archive
+const (
+	manifestEntry = "manifest.json"
+	// stateEntry holds the active states in the monolithic state.json
+	// format, which the state manager migrates on start
+	stateEntry   = "state.json"
+	archiveEntry = "state.archive.json"
+	zonesDir     = "zones/"
+)
+
+// DataFiles are the files next to the state file that are backed up: the
+// audit log and the changes operators made, which cannot be derived again
+// from WHM and Bunny
+var DataFiles = []string{"audit.log", "overrides.json", "freeze.json", "bypass.json", "dns_records.json", "token_keys.json", "certificates.json", "incidents.json", "maintenance.json", "pause.json", "api_tokens.json"}
+
+// Manifest describes the contents of a backup
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Domains is how many provisioning states were backed up
+	Domains int `json:"domains"`
+	// Files are the state and data files backed up
+	Files []string `json:"files"`
+	// Zones are the domains whose DNS zone was exported
+	Zones []string `json:"zones,omitempty"`
+	// ZoneErrors lists the zones that could not be exported, as
+	// "domain: error"
+	ZoneErrors []string `json:"zone_errors,omitempty"`
+}
+
+// ZoneExporter returns the DNS zone of a domain
+type ZoneExporter func(ctx context.Context, domain string) (*zonefile.Zone, error)
+
+// Archiver builds backup archives of the files of one installation
+type Archiver struct {
+	states    *state.Manager
+	stateFile string
+	// zones is nil when zones are not backed up
+	zones  ZoneExporter
+	logger *zap.Logger
+}
+
+// NewArchiver creates an archiver of states and the data files next to
+// stateFile. With zones set, the DNS zone of each provisioned domain is
+// exported into the archive
+func NewArchiver(states *state.Manager, stateFile string, zones ZoneExporter, logger *zap.Logger) *Archiver {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Archiver{states: states, stateFile: stateFile, zones: zones, logger: logger}
+}
+
+// Build writes a gzipped tar archive of the states, the state archive, the
+// data files and the DNS zones. A zone that cannot be exported is listed
+// in the manifest instead of failing the backup
+func (a *Archiver) Build(ctx context.Context, now time.Time) ([]byte, Manifest, error) {
+	manifest := Manifest{CreatedAt: now.UTC()}
+	files := make(map[string][]byte)
+
+	states := a.states.ListAll()
+	sort.Slice(states, func(i, j int) bool { return states[i].Domain < states[j].Domain })
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return nil, manifest, fmt.Errorf("failed to marshal states: %w", err)
+	}
+	files[stateEntry] = data
+	manifest.Domains = len(states)
+
+	// The state archive and data files are copied as they are; encrypted
+	// ones need the same key when restored
+	copies := map[string]string{archiveEntry: state.ArchivePath(a.stateFile)}
+	for _, name := range DataFiles {
+		copies[name] = filepath.Join(filepath.Dir(a.stateFile), name)
+	}
+	for entry, path := range copies {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, manifest, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files[entry] = data
+	}
+
+	if a.zones != nil {
+		for _, st := range states {
+			if st.IsSubdomain() || st.ZoneID <= 0 {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, manifest, err
+			}
+			zone, err := a.zones(ctx, st.Domain)
+			if err == nil {
+				var buf bytes.Buffer
+				if err = zonefile.Write(&buf, zone); err == nil {
+					files[zonesDir+st.Domain+".zone"] = buf.Bytes()
+					manifest.Zones = append(manifest.Zones, st.Domain)
+					continue
+				}
+			}
+			a.logger.Warn("failed to export DNS zone for backup", zap.String("domain", st.Domain), zap.Error(err))
+			manifest.ZoneErrors = append(manifest.ZoneErrors, st.Domain+": "+err.Error())
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+		if !strings.HasPrefix(name, zonesDir) {
+			manifest.Files = append(manifest.Files, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(manifest.Files)
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, manifest, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(manifestEntry, data); err != nil {
+		return nil, manifest, fmt.Errorf("failed to write archive: %w", err)
+	}
+	for _, name := range names {
+		if err := write(name, files[name]); err != nil {
+			return nil, manifest, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, manifest, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, manifest, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return buf.Bytes(), manifest, nil
+}
+
+// Archive is a backup read back
+type Archive struct {
+	Manifest Manifest
+	// files holds the contents of the entries by name
+	files map[string][]byte
+}
+
+// ReadArchive reads a gzipped tar archive written by Build
+func ReadArchive(data []byte) (*Archive, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("backup is not a gzipped archive: %w", err)
+	}
+	defer gz.Close()
+
+	a := &Archive{files: make(map[string][]byte)}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		a.files[header.Name] = data
+	}
+
+	manifest, ok := a.files[manifestEntry]
+	if !ok {
+		return nil, fmt.Errorf("backup has no %s", manifestEntry)
+	}
+	if err := json.Unmarshal(manifest, &a.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestEntry, err)
+	}
+	return a, nil
+}
+
+// Zone returns the DNS zone of domain exported into the archive
+func (a *Archive) Zone(domain string) (*zonefile.Zone, error) {
+	data, ok := a.files[zonesDir+domain+".zone"]
+	if !ok {
+		return nil, fmt.Errorf("backup has no zone of %s", domain)
+	}
+	return zonefile.Parse(bytes.NewReader(data), domain)
+}
+
+// Restore writes the states, the state archive and the data files of the
+// archive next to stateFile, for the server to load on its next start. The
+// state directory and the files replaced are kept, renamed with the suffix
+// .before-restore-<time>. It returns the files written
+func (a *Archive) Restore(stateFile string, now time.Time) ([]string, error) {
+	if _, ok := a.files[stateEntry]; !ok {
+		return nil, fmt.Errorf("backup has no %s", stateEntry)
+	}
+	suffix := ".before-restore-" + now.UTC().Format("20060102T150405Z")
+
+	// The state manager migrates state.json only when there is no state
+	// directory
+	dir := state.RecordsDir(stateFile)
+	if err := os.Rename(dir, dir+suffix); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to move the state directory aside: %w", err)
+	}
+
+	targets := map[string]string{
+		stateEntry:   stateFile,
+		archiveEntry: state.ArchivePath(stateFile),
+	}
+	for _, name := range DataFiles {
+		targets[name] = filepath.Join(filepath.Dir(stateFile), name)
+	}
+	entries := make([]string, 0, len(targets))
+	for entry := range targets {
+		if _, ok := a.files[entry]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+
+	var written []string
+	for _, entry := range entries {
+		path := targets[entry]
+		if err := os.Rename(path, path+suffix); err != nil && !os.IsNotExist(err) {
+			return written, fmt.Errorf("failed to move %s aside: %w", path, err)
+		}
+		if err := os.WriteFile(path, a.files[entry], 0600); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// ZoneDomains returns the domains whose zone the archive holds
+func (a *Archive) ZoneDomains() []string {
+	var domains []string
+	for name := range a.files {
+		if domain, ok := strings.CutPrefix(name, zonesDir); ok {
+			domains = append(domains, strings.TrimSuffix(domain, ".zone"))
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+)
+
+// Backup names are whm2bunny-<time>.tar.gz.enc, sorting by time
+const (
+	namePrefix = "whm2bunny-"
+	nameSuffix = ".tar.gz.enc"
+	nameTime   = "20060102T150405Z"
+)
+
+// Name returns the name of a backup made at t
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format(nameTime) + nameSuffix
+}
+
+// isBackup reports whether name is the name of a backup
+func isBackup(name string) bool {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return false
+	}
+	stamp, ok = strings.CutSuffix(stamp, nameSuffix)
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(nameTime, stamp)
+	return err == nil
+}
+
+// Result is the outcome of one backup
+type Result struct {
+	Name     string   `json:"name"`
+	Size     int      `json:"size"`
+	Manifest Manifest `json:"manifest"`
+	// Pruned are the backups deleted past the retention
+	Pruned []string `json:"pruned,omitempty"`
+}
+
+// Manager uploads encrypted backups to a remote storage and keeps the
+// last ones of them
+type Manager struct {
+	archiver  *Archiver
+	remote    Remote
+	keyring   *encryption.Keyring
+	prefix    string
+	retention int
+	logger    *zap.Logger
+}
+
+// NewManager creates a manager uploading the archives of archiver,
+// encrypted with keyring, to the prefix directory of remote, keeping
+// retention backups. remote may be nil when backups are only built
+func NewManager(archiver *Archiver, remote Remote, keyring *encryption.Keyring, prefix string, retention int, logger *zap.Logger) *Manager {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Manager{
+		archiver:  archiver,
+		remote:    remote,
+		keyring:   keyring,
+		prefix:    strings.Trim(prefix, "/"),
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+func (m *Manager) path(name string) string {
+	if m.prefix == "" {
+		return name
+	}
+	return m.prefix + "/" + name
+}
+
+// Run makes a backup, uploads it and deletes the backups past the
+// retention. A failure to delete an old backup is logged and does not
+// fail the run
+func (m *Manager) Run(ctx context.Context, now time.Time) (*Result, error) {
+	sealed, manifest, err := m.Build(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Name: Name(now), Size: len(sealed), Manifest: manifest}
+	if err := m.remote.Put(ctx, m.path(result.Name), sealed); err != nil {
+		return nil, fmt.Errorf("failed to upload backup: %w", err)
+	}
+	m.logger.Info("backup uploaded",
+		zap.String("name", result.Name),
+		zap.Int("size", result.Size),
+		zap.Int("domains", manifest.Domains),
+		zap.Int("zones", len(manifest.Zones)),
+		zap.Int("zone_errors", len(manifest.ZoneErrors)),
+	)
+
+	backups, err := m.List(ctx)
+	if err != nil {
+		m.logger.Warn("failed to list backups to prune", zap.Error(err))
+		return result, nil
+	}
+	for len(backups) > m.retention {
+		old := backups[len(backups)-1]
+		backups = backups[:len(backups)-1]
+		if err := m.remote.Delete(ctx, m.path(old.Name)); err != nil {
+			m.logger.Warn("failed to delete old backup", zap.String("name", old.Name), zap.Error(err))
+			continue
+		}
+		result.Pruned = append(result.Pruned, old.Name)
+	}
+	return result, nil
+}
+
+// Build makes a backup, encrypted, without uploading it
+func (m *Manager) Build(ctx context.Context, now time.Time) ([]byte, Manifest, error) {
+	if !m.keyring.Encrypting() {
+		return nil, Manifest{}, fmt.Errorf("backups require encryption.enabled")
+	}
+	data, manifest, err := m.archiver.Build(ctx, now)
+	if err != nil {
+		return nil, manifest, err
+	}
+	sealed, err := m.keyring.Encrypt(data)
+	if err != nil {
+		return nil, manifest, fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+	return sealed, manifest, nil
+}
+
+// List returns the backups in the storage, newest first
+func (m *Manager) List(ctx context.Context) ([]Object, error) {
+	objects, err := m.remote.List(ctx, m.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []Object
+	for _, o := range objects {
+		if isBackup(o.Name) {
+			backups = append(backups, o)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Fetch downloads and decrypts the backup name, or the latest one when
+// name is empty
+func (m *Manager) Fetch(ctx context.Context, name string) (string, *Archive, error) {
+	if name == "" {
+		backups, err := m.List(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(backups) == 0 {
+			return "", nil, fmt.Errorf("no backups in the storage")
+		}
+		name = backups[0].Name
+	}
+	if !isBackup(name) {
+		return "", nil, fmt.Errorf("%q is not the name of a backup", name)
+	}
+
+	sealed, err := m.remote.Get(ctx, m.path(name))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download backup %s: %w", name, err)
+	}
+	a, err := Open(sealed, m.keyring)
+	if err != nil {
+		return "", nil, fmt.Errorf("backup %s: %w", name, err)
+	}
+	return name, a, nil
+}
+
+// Open decrypts a backup with keyring and reads it
+func Open(data []byte, keyring *encryption.Keyring) (*Archive, error) {
+	plain, err := keyring.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	return ReadArchive(plain)
+}
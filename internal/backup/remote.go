@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// DefaultTimeout bounds one request to the storage, an upload included
+const DefaultTimeout = 5 * time.Minute
+
+// Object is a backup stored remotely
+type Object struct {
+	// Name is the file name of the backup, without the prefix
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time,omitempty"`
+}
+
+// Remote is the storage backups are uploaded to. Names are relative to
+// the storage's root
+type Remote interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	// List returns the objects in the directory dir
+	List(ctx context.Context, dir string) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// NewRemote creates the remote storage selected by cfg
+func NewRemote(cfg config.BackupConfig, client *http.Client) (Remote, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	switch cfg.Storage {
+	case config.BackupStorageBunny:
+		return &bunnyStorage{
+			endpoint: strings.TrimSuffix(cfg.BunnyStorage.Endpoint, "/"),
+			zone:     cfg.BunnyStorage.Zone,
+			password: cfg.BunnyStorage.Password,
+			client:   client,
+		}, nil
+	case config.BackupStorageS3:
+		return newS3(cfg.S3, client), nil
+	default:
+		return nil, fmt.Errorf("unknown backup storage %q", cfg.Storage)
+	}
+}
+
+// bunnyStorage is a Bunny Storage zone, through its HTTP storage API
+type bunnyStorage struct {
+	endpoint string
+	zone     string
+	password string
+	client   *http.Client
+}
+
+// storageObject is a file or directory listed by the storage API
+type storageObject struct {
+	ObjectName  string `json:"ObjectName"`
+	Length      int64  `json:"Length"`
+	LastChanged string `json:"LastChanged"`
+	IsDirectory bool   `json:"IsDirectory"`
+}
+
+func (s *bunnyStorage) url(name string) string {
+	return s.endpoint + "/" + url.PathEscape(s.zone) + "/" + escapePath(name)
+}
+
+func (s *bunnyStorage) do(ctx context.Context, method, target string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("AccessKey", s.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return send(s.client, req)
+}
+
+func (s *bunnyStorage) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, s.url(name), data)
+	return err
+}
+
+func (s *bunnyStorage) Get(ctx context.Context, name string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, s.url(name), nil)
+}
+
+func (s *bunnyStorage) Delete(ctx context.Context, name string) error {
+	_, err := s.do(ctx, http.MethodDelete, s.url(name), nil)
+	return err
+}
+
+func (s *bunnyStorage) List(ctx context.Context, dir string) ([]Object, error) {
+	target := s.url(strings.Trim(dir, "/"))
+	if !strings.HasSuffix(target, "/") {
+		target += "/"
+	}
+	body, err := s.do(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		var remoteErr *RemoteError
+		if errors.As(err, &remoteErr) && remoteErr.StatusCode == http.StatusNotFound {
+			// Nothing was uploaded yet
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var listed []storageObject
+	if err := json.Unmarshal(body, &listed); err != nil {
+		return nil, fmt.Errorf("failed to parse storage listing: %w", err)
+	}
+	var objects []Object
+	for _, o := range listed {
+		if o.IsDirectory {
+			continue
+		}
+		// LastChanged has no zone and is in UTC
+		changed, _ := time.Parse("2006-01-02T15:04:05", strings.SplitN(o.LastChanged, ".", 2)[0])
+		objects = append(objects, Object{Name: o.ObjectName, Size: o.Length, Time: changed})
+	}
+	return objects, nil
+}
+
+// RemoteError is a storage request answered with an error status
+type RemoteError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *RemoteError) Error() string {
+	msg := fmt.Sprintf("%s %s: HTTP %d", e.Method, e.URL, e.StatusCode)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// send performs req, returning the body of a successful response and a
+// RemoteError otherwise
+func send(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The URL is logged without its query, which may carry a token
+		u := *req.URL
+		u.RawQuery = ""
+		text := strings.TrimSpace(string(body))
+		if len(text) > 200 {
+			text = text[:200]
+		}
+		return nil, &RemoteError{Method: req.Method, URL: u.String(), StatusCode: resp.StatusCode, Body: text}
+	}
+	return body, nil
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,148 @@
+package balance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// Fetcher retrieves Bunny account billing information
+type Fetcher interface {
+	GetAccountStatistics(ctx context.Context) (*bunny.AccountStats, error)
+}
+
+// Status is the last known balance check result
+type Status struct {
+	Balance   float64   `json:"balance"`
+	Threshold float64   `json:"threshold"`
+	Low       bool      `json:"low"`
+	Paused    bool      `json:"pull_zones_paused"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Guard watches the Bunny account balance and pauses pull zone
+// creation while it is below the configured threshold
+type Guard struct {
+	fetcher Fetcher
+	cfg     config.BalanceConfig
+	status  Status
+	mu      sync.Mutex
+	logger  *zap.Logger
+	now     func() time.Time
+}
+
+// NewGuard creates a balance guard
+func NewGuard(fetcher Fetcher, cfg config.BalanceConfig, logger *zap.Logger) *Guard {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = config.DefaultBalanceCheckInterval
+	}
+
+	return &Guard{
+		fetcher: fetcher,
+		cfg:     cfg,
+		status:  Status{Threshold: cfg.Threshold},
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// Enabled returns whether the guard is active
+func (g *Guard) Enabled() bool {
+	return g.cfg.Enabled
+}
+
+// Status returns the last known balance status without calling the API
+func (g *Guard) Status() Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// Refresh fetches the current balance from Bunny
+// If the API call fails the previous low/ok verdict is kept, so a
+// transient error neither pauses nor resumes pull zone creation
+func (g *Guard) Refresh(ctx context.Context) Status {
+	stats, err := g.fetcher.GetAccountStatistics(ctx)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.status.CheckedAt = g.now()
+	if err != nil {
+		g.logger.Warn("Failed to check Bunny balance", zap.Error(err))
+		g.status.Error = err.Error()
+		return g.status
+	}
+
+	g.status.Error = ""
+	g.status.Balance = stats.Balance
+	g.status.Low = stats.Balance < g.cfg.Threshold
+	g.status.Paused = g.status.Low && g.cfg.PausePullZones
+
+	if g.status.Low {
+		g.logger.Warn("Bunny balance below threshold",
+			zap.Float64("balance", stats.Balance),
+			zap.Float64("threshold", g.cfg.Threshold),
+			zap.Bool("pull_zones_paused", g.status.Paused),
+		)
+	}
+
+	return g.status
+}
+
+// Check returns the balance status, refreshing it when older than the check interval
+func (g *Guard) Check(ctx context.Context) Status {
+	if !g.cfg.Enabled {
+		return Status{Threshold: g.cfg.Threshold}
+	}
+
+	g.mu.Lock()
+	fresh := !g.status.CheckedAt.IsZero() && g.now().Sub(g.status.CheckedAt) < g.cfg.CheckInterval
+	status := g.status
+	g.mu.Unlock()
+
+	if fresh {
+		return status
+	}
+	return g.Refresh(ctx)
+}
+
+// PullZonesPaused reports whether new pull zones should not be created
+func (g *Guard) PullZonesPaused(ctx context.Context) bool {
+	return g.Check(ctx).Paused
+}
+
+// Watch refreshes the balance every check interval until ctx is cancelled,
+// calling fn whenever the balance crosses the threshold in either direction
+func (g *Guard) Watch(ctx context.Context, fn func(prev, cur Status)) {
+	if !g.cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(g.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	prev := g.Status()
+	for {
+		cur := g.Refresh(ctx)
+		if cur.Low != prev.Low && fn != nil {
+			fn(prev, cur)
+		}
+		prev = cur
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+type fakeFetcher struct {
+	balance float64
+	err     error
+	calls   int
+}
+
+func (f *fakeFetcher) GetAccountStatistics(ctx context.Context) (*bunny.AccountStats, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &bunny.AccountStats{Balance: f.balance}, nil
+}
+
+func newTestGuard(f *fakeFetcher, pause bool) *Guard {
+	return NewGuard(f, config.BalanceConfig{
+		Enabled:        true,
+		Threshold:      10,
+		PausePullZones: pause,
+		CheckInterval:  time.Minute,
+	}, zap.NewNop())
+}
+
+func TestGuard_Disabled(t *testing.T) {
+	f := &fakeFetcher{balance: 0}
+	g := NewGuard(f, config.BalanceConfig{Threshold: 10, PausePullZones: true}, zap.NewNop())
+
+	assert.False(t, g.PullZonesPaused(context.Background()))
+	assert.Equal(t, 0, f.calls)
+}
+
+func TestGuard_LowBalancePauses(t *testing.T) {
+	f := &fakeFetcher{balance: 5}
+	g := newTestGuard(f, true)
+
+	status := g.Check(context.Background())
+	assert.True(t, status.Low)
+	assert.True(t, status.Paused)
+	assert.Equal(t, 5.0, status.Balance)
+	assert.True(t, g.PullZonesPaused(context.Background()))
+}
+
+func TestGuard_AlertOnlyWhenPauseDisabled(t *testing.T) {
+	g := newTestGuard(&fakeFetcher{balance: 5}, false)
+
+	status := g.Check(context.Background())
+	assert.True(t, status.Low)
+	assert.False(t, status.Paused)
+}
+
+func TestGuard_CachesWithinInterval(t *testing.T) {
+	f := &fakeFetcher{balance: 50}
+	g := newTestGuard(f, true)
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	g.Check(context.Background())
+	g.Check(context.Background())
+	assert.Equal(t, 1, f.calls)
+
+	now = now.Add(2 * time.Minute)
+	g.Check(context.Background())
+	assert.Equal(t, 2, f.calls)
+}
+
+func TestGuard_KeepsVerdictOnError(t *testing.T) {
+	f := &fakeFetcher{balance: 5}
+	g := newTestGuard(f, true)
+	g.Refresh(context.Background())
+
+	f.err = errors.New("api down")
+	status := g.Refresh(context.Background())
+	assert.True(t, status.Paused)
+	assert.Equal(t, "api down", status.Error)
+}
+
+func TestGuard_WatchReportsTransitions(t *testing.T) {
+	f := &fakeFetcher{balance: 5}
+	g := newTestGuard(f, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan Status, 1)
+	go g.Watch(ctx, func(prev, cur Status) {
+		changes <- cur
+		cancel()
+	})
+
+	select {
+	case cur := <-changes:
+		assert.True(t, cur.Low)
+	case <-time.After(time.Second):
+		t.Fatal("expected a low balance transition")
+	}
+}
@@ -0,0 +1,207 @@
+// Package bench runs synthetic provisioning against the in-memory Bunny API
+// of bunnytest, so performance changes to the provisioner and the state
+// store can be measured before they reach production hosts
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bunny/bunnytest"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/slo"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// originIP is the origin of the synthetic domains, from the documentation
+// range
+const originIP = "192.0.2.10"
+
+// Options configures a benchmark run
+type Options struct {
+	// Domains is how many domains are provisioned
+	Domains int
+	// Concurrency is how many provisions run at once
+	Concurrency int
+	// APILatency delays every response of the fake Bunny API, to simulate
+	// the round trip to the real one
+	APILatency time.Duration
+	// Dir holds the state of the run, which starts empty
+	Dir string
+	// Logger receives the provisioning logs; nil discards them
+	Logger *zap.Logger
+}
+
+// Percentiles summarizes a set of durations
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	Max time.Duration `json:"max"`
+}
+
+// Report is the outcome of a benchmark run
+type Report struct {
+	Domains     int           `json:"domains"`
+	Concurrency int           `json:"concurrency"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	Elapsed     time.Duration `json:"elapsed"`
+	// Throughput is in provisions per second
+	Throughput float64 `json:"throughput"`
+	// Latency is the time of a provision once a worker took it
+	Latency Percentiles `json:"latency"`
+	// QueueWait is the time a domain waited for a free worker
+	QueueWait Percentiles `json:"queue_wait"`
+	// APICalls is how many requests the fake Bunny API served
+	APICalls int `json:"api_calls"`
+	// StateWrites counts the state records written
+	StateWrites state.WriteStats `json:"state_writes"`
+	// Errors holds the distinct provisioning errors with their count
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// PerDomain returns n divided by the number of domains provisioned
+func (r *Report) PerDomain(n int64) float64 {
+	if r.Domains == 0 {
+		return 0
+	}
+	return float64(n) / float64(r.Domains)
+}
+
+// result is the outcome of one provision
+type result struct {
+	wait, took time.Duration
+	err        error
+}
+
+// Run provisions opts.Domains synthetic domains with opts.Concurrency
+// workers against a fake Bunny API started for the run. Every domain is
+// queued when the run starts. A cancelled ctx stops the workers from taking
+// further domains
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Domains < 1 {
+		return nil, errors.New("domains must be at least 1")
+	}
+	if opts.Concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if opts.Dir == "" {
+		return nil, errors.New("a state directory is required")
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	fake := bunnytest.NewServer()
+	defer fake.Close()
+	fake.SetLatency(opts.APILatency)
+
+	cfg := config.Defaults()
+	cfg.Bunny.APIKey = "bench"
+	cfg.Bunny.BaseURL = fake.URL
+	cfg.Origin.IP = originIP
+
+	client := bunny.NewClient(cfg.Bunny.APIKey,
+		bunny.WithBaseURL(cfg.Bunny.BaseURL),
+		bunny.WithLogger(opts.Logger),
+	)
+	stateMgr, err := state.NewManager(filepath.Join(opts.Dir, "state.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state: %w", err)
+	}
+	if n := stateMgr.GetCount(); n > 0 {
+		return nil, fmt.Errorf("state directory %s already holds %d states", opts.Dir, n)
+	}
+
+	prov := provisioner.NewProvisioner(&cfg, client, stateMgr, &notifier.TelegramNotifier{}, opts.Logger)
+	// The fake API's CDN hostnames do not resolve
+	prov.SetLookupHost(func(ctx context.Context, host string) ([]string, error) {
+		return []string{originIP}, nil
+	})
+
+	domains := make(chan string, opts.Domains)
+	for i := 1; i <= opts.Domains; i++ {
+		domains <- fmt.Sprintf("bench%05d.example.com", i)
+	}
+	close(domains)
+
+	var (
+		mu      sync.Mutex
+		results []result
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range domains {
+				if ctx.Err() != nil {
+					return
+				}
+				taken := time.Now()
+				err := prov.Provision(domain, "bench")
+
+				mu.Lock()
+				results = append(results, result{wait: taken.Sub(start), took: time.Since(taken), err: err})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &Report{
+		Domains:     len(results),
+		Concurrency: opts.Concurrency,
+		Elapsed:     elapsed,
+		APICalls:    fake.TotalCalls(),
+		StateWrites: stateMgr.WriteStats(),
+	}
+	waits := make([]time.Duration, 0, len(results))
+	took := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		waits = append(waits, r.wait)
+		took = append(took, r.took)
+		if r.err != nil {
+			report.Failed++
+			if report.Errors == nil {
+				report.Errors = make(map[string]int)
+			}
+			report.Errors[r.err.Error()]++
+			continue
+		}
+		report.Succeeded++
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Succeeded) / elapsed.Seconds()
+	}
+	report.Latency = percentiles(took)
+	report.QueueWait = percentiles(waits)
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// percentiles summarizes durations
+func percentiles(durations []time.Duration) Percentiles {
+	p := Percentiles{
+		P50: slo.Percentile(durations, 50),
+		P95: slo.Percentile(durations, 95),
+	}
+	for _, d := range durations {
+		p.Max = max(p.Max, d)
+	}
+	return p
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(context.Background(), Options{Domains: 12, Concurrency: 3, Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	assert.Equal(t, 12, report.Domains)
+	assert.Equal(t, 12, report.Succeeded)
+	assert.Zero(t, report.Failed)
+	assert.Empty(t, report.Errors)
+	assert.Positive(t, report.Throughput)
+	assert.Positive(t, report.Latency.P95)
+	assert.LessOrEqual(t, report.Latency.P50, report.Latency.P95)
+	assert.LessOrEqual(t, report.Latency.P95, report.Latency.Max)
+	assert.LessOrEqual(t, report.QueueWait.P95, report.QueueWait.Max)
+	assert.Positive(t, report.APICalls)
+	// Every provision writes its state more than once
+	assert.Greater(t, report.StateWrites.Writes, int64(12))
+	assert.Positive(t, report.StateWrites.Bytes)
+	assert.Greater(t, report.PerDomain(report.StateWrites.Writes), 1.0)
+}
+
+func TestRun_Options(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []Options{
+		{Domains: 0, Concurrency: 1, Dir: t.TempDir()},
+		{Domains: 1, Concurrency: 0, Dir: t.TempDir()},
+		{Domains: 1, Concurrency: 1},
+	} {
+		_, err := Run(ctx, opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+
+	// A directory holding states from another run is refused
+	dir := t.TempDir()
+	_, err := Run(ctx, Options{Domains: 1, Concurrency: 1, Dir: dir})
+	require.NoError(t, err)
+	_, err = Run(ctx, Options{Domains: 1, Concurrency: 1, Dir: dir})
+	assert.ErrorContains(t, err, "already holds")
+	_, err = os.Stat(filepath.Join(dir, "state.d"))
+	assert.NoError(t, err)
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := Run(ctx, Options{Domains: 5, Concurrency: 2, Dir: t.TempDir()})
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, report)
+	assert.Zero(t, report.Domains)
+}
@@ -0,0 +1,234 @@
+// Package bot answers Telegram commands from the operators' chat with the
+// operations of the application service
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+const (
+	// defaultReportDays is the period of /report without a day count
+	defaultReportDays = 7
+	// maxReportDays bounds the period of /report
+	maxReportDays = 90
+	// commandTimeout bounds the Bunny API calls of one command
+	commandTimeout = time.Minute
+)
+
+// Service performs the operations shared with the CLI and the API
+type Service interface {
+	GetStatus(domain string) (*app.Status, error)
+	Retry(domain string) (*state.ProvisionState, error)
+	Purge(ctx context.Context, domain string, urls []string) error
+	Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error)
+}
+
+// Commands answers bot commands
+type Commands struct {
+	service   Service
+	incidents *incident.Store
+}
+
+// NewCommands creates the command handler over service
+func NewCommands(service Service) *Commands {
+	return &Commands{service: service}
+}
+
+// SetIncidents enables /incidents and /ack over store
+func (c *Commands) SetIncidents(store *incident.Store) {
+	c.incidents = store
+}
+
+// Handle answers one command; it is a notifier.CommandHandler
+func (c *Commands) Handle(ctx context.Context, command string, args []string) string {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	switch command {
+	case "status":
+		if len(args) != 1 {
+			return usage("/status &lt;domain&gt;")
+		}
+		return c.status(args[0])
+	case "retry":
+		if len(args) != 1 {
+			return usage("/retry &lt;domain&gt;")
+		}
+		return c.retry(args[0])
+	case "purge":
+		if len(args) < 1 {
+			return usage("/purge &lt;domain&gt; [url...]")
+		}
+		return c.purge(ctx, args[0], args[1:])
+	case "report":
+		if len(args) < 1 || len(args) > 2 {
+			return usage("/report &lt;domain&gt; [days]")
+		}
+		return c.report(ctx, args)
+	case "incidents":
+		if c.incidents == nil {
+			return i18n.T("bot.unknown", command)
+		}
+		return c.listIncidents()
+	case notifier.AckCommand:
+		if c.incidents == nil {
+			return i18n.T("bot.unknown", command)
+		}
+		if len(args) != 1 {
+			return usage("/ack &lt;id&gt;")
+		}
+		return c.ack(ctx, args[0])
+	case "help", "start":
+		return i18n.T("bot.help")
+	default:
+		return i18n.T("bot.unknown", html.EscapeString(command))
+	}
+}
+
+func (c *Commands) status(domain string) string {
+	status, err := c.service.GetStatus(domain)
+	if err != nil {
+		return failure(err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(status.Domain))
+	line(&b, i18n.T("doctor.status"), string(status.Status))
+	line(&b, i18n.T("state.step"), status.StepName())
+	if status.User != "" {
+		line(&b, i18n.T("state.user"), status.User)
+	}
+	if status.PullZoneID > 0 {
+		line(&b, i18n.T("doctor.pull_zone"), strconv.FormatInt(status.PullZoneID, 10))
+	}
+	if status.CDNHostname != "" {
+		line(&b, i18n.T("state.cdn_hostname"), status.CDNHostname)
+	}
+	if status.Error != "" {
+		line(&b, i18n.T("state.error"), status.Error)
+	}
+	if status.Trend != nil {
+		var total int64
+		for _, v := range status.Trend.Week.Bandwidth {
+			if v != nil {
+				total += *v
+			}
+		}
+		line(&b, i18n.T("bot.week_bandwidth"), formatGB(total))
+	}
+	return b.String()
+}
+
+func (c *Commands) retry(domain string) string {
+	st, err := c.service.Retry(domain)
+	if err != nil {
+		return failure(err)
+	}
+	return i18n.T("retry.scheduled", html.EscapeString(st.Domain))
+}
+
+func (c *Commands) purge(ctx context.Context, domain string, urls []string) string {
+	if err := c.service.Purge(ctx, domain, urls); err != nil {
+		return failure(err)
+	}
+	if len(urls) == 0 {
+		return i18n.T("purge.done", html.EscapeString(domain))
+	}
+	return i18n.T("purge.urls_done", len(urls), html.EscapeString(domain))
+}
+
+func (c *Commands) report(ctx context.Context, args []string) string {
+	days := defaultReportDays
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > maxReportDays {
+			return usage("/report &lt;domain&gt; [1-90]")
+		}
+		days = n
+	}
+
+	to := time.Now()
+	report, err := c.service.Report(ctx, args[0], to.AddDate(0, 0, -days), to)
+	if err != nil {
+		return failure(err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n", i18n.T("report.title", html.EscapeString(report.Domain), days))
+	line(&b, i18n.T("report.bandwidth"), formatGB(report.Bandwidth))
+	line(&b, i18n.T("report.requests"), notifier.FormatNumber(report.Requests))
+	line(&b, i18n.T("report.cache_hit_rate"), fmt.Sprintf("%.1f%%", report.CacheHitRate))
+	if report.DNSQueries != nil {
+		line(&b, i18n.T("report.dns_queries"), notifier.FormatNumber(*report.DNSQueries))
+	}
+	return b.String()
+}
+
+func (c *Commands) listIncidents() string {
+	active := c.incidents.Active()
+	if len(active) == 0 {
+		return i18n.T("incident.none")
+	}
+
+	var b strings.Builder
+	for _, inc := range active {
+		fmt.Fprintf(&b, "<b>%s</b> %s - %s\n", inc.ID, html.EscapeString(inc.Zone), i18n.T("incident.kind."+string(inc.Kind)))
+		line(&b, i18n.T("incident.value"), i18n.T("incident.value_text", inc.Value, inc.Threshold))
+		line(&b, i18n.T("incident.opened"), inc.OpenedAt.Format("2006-01-02 15:04"))
+		if inc.AckedBy != "" {
+			line(&b, i18n.T("incident.acked_by"), inc.AckedBy)
+		}
+	}
+	return b.String()
+}
+
+func (c *Commands) ack(ctx context.Context, id string) string {
+	by := notifier.Actor(ctx)
+	if by == "" {
+		by = "telegram"
+	}
+
+	inc, err := c.incidents.Ack(id, by)
+	if err != nil {
+		return failure(err)
+	}
+	if inc.AckedBy != by {
+		return i18n.T("incident.already_acked", inc.ID, html.EscapeString(inc.AckedBy))
+	}
+	return i18n.T("incident.acked", inc.ID)
+}
+
+// line writes an escaped "label: value" line
+func line(b *strings.Builder, label, value string) {
+	fmt.Fprintf(b, "%s: <code>%s</code>\n", html.EscapeString(label), html.EscapeString(value))
+}
+
+// usage returns the usage hint of a command
+func usage(syntax string) string {
+	return i18n.T("bot.usage", syntax)
+}
+
+// failure returns the reply for a failed command
+func failure(err error) string {
+	if errors.Is(err, state.ErrStateNotFound) {
+		return i18n.T("bot.not_found")
+	}
+	return "⚠️ " + html.EscapeString(err.Error())
+}
+
+// formatGB formats bytes as gigabytes, like the summaries
+func formatGB(bytes int64) string {
+	return fmt.Sprintf("%.2f GB", float64(bytes)/(1024*1024*1024))
+}
@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// fakeService answers from fixed states
+type fakeService struct {
+	states []*state.ProvisionState
+	purged []string
+	from   time.Time
+	to     time.Time
+}
+
+func (f *fakeService) get(domain string) (*state.ProvisionState, error) {
+	for _, st := range f.states {
+		if st.Domain == domain {
+			return st, nil
+		}
+	}
+	return nil, state.ErrStateNotFound
+}
+
+func (f *fakeService) GetStatus(domain string) (*app.Status, error) {
+	st, err := f.get(domain)
+	if err != nil {
+		return nil, err
+	}
+	week := make([]*int64, 7)
+	gb := int64(1024 * 1024 * 1024)
+	week[6] = &gb
+	return &app.Status{ProvisionState: st, Trend: &app.DomainTrend{Week: app.Trend{Bandwidth: week}}}, nil
+}
+
+func (f *fakeService) Retry(domain string) (*state.ProvisionState, error) {
+	st, err := f.get(domain)
+	if err != nil {
+		return nil, err
+	}
+	if st.Status != state.StatusFailed {
+		return nil, fmt.Errorf("%s: %w", domain, app.ErrNotFailed)
+	}
+	return st, nil
+}
+
+func (f *fakeService) Purge(ctx context.Context, domain string, urls []string) error {
+	if _, err := f.get(domain); err != nil {
+		return err
+	}
+	f.purged = urls
+	return nil
+}
+
+func (f *fakeService) Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error) {
+	f.from, f.to = from, to
+	return &app.Report{Domain: domain, Bandwidth: 2 * 1024 * 1024 * 1024, Requests: 1500, CacheHitRate: 87.5}, nil
+}
+
+func TestHandle(t *testing.T) {
+	svc := &fakeService{states: []*state.ProvisionState{
+		{ID: "1", Domain: "example.com", User: "exampleu", Status: state.StatusSuccess, PullZoneID: 42, CDNHostname: "example.b-cdn.net"},
+		{ID: "2", Domain: "broken.com", Status: state.StatusFailed, Error: "<dns> failed"},
+	}}
+	commands := NewCommands(svc)
+	ctx := context.Background()
+
+	t.Run("status", func(t *testing.T) {
+		reply := commands.Handle(ctx, "status", []string{"example.com"})
+		assert.Contains(t, reply, "<b>example.com</b>")
+		assert.Contains(t, reply, "example.b-cdn.net")
+		assert.Contains(t, reply, "1.00 GB")
+
+		reply = commands.Handle(ctx, "status", []string{"broken.com"})
+		assert.Contains(t, reply, "&lt;dns&gt; failed", "values are escaped")
+
+		assert.Equal(t, "Domain not found", commands.Handle(ctx, "status", []string{"unknown.com"}))
+		assert.Contains(t, commands.Handle(ctx, "status", nil), "Usage: /status")
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		assert.Equal(t, "Retry of broken.com scheduled", commands.Handle(ctx, "retry", []string{"broken.com"}))
+		assert.Contains(t, commands.Handle(ctx, "retry", []string{"example.com"}), "provision has not failed")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		assert.Equal(t, "Cache of example.com purged", commands.Handle(ctx, "purge", []string{"example.com"}))
+		assert.Equal(t, "Purged 2 URL(s) of example.com", commands.Handle(ctx, "purge", []string{"example.com", "/a.css", "/b.js"}))
+		assert.Equal(t, []string{"/a.css", "/b.js"}, svc.purged)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		reply := commands.Handle(ctx, "report", []string{"example.com", "30"})
+		assert.Contains(t, reply, "Traffic of example.com (last 30 days)")
+		assert.Contains(t, reply, "2.00 GB")
+		assert.Contains(t, reply, "87.5%")
+		assert.InDelta(t, 30*24*time.Hour, svc.to.Sub(svc.from), float64(2*time.Hour))
+
+		assert.Contains(t, commands.Handle(ctx, "report", []string{"example.com", "500"}), "Usage")
+	})
+
+	t.Run("help and unknown commands", func(t *testing.T) {
+		assert.Contains(t, commands.Handle(ctx, "help", nil), "/purge")
+		assert.Contains(t, commands.Handle(ctx, "deploy", nil), "Unknown command /deploy")
+	})
+}
+
+func TestHandle_Incidents(t *testing.T) {
+	commands := NewCommands(&fakeService{})
+	ctx := context.Background()
+	assert.Contains(t, commands.Handle(ctx, "incidents", nil), "Unknown command", "incidents are not tracked")
+
+	store, err := incident.NewStore(filepath.Join(t.TempDir(), "incidents.json"), nil)
+	require.NoError(t, err)
+	commands.SetIncidents(store)
+	assert.Equal(t, "No open incidents", commands.Handle(ctx, "incidents", nil))
+
+	_, _, err = store.Fire(incident.KindHitRate, 42, "morden-example-com", 32.5, 50)
+	require.NoError(t, err)
+
+	reply := commands.Handle(ctx, "incidents", nil)
+	assert.Contains(t, reply, "<b>INC-1</b> morden-example-com - low cache hit rate")
+	assert.Contains(t, reply, "32.5% (threshold 50%)")
+
+	assert.Equal(t, "Incident INC-1 acknowledged", commands.Handle(notifier.WithActor(ctx, "@alice"), "ack", []string{"inc-1"}))
+	assert.Equal(t, "Incident INC-1 was already acknowledged by @alice", commands.Handle(ctx, "ack", []string{"INC-1"}))
+	assert.Contains(t, commands.Handle(ctx, "ack", []string{"INC-7"}), "incident not found")
+	assert.Contains(t, commands.Handle(ctx, "ack", nil), "Usage: /ack")
+}
@@ -0,0 +1,415 @@
+// Package bulk provisions the domains listed in a CSV file, for agencies
+// onboarding many customers at once. Each row may override the origin IP,
+// CDN profile, geo zones and mail preset of its domain. The whole file is
+// validated before anything is provisioned, and the outcome of each row is
+// written back as CSV
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/validator"
+)
+
+// Columns are the columns a file may have, in any order; only domain is
+// required
+var Columns = []string{"domain", "parent", "user", "package", "origin_ip", "profile", "geo_zones", "mail_preset"}
+
+// ResultColumns are the columns of the results file
+var ResultColumns = []string{"line", "domain", "outcome", "error", "dns_zone_id", "pull_zone_id", "cdn_hostname"}
+
+// Row is one domain to provision
+type Row struct {
+	// Line is the line of the row in the file, the header being line 1
+	Line   int
+	Domain string
+	// Parent is set for subdomains; it must be listed on an earlier row or
+	// already be provisioned
+	Parent  string
+	User    string
+	Package string
+	// Overrides holds the origin IP, profile, geo zones and mail preset of
+	// the row; empty fields keep the domain's stored override
+	Overrides overrides.Override
+}
+
+// RowError is an invalid row
+type RowError struct {
+	Line   int
+	Domain string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	if e.Domain == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d (%s): %v", e.Line, e.Domain, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Errors are the invalid rows of a file, all reported at once
+type Errors []*RowError
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid rows:\n  %s", len(e), strings.Join(lines, "\n  "))
+}
+
+// Outcome is how provisioning a row ended
+type Outcome string
+
+const (
+	OutcomeProvisioned Outcome = "provisioned"
+	// OutcomeExists is a domain provisioned before; its row's overrides
+	// are not applied
+	OutcomeExists Outcome = "exists"
+	OutcomeFailed Outcome = "failed"
+	// OutcomeSkipped is a subdomain whose parent failed, or a row not
+	// reached before the run was cancelled
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// Result is the outcome of a row, with the Bunny IDs and CDN hostname of
+// its domain when it has them
+type Result struct {
+	Row         Row
+	Outcome     Outcome
+	Error       string
+	ZoneID      int64
+	PullZoneID  int64
+	CDNHostname string
+}
+
+// Provisioner provisions domains and reads their state; *app.Service
+// implements it
+type Provisioner interface {
+	ProvisionDomain(req app.ProvisionRequest) error
+	GetStatus(domain string) (*app.Status, error)
+}
+
+// Overrides writes per-domain overrides
+type Overrides interface {
+	Update(domain string, fn func(o *overrides.Override)) error
+}
+
+// Runner validates and provisions the rows of a file
+type Runner struct {
+	provisioner Provisioner
+	overrides   Overrides
+	profiles    config.ProfilesConfig
+	logger      *zap.Logger
+}
+
+// NewRunner creates a runner provisioning with prov and saving the rows'
+// overrides to o; profiles are the profiles rows may name
+func NewRunner(prov Provisioner, o Overrides, profiles config.ProfilesConfig, logger *zap.Logger) *Runner {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Runner{provisioner: prov, overrides: o, profiles: profiles, logger: logger}
+}
+
+// Parse reads and validates a CSV file whose first line names its
+// columns. Blank lines and lines starting with # are skipped. Every invalid
+// row is reported, as Errors, and no row is returned then
+func (r *Runner) Parse(in io.Reader) ([]Row, error) {
+	reader := csv.NewReader(in)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(Columns, name) {
+			return nil, fmt.Errorf("unknown column %q (expected %s)", name, strings.Join(Columns, ", "))
+		}
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("column %q is listed twice", name)
+		}
+		index[name] = i
+	}
+	if _, ok := index["domain"]; !ok {
+		return nil, fmt.Errorf("the domain column is missing")
+	}
+
+	var (
+		rows []Row
+		errs Errors
+	)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			errs = append(errs, &RowError{Line: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := Row{
+			Line:    line,
+			Domain:  domainname.Normalize(field("domain")),
+			Parent:  domainname.Normalize(field("parent")),
+			User:    field("user"),
+			Package: field("package"),
+			Overrides: overrides.Override{
+				OriginIP:   field("origin_ip"),
+				Profile:    field("profile"),
+				GeoZones:   splitList(field("geo_zones")),
+				MailPreset: strings.ToLower(field("mail_preset")),
+			},
+		}
+		rows = append(rows, row)
+	}
+
+	errs = append(errs, r.validate(rows)...)
+	if len(errs) > 0 {
+		slices.SortStableFunc(errs, func(a, b *RowError) int { return a.Line - b.Line })
+		return nil, errs
+	}
+	return rows, nil
+}
+
+// validate checks each row on its own, then the parents of the subdomains
+func (r *Runner) validate(rows []Row) Errors {
+	v := validator.NewValidatorWithConfig(&validator.ValidatorConfig{}, nil)
+
+	var errs Errors
+	fail := func(row Row, format string, args ...any) {
+		errs = append(errs, &RowError{Line: row.Line, Domain: row.Domain, Err: fmt.Errorf(format, args...)})
+	}
+
+	// position is where each domain is first listed
+	position := make(map[string]int, len(rows))
+	for i, row := range rows {
+		if _, ok := position[row.Domain]; !ok {
+			position[row.Domain] = i
+		}
+	}
+
+	listed := make(map[string]Row, len(rows))
+	for i, row := range rows {
+		if err := v.ValidateDomain(row.Domain); err != nil {
+			fail(row, "%v", err)
+			continue
+		}
+		if first, ok := listed[row.Domain]; ok {
+			fail(row, "already listed on line %d", first.Line)
+			continue
+		}
+		listed[row.Domain] = row
+
+		if err := row.Overrides.Validate(); err != nil {
+			fail(row, "%v", err)
+		}
+		if name := row.Overrides.Profile; name != "" {
+			if _, _, ok := r.profiles.Named(name); !ok {
+				fail(row, "unknown profile %q", name)
+			}
+		}
+
+		if row.Parent == "" {
+			continue
+		}
+		if label, ok := strings.CutSuffix(row.Domain, "."+row.Parent); !ok || label == "" {
+			fail(row, "not a subdomain of %s", row.Parent)
+			continue
+		}
+		if pos, ok := position[row.Parent]; ok {
+			// A parent listed after its subdomain is provisioned too late
+			if pos > i {
+				fail(row, "parent %s must be listed before its subdomains", row.Parent)
+			} else if parent, ok := listed[row.Parent]; ok && parent.Parent != "" {
+				fail(row, "parent %s is itself a subdomain", row.Parent)
+			}
+			continue
+		}
+		if st, err := r.provisioner.GetStatus(row.Parent); err != nil || st.Status != state.StatusSuccess {
+			fail(row, "parent %s is neither listed nor provisioned", row.Parent)
+		}
+	}
+	return errs
+}
+
+// Run provisions the rows in order, calling progress with the result of
+// each, and returns the results. A failed row does not stop the run, but
+// skips the subdomains of a failed domain
+func (r *Runner) Run(ctx context.Context, rows []Row, progress func(Result)) []Result {
+	results := make([]Result, 0, len(rows))
+	failed := make(map[string]bool)
+	for _, row := range rows {
+		var result Result
+		switch {
+		case ctx.Err() != nil:
+			result = Result{Row: row, Outcome: OutcomeSkipped, Error: ctx.Err().Error()}
+		case failed[row.Parent]:
+			result = Result{Row: row, Outcome: OutcomeSkipped, Error: fmt.Sprintf("parent %s failed", row.Parent)}
+		default:
+			result = r.provision(row)
+		}
+		if result.Outcome == OutcomeFailed || result.Outcome == OutcomeSkipped {
+			failed[row.Domain] = true
+		}
+		if progress != nil {
+			progress(result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// provision saves the overrides of a row and provisions its domain, unless
+// it was provisioned before
+func (r *Runner) provision(row Row) Result {
+	result := Result{Row: row}
+	if st, err := r.provisioner.GetStatus(row.Domain); err == nil && st.Status == state.StatusSuccess {
+		result.Outcome = OutcomeExists
+		result.setState(st.ProvisionState)
+		return result
+	}
+
+	// Overrides are read when the DNS records and pull zone are created
+	if o := row.Overrides; o.OriginIP != "" || o.Profile != "" || len(o.GeoZones) > 0 || o.MailPreset != "" {
+		err := r.overrides.Update(row.Domain, func(stored *overrides.Override) {
+			if o.OriginIP != "" {
+				stored.OriginIP = o.OriginIP
+			}
+			if o.Profile != "" {
+				stored.Profile = o.Profile
+			}
+			if len(o.GeoZones) > 0 {
+				stored.GeoZones, _ = bunny.ParseGeoZones(o.GeoZones)
+			}
+			if o.MailPreset != "" {
+				stored.MailPreset = o.MailPreset
+			}
+		})
+		if err != nil {
+			result.Outcome = OutcomeFailed
+			result.Error = fmt.Sprintf("failed to save overrides: %v", err)
+			return result
+		}
+	}
+
+	err := r.provisioner.ProvisionDomain(app.ProvisionRequest{
+		Domain:       row.Domain,
+		ParentDomain: row.Parent,
+		User:         row.User,
+		Package:      row.Package,
+	})
+	if st, stErr := r.provisioner.GetStatus(row.Domain); stErr == nil {
+		result.setState(st.ProvisionState)
+	}
+	if err != nil {
+		r.logger.Warn("bulk provisioning failed", zap.String("domain", row.Domain), zap.Error(err))
+		result.Outcome = OutcomeFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Outcome = OutcomeProvisioned
+	return result
+}
+
+// setState copies the Bunny IDs and CDN hostname of a state
+func (r *Result) setState(st *state.ProvisionState) {
+	if st == nil {
+		return
+	}
+	r.ZoneID = st.ZoneID
+	r.PullZoneID = st.PullZoneID
+	r.CDNHostname = st.CDNHostname
+}
+
+// WriteResults writes results as CSV with the ResultColumns; IDs a domain
+// does not have are left empty
+func WriteResults(out io.Writer, results []Result) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(ResultColumns); err != nil {
+		return err
+	}
+	id := func(n int64) string {
+		if n <= 0 {
+			return ""
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	for _, result := range results {
+		err := w.Write([]string{
+			strconv.Itoa(result.Row.Line),
+			result.Row.Domain,
+			string(result.Outcome),
+			result.Error,
+			id(result.ZoneID),
+			id(result.PullZoneID),
+			result.CDNHostname,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Count returns how many results have outcome
+func Count(results []Result, outcome Outcome) int {
+	n := 0
+	for _, result := range results {
+		if result.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// splitList splits a cell listing several values, separated by spaces,
+// commas or semicolons
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == ';'
+	})
+}
@@ -0,0 +1,192 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// fakeProvisioner provisions every domain but those in fail
+type fakeProvisioner struct {
+	states      map[string]*state.ProvisionState
+	fail        map[string]bool
+	provisioned []app.ProvisionRequest
+}
+
+func newFakeProvisioner() *fakeProvisioner {
+	return &fakeProvisioner{states: make(map[string]*state.ProvisionState), fail: make(map[string]bool)}
+}
+
+func (f *fakeProvisioner) ProvisionDomain(req app.ProvisionRequest) error {
+	f.provisioned = append(f.provisioned, req)
+	if f.fail[req.Domain] {
+		f.states[req.Domain] = &state.ProvisionState{Domain: req.Domain, Status: state.StatusFailed, ZoneID: 7}
+		return errors.New("origin pre-flight check failed")
+	}
+	f.states[req.Domain] = &state.ProvisionState{
+		Domain:      req.Domain,
+		Status:      state.StatusSuccess,
+		ZoneID:      7,
+		PullZoneID:  42,
+		CDNHostname: "morden-" + strings.ReplaceAll(req.Domain, ".", "-") + ".b-cdn.net",
+	}
+	return nil
+}
+
+func (f *fakeProvisioner) GetStatus(domain string) (*app.Status, error) {
+	st, ok := f.states[domain]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &app.Status{ProvisionState: st}, nil
+}
+
+// fakeOverrides keeps overrides in memory
+type fakeOverrides map[string]overrides.Override
+
+func (f fakeOverrides) Update(domain string, fn func(o *overrides.Override)) error {
+	o := f[domain]
+	fn(&o)
+	f[domain] = o
+	return nil
+}
+
+var testProfiles = config.ProfilesConfig{
+	Definitions: map[string]config.ProfileConfig{"Premium": {Rank: 2}},
+}
+
+func TestParse(t *testing.T) {
+	prov := newFakeProvisioner()
+	prov.states["existing.com"] = &state.ProvisionState{Domain: "existing.com", Status: state.StatusSuccess}
+	runner := NewRunner(prov, fakeOverrides{}, testProfiles, nil)
+
+	rows, err := runner.Parse(strings.NewReader(`Domain,user,origin_ip,profile,geo_zones,mail_preset,parent
+# agency batch 1
+Example.com,exampleu,203.0.113.10,premium,"asia, EU",Google,
+
+blog.example.com,exampleu,,,,,example.com
+shop.existing.com,,,,,,existing.com
+`))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+
+	assert.Equal(t, Row{
+		Line:   3,
+		Domain: "example.com",
+		User:   "exampleu",
+		Overrides: overrides.Override{
+			OriginIP:   "203.0.113.10",
+			Profile:    "premium",
+			GeoZones:   []string{"asia", "EU"},
+			MailPreset: "google",
+		},
+	}, rows[0])
+	assert.Equal(t, 5, rows[1].Line)
+	assert.Equal(t, "example.com", rows[1].Parent)
+	assert.Equal(t, "existing.com", rows[2].Parent)
+}
+
+func TestParse_ReportsEveryInvalidRow(t *testing.T) {
+	runner := NewRunner(newFakeProvisioner(), fakeOverrides{}, testProfiles, nil)
+
+	_, err := runner.Parse(strings.NewReader(`domain,parent,origin_ip,profile,geo_zones,mail_preset
+not a domain,,,,,
+example.com,,300.1.1.1,,,
+a.example.com,,,gold,,
+b.example.com,,,,mars,
+c.example.com,,,,,fax
+example.com,,,,,
+www.other.com,other.com,,,,
+shop.example.net,example.org,,,,
+sub.late.com,late.com,,,,
+late.com,,,,,
+`))
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 9)
+
+	lines := make([]int, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Line
+	}
+	assert.Equal(t, []int{2, 3, 4, 5, 6, 7, 8, 9, 10}, lines)
+	assert.Contains(t, errs[1].Error(), "origin_ip")
+	assert.Contains(t, errs[2].Error(), `unknown profile "gold"`)
+	assert.Contains(t, errs[3].Error(), "geo_zones")
+	assert.Contains(t, errs[4].Error(), "mail_preset")
+	assert.Contains(t, errs[5].Error(), "already listed on line 3")
+	assert.Contains(t, errs[6].Error(), "neither listed nor provisioned")
+	assert.Contains(t, errs[7].Error(), "not a subdomain of example.org")
+	assert.Contains(t, errs[8].Error(), "must be listed before")
+}
+
+func TestParse_Header(t *testing.T) {
+	runner := NewRunner(newFakeProvisioner(), fakeOverrides{}, testProfiles, nil)
+
+	_, err := runner.Parse(strings.NewReader("domain,colour\nexample.com,red\n"))
+	assert.ErrorContains(t, err, `unknown column "colour"`)
+	_, err = runner.Parse(strings.NewReader("user\nexampleu\n"))
+	assert.ErrorContains(t, err, "domain column is missing")
+	_, err = runner.Parse(strings.NewReader(""))
+	assert.ErrorContains(t, err, "empty")
+}
+
+func TestRun(t *testing.T) {
+	prov := newFakeProvisioner()
+	prov.states["existing.com"] = &state.ProvisionState{Domain: "existing.com", Status: state.StatusSuccess, ZoneID: 1, PullZoneID: 2, CDNHostname: "morden-existing-com.b-cdn.net"}
+	prov.fail["broken.com"] = true
+	stored := fakeOverrides{"example.com": {Tier: "volume"}}
+	runner := NewRunner(prov, stored, testProfiles, nil)
+
+	rows, err := runner.Parse(strings.NewReader(`domain,parent,package,origin_ip,geo_zones,mail_preset
+example.com,,gold,203.0.113.10,eu asia,none
+existing.com,,,198.51.100.1,,
+broken.com,,,,,
+blog.broken.com,broken.com,,,,
+`))
+	require.NoError(t, err)
+
+	var progress []Outcome
+	results := runner.Run(context.Background(), rows, func(r Result) { progress = append(progress, r.Outcome) })
+	assert.Equal(t, []Outcome{OutcomeProvisioned, OutcomeExists, OutcomeFailed, OutcomeSkipped}, progress)
+
+	// The row's overrides are added to the stored ones before provisioning
+	assert.Equal(t, overrides.Override{Tier: "volume", OriginIP: "203.0.113.10", GeoZones: []string{"eu", "asia"}, MailPreset: "none"}, stored["example.com"])
+	assert.NotContains(t, stored, "existing.com", "provisioned domains are left alone")
+	require.Len(t, prov.provisioned, 2)
+	assert.Equal(t, app.ProvisionRequest{Domain: "example.com", Package: "gold"}, prov.provisioned[0])
+
+	assert.Equal(t, "failed", string(results[2].Outcome))
+	assert.Equal(t, "origin pre-flight check failed", results[2].Error)
+	assert.Equal(t, "parent broken.com failed", results[3].Error)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteResults(&buf, results))
+	assert.Equal(t, `line,domain,outcome,error,dns_zone_id,pull_zone_id,cdn_hostname
+2,example.com,provisioned,,7,42,morden-example-com.b-cdn.net
+3,existing.com,exists,,1,2,morden-existing-com.b-cdn.net
+4,broken.com,failed,origin pre-flight check failed,7,,
+5,blog.broken.com,skipped,parent broken.com failed,,,
+`, buf.String())
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	prov := newFakeProvisioner()
+	runner := NewRunner(prov, fakeOverrides{}, testProfiles, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := runner.Run(ctx, []Row{{Line: 2, Domain: "example.com"}}, nil)
+	assert.Equal(t, OutcomeSkipped, results[0].Outcome)
+	assert.Empty(t, prov.provisioned)
+}
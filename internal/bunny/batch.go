@@ -0,0 +1,186 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	goRetry "github.com/sethvargo/go-retry"
+
+	"github.com/mordenhost/whm2bunny/internal/retry"
+)
+
+const (
+	// DefaultBatchConcurrency is how many zones a record batch writes at
+	// the same time unless BatchOptions sets another
+	DefaultBatchConcurrency = 4
+	// DefaultBatchRetryBudget is how many retries a record batch may spend
+	// in total unless BatchOptions sets another
+	DefaultBatchRetryBudget = 10
+)
+
+// BatchOptions configures a RecordBatch
+type BatchOptions struct {
+	// Concurrency is how many zones are written at the same time; the
+	// writes to one zone are made one after the other, in order
+	Concurrency int
+	// RetryBudget is how many retries the whole batch may spend. Each write
+	// backs off exponentially as any request does, but once the budget is
+	// spent failed writes are no longer retried, so a rate limited batch
+	// ends instead of retrying every write. A negative budget disables
+	// retries
+	RetryBudget int
+}
+
+// RecordOp is the kind of a record write
+type RecordOp string
+
+// Record write kinds
+const (
+	RecordAdd    RecordOp = "add"
+	RecordUpdate RecordOp = "update"
+	RecordDelete RecordOp = "delete"
+)
+
+// RecordWrite is one record change queued in a RecordBatch
+type RecordWrite struct {
+	Op     RecordOp
+	ZoneID int64
+	// RecordID is the record updated or deleted
+	RecordID int64
+	// Add and Update hold the request of add and update writes
+	Add    *AddDNSRecordRequest
+	Update *UpdateDNSRecordRequest
+	// Label names the write in results, such as "www CNAME"
+	Label string
+}
+
+// RecordResult is the outcome of a RecordWrite
+type RecordResult struct {
+	RecordWrite
+	// Record is the record created by an add write
+	Record *DNSRecord
+	Err    error
+}
+
+// RecordBatch groups DNS record writes per zone and applies them with
+// bounded concurrency and a shared retry budget
+type RecordBatch struct {
+	client *Client
+	opts   BatchOptions
+	writes []RecordWrite
+}
+
+// NewRecordBatch creates an empty batch
+func (c *Client) NewRecordBatch(opts BatchOptions) *RecordBatch {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultBatchConcurrency
+	}
+	if opts.RetryBudget < 0 {
+		opts.RetryBudget = 0
+	} else if opts.RetryBudget == 0 {
+		opts.RetryBudget = DefaultBatchRetryBudget
+	}
+	return &RecordBatch{client: c, opts: opts}
+}
+
+// Add queues adding a record to a zone
+func (b *RecordBatch) Add(zoneID int64, label string, req *AddDNSRecordRequest) {
+	b.writes = append(b.writes, RecordWrite{Op: RecordAdd, ZoneID: zoneID, Add: req, Label: label})
+}
+
+// Update queues updating a record
+func (b *RecordBatch) Update(zoneID, recordID int64, label string, req *UpdateDNSRecordRequest) {
+	b.writes = append(b.writes, RecordWrite{Op: RecordUpdate, ZoneID: zoneID, RecordID: recordID, Update: req, Label: label})
+}
+
+// Delete queues deleting a record
+func (b *RecordBatch) Delete(zoneID, recordID int64, label string) {
+	b.writes = append(b.writes, RecordWrite{Op: RecordDelete, ZoneID: zoneID, RecordID: recordID, Label: label})
+}
+
+// Len returns the number of queued writes
+func (b *RecordBatch) Len() int {
+	return len(b.writes)
+}
+
+// Apply makes the queued writes and returns their results in the order
+// they were queued. A failed write does not stop the others
+func (b *RecordBatch) Apply(ctx context.Context) []RecordResult {
+	results := make([]RecordResult, len(b.writes))
+	zones := make(map[int64][]int)
+	var order []int64
+	for i, w := range b.writes {
+		results[i].RecordWrite = w
+		if _, ok := zones[w.ZoneID]; !ok {
+			order = append(order, w.ZoneID)
+		}
+		zones[w.ZoneID] = append(zones[w.ZoneID], i)
+	}
+
+	var budget atomic.Int64
+	budget.Store(int64(b.opts.RetryBudget))
+
+	sem := make(chan struct{}, b.opts.Concurrency)
+	var wg sync.WaitGroup
+	for _, zoneID := range order {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				for _, i := range indexes {
+					results[i].Err = ctx.Err()
+				}
+				return
+			}
+			for _, i := range indexes {
+				results[i].Record, results[i].Err = b.apply(ctx, &budget, b.writes[i])
+			}
+		}(zones[zoneID])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// apply makes one write, retrying while the shared budget lasts
+func (b *RecordBatch) apply(ctx context.Context, budget *atomic.Int64, w RecordWrite) (*DNSRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	backoff := budgetBackoff{Backoff: retry.WithBackoff(b.client.retryCfg), budget: budget}
+	var err error
+	var record *DNSRecord
+	switch w.Op {
+	case RecordAdd:
+		record, err = b.client.addDNSRecord(ctx, backoff, w.ZoneID, w.Add)
+	case RecordUpdate:
+		err = b.client.updateDNSRecord(ctx, backoff, w.ZoneID, w.RecordID, w.Update)
+	case RecordDelete:
+		err = b.client.deleteDNSRecord(ctx, backoff, w.ZoneID, w.RecordID)
+	default:
+		err = fmt.Errorf("unknown record write %q", w.Op)
+	}
+	return record, err
+}
+
+// budgetBackoff stops retrying once the retries shared by a batch are spent
+type budgetBackoff struct {
+	goRetry.Backoff
+	budget *atomic.Int64
+}
+
+// Next returns the delay before the next retry, taking it from the budget
+func (b budgetBackoff) Next() (time.Duration, bool) {
+	d, stop := b.Backoff.Next()
+	if stop || b.budget.Add(-1) < 0 {
+		return 0, true
+	}
+	return d, false
+}
@@ -0,0 +1,131 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/retry"
+)
+
+// fastRetry retries quickly so rate limited tests finish at once
+func fastRetry() *retry.Config {
+	cfg := retry.DefaultConfig()
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	return cfg
+}
+
+func TestRecordBatch_Apply(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/records/404"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/records"):
+			fmt.Fprint(w, `{"Id":7,"Type":0,"Name":"","Value":"1.2.3.4"}`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithRetryConfig(fastRetry()))
+	batch := client.NewRecordBatch(BatchOptions{})
+	batch.Add(1, "A", &AddDNSRecordRequest{Type: DNSRecordTypeA, Name: "@", Value: "1.2.3.4"})
+	batch.Delete(2, 404, "stale")
+	batch.Update(1, 3, "www CNAME", &UpdateDNSRecordRequest{Value: "cdn.example.com"})
+	batch.Delete(1, 5, "old MX")
+	require.Equal(t, 4, batch.Len())
+
+	results := batch.Apply(context.Background())
+	require.Len(t, results, 4)
+
+	// Results keep the queue order, and a failed write does not stop the others
+	assert.Equal(t, "A", results[0].Label)
+	require.NoError(t, results[0].Err)
+	require.NotNil(t, results[0].Record)
+	assert.Equal(t, int64(7), results[0].Record.ID)
+	assert.Equal(t, "stale", results[1].Label)
+	assert.Error(t, results[1].Err)
+	assert.NoError(t, results[2].Err)
+	assert.NoError(t, results[3].Err)
+
+	// The writes to a zone are made in order
+	var zone1 []string
+	for _, p := range paths {
+		if strings.Contains(p, "/dns/1/") {
+			zone1 = append(zone1, p)
+		}
+	}
+	assert.Equal(t, []string{
+		"POST /dns/1/records",
+		"POST /dns/1/records/3",
+		"DELETE /dns/1/records/5",
+	}, zone1)
+}
+
+func TestRecordBatch_RetryBudget(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithRetryConfig(fastRetry()))
+	batch := client.NewRecordBatch(BatchOptions{Concurrency: 1, RetryBudget: 3})
+	for zoneID := range int64(4) {
+		batch.Delete(zoneID+1, 1, "record")
+	}
+
+	results := batch.Apply(context.Background())
+	for _, result := range results {
+		assert.Error(t, result.Err)
+	}
+	// One attempt per write plus the three retries of the budget, instead
+	// of five retries for each write
+	assert.Equal(t, int64(4+3), requests.Load())
+}
+
+func TestRecordBatch_NoRetries(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithRetryConfig(fastRetry()))
+	batch := client.NewRecordBatch(BatchOptions{RetryBudget: -1})
+	batch.Delete(1, 1, "record")
+
+	results := batch.Apply(context.Background())
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, int64(1), requests.Load())
+}
+
+func TestRecordBatch_Canceled(t *testing.T) {
+	client := NewClient("test-key", WithBaseURL("http://127.0.0.1:0"))
+	batch := client.NewRecordBatch(BatchOptions{})
+	batch.Delete(1, 1, "record")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := batch.Apply(ctx)
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+}
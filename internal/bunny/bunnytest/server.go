@@ -0,0 +1,523 @@
+// Package bunnytest provides an in-memory Bunny API for tests, in the style
+// of net/http/httptest. It keeps DNS zones, records and pull zones created
+// through it and can hold requests to simulate a slow or stuck API.
+package bunnytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// Server is a fake Bunny API
+type Server struct {
+	// URL is the base URL of the API, for bunny.WithBaseURL
+	URL string
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	nextID    int64
+	zones     map[int64]*bunny.DNSZone
+	records   map[int64][]bunny.DNSRecord
+	pullZones map[int64]*bunny.PullZone
+	queries   map[int64]int64
+	calls     map[string]int
+	holds     map[string]*hold
+	balance   float64
+	latency   time.Duration
+}
+
+// hold blocks matching requests until released
+type hold struct {
+	reached  chan struct{}
+	released chan struct{}
+	once     sync.Once
+}
+
+// NewServer starts a fake Bunny API; call Close when done
+func NewServer() *Server {
+	s := &Server{
+		nextID:    1000,
+		zones:     make(map[int64]*bunny.DNSZone),
+		records:   make(map[int64][]bunny.DNSRecord),
+		pullZones: make(map[int64]*bunny.PullZone),
+		queries:   make(map[int64]int64),
+		calls:     make(map[string]int),
+		holds:     make(map[string]*hold),
+		balance:   100,
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close releases held requests and shuts the server down
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, h := range s.holds {
+		h.release()
+	}
+	s.mu.Unlock()
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// Hold blocks requests with method and path, such as "POST /pullzone",
+// until the returned release func is called or the client gives up. The
+// returned channel is closed when the first such request arrives
+func (s *Server) Hold(method, path string) (reached <-chan struct{}, release func()) {
+	h := &hold{reached: make(chan struct{}), released: make(chan struct{})}
+
+	s.mu.Lock()
+	s.holds[method+" "+path] = h
+	s.mu.Unlock()
+
+	return h.reached, func() {
+		s.mu.Lock()
+		delete(s.holds, method+" "+path)
+		s.mu.Unlock()
+		h.release()
+	}
+}
+
+func (h *hold) release() {
+	h.once.Do(func() { close(h.released) })
+}
+
+// Calls returns how many requests with method and path completed
+func (s *Server) Calls(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method+" "+path]
+}
+
+// TotalCalls returns how many requests completed, whatever their method
+// and path
+func (s *Server) TotalCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, n := range s.calls {
+		total += n
+	}
+	return total
+}
+
+// SetLatency delays every response by d, to simulate the round trip to the
+// real API
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetBalance sets the account balance returned by GET /billing
+func (s *Server) SetBalance(balance float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balance = balance
+}
+
+// DNSZone returns the DNS zone of domain
+func (s *Server) DNSZone(domain string) (bunny.DNSZone, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, zone := range s.zones {
+		if zone.Domain == domain {
+			return *zone, true
+		}
+	}
+	return bunny.DNSZone{}, false
+}
+
+// SetDNSZoneEnabled enables or disables the DNS zone of domain, as Bunny
+// does after an abuse report or a billing problem
+func (s *Server) SetDNSZoneEnabled(domain string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, zone := range s.zones {
+		if zone.Domain == domain {
+			zone.UserEnabled = enabled
+		}
+	}
+}
+
+// SetDNSQueries sets the queries the DNS zone of domain reports answering
+// in GET /dns/{id}/statistics, whatever the period
+func (s *Server) SetDNSQueries(domain string, queries int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, zone := range s.zones {
+		if zone.Domain == domain {
+			s.queries[zone.ID] = queries
+		}
+	}
+}
+
+// DNSRecords returns the records of a DNS zone
+func (s *Server) DNSRecords(zoneID int64) []bunny.DNSRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bunny.DNSRecord(nil), s.records[zoneID]...)
+}
+
+// PullZone returns a copy of the pull zone named name
+func (s *Server) PullZone(name string) (bunny.PullZone, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, zone := range s.pullZones {
+		if zone.Name == name {
+			// Hostnames and edge rules are updated in place by later requests
+			copied := *zone
+			copied.Hostnames = append([]bunny.Hostname(nil), zone.Hostnames...)
+			copied.EdgeRules = append([]bunny.EdgeRule(nil), zone.EdgeRules...)
+			return copied, true
+		}
+	}
+	return bunny.PullZone{}, false
+}
+
+// serve routes a request to the resource it names
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(bunny.AccessKeyHeader) == "" {
+		writeError(w, http.StatusUnauthorized, "missing access key")
+		return
+	}
+
+	key := r.Method + " " + r.URL.Path
+	s.mu.Lock()
+	h := s.holds[key]
+	latency := s.latency
+	s.mu.Unlock()
+	if h != nil {
+		select {
+		case <-h.reached:
+		default:
+			close(h.reached)
+		}
+		select {
+		case <-h.released:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key]++
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "dns":
+		s.serveDNS(w, r, parts[1:])
+	case "pullzone":
+		s.servePullZone(w, r, parts[1:])
+	case "billing":
+		writeJSON(w, map[string]float64{"Balance": s.balance})
+	case "statistics":
+		writeJSON(w, map[string]interface{}{})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveDNS handles /dns, /dns/{id}, /dns/{id}/statistics and
+// /dns/{id}/records[/{recordId}]
+// Caller must hold s.mu
+func (s *Server) serveDNS(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			items := make([]bunny.DNSZone, 0, len(s.zones))
+			for _, zone := range s.zones {
+				items = append(items, *zone)
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+			page, items, more := paginate(r, items)
+			writeJSON(w, bunny.DNSZoneListResponse{Items: items, CurrentPage: page, TotalItems: len(s.zones), HasMoreItems: more})
+		case http.MethodPost:
+			var req bunny.CreateDNSZoneRequest
+			if !decode(w, r, &req) {
+				return
+			}
+			zone := &bunny.DNSZone{ID: s.id(), Domain: req.Domain, SoaEmail: req.SoaEmail, UserEnabled: true}
+			s.zones[zone.ID] = zone
+			writeJSON(w, zone)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	zoneID, _ := strconv.ParseInt(parts[0], 10, 64)
+	zone, ok := s.zones[zoneID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "DNS zone not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, zone)
+		case http.MethodDelete:
+			delete(s.zones, zoneID)
+			delete(s.records, zoneID)
+			delete(s.queries, zoneID)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			var req bunny.UpdateDNSZoneRequest
+			if !decode(w, r, &req) {
+				return
+			}
+			if req.UserEnabled != nil {
+				zone.UserEnabled = *req.UserEnabled
+			}
+			if req.SoaEmail != "" {
+				zone.SoaEmail = req.SoaEmail
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if parts[1] == "statistics" && r.Method == http.MethodGet {
+		writeJSON(w, map[string]int64{"TotalQueriesServed": s.queries[zoneID]})
+		return
+	}
+	if parts[1] != "records" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, bunny.DNSRecordsResponse{Items: append([]bunny.DNSRecord{}, s.records[zoneID]...)})
+		case http.MethodPost:
+			var record bunny.DNSRecord
+			if !decode(w, r, &record) {
+				return
+			}
+			record.ID = s.id()
+			s.records[zoneID] = append(s.records[zoneID], record)
+			writeJSON(w, record)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	recordID, _ := strconv.ParseInt(parts[2], 10, 64)
+	records := s.records[zoneID]
+	for i, record := range records {
+		if record.ID != recordID {
+			continue
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, record)
+		case http.MethodDelete:
+			s.records[zoneID] = append(records[:i:i], records[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			var update bunny.DNSRecord
+			if !decode(w, r, &update) {
+				return
+			}
+			update.ID = recordID
+			records[i] = update
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	writeError(w, http.StatusNotFound, "DNS record not found")
+}
+
+// servePullZone handles /pullzone, /pullzone/{id} and the pull zone
+// actions; actions other than addHostname, setForceSSL and the edge rules
+// are accepted and ignored
+// Caller must hold s.mu
+func (s *Server) servePullZone(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			items := make([]bunny.PullZone, 0, len(s.pullZones))
+			for _, zone := range s.pullZones {
+				items = append(items, *zone)
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+			page, items, more := paginate(r, items)
+			writeJSON(w, bunny.PullZoneListResponse{Items: items, CurrentPage: page, TotalItems: len(s.pullZones), HasMoreItems: more})
+		case http.MethodPost:
+			var zone bunny.PullZone
+			if !decode(w, r, &zone) {
+				return
+			}
+			zone.ID = s.id()
+			zone.Hostnames = []bunny.Hostname{{ID: s.id(), Hostname: zone.Name + bunny.SystemHostnameSuffix, IsSystemHostname: true}}
+			s.pullZones[zone.ID] = &zone
+			writeJSON(w, zone)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	if parts[0] == "loadFreeCertificate" {
+		// Certificates are issued at once, as every hostname resolves here
+		hostname := r.URL.Query().Get("hostname")
+		for _, zone := range s.pullZones {
+			for i := range zone.Hostnames {
+				if strings.EqualFold(zone.Hostnames[i].Hostname, hostname) {
+					zone.Hostnames[i].HasCertificate = true
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	zoneID, _ := strconv.ParseInt(parts[0], 10, 64)
+	zone, ok := s.pullZones[zoneID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "pull zone not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, zone)
+		case http.MethodDelete:
+			delete(s.pullZones, zoneID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	switch parts[1] {
+	case "addHostname":
+		var req bunny.AddHostnameRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		zone.Hostnames = append(zone.Hostnames, bunny.Hostname{ID: s.id(), Hostname: req.Hostname})
+		w.WriteHeader(http.StatusNoContent)
+	case "setForceSSL":
+		var req struct {
+			Hostname string
+			ForceSSL bool
+		}
+		if !decode(w, r, &req) {
+			return
+		}
+		for i := range zone.Hostnames {
+			if strings.EqualFold(zone.Hostnames[i].Hostname, req.Hostname) {
+				zone.Hostnames[i].ForceSSL = req.ForceSSL
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "edgerules":
+		s.serveEdgeRules(w, r, zone, parts[2:])
+	case "stats", "certificates":
+		writeJSON(w, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveEdgeRules handles /pullzone/{id}/edgerules/addOrUpdate and
+// /pullzone/{id}/edgerules/{guid}
+// Caller must hold s.mu
+func (s *Server) serveEdgeRules(w http.ResponseWriter, r *http.Request, zone *bunny.PullZone, parts []string) {
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "addOrUpdate":
+		var rule bunny.EdgeRule
+		if !decode(w, r, &rule) {
+			return
+		}
+		if rule.GUID == "" {
+			rule.GUID = fmt.Sprintf("edge-rule-%d", s.id())
+		}
+		for i := range zone.EdgeRules {
+			if zone.EdgeRules[i].GUID == rule.GUID {
+				zone.EdgeRules[i] = rule
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		zone.EdgeRules = append(zone.EdgeRules, rule)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && len(parts) == 1:
+		for i := range zone.EdgeRules {
+			if zone.EdgeRules[i].GUID == parts[0] {
+				zone.EdgeRules = append(zone.EdgeRules[:i], zone.EdgeRules[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "edge rule not found")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// id returns the next resource ID
+// Caller must hold s.mu
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// paginate returns the page of items selected by the page and perPage
+// query parameters, and whether more pages follow; without them all items
+// are returned
+func paginate[T any](r *http.Request, items []T) (int, []T, bool) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if page < 1 || perPage < 1 {
+		return 1, items, false
+	}
+	start := min((page-1)*perPage, len(items))
+	end := min(start+perPage, len(items))
+	return page, items[start:end], end < len(items)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"Message": message})
+}
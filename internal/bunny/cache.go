@@ -0,0 +1,101 @@
+package bunny
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultResponseCacheEntries is the number of GET responses kept for
+// conditional requests unless WithResponseCache sets another
+const DefaultResponseCacheEntries = 256
+
+// cachedResponse is a GET response body with the validators Bunny sent
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	body         []byte
+	storedAt     time.Time
+}
+
+// responseCache keeps the GET responses that carried an ETag or
+// Last-Modified header, keyed by path, so repeated polling of unchanged
+// resources is answered with 304 Not Modified
+type responseCache struct {
+	mu         sync.Mutex
+	entries    map[string]*cachedResponse
+	maxEntries int
+}
+
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{
+		entries:    make(map[string]*cachedResponse),
+		maxEntries: maxEntries,
+	}
+}
+
+// get returns the cached response of path, or nil
+func (c *responseCache) get(path string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[path]
+}
+
+// put caches a response of path if it has validators, evicting the oldest
+// entry when the cache is full
+func (c *responseCache) put(path string, header http.Header, body []byte) {
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for key, entry := range c.entries {
+			if oldest == "" || entry.storedAt.Before(c.entries[oldest].storedAt) {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[path] = &cachedResponse{
+		etag:         etag,
+		lastModified: lastModified,
+		body:         body,
+		storedAt:     time.Now(),
+	}
+}
+
+// invalidate drops the cached responses of the collection a changed path
+// belongs to, such as every /pullzone response after POST /pullzone/1
+func (c *responseCache) invalidate(path string) {
+	collection := collectionOf(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if collectionOf(key) == collection {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// collectionOf returns the first segment of a path, without the query
+func collectionOf(path string) string {
+	path, _, _ = strings.Cut(strings.TrimPrefix(path, "/"), "?")
+	collection, _, _ := strings.Cut(path, "/")
+	return collection
+}
+
+// setValidators makes req conditional on the cached response
+func (r *cachedResponse) setValidators(req *http.Request) {
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+}
@@ -0,0 +1,87 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newETagServer serves pull zone 1 with an ETag that changes on every
+// update, answering matching conditional requests with 304
+func newETagServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	version := 1
+	var conditions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			version++
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		etag := fmt.Sprintf(`"v%d"`, version)
+		conditions = append(conditions, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"Id":1,"Name":"zone-v%d"}`, version)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &conditions
+}
+
+func TestClient_ConditionalRequests(t *testing.T) {
+	srv, conditions := newETagServer(t)
+	client := NewClient("test-key", WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	for range 2 {
+		zone, err := client.GetPullZone(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "zone-v1", zone.Name)
+	}
+	assert.Equal(t, []string{"", `"v1"`}, *conditions, "second request is conditional")
+
+	// A change drops the cached copy
+	require.NoError(t, client.UpdatePullZone(ctx, 1, &UpdatePullZoneRequest{}))
+	zone, err := client.GetPullZone(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "zone-v2", zone.Name)
+	assert.Equal(t, "", (*conditions)[2])
+}
+
+func TestClient_ResponseCacheDisabled(t *testing.T) {
+	srv, conditions := newETagServer(t)
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithResponseCache(0))
+
+	for range 2 {
+		_, err := client.GetPullZone(context.Background(), 1)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"", ""}, *conditions)
+}
+
+func TestResponseCache_Eviction(t *testing.T) {
+	c := newResponseCache(2)
+	header := http.Header{"Etag": []string{`"a"`}}
+
+	c.put("/pullzone/1", header, []byte("1"))
+	c.put("/pullzone/2", header, []byte("2"))
+	c.put("/dns/3", header, []byte("3"))
+	assert.Nil(t, c.get("/pullzone/1"), "oldest entry evicted")
+	assert.NotNil(t, c.get("/dns/3"))
+
+	c.put("/pullzone/4", http.Header{}, []byte("4"))
+	assert.Nil(t, c.get("/pullzone/4"), "responses without validators are not cached")
+
+	c.invalidate("/pullzone/2/addHostname")
+	assert.Nil(t, c.get("/pullzone/2"))
+	assert.NotNil(t, c.get("/dns/3"))
+}
@@ -2,45 +2,186 @@ package bunny
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// PullZoneType is the pricing tier of a pull zone
+type PullZoneType int
+
+const (
+	// PullZoneTypeStandard is the standard tier (called Premium in the Bunny API)
+	PullZoneTypeStandard PullZoneType = 0
+	// PullZoneTypeVolume is the high-bandwidth Volume tier
+	PullZoneTypeVolume PullZoneType = 1
+)
+
+// String returns the tier name used in configuration
+func (t PullZoneType) String() string {
+	switch t {
+	case PullZoneTypeStandard:
+		return "standard"
+	case PullZoneTypeVolume:
+		return "volume"
+	default:
+		return fmt.Sprintf("type-%d", int(t))
+	}
+}
+
+// ParsePullZoneType parses a tier name; an empty name is the standard tier
+func ParsePullZoneType(name string) (PullZoneType, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "standard":
+		return PullZoneTypeStandard, nil
+	case "volume":
+		return PullZoneTypeVolume, nil
+	default:
+		return 0, fmt.Errorf("invalid pull zone tier %q (expected standard or volume)", name)
+	}
+}
+
+// Geo zones a pull zone can serve from
+const (
+	GeoZoneAsia = "asia" // Asia and Oceania
+	GeoZoneEU   = "eu"   // Europe
+	GeoZoneNA   = "na"   // North America
+	GeoZoneSA   = "sa"   // South America
+	GeoZoneAF   = "af"   // Africa
+)
+
+// GeoZones lists every geo zone
+var GeoZones = []string{GeoZoneAsia, GeoZoneEU, GeoZoneNA, GeoZoneSA, GeoZoneAF}
+
+// ParseGeoZones parses and lowercases geo zone names, dropping duplicates
+func ParseGeoZones(names []string) ([]string, error) {
+	var zones []string
+	for _, name := range names {
+		zone := strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(GeoZones, zone) {
+			return nil, fmt.Errorf("invalid geo zone %q (expected %s)", name, strings.Join(GeoZones, ", "))
+		}
+		if !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
 // PullZone represents a BunnyCDN Pull Zone
 type PullZone struct {
-	ID                      int64      `json:"Id"`
-	Name                    string     `json:"Name"`
-	OriginURL               string     `json:"OriginUrl"`
-	OriginHostHeader        string     `json:"OriginHostHeader,omitempty"`
-	OriginShieldZoneCode    string     `json:"OriginShieldZoneCode,omitempty"`
-	EnableGeoZoneASIA       bool       `json:"EnableGeoZoneASIA"`
-	EnableGeoZoneEU         bool       `json:"EnableGeoZoneEU,omitempty"`
-	EnableGeoZoneNA         bool       `json:"EnableGeoZoneNA,omitempty"`
-	EnableGeoZoneSA         bool       `json:"EnableGeoZoneSA,omitempty"`
-	EnableGeoZoneAF         bool       `json:"EnableGeoZoneAF,omitempty"`
-	EnableOriginShield      bool       `json:"EnableOriginShield,omitempty"`
-	EnableAutoSSL           bool       `json:"EnableAutoSSL"`
-	EnableBrotliCompression bool       `json:"EnableBrotliCompression,omitempty"`
-	CacheExpirationTime     int        `json:"CacheExpirationTime,omitempty"`
-	BracketedForce          bool       `json:"BracketedForce,omitempty"`
-	DisableCookies          bool       `json:"DisableCookies,omitempty"`
-	EnableQueryStringBased  bool       `json:"EnableQueryStringBased,omitempty"`
-	ZoneStatus              int        `json:"ZoneStatus,omitempty"`
-	Hostnames               []Hostname `json:"Hostnames,omitempty"`
-	Type                    int        `json:"Type,omitempty"`
-	CreatedAt               time.Time  `json:"CreationDate,omitempty"`
-	ModifiedAt              time.Time  `json:"ModifyDate,omitempty"`
+	ID                      int64        `json:"Id"`
+	Name                    string       `json:"Name"`
+	OriginURL               string       `json:"OriginUrl"`
+	OriginHostHeader        string       `json:"OriginHostHeader,omitempty"`
+	VerifyOriginSSL         bool         `json:"VerifyOriginSSL,omitempty"`
+	OriginShieldZoneCode    string       `json:"OriginShieldZoneCode,omitempty"`
+	EnableGeoZoneASIA       bool         `json:"EnableGeoZoneASIA"`
+	EnableGeoZoneEU         bool         `json:"EnableGeoZoneEU,omitempty"`
+	EnableGeoZoneNA         bool         `json:"EnableGeoZoneNA,omitempty"`
+	EnableGeoZoneSA         bool         `json:"EnableGeoZoneSA,omitempty"`
+	EnableGeoZoneAF         bool         `json:"EnableGeoZoneAF,omitempty"`
+	EnableOriginShield      bool         `json:"EnableOriginShield,omitempty"`
+	EnableAutoSSL           bool         `json:"EnableAutoSSL"`
+	EnableBrotliCompression bool         `json:"EnableBrotliCompression,omitempty"`
+	CacheExpirationTime     int          `json:"CacheExpirationTime,omitempty"`
+	BracketedForce          bool         `json:"BracketedForce,omitempty"`
+	DisableCookies          bool         `json:"DisableCookies,omitempty"`
+	EnableQueryStringBased  bool         `json:"EnableQueryStringBased,omitempty"`
+	ZoneStatus              int          `json:"ZoneStatus,omitempty"`
+	Hostnames               []Hostname   `json:"Hostnames,omitempty"`
+	PermaCacheStorageZoneID int64        `json:"PermaCacheStorageZoneId,omitempty"`
+	OptimizerEnabled        bool         `json:"OptimizerEnabled,omitempty"`
+	OptimizerAutoOptimize   bool         `json:"OptimizerAutomaticOptimizationEnabled,omitempty"`
+	OptimizerEnableWebP     bool         `json:"OptimizerEnableWebP,omitempty"`
+	OptimizerMinifyCSS      bool         `json:"OptimizerMinifyCSS,omitempty"`
+	OptimizerMinifyJS       bool         `json:"OptimizerMinifyJavaScript,omitempty"`
+	ZoneSecurityEnabled     bool         `json:"ZoneSecurityEnabled,omitempty"`
+	ZoneSecurityKey         string       `json:"ZoneSecurityKey,omitempty"`
+	AllowedReferrers        []string     `json:"AllowedReferrers,omitempty"`
+	BlockedReferrers        []string     `json:"BlockedReferrers,omitempty"`
+	BlockNoneReferrer       bool         `json:"BlockNoneReferrer,omitempty"`
+	BlockedCountries        []string     `json:"BlockedCountries,omitempty"`
+	UseStaleWhileOffline    bool         `json:"UseStaleWhileOffline,omitempty"`
+	UseStaleWhileUpdating   bool         `json:"UseStaleWhileUpdating,omitempty"`
+	OriginConnectTimeout    int          `json:"OriginConnectTimeout,omitempty"`
+	OriginResponseTimeout   int          `json:"OriginResponseTimeout,omitempty"`
+	OriginRetries           int          `json:"OriginRetries,omitempty"`
+	BlockedIPs              []string     `json:"BlockedIps,omitempty"`
+	EdgeRules               []EdgeRule   `json:"EdgeRules,omitempty"`
+	Type                    PullZoneType `json:"Type,omitempty"`
+	CreatedAt               time.Time    `json:"CreationDate,omitempty"`
+	ModifiedAt              time.Time    `json:"ModifyDate,omitempty"`
+
+	// Request logging, see Logging
+	EnableLogging                 bool   `json:"EnableLogging"`
+	LoggingIPAnonymizationEnabled bool   `json:"LoggingIPAnonymizationEnabled,omitempty"`
+	LogAnonymizationType          int    `json:"LogAnonymizationType,omitempty"`
+	LogForwardingEnabled          bool   `json:"LogForwardingEnabled,omitempty"`
+	LogForwardingHostname         string `json:"LogForwardingHostname,omitempty"`
+	LogForwardingPort             int    `json:"LogForwardingPort,omitempty"`
+	LogForwardingToken            string `json:"LogForwardingToken,omitempty"`
+	LogForwardingProtocol         int    `json:"LogForwardingProtocol,omitempty"`
+	LogForwardingFormat           int    `json:"LogForwardingFormat,omitempty"`
+}
+
+const (
+	// SystemHostnameSuffix is the domain of the hostname Bunny gives every
+	// pull zone, <zone name>.b-cdn.net
+	SystemHostnameSuffix = ".b-cdn.net"
+	// LegacySystemHostnameSuffix is the domain of system hostnames issued
+	// to older pull zones
+	LegacySystemHostnameSuffix = ".bunnycdn.com"
+)
+
+// SystemHostname returns the hostname Bunny serves the pull zone on, which
+// CNAME records point to: the hostname Bunny marks as the system hostname,
+// else one under a system hostname suffix, else the name Bunny derives
+// from the zone name. It is empty for a zone without a name
+func (pz *PullZone) SystemHostname() string {
+	for _, h := range pz.Hostnames {
+		if h.IsSystemHostname && h.Hostname != "" {
+			return strings.ToLower(h.Hostname)
+		}
+	}
+	for _, h := range pz.Hostnames {
+		name := strings.ToLower(h.Hostname)
+		if strings.HasSuffix(name, SystemHostnameSuffix) || strings.HasSuffix(name, LegacySystemHostnameSuffix) {
+			return name
+		}
+	}
+	if pz.Name != "" {
+		return strings.ToLower(pz.Name) + SystemHostnameSuffix
+	}
+	return ""
+}
+
+// FindHostname returns the pull zone's hostname matching name, ignoring
+// case, or nil when the zone does not serve it
+func (pz *PullZone) FindHostname(name string) *Hostname {
+	for i := range pz.Hostnames {
+		if strings.EqualFold(pz.Hostnames[i].Hostname, name) {
+			return &pz.Hostnames[i]
+		}
+	}
+	return nil
 }
 
 // Hostname represents a hostname (custom domain) for a pull zone
 type Hostname struct {
 	ID                int64   `json:"Id"`
 	Hostname          string  `json:"Hostname"`
+	IsSystemHostname  bool    `json:"IsSystemHostname"`
 	ForceSSL          bool    `json:"ForceSSL"`
+	HasCertificate    bool    `json:"HasCertificate"`
 	SSLCertificateID  *int64  `json:"SslCertificateId,omitempty"`
 	Value             *string `json:"Value,omitempty"`
 	VerificationError *string `json:"VerificationError,omitempty"`
@@ -59,19 +200,26 @@ type SSLCertificate struct {
 
 // CreatePullZoneRequest is the request to create a pull zone
 type CreatePullZoneRequest struct {
-	Name                    string `json:"Name"`
-	OriginURL               string `json:"OriginUrl"`
-	OriginHostHeader        string `json:"OriginHostHeader,omitempty"`
-	EnableGeoZoneASIA       bool   `json:"EnableGeoZoneASIA"`
-	EnableGeoZoneEU         bool   `json:"EnableGeoZoneEU,omitempty"`
-	EnableGeoZoneNA         bool   `json:"EnableGeoZoneNA,omitempty"`
-	EnableGeoZoneSA         bool   `json:"EnableGeoZoneSA,omitempty"`
-	EnableGeoZoneAF         bool   `json:"EnableGeoZoneAF,omitempty"`
-	EnableOriginShield      bool   `json:"EnableOriginShield"`
-	OriginShieldZoneCode    string `json:"OriginShieldZoneCode,omitempty"`
-	EnableAutoSSL           bool   `json:"EnableAutoSSL"`
-	EnableBrotliCompression bool   `json:"EnableBrotliCompression"`
-	CacheExpirationTime     int    `json:"CacheExpirationTime"`
+	Name                    string       `json:"Name"`
+	OriginURL               string       `json:"OriginUrl"`
+	OriginHostHeader        string       `json:"OriginHostHeader,omitempty"`
+	VerifyOriginSSL         bool         `json:"VerifyOriginSSL"`
+	EnableGeoZoneASIA       bool         `json:"EnableGeoZoneASIA"`
+	EnableGeoZoneEU         bool         `json:"EnableGeoZoneEU,omitempty"`
+	EnableGeoZoneNA         bool         `json:"EnableGeoZoneNA,omitempty"`
+	EnableGeoZoneSA         bool         `json:"EnableGeoZoneSA,omitempty"`
+	EnableGeoZoneAF         bool         `json:"EnableGeoZoneAF,omitempty"`
+	EnableOriginShield      bool         `json:"EnableOriginShield"`
+	OriginShieldZoneCode    string       `json:"OriginShieldZoneCode,omitempty"`
+	EnableAutoSSL           bool         `json:"EnableAutoSSL"`
+	EnableBrotliCompression bool         `json:"EnableBrotliCompression"`
+	CacheExpirationTime     int          `json:"CacheExpirationTime"`
+	UseStaleWhileOffline    bool         `json:"UseStaleWhileOffline"`
+	UseStaleWhileUpdating   bool         `json:"UseStaleWhileUpdating"`
+	OriginConnectTimeout    int          `json:"OriginConnectTimeout,omitempty"`
+	OriginResponseTimeout   int          `json:"OriginResponseTimeout,omitempty"`
+	OriginRetries           int          `json:"OriginRetries"`
+	Type                    PullZoneType `json:"Type"`
 }
 
 // UpdatePullZoneRequest is the request to update a pull zone
@@ -89,6 +237,126 @@ type UpdatePullZoneRequest struct {
 	EnableAutoSSL           bool   `json:"EnableAutoSSL,omitempty"`
 	EnableBrotliCompression bool   `json:"EnableBrotliCompression,omitempty"`
 	CacheExpirationTime     int    `json:"CacheExpirationTime,omitempty"`
+
+	// Optimizer fields are pointers so they can be explicitly disabled
+	OptimizerEnabled      *bool `json:"OptimizerEnabled,omitempty"`
+	OptimizerAutoOptimize *bool `json:"OptimizerAutomaticOptimizationEnabled,omitempty"`
+	OptimizerEnableWebP   *bool `json:"OptimizerEnableWebP,omitempty"`
+	OptimizerMinifyCSS    *bool `json:"OptimizerMinifyCSS,omitempty"`
+	OptimizerMinifyJS     *bool `json:"OptimizerMinifyJavaScript,omitempty"`
+
+	// ZoneSecurityEnabled toggles URL token authentication
+	ZoneSecurityEnabled *bool `json:"ZoneSecurityEnabled,omitempty"`
+
+	// Origin outage handling; pointers so they can be explicitly disabled or zeroed
+	UseStaleWhileOffline  *bool `json:"UseStaleWhileOffline,omitempty"`
+	UseStaleWhileUpdating *bool `json:"UseStaleWhileUpdating,omitempty"`
+	OriginConnectTimeout  *int  `json:"OriginConnectTimeout,omitempty"`
+	OriginResponseTimeout *int  `json:"OriginResponseTimeout,omitempty"`
+	OriginRetries         *int  `json:"OriginRetries,omitempty"`
+
+	// Request logging; pointers so it can be explicitly disabled
+	EnableLogging                 *bool   `json:"EnableLogging,omitempty"`
+	LoggingIPAnonymizationEnabled *bool   `json:"LoggingIPAnonymizationEnabled,omitempty"`
+	LogAnonymizationType          *int    `json:"LogAnonymizationType,omitempty"`
+	LogForwardingEnabled          *bool   `json:"LogForwardingEnabled,omitempty"`
+	LogForwardingHostname         *string `json:"LogForwardingHostname,omitempty"`
+	LogForwardingPort             *int    `json:"LogForwardingPort,omitempty"`
+	LogForwardingToken            *string `json:"LogForwardingToken,omitempty"`
+	LogForwardingProtocol         *int    `json:"LogForwardingProtocol,omitempty"`
+	LogForwardingFormat           *int    `json:"LogForwardingFormat,omitempty"`
+
+	// Type is a pointer so a zone can be converted back to the standard tier
+	Type *PullZoneType `json:"Type,omitempty"`
+
+	// VerifyOriginSSL is a pointer so verification can be explicitly disabled
+	VerifyOriginSSL *bool `json:"VerifyOriginSSL,omitempty"`
+}
+
+// PullZoneOptions holds the per-profile settings used when creating a pull zone
+type PullZoneOptions struct {
+	Name     string // Also the <name>.b-cdn.net hostname, which cannot change
+	Type     PullZoneType
+	Origin   OriginSettings
+	Endpoint OriginEndpoint
+	// GeoZones are the geo zones served from, see GeoZones; empty serves
+	// from Asia and Oceania only
+	GeoZones []string
+}
+
+// OriginEndpoint describes how a pull zone connects to its origin
+type OriginEndpoint struct {
+	Protocol   string // "http" or "https"; empty is http
+	Port       int    // 0 uses the protocol's default port
+	HostHeader string // Empty sends the pull zone's domain
+	VerifySSL  bool   // Verify the origin certificate (https only)
+}
+
+// Scheme returns the lowercased protocol, defaulting to http
+func (e OriginEndpoint) Scheme() string {
+	if scheme := strings.ToLower(e.Protocol); scheme != "" {
+		return scheme
+	}
+	return "http"
+}
+
+// URL returns the origin URL for an origin IP, omitting the port when it is
+// the protocol's default
+func (e OriginEndpoint) URL(originIP string) string {
+	scheme := e.Scheme()
+	defaultPort := 80
+	if scheme == "https" {
+		defaultPort = 443
+	}
+
+	host := originIP
+	if e.Port != 0 && e.Port != defaultPort {
+		host = net.JoinHostPort(originIP, strconv.Itoa(e.Port))
+	} else if strings.Contains(originIP, ":") {
+		host = "[" + originIP + "]"
+	}
+	return scheme + "://" + host
+}
+
+// Host returns the host header sent to the origin for a domain
+func (e OriginEndpoint) Host(domain string) string {
+	if e.HostHeader != "" {
+		return e.HostHeader
+	}
+	return domain
+}
+
+// OriginSettings controls how a pull zone behaves when its origin is slow
+// or unreachable
+type OriginSettings struct {
+	StaleWhileOffline  bool
+	StaleWhileUpdating bool
+	ConnectTimeout     int // Seconds
+	ResponseTimeout    int // Seconds
+	Retries            int
+}
+
+// OptimizerSettings describes the Bunny Optimizer configuration of a pull zone
+type OptimizerSettings struct {
+	Enabled      bool
+	AutoOptimize bool // Automatic image optimization
+	WebP         bool // Serve WebP to supporting browsers
+	MinifyCSS    bool
+	MinifyJS     bool
+}
+
+// ReferrerSettings describes the referrer (hotlink protection) rules of a pull zone
+// An empty Allowed list allows every referrer not in Blocked
+type ReferrerSettings struct {
+	Allowed         []string `json:"allowed"`
+	Blocked         []string `json:"blocked"`
+	BlockNoReferrer bool     `json:"block_no_referrer"`
+}
+
+// AccessRules describes the country and IP blocking rules of a pull zone
+type AccessRules struct {
+	BlockedCountries []string `json:"blocked_countries"` // ISO 3166-1 alpha-2 codes
+	BlockedIPs       []string `json:"blocked_ips"`
 }
 
 // AddHostnameRequest is the request to add a hostname to a pull zone
@@ -96,9 +364,13 @@ type AddHostnameRequest struct {
 	Hostname string `json:"Hostname"`
 }
 
-// PullZoneListResponse is the response from listing pull zones
+// PullZoneListResponse is the response from listing pull zones, one page
+// at a time
 type PullZoneListResponse struct {
-	Items []PullZone `json:"Items"`
+	Items        []PullZone `json:"Items"`
+	CurrentPage  int        `json:"CurrentPage,omitempty"`
+	TotalItems   int        `json:"TotalItems,omitempty"`
+	HasMoreItems bool       `json:"HasMoreItems,omitempty"`
 }
 
 // SSLCertificatesResponse is the response from listing SSL certificates
@@ -108,31 +380,44 @@ type SSLCertificatesResponse struct {
 
 // CreatePullZone creates a new pull zone
 // API: POST /pullzone
-func (c *Client) CreatePullZone(ctx context.Context, domain, originIP string) (*PullZone, error) {
+func (c *Client) CreatePullZone(ctx context.Context, domain, originIP string, opts PullZoneOptions) (*PullZone, error) {
 	if domain == "" {
 		return nil, fmt.Errorf("domain is required")
 	}
 	if originIP == "" {
 		return nil, fmt.Errorf("origin IP is required")
 	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("pull zone name is required")
+	}
 
-	// Generate pull zone name: morden-example-com (replace dots with dashes)
-	zoneName := generatePullZoneName(domain)
+	geoZones := opts.GeoZones
+	if len(geoZones) == 0 {
+		geoZones = []string{GeoZoneAsia}
+	}
 
+	origin := opts.Origin
 	req := &CreatePullZoneRequest{
-		Name:                    zoneName,
-		OriginURL:               fmt.Sprintf("http://%s", originIP),
-		OriginHostHeader:        domain,
-		EnableGeoZoneASIA:       true, // ONLY Asia+Oceania
-		EnableGeoZoneEU:         false,
-		EnableGeoZoneNA:         false,
-		EnableGeoZoneSA:         false,
-		EnableGeoZoneAF:         false,
+		Name:                    opts.Name,
+		OriginURL:               opts.Endpoint.URL(originIP),
+		OriginHostHeader:        opts.Endpoint.Host(domain),
+		VerifyOriginSSL:         opts.Endpoint.VerifySSL,
+		EnableGeoZoneASIA:       slices.Contains(geoZones, GeoZoneAsia),
+		EnableGeoZoneEU:         slices.Contains(geoZones, GeoZoneEU),
+		EnableGeoZoneNA:         slices.Contains(geoZones, GeoZoneNA),
+		EnableGeoZoneSA:         slices.Contains(geoZones, GeoZoneSA),
+		EnableGeoZoneAF:         slices.Contains(geoZones, GeoZoneAF),
 		EnableOriginShield:      true,
 		OriginShieldZoneCode:    "SG", // Singapore
 		EnableAutoSSL:           true,
 		EnableBrotliCompression: true,
 		CacheExpirationTime:     1440, // 24 hours
+		UseStaleWhileOffline:    origin.StaleWhileOffline,
+		UseStaleWhileUpdating:   origin.StaleWhileUpdating,
+		OriginConnectTimeout:    origin.ConnectTimeout,
+		OriginResponseTimeout:   origin.ResponseTimeout,
+		OriginRetries:           origin.Retries,
+		Type:                    opts.Type,
 	}
 
 	var zone PullZone
@@ -145,6 +430,7 @@ func (c *Client) CreatePullZone(ctx context.Context, domain, originIP string) (*
 		zap.Int64("zone_id", zone.ID),
 		zap.String("name", zone.Name),
 		zap.String("domain", domain),
+		zap.Stringer("tier", opts.Type),
 	)
 
 	return &zone, nil
@@ -168,39 +454,86 @@ func (c *Client) GetPullZone(ctx context.Context, zoneID int64) (*PullZone, erro
 }
 
 // GetPullZoneByName retrieves a pull zone by name
-// Bunny.net doesn't have a direct "get by name" endpoint, so we list all zones
+// Bunny.net doesn't have a direct "get by name" endpoint, so we list the
+// zones until it is found
 func (c *Client) GetPullZoneByName(ctx context.Context, name string) (*PullZone, error) {
-	if name == "" {
+	return c.FindPullZone(ctx, name)
+}
+
+// FindPullZone retrieves the pull zone with the first of names that exists,
+// listing the zones once
+func (c *Client) FindPullZone(ctx context.Context, names ...string) (*PullZone, error) {
+	if len(names) == 0 || slices.Contains(names, "") {
 		return nil, fmt.Errorf("name is required")
 	}
 
-	zones, err := c.ListPullZones(ctx)
+	found := make(map[string]PullZone)
+	err := c.ForEachPullZone(ctx, func(zone PullZone) error {
+		if slices.Contains(names, zone.Name) {
+			found[zone.Name] = zone
+			if zone.Name == names[0] {
+				return errStopIteration
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	for _, zone := range zones {
-		if zone.Name == name {
+	for _, name := range names {
+		if zone, ok := found[name]; ok {
 			return &zone, nil
 		}
 	}
 
 	return nil, &APIError{
 		StatusCode: http.StatusNotFound,
-		Message:    fmt.Sprintf("Pull zone with name %s not found", name),
+		Message:    fmt.Sprintf("Pull zone with name %s not found", strings.Join(names, " or ")),
 	}
 }
 
 // ListPullZones lists all pull zones
-// API: GET /pullzone
+// Large accounts should use ForEachPullZone, which does not hold every zone
+// in memory
 func (c *Client) ListPullZones(ctx context.Context) ([]PullZone, error) {
-	var resp PullZoneListResponse
-	err := c.get(ctx, "/pullzone", &resp)
+	var zones []PullZone
+	err := c.ForEachPullZone(ctx, func(zone PullZone) error {
+		zones = append(zones, zone)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return zones, nil
+}
 
-	return resp.Items, nil
+// ForEachPullZone calls fn with each pull zone, fetching them a page at a
+// time. An error returned by fn stops the listing and is returned
+// API: GET /pullzone?page={n}&perPage={ListPageSize}
+func (c *Client) ForEachPullZone(ctx context.Context, fn func(zone PullZone) error) error {
+	for page := 1; ; page++ {
+		var resp PullZoneListResponse
+		path := fmt.Sprintf("/pullzone?page=%d&perPage=%d", page, ListPageSize)
+		if err := c.get(ctx, path, &resp); err != nil {
+			return err
+		}
+
+		for _, zone := range resp.Items {
+			if err := fn(zone); err != nil {
+				if errors.Is(err, errStopIteration) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		if !resp.HasMoreItems || len(resp.Items) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
 }
 
 // UpdatePullZone updates a pull zone
@@ -223,6 +556,201 @@ func (c *Client) UpdatePullZone(ctx context.Context, zoneID int64, req *UpdatePu
 	return nil
 }
 
+// SetOptimizer enables or disables Bunny Optimizer on a pull zone
+// When disabling, only the master switch is sent so sub-options are preserved
+// API: POST /pullzone/{id}
+func (c *Client) SetOptimizer(ctx context.Context, zoneID int64, settings OptimizerSettings) error {
+	req := &UpdatePullZoneRequest{OptimizerEnabled: &settings.Enabled}
+	if settings.Enabled {
+		req.OptimizerAutoOptimize = &settings.AutoOptimize
+		req.OptimizerEnableWebP = &settings.WebP
+		req.OptimizerMinifyCSS = &settings.MinifyCSS
+		req.OptimizerMinifyJS = &settings.MinifyJS
+	}
+
+	if err := c.UpdatePullZone(ctx, zoneID, req); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone optimizer updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Bool("enabled", settings.Enabled),
+	)
+	return nil
+}
+
+// SetPullZoneType converts a pull zone to another pricing tier
+// Bunny rejects conversions it does not support; the API error is returned as is
+// API: POST /pullzone/{id}
+func (c *Client) SetPullZoneType(ctx context.Context, zoneID int64, zoneType PullZoneType) error {
+	if err := c.UpdatePullZone(ctx, zoneID, &UpdatePullZoneRequest{Type: &zoneType}); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone tier updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Stringer("tier", zoneType),
+	)
+	return nil
+}
+
+// SetOriginEndpoint points a pull zone at its origin with the given
+// protocol, port, host header and certificate verification
+// API: POST /pullzone/{id}
+func (c *Client) SetOriginEndpoint(ctx context.Context, zoneID int64, domain, originIP string, endpoint OriginEndpoint) error {
+	if originIP == "" {
+		return fmt.Errorf("origin IP is required")
+	}
+
+	req := &UpdatePullZoneRequest{
+		OriginURL:        endpoint.URL(originIP),
+		OriginHostHeader: endpoint.Host(domain),
+		VerifyOriginSSL:  &endpoint.VerifySSL,
+	}
+	if err := c.UpdatePullZone(ctx, zoneID, req); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone origin updated",
+		zap.Int64("zone_id", zoneID),
+		zap.String("origin_url", req.OriginURL),
+		zap.String("host_header", req.OriginHostHeader),
+	)
+	return nil
+}
+
+// SetTokenAuthentication enables or disables URL token authentication on a pull zone
+// API: POST /pullzone/{id}
+func (c *Client) SetTokenAuthentication(ctx context.Context, zoneID int64, enabled bool) error {
+	req := &UpdatePullZoneRequest{ZoneSecurityEnabled: &enabled}
+	if err := c.UpdatePullZone(ctx, zoneID, req); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone token authentication updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Bool("enabled", enabled),
+	)
+	return nil
+}
+
+// ResetSecurityKey sets the token authentication key of a pull zone
+// API: POST /pullzone/{id}/resetSecurityKey
+func (c *Client) ResetSecurityKey(ctx context.Context, zoneID int64, key string) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+	if key == "" {
+		return fmt.Errorf("security key is required")
+	}
+
+	path := fmt.Sprintf("/pullzone/%d/resetSecurityKey", zoneID)
+	req := map[string]string{"SecurityKey": key}
+	if err := c.post(ctx, path, req, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone security key reset", zap.Int64("zone_id", zoneID))
+	return nil
+}
+
+// SetOriginSettings updates the origin outage handling of a pull zone
+// API: POST /pullzone/{id}
+func (c *Client) SetOriginSettings(ctx context.Context, zoneID int64, origin OriginSettings) error {
+	req := &UpdatePullZoneRequest{
+		UseStaleWhileOffline:  &origin.StaleWhileOffline,
+		UseStaleWhileUpdating: &origin.StaleWhileUpdating,
+		OriginRetries:         &origin.Retries,
+	}
+	if origin.ConnectTimeout > 0 {
+		req.OriginConnectTimeout = &origin.ConnectTimeout
+	}
+	if origin.ResponseTimeout > 0 {
+		req.OriginResponseTimeout = &origin.ResponseTimeout
+	}
+
+	if err := c.UpdatePullZone(ctx, zoneID, req); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone origin settings updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Bool("stale_while_offline", origin.StaleWhileOffline),
+		zap.Int("connect_timeout", origin.ConnectTimeout),
+		zap.Int("response_timeout", origin.ResponseTimeout),
+	)
+	return nil
+}
+
+// SetReferrers replaces the referrer rules of a pull zone
+// API: POST /pullzone/{id}
+func (c *Client) SetReferrers(ctx context.Context, zoneID int64, settings ReferrerSettings) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+
+	// Send all fields explicitly so lists can be cleared
+	allowed := settings.Allowed
+	if allowed == nil {
+		allowed = []string{}
+	}
+	blocked := settings.Blocked
+	if blocked == nil {
+		blocked = []string{}
+	}
+	req := map[string]interface{}{
+		"AllowedReferrers":  allowed,
+		"BlockedReferrers":  blocked,
+		"BlockNoneReferrer": settings.BlockNoReferrer,
+	}
+
+	path := fmt.Sprintf("/pullzone/%d", zoneID)
+	if err := c.post(ctx, path, req, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone referrers updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Int("allowed", len(allowed)),
+		zap.Int("blocked", len(blocked)),
+	)
+	return nil
+}
+
+// SetAccessRules replaces the blocked countries and IPs of a pull zone
+// API: POST /pullzone/{id}
+func (c *Client) SetAccessRules(ctx context.Context, zoneID int64, rules AccessRules) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+
+	// Send all fields explicitly so lists can be cleared
+	countries := rules.BlockedCountries
+	if countries == nil {
+		countries = []string{}
+	}
+	ips := rules.BlockedIPs
+	if ips == nil {
+		ips = []string{}
+	}
+	req := map[string]interface{}{
+		"BlockedCountries": countries,
+		"BlockedIps":       ips,
+	}
+
+	path := fmt.Sprintf("/pullzone/%d", zoneID)
+	if err := c.post(ctx, path, req, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone access rules updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Int("blocked_countries", len(countries)),
+		zap.Int("blocked_ips", len(ips)),
+	)
+	return nil
+}
+
 // DeletePullZone deletes a pull zone
 // API: DELETE /pullzone/{id}
 func (c *Client) DeletePullZone(ctx context.Context, zoneID int64) error {
@@ -368,25 +896,35 @@ func (c *Client) RemovePullZoneHostname(ctx context.Context, zoneID int64, hostn
 // GetSSLCertificate retrieves SSL certificate for a pull zone hostname
 // API: GET /pullzone/{id}/certificates
 func (c *Client) GetSSLCertificate(ctx context.Context, zoneID int64) (*SSLCertificate, error) {
-	if zoneID <= 0 {
-		return nil, fmt.Errorf("zone ID must be positive")
-	}
-
-	var resp SSLCertificatesResponse
-	path := fmt.Sprintf("/pullzone/%d/certificates", zoneID)
-	err := c.get(ctx, path, &resp)
+	certs, err := c.ListSSLCertificates(ctx, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Items) == 0 {
+	if len(certs) == 0 {
 		return nil, &APIError{
 			StatusCode: http.StatusNotFound,
 			Message:    "No SSL certificate found for this pull zone",
 		}
 	}
 
-	return &resp.Items[0], nil
+	return &certs[0], nil
+}
+
+// ListSSLCertificates retrieves the SSL certificates of all hostnames of a pull zone
+// API: GET /pullzone/{id}/certificates
+func (c *Client) ListSSLCertificates(ctx context.Context, zoneID int64) ([]SSLCertificate, error) {
+	if zoneID <= 0 {
+		return nil, fmt.Errorf("zone ID must be positive")
+	}
+
+	var resp SSLCertificatesResponse
+	path := fmt.Sprintf("/pullzone/%d/certificates", zoneID)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Items, nil
 }
 
 // AddCertificate adds a custom SSL certificate to a pull zone
@@ -441,14 +979,53 @@ func (c *Client) ForceSSLCertificate(ctx context.Context, zoneID int64) error {
 	return nil
 }
 
-// generatePullZoneName generates a pull zone name from a domain
-// e.g., "example.com" -> "morden-example-com"
-func generatePullZoneName(domain string) string {
-	// Convert domain to lowercase and replace dots with dashes
-	name := strings.ToLower(domain)
-	name = strings.ReplaceAll(name, ".", "-")
-	// Prefix with morden-
-	return "morden-" + name
+// LoadFreeCertificate requests a free Let's Encrypt certificate for a pull
+// zone hostname. Bunny issues it asynchronously once the hostname resolves
+// to the CDN; GetPullZone reports it through Hostname.HasCertificate
+// API: GET /pullzone/loadFreeCertificate?hostname={hostname}
+func (c *Client) LoadFreeCertificate(ctx context.Context, hostname string) error {
+	if hostname == "" {
+		return fmt.Errorf("hostname is required")
+	}
+
+	path := "/pullzone/loadFreeCertificate?hostname=" + url.QueryEscape(hostname)
+	err := c.get(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Free SSL certificate requested", zap.String("hostname", hostname))
+	return nil
+}
+
+// SetHostnameForceSSL enables or disables redirecting HTTP to HTTPS on a
+// pull zone hostname
+// API: POST /pullzone/{id}/setForceSSL
+func (c *Client) SetHostnameForceSSL(ctx context.Context, zoneID int64, hostname string, force bool) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+	if hostname == "" {
+		return fmt.Errorf("hostname is required")
+	}
+
+	req := map[string]interface{}{
+		"Hostname": hostname,
+		"ForceSSL": force,
+	}
+
+	path := fmt.Sprintf("/pullzone/%d/setForceSSL", zoneID)
+	err := c.post(ctx, path, req, nil)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Hostname force SSL set",
+		zap.Int64("zone_id", zoneID),
+		zap.String("hostname", hostname),
+		zap.Bool("force_ssl", force),
+	)
+	return nil
 }
 
 // GetPullZoneStats returns statistics for a pull zone
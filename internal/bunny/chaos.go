@@ -0,0 +1,151 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Fault is a failure injected into Bunny API calls
+type Fault string
+
+const (
+	// FaultRateLimit answers 429 Too Many Requests
+	FaultRateLimit Fault = "429"
+	// FaultServerError answers 500 Internal Server Error
+	FaultServerError Fault = "500"
+	// FaultTimeout fails the call with a timeout after Faults.Delay
+	FaultTimeout Fault = "timeout"
+)
+
+// DefaultFaultDelay is how long an injected timeout takes
+const DefaultFaultDelay = 5 * time.Second
+
+// Faults configures fault injection, used to check retries and recovery
+// under failures in staging. Requests never reach Bunny when a fault is
+// injected
+type Faults struct {
+	// Rate is the share of calls that fail, from 0 to 1
+	Rate float64
+	// Kinds are the faults to choose from; empty injects all of them
+	Kinds []Fault
+	// Delay is how long an injected timeout takes
+	Delay time.Duration
+}
+
+// WithFaults injects faults into a share of the API calls. Never use it in
+// production
+func WithFaults(f Faults) ClientOption {
+	return func(c *Client) {
+		c.faults = &f
+	}
+}
+
+// FaultRate returns the share of calls failed by fault injection, zero when
+// it is off
+func (c *Client) FaultRate() float64 {
+	if c.faults == nil {
+		return 0
+	}
+	return c.faults.Rate
+}
+
+// faultTransport fails a share of requests before they are sent
+type faultTransport struct {
+	next   http.RoundTripper
+	faults Faults
+	logger *zap.Logger
+	// roll returns a number in [0, 1) deciding whether a request fails
+	roll func() float64
+	// pick returns a number in [0, n) choosing the fault
+	pick func(n int) int
+}
+
+// newFaultTransport wraps next with the faults
+func newFaultTransport(next http.RoundTripper, faults Faults, logger *zap.Logger) *faultTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if len(faults.Kinds) == 0 {
+		faults.Kinds = []Fault{FaultRateLimit, FaultServerError, FaultTimeout}
+	}
+	if faults.Delay <= 0 {
+		faults.Delay = DefaultFaultDelay
+	}
+	return &faultTransport{
+		next:   next,
+		faults: faults,
+		logger: logger,
+		roll:   rand.Float64,
+		pick:   rand.IntN,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.faults.Rate <= 0 || t.roll() >= t.faults.Rate {
+		return t.next.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	fault := t.faults.Kinds[t.pick(len(t.faults.Kinds))]
+	t.logger.Info("injecting Bunny API fault",
+		zap.String("fault", string(fault)),
+		zap.String("method", req.Method),
+		zap.String("path", req.URL.Path),
+	)
+
+	switch fault {
+	case FaultRateLimit:
+		resp := faultResponse(req, http.StatusTooManyRequests)
+		resp.Header.Set("Retry-After", "1")
+		return resp, nil
+	case FaultTimeout:
+		timer := time.NewTimer(t.faults.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil, errInjectedTimeout
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	default:
+		return faultResponse(req, http.StatusInternalServerError), nil
+	}
+}
+
+// faultResponse builds an error response as Bunny would send it
+func faultResponse(req *http.Request, status int) *http.Response {
+	body := fmt.Sprintf(`{"Message":"injected fault: %s"}`, http.StatusText(status))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// injectedTimeout is the error of an injected timeout; like a real client
+// timeout it reports Timeout() and wraps context.DeadlineExceeded
+type injectedTimeout struct{}
+
+var errInjectedTimeout error = injectedTimeout{}
+
+func (injectedTimeout) Error() string   { return "injected fault: request timed out" }
+func (injectedTimeout) Timeout() bool   { return true }
+func (injectedTimeout) Temporary() bool { return true }
+func (injectedTimeout) Unwrap() error   { return context.DeadlineExceeded }
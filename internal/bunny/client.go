@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	goRetry "github.com/sethvargo/go-retry"
@@ -22,8 +24,14 @@ const (
 	AccessKeyHeader = "AccessKey"
 	// DefaultTimeout is the default HTTP timeout
 	DefaultTimeout = 30 * time.Second
+	// ListPageSize is the number of items requested per page when listing
+	// zones, the largest Bunny.net accepts
+	ListPageSize = 1000
 )
 
+// errStopIteration ends a ForEach listing early without an error
+var errStopIteration = errors.New("stop iteration")
+
 // APIError represents an error response from the Bunny.net API
 type APIError struct {
 	StatusCode int
@@ -62,6 +70,15 @@ type Client struct {
 	logger     *zap.Logger
 	retryCfg   *retry.Config
 	backoff    goRetry.Backoff
+	faults     *Faults
+	// responses caches GET responses for conditional requests; nil when
+	// disabled
+	responses *responseCache
+
+	// lastSuccess is the Unix nano time of the last successful API call
+	lastSuccess atomic.Int64
+	// endpoints times and counts the calls per endpoint and family
+	endpoints *endpointTracker
 }
 
 // ClientOption is a function that configures a Client
@@ -96,6 +113,18 @@ func WithRetryConfig(retryCfg *retry.Config) ClientOption {
 	}
 }
 
+// WithResponseCache sets how many GET responses are kept to make repeated
+// requests conditional on their ETag or Last-Modified header; 0 disables
+// the cache
+func WithResponseCache(entries int) ClientOption {
+	return func(c *Client) {
+		c.responses = nil
+		if entries > 0 {
+			c.responses = newResponseCache(entries)
+		}
+	}
+}
+
 // NewClient creates a new Bunny.net API client
 func NewClient(apiKey string, opts ...ClientOption) *Client {
 	c := &Client{
@@ -104,8 +133,10 @@ func NewClient(apiKey string, opts ...ClientOption) *Client {
 		httpClient: &http.Client{
 			Timeout: DefaultTimeout,
 		},
-		retryCfg: retry.DefaultConfig(),
-		logger:   zap.NewNop(), // No-op logger by default
+		retryCfg:  retry.DefaultConfig(),
+		logger:    zap.NewNop(), // No-op logger by default
+		responses: newResponseCache(DefaultResponseCacheEntries),
+		endpoints: newEndpointTracker(),
 	}
 
 	// Initialize backoff with default config
@@ -115,11 +146,23 @@ func NewClient(apiKey string, opts ...ClientOption) *Client {
 		opt(c)
 	}
 
+	if c.faults != nil {
+		// Copy the HTTP client so one passed in by WithHTTPClient is not changed
+		httpClient := *c.httpClient
+		httpClient.Transport = newFaultTransport(httpClient.Transport, *c.faults, c.logger)
+		c.httpClient = &httpClient
+	}
+
 	return c
 }
 
 // doRequest performs an HTTP request with retry logic
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	return c.doRequestWithBackoff(ctx, c.backoff, method, path, body, result)
+}
+
+// doRequestWithBackoff performs an HTTP request, retrying as backoff allows
+func (c *Client) doRequestWithBackoff(ctx context.Context, backoff goRetry.Backoff, method, path string, body interface{}, result interface{}) error {
 	var bodyReader io.Reader
 	if body != nil {
 		jsonData, err := json.Marshal(body)
@@ -153,13 +196,23 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 			req.Header.Set("Content-Type", "application/json")
 		}
 
+		// Polling unchanged resources is answered with 304 Not Modified
+		var cached *cachedResponse
+		if method == http.MethodGet && c.responses != nil {
+			if cached = c.responses.get(path); cached != nil {
+				cached.setValidators(req)
+			}
+		}
+
 		c.logger.Debug("making API request",
 			zap.String("method", method),
 			zap.String("path", path),
 		)
 
+		start := time.Now()
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
+			c.endpoints.record(method, path, time.Since(start), classify(ctx, 0, err))
 			c.logger.Warn("API request failed, will retry",
 				zap.String("method", method),
 				zap.String("path", path),
@@ -170,6 +223,7 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 		defer resp.Body.Close()
 
 		respBody, err := io.ReadAll(resp.Body)
+		c.endpoints.record(method, path, time.Since(start), classify(ctx, resp.StatusCode, err))
 		if err != nil {
 			return fmt.Errorf("failed to read response body: %w", err)
 		}
@@ -207,6 +261,20 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 			return goRetry.RetryableError(apiErr) // Mark as retryable
 		}
 
+		if c.responses != nil {
+			switch {
+			case resp.StatusCode == http.StatusNotModified && cached != nil:
+				c.logger.Debug("API response not modified, using cached copy",
+					zap.String("path", path),
+				)
+				respBody = cached.body
+			case method == http.MethodGet:
+				c.responses.put(path, resp.Header, respBody)
+			default:
+				c.responses.invalidate(path)
+			}
+		}
+
 		// Parse success response
 		if result != nil {
 			if err := json.Unmarshal(respBody, result); err != nil {
@@ -214,10 +282,20 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 			}
 		}
 
+		c.lastSuccess.Store(time.Now().UnixNano())
 		return nil // Success, no more retries
 	}
 
-	return goRetry.Do(ctx, c.backoff, retryFunc)
+	return goRetry.Do(ctx, backoff, retryFunc)
+}
+
+// LastSuccess returns when an API call last succeeded (zero if none has)
+func (c *Client) LastSuccess() time.Time {
+	ns := c.lastSuccess.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
 }
 
 // get performs a GET request
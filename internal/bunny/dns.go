@@ -2,10 +2,12 @@ package bunny
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	goRetry "github.com/sethvargo/go-retry"
 	"go.uber.org/zap"
 )
 
@@ -80,8 +82,9 @@ type CreateDNSZoneRequest struct {
 }
 
 // UpdateDNSZoneRequest is the request to update a DNS zone
+// Fields left nil or empty are not changed
 type UpdateDNSZoneRequest struct {
-	UserEnabled bool   `json:"UserEnabled"`
+	UserEnabled *bool  `json:"UserEnabled,omitempty"`
 	SoaEmail    string `json:"SoaEmail,omitempty"`
 }
 
@@ -115,9 +118,13 @@ type UpdateDNSRecordRequest struct {
 	DisableLinks bool          `json:"DisableLinks,omitempty"`
 }
 
-// DNSZoneListResponse is the response from listing DNS zones
+// DNSZoneListResponse is the response from listing DNS zones, one page at
+// a time
 type DNSZoneListResponse struct {
-	Items []DNSZone `json:"Items"`
+	Items        []DNSZone `json:"Items"`
+	CurrentPage  int       `json:"CurrentPage,omitempty"`
+	TotalItems   int       `json:"TotalItems,omitempty"`
+	HasMoreItems bool      `json:"HasMoreItems,omitempty"`
 }
 
 // DNSRecordsResponse is the response from getting DNS records
@@ -159,16 +166,20 @@ func (c *Client) GetDNSZone(ctx context.Context, domain string) (*DNSZone, error
 	}
 
 	// Bunny.net doesn't have a direct "get by domain" endpoint
-	// We need to list zones and find the matching one
-	zones, err := c.ListDNSZones(ctx)
+	// We need to list zones until the matching one is found
+	var found *DNSZone
+	err := c.ForEachDNSZone(ctx, func(zone DNSZone) error {
+		if zone.Domain == domain {
+			found = &zone
+			return errStopIteration
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	for _, zone := range zones {
-		if zone.Domain == domain {
-			return &zone, nil
-		}
+	if found != nil {
+		return found, nil
 	}
 
 	return nil, &APIError{
@@ -195,15 +206,45 @@ func (c *Client) GetDNSZoneByID(ctx context.Context, zoneID int64) (*DNSZone, er
 }
 
 // ListDNSZones lists all DNS zones
-// API: GET /dns
 func (c *Client) ListDNSZones(ctx context.Context) ([]DNSZone, error) {
-	var resp DNSZoneListResponse
-	err := c.get(ctx, "/dns", &resp)
+	var zones []DNSZone
+	err := c.ForEachDNSZone(ctx, func(zone DNSZone) error {
+		zones = append(zones, zone)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return zones, nil
+}
 
-	return resp.Items, nil
+// ForEachDNSZone calls fn with each DNS zone, fetching them a page at a
+// time. An error returned by fn stops the listing and is returned
+// API: GET /dns?page={n}&perPage={ListPageSize}
+func (c *Client) ForEachDNSZone(ctx context.Context, fn func(zone DNSZone) error) error {
+	for page := 1; ; page++ {
+		var resp DNSZoneListResponse
+		path := fmt.Sprintf("/dns?page=%d&perPage=%d", page, ListPageSize)
+		if err := c.get(ctx, path, &resp); err != nil {
+			return err
+		}
+
+		for _, zone := range resp.Items {
+			if err := fn(zone); err != nil {
+				if errors.Is(err, errStopIteration) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		if !resp.HasMoreItems || len(resp.Items) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
 }
 
 // UpdateDNSZone updates a DNS zone
@@ -246,6 +287,11 @@ func (c *Client) DeleteDNSZone(ctx context.Context, zoneID int64) error {
 // AddDNSRecord adds a DNS record to a zone
 // API: POST /dns/{id}/records
 func (c *Client) AddDNSRecord(ctx context.Context, zoneID int64, req *AddDNSRecordRequest) (*DNSRecord, error) {
+	return c.addDNSRecord(ctx, c.backoff, zoneID, req)
+}
+
+// addDNSRecord adds a DNS record, retrying with backoff
+func (c *Client) addDNSRecord(ctx context.Context, backoff goRetry.Backoff, zoneID int64, req *AddDNSRecordRequest) (*DNSRecord, error) {
 	if zoneID <= 0 {
 		return nil, fmt.Errorf("zone ID must be positive")
 	}
@@ -262,7 +308,7 @@ func (c *Client) AddDNSRecord(ctx context.Context, zoneID int64, req *AddDNSReco
 	path := fmt.Sprintf("/dns/%d/records", zoneID)
 
 	var record DNSRecord
-	err := c.post(ctx, path, req, &record)
+	err := c.doRequestWithBackoff(ctx, backoff, http.MethodPost, path, req, &record)
 	if err != nil {
 		return nil, err
 	}
@@ -317,6 +363,11 @@ func (c *Client) GetDNSRecord(ctx context.Context, zoneID int64, recordID int64)
 // UpdateDNSRecord updates a DNS record
 // API: POST /dns/{id}/records/{recordId}
 func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID int64, recordID int64, req *UpdateDNSRecordRequest) error {
+	return c.updateDNSRecord(ctx, c.backoff, zoneID, recordID, req)
+}
+
+// updateDNSRecord updates a DNS record, retrying with backoff
+func (c *Client) updateDNSRecord(ctx context.Context, backoff goRetry.Backoff, zoneID int64, recordID int64, req *UpdateDNSRecordRequest) error {
 	if zoneID <= 0 {
 		return fmt.Errorf("zone ID must be positive")
 	}
@@ -328,7 +379,7 @@ func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID int64, recordID int
 	}
 
 	path := fmt.Sprintf("/dns/%d/records/%d", zoneID, recordID)
-	err := c.post(ctx, path, req, nil)
+	err := c.doRequestWithBackoff(ctx, backoff, http.MethodPost, path, req, nil)
 	if err != nil {
 		return err
 	}
@@ -341,9 +392,53 @@ func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID int64, recordID int
 	return nil
 }
 
+// SetDNSRecordEnabled disables or enables a DNS record, keeping its other
+// fields; a disabled record stays in the zone but is not served. It
+// returns the record as updated
+// API: GET then POST /dns/{id}/records/{recordId}
+func (c *Client) SetDNSRecordEnabled(ctx context.Context, zoneID int64, recordID int64, enabled bool) (*DNSRecord, error) {
+	record, err := c.GetDNSRecord(ctx, zoneID, recordID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Enabled == enabled {
+		return record, nil
+	}
+
+	record.Enabled = enabled
+	err = c.UpdateDNSRecord(ctx, zoneID, recordID, &UpdateDNSRecordRequest{
+		Type:         record.Type,
+		Name:         record.Name,
+		Value:        record.Value,
+		TTL:          record.TTL,
+		Priority:     record.Priority,
+		Weight:       record.Weight,
+		Flags:        record.Flags,
+		Tag:          record.Tag,
+		Port:         record.Port,
+		Enabled:      enabled,
+		DisableLinks: record.DisableLinks,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("DNS record toggled",
+		zap.Int64("zone_id", zoneID),
+		zap.Int64("record_id", recordID),
+		zap.Bool("enabled", enabled),
+	)
+	return record, nil
+}
+
 // DeleteDNSRecord deletes a DNS record
 // API: DELETE /dns/{id}/records/{recordId}
 func (c *Client) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int64) error {
+	return c.deleteDNSRecord(ctx, c.backoff, zoneID, recordID)
+}
+
+// deleteDNSRecord deletes a DNS record, retrying with backoff
+func (c *Client) deleteDNSRecord(ctx context.Context, backoff goRetry.Backoff, zoneID int64, recordID int64) error {
 	if zoneID <= 0 {
 		return fmt.Errorf("zone ID must be positive")
 	}
@@ -352,7 +447,7 @@ func (c *Client) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int
 	}
 
 	path := fmt.Sprintf("/dns/%d/records/%d", zoneID, recordID)
-	err := c.delete(ctx, path)
+	err := c.doRequestWithBackoff(ctx, backoff, http.MethodDelete, path, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,94 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.uber.org/zap"
+)
+
+// EdgeRuleActionRedirect redirects matching requests to ActionParameter1
+// with the status code in ActionParameter2
+const EdgeRuleActionRedirect = 1
+
+// EdgeRuleTriggerURL matches the request URL against the patterns
+const EdgeRuleTriggerURL = 0
+
+// EdgeRuleMatchAny matches when any pattern or trigger matches
+const EdgeRuleMatchAny = 0
+
+// EdgeRule is a rule a pull zone applies at the edge
+type EdgeRule struct {
+	GUID                string            `json:"Guid,omitempty"`
+	ActionType          int               `json:"ActionType"`
+	ActionParameter1    string            `json:"ActionParameter1,omitempty"`
+	ActionParameter2    string            `json:"ActionParameter2,omitempty"`
+	Triggers            []EdgeRuleTrigger `json:"Triggers"`
+	TriggerMatchingType int               `json:"TriggerMatchingType"`
+	Description         string            `json:"Description,omitempty"`
+	Enabled             bool              `json:"Enabled"`
+}
+
+// EdgeRuleTrigger is a condition of an edge rule; patterns may use *
+// wildcards
+type EdgeRuleTrigger struct {
+	Type                int      `json:"Type"`
+	PatternMatches      []string `json:"PatternMatches"`
+	PatternMatchingType int      `json:"PatternMatchingType"`
+}
+
+// FindEdgeRule returns the edge rule with description, if any
+func (pz *PullZone) FindEdgeRule(description string) *EdgeRule {
+	for i := range pz.EdgeRules {
+		if pz.EdgeRules[i].Description == description {
+			return &pz.EdgeRules[i]
+		}
+	}
+	return nil
+}
+
+// AddOrUpdateEdgeRule adds an edge rule to a pull zone, or replaces the
+// rule with the same GUID
+// API: POST /pullzone/{id}/edgerules/addOrUpdate
+func (c *Client) AddOrUpdateEdgeRule(ctx context.Context, zoneID int64, rule EdgeRule) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+	if len(rule.Triggers) == 0 {
+		return fmt.Errorf("edge rule needs at least one trigger")
+	}
+
+	path := fmt.Sprintf("/pullzone/%d/edgerules/addOrUpdate", zoneID)
+	if err := c.post(ctx, path, rule, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone edge rule saved",
+		zap.Int64("zone_id", zoneID),
+		zap.String("description", rule.Description),
+	)
+	return nil
+}
+
+// DeleteEdgeRule removes an edge rule from a pull zone
+// API: DELETE /pullzone/{id}/edgerules/{guid}
+func (c *Client) DeleteEdgeRule(ctx context.Context, zoneID int64, guid string) error {
+	if zoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+	if guid == "" {
+		return fmt.Errorf("edge rule GUID is required")
+	}
+
+	path := fmt.Sprintf("/pullzone/%d/edgerules/%s", zoneID, url.PathEscape(guid))
+	if err := c.delete(ctx, path); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone edge rule deleted",
+		zap.Int64("zone_id", zoneID),
+		zap.String("guid", guid),
+	)
+	return nil
+}
@@ -0,0 +1,308 @@
+package bunny
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Family groups the API endpoints whose availability is tracked together,
+// so an outage of one part of the Bunny API is told apart from another
+type Family string
+
+const (
+	FamilyDNS      Family = "dns"
+	FamilyPullZone Family = "pullzone"
+	FamilyStats    Family = "stats"
+	FamilyStorage  Family = "storage"
+	FamilyOther    Family = "other"
+)
+
+// Families lists every endpoint family
+var Families = []Family{FamilyDNS, FamilyPullZone, FamilyStats, FamilyStorage, FamilyOther}
+
+// Name returns how alerts name the family, e.g. "Bunny DNS API"
+func (f Family) Name() string {
+	switch f {
+	case FamilyDNS:
+		return "Bunny DNS API"
+	case FamilyPullZone:
+		return "Bunny Pull Zone API"
+	case FamilyStats:
+		return "Bunny Statistics API"
+	case FamilyStorage:
+		return "Bunny Storage API"
+	default:
+		return "Bunny API"
+	}
+}
+
+const (
+	// DefaultHealthWindow is how far back the availability of a family is
+	// computed
+	DefaultHealthWindow = 15 * time.Minute
+	// DefaultHealthAvailability is the percent of calls of a family that
+	// must succeed; the rest is its error budget
+	DefaultHealthAvailability = 95.0
+	// DefaultHealthMinRequests is how many calls a family needs in the
+	// window before it can be degraded
+	DefaultHealthMinRequests = 20
+
+	// healthBucket is the resolution of the rolling window
+	healthBucket = time.Minute
+)
+
+// Objective is the availability each endpoint family must keep over a
+// rolling window
+type Objective struct {
+	Window       time.Duration
+	Availability float64 // Percent of calls that must not fail
+	MinRequests  int     // Calls in the window before a family can be degraded
+}
+
+// WithObjective sets the availability objective of the endpoint families
+func WithObjective(o Objective) ClientOption {
+	return func(c *Client) {
+		c.endpoints.objective = o
+	}
+}
+
+// EndpointStats counts the calls of one endpoint since the client was
+// created; retries are counted as calls
+type EndpointStats struct {
+	// Endpoint is the method and path with IDs replaced, e.g.
+	// "GET /pullzone/{id}"
+	Endpoint string `json:"endpoint"`
+	Family   Family `json:"family"`
+	Requests int64  `json:"requests"`
+	// Errors are the calls that count against the availability: network
+	// errors, timeouts, 429 and 5xx answers
+	Errors int64 `json:"errors"`
+	// ClientErrors are the other 4xx answers, such as lookups of zones that
+	// do not exist
+	ClientErrors int64   `json:"client_errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+}
+
+// FamilyHealth is the availability of an endpoint family over the rolling
+// window
+type FamilyHealth struct {
+	Family       Family  `json:"family"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	Availability float64 `json:"availability"` // Percent
+	// Degraded is set while the availability is below the objective and
+	// the family had at least its minimum of calls
+	Degraded bool `json:"degraded"`
+}
+
+// outcome is how a call ended, as far as the availability is concerned
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeClientError
+	outcomeError
+	// outcomeCanceled is a call the caller gave up on; it is not counted
+	outcomeCanceled
+)
+
+// classify returns the outcome of a call that got status or failed with err
+func classify(ctx context.Context, status int, err error) outcome {
+	switch {
+	case err != nil && ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return outcomeCanceled
+	case err != nil, status >= 500, status == http.StatusTooManyRequests:
+		return outcomeError
+	case status >= 400:
+		return outcomeClientError
+	default:
+		return outcomeSuccess
+	}
+}
+
+// endpointCounters are the counts behind EndpointStats
+type endpointCounters struct {
+	family       Family
+	requests     int64
+	errors       int64
+	clientErrors int64
+	total        time.Duration
+	max          time.Duration
+}
+
+// healthBucketCounts counts the calls of a family in one healthBucket
+type healthBucketCounts struct {
+	start    time.Time
+	requests int
+	errors   int
+}
+
+// endpointTracker records the calls of a client per endpoint and family
+type endpointTracker struct {
+	mu        sync.Mutex
+	objective Objective
+	endpoints map[string]*endpointCounters
+	buckets   map[Family][]healthBucketCounts
+	now       func() time.Time
+}
+
+// newEndpointTracker creates a tracker with the default objective
+func newEndpointTracker() *endpointTracker {
+	return &endpointTracker{
+		objective: Objective{
+			Window:       DefaultHealthWindow,
+			Availability: DefaultHealthAvailability,
+			MinRequests:  DefaultHealthMinRequests,
+		},
+		endpoints: make(map[string]*endpointCounters),
+		buckets:   make(map[Family][]healthBucketCounts),
+		now:       time.Now,
+	}
+}
+
+// record counts a call of method on path that took latency
+func (t *endpointTracker) record(method, path string, latency time.Duration, result outcome) {
+	if result == outcomeCanceled {
+		return
+	}
+	endpoint, family := endpointOf(method, path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counters := t.endpoints[endpoint]
+	if counters == nil {
+		counters = &endpointCounters{family: family}
+		t.endpoints[endpoint] = counters
+	}
+	counters.requests++
+	counters.total += latency
+	counters.max = max(counters.max, latency)
+	switch result {
+	case outcomeError:
+		counters.errors++
+	case outcomeClientError:
+		counters.clientErrors++
+	}
+
+	start := t.now().Truncate(healthBucket)
+	buckets := t.prune(family)
+	if n := len(buckets); n == 0 || !buckets[n-1].start.Equal(start) {
+		buckets = append(buckets, healthBucketCounts{start: start})
+	}
+	last := &buckets[len(buckets)-1]
+	last.requests++
+	if result == outcomeError {
+		last.errors++
+	}
+	t.buckets[family] = buckets
+}
+
+// prune drops the buckets of family that left the window and returns the
+// rest
+// Caller must hold t.mu
+func (t *endpointTracker) prune(family Family) []healthBucketCounts {
+	since := t.now().Add(-t.objective.Window)
+	buckets := t.buckets[family]
+	i := 0
+	for i < len(buckets) && !buckets[i].start.Add(healthBucket).After(since) {
+		i++
+	}
+	buckets = buckets[i:]
+	t.buckets[family] = buckets
+	return buckets
+}
+
+// stats returns the counters of every endpoint called, sorted by endpoint
+func (t *endpointTracker) stats() []EndpointStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make([]EndpointStats, 0, len(t.endpoints))
+	for endpoint, c := range t.endpoints {
+		stats = append(stats, EndpointStats{
+			Endpoint:     endpoint,
+			Family:       c.family,
+			Requests:     c.requests,
+			Errors:       c.errors,
+			ClientErrors: c.clientErrors,
+			AvgLatencyMs: float64(c.total.Microseconds()) / float64(c.requests) / 1000,
+			MaxLatencyMs: float64(c.max.Microseconds()) / 1000,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Endpoint < stats[j].Endpoint })
+	return stats
+}
+
+// health returns the availability of every family called within the
+// window, in the order of Families
+func (t *endpointTracker) health() []FamilyHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var health []FamilyHealth
+	for _, family := range Families {
+		h := FamilyHealth{Family: family}
+		for _, b := range t.prune(family) {
+			h.Requests += b.requests
+			h.Errors += b.errors
+		}
+		if h.Requests == 0 {
+			continue
+		}
+		h.Availability = float64(h.Requests-h.Errors) / float64(h.Requests) * 100
+		h.Degraded = h.Requests >= t.objective.MinRequests && h.Availability < t.objective.Availability
+		health = append(health, h)
+	}
+	return health
+}
+
+// endpointOf returns the endpoint of a call, its path without the query and
+// with IDs replaced by {id}, and the endpoint's family
+// e.g., ("GET", "/pullzone/42/stats?Hourly=true") -> ("GET /pullzone/{id}/stats", FamilyStats)
+func endpointOf(method, path string) (string, Family) {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		// Zone and record IDs are numbers, edge rule IDs GUIDs
+		if strings.ContainsAny(segment, "0123456789") {
+			segments[i] = "{id}"
+		}
+	}
+
+	family := FamilyOther
+	switch last := segments[len(segments)-1]; {
+	case last == "stats" || last == "statistics" || segments[0] == "statistics":
+		family = FamilyStats
+	case segments[0] == "dns":
+		family = FamilyDNS
+	case segments[0] == "pullzone":
+		family = FamilyPullZone
+	case segments[0] == "storagezone":
+		family = FamilyStorage
+	}
+	return method + " /" + strings.Join(segments, "/"), family
+}
+
+// Endpoints returns the calls of each endpoint since the client was
+// created, sorted by endpoint
+func (c *Client) Endpoints() []EndpointStats {
+	return c.endpoints.stats()
+}
+
+// Health returns the availability of each endpoint family called within
+// the objective's window
+func (c *Client) Health() []FamilyHealth {
+	return c.endpoints.health()
+}
+
+// Objective returns the availability objective of the endpoint families
+func (c *Client) Objective() Objective {
+	return c.endpoints.objective
+}
@@ -0,0 +1,87 @@
+package bunny
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointOf(t *testing.T) {
+	tests := []struct {
+		method, path string
+		endpoint     string
+		family       Family
+	}{
+		{"GET", "/pullzone/42", "GET /pullzone/{id}", FamilyPullZone},
+		{"GET", "/pullzone?page=1&perPage=1000", "GET /pullzone", FamilyPullZone},
+		{"POST", "/pullzone/42/addHostname", "POST /pullzone/{id}/addHostname", FamilyPullZone},
+		{"DELETE", "/pullzone/42/edgerules/0b5c3c6e-7b1e-4d4e-9f0a-1c2d3e4f5a6b", "DELETE /pullzone/{id}/edgerules/{id}", FamilyPullZone},
+		{"GET", "/pullzone/42/stats?DateStart=2024-01-01&Hourly=true", "GET /pullzone/{id}/stats", FamilyStats},
+		{"GET", "/statistics?pullZone=42&loadErrors=true", "GET /statistics", FamilyStats},
+		{"GET", "/dns/7/statistics?dateFrom=2024-01-01", "GET /dns/{id}/statistics", FamilyStats},
+		{"DELETE", "/dns/7/records/9", "DELETE /dns/{id}/records/{id}", FamilyDNS},
+		{"GET", "/storagezone/3", "GET /storagezone/{id}", FamilyStorage},
+		{"GET", "/billing", "GET /billing", FamilyOther},
+	}
+	for _, tt := range tests {
+		endpoint, family := endpointOf(tt.method, tt.path)
+		assert.Equal(t, tt.endpoint, endpoint, tt.path)
+		assert.Equal(t, tt.family, family, tt.path)
+	}
+}
+
+func TestClient_Health(t *testing.T) {
+	// The DNS API fails, the pull zone API works and zone 404 does not exist
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/dns"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/pullzone/404":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{"Id":1}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithRetryConfig(fastRetry()), WithResponseCache(0),
+		WithObjective(Objective{Window: 10 * time.Minute, Availability: 90, MinRequests: 5}))
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	client.endpoints.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := client.GetDNSZoneByID(ctx, 7)
+	require.Error(t, err)
+	for range 10 {
+		_, err := client.GetPullZone(ctx, 1)
+		require.NoError(t, err)
+	}
+	_, err = client.GetPullZone(ctx, 404)
+	require.Error(t, err)
+
+	health := client.Health()
+	require.Len(t, health, 2)
+	assert.Equal(t, FamilyHealth{Family: FamilyDNS, Requests: 6, Errors: 6, Availability: 0, Degraded: true}, health[0],
+		"every attempt of the retried call counts")
+	assert.Equal(t, FamilyHealth{Family: FamilyPullZone, Requests: 11, Availability: 100}, health[1],
+		"a missing zone is the caller's error, not the API's")
+
+	endpoints := client.Endpoints()
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, "GET /dns/{id}", endpoints[0].Endpoint)
+	assert.Equal(t, int64(6), endpoints[0].Errors)
+	assert.Equal(t, "GET /pullzone/{id}", endpoints[1].Endpoint)
+	assert.Equal(t, int64(11), endpoints[1].Requests)
+	assert.Equal(t, int64(1), endpoints[1].ClientErrors)
+
+	// Calls leave the window, the endpoint counters stay
+	now = now.Add(11 * time.Minute)
+	assert.Empty(t, client.Health())
+	assert.Len(t, client.Endpoints(), 2)
+}
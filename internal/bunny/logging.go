@@ -0,0 +1,148 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// Client IP anonymization of pull zone logs
+const (
+	LogAnonymizationNone     = "none"
+	LogAnonymizationOneDigit = "one_digit" // The last octet is zeroed
+	LogAnonymizationDrop     = "drop"      // The IP is not logged
+)
+
+// Protocols and formats logs are forwarded with
+const (
+	LogForwardingUDP          = "udp"
+	LogForwardingTCP          = "tcp"
+	LogForwardingTCPEncrypted = "tcp_encrypted"
+
+	LogFormatPlain = "plain"
+	LogFormatJSON  = "json"
+)
+
+// Bunny's codes of the anonymization types, forwarding protocols and
+// forwarding formats
+var (
+	logAnonymizationTypes  = map[string]int{LogAnonymizationOneDigit: 0, LogAnonymizationDrop: 1}
+	logForwardingProtocols = map[string]int{LogForwardingUDP: 0, LogForwardingTCP: 1, LogForwardingTCPEncrypted: 2}
+	logForwardingFormats   = map[string]int{LogFormatPlain: 0, LogFormatJSON: 1}
+)
+
+// LoggingSettings describes the request logging of a pull zone
+type LoggingSettings struct {
+	Enabled bool
+	// Anonymization is none, one_digit or drop; empty is none
+	Anonymization string
+	// Forwarding sends the logs to a syslog endpoint; nil does not
+	Forwarding *LogForwarding
+}
+
+// LogForwarding is the syslog endpoint a pull zone forwards its logs to
+type LogForwarding struct {
+	Hostname string
+	Port     int
+	Token    string // Sent with each line, for hosted log services
+	Protocol string // udp, tcp or tcp_encrypted; empty is udp
+	Format   string // plain or json; empty is plain
+}
+
+// String returns the endpoint as protocol://host:port (format), without
+// the token
+func (f *LogForwarding) String() string {
+	if f == nil {
+		return "off"
+	}
+	return fmt.Sprintf("%s://%s (%s)", orDefault(f.Protocol, LogForwardingUDP),
+		net.JoinHostPort(f.Hostname, strconv.Itoa(f.Port)), orDefault(f.Format, LogFormatPlain))
+}
+
+// orDefault returns s, or def when s is empty
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// nameOf returns the name of a Bunny code, or the code itself when unknown
+func nameOf(codes map[string]int, code int) string {
+	for name, c := range codes {
+		if c == code {
+			return name
+		}
+	}
+	return strconv.Itoa(code)
+}
+
+// Logging returns the request logging settings of the pull zone
+func (pz *PullZone) Logging() LoggingSettings {
+	s := LoggingSettings{Enabled: pz.EnableLogging, Anonymization: LogAnonymizationNone}
+	if pz.LoggingIPAnonymizationEnabled {
+		s.Anonymization = nameOf(logAnonymizationTypes, pz.LogAnonymizationType)
+	}
+	if pz.LogForwardingEnabled {
+		s.Forwarding = &LogForwarding{
+			Hostname: pz.LogForwardingHostname,
+			Port:     pz.LogForwardingPort,
+			Token:    pz.LogForwardingToken,
+			Protocol: nameOf(logForwardingProtocols, pz.LogForwardingProtocol),
+			Format:   nameOf(logForwardingFormats, pz.LogForwardingFormat),
+		}
+	}
+	return s
+}
+
+// SetLogging sets the request logging of a pull zone
+// When disabling, only the master switch is sent so the other settings are
+// preserved
+// API: POST /pullzone/{id}
+func (c *Client) SetLogging(ctx context.Context, zoneID int64, settings LoggingSettings) error {
+	req := &UpdatePullZoneRequest{EnableLogging: &settings.Enabled}
+	if settings.Enabled {
+		anonymize := settings.Anonymization != "" && settings.Anonymization != LogAnonymizationNone
+		req.LoggingIPAnonymizationEnabled = &anonymize
+		if anonymize {
+			code, ok := logAnonymizationTypes[settings.Anonymization]
+			if !ok {
+				return fmt.Errorf("invalid log anonymization %q", settings.Anonymization)
+			}
+			req.LogAnonymizationType = &code
+		}
+
+		forward := settings.Forwarding != nil
+		req.LogForwardingEnabled = &forward
+		if f := settings.Forwarding; forward {
+			protocol, ok := logForwardingProtocols[orDefault(f.Protocol, LogForwardingUDP)]
+			if !ok {
+				return fmt.Errorf("invalid log forwarding protocol %q", f.Protocol)
+			}
+			format, ok := logForwardingFormats[orDefault(f.Format, LogFormatPlain)]
+			if !ok {
+				return fmt.Errorf("invalid log forwarding format %q", f.Format)
+			}
+			req.LogForwardingHostname = &f.Hostname
+			req.LogForwardingPort = &f.Port
+			req.LogForwardingToken = &f.Token
+			req.LogForwardingProtocol = &protocol
+			req.LogForwardingFormat = &format
+		}
+	}
+
+	if err := c.UpdatePullZone(ctx, zoneID, req); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone logging updated",
+		zap.Int64("zone_id", zoneID),
+		zap.Bool("enabled", settings.Enabled),
+		zap.String("anonymization", settings.Anonymization),
+		zap.Stringer("forwarding", settings.Forwarding),
+	)
+	return nil
+}
@@ -0,0 +1,66 @@
+package bunny
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SetLogging(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewClient("test-key", WithBaseURL(srv.URL), WithRetryConfig(fastRetry()), WithResponseCache(0))
+	ctx := context.Background()
+
+	settings := LoggingSettings{
+		Enabled:       true,
+		Anonymization: LogAnonymizationDrop,
+		Forwarding:    &LogForwarding{Hostname: "logs.example.com", Port: 6514, Token: "secret", Protocol: LogForwardingTCPEncrypted, Format: LogFormatJSON},
+	}
+	require.NoError(t, client.SetLogging(ctx, 42, settings))
+	require.NoError(t, client.SetLogging(ctx, 42, LoggingSettings{}))
+	assert.Error(t, client.SetLogging(ctx, 42, LoggingSettings{Enabled: true, Anonymization: "half"}))
+
+	require.Len(t, bodies, 2)
+	assert.Equal(t, map[string]any{
+		"EnableLogging":                 true,
+		"LoggingIPAnonymizationEnabled": true,
+		"LogAnonymizationType":          float64(1),
+		"LogForwardingEnabled":          true,
+		"LogForwardingHostname":         "logs.example.com",
+		"LogForwardingPort":             float64(6514),
+		"LogForwardingToken":            "secret",
+		"LogForwardingProtocol":         float64(2),
+		"LogForwardingFormat":           float64(1),
+	}, bodies[0])
+	// Disabling only sends the master switch
+	assert.Equal(t, map[string]any{"EnableLogging": false}, bodies[1])
+
+	// The zone reads back as the settings sent
+	zone := PullZone{
+		EnableLogging:                 true,
+		LoggingIPAnonymizationEnabled: true,
+		LogAnonymizationType:          1,
+		LogForwardingEnabled:          true,
+		LogForwardingHostname:         "logs.example.com",
+		LogForwardingPort:             6514,
+		LogForwardingToken:            "secret",
+		LogForwardingProtocol:         2,
+		LogForwardingFormat:           1,
+	}
+	assert.Equal(t, settings, zone.Logging())
+	assert.Equal(t, "tcp_encrypted://logs.example.com:6514 (json)", zone.Logging().Forwarding.String())
+	assert.Equal(t, LoggingSettings{Anonymization: LogAnonymizationNone}, (&PullZone{}).Logging())
+}
@@ -3,6 +3,8 @@ package bunny
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -242,6 +244,56 @@ type ZoneStats struct {
 	ZoneName string `json:"ZoneName"`
 }
 
+// DNSQueryStats is the number of DNS queries a zone answered over a period
+type DNSQueryStats struct {
+	ZoneID       int64     `json:"ZoneId"`
+	StartDate    time.Time `json:"StartDate"`
+	EndDate      time.Time `json:"EndDate"`
+	TotalQueries int64     `json:"TotalQueries"`
+	// QueriesByType maps a record type, such as "A" or "MX", to the
+	// queries answered for it
+	QueriesByType map[string]int64 `json:"QueriesByType,omitempty"`
+}
+
+// GetDNSQueryStats retrieves the queries a DNS zone answered between from
+// and to
+// API: GET /dns/{id}/statistics
+func (c *Client) GetDNSQueryStats(ctx context.Context, zoneID int64, from, to time.Time) (*DNSQueryStats, error) {
+	if zoneID <= 0 {
+		return nil, fmt.Errorf("zone ID must be positive")
+	}
+
+	path := fmt.Sprintf("/dns/%d/statistics?dateFrom=%s&dateTo=%s",
+		zoneID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	var resp struct {
+		TotalQueriesServed int64 `json:"TotalQueriesServed"`
+		// QueriesServedChart maps a date to the queries answered that day
+		QueriesServedChart map[string]float64 `json:"QueriesServedChart"`
+		QueriesByTypeChart map[string]float64 `json:"QueriesByTypeChart"`
+	}
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+
+	stats := &DNSQueryStats{
+		ZoneID:       zoneID,
+		StartDate:    from,
+		EndDate:      to,
+		TotalQueries: resp.TotalQueriesServed,
+	}
+	if stats.TotalQueries == 0 {
+		stats.TotalQueries = sumChart(resp.QueriesServedChart)
+	}
+	if len(resp.QueriesByTypeChart) > 0 {
+		stats.QueriesByType = make(map[string]int64, len(resp.QueriesByTypeChart))
+		for recordType, queries := range resp.QueriesByTypeChart {
+			stats.QueriesByType[recordType] = int64(queries)
+		}
+	}
+	return stats, nil
+}
+
 // GetDailyPullZoneStats retrieves daily statistics for a pull zone
 // API: GET /pullzone/{id}/stats?daily=true
 func (c *Client) GetDailyPullZoneStats(ctx context.Context, pullZoneID int64, from, to time.Time) ([]TimestampedStats, error) {
@@ -262,3 +314,202 @@ func (c *Client) GetDailyPullZoneStats(ctx context.Context, pullZoneID int64, fr
 
 	return resp, nil
 }
+
+// ErrorStats is the response status breakdown of a pull zone's traffic,
+// used to tell CDN problems from origin problems
+type ErrorStats struct {
+	PullZoneID    int64     `json:"PullZoneId"`
+	StartDate     time.Time `json:"StartDate"`
+	EndDate       time.Time `json:"EndDate"`
+	TotalRequests int64     `json:"TotalRequests"`
+	// OriginRequests are requests the edge forwarded to the origin (cache misses)
+	OriginRequests int64 `json:"OriginRequests"`
+	Status3xx      int64 `json:"Status3xx"`
+	Status4xx      int64 `json:"Status4xx"`
+	Status5xx      int64 `json:"Status5xx"`
+	// AverageOriginResponseTime is in milliseconds
+	AverageOriginResponseTime float64 `json:"AverageOriginResponseTime"`
+}
+
+// Rate5xx returns the share of requests answered with a 5xx status, in percent
+func (s ErrorStats) Rate5xx() float64 {
+	if s.TotalRequests <= 0 {
+		return 0
+	}
+	return float64(s.Status5xx) / float64(s.TotalRequests) * 100
+}
+
+// Rate4xx returns the share of requests answered with a 4xx status, in percent
+func (s ErrorStats) Rate4xx() float64 {
+	if s.TotalRequests <= 0 {
+		return 0
+	}
+	return float64(s.Status4xx) / float64(s.TotalRequests) * 100
+}
+
+// OriginSide reports whether the 5xx responses can all be explained by
+// requests that went to the origin. More 5xx responses than origin requests
+// means the edge itself produced errors, which points at the CDN
+func (s ErrorStats) OriginSide() bool {
+	return s.Status5xx <= s.OriginRequests
+}
+
+// statisticsResponse is the subset of GET /statistics used for error stats;
+// charts map a date to a value
+type statisticsResponse struct {
+	TotalRequestsServed       int64              `json:"TotalRequestsServed"`
+	AverageOriginResponseTime float64            `json:"AverageOriginResponseTime"`
+	PullRequestsPulledChart   map[string]float64 `json:"PullRequestsPulledChart"`
+	Error3xxChart             map[string]float64 `json:"Error3xxChart"`
+	Error4xxChart             map[string]float64 `json:"Error4xxChart"`
+	Error5xxChart             map[string]float64 `json:"Error5xxChart"`
+}
+
+// GetPullZoneErrorStats retrieves the 3xx/4xx/5xx breakdown and origin
+// request counts of a pull zone
+// API: GET /statistics?pullZone={id}&loadErrors=true
+func (c *Client) GetPullZoneErrorStats(ctx context.Context, pullZoneID int64, from, to time.Time) (*ErrorStats, error) {
+	if pullZoneID <= 0 {
+		return nil, fmt.Errorf("pull zone ID must be positive")
+	}
+
+	path := fmt.Sprintf("/statistics?pullZone=%d&dateFrom=%s&dateTo=%s&loadErrors=true",
+		pullZoneID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	var resp statisticsResponse
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+
+	return &ErrorStats{
+		PullZoneID:                pullZoneID,
+		StartDate:                 from,
+		EndDate:                   to,
+		TotalRequests:             resp.TotalRequestsServed,
+		OriginRequests:            sumChart(resp.PullRequestsPulledChart),
+		Status3xx:                 sumChart(resp.Error3xxChart),
+		Status4xx:                 sumChart(resp.Error4xxChart),
+		Status5xx:                 sumChart(resp.Error5xxChart),
+		AverageOriginResponseTime: resp.AverageOriginResponseTime,
+	}, nil
+}
+
+// sumChart adds up the values of a statistics chart
+func sumChart(chart map[string]float64) int64 {
+	var total float64
+	for _, v := range chart {
+		total += v
+	}
+	return int64(total)
+}
+
+// GeoTraffic is the bandwidth a pull zone served from one edge location
+type GeoTraffic struct {
+	// Region is Bunny's region code, e.g. "ASIA" or "EU"
+	Region string
+	// Location is the edge location, e.g. "Singapore, SG"
+	Location  string
+	Bandwidth int64
+}
+
+// RegionTraffic is the bandwidth served from one region, with its share of
+// the total in percent
+type RegionTraffic struct {
+	Region    string
+	Bandwidth int64
+	Share     float64
+}
+
+// regionNames maps Bunny's region codes to readable names
+var regionNames = map[string]string{
+	"ASIA": "Asia & Oceania",
+	"AS":   "Asia & Oceania",
+	"OC":   "Asia & Oceania",
+	"EU":   "Europe",
+	"NA":   "North America",
+	"SA":   "South America",
+	"AF":   "Africa",
+	"ME":   "Middle East",
+}
+
+// RegionName returns the readable name of a region code
+func RegionName(code string) string {
+	if name, ok := regionNames[strings.ToUpper(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// IsAsiaRegion reports whether a region code (or readable name) is served by the Asia+Oceania
+// geo zone that pull zones are created with
+func IsAsiaRegion(code string) bool {
+	return RegionName(code) == regionNames["ASIA"]
+}
+
+// GetPullZoneGeoTraffic retrieves the bandwidth a pull zone served per edge
+// location, largest first
+// API: GET /statistics?pullZone={id}&loadGeo=true
+func (c *Client) GetPullZoneGeoTraffic(ctx context.Context, pullZoneID int64, from, to time.Time) ([]GeoTraffic, error) {
+	if pullZoneID <= 0 {
+		return nil, fmt.Errorf("pull zone ID must be positive")
+	}
+
+	path := fmt.Sprintf("/statistics?pullZone=%d&dateFrom=%s&dateTo=%s&loadGeo=true",
+		pullZoneID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	var resp struct {
+		// GeoTrafficDistribution maps "REGION: Location" to bytes served
+		GeoTrafficDistribution map[string]float64 `json:"GeoTrafficDistribution"`
+	}
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+
+	traffic := make([]GeoTraffic, 0, len(resp.GeoTrafficDistribution))
+	for key, bytes := range resp.GeoTrafficDistribution {
+		region, location, found := strings.Cut(key, ":")
+		if !found {
+			region, location = "", key
+		}
+		traffic = append(traffic, GeoTraffic{
+			Region:    strings.ToUpper(strings.TrimSpace(region)),
+			Location:  strings.TrimSpace(location),
+			Bandwidth: int64(bytes),
+		})
+	}
+	sort.Slice(traffic, func(i, j int) bool {
+		if traffic[i].Bandwidth != traffic[j].Bandwidth {
+			return traffic[i].Bandwidth > traffic[j].Bandwidth
+		}
+		return traffic[i].Location < traffic[j].Location
+	})
+
+	return traffic, nil
+}
+
+// GroupByRegion adds up edge location traffic per region, largest first.
+// Codes that share a readable name (e.g. "AS" and "ASIA") are merged
+func GroupByRegion(traffic []GeoTraffic) []RegionTraffic {
+	totals := make(map[string]int64)
+	var total int64
+	for _, t := range traffic {
+		totals[RegionName(t.Region)] += t.Bandwidth
+		total += t.Bandwidth
+	}
+
+	regions := make([]RegionTraffic, 0, len(totals))
+	for region, bandwidth := range totals {
+		share := 0.0
+		if total > 0 {
+			share = float64(bandwidth) / float64(total) * 100
+		}
+		regions = append(regions, RegionTraffic{Region: region, Bandwidth: bandwidth, Share: share})
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Bandwidth != regions[j].Bandwidth {
+			return regions[i].Bandwidth > regions[j].Bandwidth
+		}
+		return regions[i].Region < regions[j].Region
+	})
+	return regions
+}
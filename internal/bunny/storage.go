@@ -0,0 +1,162 @@
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// StorageZone represents a Bunny Storage zone
+type StorageZone struct {
+	ID                 int64    `json:"Id"`
+	Name               string   `json:"Name"`
+	Password           string   `json:"Password,omitempty"`
+	ReadOnlyPassword   string   `json:"ReadOnlyPassword,omitempty"`
+	Region             string   `json:"Region"`
+	ReplicationRegions []string `json:"ReplicationRegions,omitempty"`
+	StorageHostname    string   `json:"StorageHostname,omitempty"`
+	StorageUsed        int64    `json:"StorageUsed"`
+	FilesStored        int64    `json:"FilesStored"`
+	Deleted            bool     `json:"Deleted,omitempty"`
+}
+
+// CreateStorageZoneRequest is the request to create a storage zone
+type CreateStorageZoneRequest struct {
+	Name               string   `json:"Name"`
+	Region             string   `json:"Region"`
+	ReplicationRegions []string `json:"ReplicationRegions,omitempty"`
+}
+
+// CreateStorageZone creates a new storage zone
+// API: POST /storagezone
+func (c *Client) CreateStorageZone(ctx context.Context, name, region string, replicationRegions []string) (*StorageZone, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+
+	req := &CreateStorageZoneRequest{
+		Name:               name,
+		Region:             region,
+		ReplicationRegions: replicationRegions,
+	}
+
+	var zone StorageZone
+	if err := c.post(ctx, "/storagezone", req, &zone); err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("Storage zone created",
+		zap.Int64("storage_zone_id", zone.ID),
+		zap.String("name", zone.Name),
+		zap.String("region", zone.Region),
+	)
+
+	return &zone, nil
+}
+
+// GetStorageZone retrieves a storage zone by ID
+// API: GET /storagezone/{id}
+func (c *Client) GetStorageZone(ctx context.Context, storageZoneID int64) (*StorageZone, error) {
+	if storageZoneID <= 0 {
+		return nil, fmt.Errorf("storage zone ID must be positive")
+	}
+
+	var zone StorageZone
+	path := fmt.Sprintf("/storagezone/%d", storageZoneID)
+	if err := c.get(ctx, path, &zone); err != nil {
+		return nil, err
+	}
+
+	return &zone, nil
+}
+
+// ListStorageZones lists all storage zones
+// API: GET /storagezone
+func (c *Client) ListStorageZones(ctx context.Context) ([]StorageZone, error) {
+	var zones []StorageZone
+	if err := c.get(ctx, "/storagezone", &zones); err != nil {
+		return nil, err
+	}
+
+	return zones, nil
+}
+
+// GetStorageZoneByName retrieves a storage zone by name
+// Bunny.net doesn't have a direct "get by name" endpoint, so we list all zones
+func (c *Client) GetStorageZoneByName(ctx context.Context, name string) (*StorageZone, error) {
+	return c.FindStorageZone(ctx, name)
+}
+
+// FindStorageZone retrieves the storage zone with the first of names that
+// exists, listing the zones once
+func (c *Client) FindStorageZone(ctx context.Context, names ...string) (*StorageZone, error) {
+	if len(names) == 0 || slices.Contains(names, "") {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	zones, err := c.ListStorageZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		for _, zone := range zones {
+			if zone.Name == name && !zone.Deleted {
+				return &zone, nil
+			}
+		}
+	}
+
+	return nil, &APIError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("Storage zone with name %s not found", strings.Join(names, " or ")),
+	}
+}
+
+// DeleteStorageZone deletes a storage zone and all files in it
+// API: DELETE /storagezone/{id}
+func (c *Client) DeleteStorageZone(ctx context.Context, storageZoneID int64) error {
+	if storageZoneID <= 0 {
+		return fmt.Errorf("storage zone ID must be positive")
+	}
+
+	path := fmt.Sprintf("/storagezone/%d", storageZoneID)
+	if err := c.delete(ctx, path); err != nil {
+		return err
+	}
+
+	c.logger.Info("Storage zone deleted", zap.Int64("storage_zone_id", storageZoneID))
+	return nil
+}
+
+// SetPermaCacheStorageZone links a storage zone to a pull zone as its Perma-Cache
+// Passing 0 detaches Perma-Cache from the pull zone
+// API: POST /pullzone/{id}
+func (c *Client) SetPermaCacheStorageZone(ctx context.Context, pullZoneID, storageZoneID int64) error {
+	if pullZoneID <= 0 {
+		return fmt.Errorf("zone ID must be positive")
+	}
+	if storageZoneID < 0 {
+		return fmt.Errorf("storage zone ID must not be negative")
+	}
+
+	// UpdatePullZoneRequest omits zero values, so send the field explicitly
+	req := map[string]int64{"PermaCacheStorageZoneId": storageZoneID}
+	path := fmt.Sprintf("/pullzone/%d", pullZoneID)
+	if err := c.post(ctx, path, req, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Pull zone Perma-Cache updated",
+		zap.Int64("zone_id", pullZoneID),
+		zap.Int64("storage_zone_id", storageZoneID),
+	)
+	return nil
+}
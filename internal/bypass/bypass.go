@@ -0,0 +1,125 @@
+// Package bypass tracks domains whose DNS was switched to point directly at
+// the origin during a CDN emergency, remembering the original records so
+// they can be restored
+package bypass
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Entry is the bypass state of one domain
+type Entry struct {
+	Reason string `json:"reason,omitempty"`
+	// Records holds the DNS records the bypass disabled
+	Records []bunny.DNSRecord `json:"records"`
+	// Added holds the IDs of the records pointing at the origin that were
+	// added next to them
+	Added      []int64   `json:"added,omitempty"`
+	BypassedAt time.Time `json:"bypassed_at"`
+}
+
+// Store persists bypass state per domain
+type Store struct {
+	file    *filestore.File[map[string]Entry]
+	entries map[string]Entry
+	mu      sync.Mutex
+	logger  *zap.Logger
+}
+
+// NewStore creates a bypass store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "bypass", 0644, func() map[string]Entry {
+		return make(map[string]Entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.entries); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns the bypass entry for a domain
+func (s *Store) Get(domain string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload bypass state, using cached copy", zap.Error(err))
+	}
+
+	e, ok := s.entries[domainname.Normalize(domain)]
+	return e, ok
+}
+
+// Set records a domain as bypassed
+func (s *Store) Set(domain string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	if entry.BypassedAt.IsZero() {
+		entry.BypassedAt = time.Now()
+	}
+	s.entries[domainname.Normalize(domain)] = entry
+	return s.file.Save(s.entries)
+}
+
+// Delete removes a domain's bypass entry
+func (s *Store) Delete(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	return s.file.Save(s.entries)
+}
+
+// Domains returns all bypassed domains, sorted
+func (s *Store) Domains() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload bypass state, using cached copy", zap.Error(err))
+	}
+
+	domains := make([]string, 0, len(s.entries))
+	for d := range s.entries {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
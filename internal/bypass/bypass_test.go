@@ -0,0 +1,48 @@
+package bypass
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+func TestStore_SetGetDelete(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "bypass.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	_, ok := s.Get("example.com")
+	assert.False(t, ok)
+
+	record := bunny.DNSRecord{ID: 7, Type: bunny.DNSRecordTypeCNAME, Name: "cdn", Value: "morden-example-com.b-cdn.net", TTL: 3600}
+	require.NoError(t, s.Set("Example.com.", Entry{Reason: "CDN outage", Records: []bunny.DNSRecord{record}}))
+
+	e, ok := s.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "CDN outage", e.Reason)
+	assert.Equal(t, []bunny.DNSRecord{record}, e.Records)
+	assert.False(t, e.BypassedAt.IsZero())
+	assert.Equal(t, []string{"example.com"}, s.Domains())
+
+	require.NoError(t, s.Delete("example.com"))
+	_, ok = s.Get("example.com")
+	assert.False(t, ok)
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bypass.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Set("example.com", Entry{Reason: "origin test"}))
+
+	e, ok := server.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "origin test", e.Reason)
+}
@@ -0,0 +1,291 @@
+// Package certs validates custom (bring-your-own) certificates and tracks
+// the expiry of both uploaded and Bunny-managed certificates on pull zone
+// hostnames so reminders can be sent
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// ErrInvalid is returned by ParsePair for certificates that cannot be used
+var ErrInvalid = errors.New("invalid certificate")
+
+// Certificate sources
+const (
+	// SourceCustom is a certificate uploaded with ParsePair and Store.Set
+	SourceCustom = "custom"
+	// SourceManaged is a certificate issued and renewed by Bunny, recorded
+	// by expiry monitoring
+	SourceManaged = "managed"
+)
+
+// Entry is the metadata of the certificate on one hostname
+type Entry struct {
+	// Domain is the provisioned domain whose pull zone serves the hostname
+	Domain      string    `json:"domain"`
+	Hostname    string    `json:"hostname"`
+	Source      string    `json:"source,omitempty"` // SourceCustom (also when empty) or SourceManaged
+	Status      string    `json:"status,omitempty"` // Bunny certificate status, managed only
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the leaf certificate
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	// RemindedDays is the smallest reminder threshold already sent, 0 if none
+	RemindedDays int `json:"reminded_days,omitempty"`
+}
+
+// Managed reports whether the certificate is issued and renewed by Bunny
+func (e Entry) Managed() bool {
+	return e.Source == SourceManaged
+}
+
+// DaysLeft returns the whole days until the certificate expires, negative
+// once it has expired
+func (e Entry) DaysLeft(now time.Time) int {
+	return int(math.Floor(e.NotAfter.Sub(now).Hours() / 24))
+}
+
+// DueReminder returns the reminder threshold (in days before expiry) that is
+// due and not yet sent. Only the smallest crossed threshold is reported, so a
+// certificate uploaded close to expiry gets one reminder rather than several
+func (e Entry) DueReminder(now time.Time, thresholds []int) (int, bool) {
+	left := e.DaysLeft(now)
+	due := 0
+	for _, t := range thresholds {
+		if t <= 0 || left > t {
+			continue
+		}
+		if e.RemindedDays > 0 && t >= e.RemindedDays {
+			continue
+		}
+		if due == 0 || t < due {
+			due = t
+		}
+	}
+	return due, due > 0
+}
+
+// ParsePair validates a PEM certificate and private key for hostname: the
+// key must match the certificate, the certificate must be valid at now and
+// it must cover hostname. The returned entry has no Domain or UploadedAt set
+func ParsePair(certPEM, keyPEM []byte, hostname string, now time.Time) (Entry, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: certificate and key do not form a valid pair: %v", ErrInvalid, err)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return Entry{}, fmt.Errorf("%w: not valid until %s", ErrInvalid, leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return Entry{}, fmt.Errorf("%w: expired on %s", ErrInvalid, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if err := leaf.VerifyHostname(hostname); err != nil {
+		return Entry{}, fmt.Errorf("%w: does not cover %s: %v", ErrInvalid, hostname, err)
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	return Entry{
+		Hostname:    domainname.Normalize(hostname),
+		Source:      SourceCustom,
+		Subject:     leaf.Subject.CommonName,
+		Issuer:      leaf.Issuer.CommonName,
+		DNSNames:    leaf.DNSNames,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
+
+// Store persists certificate metadata per hostname
+type Store struct {
+	file    *filestore.File[map[string]Entry]
+	entries map[string]Entry
+	mu      sync.Mutex
+	logger  *zap.Logger
+}
+
+// NewStore creates a certificate store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "certificate", 0644, func() map[string]Entry {
+		return make(map[string]Entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.entries); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns the certificate entry for a hostname
+func (s *Store) Get(hostname string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload certificates, using cached copy", zap.Error(err))
+	}
+
+	e, ok := s.entries[domainname.Normalize(hostname)]
+	return e, ok
+}
+
+// Set records the certificate uploaded for entry.Hostname, replacing any
+// previous one
+func (s *Store) Set(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	entry.Hostname = domainname.Normalize(entry.Hostname)
+	entry.Domain = domainname.Normalize(entry.Domain)
+	if entry.Source == "" {
+		entry.Source = SourceCustom
+	}
+	if entry.UploadedAt.IsZero() {
+		entry.UploadedAt = time.Now()
+	}
+	s.entries[entry.Hostname] = entry
+	return s.file.Save(s.entries)
+}
+
+// ReplaceManaged replaces a domain's managed entries with entries, as seen
+// by the latest monitoring run. Hostnames with a custom certificate are left
+// alone, and a reminder already sent is kept while the expiry is unchanged so
+// a certificate is not reported twice for the same threshold
+func (s *Store) ReplaceManaged(domain string, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	domain = domainname.Normalize(domain)
+	previous := make(map[string]Entry)
+	for key, e := range s.entries {
+		if e.Domain == domain && e.Managed() {
+			previous[key] = e
+			delete(s.entries, key)
+		}
+	}
+
+	for _, e := range entries {
+		key := domainname.Normalize(e.Hostname)
+		if existing, ok := s.entries[key]; ok && !existing.Managed() {
+			continue
+		}
+		e.Domain = domain
+		e.Hostname = key
+		e.Source = SourceManaged
+		if old, ok := previous[key]; ok && old.NotAfter.Equal(e.NotAfter) {
+			e.RemindedDays = old.RemindedDays
+		}
+		s.entries[key] = e
+	}
+	return s.file.Save(s.entries)
+}
+
+// MarkReminded records that the reminder for days before expiry was sent
+func (s *Store) MarkReminded(hostname string, days int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(hostname)
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	e.RemindedDays = days
+	s.entries[key] = e
+	return s.file.Save(s.entries)
+}
+
+// DeleteDomain removes all certificate entries of a domain
+func (s *Store) DeleteDomain(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	domain = domainname.Normalize(domain)
+	removed := false
+	for key, e := range s.entries {
+		if e.Domain == domain {
+			delete(s.entries, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return s.file.Save(s.entries)
+}
+
+// List returns all entries sorted by hostname, optionally limited to one domain
+func (s *Store) List(domain string) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload certificates, using cached copy", zap.Error(err))
+	}
+
+	domain = domainname.Normalize(domain)
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if domain == "" || e.Domain == domain {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hostname < entries[j].Hostname
+	})
+	return entries
+}
@@ -0,0 +1,159 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// selfSigned returns a PEM certificate and key valid between notBefore and notAfter
+func selfSigned(t *testing.T, names []string, notBefore, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestParsePair(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM := selfSigned(t, []string{"example.com", "*.example.com"}, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+
+	e, err := ParsePair(certPEM, keyPEM, "www.example.com", now)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", e.Hostname)
+	assert.Equal(t, "example.com", e.Subject)
+	assert.Equal(t, []string{"example.com", "*.example.com"}, e.DNSNames)
+	assert.Len(t, e.Fingerprint, 64)
+
+	_, err = ParsePair(certPEM, keyPEM, "example.org", now)
+	assert.ErrorContains(t, err, "does not cover")
+
+	_, otherKey := selfSigned(t, []string{"example.com"}, now.Add(-time.Hour), now.Add(time.Hour))
+	_, err = ParsePair(certPEM, otherKey, "example.com", now)
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.ErrorContains(t, err, "do not form a valid pair")
+
+	expiredCert, expiredKey := selfSigned(t, []string{"example.com"}, now.Add(-48*time.Hour), now.Add(-time.Hour))
+	_, err = ParsePair(expiredCert, expiredKey, "example.com", now)
+	assert.ErrorContains(t, err, "expired")
+
+	futureCert, futureKey := selfSigned(t, []string{"example.com"}, now.Add(time.Hour), now.Add(48*time.Hour))
+	_, err = ParsePair(futureCert, futureKey, "example.com", now)
+	assert.ErrorContains(t, err, "not valid until")
+}
+
+func TestEntry_DueReminder(t *testing.T) {
+	now := time.Now()
+	thresholds := []int{30, 14, 7, 1}
+	e := Entry{NotAfter: now.Add(60 * 24 * time.Hour)}
+
+	_, due := e.DueReminder(now, thresholds)
+	assert.False(t, due)
+
+	e.NotAfter = now.Add(20*24*time.Hour + time.Hour)
+	days, due := e.DueReminder(now, thresholds)
+	require.True(t, due)
+	assert.Equal(t, 30, days)
+
+	e.RemindedDays = 30
+	_, due = e.DueReminder(now, thresholds)
+	assert.False(t, due)
+
+	// Jumping past several thresholds sends only the closest one
+	e.NotAfter = now.Add(5*24*time.Hour + time.Hour)
+	days, due = e.DueReminder(now, thresholds)
+	require.True(t, due)
+	assert.Equal(t, 7, days)
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certificates.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Set(Entry{Domain: "Example.com", Hostname: "WWW.example.com.", Fingerprint: "abc"}))
+	require.NoError(t, cli.Set(Entry{Domain: "example.org", Hostname: "example.org"}))
+
+	e, ok := server.Get("www.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "example.com", e.Domain)
+	assert.False(t, e.UploadedAt.IsZero())
+	assert.Len(t, server.List(""), 2)
+	assert.Len(t, server.List("example.com"), 1)
+
+	require.NoError(t, server.MarkReminded("www.example.com", 14))
+	e, _ = cli.Get("www.example.com")
+	assert.Equal(t, 14, e.RemindedDays)
+
+	require.NoError(t, server.DeleteDomain("example.com"))
+	_, ok = cli.Get("www.example.com")
+	assert.False(t, ok)
+	assert.Len(t, cli.List(""), 1)
+}
+
+func TestStore_ReplaceManaged(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "certificates.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	expiry := time.Now().Add(20 * 24 * time.Hour).Truncate(time.Second)
+	require.NoError(t, s.Set(Entry{Domain: "example.com", Hostname: "www.example.com", Fingerprint: "abc"}))
+	require.NoError(t, s.ReplaceManaged("example.com", []Entry{
+		{Hostname: "example.com", NotAfter: expiry, Status: "Active"},
+		{Hostname: "www.example.com", NotAfter: expiry},
+		{Hostname: "old.example.com", NotAfter: expiry},
+	}))
+
+	// The custom certificate is not replaced by the monitored one
+	e, ok := s.Get("www.example.com")
+	require.True(t, ok)
+	assert.False(t, e.Managed())
+	assert.Equal(t, "abc", e.Fingerprint)
+
+	e, ok = s.Get("example.com")
+	require.True(t, ok)
+	assert.True(t, e.Managed())
+	assert.Equal(t, "Active", e.Status)
+
+	require.NoError(t, s.MarkReminded("example.com", 30))
+
+	// Same expiry keeps the reminder, removed hostnames are dropped
+	require.NoError(t, s.ReplaceManaged("example.com", []Entry{{Hostname: "example.com", NotAfter: expiry}}))
+	e, _ = s.Get("example.com")
+	assert.Equal(t, 30, e.RemindedDays)
+	_, ok = s.Get("old.example.com")
+	assert.False(t, ok)
+
+	// A renewed certificate starts over
+	require.NoError(t, s.ReplaceManaged("example.com", []Entry{{Hostname: "example.com", NotAfter: expiry.Add(90 * 24 * time.Hour)}}))
+	e, _ = s.Get("example.com")
+	assert.Zero(t, e.RemindedDays)
+}
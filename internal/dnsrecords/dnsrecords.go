@@ -0,0 +1,172 @@
+// Package dnsrecords keeps the manifest of managed DNS records that were
+// disabled on Bunny instead of deleted, so temporary changes can be undone
+// and drift enforcement leaves them alone. Records are disabled by hand and
+// by emergency bypass; suspended accounts are not handled, as whm2bunny
+// receives no suspension events
+package dnsrecords
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Disabled is a DNS record disabled by whm2bunny, as it was when disabled
+type Disabled struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Reason says why, e.g. "emergency bypass" or an operator's note
+	Reason     string    `json:"reason,omitempty"`
+	DisabledAt time.Time `json:"disabled_at"`
+}
+
+// Store persists the disabled records per domain
+type Store struct {
+	file    *filestore.File[map[string][]Disabled]
+	domains map[string][]Disabled
+	mu      sync.Mutex
+	logger  *zap.Logger
+}
+
+// NewStore creates a disabled records store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "DNS records", 0644, func() map[string][]Disabled {
+		return make(map[string][]Disabled)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.domains); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Disable records a domain's record as disabled, replacing an earlier
+// entry of the same record
+func (s *Store) Disable(domain string, record Disabled) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.domains); err != nil {
+		return err
+	}
+
+	if record.DisabledAt.IsZero() {
+		record.DisabledAt = time.Now()
+	}
+	key := domainname.Normalize(domain)
+	records := removeID(s.domains[key], record.ID)
+	s.domains[key] = append(records, record)
+	return s.file.Save(s.domains)
+}
+
+// Enable forgets a disabled record, reporting whether it was recorded
+func (s *Store) Enable(domain string, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.domains); err != nil {
+		return false, err
+	}
+
+	key := domainname.Normalize(domain)
+	records := s.domains[key]
+	kept := removeID(records, id)
+	if len(kept) == len(records) {
+		return false, nil
+	}
+	if len(kept) == 0 {
+		delete(s.domains, key)
+	} else {
+		s.domains[key] = kept
+	}
+	return true, s.file.Save(s.domains)
+}
+
+// Get returns the disabled entry of a domain's record
+func (s *Store) Get(domain string, id int64) (Disabled, bool) {
+	for _, r := range s.Records(domain) {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Disabled{}, false
+}
+
+// Records returns the disabled records of a domain
+func (s *Store) Records(domain string) []Disabled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.domains); err != nil {
+		s.logger.Warn("Failed to reload DNS records, using cached copy", zap.Error(err))
+	}
+
+	return append([]Disabled(nil), s.domains[domainname.Normalize(domain)]...)
+}
+
+// Forget drops every disabled record of a domain, once it is deprovisioned
+func (s *Store) Forget(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.domains); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	if _, ok := s.domains[key]; !ok {
+		return nil
+	}
+	delete(s.domains, key)
+	return s.file.Save(s.domains)
+}
+
+// Domains returns the domains with disabled records, sorted
+func (s *Store) Domains() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.domains); err != nil {
+		s.logger.Warn("Failed to reload DNS records, using cached copy", zap.Error(err))
+	}
+
+	domains := make([]string, 0, len(s.domains))
+	for d := range s.domains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// removeID returns records without the one with id
+func removeID(records []Disabled, id int64) []Disabled {
+	kept := make([]Disabled, 0, len(records))
+	for _, r := range records {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,58 @@
+package dnsrecords
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_DisableEnable(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "dns_records.json"), zap.NewNop())
+	require.NoError(t, err)
+	assert.Empty(t, s.Records("example.com"))
+
+	require.NoError(t, s.Disable("Example.com.", Disabled{ID: 7, Type: "MX", Name: "@", Value: "mail.example.com", Reason: "mail migration"}))
+	require.NoError(t, s.Disable("example.com", Disabled{ID: 8, Type: "A", Name: "old", Value: "192.0.2.1"}))
+
+	r, ok := s.Get("example.com", 7)
+	require.True(t, ok)
+	assert.Equal(t, "mail migration", r.Reason)
+	assert.False(t, r.DisabledAt.IsZero())
+	assert.Equal(t, []string{"example.com"}, s.Domains())
+
+	// Disabling again replaces the entry
+	require.NoError(t, s.Disable("example.com", Disabled{ID: 7, Type: "MX", Name: "@", Value: "mail.example.com", Reason: "spam"}))
+	assert.Len(t, s.Records("example.com"), 2)
+	r, _ = s.Get("example.com", 7)
+	assert.Equal(t, "spam", r.Reason)
+
+	found, err := s.Enable("example.com", 7)
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = s.Enable("example.com", 7)
+	require.NoError(t, err)
+	assert.False(t, found, "enabling twice is a no-op")
+
+	_, err = s.Enable("example.com", 8)
+	require.NoError(t, err)
+	assert.Empty(t, s.Domains())
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_records.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Disable("example.com", Disabled{ID: 7, Type: "CNAME", Name: "cdn"}))
+	_, ok := server.Get("example.com", 7)
+	assert.True(t, ok)
+
+	require.NoError(t, cli.Forget("example.com"))
+	_, ok = server.Get("example.com", 7)
+	assert.False(t, ok)
+}
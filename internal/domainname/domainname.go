@@ -0,0 +1,11 @@
+// Package domainname holds the canonical form domains are compared and
+// keyed by
+package domainname
+
+import "strings"
+
+// Normalize returns the canonical form of a domain or hostname: trimmed,
+// lower case and without a trailing dot
+func Normalize(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
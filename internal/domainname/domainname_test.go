@@ -0,0 +1,13 @@
+package domainname
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "example.com", Normalize(" Example.COM. "))
+	assert.Equal(t, "shop.example.com", Normalize("shop.example.com"))
+	assert.Equal(t, "", Normalize(" "))
+}
@@ -0,0 +1,123 @@
+// Package email sends the HTML summaries to managers who do not use
+// Telegram
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// Image is an image embedded in a message and referenced from its HTML as
+// cid:<CID>
+type Image struct {
+	CID string
+	PNG []byte
+}
+
+// Sender sends HTML messages over SMTP
+type Sender struct {
+	cfg config.EmailConfig
+	// sendMail is smtp.SendMail, replaced in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSender creates a sender for the configured SMTP server
+func NewSender(cfg config.EmailConfig) *Sender {
+	return &Sender{cfg: cfg, sendMail: smtp.SendMail}
+}
+
+// Send sends an HTML message with its inline images to every recipient.
+// STARTTLS is used when the server offers it, and is required to log in
+func (s *Sender) Send(subject, html string, images []Image) error {
+	msg, err := buildMessage(s.cfg.From, s.cfg.To, subject, html, images, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	if err := s.sendMail(addr, auth, s.cfg.From, s.cfg.To, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// buildMessage builds a multipart/related message: the HTML body followed
+// by the images it references
+func buildMessage(from string, to []string, subject, html string, images []Image, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	htmlHeader := textproto.MIMEHeader{}
+	htmlHeader.Set("Content-Type", "text/html; charset=UTF-8")
+	htmlHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := w.CreatePart(htmlHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create html part: %w", err)
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(html)); err != nil {
+		return nil, fmt.Errorf("failed to write html part: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write html part: %w", err)
+	}
+
+	for _, img := range images {
+		imgHeader := textproto.MIMEHeader{}
+		imgHeader.Set("Content-Type", "image/png")
+		imgHeader.Set("Content-Transfer-Encoding", "base64")
+		imgHeader.Set("Content-ID", "<"+img.CID+">")
+		imgHeader.Set("Content-Disposition", `inline; filename="`+img.CID+`.png"`)
+		part, err := w.CreatePart(imgHeader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create image part: %w", err)
+		}
+		if _, err := part.Write(base64Lines(img.PNG)); err != nil {
+			return nil, fmt.Errorf("failed to write image part: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close message: %w", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/related; type=\"text/html\"; boundary=%q\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// base64Lines encodes data as base64 in lines of 76 characters, as MIME
+// requires
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var out bytes.Buffer
+	for len(encoded) > 76 {
+		out.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	out.WriteString(encoded + "\r\n")
+	return out.Bytes()
+}
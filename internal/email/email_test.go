@@ -0,0 +1,148 @@
+package email
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+)
+
+func TestSender_Send(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotAuth smtp.Auth
+	var gotMsg []byte
+
+	sender := NewSender(config.EmailConfig{
+		Host:     "smtp.example.com",
+		Port:     587,
+		Username: "reports",
+		Password: "secret",
+		From:     "whm2bunny@example.com",
+		To:       []string{"ops@example.com", "boss@example.com"},
+	})
+	sender.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotFrom, gotTo, gotMsg = addr, a, from, to, msg
+		return nil
+	}
+
+	chart, err := Sparkline([]int64{1, 5, 3}, 20, 10)
+	require.NoError(t, err)
+	require.NoError(t, sender.Send("Daily CDN Summary – Jan 2", `<p><img src="cid:total"></p>`, []Image{{CID: "total", PNG: chart}}))
+
+	assert.Equal(t, "smtp.example.com:587", gotAddr)
+	assert.NotNil(t, gotAuth)
+	assert.Equal(t, "whm2bunny@example.com", gotFrom)
+	assert.Equal(t, []string{"ops@example.com", "boss@example.com"}, gotTo)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(gotMsg))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Daily CDN Summary – Jan 2", subject)
+	assert.Equal(t, "ops@example.com, boss@example.com", msg.Header.Get("To"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/related", mediaType)
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+
+	htmlPart, err := parts.NextRawPart()
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=UTF-8", htmlPart.Header.Get("Content-Type"))
+	html, err := io.ReadAll(quotedprintable.NewReader(htmlPart))
+	require.NoError(t, err)
+	assert.Equal(t, `<p><img src="cid:total"></p>`, string(html))
+
+	imgPart, err := parts.NextRawPart()
+	require.NoError(t, err)
+	assert.Equal(t, "<total>", imgPart.Header.Get("Content-ID"))
+	assert.Equal(t, "image/png", imgPart.Header.Get("Content-Type"))
+
+	_, err = parts.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSender_NoAuthWithoutUsername(t *testing.T) {
+	sender := NewSender(config.EmailConfig{Host: "localhost", Port: 25, From: "a@example.com", To: []string{"b@example.com"}})
+	called := false
+	sender.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		called = true
+		assert.Nil(t, a)
+		return nil
+	}
+	require.NoError(t, sender.Send("subject", "<p>hi</p>", nil))
+	assert.True(t, called)
+}
+
+func TestSparkline(t *testing.T) {
+	data, err := Sparkline([]int64{0, 10, 5, 20}, 40, 12)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 40, img.Bounds().Dx())
+	assert.Equal(t, 12, img.Bounds().Dy())
+
+	// The peak is drawn at the top of the last column
+	_, _, _, a := img.At(39, 1).RGBA()
+	assert.NotZero(t, a)
+	r, g, b, _ := img.At(39, 1).RGBA()
+	assert.NotEqual(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b})
+
+	_, err = Sparkline(nil, 40, 12)
+	assert.NoError(t, err, "no values renders an empty chart")
+}
+
+func TestRenderSummary(t *testing.T) {
+	html, err := RenderSummary(Summary{
+		Title:        "Weekly CDN Summary",
+		Period:       "Week 2, 2024",
+		Server:       "cdn01",
+		Weekly:       true,
+		Bandwidth:    5 * 1024 * 1024 * 1024,
+		Requests:     1500000,
+		CacheHitRate: 91.5,
+		TopZones: []Zone{
+			{ZoneUsage: notifier.ZoneUsage{Name: "<script>.com", User: "exampleu", Bandwidth: 1024 * 1024 * 1024, Share: 20}, Chart: "zone-1"},
+		},
+		Regions:    []notifier.RegionUsage{{Name: "Asia", Bandwidth: 1024 * 1024 * 1024, Share: 80}},
+		TotalChart: "total",
+		ChartDays:  28,
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, html, "5.00 GB")
+	assert.Contains(t, html, "1.50M")
+	assert.Contains(t, html, `src="cid:total"`)
+	assert.Contains(t, html, `src="cid:zone-1"`)
+	assert.Contains(t, html, "last 28 days")
+	assert.Contains(t, html, "&lt;script&gt;.com")
+	assert.Contains(t, html, "Top regions")
+	assert.NotContains(t, html, "5xx errors")
+}
+
+func TestBuildMessage_Date(t *testing.T) {
+	date := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	msg, err := buildMessage("a@example.com", []string{"b@example.com"}, "s", "<p></p>", nil, date)
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	require.NoError(t, err)
+	got, err := parsed.Header.Date()
+	require.NoError(t, err)
+	assert.True(t, date.Equal(got))
+}
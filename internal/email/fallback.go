@@ -0,0 +1,47 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+)
+
+// Fallback sends notifications Telegram could not take by email
+type Fallback struct {
+	sender *Sender
+	server string
+}
+
+// NewFallback creates a notification fallback sending with sender; the
+// subject names the instance server
+func NewFallback(sender *Sender, server string) *Fallback {
+	return &Fallback{sender: sender, server: server}
+}
+
+// Name identifies the fallback
+func (f *Fallback) Name() string {
+	return "email"
+}
+
+// Deliver sends the messages in one email, oldest first
+func (f *Fallback) Deliver(_ context.Context, messages []notifier.QueuedMessage) error {
+	subject := fmt.Sprintf("whm2bunny on %s: %d notifications (Telegram unreachable)", f.server, len(messages))
+	return f.sender.Send(subject, fallbackHTML(messages), nil)
+}
+
+// fallbackHTML renders the messages as an HTML email. Telegram messages
+// already use a subset of HTML, so only their line breaks are converted
+func fallbackHTML(messages []notifier.QueuedMessage) string {
+	var b strings.Builder
+	b.WriteString("<html><body>\n")
+	for _, m := range messages {
+		fmt.Fprintf(&b, "<p><small>%s</small><br>\n%s</p>\n<hr>\n",
+			html.EscapeString(m.CreatedAt.Format("2006-01-02 15:04:05 MST")),
+			strings.ReplaceAll(m.Text, "\n", "<br>\n"))
+	}
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+var (
+	sparkLine = color.RGBA{R: 0xf2, G: 0x6b, B: 0x1d, A: 0xff}
+	sparkFill = color.RGBA{R: 0xfd, G: 0xe3, B: 0xd2, A: 0xff}
+)
+
+// Sparkline renders values as a small PNG line chart: the line scaled
+// between zero and the largest value, with the area below it filled
+func Sparkline(values []int64, width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	if len(values) > 0 && width > 1 && height > 1 {
+		var peak int64
+		for _, v := range values {
+			peak = max(peak, v)
+		}
+
+		// y returns the row of the line at column x
+		y := func(x int) int {
+			if peak == 0 {
+				return height - 1
+			}
+			pos := 0.0
+			if len(values) > 1 {
+				pos = float64(x) / float64(width-1) * float64(len(values)-1)
+			}
+			i := min(int(pos), len(values)-1)
+			v := float64(values[i])
+			if i+1 < len(values) {
+				v += (float64(values[i+1]) - v) * (pos - float64(i))
+			}
+			return height - 1 - int(v/float64(peak)*float64(height-2))
+		}
+
+		prev := y(0)
+		for x := 0; x < width; x++ {
+			row := y(x)
+			for yy := row + 1; yy < height; yy++ {
+				img.SetRGBA(x, yy, sparkFill)
+			}
+			// Join the previous column so steep changes stay connected
+			for yy := min(prev, row); yy <= max(prev, row); yy++ {
+				img.SetRGBA(x, yy, sparkLine)
+			}
+			prev = row
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode sparkline: %w", err)
+	}
+	return buf.Bytes(), nil
+}
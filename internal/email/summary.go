@@ -0,0 +1,54 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+)
+
+//go:embed templates/summary.html.tmpl
+var summaryFS embed.FS
+
+var summaryTemplate = template.Must(template.New("summary.html.tmpl").Funcs(notifier.TemplateFuncs()).
+	ParseFS(summaryFS, "templates/summary.html.tmpl"))
+
+// Zone is a domain of the summary with the CID of its trend chart, if any
+type Zone struct {
+	notifier.ZoneUsage
+	Chart string
+}
+
+// Summary is the data of the HTML summary; it carries the same sections as
+// the Telegram summaries
+type Summary struct {
+	Title  string
+	Period string
+	Server string
+	Weekly bool
+
+	Bandwidth       int64
+	Requests        int64
+	CacheHitRate    float64
+	BandwidthChange float64
+
+	TopZones       []Zone
+	ErrorZones     []notifier.ZoneErrors
+	ErrorThreshold float64
+	Regions        []notifier.RegionUsage
+
+	// TotalChart is the CID of the total bandwidth chart covering ChartDays
+	TotalChart string
+	ChartDays  int
+}
+
+// RenderSummary renders the HTML summary
+func RenderSummary(summary Summary) (string, error) {
+	var buf bytes.Buffer
+	if err := summaryTemplate.Execute(&buf, summary); err != nil {
+		return "", fmt.Errorf("failed to render email summary: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,206 @@
+// Package encryption encrypts the data files of whm2bunny at rest with
+// AES-256-GCM. Encrypted files start with a header naming the key they were
+// sealed with, so files written before encryption was enabled, or with a
+// previous key, are still read while they are rewritten
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// KeySize is the size of an AES-256 key in bytes
+const KeySize = 32
+
+// magic starts every encrypted file; JSON files never start with it
+var magic = []byte("W2BENC1")
+
+// keyIDSize is how many bytes of the key's SHA-256 identify it
+const keyIDSize = 8
+
+var (
+	// ErrEncrypted is returned when reading an encrypted file without a
+	// keyring
+	ErrEncrypted = errors.New("file is encrypted, configure encryption with its key")
+	// ErrUnknownKey is returned for files sealed with a key not in the
+	// keyring
+	ErrUnknownKey = errors.New("file is encrypted with a key that is not configured")
+)
+
+// key is one AES-256-GCM key
+type key struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+func newKey(raw []byte) (*key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &key{id: sum[:keyIDSize], aead: aead}, nil
+}
+
+// Keyring encrypts with its current key and decrypts with the current and
+// previous keys. A nil Keyring, or one without a current key, writes plain
+// JSON; methods are safe to call on nil
+type Keyring struct {
+	current *key
+	keys    []*key
+}
+
+// NewKeyring creates a keyring encrypting with current, which may be nil
+// to only decrypt, and decrypting with current and previous
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	r := &Keyring{}
+	if current != nil {
+		k, err := newKey(current)
+		if err != nil {
+			return nil, err
+		}
+		r.current = k
+		r.keys = append(r.keys, k)
+	}
+	for _, raw := range previous {
+		k, err := newKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		r.keys = append(r.keys, k)
+	}
+	return r, nil
+}
+
+// Load creates the keyring of cfg: the configured key encrypts when
+// encryption is enabled, and the previous keys decrypt. It returns nil
+// when no key is configured at all
+func Load(cfg config.EncryptionConfig) (*Keyring, error) {
+	var current []byte
+	if cfg.Enabled {
+		encoded := cfg.Key
+		if encoded == "" {
+			data, err := os.ReadFile(cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read encryption key: %w", err)
+			}
+			encoded = string(data)
+		}
+		raw, err := ParseKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		current = raw
+	}
+
+	var previous [][]byte
+	for _, path := range cfg.PreviousKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous encryption key: %w", err)
+		}
+		raw, err := ParseKey(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		previous = append(previous, raw)
+	}
+
+	if current == nil && len(previous) == 0 {
+		return nil, nil
+	}
+	return NewKeyring(current, previous...)
+}
+
+// ParseKey decodes a base64 encoded key, as written by
+// "openssl rand -base64 32"
+func ParseKey(encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64 encoded: %w", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return raw, nil
+}
+
+// IsEncrypted reports whether data was written by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encrypting reports whether files are written encrypted
+func (r *Keyring) Encrypting() bool {
+	return r != nil && r.current != nil
+}
+
+// Encrypt seals plaintext with the current key, or returns it unchanged
+// when the keyring does not encrypt
+func (r *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	if !r.Encrypting() {
+		return plaintext, nil
+	}
+	k := r.current
+
+	header := append(slices.Clone(magic), k.id...)
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// The header is authenticated along with the plaintext
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+k.aead.Overhead())
+	out = append(append(out, header...), nonce...)
+	return k.aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Decrypt opens data written by Encrypt with the key it names, or returns
+// data unchanged when it is not encrypted
+func (r *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if r == nil {
+		return nil, ErrEncrypted
+	}
+
+	rest := data[len(magic):]
+	if len(rest) < keyIDSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	id, rest := rest[:keyIDSize], rest[keyIDSize:]
+	for _, k := range r.keys {
+		if !bytes.Equal(k.id, id) {
+			continue
+		}
+		if len(rest) < k.aead.NonceSize() {
+			return nil, errors.New("encrypted file is truncated")
+		}
+		nonce, sealed := rest[:k.aead.NonceSize()], rest[k.aead.NonceSize():]
+		plaintext, err := k.aead.Open(nil, nonce, sealed, data[:len(magic)+keyIDSize])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt file: %w", err)
+		}
+		return plaintext, nil
+	}
+	return nil, ErrUnknownKey
+}
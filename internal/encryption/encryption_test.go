@@ -0,0 +1,140 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// testKey returns a key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	r, err := NewKeyring(testKey(1))
+	require.NoError(t, err)
+	assert.True(t, r.Encrypting())
+
+	plaintext := []byte(`{"domain":"example.com"}`)
+	sealed, err := r.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.NotContains(t, string(sealed), "example.com")
+
+	// Every write gets its own nonce
+	again, err := r.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	opened, err := r.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Plain JSON written before encryption was enabled is read as it is
+	opened, err = r.Decrypt(plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+}
+
+func TestKeyring_Keys(t *testing.T) {
+	old, err := NewKeyring(testKey(1))
+	require.NoError(t, err)
+	sealed, err := old.Encrypt([]byte("state"))
+	require.NoError(t, err)
+
+	// A rotated keyring reads files sealed with the previous key
+	rotated, err := NewKeyring(testKey(2), testKey(1))
+	require.NoError(t, err)
+	opened, err := rotated.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("state"), opened)
+
+	resealed, err := rotated.Encrypt(opened)
+	require.NoError(t, err)
+	_, err = old.Decrypt(resealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// A keyring with only previous keys decrypts and writes plain JSON
+	decryptOnly, err := NewKeyring(nil, testKey(1))
+	require.NoError(t, err)
+	assert.False(t, decryptOnly.Encrypting())
+	out, err := decryptOnly.Encrypt([]byte("state"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("state"), out)
+	_, err = decryptOnly.Decrypt(sealed)
+	assert.NoError(t, err)
+
+	var none *Keyring
+	assert.False(t, none.Encrypting())
+	_, err = none.Decrypt(sealed)
+	assert.ErrorIs(t, err, ErrEncrypted)
+
+	_, err = NewKeyring([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	r, err := NewKeyring(testKey(1))
+	require.NoError(t, err)
+	sealed, err := r.Encrypt([]byte("state"))
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	_, err = r.Decrypt(tampered)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownKey)
+
+	_, err = r.Decrypt(sealed[:len(magic)+3])
+	assert.ErrorContains(t, err, "truncated")
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, key []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+		return path
+	}
+	current := writeKey("current.key", testKey(1))
+	previous := writeKey("previous.key", testKey(2))
+
+	r, err := Load(config.EncryptionConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	r, err = Load(config.EncryptionConfig{Enabled: true, KeyFile: current, PreviousKeyFiles: []string{previous}})
+	require.NoError(t, err)
+	assert.True(t, r.Encrypting())
+
+	old, _ := NewKeyring(testKey(2))
+	sealed, _ := old.Encrypt([]byte("state"))
+	_, err = r.Decrypt(sealed)
+	assert.NoError(t, err)
+
+	// The key itself takes precedence over the key file
+	r, err = Load(config.EncryptionConfig{Enabled: true, Key: base64.StdEncoding.EncodeToString(testKey(2)), KeyFile: current})
+	require.NoError(t, err)
+	_, err = r.Decrypt(sealed)
+	assert.NoError(t, err)
+
+	// Previous keys alone decrypt without encrypting
+	r, err = Load(config.EncryptionConfig{PreviousKeyFiles: []string{previous}})
+	require.NoError(t, err)
+	assert.False(t, r.Encrypting())
+
+	_, err = Load(config.EncryptionConfig{Enabled: true, KeyFile: filepath.Join(dir, "missing.key")})
+	assert.Error(t, err)
+	_, err = Load(config.EncryptionConfig{Enabled: true, Key: "not base64!"})
+	assert.Error(t, err)
+	_, err = Load(config.EncryptionConfig{Enabled: true, Key: base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.ErrorContains(t, err, "32 bytes")
+}
@@ -0,0 +1,160 @@
+// Package failures classifies provisioning errors and counts failures per
+// error class and day, so systemic problems show in the weekly summary
+package failures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+	"github.com/mordenhost/whm2bunny/internal/quota"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// Retention is how long daily counts are kept: enough to compare two full
+// weeks
+const Retention = 35 * 24 * time.Hour
+
+// dayLayout keys the daily counts
+const dayLayout = "2006-01-02"
+
+// Classify returns the class of a provisioning error from the Bunny API
+// status, quota and network errors it wraps. Errors specific to the
+// provisioner, such as a failed origin check, are classified by the caller
+func Classify(err error) state.ErrorClass {
+	var apiErr *bunny.APIError
+	if errors.As(err, &apiErr) {
+		switch code := apiErr.StatusCode; {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return state.ErrorClassAuth
+		case code == http.StatusPaymentRequired:
+			return state.ErrorClassQuota
+		case code == http.StatusTooManyRequests:
+			return state.ErrorClassRateLimit
+		case code >= http.StatusInternalServerError:
+			return state.ErrorClassNetwork
+		case code >= http.StatusBadRequest:
+			return state.ErrorClassValidation
+		}
+	}
+
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		return state.ErrorClassQuota
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return state.ErrorClassNetwork
+	}
+	return state.ErrorClassUnknown
+}
+
+// Count is the number of failures of one class
+type Count struct {
+	Class    state.ErrorClass `json:"class"`
+	Failures int              `json:"failures"`
+}
+
+// Store persists daily failure counts to a JSON file
+type Store struct {
+	file *filestore.File[map[string]map[state.ErrorClass]int]
+	// days maps a UTC day to the failures per class on that day
+	days   map[string]map[state.ErrorClass]int
+	mu     sync.Mutex
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewStore creates a store persisting failure counts to filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "failure count", 0644, func() map[string]map[state.ErrorClass]int {
+		return make(map[string]map[state.ErrorClass]int)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	if err := s.file.Reload(&s.days); err != nil {
+		return nil, fmt.Errorf("failed to load failure counts: %w", err)
+	}
+	return s, nil
+}
+
+// Record counts a failure of class on the day of at, now when zero,
+// dropping days older than Retention. Unclassified failures are counted as
+// unknown
+func (s *Store) Record(class state.ErrorClass, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if class == "" {
+		class = state.ErrorClassUnknown
+	}
+	if at.IsZero() {
+		at = s.now()
+	}
+
+	cutoff := s.now().UTC().Add(-Retention).Format(dayLayout)
+	maps.DeleteFunc(s.days, func(day string, _ map[state.ErrorClass]int) bool {
+		return day < cutoff
+	})
+
+	day := at.UTC().Format(dayLayout)
+	if s.days[day] == nil {
+		s.days[day] = make(map[state.ErrorClass]int)
+	}
+	s.days[day][class]++
+	return s.file.Save(s.days)
+}
+
+// Breakdown returns the failures per class on the UTC days from from up to
+// but excluding to, most frequent first; classes without failures are left
+// out
+func (s *Store) Breakdown(from, to time.Time) []Count {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first, last := from.UTC().Format(dayLayout), to.UTC().Format(dayLayout)
+	totals := make(map[state.ErrorClass]int)
+	for day, counts := range s.days {
+		if day < first || day >= last {
+			continue
+		}
+		for class, n := range counts {
+			totals[class] += n
+		}
+	}
+
+	var breakdown []Count
+	for _, class := range state.ErrorClasses {
+		if n := totals[class]; n > 0 {
+			breakdown = append(breakdown, Count{Class: class, Failures: n})
+		}
+	}
+	// Classes stay in their listed order among equal counts
+	slices.SortStableFunc(breakdown, func(a, b Count) int {
+		return b.Failures - a.Failures
+	})
+	return breakdown
+}
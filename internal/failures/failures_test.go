@@ -0,0 +1,109 @@
+package failures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/quota"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+func newTestStore(t *testing.T, now time.Time) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "failures.json"), nil)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestClassify(t *testing.T) {
+	apiErr := func(code int) error {
+		return fmt.Errorf("failed to create pull zone: %w", &bunny.APIError{StatusCode: code})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want state.ErrorClass
+	}{
+		{"unauthorized", apiErr(401), state.ErrorClassAuth},
+		{"forbidden", apiErr(403), state.ErrorClassAuth},
+		{"payment required", apiErr(402), state.ErrorClassQuota},
+		{"rate limited", apiErr(429), state.ErrorClassRateLimit},
+		{"bad request", apiErr(400), state.ErrorClassValidation},
+		{"conflict", apiErr(409), state.ErrorClassValidation},
+		{"server error", apiErr(503), state.ErrorClassNetwork},
+		{"quota", &quota.ExceededError{Owner: "alice", Scope: "daily", Limit: 5, Used: 5}, state.ErrorClassQuota},
+		{"connection refused", fmt.Errorf("dns zone: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), state.ErrorClassNetwork},
+		{"timeout", fmt.Errorf("dns zone: %w", context.DeadlineExceeded), state.ErrorClassNetwork},
+		{"other", errors.New("record name is required"), state.ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Classify(tt.err))
+		})
+	}
+}
+
+func TestStore_Breakdown(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	for _, r := range []struct {
+		class state.ErrorClass
+		at    time.Time
+	}{
+		{state.ErrorClassNetwork, now.AddDate(0, 0, -8)},
+		{state.ErrorClassNetwork, now.AddDate(0, 0, -2)},
+		{state.ErrorClassOrigin, now.AddDate(0, 0, -1)},
+		{state.ErrorClassRateLimit, now},
+		{state.ErrorClassRateLimit, now},
+		{"", now},
+		{state.ErrorClassNetwork, time.Time{}},
+	} {
+		require.NoError(t, s.Record(r.class, r.at))
+	}
+
+	week := now.AddDate(0, 0, -6).Truncate(24 * time.Hour)
+	assert.Equal(t, []Count{
+		{Class: state.ErrorClassRateLimit, Failures: 2},
+		{Class: state.ErrorClassNetwork, Failures: 2},
+		{Class: state.ErrorClassOrigin, Failures: 1},
+		{Class: state.ErrorClassUnknown, Failures: 1},
+	}, s.Breakdown(week, now.AddDate(0, 0, 1)))
+
+	assert.Equal(t, []Count{{Class: state.ErrorClassNetwork, Failures: 1}},
+		s.Breakdown(week.AddDate(0, 0, -7), week))
+	assert.Empty(t, s.Breakdown(now.AddDate(0, 0, 1), now.AddDate(0, 0, 2)))
+
+	// Counts survive a restart
+	reopened, err := NewStore(s.file.Path(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, s.Breakdown(week, now.AddDate(0, 0, 1)), reopened.Breakdown(week, now.AddDate(0, 0, 1)))
+}
+
+func TestStore_Retention(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	require.NoError(t, s.Record(state.ErrorClassAuth, now.Add(-Retention-24*time.Hour)))
+	require.NoError(t, s.Record(state.ErrorClassAuth, now.Add(-Retention+24*time.Hour)))
+	require.NoError(t, s.Record(state.ErrorClassAuth, now))
+
+	assert.Equal(t, []Count{{Class: state.ErrorClassAuth, Failures: 2}},
+		s.Breakdown(now.AddDate(-1, 0, 0), now.AddDate(0, 0, 1)))
+
+	data, err := os.ReadFile(s.file.Path())
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), now.Add(-Retention-24*time.Hour).Format(dayLayout))
+}
@@ -0,0 +1,103 @@
+// Package filestore persists the small JSON files, such as overrides,
+// freezes and bypasses, that the server and CLI commands share. A File is
+// re-read whenever it changes on disk, and written through WriteFile so a
+// crash leaves either its old or its new contents
+package filestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a JSON file holding a value of type T
+// It is not safe for concurrent use; its owner serializes Reload and Save.
+// Nothing locks the file across processes: when the server and a CLI
+// command each reload, change and save it at the same time, the last save
+// wins and the other change is lost
+type File[T any] struct {
+	path  string
+	name  string
+	perm  os.FileMode
+	empty func() T
+
+	// A change in quick succession may share a coarse mtime, but rarely
+	// the size too
+	modTime time.Time
+	size    int64
+}
+
+// New returns the File at path, creating its directory. name describes the
+// file in errors, e.g. "freeze", perm is the mode it is written with, and
+// empty returns the value of a missing or empty file
+func New[T any](path, name string, perm os.FileMode, empty func() T) (*File[T], error) {
+	dirPerm := os.FileMode(0755)
+	if perm&0077 == 0 {
+		dirPerm = 0700
+	}
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create %s directory: %w", name, err)
+	}
+	return &File[T]{path: path, name: name, perm: perm, empty: empty}, nil
+}
+
+// Path returns the path of the file
+func (f *File[T]) Path() string {
+	return f.path
+}
+
+// Reload reads the file into v if it changed on disk since it was last
+// read or saved; a missing file reads as empty. v is left as it is on error
+func (f *File[T]) Reload(v *T) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			*v = f.empty()
+			f.modTime = time.Time{}
+			f.size = 0
+			return nil
+		}
+		return fmt.Errorf("failed to stat %s file: %w", f.name, err)
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s file: %w", f.name, err)
+	}
+
+	value := f.empty()
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("failed to unmarshal %s file: %w", f.name, err)
+		}
+	}
+
+	*v = value
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// Save writes v to the file atomically and durably
+func (f *File[T]) Save(v T) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s file: %w", f.name, err)
+	}
+
+	if err := WriteFile(f.path, data, f.perm); err != nil {
+		return fmt.Errorf("failed to write %s file: %w", f.name, err)
+	}
+
+	if info, err := os.Stat(f.path); err == nil {
+		f.modTime = info.ModTime()
+		f.size = info.Size()
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package filestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMap() map[string]int {
+	return make(map[string]int)
+}
+
+func TestFile_SaveAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "counts.json")
+	f, err := New(path, "counts", 0644, newMap)
+	require.NoError(t, err)
+
+	// A missing file reads as empty
+	var counts map[string]int
+	require.NoError(t, f.Reload(&counts))
+	assert.NotNil(t, counts)
+	assert.Empty(t, counts)
+
+	counts["a"] = 1
+	require.NoError(t, f.Save(counts))
+	assertOnly(t, path)
+
+	// Another process writes the file
+	other, err := New(path, "counts", 0644, newMap)
+	require.NoError(t, err)
+	var theirs map[string]int
+	require.NoError(t, other.Reload(&theirs))
+	assert.Equal(t, map[string]int{"a": 1}, theirs)
+	theirs["b"] = 22
+	require.NoError(t, other.Save(theirs))
+
+	require.NoError(t, f.Reload(&counts))
+	assert.Equal(t, map[string]int{"a": 1, "b": 22}, counts)
+
+	// An unchanged file is not read again
+	counts["local"] = 3
+	require.NoError(t, f.Reload(&counts))
+	assert.Equal(t, 3, counts["local"])
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, f.Reload(&counts))
+	assert.Empty(t, counts)
+}
+
+func TestFile_ReloadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+
+	f, err := New(path, "counts", 0644, newMap)
+	require.NoError(t, err)
+
+	counts := map[string]int{"cached": 1}
+	err = f.Reload(&counts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "counts file")
+	assert.Equal(t, map[string]int{"cached": 1}, counts)
+}
+
+func TestFile_Permissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret", "keys.json")
+	f, err := New(path, "keys", 0600, newMap)
+	require.NoError(t, err)
+	require.NoError(t, f.Save(map[string]int{"k": 1}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	dir, err := os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), dir.Mode().Perm())
+}
+
+// assertOnly checks that path is the only file of its directory, so no
+// temp file was left behind
+func assertOnly(t *testing.T, path string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{filepath.Base(path)}, names)
+}
+
+func TestWriteFileFunc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+
+	var prepared string
+	require.NoError(t, WriteFileFunc(path, []byte("{}"), 0640, func(tmpPath string) error {
+		prepared = tmpPath
+		info, err := os.Stat(tmpPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		return nil
+	}))
+	assert.Equal(t, filepath.Dir(path), filepath.Dir(prepared))
+	assertOnly(t, path)
+
+	require.Error(t, WriteFileFunc(path, []byte("[]"), 0640, func(string) error {
+		return os.ErrPermission
+	}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data), "a failed prepare must leave the file as it was")
+	assertOnly(t, path)
+}
+
+func TestWriteFile_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pause.json")
+
+	// Writers in different processes each rename a whole file into place
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- WriteFile(path, []byte(fmt.Sprintf(`{"writer": %d}`, i)), 0644)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	var content map[string]int
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &content), "the file is one writer's whole content")
+	assert.Contains(t, content, "writer")
+	assertOnly(t, path)
+}
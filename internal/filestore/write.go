@@ -0,0 +1,73 @@
+package filestore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temp file, fsyncs it, renames it over path
+// and fsyncs the directory so the rename survives a crash
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return WriteFileFunc(path, data, perm, nil)
+}
+
+// WriteFileFunc is WriteFile calling prepare, when not nil, on the synced
+// temp file before it is renamed, e.g. to change its owner. Each write has
+// its own temp file, so the server and a CLI command writing the same file
+// do not clobber each other's
+func WriteFileFunc(path string, data []byte, perm os.FileMode, prepare func(tmpPath string) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := f.Name()
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set temp file mode: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if prepare != nil {
+		if err := prepare(tmpPath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return SyncDir(filepath.Dir(path))
+}
+
+// SyncDir fsyncs a directory so renames within it are durable
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory for sync: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package flags lists the feature flags gating risky behaviors, so they can
+// ship disabled and be enabled one server at a time during a rollout. A flag
+// is set by the flags section of the config, and an environment variable
+// overrides it. New flags default to disabled
+package flags
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Flag names
+const (
+	// ApexOnCDN allows dns.record_strategy apex_via_cdn, which points the
+	// apex of each domain at the pull zone
+	ApexOnCDN = "apex_on_cdn"
+	// DriftAutoRepair allows drift.fix, which changes the records and pull
+	// zones found to drift instead of only reporting them
+	DriftAutoRepair = "drift_auto_repair"
+
+	// AutoRollback is reserved for rolling back a failed provision
+	AutoRollback = "auto_rollback"
+	// WildcardSubdomains is reserved for routing *.<domain> through the
+	// pull zone
+	WildcardSubdomains = "wildcard_subdomains"
+)
+
+// EnvPrefix prefixes the environment variables overriding flags, e.g.
+// WHM2BUNNY_FLAG_APEX_ON_CDN=false
+const EnvPrefix = "WHM2BUNNY_FLAG_"
+
+// Source says where the value of a flag comes from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceConfig  Source = "config"
+	SourceEnv     Source = "env"
+)
+
+// Flag is a known feature flag. Flags default to disabled, except those
+// gating behaviors that shipped before them, so upgrading does not turn
+// them off
+type Flag struct {
+	Name        string
+	Description string
+	Default     bool
+	// Reserved flags name behaviors not implemented yet; they can be set,
+	// but have no effect
+	Reserved bool
+}
+
+// known lists the feature flags, sorted by name
+var known = []Flag{
+	{
+		Name:        ApexOnCDN,
+		Description: "Allow dns.record_strategy apex_via_cdn",
+		Default:     true,
+	},
+	{
+		Name:        AutoRollback,
+		Description: "Roll back the resources of a failed provision (reserved, no effect yet)",
+		Reserved:    true,
+	},
+	{
+		Name:        DriftAutoRepair,
+		Description: "Allow drift.fix to repair drifted records and pull zones",
+		Default:     true,
+	},
+	{
+		Name:        WildcardSubdomains,
+		Description: "Route *.<domain> through the pull zone (reserved, no effect yet)",
+		Reserved:    true,
+	},
+}
+
+// Known returns the feature flags, sorted by name
+func Known() []Flag {
+	return slices.Clone(known)
+}
+
+// Lookup returns the flag name; false when there is none
+func Lookup(name string) (Flag, bool) {
+	for _, f := range known {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flag{}, false
+}
+
+// EnvVar returns the environment variable overriding flag name
+func EnvVar(name string) string {
+	return EnvPrefix + strings.ToUpper(name)
+}
+
+// State is the value of a flag on this server
+type State struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Default     bool   `json:"default"`
+	Source      Source `json:"source"`
+	Reserved    bool   `json:"reserved,omitempty"`
+}
+
+// Set holds the value of every known flag
+type Set struct {
+	states map[string]State
+}
+
+// Resolve returns the flags set by configured, the flags section of the
+// config, overridden by the environment variables lookupEnv finds. Unknown
+// names and values that are not booleans are errors, so a typo does not
+// leave a flag silently at its default
+func Resolve(configured map[string]bool, lookupEnv func(string) (string, bool)) (*Set, error) {
+	for name := range configured {
+		if _, ok := Lookup(name); !ok {
+			return nil, fmt.Errorf("unknown feature flag %q", name)
+		}
+	}
+
+	s := &Set{states: make(map[string]State, len(known))}
+	for _, f := range known {
+		state := State{
+			Name:        f.Name,
+			Description: f.Description,
+			Enabled:     f.Default,
+			Default:     f.Default,
+			Source:      SourceDefault,
+			Reserved:    f.Reserved,
+		}
+		if enabled, ok := configured[f.Name]; ok {
+			state.Enabled, state.Source = enabled, SourceConfig
+		}
+		if lookupEnv != nil {
+			if value, ok := lookupEnv(EnvVar(f.Name)); ok && value != "" {
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s must be true or false, got %q", EnvVar(f.Name), value)
+				}
+				state.Enabled, state.Source = enabled, SourceEnv
+			}
+		}
+		s.states[f.Name] = state
+	}
+	return s, nil
+}
+
+// Enabled reports whether flag name is enabled; unknown flags are not
+func (s *Set) Enabled(name string) bool {
+	return s.states[name].Enabled
+}
+
+// States returns the value of every flag, sorted by name
+func (s *Set) States() []State {
+	states := make([]State, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
@@ -0,0 +1,95 @@
+package flags
+
+import (
+	"sort"
+	"testing"
+)
+
+// env returns a lookupEnv reading vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func TestResolve(t *testing.T) {
+	set, err := Resolve(map[string]bool{ApexOnCDN: false, DriftAutoRepair: false}, env(map[string]string{
+		"WHM2BUNNY_FLAG_DRIFT_AUTO_REPAIR": "true",
+	}))
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	if set.Enabled(ApexOnCDN) {
+		t.Error("Expected the config to disable apex_on_cdn")
+	}
+	if !set.Enabled(DriftAutoRepair) {
+		t.Error("Expected the environment to override the config")
+	}
+	if set.Enabled("unknown") {
+		t.Error("Expected unknown flags to be disabled")
+	}
+
+	states := set.States()
+	if len(states) != len(Known()) {
+		t.Fatalf("Expected %d flags, got %d", len(Known()), len(states))
+	}
+	for _, state := range states {
+		want, ok := map[string]Source{ApexOnCDN: SourceConfig, DriftAutoRepair: SourceEnv}[state.Name]
+		if !ok {
+			want = SourceDefault
+		}
+		if state.Source != want {
+			t.Errorf("Expected %s from %s, got %s", state.Name, want, state.Source)
+		}
+	}
+}
+
+func TestResolve_Defaults(t *testing.T) {
+	set, err := Resolve(nil, nil)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	for _, f := range Known() {
+		if set.Enabled(f.Name) != f.Default {
+			t.Errorf("Expected %s to default to %v", f.Name, f.Default)
+		}
+	}
+}
+
+func TestResolve_Invalid(t *testing.T) {
+	if _, err := Resolve(map[string]bool{"apex_on_cnd": true}, nil); err == nil {
+		t.Error("Expected an unknown flag to be rejected")
+	}
+	if _, err := Resolve(nil, env(map[string]string{"WHM2BUNNY_FLAG_APEX_ON_CDN": "maybe"})); err == nil {
+		t.Error("Expected a non-boolean override to be rejected")
+	}
+}
+
+func TestKnown(t *testing.T) {
+	// Only flags gating behaviors that predate the flags may default to
+	// enabled; new gates ship dark
+	predating := map[string]bool{ApexOnCDN: true, DriftAutoRepair: true}
+
+	names := make([]string, 0, len(Known()))
+	for _, f := range Known() {
+		names = append(names, f.Name)
+		if f.Default && !predating[f.Name] {
+			t.Errorf("Expected %s to default to disabled", f.Name)
+		}
+		if f.Reserved && f.Default {
+			t.Errorf("Expected reserved flag %s to default to disabled", f.Name)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Expected flags sorted by name, got %v", names)
+	}
+
+	for _, name := range []string{AutoRollback, WildcardSubdomains} {
+		f, ok := Lookup(name)
+		if !ok || !f.Reserved {
+			t.Errorf("Expected %s to be a reserved flag", name)
+		}
+	}
+}
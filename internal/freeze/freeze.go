@@ -0,0 +1,144 @@
+// Package freeze tracks domains frozen by an administrator, whose DNS and
+// CDN automation is suspended while support debugs them by hand
+package freeze
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Entry is the freeze of one domain
+type Entry struct {
+	Reason   string    `json:"reason,omitempty"`
+	FrozenAt time.Time `json:"frozen_at"`
+}
+
+// Store persists frozen domains
+type Store struct {
+	file    *filestore.File[map[string]Entry]
+	entries map[string]Entry
+	mu      sync.Mutex
+	logger  *zap.Logger
+}
+
+// NewStore creates a freeze store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "freeze", 0644, func() map[string]Entry {
+		return make(map[string]Entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.entries); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns the freeze entry of exactly domain
+func (s *Store) Get(domain string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload freeze state, using cached copy", zap.Error(err))
+	}
+
+	e, ok := s.entries[domainname.Normalize(domain)]
+	return e, ok
+}
+
+// Frozen reports whether domain or one of its parent domains is frozen,
+// as a subdomain's records live in its parent's zone. It returns the
+// frozen domain and its entry
+func (s *Store) Frozen(domain string) (string, Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload freeze state, using cached copy", zap.Error(err))
+	}
+
+	name := domainname.Normalize(domain)
+	for name != "" {
+		if e, ok := s.entries[name]; ok {
+			return name, e, true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			break
+		}
+		name = parent
+	}
+	return "", Entry{}, false
+}
+
+// Set records a domain as frozen
+func (s *Store) Set(domain string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	if entry.FrozenAt.IsZero() {
+		entry.FrozenAt = time.Now()
+	}
+	s.entries[domainname.Normalize(domain)] = entry
+	return s.file.Save(s.entries)
+}
+
+// Delete removes a domain's freeze
+func (s *Store) Delete(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	return s.file.Save(s.entries)
+}
+
+// Domains returns all frozen domains, sorted
+func (s *Store) Domains() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.entries); err != nil {
+		s.logger.Warn("Failed to reload freeze state, using cached copy", zap.Error(err))
+	}
+
+	domains := make([]string, 0, len(s.entries))
+	for d := range s.entries {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
@@ -0,0 +1,59 @@
+package freeze
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_SetGetDelete(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "freeze.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	_, ok := s.Get("example.com")
+	assert.False(t, ok)
+
+	require.NoError(t, s.Set("Example.com.", Entry{Reason: "ticket 4711"}))
+
+	e, ok := s.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "ticket 4711", e.Reason)
+	assert.False(t, e.FrozenAt.IsZero())
+	assert.Equal(t, []string{"example.com"}, s.Domains())
+
+	require.NoError(t, s.Delete("example.com"))
+	_, ok = s.Get("example.com")
+	assert.False(t, ok)
+}
+
+func TestStore_FrozenCoversSubdomains(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "freeze.json"), zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, s.Set("example.com", Entry{}))
+
+	domain, _, ok := s.Frozen("Blog.Example.com")
+	require.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	_, _, ok = s.Frozen("example.org")
+	assert.False(t, ok)
+	_, _, ok = s.Frozen("notexample.com")
+	assert.False(t, ok)
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "freeze.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Set("example.com", Entry{Reason: "manual DNS debugging"}))
+
+	_, e, ok := server.Frozen("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "manual DNS debugging", e.Reason)
+}
@@ -0,0 +1,359 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// fakeProvisioner records the calls made by Apply
+type fakeProvisioner struct {
+	states map[string]*state.ProvisionState
+	drifts map[string][]provisioner.Drift
+	frozen map[string]bool
+	calls  []string
+}
+
+func (f *fakeProvisioner) Provision(domain, user string) error {
+	f.calls = append(f.calls, "provision "+domain+" "+user)
+	return nil
+}
+
+func (f *fakeProvisioner) ProvisionSubdomain(subdomain, parentDomain, user string) error {
+	f.calls = append(f.calls, "provision_subdomain "+subdomain+" "+parentDomain)
+	return nil
+}
+
+func (f *fakeProvisioner) AssignPackage(domain, pkg string) error {
+	if pkg != "" {
+		f.calls = append(f.calls, "assign "+domain+" "+pkg)
+	}
+	return nil
+}
+
+func (f *fakeProvisioner) SetPackage(_ context.Context, domain, pkg string) (*provisioner.PackageChange, error) {
+	if f.states[domain].Package == pkg {
+		return nil, nil
+	}
+	f.calls = append(f.calls, "set_package "+domain+" "+pkg)
+	return &provisioner.PackageChange{Domain: domain, ToPackage: pkg}, nil
+}
+
+func (f *fakeProvisioner) Deprovision(domain string) error {
+	f.calls = append(f.calls, "deprovision "+domain)
+	return nil
+}
+
+func (f *fakeProvisioner) RemoveSubdomain(subdomain, parentDomain string) error {
+	f.calls = append(f.calls, "remove_subdomain "+subdomain+" "+parentDomain)
+	return nil
+}
+
+func (f *fakeProvisioner) AllStates() []*state.ProvisionState {
+	states := make([]*state.ProvisionState, 0, len(f.states))
+	for _, st := range f.states {
+		states = append(states, st)
+	}
+	return states
+}
+
+func (f *fakeProvisioner) CheckDrift(_ context.Context, domain string, fix bool) ([]provisioner.Drift, error) {
+	if !fix {
+		return f.drifts[domain], nil
+	}
+	f.calls = append(f.calls, "fix "+domain)
+	drifts := f.drifts[domain]
+	for i := range drifts {
+		drifts[i].Fixed = true
+	}
+	return drifts, nil
+}
+
+func (f *fakeProvisioner) IsFrozen(domain string) bool {
+	return f.frozen[domain]
+}
+
+// fakeOverrides is an in-memory override store
+type fakeOverrides map[string]overrides.Override
+
+func (f fakeOverrides) Get(domain string) (overrides.Override, bool) {
+	o, ok := f[domain]
+	return o, ok
+}
+
+func (f fakeOverrides) Update(domain string, fn func(o *overrides.Override)) error {
+	o := f[domain]
+	fn(&o)
+	f[domain] = o
+	return nil
+}
+
+func (f fakeOverrides) Delete(domain string) error {
+	delete(f, domain)
+	return nil
+}
+
+var testProfiles = config.ProfilesConfig{
+	Default:  "basic",
+	Packages: map[string]string{"gold": "premium"},
+	Definitions: map[string]config.ProfileConfig{
+		"basic":   {Rank: 1},
+		"premium": {Rank: 2},
+	},
+}
+
+const testSpec = `
+prune: true
+domains:
+  - domain: Example.com.
+    user: exampleu
+    package: gold
+  - domain: blog.example.com
+    parent: example.com
+  - domain: shop.com
+    package: gold
+    overrides:
+      optimizer: true
+      blocked_countries: [CN, RU]
+  - domain: stable.com
+`
+
+func TestParse(t *testing.T) {
+	spec, err := Parse([]byte(testSpec))
+	require.NoError(t, err)
+	require.Len(t, spec.Domains, 4)
+	assert.True(t, spec.Prune)
+	assert.Equal(t, "example.com", spec.Domains[0].Domain)
+	assert.Equal(t, "example.com", spec.Domains[1].Parent)
+	assert.Equal(t, overrides.Bool(true), spec.Domains[2].Overrides.Optimizer)
+	assert.Equal(t, []string{"CN", "RU"}, spec.Domains[2].Overrides.BlockedCountries)
+
+	for name, doc := range map[string]string{
+		"unknown field":     "domains:\n  - domain: example.com\n    pakage: gold\n",
+		"duplicate":         "domains:\n  - domain: example.com\n  - domain: EXAMPLE.com\n",
+		"unlisted parent":   "domains:\n  - domain: blog.example.com\n    parent: example.com\n",
+		"not a subdomain":   "domains:\n  - domain: example.com\n  - domain: blog.other.com\n    parent: example.com\n",
+		"invalid domain":    "domains:\n  - domain: -bad-\n",
+		"invalid tier":      "domains:\n  - domain: example.com\n    overrides:\n      tier: premium\n",
+		"invalid protocol":  "domains:\n  - domain: example.com\n    overrides:\n      origin_protocol: ftp\n",
+		"invalid port":      "domains:\n  - domain: example.com\n    overrides:\n      origin_port: 70000\n",
+		"nested subdomains": "domains:\n  - domain: example.com\n  - domain: a.example.com\n    parent: example.com\n  - domain: b.a.example.com\n    parent: a.example.com\n",
+	} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, name)
+	}
+}
+
+func newTestPlanner(t *testing.T) (*Planner, *fakeProvisioner, fakeOverrides, *Spec) {
+	t.Helper()
+	spec, err := Parse([]byte(testSpec))
+	require.NoError(t, err)
+
+	prov := &fakeProvisioner{
+		states: map[string]*state.ProvisionState{
+			"shop.com":         {Domain: "shop.com", Status: state.StatusSuccess, Package: "silver", PullZoneID: 1},
+			"stable.com":       {Domain: "stable.com", Status: state.StatusSuccess, PullZoneID: 2},
+			"old.com":          {Domain: "old.com", Status: state.StatusSuccess, PullZoneID: 3},
+			"cdn.old.com":      {Domain: "cdn.old.com", ParentDomain: "old.com", Status: state.StatusSuccess, PullZoneID: 4},
+			"frozen.com":       {Domain: "frozen.com", Status: state.StatusSuccess, PullZoneID: 5},
+			"blog.example.com": {Domain: "blog.example.com", ParentDomain: "example.com", Status: state.StatusFailed},
+		},
+		drifts: map[string][]provisioner.Drift{
+			"shop.com": {{Field: "tier", Want: "volume", Got: "standard"}},
+		},
+		frozen: map[string]bool{"frozen.com": true},
+	}
+	o := fakeOverrides{"shop.com": {Optimizer: overrides.Bool(false), Tier: "volume"}}
+	return NewPlanner(prov, o, testProfiles, nil), prov, o, spec
+}
+
+func TestPlan(t *testing.T) {
+	planner, _, _, spec := newTestPlanner(t)
+
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+
+	var order []string
+	for _, a := range plan.Actions {
+		order = append(order, string(a.Type)+" "+a.Domain)
+	}
+	assert.Equal(t, []string{
+		"create example.com",
+		"create blog.example.com",
+		"update shop.com",
+		"delete cdn.old.com",
+		"delete old.com",
+	}, order)
+	assert.Equal(t, 2, plan.Count(ActionCreate))
+	assert.Equal(t, []string{"frozen.com"}, plan.Frozen)
+
+	assert.Equal(t, []Change{
+		{Field: "user", After: "exampleu"},
+		{Field: "package", After: "gold"},
+		{Field: "profile", After: "premium"},
+	}, plan.Actions[0].Changes)
+	assert.Equal(t, []Change{
+		{Field: "package", Before: "silver", After: "gold"},
+		{Field: "profile", Before: "basic", After: "premium"},
+		{Field: "overrides.blocked_countries", After: "CN, RU"},
+		{Field: "overrides.optimizer", Before: "false", After: "true"},
+		{Field: "overrides.tier", Before: "volume"},
+		{Field: "tier", Before: "standard", After: "volume"},
+	}, plan.Actions[2].Changes)
+}
+
+func TestPlan_WithoutPrune(t *testing.T) {
+	planner, _, _, spec := newTestPlanner(t)
+	spec.Prune = false
+
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+	assert.Zero(t, plan.Count(ActionDelete))
+	assert.Equal(t, []string{"cdn.old.com", "frozen.com", "old.com"}, plan.Unmanaged)
+}
+
+func TestApply(t *testing.T) {
+	planner, prov, o, spec := newTestPlanner(t)
+
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+	require.NoError(t, planner.Apply(context.Background(), plan))
+
+	assert.Equal(t, []string{
+		"assign example.com gold",
+		"provision example.com exampleu",
+		"provision_subdomain blog example.com",
+		"set_package shop.com gold",
+		"fix shop.com",
+		"remove_subdomain cdn old.com",
+		"deprovision old.com",
+	}, prov.calls)
+	assert.Equal(t, overrides.Override{
+		Optimizer:        overrides.Bool(true),
+		BlockedCountries: []string{"CN", "RU"},
+	}, o["shop.com"])
+}
+
+func TestApply_ReportsFailures(t *testing.T) {
+	planner, prov, _, spec := newTestPlanner(t)
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+
+	planner.provisioner = &failingProvisioner{fakeProvisioner: prov}
+	err = planner.Apply(context.Background(), plan)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 5 action(s) failed")
+	assert.Contains(t, prov.calls, "deprovision old.com", "later actions still run")
+	assert.Equal(t, "zone creation failed", plan.Actions[0].Error)
+	assert.Equal(t, 1, plan.Failed())
+}
+
+// fakeAuditor keeps the recorded entries
+type fakeAuditor []audit.Entry
+
+func (f *fakeAuditor) Record(entry audit.Entry) error {
+	*f = append(*f, entry)
+	return nil
+}
+
+func TestApply_SavesPlan(t *testing.T) {
+	planner, _, _, spec := newTestPlanner(t)
+	spec.Source = "domains.yaml"
+	var auditor fakeAuditor
+	dir := filepath.Join(t.TempDir(), "plans")
+	planner.SetAudit(&auditor, dir)
+
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+	require.NoError(t, planner.Apply(context.Background(), plan))
+
+	require.Len(t, auditor, 1)
+	assert.Equal(t, "plan.applied", auditor[0].Action)
+	details := auditor[0].Details.(map[string]any)
+	assert.Equal(t, "domains.yaml", details["source"])
+	assert.Equal(t, 2, details["created"])
+
+	data, err := os.ReadFile(filepath.Join(dir, details["plan"].(string)))
+	require.NoError(t, err)
+	var saved Plan
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, "domains.yaml", saved.Source)
+	assert.False(t, saved.Applied.IsZero())
+	assert.Len(t, saved.Actions, 5)
+	assert.Equal(t, plan.Actions[2].Changes, saved.Actions[2].Changes)
+}
+
+func TestPlanDomain(t *testing.T) {
+	planner, _, o, _ := newTestPlanner(t)
+	o["new.com"] = overrides.Override{Optimizer: overrides.Bool(true)}
+
+	plan, err := planner.PlanDomain(DomainSpec{Domain: "New.com", Package: "gold"})
+	require.NoError(t, err)
+	require.Len(t, plan.Actions, 1)
+	assert.Equal(t, ActionCreate, plan.Actions[0].Type)
+	assert.Equal(t, []Change{
+		{Field: "package", After: "gold"},
+		{Field: "profile", After: "premium"},
+		{Field: "overrides.optimizer", After: "true"},
+	}, plan.Actions[0].Changes)
+
+	plan, err = planner.PlanDomain(DomainSpec{Domain: "shop.com", Package: "bronze"})
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "provisioned domains are left as they are")
+
+	_, err = planner.PlanDomain(DomainSpec{Domain: "blog.other.com", Parent: "example.com"})
+	assert.Error(t, err)
+}
+
+func TestPrint(t *testing.T) {
+	planner, _, _, spec := newTestPlanner(t)
+	plan, err := planner.Plan(context.Background(), spec)
+	require.NoError(t, err)
+	plan.Actions[0].Error = "zone creation failed"
+
+	var buf bytes.Buffer
+	plan.Print(&buf, false)
+	out := buf.String()
+	assert.Contains(t, out, "  + example.com\n")
+	assert.Contains(t, out, "  + blog.example.com (subdomain of example.com)\n")
+	assert.Contains(t, out, "  ~ shop.com\n")
+	assert.Contains(t, out, "  - old.com\n")
+	assert.Regexp(t, `overrides.optimizer +false -> true`, out)
+	assert.Regexp(t, `overrides.tier +volume -> \(none\)`, out)
+	assert.Contains(t, out, "failed: zone creation failed")
+	assert.Contains(t, out, "Plan: 2 to provision, 1 to update, 2 to deprovision")
+	assert.NotContains(t, out, "\033[")
+
+	buf.Reset()
+	plan.Print(&buf, true)
+	assert.Contains(t, buf.String(), "\033[32m+\033[0m")
+
+	buf.Reset()
+	(&Plan{}).Print(&buf, false)
+	assert.Contains(t, buf.String(), "No changes")
+}
+
+// failingProvisioner fails to provision example.com
+type failingProvisioner struct {
+	*fakeProvisioner
+}
+
+func (f *failingProvisioner) Provision(domain, user string) error {
+	if domain == "example.com" {
+		return errors.New("zone creation failed")
+	}
+	return f.fakeProvisioner.Provision(domain, user)
+}
@@ -0,0 +1,500 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// Provisioner provisions, updates and removes domains
+type Provisioner interface {
+	Provision(domain, user string) error
+	ProvisionSubdomain(subdomain, parentDomain, user string) error
+	AssignPackage(domain, pkg string) error
+	SetPackage(ctx context.Context, domain, pkg string) (*provisioner.PackageChange, error)
+	Deprovision(domain string) error
+	RemoveSubdomain(subdomain, parentDomain string) error
+	AllStates() []*state.ProvisionState
+	CheckDrift(ctx context.Context, domain string, fix bool) ([]provisioner.Drift, error)
+	IsFrozen(domain string) bool
+}
+
+// Overrides reads and writes per-domain overrides
+type Overrides interface {
+	Get(domain string) (overrides.Override, bool)
+	Update(domain string, fn func(o *overrides.Override)) error
+	Delete(domain string) error
+}
+
+// ActionType is what an action does to a domain
+type ActionType string
+
+// Action types
+const (
+	ActionCreate ActionType = "create"
+	ActionUpdate ActionType = "update"
+	ActionDelete ActionType = "delete"
+)
+
+// Change is one setting an action changes; Before is empty for settings a
+// domain does not have yet
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Action converges one domain
+type Action struct {
+	Type    ActionType `json:"type"`
+	Domain  string     `json:"domain"`
+	Parent  string     `json:"parent,omitempty"`
+	Changes []Change   `json:"changes,omitempty"`
+	// Error is set on actions of an applied plan that failed
+	Error string `json:"error,omitempty"`
+
+	// desired is the listed domain of create and update actions
+	desired DomainSpec
+}
+
+// Plan is the list of actions converging the domains on a Spec, in the
+// order they are applied: new domains before their subdomains, then
+// updates, then removed subdomains before their parents
+type Plan struct {
+	// Source is the domains file the plan was made from, empty for a
+	// single domain
+	Source  string    `json:"source,omitempty"`
+	Actions []Action  `json:"actions"`
+	Created time.Time `json:"created"`
+	// Applied is set once the plan has been applied
+	Applied time.Time `json:"applied,omitzero"`
+	// Frozen lists the domains left alone because automation is frozen
+	Frozen []string `json:"frozen,omitempty"`
+	// Unmanaged lists the domains not in the file, left alone without prune
+	Unmanaged []string `json:"unmanaged,omitempty"`
+}
+
+// Count returns the number of actions of type t
+func (p *Plan) Count(t ActionType) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// Empty reports whether the domains already match the file
+func (p *Plan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+// Failed returns the number of actions that failed when the plan was applied
+func (p *Plan) Failed() int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the plan as JSON to path
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0640); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// Auditor records applied plans
+type Auditor interface {
+	Record(entry audit.Entry) error
+}
+
+// Planner computes and applies plans
+type Planner struct {
+	provisioner Provisioner
+	overrides   Overrides
+	profiles    config.ProfilesConfig
+	audit       Auditor
+	planDir     string
+	logger      *zap.Logger
+}
+
+// NewPlanner creates a planner; profiles resolve the package of each
+// domain to the profile shown in plans
+func NewPlanner(prov Provisioner, o Overrides, profiles config.ProfilesConfig, logger *zap.Logger) *Planner {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Planner{
+		provisioner: prov,
+		overrides:   o,
+		profiles:    profiles,
+		logger:      logger,
+	}
+}
+
+// SetAudit attaches an audit log. Each applied plan is saved as JSON in
+// planDir and recorded in the log with the name of its file
+func (p *Planner) SetAudit(a Auditor, planDir string) {
+	p.audit = a
+	p.planDir = planDir
+}
+
+// Plan compares the spec with the provisioning states, the overrides and
+// the pull zones on Bunny, whose drift from the domains' profiles is
+// corrected by an update
+func (p *Planner) Plan(ctx context.Context, spec *Spec) (*Plan, error) {
+	current := make(map[string]*state.ProvisionState)
+	for _, st := range p.provisioner.AllStates() {
+		current[st.Domain] = st
+	}
+
+	plan := &Plan{Source: spec.Source, Created: time.Now()}
+	var creates, updates, deletes []Action
+
+	for _, d := range spec.Domains {
+		if name := d.Overrides.Profile; name != "" {
+			if _, _, ok := p.profiles.Named(name); !ok {
+				return nil, fmt.Errorf("%s: unknown profile %q", d.Domain, name)
+			}
+		}
+		if p.provisioner.IsFrozen(d.Domain) {
+			plan.Frozen = append(plan.Frozen, d.Domain)
+			continue
+		}
+		st, ok := current[d.Domain]
+		if !ok || st.Status != state.StatusSuccess {
+			creates = append(creates, p.createAction(d))
+			continue
+		}
+		action, err := p.updateAction(ctx, d, st)
+		if err != nil {
+			return nil, err
+		}
+		if len(action.Changes) > 0 {
+			updates = append(updates, action)
+		}
+	}
+
+	for domain, st := range current {
+		if _, ok := spec.Get(domain); ok {
+			continue
+		}
+		switch {
+		case !spec.Prune:
+			plan.Unmanaged = append(plan.Unmanaged, domain)
+		case p.provisioner.IsFrozen(domain):
+			plan.Frozen = append(plan.Frozen, domain)
+		default:
+			deletes = append(deletes, Action{Type: ActionDelete, Domain: domain, Parent: st.ParentDomain})
+		}
+	}
+
+	// Parents are created before and removed after their subdomains
+	sortActions(creates, false)
+	sortActions(updates, false)
+	sortActions(deletes, true)
+	plan.Actions = append(append(creates, updates...), deletes...)
+	sort.Strings(plan.Frozen)
+	sort.Strings(plan.Unmanaged)
+	return plan, nil
+}
+
+// PlanDomain plans provisioning a single domain without looking at any
+// other, as a dry run of the provision command: like it, a provisioned
+// domain is left as it is
+func (p *Planner) PlanDomain(d DomainSpec) (*Plan, error) {
+	d.Domain = domainname.Normalize(d.Domain)
+	d.Parent = domainname.Normalize(d.Parent)
+	if d.Parent != "" {
+		if label, ok := strings.CutSuffix(d.Domain, "."+d.Parent); !ok || label == "" {
+			return nil, fmt.Errorf("%s is not a subdomain of %s", d.Domain, d.Parent)
+		}
+	}
+
+	plan := &Plan{Created: time.Now()}
+	for _, st := range p.provisioner.AllStates() {
+		if st.Domain == d.Domain && st.Status == state.StatusSuccess {
+			return plan, nil
+		}
+	}
+	// The stored override is read when the pull zone is created
+	d.Overrides, _ = p.overrides.Get(d.Domain)
+	plan.Actions = append(plan.Actions, p.createAction(d))
+	return plan, nil
+}
+
+// createAction lists the settings of a domain to provision
+func (p *Planner) createAction(d DomainSpec) Action {
+	action := Action{Type: ActionCreate, Domain: d.Domain, Parent: d.Parent, desired: d}
+	if d.User != "" {
+		action.Changes = append(action.Changes, Change{Field: "user", After: d.User})
+	}
+	if d.Package != "" {
+		action.Changes = append(action.Changes, Change{Field: "package", After: d.Package})
+	}
+	// A profile override is listed with the overrides
+	if profile, _ := p.profiles.Resolve(d.Package); profile != "" && d.Overrides.Profile == "" {
+		action.Changes = append(action.Changes, Change{Field: "profile", After: profile})
+	}
+	action.Changes = append(action.Changes, overrideChanges(overrides.Override{}, d.Overrides)...)
+	return action
+}
+
+// updateAction lists the package, override and pull zone settings of a
+// provisioned domain that differ from the file
+func (p *Planner) updateAction(ctx context.Context, d DomainSpec, st *state.ProvisionState) (Action, error) {
+	action := Action{Type: ActionUpdate, Domain: d.Domain, Parent: d.Parent, desired: d}
+
+	if d.Package != "" && !strings.EqualFold(d.Package, st.Package) {
+		action.Changes = append(action.Changes, Change{Field: "package", Before: st.Package, After: d.Package})
+		before, _ := p.profiles.Resolve(st.Package)
+		if after, _ := p.profiles.Resolve(d.Package); after != before {
+			action.Changes = append(action.Changes, Change{Field: "profile", Before: before, After: after})
+		}
+	}
+
+	current, _ := p.overrides.Get(d.Domain)
+	action.Changes = append(action.Changes, overrideChanges(current, d.Overrides)...)
+
+	drifts, err := p.provisioner.CheckDrift(ctx, d.Domain, false)
+	if err != nil {
+		return action, fmt.Errorf("failed to check %s: %w", d.Domain, err)
+	}
+	for _, drift := range drifts {
+		action.Changes = append(action.Changes, Change{Field: drift.Field, Before: drift.Got, After: drift.Want})
+	}
+	return action, nil
+}
+
+// Apply performs the actions of a plan in order. A failed action is logged
+// and the others still run; the failures are returned together
+func (p *Planner) Apply(ctx context.Context, plan *Plan) error {
+	defer p.record(plan)
+	plan.Applied = time.Now()
+
+	var errs []string
+	for i, action := range plan.Actions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.logger.Info("applying domain",
+			zap.String("action", string(action.Type)),
+			zap.String("domain", action.Domain),
+		)
+		if err := p.apply(ctx, action); err != nil {
+			p.logger.Error("apply failed",
+				zap.String("action", string(action.Type)),
+				zap.String("domain", action.Domain),
+				zap.Error(err),
+			)
+			plan.Actions[i].Error = err.Error()
+			errs = append(errs, fmt.Sprintf("%s %s: %v", action.Type, action.Domain, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d action(s) failed: %s", len(errs), len(plan.Actions), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// record saves an applied plan to the plan directory and records it in the
+// audit log
+func (p *Planner) record(plan *Plan) {
+	if p.audit == nil {
+		return
+	}
+
+	file := plan.Applied.UTC().Format("20060102T150405Z") + ".json"
+	if err := os.MkdirAll(p.planDir, 0750); err != nil {
+		p.logger.Error("failed to create plan directory", zap.Error(err))
+		return
+	}
+	if err := plan.Save(filepath.Join(p.planDir, file)); err != nil {
+		p.logger.Error("failed to save applied plan", zap.Error(err))
+		return
+	}
+
+	err := p.audit.Record(audit.Entry{
+		Actor:  "apply",
+		Action: "plan.applied",
+		Details: map[string]any{
+			"source":  plan.Source,
+			"plan":    file,
+			"created": plan.Count(ActionCreate),
+			"updated": plan.Count(ActionUpdate),
+			"deleted": plan.Count(ActionDelete),
+			"failed":  plan.Failed(),
+		},
+	})
+	if err != nil {
+		p.logger.Error("failed to write audit entry", zap.Error(err))
+	}
+}
+
+// apply performs one action
+func (p *Planner) apply(ctx context.Context, action Action) error {
+	d := action.desired
+	switch action.Type {
+	case ActionCreate:
+		// Overrides and the package are read when the pull zone is created
+		if err := p.setOverrides(d); err != nil {
+			return err
+		}
+		if err := p.provisioner.AssignPackage(d.Domain, d.Package); err != nil {
+			return err
+		}
+		if d.Parent == "" {
+			return p.provisioner.Provision(d.Domain, d.User)
+		}
+		return p.provisioner.ProvisionSubdomain(strings.TrimSuffix(d.Domain, "."+d.Parent), d.Parent, d.User)
+
+	case ActionUpdate:
+		if d.Package != "" {
+			if _, err := p.provisioner.SetPackage(ctx, d.Domain, d.Package); err != nil {
+				return err
+			}
+		}
+		if err := p.setOverrides(d); err != nil {
+			return err
+		}
+		drifts, err := p.provisioner.CheckDrift(ctx, d.Domain, true)
+		if err != nil {
+			return err
+		}
+		var failed []string
+		for _, drift := range drifts {
+			if !drift.Fixed {
+				failed = append(failed, drift.Field)
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to update %s", strings.Join(failed, ", "))
+		}
+		return nil
+
+	case ActionDelete:
+		var err error
+		if action.Parent == "" {
+			err = p.provisioner.Deprovision(action.Domain)
+		} else {
+			err = p.provisioner.RemoveSubdomain(strings.TrimSuffix(action.Domain, "."+action.Parent), action.Parent)
+		}
+		if err != nil {
+			return err
+		}
+		return p.overrides.Delete(action.Domain)
+	}
+	return fmt.Errorf("unknown action %q", action.Type)
+}
+
+// setOverrides replaces the stored override of a domain with the listed
+// one, removing it when none is listed
+func (p *Planner) setOverrides(d DomainSpec) error {
+	current, ok := p.overrides.Get(d.Domain)
+	if len(overrideChanges(current, d.Overrides)) == 0 {
+		return nil
+	}
+	if len(overrideFields(d.Overrides)) == 0 {
+		if !ok {
+			return nil
+		}
+		return p.overrides.Delete(d.Domain)
+	}
+	return p.overrides.Update(d.Domain, func(o *overrides.Override) {
+		*o = d.Overrides
+	})
+}
+
+// overrideChanges lists the override fields that differ between two
+// overrides, as overrides.<field>
+func overrideChanges(before, after overrides.Override) []Change {
+	b, a := overrideFields(before), overrideFields(after)
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		if b[name] != a[name] {
+			changes = append(changes, Change{Field: "overrides." + name, Before: b[name], After: a[name]})
+		}
+	}
+	return changes
+}
+
+// overrideFields returns the set fields of an override by their names in
+// the overrides file, formatted for display
+func overrideFields(o overrides.Override) map[string]string {
+	data, _ := json.Marshal(o)
+	var raw map[string]any
+	_ = json.Unmarshal(data, &raw)
+	delete(raw, "updated_at")
+
+	fields := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			fields[name] = strings.Join(items, ", ")
+		default:
+			fields[name] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
+
+// sortActions orders actions by parent domain, the parent first unless
+// reverse is set, so subdomains follow (or precede) their parent
+func sortActions(actions []Action, reverse bool) {
+	key := func(a Action) (string, int) {
+		if a.Parent == "" {
+			return a.Domain, 0
+		}
+		return a.Parent, 1
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		pi, si := key(actions[i])
+		pj, sj := key(actions[j])
+		if pi != pj {
+			return pi < pj
+		}
+		if si != sj {
+			return (si < sj) != reverse
+		}
+		return actions[i].Domain < actions[j].Domain
+	})
+}
@@ -0,0 +1,78 @@
+package gitops
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// ANSI colors of the plan output
+const (
+	colorReset  = "\033[0m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+	colorBold   = "\033[1m"
+)
+
+// actionStyles marks each action type in the text output, as terraform does
+var actionStyles = map[ActionType]struct{ symbol, color string }{
+	ActionCreate: {"+", colorGreen},
+	ActionUpdate: {"~", colorYellow},
+	ActionDelete: {"-", colorRed},
+}
+
+// Print writes the plan as text: the domains it leaves alone, each action
+// with its changes and a summary. color adds ANSI colors for terminals
+func (p *Plan) Print(w io.Writer, color bool) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+
+	for _, domain := range p.Frozen {
+		fmt.Fprintln(w, paint(colorYellow, "! "+i18n.T("apply.frozen", domain)))
+	}
+	if len(p.Unmanaged) > 0 {
+		fmt.Fprintln(w, paint(colorYellow, "! "+i18n.T("apply.unmanaged", len(p.Unmanaged))))
+	}
+
+	if p.Empty() {
+		fmt.Fprintln(w, i18n.T("apply.no_changes"))
+		return
+	}
+
+	fmt.Fprintln(w, i18n.T("apply.title"))
+	for _, action := range p.Actions {
+		style := actionStyles[action.Type]
+		line := paint(style.color, style.symbol) + " " + paint(colorBold, action.Domain)
+		if action.Parent != "" {
+			line += " (" + i18n.T("apply.subdomain_of", action.Parent) + ")"
+		}
+		fmt.Fprintln(w, "  "+line)
+		for _, c := range action.Changes {
+			fmt.Fprintf(w, "      %-30s %s\n", c.Field, changeValue(c, paint))
+		}
+		if action.Error != "" {
+			fmt.Fprintln(w, "      "+paint(colorRed, i18n.T("apply.action_failed", action.Error)))
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, i18n.T("apply.summary", p.Count(ActionCreate), p.Count(ActionUpdate), p.Count(ActionDelete)))
+}
+
+// changeValue formats a change as "before -> after", or only the new
+// value of a setting the domain does not have yet
+func changeValue(c Change, paint func(c, s string) string) string {
+	after := c.After
+	if after == "" {
+		after = i18n.T("common.none")
+	}
+	if c.Before == "" {
+		return paint(colorGreen, after)
+	}
+	return paint(colorRed, c.Before) + " -> " + paint(colorGreen, after)
+}
@@ -0,0 +1,125 @@
+// Package gitops converges the provisioned domains on a declarative
+// domains.yaml kept under version control. A Planner compares the file with
+// the provisioning states, the per-domain overrides and the pull zones on
+// Bunny, and Apply provisions, updates and deprovisions domains until they
+// match
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/validator"
+)
+
+// Spec is the desired set of domains read from a domains.yaml file
+type Spec struct {
+	// Source is the file the spec was loaded from
+	Source string `yaml:"-"`
+	// Prune deprovisions the domains that are not listed; without it they
+	// are left alone
+	Prune   bool         `yaml:"prune"`
+	Domains []DomainSpec `yaml:"domains"`
+}
+
+// DomainSpec is one desired domain or subdomain
+type DomainSpec struct {
+	Domain string `yaml:"domain"`
+	// Parent is set for subdomains; the parent must be listed too
+	Parent string `yaml:"parent,omitempty"`
+	// User is the WHM user recorded when the domain is provisioned
+	User string `yaml:"user,omitempty"`
+	// Package is the WHM package selecting the domain's CDN profile
+	Package   string             `yaml:"package,omitempty"`
+	Overrides overrides.Override `yaml:"overrides,omitempty"`
+}
+
+// Load reads and validates a domains.yaml file
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	spec, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	spec.Source = path
+	return spec, nil
+}
+
+// Parse decodes and validates a domains.yaml document. Unknown fields are
+// rejected so a typo does not silently drop a setting
+func Parse(data []byte) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid domains file: %w", err)
+	}
+
+	for i := range spec.Domains {
+		d := &spec.Domains[i]
+		d.Domain = domainname.Normalize(d.Domain)
+		d.Parent = domainname.Normalize(d.Parent)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// validate checks the domains, their parents and their overrides
+func (s *Spec) validate() error {
+	v := validator.NewValidatorWithConfig(&validator.ValidatorConfig{}, nil)
+
+	listed := make(map[string]DomainSpec, len(s.Domains))
+	for i, d := range s.Domains {
+		if err := v.ValidateDomain(d.Domain); err != nil {
+			return fmt.Errorf("domains[%d]: %w", i, err)
+		}
+		if _, ok := listed[d.Domain]; ok {
+			return fmt.Errorf("domains[%d]: %s is listed twice", i, d.Domain)
+		}
+		listed[d.Domain] = d
+		if err := d.Overrides.Validate(); err != nil {
+			return fmt.Errorf("%s: overrides.%w", d.Domain, err)
+		}
+	}
+
+	for _, d := range s.Domains {
+		if d.Parent == "" {
+			continue
+		}
+		parent, ok := listed[d.Parent]
+		if !ok {
+			return fmt.Errorf("%s: parent %s is not listed", d.Domain, d.Parent)
+		}
+		if parent.Parent != "" {
+			return fmt.Errorf("%s: parent %s is itself a subdomain", d.Domain, d.Parent)
+		}
+		if label, ok := strings.CutSuffix(d.Domain, "."+d.Parent); !ok || label == "" {
+			return fmt.Errorf("%s is not a subdomain of %s", d.Domain, d.Parent)
+		}
+	}
+	return nil
+}
+
+// Get returns the desired domain named domain
+func (s *Spec) Get(domain string) (DomainSpec, bool) {
+	for _, d := range s.Domains {
+		if d.Domain == domain {
+			return d, true
+		}
+	}
+	return DomainSpec{}, false
+}
@@ -0,0 +1,136 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+const (
+	// commandWaitDelay bounds how long a timed-out command's output is read
+	commandWaitDelay = time.Second
+	// maxOutput is how much of a command's output is kept for the audit log
+	maxOutput = 4096
+	// defaultPath is the PATH of commands when whm2bunny has none
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// execResult is the outcome of a command or script
+type execResult struct {
+	exitCode int
+	output   string
+}
+
+// runExec runs a hook's command or script in a sandbox: a clean environment
+// holding only PATH and the event, an optional user and working directory,
+// and its own process group so a timeout kills everything it started. The
+// event is also passed as JSON on standard input
+func runExec(ctx context.Context, hook config.HookConfig, data Data, payload []byte) (execResult, error) {
+	var cmd *exec.Cmd
+	if hook.Script != "" {
+		cmd = exec.CommandContext(ctx, hook.Script, hook.Args...)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	}
+	cmd.Dir = hook.Dir
+	cmd.Stdin = bytes.NewReader(payload)
+
+	path := os.Getenv("PATH")
+	if path == "" {
+		path = defaultPath
+	}
+	cmd.Env = append([]string{"PATH=" + path}, data.env()...)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if hook.RunAs != "" {
+		credential, home, err := lookupUser(hook.RunAs)
+		if err != nil {
+			return execResult{exitCode: -1}, err
+		}
+		cmd.SysProcAttr.Credential = credential
+		cmd.Env = append(cmd.Env, "HOME="+home, "USER="+hook.RunAs)
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Children can outlive the process group kill and hold the output pipe open
+	cmd.WaitDelay = commandWaitDelay
+
+	output := &cappedBuffer{limit: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	result := execResult{exitCode: -1, output: output.String()}
+	if cmd.ProcessState != nil {
+		result.exitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("timed out: %w", ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(result.output) > 0 {
+			return result, fmt.Errorf("exited with status %d: %s", result.exitCode, bytes.TrimSpace([]byte(result.output)))
+		}
+		return result, fmt.Errorf("failed to run: %w", err)
+	}
+	return result, nil
+}
+
+// lookupUser returns the credential and home directory of a system user
+func lookupUser(name string) (*syscall.Credential, string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown run_as user: %w", err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid uid for %s: %w", name, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid gid for %s: %w", name, err)
+	}
+	// Only root can drop the supplementary groups
+	return &syscall.Credential{
+		Uid:         uint32(uid),
+		Gid:         uint32(gid),
+		NoSetGroups: os.Geteuid() != 0,
+	}, u.HomeDir, nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty command cannot fill memory or the audit log
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,250 @@
+// Package hooks runs operator-configured actions around provisioning, such
+// as regenerating the web server configuration on the origin once a domain
+// is on the CDN
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+)
+
+// Event is a point in provisioning where hooks run
+type Event string
+
+const (
+	// PreProvision runs before a domain's provisioning steps; by default a
+	// failing hook fails the provision
+	PreProvision Event = "pre_provision"
+	// PostPullZoneCreate runs once a domain's pull zone exists
+	PostPullZoneCreate Event = "post_pull_zone_create"
+	// PostProvision runs after a domain is provisioned successfully
+	PostProvision Event = "post_provision"
+	// PostDeprovision runs after a domain's resources are removed
+	PostDeprovision Event = "post_deprovision"
+)
+
+// Data describes the domain a hook runs for
+type Data struct {
+	Event        Event  `json:"event"`
+	Domain       string `json:"domain"`
+	ParentDomain string `json:"parent_domain,omitempty"`
+	User         string `json:"user,omitempty"`
+	ZoneID       int64  `json:"zone_id,omitempty"`
+	PullZoneID   int64  `json:"pull_zone_id,omitempty"`
+	CDNHostname  string `json:"cdn_hostname,omitempty"`
+}
+
+// env returns the data as WHM2BUNNY_* environment variables
+func (d Data) env() []string {
+	return []string{
+		"WHM2BUNNY_EVENT=" + string(d.Event),
+		"WHM2BUNNY_DOMAIN=" + d.Domain,
+		"WHM2BUNNY_PARENT_DOMAIN=" + d.ParentDomain,
+		"WHM2BUNNY_USER=" + d.User,
+		"WHM2BUNNY_ZONE_ID=" + strconv.FormatInt(d.ZoneID, 10),
+		"WHM2BUNNY_PULL_ZONE_ID=" + strconv.FormatInt(d.PullZoneID, 10),
+		"WHM2BUNNY_CDN_HOSTNAME=" + d.CDNHostname,
+	}
+}
+
+// Auditor records hook runs
+type Auditor interface {
+	Record(entry audit.Entry) error
+}
+
+// Runner runs the hooks configured for each event
+type Runner struct {
+	hooks  []config.HookConfig
+	client *http.Client
+	fileMu sync.Mutex
+	audit  Auditor
+	logger *zap.Logger
+}
+
+// NewRunner creates a runner for the configured hooks
+func NewRunner(hooks []config.HookConfig, logger *zap.Logger) *Runner {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Runner{
+		hooks:  hooks,
+		client: &http.Client{},
+		logger: logger,
+	}
+}
+
+// SetAudit attaches an audit log recording every hook run with the output
+// of commands and scripts
+func (r *Runner) SetAudit(a Auditor) {
+	r.audit = a
+}
+
+// Run runs the hooks for data.Event in configuration order. Every hook runs
+// even when an earlier one fails. Failures are handled by each hook's
+// failure policy; those of hooks with the fail policy are returned together
+func (r *Runner) Run(ctx context.Context, data Data) error {
+	var errs []error
+	for i, hook := range r.hooks {
+		if Event(hook.Event) != data.Event {
+			continue
+		}
+
+		start := time.Now()
+		result, err := r.run(ctx, hook, data)
+		r.record(i, hook, data, result, time.Since(start), err)
+
+		if err == nil {
+			r.logger.Debug("hook completed",
+				zap.String("event", hook.Event),
+				zap.Int("hook", i),
+				zap.String("domain", data.Domain),
+			)
+			continue
+		}
+
+		fields := []zap.Field{
+			zap.String("event", hook.Event),
+			zap.Int("hook", i),
+			zap.String("domain", data.Domain),
+			zap.Error(err),
+		}
+		switch hook.FailurePolicy() {
+		case config.HookIgnore:
+			r.logger.Debug("hook failed, ignoring", fields...)
+		case config.HookFail:
+			r.logger.Error("hook failed", fields...)
+			errs = append(errs, fmt.Errorf("hooks[%d]: %w", i, err))
+		default:
+			r.logger.Warn("hook failed", fields...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run runs one hook within its timeout
+func (r *Runner) run(ctx context.Context, hook config.HookConfig, data Data) (execResult, error) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return execResult{}, fmt.Errorf("failed to marshal hook data: %w", err)
+	}
+
+	switch {
+	case hook.Command != "", hook.Script != "":
+		return runExec(ctx, hook, data, payload)
+	case hook.URL != "":
+		return execResult{}, r.post(ctx, hook.URL, payload)
+	case hook.File != "":
+		return execResult{}, r.appendFile(hook.File, payload)
+	default:
+		return execResult{}, fmt.Errorf("no action configured")
+	}
+}
+
+// record writes a hook run to the audit log
+func (r *Runner) record(i int, hook config.HookConfig, data Data, result execResult, took time.Duration, runErr error) {
+	if r.audit == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"event":       hook.Event,
+		"hook":        i,
+		"action":      action(hook),
+		"duration_ms": took.Milliseconds(),
+		"on_failure":  hook.FailurePolicy(),
+	}
+	if hook.Command != "" || hook.Script != "" {
+		details["exit_code"] = result.exitCode
+		details["output"] = result.output
+	}
+	if runErr != nil {
+		details["error"] = runErr.Error()
+	}
+
+	if err := r.audit.Record(audit.Entry{
+		Actor:   "hook",
+		Action:  "hook.run",
+		Domain:  data.Domain,
+		Details: details,
+	}); err != nil {
+		r.logger.Warn("failed to audit hook run", zap.String("domain", data.Domain), zap.Error(err))
+	}
+}
+
+// action describes what a hook does
+func action(hook config.HookConfig) string {
+	switch {
+	case hook.Command != "":
+		return "command: " + hook.Command
+	case hook.Script != "":
+		return strings.Join(append([]string{"script:", hook.Script}, hook.Args...), " ")
+	case hook.URL != "":
+		return "url: " + hook.URL
+	default:
+		return "file: " + hook.File
+	}
+}
+
+// post sends the data to a URL as JSON
+func (r *Runner) post(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whm2bunny-hook/1.0")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// appendFile appends the data to a file as a JSON line
+func (r *Runner) appendFile(path string, payload []byte) error {
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+)
+
+var testData = Data{
+	Event:       PostProvision,
+	Domain:      "example.com",
+	User:        "exampleu",
+	ZoneID:      123,
+	PullZoneID:  456,
+	CDNHostname: "morden-example-com.b-cdn.net",
+}
+
+func TestRunner_Command(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	runner := NewRunner([]config.HookConfig{
+		{Event: "post_provision", Command: `echo "$WHM2BUNNY_DOMAIN $WHM2BUNNY_PULL_ZONE_ID" > ` + out + ` && cat >> ` + out},
+	}, nil)
+
+	require.NoError(t, runner.Run(context.Background(), testData))
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	lines := strings.SplitN(string(data), "\n", 2)
+	assert.Equal(t, "example.com 456", lines[0])
+
+	var got Data
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &got))
+	assert.Equal(t, testData, got)
+}
+
+func TestRunner_CommandFailure(t *testing.T) {
+	runner := NewRunner([]config.HookConfig{
+		{Event: "pre_provision", Command: "echo nginx is down >&2; exit 3"},
+	}, nil)
+
+	err := runner.Run(context.Background(), Data{Event: PreProvision, Domain: "example.com"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nginx is down")
+}
+
+func TestRunner_CommandTimeout(t *testing.T) {
+	runner := NewRunner([]config.HookConfig{
+		{Event: "post_provision", Command: "sleep 5 & sleep 5", Timeout: 50 * time.Millisecond, OnFailure: config.HookFail},
+	}, nil)
+
+	start := time.Now()
+	assert.Error(t, runner.Run(context.Background(), testData))
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestRunner_URL(t *testing.T) {
+	var got Data
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Domain == "broken.com" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	runner := NewRunner([]config.HookConfig{{Event: "post_provision", URL: server.URL, OnFailure: config.HookFail}}, nil)
+
+	require.NoError(t, runner.Run(context.Background(), testData))
+	assert.Equal(t, testData, got)
+
+	broken := testData
+	broken.Domain = "broken.com"
+	assert.Error(t, runner.Run(context.Background(), broken))
+}
+
+func TestRunner_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hooks", "events.jsonl")
+	runner := NewRunner([]config.HookConfig{{Event: "post_provision", File: path}}, nil)
+
+	require.NoError(t, runner.Run(context.Background(), testData))
+	require.NoError(t, runner.Run(context.Background(), testData))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var got Data
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &got))
+	assert.Equal(t, testData, got)
+}
+
+func TestRunner_OnlyMatchingEvent(t *testing.T) {
+	dir := t.TempDir()
+	pre := filepath.Join(dir, "pre.jsonl")
+	failing := filepath.Join(dir, "missing", "dir")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "missing"), nil, 0644))
+
+	runner := NewRunner([]config.HookConfig{
+		{Event: "pre_provision", File: pre},
+		{Event: "post_deprovision", File: failing},
+	}, nil)
+
+	require.NoError(t, runner.Run(context.Background(), testData))
+	_, err := os.Stat(pre)
+	assert.True(t, os.IsNotExist(err), "pre_provision hook should not run on post_provision")
+
+	t.Run("every hook runs after a failure", func(t *testing.T) {
+		ok := filepath.Join(dir, "ok.jsonl")
+		runner := NewRunner([]config.HookConfig{
+			{Event: "pre_provision", File: failing},
+			{Event: "pre_provision", File: ok},
+		}, nil)
+
+		err := runner.Run(context.Background(), Data{Event: PreProvision, Domain: "example.com"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "hooks[0]")
+		_, statErr := os.Stat(ok)
+		assert.NoError(t, statErr)
+	})
+}
+
+func TestRunner_Script(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $WHM2BUNNY_DOMAIN $(pwd)\"\necho \"secret=$BUNNY_API_KEY\"\n"), 0755))
+	t.Setenv("BUNNY_API_KEY", "leaked")
+
+	auditor := &recordingAuditor{}
+	runner := NewRunner([]config.HookConfig{
+		{Event: "post_provision", Script: script, Args: []string{"reload"}, Dir: dir},
+	}, nil)
+	runner.SetAudit(auditor)
+
+	require.NoError(t, runner.Run(context.Background(), testData))
+
+	require.Len(t, auditor.entries, 1)
+	entry := auditor.entries[0]
+	assert.Equal(t, "hook.run", entry.Action)
+	assert.Equal(t, "example.com", entry.Domain)
+	details := entry.Details.(map[string]interface{})
+	assert.Equal(t, 0, details["exit_code"])
+	assert.Equal(t, "reload example.com "+dir+"\nsecret=\n", details["output"])
+	assert.NotContains(t, details, "error")
+}
+
+func TestRunner_FailurePolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{config.HookIgnore, false},
+		{config.HookWarn, false},
+		{config.HookFail, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			auditor := &recordingAuditor{}
+			runner := NewRunner([]config.HookConfig{
+				{Event: "post_pull_zone_create", Command: "echo boom; exit 2", OnFailure: tt.policy},
+			}, nil)
+			runner.SetAudit(auditor)
+
+			err := runner.Run(context.Background(), Data{Event: PostPullZoneCreate, Domain: "example.com"})
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			require.Len(t, auditor.entries, 1)
+			details := auditor.entries[0].Details.(map[string]interface{})
+			assert.Equal(t, 2, details["exit_code"])
+			assert.Equal(t, "boom\n", details["output"])
+			assert.Contains(t, details["error"], "exited with status 2")
+		})
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 8}
+	b.Write([]byte("hello "))
+	b.Write([]byte("world"))
+	assert.Equal(t, "hello wo\n[output truncated]", b.String())
+}
+
+// recordingAuditor keeps audit entries in memory
+type recordingAuditor struct {
+	entries []audit.Entry
+}
+
+func (a *recordingAuditor) Record(entry audit.Entry) error {
+	a.entries = append(a.entries, entry)
+	return nil
+}
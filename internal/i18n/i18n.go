@@ -0,0 +1,127 @@
+// Package i18n translates human readable output. Message catalogs are JSON
+// files embedded from locales/, one per locale, mapping a key to a
+// fmt-style format string. Keys missing from a catalog fall back to English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// DefaultLocale is the locale used when none is configured, and the
+// fallback for keys missing from another catalog
+const DefaultLocale = "en"
+
+// catalogs maps a locale to its messages, loaded once from localeFS
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: failed to read catalogs: %v", err))
+	}
+
+	result := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := localeFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: failed to read %s: %v", entry.Name(), err))
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", entry.Name(), err))
+		}
+		result[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	if _, ok := result[DefaultLocale]; !ok {
+		panic("i18n: missing catalog for " + DefaultLocale)
+	}
+	return result
+}
+
+// Supported returns the available locales, sorted
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// IsSupported reports whether a catalog exists for locale
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Localizer translates message keys for one locale
+type Localizer struct {
+	locale   string
+	messages map[string]string
+}
+
+// New returns a localizer for locale
+func New(locale string) (*Localizer, error) {
+	messages, ok := catalogs[locale]
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale %q (supported: %s)", locale, strings.Join(Supported(), ", "))
+	}
+	return &Localizer{locale: locale, messages: messages}, nil
+}
+
+// Locale returns the localizer's locale
+func (l *Localizer) Locale() string {
+	return l.locale
+}
+
+// T formats the message for key with args. A key missing from the catalog
+// uses the English message; an unknown key is returned as is
+func (l *Localizer) T(key string, args ...any) string {
+	format, ok := l.messages[key]
+	if !ok {
+		format, ok = catalogs[DefaultLocale][key]
+	}
+	if !ok {
+		format = key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+var current atomic.Pointer[Localizer]
+
+func init() {
+	l, _ := New(DefaultLocale)
+	current.Store(l)
+}
+
+// SetLocale sets the locale used by T
+func SetLocale(locale string) error {
+	l, err := New(locale)
+	if err != nil {
+		return err
+	}
+	current.Store(l)
+	return nil
+}
+
+// Locale returns the locale used by T
+func Locale() string {
+	return current.Load().Locale()
+}
+
+// T formats the message for key with args in the current locale
+func T(key string, args ...any) string {
+	return current.Load().T(key, args...)
+}
@@ -0,0 +1,65 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var verbPattern = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+func TestCatalogsMatchEnglish(t *testing.T) {
+	english := catalogs[DefaultLocale]
+	for _, locale := range Supported() {
+		if locale == DefaultLocale {
+			continue
+		}
+		t.Run(locale, func(t *testing.T) {
+			messages := catalogs[locale]
+			for key, format := range english {
+				translated, ok := messages[key]
+				if !assert.True(t, ok, "missing key %s", key) {
+					continue
+				}
+				assert.Equal(t, verbPattern.FindAllString(format, -1), verbPattern.FindAllString(translated, -1),
+					"format verbs of %s differ", key)
+			}
+			for key := range messages {
+				assert.Contains(t, english, key, "key %s is not in the English catalog", key)
+			}
+		})
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	l, err := New("id")
+	require.NoError(t, err)
+	assert.Equal(t, "id", l.Locale())
+
+	t.Run("missing key falls back to English", func(t *testing.T) {
+		l := &Localizer{locale: "id", messages: map[string]string{}}
+		assert.Equal(t, catalogs[DefaultLocale]["common.inherited"], l.T("common.inherited"))
+	})
+
+	t.Run("unknown key is returned as is", func(t *testing.T) {
+		assert.Equal(t, "no.such.key", l.T("no.such.key"))
+	})
+
+	t.Run("unsupported locale", func(t *testing.T) {
+		_, err := New("fr")
+		assert.Error(t, err)
+	})
+}
+
+func TestSetLocale(t *testing.T) {
+	t.Cleanup(func() { _ = SetLocale(DefaultLocale) })
+
+	require.NoError(t, SetLocale("id"))
+	assert.Equal(t, "id", Locale())
+	assert.Equal(t, catalogs["id"]["common.inherited"], T("common.inherited"))
+
+	assert.Error(t, SetLocale("fr"))
+	assert.Equal(t, "id", Locale())
+}
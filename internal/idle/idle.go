@@ -0,0 +1,131 @@
+// Package idle finds provisioned domains whose pull zone served next to no
+// traffic over a window of days: candidates for cleanup, or sites whose DNS
+// does not point at the CDN
+package idle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// Source says where a domain's traffic was read from
+const (
+	SourceSnapshots = "snapshots"
+	SourceBunny     = "bunny"
+)
+
+// Domain is a provisioned domain that served at most the requests allowed
+// over the window
+type Domain struct {
+	Domain        string    `json:"domain"`
+	User          string    `json:"user,omitempty"`
+	PullZoneID    int64     `json:"pull_zone_id"`
+	Requests      int64     `json:"requests"`
+	Bandwidth     int64     `json:"bandwidth"`
+	ProvisionedAt time.Time `json:"provisioned_at"`
+	Source        string    `json:"source"`
+}
+
+// Snapshots reads the bandwidth snapshots of a pull zone
+type Snapshots interface {
+	GetSnapshotsByZone(zoneID int64, since time.Time) []state.BandwidthSnapshot
+}
+
+// StatsFunc reads a pull zone's traffic from Bunny, for domains whose
+// snapshots do not cover the window
+type StatsFunc func(ctx context.Context, pullZoneID int64, from, to time.Time) (*bunny.PullZoneStats, error)
+
+// Window returns the days whole days before today in loc, ending at
+// 23:59:59 yesterday like the periods of the daily summary
+func Window(now time.Time, days int, loc *time.Location) (from, to time.Time) {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	return today.AddDate(0, 0, -days), today.Add(-time.Second)
+}
+
+// Find returns the successfully provisioned domains that served at most
+// maxRequests requests between from and to, fewest first. Domains
+// provisioned after from are too new to judge. Traffic is summed from the
+// daily snapshots when they cover every day of the window, and read with
+// stats otherwise; domains whose stats fail are left out and their errors
+// returned with the domains found
+func Find(ctx context.Context, states []*state.ProvisionState, snapshots Snapshots, stats StatsFunc, from, to time.Time, maxRequests int64) ([]Domain, error) {
+	seen := make(map[int64]bool)
+	var found []Domain
+	var errs []error
+	for _, st := range states {
+		if st.Status != state.StatusSuccess || st.PullZoneID <= 0 || seen[st.PullZoneID] || st.CreatedAt.After(from) {
+			continue
+		}
+		seen[st.PullZoneID] = true
+
+		d := Domain{
+			Domain:        st.Domain,
+			User:          st.User,
+			PullZoneID:    st.PullZoneID,
+			ProvisionedAt: st.CreatedAt,
+			Source:        SourceSnapshots,
+		}
+		var ok bool
+		if snapshots != nil {
+			d.Requests, d.Bandwidth, ok = fromSnapshots(snapshots.GetSnapshotsByZone(st.PullZoneID, from), from, to)
+		}
+		if !ok {
+			if stats == nil {
+				continue
+			}
+			s, err := stats(ctx, st.PullZoneID, from, to)
+			if err != nil {
+				if ctx.Err() != nil {
+					return sortDomains(found), ctx.Err()
+				}
+				errs = append(errs, fmt.Errorf("%s: %w", st.Domain, err))
+				continue
+			}
+			d.Requests, d.Bandwidth, d.Source = s.TotalRequests, s.TotalBandwidth, SourceBunny
+		}
+
+		if d.Requests <= maxRequests {
+			found = append(found, d)
+		}
+	}
+	return sortDomains(found), errors.Join(errs...)
+}
+
+// fromSnapshots sums the daily snapshots of the days from..to; ok is false
+// unless every day has one
+func fromSnapshots(snapshots []state.BandwidthSnapshot, from, to time.Time) (requests, bandwidth int64, ok bool) {
+	days := make(map[int64]state.BandwidthSnapshot)
+	for _, snap := range snapshots {
+		if snap.Daily() {
+			days[snap.From.Unix()] = snap
+		}
+	}
+
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		snap, ok := days[day.Unix()]
+		if !ok {
+			return 0, 0, false
+		}
+		requests += snap.Requests
+		bandwidth += snap.Bandwidth
+	}
+	return requests, bandwidth, true
+}
+
+// sortDomains orders domains by requests, then name
+func sortDomains(domains []Domain) []Domain {
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Requests != domains[j].Requests {
+			return domains[i].Requests < domains[j].Requests
+		}
+		return domains[i].Domain < domains[j].Domain
+	})
+	return domains
+}
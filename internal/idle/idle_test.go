@@ -0,0 +1,91 @@
+package idle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// fakeSnapshots serves snapshots by pull zone
+type fakeSnapshots map[int64][]state.BandwidthSnapshot
+
+func (f fakeSnapshots) GetSnapshotsByZone(zoneID int64, since time.Time) []state.BandwidthSnapshot {
+	return f[zoneID]
+}
+
+// daily returns one daily snapshot per day from..to with the requests given
+func daily(zoneID int64, from, to time.Time, requests int64) []state.BandwidthSnapshot {
+	var snaps []state.BandwidthSnapshot
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		snaps = append(snaps, state.BandwidthSnapshot{
+			Timestamp: day.AddDate(0, 0, 1),
+			From:      day,
+			To:        day.AddDate(0, 0, 1).Add(-time.Second),
+			ZoneID:    zoneID,
+			Requests:  requests,
+			Bandwidth: requests * 1000,
+		})
+	}
+	return snaps
+}
+
+func TestWindow(t *testing.T) {
+	loc := time.FixedZone("WIB", 7*3600)
+	from, to := Window(time.Date(2024, 3, 31, 20, 0, 0, 0, time.UTC), 30, loc)
+
+	// 20:00 UTC is already April 1 in WIB
+	assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, loc), from)
+	assert.Equal(t, time.Date(2024, 3, 31, 23, 59, 59, 0, loc), to)
+}
+
+func TestFind(t *testing.T) {
+	from, to := Window(time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC), 30, time.UTC)
+	old := from.AddDate(0, -1, 0)
+
+	states := []*state.ProvisionState{
+		{Domain: "busy.com", PullZoneID: 1, Status: state.StatusSuccess, CreatedAt: old},
+		{Domain: "quiet.com", User: "quietu", PullZoneID: 2, Status: state.StatusSuccess, CreatedAt: old},
+		{Domain: "dead.com", PullZoneID: 3, Status: state.StatusSuccess, CreatedAt: old},
+		{Domain: "gap.com", PullZoneID: 4, Status: state.StatusSuccess, CreatedAt: old},
+		{Domain: "new.com", PullZoneID: 5, Status: state.StatusSuccess, CreatedAt: from.AddDate(0, 0, 20)},
+		{Domain: "failed.com", PullZoneID: 6, Status: state.StatusFailed, CreatedAt: old},
+		{Domain: "broken.com", PullZoneID: 7, Status: state.StatusSuccess, CreatedAt: old},
+	}
+	snapshots := fakeSnapshots{
+		1: daily(1, from, to, 5000),
+		2: daily(2, from, to, 2),
+		3: daily(3, from, to, 0),
+		// A day is missing, so Bunny's stats are read
+		4: daily(4, from.AddDate(0, 0, 1), to, 0),
+	}
+
+	var fetched []int64
+	stats := func(ctx context.Context, pullZoneID int64, f, t time.Time) (*bunny.PullZoneStats, error) {
+		fetched = append(fetched, pullZoneID)
+		if pullZoneID == 7 {
+			return nil, errors.New("API error")
+		}
+		return &bunny.PullZoneStats{TotalRequests: 10}, nil
+	}
+
+	domains, err := Find(context.Background(), states, snapshots, stats, from, to, 100)
+	require.Error(t, err, "the failed fetch is reported")
+	assert.Contains(t, err.Error(), "broken.com")
+	assert.ElementsMatch(t, []int64{4, 7}, fetched)
+
+	require.Len(t, domains, 3)
+	assert.Equal(t, "dead.com", domains[0].Domain)
+	assert.Equal(t, SourceSnapshots, domains[0].Source)
+	assert.Equal(t, "gap.com", domains[1].Domain)
+	assert.Equal(t, SourceBunny, domains[1].Source)
+	assert.Equal(t, "quiet.com", domains[2].Domain)
+	assert.Equal(t, int64(60), domains[2].Requests)
+	assert.Equal(t, "quietu", domains[2].User)
+}
@@ -0,0 +1,355 @@
+// Package incident tracks the alerts raised for a pull zone as incidents:
+// repeated alerts about the same problem are counted into one incident
+// instead of notified again, operators acknowledge incidents, and an
+// incident resolves itself once the metric that raised it recovers
+package incident
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Kind is the metric an incident was raised for
+type Kind string
+
+const (
+	// KindBandwidth is a bandwidth spike against the day before
+	KindBandwidth Kind = "bandwidth"
+	// KindHitRate is a cache hit rate below the threshold
+	KindHitRate Kind = "hit_rate"
+	// KindOriginHealth is an origin answering too many requests with 5xx
+	KindOriginHealth Kind = "origin_health"
+	// KindTrafficAnomaly is an hour whose requests are far from the same
+	// hour of the days before
+	KindTrafficAnomaly Kind = "traffic_anomaly"
+	// KindBunnyAPI is a family of Bunny API endpoints failing more calls
+	// than its error budget allows; it has no zone, and Zone names the API
+	KindBunnyAPI Kind = "bunny_api"
+)
+
+// Status is the state of an incident
+type Status string
+
+const (
+	// StatusOpen is an incident nobody acknowledged yet
+	StatusOpen Status = "open"
+	// StatusAcknowledged is an incident an operator is looking at
+	StatusAcknowledged Status = "acknowledged"
+	// StatusResolved is an incident whose metric recovered
+	StatusResolved Status = "resolved"
+)
+
+// Retention is how long resolved incidents are kept
+const Retention = 30 * 24 * time.Hour
+
+var (
+	// ErrNotFound is returned for an unknown incident ID
+	ErrNotFound = errors.New("incident not found")
+	// ErrResolved is returned when acknowledging a resolved incident
+	ErrResolved = errors.New("incident is already resolved")
+)
+
+// Incident is one problem of a pull zone, from the first alert until the
+// metric recovered
+type Incident struct {
+	// ID is a short sequential identifier, such as INC-12
+	ID     string `json:"id"`
+	Kind   Kind   `json:"kind"`
+	Zone   string `json:"zone"`
+	ZoneID int64  `json:"zone_id"`
+	Status Status `json:"status"`
+
+	// Value is the metric at the last alert, and Threshold the value it
+	// crossed; both in percent. For traffic anomalies Value is the change
+	// against the usual and Threshold the change that counts as anomalous
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	// Alerts counts the alerts raised, the first one included
+	Alerts int `json:"alerts"`
+
+	OpenedAt    time.Time  `json:"opened_at"`
+	LastAlertAt time.Time  `json:"last_alert_at"`
+	AckedAt     *time.Time `json:"acked_at,omitempty"`
+	AckedBy     string     `json:"acked_by,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Active reports whether the incident has not been resolved
+func (i Incident) Active() bool {
+	return i.Status != StatusResolved
+}
+
+// incidentFile is the persisted form of a store
+type incidentFile struct {
+	// Next is the number of the next incident ID
+	Next      int         `json:"next"`
+	Incidents []*Incident `json:"incidents"`
+}
+
+// Store persists incidents
+type Store struct {
+	file   *filestore.File[incidentFile]
+	data   incidentFile
+	mu     sync.Mutex
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewStore creates an incident store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "incident", 0644, func() incidentFile {
+		return incidentFile{Next: 1}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.data); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// save drops the incidents resolved longer than Retention ago and writes
+// the incident file atomically
+// Caller must hold s.mu
+func (s *Store) save() error {
+	cutoff := s.now().Add(-Retention)
+	kept := s.data.Incidents[:0]
+	for _, inc := range s.data.Incidents {
+		if inc.ResolvedAt != nil && inc.ResolvedAt.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, inc)
+	}
+	s.data.Incidents = kept
+
+	return s.file.Save(s.data)
+}
+
+// active returns the unresolved incident of kind for a zone; incidents
+// without a zone ID are told apart by zone
+// Caller must hold s.mu
+func (s *Store) active(kind Kind, zoneID int64, zone string) *Incident {
+	for _, inc := range s.data.Incidents {
+		if inc.Kind == kind && inc.ZoneID == zoneID && (zoneID != 0 || inc.Zone == zone) && inc.Active() {
+			return inc
+		}
+	}
+	return nil
+}
+
+// Fire records an alert of kind for a zone. It opens an incident unless
+// one is already active for the zone and kind, which the alert is counted
+// into instead. It returns the incident and whether it was opened
+func (s *Store) Fire(kind Kind, zoneID int64, zone string, value, threshold float64) (Incident, bool, error) {
+	return s.fire(kind, zoneID, zone, value, threshold)
+}
+
+// FireAPI records an alert that the Bunny API named api, such as
+// "Bunny DNS API", is degraded: its availability is value percent against
+// the objective threshold. It returns the incident and whether it was opened
+func (s *Store) FireAPI(api string, value, threshold float64) (Incident, bool, error) {
+	return s.fire(KindBunnyAPI, 0, api, value, threshold)
+}
+
+// fire implements Fire and FireAPI
+func (s *Store) fire(kind Kind, zoneID int64, zone string, value, threshold float64) (Incident, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.data); err != nil {
+		return Incident{}, false, err
+	}
+
+	now := s.now()
+	if inc := s.active(kind, zoneID, zone); inc != nil {
+		prev := *inc
+		inc.Value = value
+		inc.Alerts++
+		inc.LastAlertAt = now
+		if err := s.save(); err != nil {
+			*inc = prev
+			return Incident{}, false, err
+		}
+		return *inc, false, nil
+	}
+
+	inc := &Incident{
+		ID:          "INC-" + strconv.Itoa(s.data.Next),
+		Kind:        kind,
+		Zone:        zone,
+		ZoneID:      zoneID,
+		Status:      StatusOpen,
+		Value:       value,
+		Threshold:   threshold,
+		Alerts:      1,
+		OpenedAt:    now,
+		LastAlertAt: now,
+	}
+	s.data.Next++
+	s.data.Incidents = append(s.data.Incidents, inc)
+	if err := s.save(); err != nil {
+		s.data.Next--
+		s.data.Incidents = s.data.Incidents[:len(s.data.Incidents)-1]
+		return Incident{}, false, err
+	}
+	return *inc, true, nil
+}
+
+// Resolve resolves the active incident of kind for a zone, as its metric
+// recovered. It returns the incident and whether there was one
+func (s *Store) Resolve(kind Kind, zoneID int64) (Incident, bool, error) {
+	return s.resolve(kind, zoneID, "")
+}
+
+// ResolveAPI resolves the active incident of the Bunny API named api, as
+// its availability recovered. It returns the incident and whether there
+// was one
+func (s *Store) ResolveAPI(api string) (Incident, bool, error) {
+	return s.resolve(KindBunnyAPI, 0, api)
+}
+
+// resolve implements Resolve and ResolveAPI
+func (s *Store) resolve(kind Kind, zoneID int64, zone string) (Incident, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.data); err != nil {
+		return Incident{}, false, err
+	}
+
+	inc := s.active(kind, zoneID, zone)
+	if inc == nil {
+		return Incident{}, false, nil
+	}
+
+	prev := *inc
+	now := s.now()
+	inc.Status = StatusResolved
+	inc.ResolvedAt = &now
+	if err := s.save(); err != nil {
+		*inc = prev
+		return Incident{}, false, err
+	}
+	return *inc, true, nil
+}
+
+// Ack acknowledges an incident on behalf of by. Acknowledging it again
+// keeps the first acknowledgement
+func (s *Store) Ack(id, by string) (Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.data); err != nil {
+		return Incident{}, err
+	}
+
+	inc := s.find(id)
+	if inc == nil {
+		return Incident{}, fmt.Errorf("%s: %w", id, ErrNotFound)
+	}
+	switch inc.Status {
+	case StatusResolved:
+		return *inc, fmt.Errorf("%s: %w", inc.ID, ErrResolved)
+	case StatusAcknowledged:
+		return *inc, nil
+	}
+
+	prev := *inc
+	now := s.now()
+	inc.Status = StatusAcknowledged
+	inc.AckedAt = &now
+	inc.AckedBy = by
+	if err := s.save(); err != nil {
+		*inc = prev
+		return Incident{}, err
+	}
+	return *inc, nil
+}
+
+// find returns the incident with id; IDs match without regard to case and
+// may be given without the INC- prefix
+// Caller must hold s.mu
+func (s *Store) find(id string) *Incident {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	if !strings.HasPrefix(id, "INC-") {
+		id = "INC-" + id
+	}
+	for _, inc := range s.data.Incidents {
+		if inc.ID == id {
+			return inc
+		}
+	}
+	return nil
+}
+
+// Get returns the incident with id
+func (s *Store) Get(id string) (Incident, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.data); err != nil {
+		s.logger.Warn("Failed to reload incidents, using cached copy", zap.Error(err))
+	}
+
+	inc := s.find(id)
+	if inc == nil {
+		return Incident{}, false
+	}
+	return *inc, true
+}
+
+// Active returns the incidents not resolved yet, oldest first
+func (s *Store) Active() []Incident {
+	return s.list(true)
+}
+
+// List returns every incident kept, the resolved ones included, oldest
+// first
+func (s *Store) List() []Incident {
+	return s.list(false)
+}
+
+func (s *Store) list(activeOnly bool) []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.data); err != nil {
+		s.logger.Warn("Failed to reload incidents, using cached copy", zap.Error(err))
+	}
+
+	list := make([]Incident, 0, len(s.data.Incidents))
+	for _, inc := range s.data.Incidents {
+		if activeOnly && !inc.Active() {
+			continue
+		}
+		list = append(list, *inc)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].OpenedAt.Before(list[j].OpenedAt)
+	})
+	return list
+}
@@ -0,0 +1,144 @@
+package incident
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_FireDedupesAndResolves(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "incidents.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	inc, opened, err := s.Fire(KindBandwidth, 42, "morden-example-com", 120, 50)
+	require.NoError(t, err)
+	assert.True(t, opened)
+	assert.Equal(t, "INC-1", inc.ID)
+	assert.Equal(t, StatusOpen, inc.Status)
+
+	// Further alerts are counted into the active incident
+	inc, opened, err = s.Fire(KindBandwidth, 42, "morden-example-com", 150, 50)
+	require.NoError(t, err)
+	assert.False(t, opened)
+	assert.Equal(t, "INC-1", inc.ID)
+	assert.Equal(t, 2, inc.Alerts)
+	assert.Equal(t, 150.0, inc.Value)
+
+	// Other kinds and zones get their own incident
+	other, opened, err := s.Fire(KindHitRate, 42, "morden-example-com", 30, 50)
+	require.NoError(t, err)
+	assert.True(t, opened)
+	assert.Equal(t, "INC-2", other.ID)
+
+	inc, resolved, err := s.Resolve(KindBandwidth, 42)
+	require.NoError(t, err)
+	assert.True(t, resolved)
+	assert.Equal(t, StatusResolved, inc.Status)
+	require.NotNil(t, inc.ResolvedAt)
+
+	_, resolved, err = s.Resolve(KindBandwidth, 42)
+	require.NoError(t, err)
+	assert.False(t, resolved, "nothing left to resolve")
+
+	assert.Len(t, s.Active(), 1)
+	assert.Len(t, s.List(), 2)
+
+	// A new spike after recovery is a new incident
+	inc, opened, err = s.Fire(KindBandwidth, 42, "morden-example-com", 90, 50)
+	require.NoError(t, err)
+	assert.True(t, opened)
+	assert.Equal(t, "INC-3", inc.ID)
+}
+
+func TestStore_FireAPI(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "incidents.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	dns, opened, err := s.FireAPI("Bunny DNS API", 80, 95)
+	require.NoError(t, err)
+	assert.True(t, opened)
+	assert.Equal(t, KindBunnyAPI, dns.Kind)
+	assert.Equal(t, int64(0), dns.ZoneID)
+
+	// APIs have no zone ID, so they are told apart by name
+	_, opened, err = s.FireAPI("Bunny DNS API", 70, 95)
+	require.NoError(t, err)
+	assert.False(t, opened)
+	stats, opened, err := s.FireAPI("Bunny Statistics API", 60, 95)
+	require.NoError(t, err)
+	assert.True(t, opened)
+
+	inc, resolved, err := s.ResolveAPI("Bunny DNS API")
+	require.NoError(t, err)
+	assert.True(t, resolved)
+	assert.Equal(t, dns.ID, inc.ID)
+	assert.Equal(t, 2, inc.Alerts)
+
+	active := s.Active()
+	require.Len(t, active, 1)
+	assert.Equal(t, stats.ID, active[0].ID)
+}
+
+func TestStore_Ack(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "incidents.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = s.Ack("INC-9", "alice")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, _, err = s.Fire(KindOriginHealth, 7, "morden-shop-com", 12, 5)
+	require.NoError(t, err)
+
+	inc, err := s.Ack("inc-1", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, StatusAcknowledged, inc.Status)
+	assert.Equal(t, "alice", inc.AckedBy)
+
+	// The first acknowledgement is kept
+	inc, err = s.Ack("1", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", inc.AckedBy)
+
+	// Acknowledged incidents still collect alerts and resolve
+	inc, opened, err := s.Fire(KindOriginHealth, 7, "morden-shop-com", 15, 5)
+	require.NoError(t, err)
+	assert.False(t, opened)
+	assert.Equal(t, StatusAcknowledged, inc.Status)
+
+	_, _, err = s.Resolve(KindOriginHealth, 7)
+	require.NoError(t, err)
+	_, err = s.Ack("INC-1", "alice")
+	assert.ErrorIs(t, err, ErrResolved)
+}
+
+func TestStore_SharedFileAndRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	_, _, err = server.Fire(KindBandwidth, 42, "morden-example-com", 120, 50)
+	require.NoError(t, err)
+
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	_, err = cli.Ack("INC-1", "cli")
+	require.NoError(t, err)
+
+	inc, ok := server.Get("INC-1")
+	require.True(t, ok)
+	assert.Equal(t, StatusAcknowledged, inc.Status)
+
+	_, _, err = server.Resolve(KindBandwidth, 42)
+	require.NoError(t, err)
+
+	// Resolved incidents are dropped once they are older than Retention
+	server.now = func() time.Time { return time.Now().Add(Retention + time.Hour) }
+	_, _, err = server.Fire(KindHitRate, 42, "morden-example-com", 30, 50)
+	require.NoError(t, err)
+	list := server.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, "INC-2", list[0].ID, "IDs are not reused")
+}
@@ -0,0 +1,290 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// DefaultPollInterval is how often Watch re-evaluates maintenance status
+const DefaultPollInterval = 30 * time.Second
+
+// Window is a parsed recurring maintenance window
+type Window struct {
+	Days   map[time.Weekday]bool
+	Start  time.Duration // offset from local midnight
+	End    time.Duration // offset from local midnight
+	Reason string
+}
+
+// Override is a manual maintenance toggle persisted to disk so that the CLI
+// and the running daemon share it
+type Override struct {
+	Enabled   bool      `json:"enabled"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Status describes whether maintenance is currently in effect and why
+type Status struct {
+	Active bool       `json:"active"`
+	Manual bool       `json:"manual"`
+	Reason string     `json:"reason,omitempty"`
+	Since  time.Time  `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// Manager evaluates configured windows and the manual override
+type Manager struct {
+	file     *filestore.File[Override]
+	windows  []Window
+	loc      *time.Location
+	override Override
+	mu       sync.Mutex
+	logger   *zap.Logger
+	now      func() time.Time
+}
+
+// NewManager creates a maintenance manager backed by the given override file
+func NewManager(filePath string, cfg config.MaintenanceConfig, logger *zap.Logger) (*Manager, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	tz := cfg.Timezone
+	if tz == "" {
+		tz = "Asia/Jakarta"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance timezone %q: %w", tz, err)
+	}
+
+	windows, err := ParseWindows(cfg.Windows)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := filestore.New(filePath, "maintenance", 0644, func() Override { return Override{} })
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		file:    file,
+		windows: windows,
+		loc:     loc,
+		logger:  logger,
+		now:     time.Now,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.file.Reload(&m.override); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ParseWindows converts window configuration into Windows
+func ParseWindows(cfgs []config.MaintenanceWindowConfig) ([]Window, error) {
+	windows := make([]Window, 0, len(cfgs))
+	for i, wc := range cfgs {
+		start, err := parseClock(wc.Start)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: invalid start: %w", i, err)
+		}
+		end, err := parseClock(wc.End)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: invalid end: %w", i, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("maintenance window %d: start and end must differ", i)
+		}
+
+		days := make(map[time.Weekday]bool)
+		for _, d := range wc.Days {
+			day, err := parseWeekday(d)
+			if err != nil {
+				return nil, fmt.Errorf("maintenance window %d: %w", i, err)
+			}
+			days[day] = true
+		}
+		// No days means every day
+		if len(days) == 0 {
+			for d := time.Sunday; d <= time.Saturday; d++ {
+				days[d] = true
+			}
+		}
+
+		windows = append(windows, Window{
+			Days:   days,
+			Start:  start,
+			End:    end,
+			Reason: wc.Reason,
+		})
+	}
+	return windows, nil
+}
+
+// parseClock parses "HH:MM" into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekday parses a weekday name or three-letter abbreviation
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", s)
+}
+
+// Enable turns on manual maintenance mode with the given reason
+func (m *Manager) Enable(reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.override = Override{
+		Enabled:   true,
+		Reason:    reason,
+		ChangedAt: m.now(),
+	}
+	if err := m.file.Save(m.override); err != nil {
+		return err
+	}
+
+	m.logger.Info("Maintenance mode enabled", zap.String("reason", reason))
+	return nil
+}
+
+// Disable turns off manual maintenance mode
+// Scheduled windows still apply
+func (m *Manager) Disable() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.override = Override{
+		Enabled:   false,
+		ChangedAt: m.now(),
+	}
+	if err := m.file.Save(m.override); err != nil {
+		return err
+	}
+
+	m.logger.Info("Maintenance mode disabled")
+	return nil
+}
+
+// Status returns the current maintenance status
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Reload(&m.override); err != nil {
+		m.logger.Warn("Failed to reload maintenance override", zap.Error(err))
+	}
+
+	if m.override.Enabled {
+		return Status{
+			Active: true,
+			Manual: true,
+			Reason: m.override.Reason,
+			Since:  m.override.ChangedAt,
+		}
+	}
+
+	now := m.now().In(m.loc)
+	for _, w := range m.windows {
+		if start, end, ok := w.activeAt(now); ok {
+			reason := w.Reason
+			if reason == "" {
+				reason = "scheduled maintenance window"
+			}
+			return Status{
+				Active: true,
+				Reason: reason,
+				Since:  start,
+				Until:  &end,
+			}
+		}
+	}
+
+	return Status{}
+}
+
+// Active reports whether provisioning should currently be paused
+func (m *Manager) Active() bool {
+	return m.Status().Active
+}
+
+// activeAt reports whether t falls inside the window and returns the
+// window's start and end times
+func (w Window) activeAt(t time.Time) (time.Time, time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if w.Start < w.End {
+		if w.Days[t.Weekday()] && offset >= w.Start && offset < w.End {
+			return midnight.Add(w.Start), midnight.Add(w.End), true
+		}
+		return time.Time{}, time.Time{}, false
+	}
+
+	// Window wraps past midnight: the part after Start belongs to today,
+	// the part before End belongs to the window that started yesterday
+	if w.Days[t.Weekday()] && offset >= w.Start {
+		return midnight.Add(w.Start), midnight.AddDate(0, 0, 1).Add(w.End), true
+	}
+	yesterday := midnight.AddDate(0, 0, -1)
+	if w.Days[yesterday.Weekday()] && offset < w.End {
+		return yesterday.Add(w.Start), midnight.Add(w.End), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// Watch polls the maintenance status until ctx is cancelled and calls fn
+// whenever maintenance starts or ends
+func (m *Manager) Watch(ctx context.Context, interval time.Duration, fn func(prev, cur Status)) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	prev := m.Status()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := m.Status()
+			if cur.Active != prev.Active {
+				m.logger.Info("Maintenance status changed",
+					zap.Bool("active", cur.Active),
+					zap.Bool("manual", cur.Manual),
+					zap.String("reason", cur.Reason),
+				)
+				fn(prev, cur)
+			}
+			prev = cur
+		}
+	}
+}
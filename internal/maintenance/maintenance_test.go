@@ -0,0 +1,113 @@
+package maintenance
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+func newTestManager(t *testing.T, windows []config.MaintenanceWindowConfig) *Manager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "maintenance.json")
+	m, err := NewManager(path, config.MaintenanceConfig{Timezone: "UTC", Windows: windows}, zap.NewNop())
+	require.NoError(t, err)
+	return m
+}
+
+func TestParseWindows(t *testing.T) {
+	t.Run("valid window", func(t *testing.T) {
+		windows, err := ParseWindows([]config.MaintenanceWindowConfig{
+			{Days: []string{"sun", "Saturday"}, Start: "02:00", End: "04:30"},
+		})
+		require.NoError(t, err)
+		require.Len(t, windows, 1)
+		assert.True(t, windows[0].Days[time.Sunday])
+		assert.True(t, windows[0].Days[time.Saturday])
+		assert.False(t, windows[0].Days[time.Monday])
+		assert.Equal(t, 2*time.Hour, windows[0].Start)
+		assert.Equal(t, 4*time.Hour+30*time.Minute, windows[0].End)
+	})
+
+	t.Run("no days means every day", func(t *testing.T) {
+		windows, err := ParseWindows([]config.MaintenanceWindowConfig{{Start: "01:00", End: "02:00"}})
+		require.NoError(t, err)
+		assert.Len(t, windows[0].Days, 7)
+	})
+
+	t.Run("invalid clock", func(t *testing.T) {
+		_, err := ParseWindows([]config.MaintenanceWindowConfig{{Start: "25:00", End: "02:00"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid day", func(t *testing.T) {
+		_, err := ParseWindows([]config.MaintenanceWindowConfig{{Days: []string{"funday"}, Start: "01:00", End: "02:00"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("empty window", func(t *testing.T) {
+		_, err := ParseWindows([]config.MaintenanceWindowConfig{{Start: "01:00", End: "01:00"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestManager_ScheduledWindow(t *testing.T) {
+	m := newTestManager(t, []config.MaintenanceWindowConfig{
+		{Days: []string{"sun"}, Start: "02:00", End: "04:00", Reason: "weekly patching"},
+	})
+
+	// 2026-10-18 is a Sunday
+	m.now = func() time.Time { return time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC) }
+	status := m.Status()
+	assert.True(t, status.Active)
+	assert.False(t, status.Manual)
+	assert.Equal(t, "weekly patching", status.Reason)
+	require.NotNil(t, status.Until)
+	assert.Equal(t, time.Date(2026, 10, 18, 4, 0, 0, 0, time.UTC), *status.Until)
+
+	m.now = func() time.Time { return time.Date(2026, 10, 18, 4, 0, 0, 0, time.UTC) }
+	assert.False(t, m.Active())
+
+	m.now = func() time.Time { return time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC) }
+	assert.False(t, m.Active(), "window should not apply on Monday")
+}
+
+func TestManager_WindowWrapsMidnight(t *testing.T) {
+	m := newTestManager(t, []config.MaintenanceWindowConfig{
+		{Days: []string{"sat"}, Start: "23:00", End: "01:00"},
+	})
+
+	// Saturday 23:30 and Sunday 00:30 are both inside the window
+	m.now = func() time.Time { return time.Date(2026, 10, 17, 23, 30, 0, 0, time.UTC) }
+	assert.True(t, m.Active())
+	m.now = func() time.Time { return time.Date(2026, 10, 18, 0, 30, 0, 0, time.UTC) }
+	assert.True(t, m.Active())
+
+	// Sunday 23:30 is not (window only starts on Saturday)
+	m.now = func() time.Time { return time.Date(2026, 10, 18, 23, 30, 0, 0, time.UTC) }
+	assert.False(t, m.Active())
+}
+
+func TestManager_ManualOverride(t *testing.T) {
+	m := newTestManager(t, nil)
+	assert.False(t, m.Active())
+
+	require.NoError(t, m.Enable("bunny incident"))
+	status := m.Status()
+	assert.True(t, status.Active)
+	assert.True(t, status.Manual)
+	assert.Equal(t, "bunny incident", status.Reason)
+
+	// A second manager on the same file sees the override (CLI -> daemon)
+	other, err := NewManager(m.file.Path(), config.MaintenanceConfig{Timezone: "UTC"}, zap.NewNop())
+	require.NoError(t, err)
+	assert.True(t, other.Active())
+
+	require.NoError(t, m.Disable())
+	assert.False(t, m.Active())
+}
@@ -0,0 +1,203 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+// AckCommand is the command acknowledging an incident; the button under an
+// incident alert sends it with the incident ID as callback data
+const AckCommand = "ack"
+
+// CommandHandler answers a bot command such as "/status example.com"; the
+// command is given without its slash or bot name. The reply is sent as HTML
+type CommandHandler func(ctx context.Context, command string, args []string) string
+
+// ParseCommand splits a message into a command and its arguments. It
+// reports false for messages that are not commands
+func ParseCommand(text string) (string, []string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil, false
+	}
+	command := strings.TrimPrefix(fields[0], "/")
+	// Commands in groups may be addressed as /status@botname
+	command, _, _ = strings.Cut(command, "@")
+	if command == "" {
+		return "", nil, false
+	}
+	return strings.ToLower(command), fields[1:], true
+}
+
+// EnableAckButtons attaches a button acknowledging the incident to the
+// alerts sent with SendIncident. ListenCommands must run to answer it
+func (t *TelegramNotifier) EnableAckButtons() {
+	t.ackButtons = true
+}
+
+// SendIncident sends the alert of incident id to the chat of category,
+// with an acknowledge button when EnableAckButtons was called. Alerts that
+// have to be queued are retried without the button; /ack still works
+func (t *TelegramNotifier) SendIncident(ctx context.Context, category Category, message, id string) error {
+	if !t.enabled {
+		return nil
+	}
+	if !t.ackButtons || id == "" || (t.queue != nil && t.queue.Len() > 0) {
+		return t.SendRaw(ctx, category, message)
+	}
+
+	route := t.route(category)
+	params := telego.SendMessageParams{
+		ChatID:          telego.ChatID{ID: route.ChatID},
+		MessageThreadID: route.ThreadID,
+		Text:            message,
+		ParseMode:       "HTML",
+		ReplyMarkup: tu.InlineKeyboard(tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(i18n.T("incident.ack_button")).WithCallbackData(AckCommand + " " + id),
+		)),
+	}
+	if _, err := t.client.SendMessage(&params); err != nil {
+		t.logger.Warn("failed to send incident alert, sending it without the acknowledge button",
+			zap.String("incident", id),
+			zap.Error(err),
+		)
+		return t.SendRaw(ctx, category, message)
+	}
+	return nil
+}
+
+// ownChat reports whether chatID is the configured chat or the chat of a
+// route, where incident alerts and their buttons may have been sent
+func (t *TelegramNotifier) ownChat(chatID int64) bool {
+	if chatID == t.chatID {
+		return true
+	}
+	for _, r := range t.routes {
+		if r.ChatID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenCommands answers commands sent in the configured chat until ctx is
+// done. Messages from other chats are ignored, so only the operators who
+// receive the notifications can use the bot. Presses of an acknowledge
+// button are answered as the /ack command
+func (t *TelegramNotifier) ListenCommands(ctx context.Context, handle CommandHandler) error {
+	if !t.enabled {
+		return nil
+	}
+
+	updates, err := t.client.UpdatesViaLongPolling(&telego.GetUpdatesParams{
+		AllowedUpdates: []string{"message", "callback_query"},
+	})
+	if err != nil {
+		return err
+	}
+	defer t.client.StopLongPolling()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			if update.CallbackQuery != nil {
+				t.answerCallback(ctx, update.CallbackQuery, handle)
+				continue
+			}
+			msg := update.Message
+			if msg == nil || msg.Chat.ID != t.chatID {
+				continue
+			}
+			command, args, ok := ParseCommand(msg.Text)
+			if !ok {
+				continue
+			}
+
+			t.logger.Info("telegram command received",
+				zap.String("command", command),
+				zap.Strings("args", args),
+			)
+			reply := handle(WithActor(ctx, sender(msg.From)), command, args)
+			if reply == "" {
+				continue
+			}
+			// Replies go to the chat the commands are read from
+			if err := t.send(ctx, "", reply); err != nil {
+				t.logger.Warn("failed to answer telegram command",
+					zap.String("command", command),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// answerCallback answers the press of a button under an alert, such as the
+// acknowledge button, by running the command in its callback data
+func (t *TelegramNotifier) answerCallback(ctx context.Context, query *telego.CallbackQuery, handle CommandHandler) {
+	if query.Message == nil || !t.ownChat(query.Message.Chat.ID) {
+		return
+	}
+	command, args, ok := ParseCommand("/" + query.Data)
+	if !ok || command != AckCommand {
+		return
+	}
+
+	// The operator pressing the button is recorded as acknowledging
+	by := sender(&query.From)
+	t.logger.Info("telegram button pressed",
+		zap.String("command", command),
+		zap.Strings("args", args),
+		zap.String("by", by),
+	)
+	reply := handle(WithActor(ctx, by), command, args)
+
+	if err := t.client.AnswerCallbackQuery(&telego.AnswerCallbackQueryParams{CallbackQueryID: query.ID}); err != nil {
+		t.logger.Warn("failed to answer telegram button", zap.Error(err))
+	}
+	if reply == "" {
+		return
+	}
+	if err := t.send(ctx, "", reply); err != nil {
+		t.logger.Warn("failed to answer telegram button",
+			zap.String("command", command),
+			zap.Error(err),
+		)
+	}
+}
+
+// sender names the Telegram user who sent a command
+func sender(user *telego.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return user.FirstName
+}
+
+// actorKey is the context key of the operator running a command
+type actorKey struct{}
+
+// WithActor returns ctx naming the operator who ran a command
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the operator who ran a command, "" when unknown
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
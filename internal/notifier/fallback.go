@@ -0,0 +1,76 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookFallback posts notifications Telegram could not take to an HTTP
+// endpoint as JSON:
+//
+//	{"server": "...", "messages": [{"text": "...", "created_at": "..."}]}
+//
+// The text is the Telegram message, formatted with Telegram's HTML subset
+type WebhookFallback struct {
+	url    string
+	server string
+	client *http.Client
+}
+
+// webhookPayload is the body posted by WebhookFallback
+type webhookPayload struct {
+	Server   string           `json:"server"`
+	Messages []webhookMessage `json:"messages"`
+}
+
+// webhookMessage is one notification of a webhookPayload
+type webhookMessage struct {
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+}
+
+// NewWebhookFallback creates a fallback posting to url with client; the
+// payload names the instance server
+func NewWebhookFallback(url, server string, client *http.Client) *WebhookFallback {
+	return &WebhookFallback{url: url, server: server, client: client}
+}
+
+// Name identifies the fallback
+func (w *WebhookFallback) Name() string {
+	return "webhook"
+}
+
+// Deliver posts the messages in one request; any status but 2xx fails
+func (w *WebhookFallback) Deliver(ctx context.Context, messages []QueuedMessage) error {
+	payload := webhookPayload{Server: w.server, Messages: make([]webhookMessage, 0, len(messages))}
+	for _, m := range messages {
+		payload.Messages = append(payload.Messages, webhookMessage{
+			Text:      m.Text,
+			CreatedAt: m.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fallback payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create fallback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fallback webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("fallback webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// QueuePollInterval is how often Run looks for notifications due for a retry
+const QueuePollInterval = 5 * time.Second
+
+// QueuedMessage is a notification waiting to be delivered
+type QueuedMessage struct {
+	ID          string    `json:"id"`
+	Category    Category  `json:"category,omitempty"`
+	Text        string    `json:"text"`
+	CreatedAt   time.Time `json:"created_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Fallback delivers notifications through another channel while Telegram
+// is unreachable
+type Fallback interface {
+	// Name identifies the channel in logs and status
+	Name() string
+	// Deliver sends the messages, oldest first, in one go
+	Deliver(ctx context.Context, messages []QueuedMessage) error
+}
+
+// DeliverFunc sends one message of category to Telegram
+type DeliverFunc func(ctx context.Context, category Category, text string) error
+
+// QueueConfig controls retries and the fallback of a Queue
+type QueueConfig struct {
+	Backoff       time.Duration // First retry delay, doubled after each failure
+	MaxBackoff    time.Duration // Longest retry delay
+	MaxAge        time.Duration // Messages older than this are dropped
+	FallbackAfter time.Duration // Outage length after which the fallback is used
+	// Keyring encrypts the queue file at rest; nil keeps it in plain JSON
+	Keyring *encryption.Keyring
+}
+
+// QueueStatus describes the queue for /health
+type QueueStatus struct {
+	Pending      int        `json:"pending"`
+	Oldest       *time.Time `json:"oldest,omitempty"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	Fallback     string     `json:"fallback,omitempty"`
+}
+
+// queueFile is the persisted form of a queue
+type queueFile struct {
+	Messages     []QueuedMessage `json:"messages"`
+	FailingSince time.Time       `json:"failing_since,omitempty"`
+}
+
+// Queue persists the notifications Telegram did not accept and retries
+// them in order with exponential backoff, so an outage or a restart does
+// not lose them. Once Telegram has been failing for FallbackAfter the
+// pending messages go through the fallback channel instead
+type Queue struct {
+	filePath string
+	cfg      QueueConfig
+	messages []QueuedMessage
+	// failingSince is when the current Telegram outage started; zero while
+	// Telegram accepts messages
+	failingSince time.Time
+	fallback     Fallback
+	mu           sync.Mutex
+	logger       *zap.Logger
+	clock        clock.Clock // nil uses the system clock
+}
+
+// NewQueue creates a queue persisted at filePath, loading the messages a
+// previous run left undelivered
+func NewQueue(filePath string, cfg QueueConfig, logger *zap.Logger) (*Queue, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	q := &Queue{
+		filePath: filePath,
+		cfg:      cfg,
+		logger:   logger,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create notification queue directory: %w", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification queue: %w", err)
+	}
+	if data, err = cfg.Keyring.Decrypt(data); err != nil {
+		return nil, fmt.Errorf("failed to read notification queue: %w", err)
+	}
+	var f queueFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse notification queue: %w", err)
+	}
+	q.messages = f.Messages
+	q.failingSince = f.FailingSince
+	if len(q.messages) > 0 {
+		logger.Info("loaded undelivered notifications", zap.Int("count", len(q.messages)))
+	}
+
+	return q, nil
+}
+
+// SetFallback sets the channel used once Telegram has been unreachable
+// for FallbackAfter
+func (q *Queue) SetFallback(f Fallback) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.fallback = f
+}
+
+// SetClock replaces the clock used for retries and outages
+func (q *Queue) SetClock(c clock.Clock) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.clock = c
+}
+
+// now returns the current time from the clock
+func (q *Queue) now() time.Time {
+	if q.clock == nil {
+		return time.Now()
+	}
+	return q.clock.Now()
+}
+
+// Len returns the number of messages waiting
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// Messages returns the messages waiting, oldest first
+func (q *Queue) Messages() []QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.messages)
+}
+
+// Push queues a message of category. err is the error of a delivery
+// attempt that just failed; nil queues the message behind earlier ones
+// without an attempt
+func (q *Queue) Push(category Category, text string, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	m := QueuedMessage{
+		ID:          uuid.NewString(),
+		Category:    category,
+		Text:        text,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if err != nil {
+		q.failed(&m, err, now)
+	}
+	q.messages = append(q.messages, m)
+	return q.save()
+}
+
+// Succeeded records that Telegram accepted a message, ending an outage
+func (q *Queue) Succeeded() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.failingSince.IsZero() {
+		return
+	}
+	q.logger.Info("Telegram reachable again",
+		zap.Duration("outage", q.now().Sub(q.failingSince)),
+	)
+	q.failingSince = time.Time{}
+	if err := q.save(); err != nil {
+		q.logger.Warn("failed to save notification queue", zap.Error(err))
+	}
+}
+
+// failed records a failed attempt of m and schedules its retry; q.mu
+// must be held
+func (q *Queue) failed(m *QueuedMessage, err error, now time.Time) {
+	m.Attempts++
+	m.LastError = err.Error()
+	m.NextAttempt = now.Add(q.backoff(m.Attempts))
+	if q.failingSince.IsZero() {
+		q.failingSince = now
+	}
+}
+
+// backoff returns the delay before the retry following attempts failures
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.cfg.Backoff
+	for i := 1; i < attempts && delay < q.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, q.cfg.MaxBackoff)
+}
+
+// Flush drops expired messages, retries the due ones in order with
+// deliver until one fails, and hands every waiting message to the
+// fallback once Telegram has been failing for FallbackAfter
+func (q *Queue) Flush(ctx context.Context, deliver DeliverFunc) {
+	q.dropExpired()
+
+	for _, m := range q.Messages() {
+		if ctx.Err() != nil {
+			return
+		}
+		// Later messages wait for earlier ones to keep their order
+		if m.NextAttempt.After(q.now()) {
+			break
+		}
+		err := deliver(ctx, m.Category, m.Text)
+		q.finish(m.ID, err)
+		if err != nil {
+			q.logger.Warn("notification retry failed",
+				zap.Int("attempts", m.Attempts+1),
+				zap.Error(err),
+			)
+			break
+		}
+	}
+
+	q.useFallback(ctx)
+}
+
+// finish removes a delivered message, or records the failed attempt
+func (q *Queue) finish(id string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := slices.IndexFunc(q.messages, func(m QueuedMessage) bool { return m.ID == id })
+	if i < 0 {
+		return
+	}
+	if err != nil {
+		q.failed(&q.messages[i], err, q.now())
+	} else {
+		q.messages = slices.Delete(q.messages, i, i+1)
+		q.failingSince = time.Time{}
+	}
+	if err := q.save(); err != nil {
+		q.logger.Warn("failed to save notification queue", zap.Error(err))
+	}
+}
+
+// dropExpired removes the messages older than MaxAge
+func (q *Queue) dropExpired() {
+	if q.cfg.MaxAge <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cutoff := q.now().Add(-q.cfg.MaxAge)
+	kept := q.messages[:0]
+	for _, m := range q.messages {
+		if m.CreatedAt.Before(cutoff) {
+			q.logger.Error("dropping undelivered notification",
+				zap.Time("created_at", m.CreatedAt),
+				zap.Int("attempts", m.Attempts),
+				zap.String("last_error", m.LastError),
+				zap.String("message", m.Text),
+			)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	if len(kept) == len(q.messages) {
+		return
+	}
+	q.messages = kept
+	if err := q.save(); err != nil {
+		q.logger.Warn("failed to save notification queue", zap.Error(err))
+	}
+}
+
+// useFallback delivers the waiting messages through the fallback once
+// Telegram has been failing for FallbackAfter
+func (q *Queue) useFallback(ctx context.Context) {
+	q.mu.Lock()
+	fallback, failingSince := q.fallback, q.failingSince
+	messages := slices.Clone(q.messages)
+	q.mu.Unlock()
+
+	if fallback == nil || failingSince.IsZero() || len(messages) == 0 ||
+		q.now().Sub(failingSince) < q.cfg.FallbackAfter {
+		return
+	}
+
+	if err := fallback.Deliver(ctx, messages); err != nil {
+		q.logger.Error("notification fallback failed",
+			zap.String("fallback", fallback.Name()),
+			zap.Int("count", len(messages)),
+			zap.Error(err),
+		)
+		return
+	}
+	q.logger.Warn("Telegram unreachable, notifications sent through fallback",
+		zap.String("fallback", fallback.Name()),
+		zap.Int("count", len(messages)),
+		zap.Time("failing_since", failingSince),
+	)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = slices.DeleteFunc(q.messages, func(m QueuedMessage) bool {
+		return slices.ContainsFunc(messages, func(sent QueuedMessage) bool { return sent.ID == m.ID })
+	})
+	if err := q.save(); err != nil {
+		q.logger.Warn("failed to save notification queue", zap.Error(err))
+	}
+}
+
+// Run flushes the queue with deliver every QueuePollInterval until ctx is
+// done
+func (q *Queue) Run(ctx context.Context, deliver DeliverFunc) {
+	ticker := time.NewTicker(QueuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.Flush(ctx, deliver)
+		}
+	}
+}
+
+// Status returns the queue length, its oldest message and the outage
+func (q *Queue) Status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := QueueStatus{Pending: len(q.messages)}
+	if len(q.messages) > 0 {
+		oldest := q.messages[0].CreatedAt
+		status.Oldest = &oldest
+	}
+	if !q.failingSince.IsZero() {
+		failingSince := q.failingSince
+		status.FailingSince = &failingSince
+	}
+	if q.fallback != nil {
+		status.Fallback = q.fallback.Name()
+	}
+	return status
+}
+
+// Reencrypt rewrites the queue file with the current key of the queue's
+// keyring, or in plain JSON when it does not encrypt
+func (q *Queue) Reencrypt() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.save()
+}
+
+// save writes the queue to disk atomically; q.mu must be held
+func (q *Queue) save() error {
+	data, err := json.MarshalIndent(queueFile{Messages: q.messages, FailingSince: q.failingSince}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification queue: %w", err)
+	}
+	if data, err = q.cfg.Keyring.Encrypt(data); err != nil {
+		return fmt.Errorf("failed to encrypt notification queue: %w", err)
+	}
+	if err := filestore.WriteFile(q.filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to save notification queue: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+)
+
+var testQueueConfig = QueueConfig{
+	Backoff:       30 * time.Second,
+	MaxBackoff:    2 * time.Minute,
+	MaxAge:        time.Hour,
+	FallbackAfter: 10 * time.Minute,
+}
+
+// fakeFallback records the messages it delivers
+type fakeFallback struct {
+	delivered [][]QueuedMessage
+	err       error
+}
+
+func (f *fakeFallback) Name() string { return "fake" }
+
+func (f *fakeFallback) Deliver(_ context.Context, messages []QueuedMessage) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.delivered = append(f.delivered, messages)
+	return nil
+}
+
+// recorder is a DeliverFunc failing while err is set
+type recorder struct {
+	sent []string
+	err  error
+}
+
+func (r *recorder) deliver(_ context.Context, _ Category, text string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, text)
+	return nil
+}
+
+func newTestQueue(t *testing.T, path string, fake *clock.Fake) *Queue {
+	t.Helper()
+	q, err := NewQueue(path, testQueueConfig, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	q.SetClock(fake)
+	return q
+}
+
+func TestQueue_RetriesInOrderWithBackoff(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	q := newTestQueue(t, filepath.Join(t.TempDir(), "queue.json"), fake)
+
+	down := errors.New("telegram down")
+	require.NoError(t, q.Push(CategoryProvisioning, "first", down))
+	require.NoError(t, q.Push(CategoryProvisioning, "second", nil))
+	r := &recorder{err: down}
+
+	// Not due before the backoff
+	q.Flush(context.Background(), r.deliver)
+	assert.Equal(t, 1, q.Messages()[0].Attempts)
+
+	fake.Advance(30 * time.Second)
+	q.Flush(context.Background(), r.deliver)
+	messages := q.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, 2, messages[0].Attempts)
+	assert.Equal(t, fake.Now().Add(time.Minute), messages[0].NextAttempt)
+	assert.Equal(t, "telegram down", messages[0].LastError)
+	assert.Zero(t, messages[1].Attempts, "later messages wait for the first")
+
+	// Backoff is capped at MaxBackoff
+	assert.Equal(t, 2*time.Minute, q.backoff(5))
+
+	r.err = nil
+	fake.Advance(time.Minute)
+	q.Flush(context.Background(), r.deliver)
+	assert.Equal(t, []string{"first", "second"}, r.sent)
+	assert.Zero(t, q.Len())
+	assert.Nil(t, q.Status().FailingSince)
+}
+
+func TestQueue_PersistsAcrossRestarts(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	q := newTestQueue(t, path, fake)
+	require.NoError(t, q.Push(CategoryProvisioning, "provisioned example.com", errors.New("timeout")))
+
+	q = newTestQueue(t, path, fake)
+	messages := q.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "provisioned example.com", messages[0].Text)
+	assert.Equal(t, 1, messages[0].Attempts)
+	status := q.Status()
+	assert.Equal(t, 1, status.Pending)
+	require.NotNil(t, status.FailingSince)
+	assert.Equal(t, fake.Now(), *status.FailingSince)
+}
+
+func TestQueue_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{1}, encryption.KeySize))
+	require.NoError(t, err)
+	cfg := testQueueConfig
+	cfg.Keyring = keyring
+
+	q, err := NewQueue(path, cfg, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.NoError(t, q.Push(CategoryProvisioning, "provisioned example.com", errors.New("timeout")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(data))
+	assert.NotContains(t, string(data), "example.com")
+
+	_, err = NewQueue(path, testQueueConfig, zaptest.NewLogger(t))
+	assert.ErrorIs(t, err, encryption.ErrEncrypted)
+
+	q, err = NewQueue(path, cfg, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.Len(t, q.Messages(), 1)
+
+	// Rewritten without a current key, the queue is plain JSON again
+	decryptOnly, err := encryption.NewKeyring(nil, bytes.Repeat([]byte{1}, encryption.KeySize))
+	require.NoError(t, err)
+	cfg.Keyring = decryptOnly
+	q, err = NewQueue(path, cfg, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.NoError(t, q.Reencrypt())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "provisioned example.com")
+}
+
+func TestQueue_DropsExpiredMessages(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	q := newTestQueue(t, filepath.Join(t.TempDir(), "queue.json"), fake)
+
+	require.NoError(t, q.Push(CategoryProvisioning, "old", errors.New("down")))
+	fake.Advance(30 * time.Minute)
+	require.NoError(t, q.Push(CategoryProvisioning, "recent", nil))
+	fake.Advance(31 * time.Minute)
+
+	r := &recorder{err: errors.New("down")}
+	q.Flush(context.Background(), r.deliver)
+	messages := q.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "recent", messages[0].Text)
+}
+
+func TestQueue_Fallback(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	q := newTestQueue(t, filepath.Join(t.TempDir(), "queue.json"), fake)
+	fallback := &fakeFallback{err: errors.New("smtp down")}
+	q.SetFallback(fallback)
+
+	down := errors.New("telegram down")
+	require.NoError(t, q.Push(CategoryProvisioning, "first", down))
+	require.NoError(t, q.Push(CategoryProvisioning, "second", nil))
+	r := &recorder{err: down}
+
+	// The outage is too short for the fallback
+	fake.Advance(5 * time.Minute)
+	q.Flush(context.Background(), r.deliver)
+	assert.Equal(t, 2, q.Len())
+
+	// A failing fallback keeps the messages
+	fake.Advance(5 * time.Minute)
+	q.Flush(context.Background(), r.deliver)
+	assert.Equal(t, 2, q.Len())
+
+	fallback.err = nil
+	q.Flush(context.Background(), r.deliver)
+	require.Len(t, fallback.delivered, 1)
+	assert.Equal(t, "first", fallback.delivered[0][0].Text)
+	assert.Equal(t, "second", fallback.delivered[0][1].Text)
+	assert.Zero(t, q.Len())
+	assert.Equal(t, "fake", q.Status().Fallback)
+	assert.NotNil(t, q.Status().FailingSince, "Telegram is still failing")
+}
+
+func TestTelegramNotifier_QueuesWhileMessagesWait(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	q := newTestQueue(t, filepath.Join(t.TempDir(), "queue.json"), fake)
+	require.NoError(t, q.Push(CategoryProvisioning, "earlier", errors.New("down")))
+
+	n := &TelegramNotifier{enabled: true, logger: zaptest.NewLogger(t)}
+	n.SetQueue(q)
+
+	// The client is never used while earlier messages wait
+	require.NoError(t, n.send(context.Background(), CategoryAlerts, "later"))
+	messages := q.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, "later", messages[1].Text)
+	assert.Equal(t, CategoryAlerts, messages[1].Category)
+	assert.Zero(t, messages[1].Attempts)
+}
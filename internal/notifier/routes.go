@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Category groups notifications that can be routed to their own chat
+type Category string
+
+// Notification categories
+const (
+	// CategoryProvisioning covers provisioned, failed and removed domains
+	CategoryProvisioning Category = "provisioning"
+	// CategoryAlerts covers operational alerts such as drift, disabled
+	// zones, expiring certificates and bandwidth spikes
+	CategoryAlerts Category = "alerts"
+	// CategorySummaries covers the daily and weekly summaries and their
+	// attached reports
+	CategorySummaries Category = "summaries"
+	// CategoryBilling covers the Bunny balance and package changes
+	CategoryBilling Category = "billing"
+)
+
+// templateCategories maps each notification template to its category
+var templateCategories = map[string]Category{
+	TemplateSuccess:              CategoryProvisioning,
+	TemplateRecovered:            CategoryProvisioning,
+	TemplateFailed:               CategoryProvisioning,
+	TemplateSSL:                  CategoryProvisioning,
+	TemplateDeprovisioned:        CategoryProvisioning,
+	TemplateAccountDeprovisioned: CategoryProvisioning,
+	TemplateSubdomain:            CategoryProvisioning,
+	TemplateObserved:             CategoryProvisioning,
+	TemplateBandwidth:            CategoryAlerts,
+	TemplateMaintenance:          CategoryAlerts,
+	TemplateTokenRotated:         CategoryAlerts,
+	TemplateEmergency:            CategoryAlerts,
+	TemplateDrift:                CategoryAlerts,
+	TemplateCertificateExpiry:    CategoryAlerts,
+	TemplateSlowProvision:        CategoryAlerts,
+	TemplateSLODegraded:          CategoryAlerts,
+	TemplateZoneDisabled:         CategoryAlerts,
+	TemplateIncident:             CategoryAlerts,
+	TemplateBalance:              CategoryBilling,
+	TemplatePackageChanged:       CategoryBilling,
+	TemplateDailySummary:         CategorySummaries,
+	TemplateWeeklySummary:        CategorySummaries,
+}
+
+// Route is the chat, and the topic of a forum supergroup, a category of
+// notifications is sent to
+type Route struct {
+	// ChatID is the chat; 0 sends to the configured chat
+	ChatID int64
+	// ThreadID is the forum topic; 0 sends to the chat itself
+	ThreadID int
+}
+
+// SetRoutes sends the notifications of the given categories to their own
+// chats; other categories go to the configured chat
+func (t *TelegramNotifier) SetRoutes(routes map[Category]Route) {
+	t.routes = routes
+}
+
+// route returns where notifications of category are sent
+func (t *TelegramNotifier) route(category Category) Route {
+	r := t.routes[category]
+	if r.ChatID == 0 {
+		r.ChatID = t.chatID
+	}
+	return r
+}
+
+// ParseRoute parses the chat ID of a route; an empty chat ID keeps the
+// configured chat, so a route can pick only a forum topic
+func ParseRoute(chatID string, threadID int) (Route, error) {
+	if chatID == "" {
+		return Route{ThreadID: threadID}, nil
+	}
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid chat ID: %w", err)
+	}
+	return Route{ChatID: id, ThreadID: threadID}, nil
+}
@@ -0,0 +1,42 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateCategories(t *testing.T) {
+	for _, name := range TemplateNames() {
+		assert.NotEmpty(t, templateCategories[name], "template %s has no category", name)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	n := &TelegramNotifier{chatID: 100}
+	n.SetRoutes(map[Category]Route{
+		CategorySummaries: {ChatID: 200},
+		CategoryAlerts:    {ThreadID: 7},
+		CategoryBilling:   {ChatID: 300, ThreadID: 4},
+	})
+
+	assert.Equal(t, Route{ChatID: 200}, n.route(CategorySummaries))
+	assert.Equal(t, Route{ChatID: 100, ThreadID: 7}, n.route(CategoryAlerts), "a topic of the configured chat")
+	assert.Equal(t, Route{ChatID: 300, ThreadID: 4}, n.route(CategoryBilling))
+	assert.Equal(t, Route{ChatID: 100}, n.route(CategoryProvisioning))
+	assert.Equal(t, Route{ChatID: 100}, n.route(""), "command replies")
+}
+
+func TestParseRoute(t *testing.T) {
+	route, err := ParseRoute("-1001234567890", 12)
+	require.NoError(t, err)
+	assert.Equal(t, Route{ChatID: -1001234567890, ThreadID: 12}, route)
+
+	route, err = ParseRoute("", 3)
+	require.NoError(t, err)
+	assert.Equal(t, Route{ThreadID: 3}, route)
+
+	_, err = ParseRoute("@channel", 0)
+	assert.Error(t, err)
+}
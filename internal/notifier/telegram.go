@@ -1,15 +1,22 @@
 package notifier
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
 	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
 )
 
 // TelegramNotifier handles Telegram notifications for provisioning events
@@ -19,10 +26,36 @@ type TelegramNotifier struct {
 	enabled bool
 	events  []string
 	logger  *zap.Logger
+	clock   clock.Clock // nil uses the system clock
+
+	templates *Templates
+	// routes sends categories of notifications to their own chats
+	routes map[Category]Route
+	// queue retries messages Telegram did not accept (optional)
+	queue *Queue
+	// ackButtons attaches acknowledge buttons to incident alerts
+	ackButtons bool
+	// server names this instance in messages; empty uses the hostname
+	server string
+}
+
+// ErrUnreachable is returned with a usable notifier when the Telegram API
+// could not be reached at startup; messages are queued until it can
+var ErrUnreachable = errors.New("telegram API unreachable")
+
+// Option configures the Telegram bot client of a notifier
+type Option func(*[]telego.BotOption)
+
+// WithHTTPClient sends Telegram API requests with httpClient, such as one
+// using an egress proxy, instead of the bot's default client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(opts *[]telego.BotOption) {
+		*opts = append(*opts, telego.WithHTTPClient(httpClient))
+	}
 }
 
 // NewTelegramNotifier creates a new Telegram notifier instance
-func NewTelegramNotifier(botToken, chatID string, enabled bool, events []string, logger *zap.Logger) (*TelegramNotifier, error) {
+func NewTelegramNotifier(botToken, chatID string, enabled bool, events []string, logger *zap.Logger, opts ...Option) (*TelegramNotifier, error) {
 	if !enabled || botToken == "" || chatID == "" {
 		return &TelegramNotifier{
 			enabled: false,
@@ -38,23 +71,29 @@ func NewTelegramNotifier(botToken, chatID string, enabled bool, events []string,
 	}
 
 	// Create bot client
-	bot, err := telego.NewBot(botToken)
+	var botOpts []telego.BotOption
+	for _, opt := range opts {
+		opt(&botOpts)
+	}
+	bot, err := telego.NewBot(botToken, botOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create telegram bot: %w", err)
 	}
 
-	// Test bot connection
-	if _, err := bot.GetMe(); err != nil {
-		return nil, fmt.Errorf("failed to connect to telegram API: %w", err)
-	}
-
-	return &TelegramNotifier{
+	t := &TelegramNotifier{
 		client:  bot,
 		chatID:  chatIDInt,
 		enabled: true,
 		events:  events,
 		logger:  logger,
-	}, nil
+	}
+
+	// Test bot connection
+	if _, err := bot.GetMe(); err != nil {
+		return t, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	return t, nil
 }
 
 // IsEnabled returns whether the notifier is enabled
@@ -68,17 +107,58 @@ func (t *TelegramNotifier) Shutdown() error {
 	return nil
 }
 
-// send sends a message to the configured chat
-func (t *TelegramNotifier) send(ctx context.Context, message string) error {
+// SetQueue queues the messages Telegram does not accept for retries
+// instead of dropping them; start RunQueue to retry them
+func (t *TelegramNotifier) SetQueue(q *Queue) {
+	t.queue = q
+}
+
+// Queue returns the retry queue, nil when messages are not queued
+func (t *TelegramNotifier) Queue() *Queue {
+	return t.queue
+}
+
+// RunQueue retries queued messages until ctx is done
+func (t *TelegramNotifier) RunQueue(ctx context.Context) {
+	if !t.enabled || t.queue == nil {
+		return
+	}
+	t.queue.Run(ctx, t.sendMessage)
+}
+
+// send sends a message to the chat of its category. With a queue, a
+// message Telegram does not accept is queued for retry, as is every message
+// while earlier ones wait, so they arrive in order
+func (t *TelegramNotifier) send(ctx context.Context, category Category, message string) error {
 	if !t.enabled {
 		return nil
 	}
 
+	if t.queue != nil && t.queue.Len() > 0 {
+		return t.queue.Push(category, message, nil)
+	}
+
+	err := t.sendMessage(ctx, category, message)
+	if t.queue == nil {
+		return err
+	}
+	if err == nil {
+		t.queue.Succeeded()
+		return nil
+	}
+	t.logger.Warn("telegram notification queued for retry", zap.Error(err))
+	return t.queue.Push(category, message, err)
+}
+
+// sendMessage sends a message to the chat of its category once
+func (t *TelegramNotifier) sendMessage(ctx context.Context, category Category, message string) error {
+	route := t.route(category)
 	// Create message params - use only ID field for integer chat ID
 	params := telego.SendMessageParams{
-		ChatID:    telego.ChatID{ID: t.chatID},
-		Text:      message,
-		ParseMode: "HTML",
+		ChatID:          telego.ChatID{ID: route.ChatID},
+		MessageThreadID: route.ThreadID,
+		Text:            message,
+		ParseMode:       "HTML",
 	}
 
 	// Send message
@@ -88,6 +168,7 @@ func (t *TelegramNotifier) send(ctx context.Context, message string) error {
 	if err != nil {
 		t.logger.Error("failed to send telegram notification",
 			zap.Error(err),
+			zap.String("category", string(category)),
 			zap.String("message", message),
 		)
 		return err
@@ -110,8 +191,62 @@ func (t *TelegramNotifier) shouldNotify(event string) bool {
 	return false
 }
 
-// getHostname returns the server hostname
-func (t *TelegramNotifier) getHostname() string {
+// SetTemplates replaces the templates messages are rendered with
+func (t *TelegramNotifier) SetTemplates(templates *Templates) {
+	t.templates = templates
+}
+
+// Templates returns the templates messages are rendered with
+func (t *TelegramNotifier) Templates() *Templates {
+	if t.templates == nil {
+		return DefaultTemplates()
+	}
+	return t.templates
+}
+
+// notify renders a template and sends the message to the chat of the
+// template's category
+func (t *TelegramNotifier) notify(ctx context.Context, name string, data any) error {
+	if !t.enabled {
+		return nil
+	}
+
+	message, err := t.Templates().Render(name, data)
+	if err != nil {
+		t.logger.Error("failed to render telegram notification", zap.Error(err))
+		return err
+	}
+	return t.send(ctx, templateCategories[name], message)
+}
+
+// base returns the common template fields
+func (t *TelegramNotifier) base() MessageBase {
+	return MessageBase{Server: t.serverName(), Time: t.now()}
+}
+
+// SetServer names this instance in messages instead of the hostname
+func (t *TelegramNotifier) SetServer(name string) {
+	t.server = name
+}
+
+// SetClock replaces the clock that stamps messages
+func (t *TelegramNotifier) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+// now returns the current time from the clock
+func (t *TelegramNotifier) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+// serverName returns the name set by SetServer, or else the hostname
+func (t *TelegramNotifier) serverName() string {
+	if t.server != "" {
+		return t.server
+	}
 	hostname, err := os.Hostname()
 	if err != nil {
 		return "unknown"
@@ -120,55 +255,53 @@ func (t *TelegramNotifier) getHostname() string {
 }
 
 // NotifySuccess sends a notification on successful domain provisioning
-func (t *TelegramNotifier) NotifySuccess(ctx context.Context, domain string, zoneID int64, cdnHostname string, duration time.Duration) error {
+func (t *TelegramNotifier) NotifySuccess(ctx context.Context, domain string, user string, zoneID int64, cdnHostname string, duration time.Duration) error {
 	if !t.shouldNotify("success") {
 		return nil
 	}
 
-	message := fmt.Sprintf(`✅ <b>Domain Provisioned</b>
-
-🌐 <b>Domain:</b> %s
-📍 <b>Zone ID:</b> %d
-🚀 <b>CDN:</b> %s
-⏱️ <b>Duration:</b> %.2fs
+	return t.notify(ctx, TemplateSuccess, SuccessMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		User:        user,
+		ZoneID:      zoneID,
+		CDNHostname: cdnHostname,
+		Duration:    duration,
+	})
+}
 
-🖥️ <b>Server:</b> %s`,
-		domain,
-		zoneID,
-		cdnHostname,
-		duration.Seconds(),
-		t.getHostname(),
-	)
+// NotifyRecovered sends the notification of a successful provisioning that
+// failed before, in place of NotifySuccess; it is sent for the success event
+func (t *TelegramNotifier) NotifyRecovered(ctx context.Context, domain string, user string, zoneID int64, cdnHostname string, duration time.Duration, attempts int, since time.Duration) error {
+	if !t.shouldNotify("success") {
+		return nil
+	}
 
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateRecovered, RecoveredMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		User:        user,
+		ZoneID:      zoneID,
+		CDNHostname: cdnHostname,
+		Duration:    duration,
+		Attempts:    attempts,
+		Since:       since,
+	})
 }
 
 // NotifyFailed sends a notification when provisioning fails
-func (t *TelegramNotifier) NotifyFailed(ctx context.Context, domain string, step string, errMsg string) error {
+func (t *TelegramNotifier) NotifyFailed(ctx context.Context, domain string, user string, step string, errMsg string) error {
 	if !t.shouldNotify("failed") {
 		return nil
 	}
 
-	// Get current time in WIB (GMT+7)
-	wibLocation := time.FixedZone("WIB", 7*60*60)
-	currentTime := time.Now().In(wibLocation).Format("2006-01-02 15:04:05 WIB")
-
-	message := fmt.Sprintf(`❌ <b>Provisioning Failed</b>
-
-🌐 <b>Domain:</b> %s
-📍 <b>Step:</b> %s
-⚠️ <b>Error:</b> %s
-
-🖥️ <b>Server:</b> %s
-🕐 <b>Time:</b> %s`,
-		domain,
-		step,
-		errMsg,
-		t.getHostname(),
-		currentTime,
-	)
-
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateFailed, FailedMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		User:        user,
+		Step:        step,
+		Error:       errMsg,
+	})
 }
 
 // NotifySSLIssued sends a notification when an SSL certificate is issued
@@ -177,20 +310,12 @@ func (t *TelegramNotifier) NotifySSLIssued(ctx context.Context, domain string, i
 		return nil
 	}
 
-	message := fmt.Sprintf(`🔐 <b>SSL Certificate Issued</b>
-
-🌐 <b>Domain:</b> %s
-📜 <b>Issuer:</b> %s
-📅 <b>Expires:</b> %s
-
-🖥️ <b>Server:</b> %s`,
-		domain,
-		issuer,
-		expires.Format("2006-01-02"),
-		t.getHostname(),
-	)
-
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateSSL, SSLMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		Issuer:      issuer,
+		Expires:     expires,
+	})
 }
 
 // NotifyBandwidthAlert sends a notification for bandwidth usage alerts
@@ -199,64 +324,261 @@ func (t *TelegramNotifier) NotifyBandwidthAlert(ctx context.Context, domain stri
 		return nil
 	}
 
-	message := fmt.Sprintf(`⚠️ <b>Bandwidth Alert</b>
-
-🌐 <b>Domain:</b> %s
-📈 <b>Increase:</b> %.0f%%
-
-🖥️ <b>Server:</b> %s`,
-		domain,
-		percentIncrease,
-		t.getHostname(),
-	)
-
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateBandwidth, BandwidthMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		Increase:    percentIncrease,
+	})
 }
 
 // NotifyDeprovisioned sends a notification when a domain is removed
-func (t *TelegramNotifier) NotifyDeprovisioned(ctx context.Context, domain string) error {
+func (t *TelegramNotifier) NotifyDeprovisioned(ctx context.Context, domain string, user string) error {
 	if !t.shouldNotify("deprovisioned") {
 		return nil
 	}
 
-	message := fmt.Sprintf(`🗑️ <b>Domain Removed</b>
+	return t.notify(ctx, TemplateDeprovisioned, DeprovisionedMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		User:        user,
+	})
+}
 
-🌐 <b>Domain:</b> %s
-📍 <b>DNS Zone:</b> Deleted
-🚀 <b>CDN Pull Zone:</b> Deleted
+// NotifyAccountDeprovisioned sends a notification listing the outcome for
+// each domain of a deleted WHM account
+func (t *TelegramNotifier) NotifyAccountDeprovisioned(ctx context.Context, user string, domains []DeprovisionedDomain) error {
+	if !t.shouldNotify("deprovisioned") {
+		return nil
+	}
 
-🖥️ <b>Server:</b> %s`,
-		domain,
-		t.getHostname(),
-	)
+	failed := 0
+	for _, d := range domains {
+		if d.Error != "" {
+			failed++
+		}
+	}
 
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateAccountDeprovisioned, AccountDeprovisionedMessage{
+		MessageBase: t.base(),
+		User:        user,
+		Domains:     domains,
+		Failed:      failed,
+	})
 }
 
 // NotifySubdomainProvisioned sends a notification when a subdomain is provisioned
-func (t *TelegramNotifier) NotifySubdomainProvisioned(ctx context.Context, subdomain string, parent string, cdnHostname string) error {
+func (t *TelegramNotifier) NotifySubdomainProvisioned(ctx context.Context, subdomain string, parent string, user string, cdnHostname string) error {
 	if !t.shouldNotify("subdomain") {
 		return nil
 	}
 
-	message := fmt.Sprintf(`✅ <b>Subdomain Provisioned</b>
+	return t.notify(ctx, TemplateSubdomain, SubdomainMessage{
+		MessageBase: t.base(),
+		Subdomain:   subdomain,
+		Parent:      parent,
+		User:        user,
+		CDNHostname: cdnHostname,
+	})
+}
+
+// NotifyMaintenance sends a notification when maintenance mode starts or ends
+func (t *TelegramNotifier) NotifyMaintenance(ctx context.Context, active bool, reason string, queued int) error {
+	if !t.shouldNotify("maintenance") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateMaintenance, MaintenanceMessage{
+		MessageBase: t.base(),
+		Active:      active,
+		Reason:      reason,
+		Queued:      queued,
+	})
+}
+
+// NotifyBalance sends a notification when the Bunny balance drops below or
+// recovers above the configured threshold
+func (t *TelegramNotifier) NotifyBalance(ctx context.Context, low bool, balance, threshold float64, paused bool) error {
+	if !t.shouldNotify("balance") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateBalance, BalanceMessage{
+		MessageBase: t.base(),
+		Low:         low,
+		Balance:     balance,
+		Threshold:   threshold,
+		Paused:      paused,
+	})
+}
+
+// NotifyIncident sends the alert opening an incident, with an acknowledge
+// button as with SendIncident, or the message resolving it. Incidents the
+// scheduler opens are sent by the scheduler itself
+func (t *TelegramNotifier) NotifyIncident(ctx context.Context, msg IncidentMessage) error {
+	if !t.enabled {
+		return nil
+	}
+
+	msg.MessageBase = t.base()
+	message, err := t.Templates().Render(TemplateIncident, msg)
+	if err != nil {
+		t.logger.Error("failed to render telegram notification", zap.Error(err))
+		return err
+	}
+	category := templateCategories[TemplateIncident]
+	if msg.Resolved {
+		return t.SendRaw(ctx, category, message)
+	}
+	return t.SendIncident(ctx, category, message, msg.ID)
+}
+
+// NotifyTokenRotated sends a notification when a pull zone's token key is rotated
+func (t *TelegramNotifier) NotifyTokenRotated(ctx context.Context, domain string) error {
+	if !t.shouldNotify("token_rotated") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateTokenRotated, TokenRotatedMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+	})
+}
+
+// NotifyBypass sends a notification when a domain's DNS is switched to the
+// origin during a CDN emergency, or switched back
+func (t *TelegramNotifier) NotifyBypass(ctx context.Context, domain string, active bool, reason string) error {
+	if !t.shouldNotify("emergency") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateEmergency, EmergencyMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		Active:      active,
+		Reason:      reason,
+	})
+}
+
+// NotifyDrift sends a notification listing pull zones whose settings drifted
+// from their profile; drifted maps each domain to its drifted fields
+func (t *TelegramNotifier) NotifyDrift(ctx context.Context, drifted map[string][]string, fixed bool) error {
+	if !t.shouldNotify("drift") || len(drifted) == 0 {
+		return nil
+	}
+
+	domains := make([]DriftedDomain, 0, len(drifted))
+	for domain, fields := range drifted {
+		domains = append(domains, DriftedDomain{Domain: domain, Fields: fields})
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+
+	return t.notify(ctx, TemplateDrift, DriftMessage{
+		MessageBase: t.base(),
+		Domains:     domains,
+		Fixed:       fixed,
+	})
+}
+
+// NotifyCertificateExpiring sends a reminder that the certificate on a
+// hostname expires soon; managed certificates are issued by Bunny and should
+// have been renewed already
+func (t *TelegramNotifier) NotifyCertificateExpiring(ctx context.Context, hostname string, expires time.Time, daysLeft int, managed bool) error {
+	if !t.shouldNotify("certificate_expiry") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateCertificateExpiry, CertificateMessage{
+		MessageBase: t.base(),
+		Hostname:    hostname,
+		Expires:     expires,
+		DaysLeft:    daysLeft,
+		Managed:     managed,
+	})
+}
+
+// NotifySlowProvision sends an alert that a provisioning run took longer
+// than the target; steps maps step names to their durations
+func (t *TelegramNotifier) NotifySlowProvision(ctx context.Context, domain string, user string, duration, target time.Duration, steps map[string]time.Duration) error {
+	if !t.shouldNotify("slow_provision") {
+		return nil
+	}
+
+	times := make([]StepTime, 0, len(steps))
+	for name, d := range steps {
+		times = append(times, StepTime{Name: name, Duration: d})
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Duration > times[j].Duration })
+
+	return t.notify(ctx, TemplateSlowProvision, SlowProvisionMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		User:        user,
+		Duration:    duration,
+		Target:      target,
+		Steps:       times,
+	})
+}
+
+// NotifySLODegraded sends an alert that the p95 provisioning time rose by
+// more than threshold percent over the previous week
+func (t *TelegramNotifier) NotifySLODegraded(ctx context.Context, week, year, runs int, p95, previousP95 time.Duration, change, threshold float64) error {
+	if !t.shouldNotify("slo_degraded") {
+		return nil
+	}
+
+	return t.notify(ctx, TemplateSLODegraded, SLODegradedMessage{
+		MessageBase: t.base(),
+		Week:        week,
+		Year:        year,
+		Runs:        runs,
+		P95:         p95,
+		PreviousP95: previousP95,
+		Change:      change,
+		Threshold:   threshold,
+	})
+}
+
+// NotifyPackageChanged sends a notification that a WHM account moved to a
+// package whose CDN profile ranks higher (upgrade) or lower than before
+func (t *TelegramNotifier) NotifyPackageChanged(ctx context.Context, msg PackageChangedMessage) error {
+	if !t.shouldNotify("package_changed") {
+		return nil
+	}
 
-🌐 <b>Subdomain:</b> %s
-📍 <b>Parent Zone:</b> %s
-🚀 <b>CDN:</b> %s
+	msg.MessageBase = t.base()
+	return t.notify(ctx, TemplatePackageChanged, msg)
+}
 
-🖥️ <b>Server:</b> %s`,
-		subdomain,
-		parent,
-		cdnHostname,
-		t.getHostname(),
-	)
+// NotifyZoneDisabled sends an alert that Bunny stopped serving a managed DNS
+// zone, or a notification that it serves it again
+func (t *TelegramNotifier) NotifyZoneDisabled(ctx context.Context, domain string, zoneID int64, disabled bool, reason string) error {
+	if !t.shouldNotify("zone_disabled") {
+		return nil
+	}
 
-	return t.send(ctx, message)
+	return t.notify(ctx, TemplateZoneDisabled, ZoneDisabledMessage{
+		MessageBase: t.base(),
+		Domain:      domain,
+		ZoneID:      zoneID,
+		Disabled:    disabled,
+		Reason:      reason,
+	})
 }
 
-// SendRaw sends a raw message to Telegram (used by scheduler for summaries)
-func (t *TelegramNotifier) SendRaw(ctx context.Context, message string) error {
+// NotifyObserved sends what whm2bunny would have done with a domain while
+// provisioning is disabled
+func (t *TelegramNotifier) NotifyObserved(ctx context.Context, msg ObservedMessage) error {
+	if !t.shouldNotify("observed") {
+		return nil
+	}
+
+	msg.MessageBase = t.base()
+	return t.notify(ctx, TemplateObserved, msg)
+}
+
+// SendRaw sends a raw message to the chat of category (used by scheduler
+// for summaries)
+func (t *TelegramNotifier) SendRaw(ctx context.Context, category Category, message string) error {
 	if !t.enabled {
 		return nil
 	}
@@ -266,5 +588,31 @@ func (t *TelegramNotifier) SendRaw(ctx context.Context, message string) error {
 		message += "\n"
 	}
 
-	return t.send(ctx, message)
+	return t.send(ctx, category, message)
+}
+
+// SendDocument uploads a file to the chat of category, such as the full
+// report behind a summary
+func (t *TelegramNotifier) SendDocument(ctx context.Context, category Category, filename string, data []byte, caption string) error {
+	if !t.enabled {
+		return nil
+	}
+
+	route := t.route(category)
+	params := telego.SendDocumentParams{
+		ChatID:          telego.ChatID{ID: route.ChatID},
+		MessageThreadID: route.ThreadID,
+		Document:        tu.File(tu.NameReader(bytes.NewReader(data), filename)),
+		Caption:         caption,
+	}
+
+	if _, err := t.client.SendDocument(&params); err != nil {
+		t.logger.Error("failed to send telegram document",
+			zap.Error(err),
+			zap.String("filename", filename),
+		)
+		return err
+	}
+
+	return nil
 }
@@ -10,6 +10,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
 )
 
 func TestNewTelegramNotifier_Disabled(t *testing.T) {
@@ -95,13 +97,24 @@ func TestTelegramNotifier_ShouldNotify(t *testing.T) {
 	}
 }
 
-func TestTelegramNotifier_GetHostname(t *testing.T) {
+func TestTelegramNotifier_ServerName(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 	notifier := &TelegramNotifier{
 		logger: logger,
 	}
-	hostname := notifier.getHostname()
-	assert.NotEmpty(t, hostname)
+	assert.NotEmpty(t, notifier.serverName())
+
+	notifier.SetServer("cpanel-07")
+	assert.Equal(t, "cpanel-07", notifier.base().Server)
+}
+
+func TestTelegramNotifier_Clock(t *testing.T) {
+	notifier := &TelegramNotifier{}
+	assert.WithinDuration(t, time.Now(), notifier.base().Time, time.Minute)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	notifier.SetClock(clock.NewFake(now))
+	assert.Equal(t, now, notifier.base().Time)
 }
 
 func TestTelegramNotifier_NotifyMethods_Disabled(t *testing.T) {
@@ -121,13 +134,13 @@ func TestTelegramNotifier_NotifyMethods_Disabled(t *testing.T) {
 		{
 			name: "NotifySuccess",
 			fn: func() error {
-				return notifier.NotifySuccess(ctx, "example.com", 123456, "cdn.example.com", 3*time.Second)
+				return notifier.NotifySuccess(ctx, "example.com", "", 123456, "cdn.example.com", 3*time.Second)
 			},
 		},
 		{
 			name: "NotifyFailed",
 			fn: func() error {
-				return notifier.NotifyFailed(ctx, "example.com", "Create DNS Zone", "API error")
+				return notifier.NotifyFailed(ctx, "example.com", "", "Create DNS Zone", "API error")
 			},
 		},
 		{
@@ -145,13 +158,37 @@ func TestTelegramNotifier_NotifyMethods_Disabled(t *testing.T) {
 		{
 			name: "NotifyDeprovisioned",
 			fn: func() error {
-				return notifier.NotifyDeprovisioned(ctx, "example.com")
+				return notifier.NotifyDeprovisioned(ctx, "example.com", "")
 			},
 		},
 		{
 			name: "NotifySubdomainProvisioned",
 			fn: func() error {
-				return notifier.NotifySubdomainProvisioned(ctx, "blog.example.com", "example.com", "cdn.blog.example.com")
+				return notifier.NotifySubdomainProvisioned(ctx, "blog.example.com", "example.com", "", "cdn.blog.example.com")
+			},
+		},
+		{
+			name: "NotifyCertificateExpiring",
+			fn: func() error {
+				return notifier.NotifyCertificateExpiring(ctx, "www.example.com", time.Now().AddDate(0, 0, 7), 7, false)
+			},
+		},
+		{
+			name: "NotifyPackageChanged",
+			fn: func() error {
+				return notifier.NotifyPackageChanged(ctx, PackageChangedMessage{User: "exampleu", ToPackage: "premium_plan"})
+			},
+		},
+		{
+			name: "NotifyZoneDisabled",
+			fn: func() error {
+				return notifier.NotifyZoneDisabled(ctx, "example.com", 123456, true, "disabled")
+			},
+		},
+		{
+			name: "NotifyObserved",
+			fn: func() error {
+				return notifier.NotifyObserved(ctx, ObservedMessage{Domain: "example.com", Action: "provision"})
 			},
 		},
 	}
@@ -322,3 +359,37 @@ func TestParseChatID(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text    string
+		command string
+		args    []string
+		ok      bool
+	}{
+		{"/status example.com", "status", []string{"example.com"}, true},
+		{"/Purge@whm2bunny_bot  example.com /a.css", "purge", []string{"example.com", "/a.css"}, true},
+		{"/help", "help", []string{}, true},
+		{"hello", "", nil, false},
+		{"/", "", nil, false},
+		{"", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			command, args, ok := ParseCommand(tt.text)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.command, command)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestListenCommands_Disabled(t *testing.T) {
+	notifier := &TelegramNotifier{enabled: false}
+	err := notifier.ListenCommands(context.Background(), func(ctx context.Context, command string, args []string) string {
+		t.Fatal("disabled notifier must not handle commands")
+		return ""
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,746 @@
+package notifier
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+)
+
+//go:embed templates
+var defaultTemplateFS embed.FS
+
+// templateExt is the file extension of message templates
+const templateExt = ".tmpl"
+
+// Template names, one per notification and summary type. A template file is
+// named after its template, e.g. success.tmpl
+const (
+	TemplateSuccess              = "success"
+	TemplateRecovered            = "recovered"
+	TemplateFailed               = "failed"
+	TemplateSSL                  = "ssl"
+	TemplateBandwidth            = "bandwidth"
+	TemplateDeprovisioned        = "deprovisioned"
+	TemplateAccountDeprovisioned = "account_deprovisioned"
+	TemplateSubdomain            = "subdomain"
+	TemplateMaintenance          = "maintenance"
+	TemplateBalance              = "balance"
+	TemplateTokenRotated         = "token_rotated"
+	TemplateEmergency            = "emergency"
+	TemplateDrift                = "drift"
+	TemplateCertificateExpiry    = "certificate_expiry"
+	TemplateSlowProvision        = "slow_provision"
+	TemplateSLODegraded          = "slo_degraded"
+	TemplatePackageChanged       = "package_changed"
+	TemplateZoneDisabled         = "zone_disabled"
+	TemplateObserved             = "observed"
+	TemplateIncident             = "incident"
+	TemplateDailySummary         = "daily_summary"
+	TemplateWeeklySummary        = "weekly_summary"
+)
+
+// MessageBase holds the fields available to every template
+type MessageBase struct {
+	Server string
+	Time   time.Time
+}
+
+// SuccessMessage is the data of the success template
+type SuccessMessage struct {
+	MessageBase
+	Domain      string
+	User        string
+	ZoneID      int64
+	CDNHostname string
+	Duration    time.Duration
+}
+
+// RecoveredMessage is the data of the recovered template, sent instead of
+// success for a domain whose provisioning failed before; Attempts counts the
+// runs, this one included, and Since is the time from the first
+type RecoveredMessage struct {
+	MessageBase
+	Domain      string
+	User        string
+	ZoneID      int64
+	CDNHostname string
+	Duration    time.Duration
+	Attempts    int
+	Since       time.Duration
+}
+
+// FailedMessage is the data of the failed template
+type FailedMessage struct {
+	MessageBase
+	Domain string
+	User   string
+	Step   string
+	Error  string
+}
+
+// SSLMessage is the data of the ssl template
+type SSLMessage struct {
+	MessageBase
+	Domain  string
+	Issuer  string
+	Expires time.Time
+}
+
+// BandwidthMessage is the data of the bandwidth template; Current and
+// Previous are bytes per day and zero when unknown
+type BandwidthMessage struct {
+	MessageBase
+	Domain   string
+	Increase float64
+	Current  int64
+	Previous int64
+	// Incident is the ID of the incident the spike opened; empty when
+	// incidents are not tracked
+	Incident string
+}
+
+// DeprovisionedMessage is the data of the deprovisioned template
+type DeprovisionedMessage struct {
+	MessageBase
+	Domain string
+	User   string
+}
+
+// DeprovisionedDomain is one domain in the account_deprovisioned template;
+// Error is set when it could not be removed
+type DeprovisionedDomain struct {
+	Domain    string
+	Subdomain bool
+	Error     string
+}
+
+// AccountDeprovisionedMessage is the data of the account_deprovisioned
+// template
+type AccountDeprovisionedMessage struct {
+	MessageBase
+	User    string
+	Domains []DeprovisionedDomain
+	Failed  int
+}
+
+// SubdomainMessage is the data of the subdomain template
+type SubdomainMessage struct {
+	MessageBase
+	Subdomain   string
+	Parent      string
+	User        string
+	CDNHostname string
+}
+
+// MaintenanceMessage is the data of the maintenance template
+type MaintenanceMessage struct {
+	MessageBase
+	Active bool
+	Reason string
+	Queued int
+}
+
+// BalanceMessage is the data of the balance template
+type BalanceMessage struct {
+	MessageBase
+	Low       bool
+	Balance   float64
+	Threshold float64
+	Paused    bool
+}
+
+// TokenRotatedMessage is the data of the token_rotated template
+type TokenRotatedMessage struct {
+	MessageBase
+	Domain string
+}
+
+// EmergencyMessage is the data of the emergency template
+type EmergencyMessage struct {
+	MessageBase
+	Domain string
+	Active bool
+	Reason string
+}
+
+// DriftedDomain is one domain in the drift template
+type DriftedDomain struct {
+	Domain string
+	Fields []string
+}
+
+// DriftMessage is the data of the drift template
+type DriftMessage struct {
+	MessageBase
+	Domains []DriftedDomain
+	Fixed   bool
+}
+
+// CertificateMessage is the data of the certificate_expiry template
+type CertificateMessage struct {
+	MessageBase
+	Hostname string
+	Expires  time.Time
+	DaysLeft int
+	Managed  bool
+}
+
+// StepTime is the time a provisioning step took
+type StepTime struct {
+	Name     string
+	Duration time.Duration
+}
+
+// SlowProvisionMessage is the data of the slow_provision template; Steps
+// are slowest first
+type SlowProvisionMessage struct {
+	MessageBase
+	Domain   string
+	User     string
+	Duration time.Duration
+	Target   time.Duration
+	Steps    []StepTime
+}
+
+// IncidentMessage is the data of the incident template, sent when a hit
+// rate, origin health, traffic anomaly or Bunny API incident opens and when
+// any incident resolves. Kind is an incident kind such as "hit_rate"; Value and
+// Threshold are in percent
+type IncidentMessage struct {
+	MessageBase
+	ID        string
+	Kind      string
+	Zone      string
+	Value     float64
+	Threshold float64
+	Resolved  bool
+	// OpenedAt, Alerts and AckedBy describe a resolved incident
+	OpenedAt time.Time
+	Alerts   int
+	AckedBy  string
+}
+
+// OpenIncident is an unresolved incident listed in the daily summary
+type OpenIncident struct {
+	ID       string
+	Kind     string
+	Zone     string
+	OpenedAt time.Time
+	// AckedBy is empty while nobody acknowledged the incident
+	AckedBy string
+}
+
+// SLODegradedMessage is the data of the slo_degraded template; Change and
+// Threshold are in percent
+type SLODegradedMessage struct {
+	MessageBase
+	Week        int
+	Year        int
+	Runs        int
+	P95         time.Duration
+	PreviousP95 time.Duration
+	Change      float64
+	Threshold   float64
+}
+
+// PackageChangedMessage is the data of the package_changed template;
+// Upgrade is false for a downgrade
+type PackageChangedMessage struct {
+	MessageBase
+	User        string
+	Domains     []string
+	FromPackage string
+	ToPackage   string
+	FromProfile string
+	ToProfile   string
+	Upgrade     bool
+}
+
+// ZoneDisabledMessage is the data of the zone_disabled template; Disabled
+// is false once the zone is served again
+type ZoneDisabledMessage struct {
+	MessageBase
+	Domain   string
+	ZoneID   int64
+	Disabled bool
+	Reason   string
+}
+
+// ObservedMessage is the data of the observed template: what a webhook
+// event or a discovered domain would have done with provisioning enabled.
+// Action is provision or deprovision; SOAEmail is empty for subdomains
+type ObservedMessage struct {
+	MessageBase
+	Domain    string
+	Parent    string
+	User      string
+	Reseller  string
+	Package   string
+	Action    string
+	Source    string
+	Profile   string
+	PullZone  string
+	Hostnames []string
+	SOAEmail  string
+}
+
+// ProvisioningTimes is the provisioning time section of the weekly summary;
+// Change is the p95 change in percent, 0 without runs the week before.
+// Recovered counts the runs that succeeded after failing, taking AvgAttempts
+// runs and RecoveryP50 from the first on average
+type ProvisioningTimes struct {
+	Runs              int
+	P50               time.Duration
+	P95               time.Duration
+	PreviousP95       time.Duration
+	Change            float64
+	Slow              int
+	Target            time.Duration
+	Recovered         int
+	PreviousRecovered int
+	AvgAttempts       float64
+	MaxAttempts       int
+	RecoveryP50       time.Duration
+}
+
+// FailureCount is one line of the failure breakdown of the weekly summary:
+// the failed runs of an error class, with the change from the week before
+type FailureCount struct {
+	Class    string
+	Failures int
+	Previous int
+}
+
+// IdleDomain is a provisioned domain that served next to no traffic over
+// the idle window of the weekly summary
+type IdleDomain struct {
+	Name     string
+	User     string
+	Requests int64
+}
+
+// ZoneUsage is a pull zone's bandwidth in a summary, with its share of the
+// total in percent
+type ZoneUsage struct {
+	Name      string
+	User      string
+	Bandwidth int64
+	Share     float64
+}
+
+// DNSZoneUsage is the queries a DNS zone answered in a summary, with its
+// share of the total in percent
+type DNSZoneUsage struct {
+	Name    string
+	User    string
+	Queries int64
+	Share   float64
+}
+
+// ZoneErrors is a pull zone with a high 5xx rate in the daily summary
+type ZoneErrors struct {
+	Name     string
+	Rate     float64
+	Errors   int64
+	Requests int64
+	// OriginSide is true when the errors point at the origin, not the CDN edge
+	OriginSide bool
+	// OriginResponseTime is in milliseconds
+	OriginResponseTime float64
+}
+
+// QuotaUsage is one owner's provisioning quota usage; limits of 0 are unlimited
+type QuotaUsage struct {
+	Owner        string
+	Daily        int
+	DailyLimit   int
+	Monthly      int
+	MonthlyLimit int
+}
+
+// QuotaSummary is the quota section of the daily summary
+type QuotaSummary struct {
+	Global QuotaUsage
+	Owners []QuotaUsage
+}
+
+// RegionUsage is a region's bandwidth in the weekly summary
+type RegionUsage struct {
+	Name      string
+	Bandwidth int64
+	Share     float64
+}
+
+// DailySummaryMessage is the data of the daily_summary template
+type DailySummaryMessage struct {
+	MessageBase
+	Date         time.Time
+	Bandwidth    int64
+	Requests     int64
+	CacheHitRate float64
+	TopZones     []ZoneUsage
+	// ErrorThreshold is the 5xx rate (percent) at which zones are listed
+	ErrorThreshold float64
+	ErrorZones     []ZoneErrors
+	// Quota is nil when provisioning quotas are disabled
+	Quota *QuotaSummary
+	// Incidents are the unresolved incidents, oldest first
+	Incidents []OpenIncident
+}
+
+// WeeklySummaryMessage is the data of the weekly_summary template
+type WeeklySummaryMessage struct {
+	MessageBase
+	Week            int
+	Year            int
+	Bandwidth       int64
+	Requests        int64
+	CacheHitRate    float64
+	BandwidthChange float64
+	TopZones        []ZoneUsage
+	Regions         []RegionUsage
+	// OutsideAsia is the share of bandwidth (percent) served outside Asia+Oceania
+	OutsideAsia float64
+	// DNSQueries is the total of queries the managed DNS zones answered
+	DNSQueries int64
+	// TopDNSZones are the most queried DNS zones; empty when DNS statistics
+	// were not collected
+	TopDNSZones []DNSZoneUsage
+	// Provisioning is nil when provisioning times are not recorded
+	Provisioning *ProvisioningTimes
+	// Failures breaks the week's failed runs down by error class, most
+	// frequent first; empty without failures
+	Failures []FailureCount
+	// IdleCount domains served next to no traffic over the last IdleDays
+	// days; IdleDomains lists the first of them, quietest first
+	IdleDays    int
+	IdleCount   int
+	IdleDomains []IdleDomain
+}
+
+// templateSamples holds example data for every template; each template is
+// rendered with it on load so mistakes surface at startup, not on the first
+// notification
+var templateSamples = func() map[string]any {
+	base := MessageBase{Server: "server1", Time: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)}
+	expires := base.Time.AddDate(0, 3, 0)
+	zones := []ZoneUsage{{Name: "example.com", User: "exampleu", Bandwidth: 5 << 30, Share: 62.5}}
+
+	return map[string]any{
+		TemplateSuccess: SuccessMessage{base, "example.com", "exampleu", 123456, "morden-example-com.b-cdn.net", 3 * time.Second},
+		TemplateRecovered: RecoveredMessage{base, "example.com", "exampleu", 123456, "morden-example-com.b-cdn.net", 3 * time.Second,
+			3, 5 * time.Hour},
+		TemplateFailed:        FailedMessage{base, "example.com", "exampleu", "Create DNS Zone", "API error"},
+		TemplateSSL:           SSLMessage{base, "example.com", "Let's Encrypt", expires},
+		TemplateBandwidth:     BandwidthMessage{base, "example.com", 75, 45 << 30, 25 << 30, "INC-12"},
+		TemplateDeprovisioned: DeprovisionedMessage{base, "example.com", "exampleu"},
+		TemplateAccountDeprovisioned: AccountDeprovisionedMessage{base, "exampleu", []DeprovisionedDomain{
+			{Domain: "blog.example.com", Subdomain: true},
+			{Domain: "addon.com", Error: "API error"},
+			{Domain: "example.com"},
+		}, 1},
+		TemplateSubdomain:    SubdomainMessage{base, "blog.example.com", "example.com", "exampleu", "morden-blog.b-cdn.net"},
+		TemplateMaintenance:  MaintenanceMessage{base, true, "Bunny maintenance", 3},
+		TemplateBalance:      BalanceMessage{base, true, 4.5, 10, true},
+		TemplateTokenRotated: TokenRotatedMessage{base, "example.com"},
+		TemplateEmergency:    EmergencyMessage{base, "example.com", true, "CDN outage"},
+		TemplateDrift: DriftMessage{base, []DriftedDomain{
+			{Domain: "example.com", Fields: []string{"cache_ttl", "origin_shield"}},
+		}, false},
+		TemplateCertificateExpiry: CertificateMessage{base, "www.example.com", expires, 7, false},
+		TemplateSlowProvision: SlowProvisionMessage{base, "example.com", "exampleu", 3 * time.Minute, 2 * time.Minute, []StepTime{
+			{Name: "Create Pull Zone", Duration: 150 * time.Second},
+			{Name: "Create DNS Zone", Duration: 20 * time.Second},
+		}},
+		TemplateSLODegraded: SLODegradedMessage{base, 3, 2024, 42, 95 * time.Second, 60 * time.Second, 58.3, 25},
+		TemplatePackageChanged: PackageChangedMessage{base, "exampleu", []string{"example.com", "blog.example.com"},
+			"basic_plan", "premium_plan", "standard", "premium", true},
+		TemplateZoneDisabled: ZoneDisabledMessage{base, "example.com", 123456, true, "disabled"},
+		TemplateObserved: ObservedMessage{base, "example.com", "", "exampleu", "acme", "premium_plan", "provision", "webhook",
+			"premium", "morden-example-com", []string{"example.com", "cdn.example.com"}, "hostmaster@example.com"},
+		TemplateIncident: IncidentMessage{base, "INC-12", "hit_rate", "example.com", 32.5, 50, false, base.Time, 1, ""},
+		TemplateDailySummary: DailySummaryMessage{
+			MessageBase:    base,
+			Date:           base.Time,
+			Bandwidth:      8 << 30,
+			Requests:       1_200_000,
+			CacheHitRate:   94.5,
+			TopZones:       zones,
+			ErrorThreshold: 1,
+			ErrorZones:     []ZoneErrors{{Name: "example.com", Rate: 2.5, Errors: 250, Requests: 10_000, OriginSide: true, OriginResponseTime: 850}},
+			Quota: &QuotaSummary{
+				Global: QuotaUsage{Owner: "*", Daily: 3, DailyLimit: 100, Monthly: 40},
+				Owners: []QuotaUsage{{Owner: "reseller1", Daily: 2, DailyLimit: 20, Monthly: 30, MonthlyLimit: 200}},
+			},
+			Incidents: []OpenIncident{{ID: "INC-12", Kind: "origin_health", Zone: "example.com", OpenedAt: base.Time, AckedBy: "@alice"}},
+		},
+		TemplateWeeklySummary: WeeklySummaryMessage{
+			MessageBase:     base,
+			Week:            3,
+			Year:            2024,
+			Bandwidth:       56 << 30,
+			Requests:        8_400_000,
+			CacheHitRate:    93.2,
+			BandwidthChange: 15,
+			TopZones:        zones,
+			Regions:         []RegionUsage{{Name: "Asia & Oceania", Bandwidth: 50 << 30, Share: 89.3}},
+			OutsideAsia:     10.7,
+			DNSQueries:      1_250_000,
+			TopDNSZones:     []DNSZoneUsage{{Name: "example.com", User: "exampleu", Queries: 800_000, Share: 64}},
+			Provisioning: &ProvisioningTimes{
+				Runs: 42, P50: 35 * time.Second, P95: 95 * time.Second,
+				PreviousP95: 60 * time.Second, Change: 58.3, Slow: 2, Target: 2 * time.Minute,
+				Recovered: 3, PreviousRecovered: 1, AvgAttempts: 2.7, MaxAttempts: 4, RecoveryP50: 5 * time.Hour,
+			},
+			Failures:    []FailureCount{{Class: "rate_limit", Failures: 5, Previous: 1}, {Class: "origin", Failures: 2}},
+			IdleDays:    30,
+			IdleCount:   2,
+			IdleDomains: []IdleDomain{{Name: "old-promo.com", User: "exampleu"}, {Name: "parked.com", Requests: 12}},
+		},
+	}
+}()
+
+// TemplateNames returns the names of all message templates, sorted
+func TemplateNames() []string {
+	names := make([]string, 0, len(templateSamples))
+	for name := range templateSamples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateFuncs are the helper functions available in templates
+var templateFuncs = template.FuncMap{
+	// gb converts bytes to gigabytes
+	"gb": func(bytes int64) float64 { return float64(bytes) / (1024 * 1024 * 1024) },
+	// number formats a large count with K/M/B suffixes
+	"number": FormatNumber,
+	// quota formats a count against its limit (0 = unlimited)
+	"quota": func(used, limit int) string {
+		if limit <= 0 {
+			return fmt.Sprintf("%d", used)
+		}
+		return fmt.Sprintf("%d/%d", used, limit)
+	},
+	// change formats a percentage change with its sign
+	"change": func(pct float64) string {
+		if pct > 0 {
+			return fmt.Sprintf("+%.0f%%", pct)
+		}
+		return fmt.Sprintf("%.0f%%", pct)
+	},
+	// duration formats a duration as seconds under a minute, e.g. 4.2s,
+	// and to the second above, e.g. 2m5s
+	"duration": FormatDuration,
+	// date formats a time as YYYY-MM-DD
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	// wib formats a time in Western Indonesian Time (GMT+7)
+	"wib": func(t time.Time) string {
+		return t.In(time.FixedZone("WIB", 7*60*60)).Format("2006-01-02 15:04:05 WIB")
+	},
+	"join": strings.Join,
+	// inc adds one, for 1-based numbering in range loops
+	"inc": func(i int) int { return i + 1 },
+}
+
+// TemplateFuncs returns a copy of the helper functions available in
+// templates, so the email summary and the status page format figures the
+// same way as the messages
+func TemplateFuncs() template.FuncMap {
+	return maps.Clone(templateFuncs)
+}
+
+// FormatDuration formats a duration as seconds under a minute, e.g. 4.2s,
+// and to the second above, e.g. 2m5s
+func FormatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
+}
+
+// Templates renders notification and summary messages
+type Templates struct {
+	tmpl map[string]*template.Template
+}
+
+var (
+	defaultTemplatesOnce sync.Once
+	defaultTemplates     *Templates
+)
+
+// DefaultTemplates returns the built-in English templates
+func DefaultTemplates() *Templates {
+	defaultTemplatesOnce.Do(func() {
+		t, err := LoadTemplates(i18n.DefaultLocale, "")
+		if err != nil {
+			panic(fmt.Sprintf("invalid built-in templates: %v", err))
+		}
+		defaultTemplates = t
+	})
+	return defaultTemplates
+}
+
+// LoadTemplates loads the built-in templates of a locale ("" is English)
+// and replaces each one that has a file of the same name (e.g. success.tmpl)
+// in dir. An empty dir uses only the built-in templates. Every template is
+// rendered with sample data, so syntax errors, unknown fields and unknown
+// file names fail here
+func LoadTemplates(locale, dir string) (*Templates, error) {
+	localeDir, err := builtinDir(locale)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string, len(templateSamples))
+	for name := range templateSamples {
+		data, err := defaultTemplateFS.ReadFile(path.Join(localeDir, name+templateExt))
+		if err != nil {
+			return nil, fmt.Errorf("missing built-in template %s for locale %s: %w", name, locale, err)
+		}
+		sources[name] = string(data)
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read templates directory: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != templateExt {
+				continue
+			}
+			name := strings.TrimSuffix(entry.Name(), templateExt)
+			if _, ok := templateSamples[name]; !ok {
+				return nil, fmt.Errorf("unknown template %s (expected one of %s)",
+					entry.Name(), strings.Join(TemplateNames(), ", "))
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read template %s: %w", entry.Name(), err)
+			}
+			sources[name] = string(data)
+		}
+	}
+
+	t := &Templates{tmpl: make(map[string]*template.Template, len(sources))}
+	for name, src := range sources {
+		parsed, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", name, err)
+		}
+		t.tmpl[name] = parsed
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// builtinDir returns the embedded directory holding a locale's templates
+func builtinDir(locale string) (string, error) {
+	if locale == "" {
+		locale = i18n.DefaultLocale
+	}
+	dir := path.Join("templates", locale)
+	if _, err := fs.Stat(defaultTemplateFS, dir); err != nil {
+		return "", fmt.Errorf("no built-in templates for locale %q", locale)
+	}
+	return dir, nil
+}
+
+// Validate renders every template with sample data
+func (t *Templates) Validate() error {
+	var errs []error
+	for _, name := range TemplateNames() {
+		msg, err := t.Render(name, templateSamples[name])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if msg == "" {
+			errs = append(errs, fmt.Errorf("template %s renders an empty message", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Render renders the named template; surrounding whitespace is trimmed
+func (t *Templates) Render(name string, data any) (string, error) {
+	tmpl, ok := t.tmpl[name]
+	if !ok {
+		return "", fmt.Errorf("unknown template %s", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// WriteDefaultTemplates writes a locale's built-in templates to dir,
+// skipping files that already exist, and returns the names of the files
+// written
+func WriteDefaultTemplates(locale, dir string) ([]string, error) {
+	localeDir, err := builtinDir(locale)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create templates directory: %w", err)
+	}
+
+	var written []string
+	err = fs.WalkDir(defaultTemplateFS, localeDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		target := filepath.Join(dir, d.Name())
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		data, err := defaultTemplateFS.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		written = append(written, d.Name())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write templates: %w", err)
+	}
+	return written, nil
+}
+
+// FormatNumber formats a large number with K/M/B suffixes
+func FormatNumber(n int64) string {
+	if n >= 1_000_000_000 {
+		return fmt.Sprintf("%.2fB", float64(n)/1_000_000_000)
+	}
+	if n >= 1_000_000 {
+		return fmt.Sprintf("%.2fM", float64(n)/1_000_000)
+	}
+	if n >= 1_000 {
+		return fmt.Sprintf("%.2fK", float64(n)/1_000)
+	}
+	return fmt.Sprintf("%d", n)
+}
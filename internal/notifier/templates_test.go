@@ -0,0 +1,461 @@
+package notifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultTemplates(t *testing.T) {
+	templates := DefaultTemplates()
+	require.NoError(t, templates.Validate())
+
+	t.Run("success matches the original format", func(t *testing.T) {
+		msg, err := templates.Render(TemplateSuccess, SuccessMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			ZoneID:      123456,
+			CDNHostname: "morden-example-com.b-cdn.net",
+			Duration:    3 * time.Second,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, formatSuccessMessage("example.com", 123456, "morden-example-com.b-cdn.net", 3*time.Second, "server1"), msg)
+	})
+
+	t.Run("failed matches the original format", func(t *testing.T) {
+		now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+		msg, err := templates.Render(TemplateFailed, FailedMessage{
+			MessageBase: MessageBase{Server: "server1", Time: now},
+			Domain:      "example.com",
+			Step:        "Create DNS",
+			Error:       "Error 404",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, formatFailedMessage("example.com", "Create DNS", "Error 404", "server1", now), msg)
+	})
+
+	t.Run("subdomain matches the original format", func(t *testing.T) {
+		msg, err := templates.Render(TemplateSubdomain, SubdomainMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Subdomain:   "blog.example.com",
+			Parent:      "example.com",
+			CDNHostname: "morden-blog.b-cdn.net",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, formatSubdomainMessage("blog.example.com", "example.com", "morden-blog.b-cdn.net", "server1"), msg)
+	})
+
+	t.Run("package_changed names the direction", func(t *testing.T) {
+		msg, err := templates.Render(TemplatePackageChanged, PackageChangedMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			User:        "exampleu",
+			Domains:     []string{"example.com"},
+			FromPackage: "premium_plan",
+			ToPackage:   "basic_plan",
+			FromProfile: "premium",
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Package Downgraded")
+		assert.Contains(t, msg, "premium_plan → basic_plan")
+		assert.Contains(t, msg, "premium → none")
+	})
+
+	t.Run("zone_disabled explains a missing zone", func(t *testing.T) {
+		msg, err := templates.Render(TemplateZoneDisabled, ZoneDisabledMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			ZoneID:      42,
+			Disabled:    true,
+			Reason:      "missing",
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "DNS Zone Disabled")
+		assert.Contains(t, msg, "Zone no longer exists")
+	})
+
+	t.Run("incident names the problem until it resolves", func(t *testing.T) {
+		msg, err := templates.Render(TemplateIncident, IncidentMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			ID:          "INC-3",
+			Kind:        "origin_health",
+			Zone:        "example.com",
+			Value:       12.5,
+			Threshold:   5,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Origin Errors</b> - INC-3")
+		assert.Contains(t, msg, "12.5% of requests today")
+
+		msg, err = templates.Render(TemplateIncident, IncidentMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			ID:          "INC-3",
+			Kind:        "origin_health",
+			Zone:        "example.com",
+			Resolved:    true,
+			OpenedAt:    time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+			Alerts:      4,
+			AckedBy:     "@alice",
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Incident Resolved</b> - INC-3")
+		assert.Contains(t, msg, "(4 alerts)")
+		assert.Contains(t, msg, "Acknowledged by:</b> @alice")
+	})
+
+	t.Run("incident names the degraded Bunny API", func(t *testing.T) {
+		msg, err := templates.Render(TemplateIncident, IncidentMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			ID:          "INC-4",
+			Kind:        "bunny_api",
+			Zone:        "Bunny DNS API",
+			Value:       71.4,
+			Threshold:   95,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Bunny DNS API Degraded</b> - INC-4")
+		assert.Contains(t, msg, "71.4% of calls succeeded recently (objective 95.0%)")
+
+		msg, err = templates.Render(TemplateIncident, IncidentMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			ID:          "INC-4",
+			Kind:        "bunny_api",
+			Zone:        "Bunny DNS API",
+			Resolved:    true,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "API:</b> Bunny DNS API")
+		assert.NotContains(t, msg, "Domain:")
+	})
+
+	t.Run("daily_summary lists open incidents", func(t *testing.T) {
+		msg, err := templates.Render(TemplateDailySummary, DailySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Incidents: []OpenIncident{
+				{ID: "INC-1", Kind: "bandwidth", Zone: "example.com", OpenedAt: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)},
+				{ID: "INC-2", Kind: "hit_rate", Zone: "shop.com", AckedBy: "@alice"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "• INC-1 example.com - bandwidth spike since Jan 15 10:30, not acknowledged")
+		assert.Contains(t, msg, "INC-2 shop.com - low cache hit rate")
+		assert.Contains(t, msg, "acknowledged by @alice")
+	})
+
+	t.Run("observed shows the plan of a provision", func(t *testing.T) {
+		msg, err := templates.Render(TemplateObserved, ObservedMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			Action:      "provision",
+			Source:      "webhook",
+			Profile:     "premium",
+			PullZone:    "morden-example-com",
+			Hostnames:   []string{"example.com", "cdn.example.com"},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Would Provision Domain")
+		assert.Contains(t, msg, "example.com, cdn.example.com")
+		assert.NotContains(t, msg, "SOA Email")
+	})
+
+	t.Run("success names the owner when known", func(t *testing.T) {
+		msg, err := templates.Render(TemplateSuccess, SuccessMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			User:        "exampleu",
+			ZoneID:      123456,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "🌐 <b>Domain:</b> example.com\n👤 <b>User:</b> exampleu\n📍 <b>Zone ID:</b> 123456")
+	})
+
+	t.Run("drift lists each domain", func(t *testing.T) {
+		msg, err := templates.Render(TemplateDrift, DriftMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domains: []DriftedDomain{
+				{Domain: "a.com", Fields: []string{"cache_ttl"}},
+				{Domain: "b.com", Fields: []string{"origin_shield", "waf"}},
+			},
+			Fixed: true,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "• a.com: cache_ttl\n• b.com: origin_shield, waf\n\n🔧 <b>Action:</b> Corrected")
+	})
+
+	t.Run("account_deprovisioned itemizes domains", func(t *testing.T) {
+		msg, err := templates.Render(TemplateAccountDeprovisioned, AccountDeprovisionedMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			User:        "exampleu",
+			Domains: []DeprovisionedDomain{
+				{Domain: "blog.example.com", Subdomain: true},
+				{Domain: "addon.com", Error: "API error"},
+				{Domain: "example.com"},
+			},
+			Failed: 1,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "📋 <b>Domains:</b> 3 (1 failed)\n\n"+
+			"✅ blog.example.com (subdomain)\n❌ addon.com: API error\n✅ example.com\n\n🖥️")
+	})
+
+	t.Run("slow_provision lists steps", func(t *testing.T) {
+		msg, err := templates.Render(TemplateSlowProvision, SlowProvisionMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			Duration:    185 * time.Second,
+			Target:      2 * time.Minute,
+			Steps:       []StepTime{{Name: "Create Pull Zone", Duration: 170 * time.Second}, {Name: "Create DNS Zone", Duration: 4200 * time.Millisecond}},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "⏱️ <b>Duration:</b> 3m5s (target 2m0s)\n\n"+
+			"📋 <b>Steps:</b>\n• Create Pull Zone - 2m50s\n• Create DNS Zone - 4.2s\n\n🖥️")
+	})
+
+	t.Run("weekly_summary shows provisioning times when recorded", func(t *testing.T) {
+		msg, err := templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Provisioning: &ProvisioningTimes{
+				Runs: 12, P50: 30 * time.Second, P95: 90 * time.Second,
+				PreviousP95: 60 * time.Second, Change: 50, Target: 2 * time.Minute,
+			},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "⏱️ <b>Provisioning Time:</b> 12 runs\n• p50 30.0s, p95 1m30s (+50% vs last week)\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{MessageBase: MessageBase{Server: "server1"}})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "Provisioning Time")
+	})
+
+	t.Run("recovered counts the attempts", func(t *testing.T) {
+		msg, err := templates.Render(TemplateRecovered, RecoveredMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+			ZoneID:      123456,
+			Duration:    3 * time.Second,
+			Attempts:    3,
+			Since:       5 * time.Hour,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "🔁 <b>Domain Recovered</b>")
+		assert.Contains(t, msg, "⏱️ <b>Duration:</b> 3.00s\n🔄 Recovered after 3 attempts over 5h0m0s\n\n🖥️")
+	})
+
+	t.Run("weekly_summary shows recoveries", func(t *testing.T) {
+		msg, err := templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Provisioning: &ProvisioningTimes{
+				Runs: 12, P50: 30 * time.Second, P95: 90 * time.Second,
+				Recovered: 2, PreviousRecovered: 1, AvgAttempts: 2.5, MaxAttempts: 3, RecoveryP50: 2 * time.Hour,
+			},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "• p50 30.0s, p95 1m30s\n"+
+			"• 2 recovered after failing (1 last week), 2.5 attempts on average (max 3), p50 2h0m0s to recover\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase:  MessageBase{Server: "server1"},
+			Provisioning: &ProvisioningTimes{Runs: 12, PreviousRecovered: 1},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "• 0 recovered after failing (1 last week)\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase:  MessageBase{Server: "server1"},
+			Provisioning: &ProvisioningTimes{Runs: 12},
+		})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "recovered")
+	})
+
+	t.Run("weekly_summary breaks failures down by class", func(t *testing.T) {
+		msg, err := templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Failures:    []FailureCount{{Class: "network", Failures: 4, Previous: 1}, {Class: "auth", Failures: 1}},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "🧯 <b>Failure Breakdown:</b>\n• network - 4 (1 last week)\n• auth - 1 (0 last week)\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{MessageBase: MessageBase{Server: "server1"}})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "Failure Breakdown")
+	})
+
+	t.Run("weekly_summary lists idle domains", func(t *testing.T) {
+		msg, err := templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			IdleDays:    30,
+			IdleCount:   3,
+			IdleDomains: []IdleDomain{{Name: "parked.com", User: "parkedu"}, {Name: "promo.com", Requests: 12}},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "💤 <b>Idle Domains:</b> 3 with next to no traffic in 30 days\n"+
+			"• parked.com (parkedu) - 0 requests\n• promo.com - 12 requests\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{MessageBase: MessageBase{Server: "server1"}})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "Idle Domains")
+	})
+
+	t.Run("weekly_summary lists the most queried DNS zones", func(t *testing.T) {
+		msg, err := templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			DNSQueries:  1_500_000,
+			TopDNSZones: []DNSZoneUsage{{Name: "busy.com", User: "busyu", Queries: 1_200_000, Share: 80}},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "🧭 <b>DNS Queries:</b> 1.50M\n1. busy.com (busyu) - 1.20M (80%)\n\n🖥️")
+
+		msg, err = templates.Render(TemplateWeeklySummary, WeeklySummaryMessage{MessageBase: MessageBase{Server: "server1"}})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "DNS Queries")
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		_, err := templates.Render("nope", nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadTemplates(t *testing.T) {
+	writeTemplate := func(t *testing.T, dir, name, content string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	t.Run("empty dir uses built-in templates", func(t *testing.T) {
+		templates, err := LoadTemplates("", "")
+		require.NoError(t, err)
+		msg, err := templates.Render(TemplateDeprovisioned, DeprovisionedMessage{Domain: "example.com"})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Domain Removed")
+	})
+
+	t.Run("file replaces the built-in template", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "deprovisioned.tmpl", "Domain {{.Domain}} dihapus dari {{.Server}}\n")
+		writeTemplate(t, dir, "README.txt", "ignored")
+
+		templates, err := LoadTemplates("", dir)
+		require.NoError(t, err)
+
+		msg, err := templates.Render(TemplateDeprovisioned, DeprovisionedMessage{
+			MessageBase: MessageBase{Server: "server1"},
+			Domain:      "example.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Domain example.com dihapus dari server1", msg)
+
+		msg, err = templates.Render(TemplateTokenRotated, TokenRotatedMessage{Domain: "example.com"})
+		require.NoError(t, err)
+		assert.Contains(t, msg, "Token Key Rotated")
+	})
+
+	t.Run("unknown file name", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "sucess.tmpl", "{{.Domain}}")
+
+		_, err := LoadTemplates("", dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown template sucess.tmpl")
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "success.tmpl", "{{.Domain")
+
+		_, err := LoadTemplates("", dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid template success")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "ssl.tmpl", "{{.Domian}}")
+
+		_, err := LoadTemplates("", dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ssl")
+	})
+
+	t.Run("empty message", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplate(t, dir, "balance.tmpl", "{{if false}}x{{end}}\n")
+
+		_, err := LoadTemplates("", dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "empty message")
+	})
+
+	t.Run("locale uses its built-in templates", func(t *testing.T) {
+		templates, err := LoadTemplates("id", "")
+		require.NoError(t, err)
+		msg, err := templates.Render(TemplateDeprovisioned, DeprovisionedMessage{Domain: "example.com"})
+		require.NoError(t, err)
+		assert.NotContains(t, msg, "Domain Removed")
+		assert.Contains(t, msg, "example.com")
+	})
+
+	t.Run("unsupported locale", func(t *testing.T) {
+		_, err := LoadTemplates("fr", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "fr")
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := LoadTemplates("", filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+}
+
+func TestWriteDefaultTemplates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "success.tmpl"), []byte("custom {{.Domain}}"), 0644))
+
+	written, err := WriteDefaultTemplates("", dir)
+	require.NoError(t, err)
+	assert.Len(t, written, len(TemplateNames())-1)
+	assert.NotContains(t, written, "success.tmpl")
+
+	data, err := os.ReadFile(filepath.Join(dir, "success.tmpl"))
+	require.NoError(t, err)
+	assert.Equal(t, "custom {{.Domain}}", string(data))
+
+	_, err = LoadTemplates("", dir)
+	assert.NoError(t, err)
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    int64
+		expected string
+	}{
+		{"billions", 1_500_000_000, "1.50B"},
+		{"millions", 2_500_000, "2.50M"},
+		{"thousands", 12_500, "12.50K"},
+		{"hundreds", 500, "500"},
+		{"zero", 0, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FormatNumber(tt.input))
+		})
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	funcs := TemplateFuncs()
+	for _, name := range []string{"gb", "number", "change"} {
+		assert.Contains(t, funcs, name)
+	}
+	assert.Equal(t, "+12%", funcs["change"].(func(float64) string)(12.4))
+
+	// The copy can be changed without changing the message templates
+	delete(funcs, "gb")
+	assert.Contains(t, TemplateFuncs(), "gb")
+}
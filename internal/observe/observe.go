@@ -0,0 +1,173 @@
+// Package observe records what whm2bunny would have provisioned or removed
+// while provisioning is disabled, so a trial on a production server shows
+// what automation would do before it is enabled
+package observe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Action is what whm2bunny would have done with a domain
+type Action string
+
+const (
+	// ActionProvision is a domain that would have been provisioned
+	ActionProvision Action = "provision"
+	// ActionDeprovision is a domain that would have been removed
+	ActionDeprovision Action = "deprovision"
+)
+
+// Sources a domain is observed from
+const (
+	// SourceWebhook is a WHM hook event
+	SourceWebhook = "webhook"
+	// SourceDiscovery is a cPanel domain without a provisioning state,
+	// found by polling the cPanel domain list
+	SourceDiscovery = "discovery"
+)
+
+// Observation is what whm2bunny would have done with one domain, and the
+// plan it would have followed
+type Observation struct {
+	Domain       string `json:"domain"`
+	ParentDomain string `json:"parent_domain,omitempty"`
+	User         string `json:"user,omitempty"`
+	Reseller     string `json:"reseller,omitempty"`
+	Package      string `json:"package,omitempty"`
+	Action       Action `json:"action"`
+	Source       string `json:"source"`
+
+	// Profile is the CDN profile the pull zone would get
+	Profile string `json:"profile"`
+	// PullZone is the name of the pull zone
+	PullZone string `json:"pull_zone"`
+	// Hostnames are the hostnames that would route through the CDN
+	Hostnames []string `json:"hostnames,omitempty"`
+	// SOAEmail is the SOA email of the DNS zone; empty for subdomains,
+	// which use their parent's zone
+	SOAEmail string `json:"soa_email,omitempty"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Events counts the events seen for the domain
+	Events int `json:"events"`
+}
+
+// Store persists observations to a JSON file, keyed by domain
+type Store struct {
+	file         *filestore.File[map[string]*Observation]
+	observations map[string]*Observation
+	mu           sync.Mutex
+	logger       *zap.Logger
+	now          func() time.Time
+}
+
+// NewStore creates a store persisting observations to filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "observation", 0644, func() map[string]*Observation {
+		return make(map[string]*Observation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	if err := s.file.Reload(&s.observations); err != nil {
+		return nil, fmt.Errorf("failed to load observations: %w", err)
+	}
+	return s, nil
+}
+
+// Get returns the observation of domain
+func (s *Store) Get(domain string) (Observation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.observations[strings.ToLower(domain)]
+	if !ok {
+		return Observation{}, false
+	}
+	return *o, true
+}
+
+// Update changes the observation of domain with fn, creating it first seen
+// now if it is new, and returns it as saved
+func (s *Store) Update(domain string, fn func(o *Observation)) (Observation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domain = strings.ToLower(domain)
+	now := s.now()
+	o, ok := s.observations[domain]
+	if !ok {
+		o = &Observation{Domain: domain, FirstSeen: now}
+	}
+	updated := *o
+	fn(&updated)
+	updated.Domain = domain
+	updated.LastSeen = now
+
+	s.observations[domain] = &updated
+	if err := s.file.Save(s.observations); err != nil {
+		if ok {
+			s.observations[domain] = o
+		} else {
+			delete(s.observations, domain)
+		}
+		return Observation{}, err
+	}
+	return updated, nil
+}
+
+// List returns the observations, first seen first
+func (s *Store) List() []Observation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Observation, 0, len(s.observations))
+	for _, o := range s.observations {
+		list = append(list, *o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].FirstSeen.Equal(list[j].FirstSeen) {
+			return list[i].FirstSeen.Before(list[j].FirstSeen)
+		}
+		return list[i].Domain < list[j].Domain
+	})
+	return list
+}
+
+// Clear removes every observation and returns how many there were
+func (s *Store) Clear() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.observations)
+	if n == 0 {
+		return 0, nil
+	}
+	previous := s.observations
+	s.observations = make(map[string]*Observation)
+	if err := s.file.Save(s.observations); err != nil {
+		s.observations = previous
+		return 0, err
+	}
+	return n, nil
+}
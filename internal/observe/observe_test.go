@@ -0,0 +1,77 @@
+package observe
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_Update(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "observed.json")
+	s, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	first := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return first }
+
+	_, ok := s.Get("example.com")
+	assert.False(t, ok)
+
+	o, err := s.Update("Example.com", func(o *Observation) {
+		o.Action = ActionProvision
+		o.Events++
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", o.Domain)
+	assert.Equal(t, first, o.FirstSeen)
+	assert.Equal(t, 1, o.Events)
+
+	later := first.Add(time.Hour)
+	s.now = func() time.Time { return later }
+	o, err = s.Update("example.com", func(o *Observation) {
+		o.Action = ActionDeprovision
+		o.Events++
+	})
+	require.NoError(t, err)
+	assert.Equal(t, first, o.FirstSeen)
+	assert.Equal(t, later, o.LastSeen)
+	assert.Equal(t, 2, o.Events)
+
+	// Observations survive a restart
+	reopened, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	got, ok := reopened.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, ActionDeprovision, got.Action)
+	assert.Equal(t, 2, got.Events)
+}
+
+func TestStore_ListAndClear(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "observed.json"), nil)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for _, domain := range []string{"b.example", "a.example", "c.example"} {
+		s.now = func() time.Time { return now }
+		if domain == "c.example" {
+			s.now = func() time.Time { return now.Add(-time.Hour) }
+		}
+		_, err := s.Update(domain, func(o *Observation) { o.Action = ActionProvision })
+		require.NoError(t, err)
+	}
+
+	var domains []string
+	for _, o := range s.List() {
+		domains = append(domains, o.Domain)
+	}
+	assert.Equal(t, []string{"c.example", "a.example", "b.example"}, domains)
+
+	n, err := s.Clear()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Empty(t, s.List())
+}
@@ -0,0 +1,206 @@
+package overrides
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Override holds per-domain settings that take precedence over the domain's profile
+// Nil fields fall back to the profile. The YAML form is used by domains.yaml
+type Override struct {
+	Optimizer *bool `json:"optimizer,omitempty" yaml:"optimizer,omitempty"`
+
+	// Tier is the pull zone tier ("standard" or "volume") set by convert-zone
+	Tier string `json:"tier,omitempty" yaml:"tier,omitempty"`
+
+	// Profile names the CDN profile used instead of the one of the
+	// domain's package
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+
+	// OriginIP replaces origin.ip for the domain and its subdomains, for
+	// domains hosted on another server
+	OriginIP string `json:"origin_ip,omitempty" yaml:"origin_ip,omitempty"`
+
+	// GeoZones are the geo zones the pull zone is created with, see
+	// bunny.GeoZones; empty serves from Asia and Oceania only
+	GeoZones []string `json:"geo_zones,omitempty" yaml:"geo_zones,omitempty"`
+
+	// MailPreset selects the MX and SPF records of a new DNS zone, see
+	// MailPresets; empty is MailPresetCPanel
+	MailPreset string `json:"mail_preset,omitempty" yaml:"mail_preset,omitempty"`
+
+	// Origin connection; empty or nil fields fall back to the profile
+	OriginProtocol   string `json:"origin_protocol,omitempty" yaml:"origin_protocol,omitempty"`
+	OriginPort       *int   `json:"origin_port,omitempty" yaml:"origin_port,omitempty"`
+	OriginVerifySSL  *bool  `json:"origin_verify_ssl,omitempty" yaml:"origin_verify_ssl,omitempty"`
+	OriginHostHeader string `json:"origin_host_header,omitempty" yaml:"origin_host_header,omitempty"`
+
+	// Referrers are added to the profile's lists
+	AllowedReferrers  []string `json:"allowed_referrers,omitempty" yaml:"allowed_referrers,omitempty"`
+	BlockedReferrers  []string `json:"blocked_referrers,omitempty" yaml:"blocked_referrers,omitempty"`
+	HotlinkProtection *bool    `json:"hotlink_protection,omitempty" yaml:"hotlink_protection,omitempty"`
+
+	// Access rules are set per domain only
+	BlockedCountries []string `json:"blocked_countries,omitempty" yaml:"blocked_countries,omitempty"`
+	BlockedIPs       []string `json:"blocked_ips,omitempty" yaml:"blocked_ips,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at" yaml:"-"`
+}
+
+// Mail presets select the MX and SPF records of a new DNS zone
+const (
+	MailPresetCPanel    = "cpanel"    // mail.<domain> on the origin
+	MailPresetGoogle    = "google"    // Google Workspace
+	MailPresetMicrosoft = "microsoft" // Microsoft 365
+	MailPresetNone      = "none"      // No MX, and an SPF record allowing no sender
+)
+
+// MailPresets lists every mail preset
+var MailPresets = []string{MailPresetCPanel, MailPresetGoogle, MailPresetMicrosoft, MailPresetNone}
+
+// Validate checks the fields with a fixed set of values
+func (o Override) Validate() error {
+	if _, err := bunny.ParsePullZoneType(o.Tier); err != nil {
+		return fmt.Errorf("tier: %w", err)
+	}
+	if o.OriginIP != "" && net.ParseIP(o.OriginIP) == nil {
+		return fmt.Errorf("origin_ip must be an IP address, got %q", o.OriginIP)
+	}
+	if _, err := bunny.ParseGeoZones(o.GeoZones); err != nil {
+		return fmt.Errorf("geo_zones: %w", err)
+	}
+	if o.MailPreset != "" && !slices.Contains(MailPresets, o.MailPreset) {
+		return fmt.Errorf("mail_preset must be one of %s, got %q", strings.Join(MailPresets, ", "), o.MailPreset)
+	}
+	switch o.OriginProtocol {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("origin_protocol must be http or https, got %q", o.OriginProtocol)
+	}
+	if o.OriginPort != nil && (*o.OriginPort < 1 || *o.OriginPort > 65535) {
+		return fmt.Errorf("origin_port must be between 1 and 65535, got %d", *o.OriginPort)
+	}
+	return nil
+}
+
+// Manager persists per-domain overrides
+type Manager struct {
+	file      *filestore.File[map[string]Override]
+	overrides map[string]Override
+	mu        sync.Mutex
+	logger    *zap.Logger
+}
+
+// NewManager creates an override manager backed by filePath
+func NewManager(filePath string, logger *zap.Logger) (*Manager, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "overrides", 0644, func() map[string]Override {
+		return make(map[string]Override)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		file:   file,
+		logger: logger,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.file.Reload(&m.overrides); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Get returns the override for a domain
+func (m *Manager) Get(domain string) (Override, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Reload(&m.overrides); err != nil {
+		m.logger.Warn("Failed to reload overrides, using cached copy", zap.Error(err))
+	}
+
+	o, ok := m.overrides[domainname.Normalize(domain)]
+	return o, ok
+}
+
+// Update applies fn to a domain's override and persists the result
+func (m *Manager) Update(domain string, fn func(o *Override)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Reload(&m.overrides); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	o := m.overrides[key]
+	fn(&o)
+	o.UpdatedAt = time.Now()
+	m.overrides[key] = o
+
+	return m.file.Save(m.overrides)
+}
+
+// Delete removes a domain's override
+func (m *Manager) Delete(domain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Reload(&m.overrides); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	if _, ok := m.overrides[key]; !ok {
+		return nil
+	}
+	delete(m.overrides, key)
+
+	return m.file.Save(m.overrides)
+}
+
+// Domains returns all domains with an override, sorted
+func (m *Manager) Domains() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Reload(&m.overrides); err != nil {
+		m.logger.Warn("Failed to reload overrides, using cached copy", zap.Error(err))
+	}
+
+	domains := make([]string, 0, len(m.overrides))
+	for d := range m.overrides {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// Bool returns a pointer to b, for setting optional override fields
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Int returns a pointer to i, for setting optional override fields
+func Int(i int) *int {
+	return &i
+}
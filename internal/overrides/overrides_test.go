@@ -0,0 +1,81 @@
+package overrides
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestManager_UpdateAndGet(t *testing.T) {
+	m, err := NewManager(filepath.Join(t.TempDir(), "overrides.json"), zap.NewNop())
+	require.NoError(t, err)
+
+	_, ok := m.Get("example.com")
+	assert.False(t, ok)
+
+	require.NoError(t, m.Update("Example.COM.", func(o *Override) {
+		o.Optimizer = Bool(true)
+	}))
+
+	o, ok := m.Get("example.com")
+	require.True(t, ok)
+	require.NotNil(t, o.Optimizer)
+	assert.True(t, *o.Optimizer)
+	assert.False(t, o.UpdatedAt.IsZero())
+	assert.Equal(t, []string{"example.com"}, m.Domains())
+
+	require.NoError(t, m.Delete("example.com"))
+	_, ok = m.Get("example.com")
+	assert.False(t, ok)
+}
+
+func TestManager_Referrers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	m, err := NewManager(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, m.Update("example.com", func(o *Override) {
+		o.AllowedReferrers = []string{"partner.com"}
+		o.BlockedReferrers = []string{"bad.example"}
+		o.HotlinkProtection = Bool(true)
+	}))
+
+	reloaded, err := NewManager(path, zap.NewNop())
+	require.NoError(t, err)
+
+	o, ok := reloaded.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, []string{"partner.com"}, o.AllowedReferrers)
+	assert.Equal(t, []string{"bad.example"}, o.BlockedReferrers)
+	require.NotNil(t, o.HotlinkProtection)
+	assert.True(t, *o.HotlinkProtection)
+	assert.Nil(t, o.Optimizer)
+}
+
+func TestManager_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	server, err := NewManager(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewManager(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Update("example.com", func(o *Override) {
+		o.Optimizer = Bool(false)
+	}))
+
+	o, ok := server.Get("example.com")
+	require.True(t, ok)
+	require.NotNil(t, o.Optimizer)
+	assert.False(t, *o.Optimizer)
+}
+
+func TestOverride_Validate(t *testing.T) {
+	assert.NoError(t, Override{OriginIP: "2001:db8::1", GeoZones: []string{"ASIA", "eu"}, MailPreset: MailPresetGoogle}.Validate())
+
+	assert.ErrorContains(t, Override{OriginIP: "example.com"}.Validate(), "origin_ip")
+	assert.ErrorContains(t, Override{GeoZones: []string{"oceania"}}.Validate(), "geo_zones")
+	assert.ErrorContains(t, Override{MailPreset: "fax"}.Validate(), "mail_preset")
+}
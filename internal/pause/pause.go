@@ -0,0 +1,152 @@
+// Package pause tracks whether an administrator paused the provisioning
+// pipeline: webhooks are still accepted and queued, but no queued event is
+// started until the pipeline is resumed
+package pause
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// DefaultPollInterval is how often Watch re-reads the pause file, which
+// bounds how long a resume from the CLI takes to reach the server
+const DefaultPollInterval = 5 * time.Second
+
+// Status is whether the pipeline is paused, and by whom and why
+type Status struct {
+	Paused bool      `json:"paused"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since,omitzero"`
+	// ChangedAt is when the pipeline was last paused or resumed
+	ChangedAt time.Time `json:"changed_at,omitzero"`
+}
+
+// Store persists the pause of the pipeline
+type Store struct {
+	file   *filestore.File[Status]
+	status Status
+	mu     sync.Mutex
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewStore creates a pause store backed by filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "pause", 0644, func() Status { return Status{} })
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.status); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Pause pauses the pipeline; pausing it again only updates the reason
+// and who paused it
+func (s *Store) Pause(reason, by string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.status); err != nil {
+		return Status{}, err
+	}
+
+	now := s.now()
+	since := s.status.Since
+	if !s.status.Paused {
+		since = now
+	}
+	s.status = Status{Paused: true, Reason: reason, By: by, Since: since, ChangedAt: now}
+	if err := s.file.Save(s.status); err != nil {
+		return Status{}, err
+	}
+
+	s.logger.Info("Provisioning paused", zap.String("reason", reason), zap.String("by", by))
+	return s.status, nil
+}
+
+// Resume resumes the pipeline, returning the pause it ended
+func (s *Store) Resume() (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.status); err != nil {
+		return Status{}, err
+	}
+
+	prev := s.status
+	if !prev.Paused {
+		return prev, nil
+	}
+	s.status = Status{ChangedAt: s.now()}
+	if err := s.file.Save(s.status); err != nil {
+		return Status{}, err
+	}
+
+	s.logger.Info("Provisioning resumed", zap.Duration("paused_for", s.now().Sub(prev.Since)))
+	return prev, nil
+}
+
+// Status returns whether the pipeline is paused
+func (s *Store) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.status); err != nil {
+		s.logger.Warn("Failed to reload pause state, using cached copy", zap.Error(err))
+	}
+	return s.status
+}
+
+// Paused reports whether queued events must wait
+func (s *Store) Paused() bool {
+	return s.Status().Paused
+}
+
+// Watch polls the pause file until ctx is cancelled and calls fn whenever
+// the pipeline is paused or resumed, whether by this process or another.
+// A pause and resume between two polls is reported too, with neither prev
+// nor cur paused
+func (s *Store) Watch(ctx context.Context, interval time.Duration, fn func(prev, cur Status)) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	prev := s.Status()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := s.Status()
+			if !cur.ChangedAt.Equal(prev.ChangedAt) {
+				fn(prev, cur)
+			}
+			prev = cur
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pause
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStore_PauseResume(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "pause.json"), zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, s.Paused())
+
+	first, err := s.Pause("Bunny maintenance", "alice")
+	require.NoError(t, err)
+	assert.True(t, first.Paused)
+	assert.False(t, first.Since.IsZero())
+
+	// Pausing again keeps when the pause started
+	again, err := s.Pause("bad template", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bad template", again.Reason)
+	assert.Equal(t, "bob", again.By)
+	assert.True(t, again.Since.Equal(first.Since))
+
+	prev, err := s.Resume()
+	require.NoError(t, err)
+	assert.True(t, prev.Paused)
+	assert.Equal(t, "bad template", prev.Reason)
+	assert.False(t, s.Paused())
+
+	prev, err = s.Resume()
+	require.NoError(t, err)
+	assert.False(t, prev.Paused, "resuming twice is a no-op")
+}
+
+func TestStore_WatchSeesPauseAndResumeBetweenPolls(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pause.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	changes := make(chan Status, 1)
+	go server.Watch(t.Context(), 200*time.Millisecond, func(prev, cur Status) { changes <- cur })
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = cli.Pause("", "")
+	require.NoError(t, err)
+	_, err = cli.Resume()
+	require.NoError(t, err)
+
+	select {
+	case cur := <-changes:
+		assert.False(t, cur.Paused)
+	case <-time.After(2 * time.Second):
+		t.Fatal("pause and resume not noticed")
+	}
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pause.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = cli.Pause("investigating", "cli")
+	require.NoError(t, err)
+
+	status := server.Status()
+	assert.True(t, status.Paused)
+	assert.Equal(t, "investigating", status.Reason)
+}
+
+func TestStore_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pause.json")
+	server, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+	cli, err := NewStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	changes := make(chan Status, 2)
+	ctx := t.Context()
+	go server.Watch(ctx, 10*time.Millisecond, func(prev, cur Status) { changes <- cur })
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = cli.Pause("", "")
+	require.NoError(t, err)
+	select {
+	case cur := <-changes:
+		assert.True(t, cur.Paused)
+	case <-time.After(2 * time.Second):
+		t.Fatal("pause not noticed")
+	}
+
+	_, err = cli.Resume()
+	require.NoError(t, err)
+	select {
+	case cur := <-changes:
+		assert.False(t, cur.Paused)
+	case <-time.After(2 * time.Second):
+		t.Fatal("resume not noticed")
+	}
+}
@@ -0,0 +1,305 @@
+// Package propagation checks whether DNS records served by Bunny's
+// nameservers are visible through public resolvers
+package propagation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a single query to one resolver
+const DefaultTimeout = 5 * time.Second
+
+// Record types that can be checked
+const (
+	TypeA     = "A"
+	TypeAAAA  = "AAAA"
+	TypeCNAME = "CNAME"
+	TypeMX    = "MX"
+	TypeNS    = "NS"
+	TypeTXT   = "TXT"
+)
+
+// Resolver is a DNS server records are queried from
+type Resolver struct {
+	Name string
+	Addr string // host:port
+	// Authoritative resolvers serve the zone itself; the first of them
+	// that answers gives the answer the others are compared with
+	Authoritative bool
+}
+
+// PublicResolvers are the recursive resolvers checked by default
+var PublicResolvers = []Resolver{
+	{Name: "Cloudflare", Addr: "1.1.1.1:53"},
+	{Name: "Google", Addr: "8.8.8.8:53"},
+	{Name: "Quad9", Addr: "9.9.9.9:53"},
+}
+
+// BunnyNameservers are the nameservers Bunny DNS serves zones from
+var BunnyNameservers = []Resolver{
+	{Name: "coco.bunny.net", Addr: "coco.bunny.net:53", Authoritative: true},
+	{Name: "kiki.bunny.net", Addr: "kiki.bunny.net:53", Authoritative: true},
+}
+
+// Query is one record looked up on every resolver
+type Query struct {
+	Type string
+	Name string
+}
+
+// String returns the query as "TYPE name"
+func (q Query) String() string {
+	return q.Type + " " + q.Name
+}
+
+// Answer is what one resolver returned for a query
+// Values are sorted, with hostnames in lower case and without trailing
+// dot; a name that does not exist has no values and no error
+type Answer struct {
+	Resolver Resolver
+	Values   []string
+	Err      error
+}
+
+// Result is the answers of every resolver to one query
+type Result struct {
+	Query    Query
+	Expected []string // Answer of the first authoritative resolver that answered
+	Answers  []Answer
+	// Authoritative is false when no authoritative resolver answered, in
+	// which case Expected is empty and the result cannot be propagated
+	Authoritative bool
+}
+
+// Matches reports whether an answer agrees with the authoritative one
+func (r Result) Matches(a Answer) bool {
+	return r.Authoritative && a.Err == nil && slices.Equal(a.Values, r.Expected)
+}
+
+// Propagated reports whether every resolver returned the authoritative answer
+func (r Result) Propagated() bool {
+	if !r.Authoritative {
+		return false
+	}
+	for _, a := range r.Answers {
+		if !r.Matches(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// Report is the result of checking a set of queries
+type Report struct {
+	Results   []Result
+	CheckedAt time.Time
+	Attempts  int // Checks run before the report, more than one while waiting for convergence
+}
+
+// Propagated reports whether every query has propagated
+func (r *Report) Propagated() bool {
+	for _, result := range r.Results {
+		if !result.Propagated() {
+			return false
+		}
+	}
+	return true
+}
+
+// Pending returns the queries that have not propagated yet
+func (r *Report) Pending() []Query {
+	var pending []Query
+	for _, result := range r.Results {
+		if !result.Propagated() {
+			pending = append(pending, result.Query)
+		}
+	}
+	return pending
+}
+
+// lookupFunc queries one resolver for a record
+type lookupFunc func(ctx context.Context, resolver Resolver, query Query) ([]string, error)
+
+// DialFunc connects to a resolver
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Checker queries a set of resolvers and compares their answers
+type Checker struct {
+	resolvers []Resolver
+	timeout   time.Duration
+	dial      DialFunc
+	lookup    lookupFunc
+}
+
+// Option configures a Checker
+type Option func(*Checker)
+
+// WithDialer connects to resolvers with dial, such as one tunnelling
+// through an egress proxy
+func WithDialer(dial DialFunc) Option {
+	return func(c *Checker) {
+		c.dial = dial
+	}
+}
+
+// NewChecker creates a checker querying Bunny's nameservers and the public
+// resolvers
+func NewChecker(opts ...Option) *Checker {
+	var d net.Dialer
+	c := &Checker{
+		resolvers: append(slices.Clone(BunnyNameservers), PublicResolvers...),
+		timeout:   DefaultTimeout,
+		dial:      d.DialContext,
+	}
+	c.lookup = c.query
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Resolvers returns the resolvers queried, authoritative ones first
+func (c *Checker) Resolvers() []Resolver {
+	return slices.Clone(c.resolvers)
+}
+
+// Check queries every resolver for every query once
+func (c *Checker) Check(ctx context.Context, queries []Query) *Report {
+	report := &Report{
+		Results:   make([]Result, len(queries)),
+		CheckedAt: time.Now(),
+		Attempts:  1,
+	}
+
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		report.Results[i] = Result{Query: query, Answers: make([]Answer, len(c.resolvers))}
+		for j, resolver := range c.resolvers {
+			wg.Add(1)
+			go func(answer *Answer) {
+				defer wg.Done()
+				lookupCtx, cancel := context.WithTimeout(ctx, c.timeout)
+				defer cancel()
+				values, err := c.lookup(lookupCtx, resolver, query)
+				*answer = Answer{Resolver: resolver, Values: normalize(values), Err: err}
+			}(&report.Results[i].Answers[j])
+		}
+	}
+	wg.Wait()
+
+	for i := range report.Results {
+		result := &report.Results[i]
+		for _, a := range result.Answers {
+			if a.Resolver.Authoritative && a.Err == nil {
+				result.Expected = a.Values
+				result.Authoritative = true
+				break
+			}
+		}
+	}
+	return report
+}
+
+// Wait checks the queries every interval until they have all propagated,
+// timeout passes or ctx is done, and returns the last report
+func (c *Checker) Wait(ctx context.Context, queries []Query, timeout, interval time.Duration) *Report {
+	deadline := time.Now().Add(timeout)
+
+	attempts := 0
+	for {
+		report := c.Check(ctx, queries)
+		attempts++
+		report.Attempts = attempts
+		if report.Propagated() || !time.Now().Add(interval).Before(deadline) {
+			return report
+		}
+
+		select {
+		case <-ctx.Done():
+			return report
+		case <-time.After(interval):
+		}
+	}
+}
+
+// query looks up query on resolver with the pure Go resolver, sending
+// every request to resolver.Addr
+func (c *Checker) query(ctx context.Context, resolver Resolver, query Query) ([]string, error) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return c.dial(ctx, network, resolver.Addr)
+		},
+	}
+	// A trailing dot keeps search domains from being appended
+	name := strings.TrimSuffix(query.Name, ".") + "."
+
+	var values []string
+	var err error
+	switch query.Type {
+	case TypeA, TypeAAAA:
+		network := "ip4"
+		if query.Type == TypeAAAA {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = r.LookupIP(ctx, network, name)
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	case TypeCNAME:
+		// The first target in the chain, which authoritative and
+		// recursive resolvers both return
+		var cname string
+		cname, err = r.LookupCNAME(ctx, name)
+		if err == nil && !strings.EqualFold(hostname(cname), hostname(name)) {
+			values = []string{hostname(cname)}
+		}
+	case TypeMX:
+		var mxs []*net.MX
+		mxs, err = r.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			values = append(values, fmt.Sprintf("%d %s", mx.Pref, hostname(mx.Host)))
+		}
+	case TypeNS:
+		var nss []*net.NS
+		nss, err = r.LookupNS(ctx, name)
+		for _, ns := range nss {
+			values = append(values, hostname(ns.Host))
+		}
+	case TypeTXT:
+		values, err = r.LookupTXT(ctx, name)
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", query.Type)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	return values, err
+}
+
+// hostname returns name in lower case without its trailing dot
+func hostname(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// normalize sorts values and drops duplicates, so answers listing records
+// in a different order compare equal
+func normalize(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := slices.Clone(values)
+	slices.Sort(result)
+	return slices.Compact(result)
+}
@@ -0,0 +1,126 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChecker returns a checker whose resolvers answer from answers, keyed
+// by resolver name and query
+func fakeChecker(answers map[string]map[Query][]string) *Checker {
+	return &Checker{
+		resolvers: []Resolver{
+			{Name: "bunny", Authoritative: true},
+			{Name: "public1"},
+			{Name: "public2"},
+		},
+		timeout: time.Second,
+		lookup: func(_ context.Context, resolver Resolver, query Query) ([]string, error) {
+			byQuery, ok := answers[resolver.Name]
+			if !ok {
+				return nil, errors.New("timeout")
+			}
+			return byQuery[query], nil
+		},
+	}
+}
+
+func TestCheck_Propagated(t *testing.T) {
+	ns := Query{Type: TypeNS, Name: "example.com"}
+	cdn := Query{Type: TypeCNAME, Name: "cdn.example.com"}
+	answer := map[Query][]string{
+		ns:  {"kiki.bunny.net", "coco.bunny.net"},
+		cdn: {"example.b-cdn.net"},
+	}
+	c := fakeChecker(map[string]map[Query][]string{
+		"bunny":   answer,
+		"public1": answer,
+		"public2": {ns: {"coco.bunny.net", "kiki.bunny.net", "coco.bunny.net"}, cdn: {"example.b-cdn.net"}},
+	})
+
+	report := c.Check(context.Background(), []Query{ns, cdn})
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, []string{"coco.bunny.net", "kiki.bunny.net"}, report.Results[0].Expected)
+	assert.True(t, report.Propagated())
+	assert.Empty(t, report.Pending())
+}
+
+func TestCheck_Pending(t *testing.T) {
+	a := Query{Type: TypeA, Name: "example.com"}
+	c := fakeChecker(map[string]map[Query][]string{
+		"bunny":   {a: {"192.0.2.10"}},
+		"public1": {a: {"192.0.2.10"}},
+		"public2": {a: {"198.51.100.7"}},
+	})
+
+	report := c.Check(context.Background(), []Query{a})
+	assert.False(t, report.Propagated())
+	assert.Equal(t, []Query{a}, report.Pending())
+
+	result := report.Results[0]
+	assert.True(t, result.Matches(result.Answers[1]))
+	assert.False(t, result.Matches(result.Answers[2]))
+}
+
+func TestCheck_NoAuthoritativeAnswer(t *testing.T) {
+	a := Query{Type: TypeA, Name: "example.com"}
+	c := fakeChecker(map[string]map[Query][]string{
+		"public1": {},
+		"public2": {},
+	})
+
+	report := c.Check(context.Background(), []Query{a})
+	assert.False(t, report.Results[0].Authoritative)
+	assert.False(t, report.Propagated())
+}
+
+func TestCheck_ResolverError(t *testing.T) {
+	a := Query{Type: TypeA, Name: "example.com"}
+	c := fakeChecker(map[string]map[Query][]string{
+		"bunny":   {a: {"192.0.2.10"}},
+		"public1": {a: {"192.0.2.10"}},
+	})
+
+	report := c.Check(context.Background(), []Query{a})
+	require.Error(t, report.Results[0].Answers[2].Err)
+	assert.False(t, report.Propagated())
+}
+
+func TestWait_RetriesUntilConvergence(t *testing.T) {
+	a := Query{Type: TypeA, Name: "example.com"}
+	c := fakeChecker(nil)
+	var checks atomic.Int32
+	c.lookup = func(_ context.Context, resolver Resolver, _ Query) ([]string, error) {
+		if resolver.Name != "public2" {
+			return []string{"192.0.2.10"}, nil
+		}
+		// The third check sees the new record
+		if checks.Add(1) < 3 {
+			return []string{"198.51.100.7"}, nil
+		}
+		return []string{"192.0.2.10"}, nil
+	}
+
+	report := c.Wait(context.Background(), []Query{a}, time.Second, time.Millisecond)
+	assert.True(t, report.Propagated())
+	assert.Equal(t, 3, report.Attempts)
+}
+
+func TestWait_StopsAtTimeout(t *testing.T) {
+	a := Query{Type: TypeA, Name: "example.com"}
+	c := fakeChecker(map[string]map[Query][]string{
+		"bunny":   {a: {"192.0.2.10"}},
+		"public1": {a: {"198.51.100.7"}},
+		"public2": {a: {"198.51.100.7"}},
+	})
+
+	report := c.Wait(context.Background(), []Query{a}, 0, time.Hour)
+	assert.False(t, report.Propagated())
+	assert.Equal(t, 1, report.Attempts)
+}
@@ -0,0 +1,102 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+)
+
+// AccessRules returns the country and IP blocking rules stored for a domain
+func (p *Provisioner) AccessRules(domain string) bunny.AccessRules {
+	o := p.overrideFor(domain)
+	return bunny.AccessRules{
+		BlockedCountries: nonNilStrings(o.BlockedCountries),
+		BlockedIPs:       nonNilStrings(o.BlockedIPs),
+	}
+}
+
+// UpdateAccessRules replaces a domain's blocked countries and IPs and pushes
+// them to its pull zone
+// The rules are saved even when the domain has no pull zone yet, in which
+// case ErrNotProvisioned is returned and they apply on provisioning
+func (p *Provisioner) UpdateAccessRules(ctx context.Context, domain string, rules bunny.AccessRules) (bunny.AccessRules, error) {
+	rules, err := NormalizeAccessRules(rules)
+	if err != nil {
+		return bunny.AccessRules{}, err
+	}
+	if p.overrides == nil {
+		return bunny.AccessRules{}, fmt.Errorf("override store not configured")
+	}
+
+	err = p.overrides.Update(domain, func(o *overrides.Override) {
+		o.BlockedCountries = rules.BlockedCountries
+		o.BlockedIPs = rules.BlockedIPs
+	})
+	if err != nil {
+		return bunny.AccessRules{}, fmt.Errorf("failed to save access rules for %s: %w", domain, err)
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return rules, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	if err := p.bunnyClient.SetAccessRules(ctx, provState.PullZoneID, rules); err != nil {
+		return rules, fmt.Errorf("failed to update access rules for %s: %w", domain, err)
+	}
+
+	return rules, nil
+}
+
+// hasAccessRules reports whether rules block anything
+func hasAccessRules(rules bunny.AccessRules) bool {
+	return len(rules.BlockedCountries) > 0 || len(rules.BlockedIPs) > 0
+}
+
+// NormalizeAccessRules validates access rules, upper-casing country codes
+// and de-duplicating and sorting both lists
+// IPs may be single addresses or CIDR ranges
+func NormalizeAccessRules(rules bunny.AccessRules) (bunny.AccessRules, error) {
+	countries := make([]string, 0, len(rules.BlockedCountries))
+	seen := make(map[string]bool)
+	for _, c := range rules.BlockedCountries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return bunny.AccessRules{}, fmt.Errorf("invalid country code %q (expected ISO 3166-1 alpha-2, e.g. \"CN\")", c)
+		}
+		if !seen[c] {
+			seen[c] = true
+			countries = append(countries, c)
+		}
+	}
+
+	ips := make([]string, 0, len(rules.BlockedIPs))
+	for _, ip := range rules.BlockedIPs {
+		ip = strings.TrimSpace(ip)
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return bunny.AccessRules{}, fmt.Errorf("invalid IP address or CIDR range %q", ip)
+			}
+		}
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.Strings(countries)
+	sort.Strings(ips)
+	return bunny.AccessRules{BlockedCountries: countries, BlockedIPs: ips}, nil
+}
+
+// nonNilStrings returns an empty slice for nil
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,103 @@
+package provisioner
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// Actions recorded within a step, so a resumed step continues with the
+// first one not done instead of redoing and rechecking the whole step
+func recordAction(label string) string      { return "record:" + label }
+func cnameAction(name string) string        { return "cname:" + name }
+func hostnameAction(hostname string) string { return "hostname:" + hostname }
+
+// completeActions records actions of the current step as done; a failure
+// only costs the resume its shortcut, so it is logged
+func (p *Provisioner) completeActions(provState *state.ProvisionState, actions ...string) {
+	if len(actions) == 0 {
+		return
+	}
+	if err := p.stateManager.CompleteActions(provState, actions...); err != nil {
+		p.logger.Warn("failed to record completed actions",
+			zap.String("domain", provState.Domain),
+			zap.Strings("actions", actions),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordQueue queues the records of the DNS records step into a batch,
+// skipping those written by an earlier attempt. The zone's records are
+// listed once, and only when a record is left to check
+type recordQueue struct {
+	d         *DomainProvisioner
+	batch     *bunny.RecordBatch
+	zoneID    int64
+	domain    string
+	provState *state.ProvisionState
+
+	existing []bunny.DNSRecord
+	listed   bool
+	// found holds the actions of records that already existed
+	found []string
+}
+
+// records returns the records of the zone, listing them on first use
+func (q *recordQueue) records(ctx context.Context) []bunny.DNSRecord {
+	if q.listed {
+		return q.existing
+	}
+	q.listed = true
+
+	records, err := q.d.provisioner.bunnyClient.GetDNSRecords(ctx, q.zoneID)
+	if err != nil {
+		q.d.provisioner.logger.Warn("failed to get existing DNS records, continuing anyway",
+			zap.Int64("zone_id", q.zoneID),
+			zap.Error(err),
+		)
+		return nil
+	}
+	q.existing = records
+	return records
+}
+
+// add queues req under label unless it was written before or exists
+// reports a matching record in the zone
+func (q *recordQueue) add(ctx context.Context, label string, req *bunny.AddDNSRecordRequest, exists func(r bunny.DNSRecord) bool) {
+	action := recordAction(label)
+	if q.provState.ActionDone(action) {
+		return
+	}
+	for _, r := range q.records(ctx) {
+		if exists(r) {
+			q.d.provisioner.logger.Debug("record already exists, skipping",
+				zap.String("domain", q.domain),
+				zap.String("record", label),
+			)
+			q.found = append(q.found, action)
+			return
+		}
+	}
+	req.TTL = defaultDNSRecordTTL
+	req.Enabled = true
+	q.batch.Add(q.zoneID, label, req)
+}
+
+// sameRecord matches records with the name and type of req
+func sameRecord(req *bunny.AddDNSRecordRequest) func(r bunny.DNSRecord) bool {
+	return func(r bunny.DNSRecord) bool {
+		return r.Name == req.Name && r.Type == req.Type
+	}
+}
+
+// sameName matches records of any type named name
+func sameName(name string) func(r bunny.DNSRecord) bool {
+	return func(r bunny.DNSRecord) bool {
+		return strings.EqualFold(r.Name, name)
+	}
+}
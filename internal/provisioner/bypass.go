@@ -0,0 +1,188 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// bypassRecordTTL keeps bypass records short-lived so restoring the CDN
+// propagates quickly
+const bypassRecordTTL = 60
+
+var (
+	// ErrAlreadyBypassed is returned when bypassing a domain that is already bypassed
+	ErrAlreadyBypassed = errors.New("domain is already bypassed")
+	// ErrNotBypassed is returned when restoring a domain that is not bypassed
+	ErrNotBypassed = errors.New("domain is not bypassed")
+)
+
+// SetBypass attaches the emergency bypass store
+func (p *Provisioner) SetBypass(s *bypass.Store) {
+	p.bypass = s
+}
+
+// IsBypassed reports whether a domain's DNS currently points at the origin
+// instead of the CDN
+func (p *Provisioner) IsBypassed(domain string) bool {
+	if p.bypass == nil {
+		return false
+	}
+	_, ok := p.bypass.Get(domain)
+	return ok
+}
+
+// bypassReason is the manifest reason of records disabled by a bypass
+const bypassReason = "emergency bypass"
+
+// EmergencyBypass disables the DNS records that route a domain through the
+// CDN and adds records pointing directly at the origin, remembering both
+// for Restore. It returns the records that were disabled
+func (p *Provisioner) EmergencyBypass(ctx context.Context, domain, reason string) ([]bunny.DNSRecord, error) {
+	if p.bypass == nil {
+		return nil, fmt.Errorf("bypass store not configured")
+	}
+	if p.IsBypassed(domain) {
+		return nil, fmt.Errorf("%s: %w", domain, ErrAlreadyBypassed)
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.ZoneID <= 0 || provState.CDNHostname == "" {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	records, err := p.bunnyClient.GetDNSRecords(ctx, provState.ZoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS records: %w", err)
+	}
+
+	var cdnRecords []bunny.DNSRecord
+	for _, r := range records {
+		if r.Type == bunny.DNSRecordTypeCNAME && r.Enabled && sameHost(r.Value, provState.CDNHostname) {
+			cdnRecords = append(cdnRecords, r)
+		}
+	}
+	if len(cdnRecords) == 0 {
+		return nil, fmt.Errorf("no DNS records point %s at the CDN", domain)
+	}
+
+	// Record the originals first so a partial failure can still be
+	// restored, and each added record as soon as it exists
+	entry := bypass.Entry{Reason: reason, Records: cdnRecords, BypassedAt: time.Now()}
+	if err := p.bypass.Set(domain, entry); err != nil {
+		return nil, fmt.Errorf("failed to save bypass state: %w", err)
+	}
+
+	manifestReason := bypassReason
+	if reason != "" {
+		manifestReason += ": " + reason
+	}
+	originIP := p.originIP(domain)
+	for _, r := range cdnRecords {
+		if _, err := p.setRecordEnabled(ctx, provState, r, false, manifestReason); err != nil {
+			return cdnRecords, fmt.Errorf("failed to disable %s (run restore to revert): %w", r.Name, err)
+		}
+		added, err := p.bunnyClient.AddDNSRecord(ctx, provState.ZoneID, &bunny.AddDNSRecordRequest{
+			Type:    bunny.DNSRecordTypeA,
+			Name:    r.Name,
+			Value:   originIP,
+			TTL:     bypassRecordTTL,
+			Enabled: true,
+		})
+		if err != nil {
+			return cdnRecords, fmt.Errorf("failed to point %s at origin (run restore to revert): %w", r.Name, err)
+		}
+		entry.Added = append(entry.Added, added.ID)
+		if err := p.bypass.Set(domain, entry); err != nil {
+			return cdnRecords, fmt.Errorf("failed to save bypass state: %w", err)
+		}
+	}
+
+	p.logger.Warn("CDN bypassed, DNS points at origin",
+		zap.String("domain", domain),
+		zap.String("origin_ip", originIP),
+		zap.Int("records", len(cdnRecords)),
+		zap.String("reason", reason),
+	)
+
+	if err := p.notifier.NotifyBypass(ctx, domain, true, reason); err != nil {
+		p.logger.Warn("failed to send bypass notification", zap.Error(err))
+	}
+
+	return cdnRecords, nil
+}
+
+// RestoreFromBypass deletes the records added by EmergencyBypass and
+// enables the original records again
+func (p *Provisioner) RestoreFromBypass(ctx context.Context, domain string) error {
+	if p.bypass == nil {
+		return fmt.Errorf("bypass store not configured")
+	}
+	entry, ok := p.bypass.Get(domain)
+	if !ok {
+		return fmt.Errorf("%s: %w", domain, ErrNotBypassed)
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.ZoneID <= 0 {
+		return fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	for _, id := range entry.Added {
+		err := p.bunnyClient.DeleteDNSRecord(ctx, provState.ZoneID, id)
+		var apiErr *bunny.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.IsNotFound()) {
+			return fmt.Errorf("failed to delete origin record %d: %w", id, err)
+		}
+	}
+
+	for _, r := range entry.Records {
+		if _, err := p.setRecordEnabled(ctx, provState, r, true, ""); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", r.Name, err)
+		}
+	}
+
+	if err := p.bypass.Delete(domain); err != nil {
+		return fmt.Errorf("failed to clear bypass state: %w", err)
+	}
+
+	p.logger.Info("CDN restored after bypass",
+		zap.String("domain", domain),
+		zap.Int("records", len(entry.Records)),
+	)
+
+	if err := p.notifier.NotifyBypass(ctx, domain, false, entry.Reason); err != nil {
+		p.logger.Warn("failed to send bypass notification", zap.Error(err))
+	}
+
+	return nil
+}
+
+// cdnRecordName returns the name of the DNS record that routes a domain
+// through its pull zone: "cdn" for zone apexes, the subdomain label otherwise
+func (p *Provisioner) cdnRecordName(ctx context.Context, provState *state.ProvisionState) (string, error) {
+	zone, err := p.bunnyClient.GetDNSZoneByID(ctx, provState.ZoneID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get DNS zone: %w", err)
+	}
+
+	domain := strings.ToLower(provState.Domain)
+	apex := strings.ToLower(zone.Domain)
+	if domain == apex {
+		return "cdn", nil
+	}
+	return strings.TrimSuffix(domain, "."+apex), nil
+}
+
+// sameHost compares hostnames ignoring case and a trailing dot
+func sameHost(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,185 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+)
+
+// ErrUnknownHostname is returned when a certificate is uploaded for a
+// hostname the domain's pull zone does not serve
+var ErrUnknownHostname = errors.New("hostname is not on the pull zone")
+
+// SetCertificates attaches the store that tracks uploaded custom certificates
+func (p *Provisioner) SetCertificates(s *certs.Store) {
+	p.certs = s
+}
+
+// Certificates returns the tracked certificates of a domain's hostnames, or
+// of all domains when domain is empty
+func (p *Provisioner) Certificates(domain string) []certs.Entry {
+	if p.certs == nil {
+		return nil
+	}
+	return p.certs.List(domain)
+}
+
+// UploadCertificate validates a PEM certificate and key for hostname (the
+// domain itself when empty), uploads it to the domain's pull zone and
+// records its metadata for expiry reminders
+func (p *Provisioner) UploadCertificate(ctx context.Context, domain, hostname string, certPEM, keyPEM []byte) (certs.Entry, error) {
+	if p.certs == nil {
+		return certs.Entry{}, fmt.Errorf("certificate store not configured")
+	}
+	domain = domainname.Normalize(domain)
+	hostname = domainname.Normalize(hostname)
+	if hostname == "" {
+		hostname = domain
+	}
+
+	entry, err := certs.ParsePair(certPEM, keyPEM, hostname, time.Now())
+	if err != nil {
+		return certs.Entry{}, err
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return certs.Entry{}, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	pullZone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+	if err != nil {
+		return certs.Entry{}, fmt.Errorf("failed to get pull zone for %s: %w", domain, err)
+	}
+	found := false
+	for _, h := range pullZone.Hostnames {
+		if strings.EqualFold(h.Hostname, hostname) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return certs.Entry{}, fmt.Errorf("%s (%s): %w", hostname, domain, ErrUnknownHostname)
+	}
+
+	// Bunny expects the PEM data base64 encoded
+	err = p.bunnyClient.AddCertificate(ctx, provState.PullZoneID, hostname,
+		base64.StdEncoding.EncodeToString(certPEM),
+		base64.StdEncoding.EncodeToString(keyPEM))
+	if err != nil {
+		return certs.Entry{}, fmt.Errorf("failed to upload certificate for %s: %w", hostname, err)
+	}
+
+	entry.Domain = domain
+	entry.UploadedAt = time.Now()
+	if err := p.certs.Set(entry); err != nil {
+		return entry, fmt.Errorf("certificate uploaded but failed to record it: %w", err)
+	}
+
+	p.logger.Info("custom certificate uploaded",
+		zap.String("domain", domain),
+		zap.String("hostname", hostname),
+		zap.Time("not_after", entry.NotAfter),
+	)
+	return entry, nil
+}
+
+// MonitorCertificates records the expiry of the Bunny-managed certificate on
+// every hostname of every provisioned pull zone and returns how many pull
+// zones were checked. Zones that cannot be read are logged and skipped
+func (p *Provisioner) MonitorCertificates(ctx context.Context) int {
+	if p.certs == nil {
+		return 0
+	}
+
+	checked := 0
+	for _, provState := range p.provisionedStates() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		list, err := p.bunnyClient.ListSSLCertificates(ctx, provState.PullZoneID)
+		if err != nil {
+			p.logger.Warn("failed to list certificates",
+				zap.String("domain", provState.Domain),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		entries := make([]certs.Entry, 0, len(list))
+		for _, c := range list {
+			if c.ExpirationDate.IsZero() {
+				continue
+			}
+			entries = append(entries, certs.Entry{
+				Hostname: c.Hostname,
+				Issuer:   c.Issuer,
+				Status:   c.Status,
+				NotAfter: c.ExpirationDate,
+			})
+		}
+		if err := p.certs.ReplaceManaged(provState.Domain, entries); err != nil {
+			p.logger.Warn("failed to record certificates",
+				zap.String("domain", provState.Domain),
+				zap.Error(err),
+			)
+			continue
+		}
+		checked++
+	}
+	return checked
+}
+
+// CertificateThresholds returns the days before expiry at which a
+// certificate is reported: certificates.alert_days for managed certificates,
+// certificates.reminder_days for custom ones
+func (p *Provisioner) CertificateThresholds(entry certs.Entry) []int {
+	if entry.Managed() {
+		return p.config.Certificates.AlertDays
+	}
+	return p.config.Certificates.ReminderDays
+}
+
+// RemindCertificateExpiry sends a reminder for every certificate that crossed
+// one of its thresholds since the last check and returns how many reminders
+// were sent
+func (p *Provisioner) RemindCertificateExpiry(ctx context.Context) int {
+	if p.certs == nil {
+		return 0
+	}
+
+	now := time.Now()
+	sent := 0
+	for _, entry := range p.certs.List("") {
+		days, due := entry.DueReminder(now, p.CertificateThresholds(entry))
+		if !due {
+			continue
+		}
+
+		err := p.notifier.NotifyCertificateExpiring(ctx, entry.Hostname, entry.NotAfter, entry.DaysLeft(now), entry.Managed())
+		if err != nil {
+			p.logger.Warn("failed to send certificate expiry reminder",
+				zap.String("hostname", entry.Hostname),
+				zap.Error(err),
+			)
+			continue
+		}
+		if err := p.certs.MarkReminded(entry.Hostname, days); err != nil {
+			p.logger.Warn("failed to record certificate expiry reminder",
+				zap.String("hostname", entry.Hostname),
+				zap.Error(err),
+			)
+		}
+		sent++
+	}
+	return sent
+}
@@ -62,6 +62,19 @@ func (d *Deprovisioner) Deprovision(ctx context.Context, domain string) error {
 		// Continue with state cleanup even if pull zone deletion fails
 	}
 
+	// Remove the Perma-Cache storage zone once the pull zone no longer uses it
+	if err := d.deleteStorageZone(ctx, provState.StorageZoneID, domain); err != nil {
+		d.provisioner.logger.Error("failed to delete storage zone",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+
+	d.deleteTokenKey(domain)
+	d.deleteBypass(domain)
+	d.forgetDisabledRecords(domain)
+	d.deleteCertificates(domain)
+
 	// Step 3: Clean up state
 	if err := d.deleteState(ctx, provState.ID, domain); err != nil {
 		d.provisioner.logger.Error("failed to delete state",
@@ -97,8 +110,7 @@ func (d *Deprovisioner) deprovisionByName(ctx context.Context, domain string) er
 	}
 
 	// Try to find pull zone by name
-	pullZoneName := generatePullZoneName(domain)
-	pullZone, err := d.provisioner.bunnyClient.GetPullZoneByName(ctx, pullZoneName)
+	pullZone, err := d.provisioner.findPullZone(ctx, domain)
 	pullZoneID := int64(0)
 	if err == nil && pullZone != nil {
 		pullZoneID = pullZone.ID
@@ -128,6 +140,12 @@ func (d *Deprovisioner) deprovisionByName(ctx context.Context, domain string) er
 		}
 	}
 
+	d.deleteStorageZoneByName(ctx, domain)
+	d.deleteTokenKey(domain)
+	d.deleteBypass(domain)
+	d.forgetDisabledRecords(domain)
+	d.deleteCertificates(domain)
+
 	return nil
 }
 
@@ -204,6 +222,97 @@ func (d *Deprovisioner) deletePullZone(ctx context.Context, pullZoneID int64, do
 	return nil
 }
 
+// deleteStorageZone deletes a Perma-Cache storage zone
+func (d *Deprovisioner) deleteStorageZone(ctx context.Context, storageZoneID int64, domain string) error {
+	if storageZoneID <= 0 {
+		return nil
+	}
+
+	d.provisioner.logger.Info("deleting storage zone",
+		zap.String("domain", domain),
+		zap.Int64("storage_zone_id", storageZoneID),
+	)
+
+	if err := d.provisioner.bunnyClient.DeleteStorageZone(ctx, storageZoneID); err != nil {
+		return fmt.Errorf("failed to delete storage zone: %w", err)
+	}
+
+	return nil
+}
+
+// deleteStorageZoneByName deletes a domain's Perma-Cache storage zone when
+// state is unavailable; only attempted if any profile uses Perma-Cache
+func (d *Deprovisioner) deleteStorageZoneByName(ctx context.Context, domain string) {
+	if !d.provisioner.permaCacheConfigured() {
+		return
+	}
+
+	zone, err := d.provisioner.bunnyClient.FindStorageZone(ctx, d.provisioner.names.StorageZones(domain)...)
+	if err != nil || zone == nil {
+		return
+	}
+
+	if err := d.deleteStorageZone(ctx, zone.ID, domain); err != nil {
+		d.provisioner.logger.Error("failed to delete storage zone",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// deleteTokenKey removes a domain's stored token authentication key
+func (d *Deprovisioner) deleteTokenKey(domain string) {
+	if d.provisioner.tokenKeys == nil {
+		return
+	}
+	if err := d.provisioner.tokenKeys.Delete(domain); err != nil {
+		d.provisioner.logger.Warn("failed to delete token key",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// deleteBypass forgets a domain's emergency bypass state
+func (d *Deprovisioner) deleteBypass(domain string) {
+	if d.provisioner.bypass == nil {
+		return
+	}
+	if err := d.provisioner.bypass.Delete(domain); err != nil {
+		d.provisioner.logger.Warn("failed to delete bypass state",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// forgetDisabledRecords drops a domain's records from the manifest of
+// disabled records
+func (d *Deprovisioner) forgetDisabledRecords(domain string) {
+	if d.provisioner.disabledRecords == nil {
+		return
+	}
+	if err := d.provisioner.disabledRecords.Forget(domain); err != nil {
+		d.provisioner.logger.Warn("failed to forget disabled DNS records",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// deleteCertificates forgets the custom certificates uploaded for a domain
+func (d *Deprovisioner) deleteCertificates(domain string) {
+	if d.provisioner.certs == nil {
+		return
+	}
+	if err := d.provisioner.certs.DeleteDomain(domain); err != nil {
+		d.provisioner.logger.Warn("failed to delete certificate records",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
 // deleteState removes the provisioning state
 func (d *Deprovisioner) deleteState(ctx context.Context, stateID string, domain string) error {
 	d.provisioner.logger.Info("deleting provisioning state",
@@ -266,6 +375,18 @@ func (d *Deprovisioner) DeprovisionSubdomain(ctx context.Context, subdomain, par
 		}
 	}
 
+	if err := d.deleteStorageZone(ctx, provState.StorageZoneID, fullDomain); err != nil {
+		d.provisioner.logger.Error("failed to delete subdomain storage zone",
+			zap.String("subdomain", fullDomain),
+			zap.Error(err),
+		)
+	}
+
+	d.deleteTokenKey(fullDomain)
+	d.deleteBypass(fullDomain)
+	d.forgetDisabledRecords(fullDomain)
+	d.deleteCertificates(fullDomain)
+
 	// Delete state
 	if err := d.provisioner.stateManager.Delete(provState.ID); err != nil {
 		d.provisioner.logger.Warn("failed to delete subdomain state",
@@ -293,14 +414,19 @@ func (d *Deprovisioner) deprovisionSubdomainByName(ctx context.Context, subdomai
 	}
 
 	// Find and delete pull zone
-	pullZoneName := generateSubdomainPullZoneName(subdomain, parentDomain)
-	pullZone, err := d.provisioner.bunnyClient.GetPullZoneByName(ctx, pullZoneName)
+	pullZone, err := d.provisioner.findPullZone(ctx, fullDomain)
 	if err == nil && pullZone != nil {
 		if err := d.deletePullZone(ctx, pullZone.ID, fullDomain); err != nil {
 			return err
 		}
 	}
 
+	d.deleteStorageZoneByName(ctx, fullDomain)
+	d.deleteTokenKey(fullDomain)
+	d.deleteBypass(fullDomain)
+	d.forgetDisabledRecords(fullDomain)
+	d.deleteCertificates(fullDomain)
+
 	return nil
 }
 
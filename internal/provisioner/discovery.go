@@ -0,0 +1,169 @@
+package provisioner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// DiscoveredSubdomain is a cPanel subdomain of a provisioned domain that has
+// no provisioning state
+type DiscoveredSubdomain struct {
+	Domain string `json:"domain"`
+	Parent string `json:"parent"`
+	User   string `json:"user"`
+	// Error is set when provisioning the subdomain failed
+	Error string `json:"error,omitempty"`
+}
+
+// CPanelDomain is a domain on the cPanel server, such as one line of
+// /etc/userdatadomains:
+//
+//	blog.example.com: exampleu==root==sub==example.com==/home/exampleu/blog==...
+type CPanelDomain struct {
+	Domain string
+	User   string
+	Type   string // main, addon, sub or parked
+	// Target is the account's main domain, or for addon domains the
+	// subdomain cPanel creates to back them
+	Target string
+}
+
+// parseUserdataDomains reads the cPanel domain list
+func parseUserdataDomains(r io.Reader) ([]CPanelDomain, error) {
+	var domains []CPanelDomain
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, fields, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(fields, "==")
+		if len(parts) < 4 {
+			continue
+		}
+		domains = append(domains, CPanelDomain{
+			Domain: strings.ToLower(strings.TrimSpace(name)),
+			User:   parts[0],
+			Type:   parts[2],
+			Target: strings.ToLower(parts[3]),
+		})
+	}
+	return domains, scanner.Err()
+}
+
+// CPanelDomains reads the cPanel domain list, discovery.userdata_domains
+func (p *Provisioner) CPanelDomains() ([]CPanelDomain, error) {
+	path := p.config.Discovery.UserdataDomains
+	if path == "" {
+		path = config.DefaultUserdataDomainsPath
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cPanel domains: %w", err)
+	}
+	defer f.Close()
+
+	domains, err := parseUserdataDomains(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cPanel domains from %s: %w", path, err)
+	}
+	return domains, nil
+}
+
+// DiscoverSubdomains lists the cPanel subdomains of provisioned domains that
+// were never provisioned, e.g. because they were created before whm2bunny
+// was installed, and provisions them when provision is set
+func (p *Provisioner) DiscoverSubdomains(ctx context.Context, provision bool) ([]DiscoveredSubdomain, error) {
+	domains, err := p.CPanelDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	found := p.missingSubdomains(domains)
+	if !provision {
+		return found, nil
+	}
+
+	for i := range found {
+		if ctx.Err() != nil {
+			break
+		}
+		sub := &found[i]
+		if p.skipFrozen(sub.Domain, "subdomain discovery") {
+			sub.Error = ErrFrozen.Error()
+			continue
+		}
+		label := strings.TrimSuffix(sub.Domain, "."+sub.Parent)
+		if err := p.ProvisionSubdomain(label, sub.Parent, sub.User); err != nil {
+			sub.Error = err.Error()
+			p.logger.Warn("failed to provision discovered subdomain",
+				zap.String("subdomain", sub.Domain),
+				zap.Error(err),
+			)
+		}
+	}
+	return found, nil
+}
+
+// missingSubdomains returns the subdomains of provisioned domains without a
+// provisioning state. The subdomains backing addon domains are skipped, as
+// the addon domain is provisioned in their place
+func (p *Provisioner) missingSubdomains(domains []CPanelDomain) []DiscoveredSubdomain {
+	parents := make(map[string]bool)
+	for _, provState := range p.provisionedStates() {
+		if !provState.IsSubdomain() {
+			parents[provState.Domain] = true
+		}
+	}
+
+	addonBacking := make(map[string]bool)
+	for _, d := range domains {
+		if d.Type == "addon" {
+			addonBacking[d.Target] = true
+		}
+	}
+
+	var found []DiscoveredSubdomain
+	for _, d := range domains {
+		if d.Type != "sub" || addonBacking[d.Domain] {
+			continue
+		}
+		parent := parentDomain(d.Domain, parents)
+		if parent == "" {
+			continue
+		}
+		if _, err := p.stateManager.GetByDomain(d.Domain); !errors.Is(err, state.ErrStateNotFound) {
+			continue
+		}
+		found = append(found, DiscoveredSubdomain{Domain: d.Domain, Parent: parent, User: d.User})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Domain < found[j].Domain })
+	return found
+}
+
+// parentDomain returns the longest of parents that domain is a subdomain of
+func parentDomain(domain string, parents map[string]bool) string {
+	for rest := domain; ; {
+		_, after, ok := strings.Cut(rest, ".")
+		if !ok {
+			return ""
+		}
+		if parents[after] {
+			return after
+		}
+		rest = after
+	}
+}
@@ -0,0 +1,118 @@
+package provisioner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/i18n"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/vhost"
+)
+
+// errorRateWarning is the 5xx share (percent) at which a domain's traffic
+// is reported as unhealthy
+const errorRateWarning = 1.0
+
+// Diagnosis is the result of checking one domain end to end
+// Each part is collected independently; a failed part records its error
+// and the rest of the diagnosis still runs
+type Diagnosis struct {
+	Domain string
+
+	State    *state.ProvisionState
+	StateErr error
+
+	PullZone    *bunny.PullZone
+	PullZoneErr error
+
+	Origin       bunny.OriginEndpoint
+	OriginStatus int
+	OriginErr    error
+
+	Errors    *bunny.ErrorStats
+	ErrorsErr error
+
+	// VHost compares the site through the CDN and at the origin; nil
+	// without a CDN hostname
+	VHost *vhost.Report
+
+	Certificates []certs.Entry
+
+	// Findings are human readable problems, most important first
+	Findings []string
+}
+
+// Diagnose checks a domain's state, pull zone, origin reachability and
+// virtual host, recent error statistics and certificates, and explains what
+// looks wrong
+func (p *Provisioner) Diagnose(ctx context.Context, domain string, window time.Duration) *Diagnosis {
+	d := &Diagnosis{Domain: domain}
+
+	d.State, d.StateErr = p.stateManager.GetByDomain(domain)
+	if d.StateErr != nil {
+		d.Findings = append(d.Findings, i18n.T("finding.no_state"))
+	} else if d.State.Status != state.StatusSuccess {
+		d.Findings = append(d.Findings, i18n.T("finding.incomplete", d.State.Status, d.State.StepName()))
+	}
+
+	d.Origin = p.OriginEndpoint(domain)
+	d.OriginStatus, d.OriginErr = p.ProbeOrigin(ctx, domain, d.Origin)
+	switch {
+	case d.OriginErr != nil:
+		d.Findings = append(d.Findings, i18n.T("finding.origin_unreachable", d.OriginErr))
+	case d.OriginStatus >= http.StatusInternalServerError:
+		d.Findings = append(d.Findings, i18n.T("finding.origin_status", d.OriginStatus, d.Origin.Host(domain)))
+	}
+
+	if d.State != nil && d.State.PullZoneID > 0 {
+		d.PullZone, d.PullZoneErr = p.bunnyClient.GetPullZone(ctx, d.State.PullZoneID)
+		if d.PullZoneErr != nil {
+			d.Findings = append(d.Findings, i18n.T("finding.pull_zone_unreadable", d.PullZoneErr))
+		} else if hostname := d.PullZone.SystemHostname(); d.State.CDNHostname != "" && !strings.EqualFold(d.State.CDNHostname, hostname) {
+			d.Findings = append(d.Findings, i18n.T("finding.cdn_hostname_mismatch", d.State.CDNHostname, hostname))
+		}
+
+		if d.State.CDNHostname != "" {
+			d.VHost = p.CheckVHost(ctx, domain, d.State.CDNHostname, p.config.CDN.VHostCheck.Timeout)
+			switch d.VHost.Result {
+			case vhost.ResultDefaultVHost:
+				d.Findings = append(d.Findings, i18n.T("finding.default_vhost", d.Origin.Host(domain)))
+			case vhost.ResultMismatch:
+				d.Findings = append(d.Findings, i18n.T("finding.vhost_mismatch", d.VHost.CDN.Status, d.VHost.Origin.Status))
+			}
+		}
+
+		now := time.Now()
+		d.Errors, d.ErrorsErr = p.bunnyClient.GetPullZoneErrorStats(ctx, d.State.PullZoneID, now.Add(-window), now)
+		if d.Errors != nil && d.Errors.Rate5xx() >= errorRateWarning {
+			d.Findings = append(d.Findings, d.errorFinding())
+		}
+	}
+
+	for _, c := range p.Certificates(domain) {
+		if left := c.DaysLeft(time.Now()); left < 0 {
+			d.Findings = append(d.Findings, i18n.T("finding.certificate_expired", c.Hostname, -left))
+		}
+		d.Certificates = append(d.Certificates, c)
+	}
+
+	return d
+}
+
+// errorFinding explains a high 5xx rate, using the live origin probe and
+// the origin request count to tell origin problems from CDN problems
+func (d *Diagnosis) errorFinding() string {
+	rate := d.Errors.Rate5xx()
+	switch {
+	case d.OriginErr != nil || d.OriginStatus >= http.StatusInternalServerError:
+		return i18n.T("finding.5xx_origin_failing", rate)
+	case !d.Errors.OriginSide():
+		return i18n.T("finding.5xx_edge", rate)
+	default:
+		return i18n.T("finding.5xx_origin_likely", rate)
+	}
+}
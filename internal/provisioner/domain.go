@@ -3,17 +3,22 @@ package provisioner
 import (
 	"context"
 	"fmt"
-	"strings"
+	"slices"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/hooks"
 	"github.com/mordenhost/whm2bunny/internal/state"
 )
 
 const (
 	// Default TTL for DNS records (1 hour)
 	defaultDNSRecordTTL = 3600
+
+	// cdnHostnameLookupTimeout bounds the check that a CDN hostname resolves
+	cdnHostnameLookupTimeout = 5 * time.Second
 )
 
 // DomainProvisioner handles provisioning for main domains and addon domains
@@ -37,6 +42,9 @@ func (d *DomainProvisioner) Provision(ctx context.Context, domain, user string)
 	// Resume from the last successful step
 	switch provState.CurrentStep {
 	case state.StepNone, state.StepDNSZone:
+		if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepDNSZone); err != nil {
+			return err
+		}
 		if err := d.createDNSZone(ctx, domain, provState); err != nil {
 			return fmt.Errorf("failed to create DNS zone: %w", err)
 		}
@@ -49,15 +57,26 @@ func (d *DomainProvisioner) Provision(ctx context.Context, domain, user string)
 		fallthrough
 
 	case state.StepPullZone:
+		if d.provisioner.pullZonesPaused(ctx) {
+			return ErrPullZonesPaused
+		}
 		if err := d.createPullZone(ctx, domain, provState); err != nil {
 			return fmt.Errorf("failed to create pull zone: %w", err)
 		}
+		d.provisioner.applyProfile(ctx, domain, provState)
 		fallthrough
 
 	case state.StepCNAMESync:
+		// Also runs on resume, so a provision failed by the hook retries it
+		if err := d.provisioner.runHooks(ctx, hooks.PostPullZoneCreate, provState); err != nil {
+			return fmt.Errorf("post_pull_zone_create hook failed: %w", err)
+		}
 		if err := d.syncCDNCNAME(ctx, provState.ZoneID, provState.PullZoneID, provState); err != nil {
 			return fmt.Errorf("failed to sync CDN CNAME: %w", err)
 		}
+		d.provisioner.secureHostnames(ctx, provState, d.provisioner.cdnHostnames(domain))
+		d.provisioner.verifyPropagation(ctx, provState)
+		d.provisioner.verifyVHost(ctx, provState)
 
 	default:
 		// Already completed
@@ -88,14 +107,14 @@ func (d *DomainProvisioner) createDNSZone(ctx context.Context, domain string, pr
 		if updateErr := d.provisioner.stateManager.Update(provState); updateErr != nil {
 			return updateErr
 		}
-		if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+		if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepDNSRecords); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	// Create the DNS zone
-	soaEmail := d.provisioner.config.DNS.SOAEmail
+	// Create the DNS zone with the SOA email of the domain's reseller
+	soaEmail := d.provisioner.soaEmail(ctx, provState.Reseller)
 	zone, err := d.provisioner.bunnyClient.CreateDNSZone(ctx, domain, soaEmail)
 	if err != nil {
 		d.provisioner.logger.Error("failed to create DNS zone",
@@ -111,8 +130,8 @@ func (d *DomainProvisioner) createDNSZone(ctx context.Context, domain string, pr
 		return err
 	}
 
-	// Advance to next step
-	if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+	// Advance to the next step
+	if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepDNSRecords); err != nil {
 		return err
 	}
 
@@ -124,152 +143,84 @@ func (d *DomainProvisioner) createDNSZone(ctx context.Context, domain string, pr
 	return nil
 }
 
+// dmarcLabel names the DMARC record, the only one allowed to fail
+const dmarcLabel = "DMARC TXT"
+
 // addDNSRecords adds standard DNS records to the zone
 // Step 2 of the provisioning process
 // Adds:
 // - A record: @ -> reverseProxyIP
 // - CNAME: www -> @
-// - MX record: 10 mail.domain.com
-// - TXT: v=spf1 a mx -all
+// - MX record: 10 mail.domain.com, unless the mail preset says otherwise
+// - TXT: v=spf1 a mx -all, or the SPF record of the mail preset
 func (d *DomainProvisioner) addDNSRecords(ctx context.Context, zoneID int64, domain string, provState *state.ProvisionState) error {
 	d.provisioner.logger.Info("adding DNS records",
 		zap.String("domain", domain),
 		zap.Int64("zone_id", zoneID),
 	)
 
-	originIP := d.provisioner.config.Origin.IP
-
-	// Get existing records to check for duplicates
-	existingRecords, err := d.provisioner.bunnyClient.GetDNSRecords(ctx, zoneID)
-	if err != nil {
-		d.provisioner.logger.Warn("failed to get existing DNS records, continuing anyway",
-			zap.Int64("zone_id", zoneID),
-			zap.Error(err),
-		)
-		existingRecords = nil
+	originIP := d.provisioner.originIP(domain)
+
+	// The records are written in one batch sharing a retry budget, so a
+	// rate limited API does not retry each record on its own. Records
+	// written by an earlier attempt are skipped without listing the zone
+	q := &recordQueue{
+		d:         d,
+		batch:     d.provisioner.bunnyClient.NewRecordBatch(bunny.BatchOptions{}),
+		zoneID:    zoneID,
+		domain:    domain,
+		provState: provState,
 	}
-
-	recordExists := func(name string, recordType bunny.DNSRecordType) bool {
-		for _, r := range existingRecords {
-			if r.Name == name && r.Type == recordType {
-				return true
-			}
-		}
-		return false
+	queue := func(label string, req *bunny.AddDNSRecordRequest) {
+		q.add(ctx, label, req, sameRecord(req))
 	}
 
-	// Add A record: @ -> originIP
-	if !recordExists("@", bunny.DNSRecordTypeA) {
-		aRecord := &bunny.AddDNSRecordRequest{
-			Type:    bunny.DNSRecordTypeA,
-			Name:    "@",
-			Value:   originIP,
-			TTL:     defaultDNSRecordTTL,
-			Enabled: true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, aRecord); err != nil {
-			return fmt.Errorf("failed to add A record: %w", err)
-		}
-		d.provisioner.logger.Debug("added A record",
-			zap.String("domain", domain),
-			zap.String("name", "@"),
-			zap.String("value", originIP),
-		)
-	} else {
-		d.provisioner.logger.Debug("A record already exists, skipping",
-			zap.String("domain", domain),
-		)
+	// A record: @ -> originIP
+	queue("A", &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeA, Name: "@", Value: originIP})
+	// CNAME: www -> @
+	queue("www CNAME", &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeCNAME, Name: "www", Value: domain + "."})
+	// MX and SPF records of the mail preset: by default 10 mail.domain.com
+	// and v=spf1 a mx -all
+	mx, spf := mailRecords(domain, d.provisioner.overrideFor(domain).MailPreset)
+	if mx != nil {
+		queue("MX", mx)
 	}
-
-	// Add CNAME: www -> @
-	if !recordExists("www", bunny.DNSRecordTypeCNAME) {
-		cnameRecord := &bunny.AddDNSRecordRequest{
-			Type:    bunny.DNSRecordTypeCNAME,
-			Name:    "www",
-			Value:   domain + ".",
-			TTL:     defaultDNSRecordTTL,
-			Enabled: true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, cnameRecord); err != nil {
-			return fmt.Errorf("failed to add www CNAME record: %w", err)
-		}
-		d.provisioner.logger.Debug("added www CNAME record",
-			zap.String("domain", domain),
-		)
-	} else {
-		d.provisioner.logger.Debug("www CNAME record already exists, skipping",
-			zap.String("domain", domain),
-		)
-	}
-
-	// Add MX record: 10 mail.domain.com
-	if !recordExists("@", bunny.DNSRecordTypeMX) {
-		mxRecord := &bunny.AddDNSRecordRequest{
-			Type:     bunny.DNSRecordTypeMX,
-			Name:     "@",
-			Value:    fmt.Sprintf("mail.%s.", domain),
-			Priority: 10,
-			TTL:      defaultDNSRecordTTL,
-			Enabled:  true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, mxRecord); err != nil {
-			return fmt.Errorf("failed to add MX record: %w", err)
-		}
-		d.provisioner.logger.Debug("added MX record",
-			zap.String("domain", domain),
-		)
-	} else {
-		d.provisioner.logger.Debug("MX record already exists, skipping",
-			zap.String("domain", domain),
-		)
-	}
-
-	// Add TXT record: v=spf1 a mx -all
-	if !recordExists("@", bunny.DNSRecordTypeTXT) {
-		txtRecord := &bunny.AddDNSRecordRequest{
-			Type:    bunny.DNSRecordTypeTXT,
-			Name:    "@",
-			Value:   "v=spf1 a mx -all",
-			TTL:     defaultDNSRecordTTL,
-			Enabled: true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, txtRecord); err != nil {
-			return fmt.Errorf("failed to add SPF TXT record: %w", err)
-		}
-		d.provisioner.logger.Debug("added SPF TXT record",
-			zap.String("domain", domain),
-		)
-	} else {
-		d.provisioner.logger.Debug("SPF TXT record already exists, skipping",
-			zap.String("domain", domain),
-		)
-	}
-
-	// Add DMARC TXT record if not exists
-	dmarcName := "_dmarc"
-	if !recordExists(dmarcName, bunny.DNSRecordTypeTXT) {
-		dmarcRecord := &bunny.AddDNSRecordRequest{
-			Type:    bunny.DNSRecordTypeTXT,
-			Name:    dmarcName,
-			Value:   "v=DMARC1; p=none; rua=mailto:dmarc@" + domain,
-			TTL:     defaultDNSRecordTTL,
-			Enabled: true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, dmarcRecord); err != nil {
-			// Don't fail on DMARC error, just log
-			d.provisioner.logger.Warn("failed to add DMARC record",
+	queue("SPF TXT", spf)
+	// DMARC TXT record
+	queue(dmarcLabel, &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeTXT, Name: "_dmarc", Value: "v=DMARC1; p=none; rua=mailto:dmarc@" + domain})
+
+	// cPanel service records (mail, webmail, cpanel, ...) if enabled
+	d.queueServiceRecords(ctx, q)
+
+	// Every record written is recorded, so a retry after a failed record
+	// continues with it rather than rewriting the step
+	done := q.found
+	var failed error
+	for _, result := range q.batch.Apply(ctx) {
+		switch {
+		case result.Err == nil:
+			done = append(done, recordAction(result.Label))
+			d.provisioner.logger.Debug("added DNS record",
 				zap.String("domain", domain),
-				zap.Error(err),
+				zap.String("record", result.Label),
 			)
-		} else {
-			d.provisioner.logger.Debug("added DMARC TXT record",
+		case result.Label == dmarcLabel:
+			// Don't fail on DMARC error, just log
+			d.provisioner.logger.Warn("failed to add DMARC record",
 				zap.String("domain", domain),
+				zap.Error(result.Err),
 			)
+		case failed == nil:
+			failed = fmt.Errorf("failed to add %s record: %w", result.Label, result.Err)
 		}
 	}
+	d.provisioner.completeActions(provState, done...)
+	if failed != nil {
+		return failed
+	}
 
-	// Advance to next step
-	if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+	// Advance to the next step
+	if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepPullZone); err != nil {
 		return err
 	}
 
@@ -288,51 +239,52 @@ func (d *DomainProvisioner) createPullZone(ctx context.Context, domain string, p
 		zap.String("domain", domain),
 	)
 
-	// Generate pull zone name
-	zoneName := generatePullZoneName(domain)
-
-	// Check if pull zone already exists (idempotency)
-	existingZone, err := d.provisioner.bunnyClient.GetPullZoneByName(ctx, zoneName)
+	// Check if pull zone already exists (idempotency), also under a
+	// previous zone name prefix
+	existingZone, err := d.provisioner.findPullZone(ctx, domain)
 	if err == nil && existingZone != nil {
 		d.provisioner.logger.Info("pull zone already exists, reusing",
 			zap.String("domain", domain),
-			zap.String("zone_name", zoneName),
+			zap.String("zone_name", existingZone.Name),
 			zap.Int64("zone_id", existingZone.ID),
 		)
+		// A zone created before a crash may still lack its hostnames
+		d.addPullZoneHostnames(ctx, domain, existingZone, provState)
 		provState.PullZoneID = existingZone.ID
-		provState.CDNHostname = d.extractCDNHostname(existingZone)
+		provState.CDNHostname = existingZone.SystemHostname()
 		if updateErr := d.provisioner.stateManager.Update(provState); updateErr != nil {
 			return updateErr
 		}
-		if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+		if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepCNAMESync); err != nil {
 			return err
 		}
 		return nil
 	}
 
 	// Create the pull zone
-	originIP := d.provisioner.config.Origin.IP
-	pullZone, err := d.provisioner.bunnyClient.CreatePullZone(ctx, domain, originIP)
-	if err != nil {
-		d.provisioner.logger.Error("failed to create pull zone",
+	originIP := d.provisioner.originIP(domain)
+	_, profile := d.provisioner.profileFor(provState)
+	opts := d.provisioner.pullZoneOptions(domain, profile)
+	if err := d.provisioner.verifyOrigin(ctx, domain, opts.Endpoint); err != nil {
+		d.provisioner.logger.Error("origin pre-flight check failed",
 			zap.String("domain", domain),
 			zap.Error(err),
 		)
 		return err
 	}
-
-	// Add domain hostname to pull zone
-	if err := d.provisioner.bunnyClient.AddPullZoneHostname(ctx, pullZone.ID, domain); err != nil {
-		d.provisioner.logger.Warn("failed to add hostname to pull zone",
+	pullZone, err := d.provisioner.bunnyClient.CreatePullZone(ctx, domain, originIP, opts)
+	if err != nil {
+		d.provisioner.logger.Error("failed to create pull zone",
 			zap.String("domain", domain),
-			zap.Int64("pull_zone_id", pullZone.ID),
 			zap.Error(err),
 		)
-		// Don't fail on hostname error, the zone is still usable
+		return err
 	}
 
+	d.addPullZoneHostnames(ctx, domain, pullZone, provState)
+
 	// Extract CDN hostname from pull zone
-	cdnHostname := d.extractCDNHostname(pullZone)
+	cdnHostname := pullZone.SystemHostname()
 
 	// Update state
 	provState.PullZoneID = pullZone.ID
@@ -341,14 +293,14 @@ func (d *DomainProvisioner) createPullZone(ctx context.Context, domain string, p
 		return err
 	}
 
-	// Advance to next step
-	if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+	// Advance to the next step
+	if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepCNAMESync); err != nil {
 		return err
 	}
 
 	d.provisioner.logger.Info("pull zone created successfully",
 		zap.String("domain", domain),
-		zap.String("zone_name", zoneName),
+		zap.String("zone_name", pullZone.Name),
 		zap.Int64("zone_id", pullZone.ID),
 		zap.String("cdn_hostname", cdnHostname),
 	)
@@ -356,7 +308,8 @@ func (d *DomainProvisioner) createPullZone(ctx context.Context, domain string, p
 	return nil
 }
 
-// syncCDNCNAME adds a CNAME record pointing 'cdn' to the CDN hostname
+// syncCDNCNAME points the records of the DNS record strategy, such as
+// 'cdn', at the CDN hostname
 // Step 4 of the provisioning process
 func (d *DomainProvisioner) syncCDNCNAME(ctx context.Context, zoneID int64, pullZoneID int64, provState *state.ProvisionState) error {
 	d.provisioner.logger.Info("syncing CDN CNAME",
@@ -370,7 +323,7 @@ func (d *DomainProvisioner) syncCDNCNAME(ctx context.Context, zoneID int64, pull
 		return fmt.Errorf("failed to get pull zone: %w", err)
 	}
 
-	cdnHostname := d.extractCDNHostname(pullZone)
+	cdnHostname := pullZone.SystemHostname()
 	if cdnHostname == "" {
 		return fmt.Errorf("could not extract CDN hostname from pull zone")
 	}
@@ -385,92 +338,155 @@ func (d *DomainProvisioner) syncCDNCNAME(ctx context.Context, zoneID int64, pull
 		existingRecords = nil
 	}
 
-	// Check if 'cdn' CNAME already exists
-	cnameExists := false
-	for _, r := range existingRecords {
-		if r.Name == "cdn" && r.Type == bunny.DNSRecordTypeCNAME {
-			cnameExists = true
-			d.provisioner.logger.Info("cdn CNAME already exists, updating value",
-				zap.Int64("zone_id", zoneID),
-				zap.String("old_value", r.Value),
-				zap.String("new_value", cdnHostname),
-			)
-			// Update existing record
-			updateReq := &bunny.UpdateDNSRecordRequest{
-				Type:    bunny.DNSRecordTypeCNAME,
-				Name:    "cdn",
-				Value:   cdnHostname,
-				TTL:     defaultDNSRecordTTL,
-				Enabled: true,
-			}
-			if err := d.provisioner.bunnyClient.UpdateDNSRecord(ctx, zoneID, r.ID, updateReq); err != nil {
-				return fmt.Errorf("failed to update cdn CNAME record: %w", err)
-			}
-			break
+	names := d.provisioner.config.DNS.CDNRecordNames()
+	for _, name := range names {
+		// Names pointed by an earlier attempt are not checked again
+		if provState.ActionDone(cnameAction(name)) {
+			continue
 		}
-	}
-
-	// Add new CNAME if it doesn't exist
-	if !cnameExists {
-		cnameRecord := &bunny.AddDNSRecordRequest{
-			Type:    bunny.DNSRecordTypeCNAME,
-			Name:    "cdn",
-			Value:   cdnHostname,
-			TTL:     defaultDNSRecordTTL,
-			Enabled: true,
-		}
-		if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, cnameRecord); err != nil {
-			return fmt.Errorf("failed to add cdn CNAME record: %w", err)
+		if err := d.pointAtCDN(ctx, zoneID, name, cdnHostname, existingRecords); err != nil {
+			return err
 		}
+		d.provisioner.completeActions(provState, cnameAction(name))
 	}
 
-	// Update state with CDN hostname
+	// Update state with the CDN hostname, once checked to resolve
 	provState.CDNHostname = cdnHostname
+	d.provisioner.verifyCDNHostname(ctx, provState)
 	if err := d.provisioner.stateManager.Update(provState); err != nil {
 		return err
 	}
 
-	// Advance to next step
-	if err := d.provisioner.stateManager.IncrementStep(provState.ID); err != nil {
+	// Advance to the next step
+	if err := d.provisioner.stateManager.AdvanceStep(provState.ID, state.StepDone); err != nil {
 		return err
 	}
 
 	d.provisioner.logger.Info("CDN CNAME synced successfully",
 		zap.Int64("zone_id", zoneID),
 		zap.String("cdn_hostname", cdnHostname),
+		zap.Strings("records", names),
 	)
 
 	return nil
 }
 
-// extractCDNHostname extracts the CDN hostname from a pull zone
-// BunnyCDN typically returns hostnames like: xxxxx.bunnycdn.com
-func (d *DomainProvisioner) extractCDNHostname(pullZone *bunny.PullZone) string {
-	if pullZone == nil {
-		return ""
+// pointAtCDN adds or updates the CNAME record name pointing at the CDN
+// hostname. The apex (@) has its A and AAAA records replaced, which Bunny
+// DNS flattens into addresses
+func (d *DomainProvisioner) pointAtCDN(ctx context.Context, zoneID int64, name, cdnHostname string, existingRecords []bunny.DNSRecord) error {
+	for _, r := range existingRecords {
+		if r.Name != name {
+			continue
+		}
+		switch r.Type {
+		case bunny.DNSRecordTypeCNAME:
+			if sameHost(r.Value, cdnHostname) {
+				return nil
+			}
+			d.provisioner.logger.Info("CNAME already exists, updating value",
+				zap.Int64("zone_id", zoneID),
+				zap.String("name", name),
+				zap.String("old_value", r.Value),
+				zap.String("new_value", cdnHostname),
+			)
+			updateReq := &bunny.UpdateDNSRecordRequest{
+				Type:    bunny.DNSRecordTypeCNAME,
+				Name:    name,
+				Value:   cdnHostname,
+				TTL:     defaultDNSRecordTTL,
+				Enabled: true,
+			}
+			if err := d.provisioner.bunnyClient.UpdateDNSRecord(ctx, zoneID, r.ID, updateReq); err != nil {
+				return fmt.Errorf("failed to update %s CNAME record: %w", name, err)
+			}
+			return nil
+		case bunny.DNSRecordTypeA, bunny.DNSRecordTypeAAAA:
+			// A CNAME cannot coexist with address records
+			if err := d.provisioner.bunnyClient.DeleteDNSRecord(ctx, zoneID, r.ID); err != nil {
+				return fmt.Errorf("failed to replace %s %s record: %w", name, r.Type, err)
+			}
+		}
 	}
 
-	// Check if we have hostnames in the pull zone
-	if len(pullZone.Hostnames) > 0 {
-		// The first hostname is usually the CDN hostname
-		for _, h := range pullZone.Hostnames {
-			if strings.Contains(h.Hostname, ".bunnycdn.com") {
-				return h.Hostname
-			}
+	cnameRecord := &bunny.AddDNSRecordRequest{
+		Type:    bunny.DNSRecordTypeCNAME,
+		Name:    name,
+		Value:   cdnHostname,
+		TTL:     defaultDNSRecordTTL,
+		Enabled: true,
+	}
+	if _, err := d.provisioner.bunnyClient.AddDNSRecord(ctx, zoneID, cnameRecord); err != nil {
+		return fmt.Errorf("failed to add %s CNAME record: %w", name, err)
+	}
+	return nil
+}
+
+// addPullZoneHostnames adds the domain and the hostnames routed through
+// the CDN to the pull zone, skipping those it already has or an earlier
+// attempt added
+func (d *DomainProvisioner) addPullZoneHostnames(ctx context.Context, domain string, pullZone *bunny.PullZone, provState *state.ProvisionState) {
+	var done []string
+	for _, hostname := range d.provisioner.pullZoneHostnames(domain) {
+		if provState.ActionDone(hostnameAction(hostname)) {
+			continue
 		}
-		// If no bunnycdn.com hostname, return the first one
-		if pullZone.Hostnames[0].Hostname != "" {
-			return pullZone.Hostnames[0].Hostname
+		if pullZone.FindHostname(hostname) == nil {
+			if err := d.provisioner.bunnyClient.AddPullZoneHostname(ctx, pullZone.ID, hostname); err != nil {
+				d.provisioner.logger.Warn("failed to add hostname to pull zone",
+					zap.String("domain", domain),
+					zap.String("hostname", hostname),
+					zap.Int64("pull_zone_id", pullZone.ID),
+					zap.Error(err),
+				)
+				// Don't fail on hostname error, the zone is still usable
+				continue
+			}
 		}
+		done = append(done, hostnameAction(hostname))
 	}
+	d.provisioner.completeActions(provState, done...)
+}
 
-	// Fallback: construct hostname from zone name
-	// BunnyCDN typically uses: {zone-id}.bunnycdn.com
-	if pullZone.ID > 0 {
-		return fmt.Sprintf("%d.bunnycdn.com", pullZone.ID)
+// pullZoneHostnames returns the hostnames added to a domain's pull zone:
+// the domain itself and each hostname the DNS record strategy routes
+// through the CDN
+func (p *Provisioner) pullZoneHostnames(domain string) []string {
+	hostnames := []string{domain}
+	for _, name := range p.config.DNS.CDNRecordNames() {
+		hostname := domain
+		if name != "@" {
+			hostname = name + "." + domain
+		}
+		if !slices.Contains(hostnames, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
 	}
+	return hostnames
+}
 
-	return ""
+// SetLookupHost replaces the resolver the CDN hostname check uses, for
+// runs against a fake Bunny API whose hostnames do not resolve
+func (p *Provisioner) SetLookupHost(fn func(ctx context.Context, host string) ([]string, error)) {
+	p.lookupHost = fn
+}
+
+// verifyCDNHostname records in provState whether its CDN hostname
+// resolves. A hostname that does not resolve yet is still used, as it is
+// the one Bunny issued for the pull zone
+func (p *Provisioner) verifyCDNHostname(ctx context.Context, provState *state.ProvisionState) {
+	lookupCtx, cancel := context.WithTimeout(ctx, cdnHostnameLookupTimeout)
+	defer cancel()
+
+	_, err := p.lookupHost(lookupCtx, provState.CDNHostname)
+	provState.CDNHostnameVerified = err == nil
+	if err != nil {
+		p.logger.Warn("CDN hostname does not resolve yet",
+			zap.String("domain", provState.Domain),
+			zap.String("cdn_hostname", provState.CDNHostname),
+			zap.Error(err),
+		)
+	}
 }
 
 // GetProvisionStatus returns the current provisioning status for a domain
@@ -0,0 +1,395 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// Drift describes a pull zone setting that differs from its desired value
+type Drift struct {
+	Field string `json:"field"`
+	Want  string `json:"want"`
+	Got   string `json:"got"`
+	Fixed bool   `json:"fixed"`
+}
+
+// CheckDrift compares a provisioned domain's pull zone with the settings
+// derived from its profile and overrides, correcting differences when fix is set
+func (p *Provisioner) CheckDrift(ctx context.Context, domain string, fix bool) ([]Drift, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	return p.checkDrift(ctx, provState, fix)
+}
+
+// EnforceDrift checks every successfully provisioned domain, including
+// archived ones, for drift and returns the drifted fields per domain
+// Domains that cannot be checked are logged and skipped
+func (p *Provisioner) EnforceDrift(ctx context.Context, fix bool) map[string][]Drift {
+	report := make(map[string][]Drift)
+	for _, provState := range p.provisionedStates() {
+		if ctx.Err() != nil {
+			break
+		}
+		if p.skipFrozen(provState.Domain, "drift enforcement") {
+			continue
+		}
+
+		drifts, err := p.checkDrift(ctx, provState, fix)
+		if err != nil {
+			p.logger.Warn("drift check failed",
+				zap.String("domain", provState.Domain),
+				zap.Error(err),
+			)
+			continue
+		}
+		if len(drifts) > 0 {
+			report[provState.Domain] = drifts
+		}
+	}
+	return report
+}
+
+// checkDrift compares one pull zone with its desired settings
+func (p *Provisioner) checkDrift(ctx context.Context, provState *state.ProvisionState, fix bool) ([]Drift, error) {
+	domain := provState.Domain
+	zone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull zone for %s: %w", domain, err)
+	}
+
+	_, profile := p.profileFor(provState)
+	var drifts []Drift
+
+	// Zone name, only reported when not fixing: Bunny cannot rename a pull
+	// zone, so it is not a drift fix could correct
+	if !fix && !slices.Contains(p.names.PullZones(domain), zone.Name) {
+		drifts = append(drifts, Drift{Field: "zone_name", Want: p.names.PullZone(domain), Got: zone.Name})
+	}
+
+	// Tier
+	if zoneType := p.pullZoneType(domain, profile); zoneType != zone.Type {
+		d := Drift{Field: "tier", Want: zoneType.String(), Got: zone.Type.String()}
+		if fix {
+			err := p.bunnyClient.SetPullZoneType(ctx, provState.PullZoneID, zoneType)
+			d.Fixed = err == nil
+			p.logFix(domain, d.Field, err)
+		}
+		drifts = append(drifts, d)
+	}
+
+	// Referrers
+	want := p.referrerSettings(domain, profile)
+	got := bunny.ReferrerSettings{
+		Allowed:         normalizeReferrers(zone.AllowedReferrers),
+		Blocked:         normalizeReferrers(zone.BlockedReferrers),
+		BlockNoReferrer: zone.BlockNoneReferrer,
+	}
+	referrerDrifts := compareReferrers(want, got)
+	if len(referrerDrifts) > 0 && fix {
+		err := p.bunnyClient.SetReferrers(ctx, provState.PullZoneID, want)
+		markFixed(referrerDrifts, err)
+		p.logFix(domain, "referrers", err)
+	}
+	drifts = append(drifts, referrerDrifts...)
+
+	// Access rules
+	rules := p.AccessRules(domain)
+	accessDrifts := compareAccessRules(rules, bunny.AccessRules{
+		BlockedCountries: sortedCopy(zone.BlockedCountries),
+		BlockedIPs:       sortedCopy(zone.BlockedIPs),
+	})
+	if len(accessDrifts) > 0 && fix {
+		err := p.bunnyClient.SetAccessRules(ctx, provState.PullZoneID, rules)
+		markFixed(accessDrifts, err)
+		p.logFix(domain, "access_rules", err)
+	}
+	drifts = append(drifts, accessDrifts...)
+
+	// Origin endpoint
+	endpoint := p.originEndpoint(domain, profile)
+	endpointDrifts := compareOriginEndpoint(endpoint, domain, p.originIP(domain), zone)
+	if len(endpointDrifts) > 0 && fix {
+		err := p.bunnyClient.SetOriginEndpoint(ctx, provState.PullZoneID, domain, p.originIP(domain), endpoint)
+		markFixed(endpointDrifts, err)
+		p.logFix(domain, "origin_endpoint", err)
+	}
+	drifts = append(drifts, endpointDrifts...)
+
+	// Origin outage handling
+	origin := p.originSettings(profile)
+	originDrifts := compareOrigin(origin, zone)
+	if len(originDrifts) > 0 && fix {
+		err := p.bunnyClient.SetOriginSettings(ctx, provState.PullZoneID, origin)
+		markFixed(originDrifts, err)
+		p.logFix(domain, "origin_resilience", err)
+	}
+	drifts = append(drifts, originDrifts...)
+
+	// Request logging, only when the profile configures it
+	if want, ok := loggingSettings(profile); ok {
+		loggingDrifts := compareLogging(want, zone.Logging())
+		if len(loggingDrifts) > 0 && fix {
+			err := p.bunnyClient.SetLogging(ctx, provState.PullZoneID, want)
+			markFixed(loggingDrifts, err)
+			p.logFix(domain, "logging", err)
+		}
+		drifts = append(drifts, loggingDrifts...)
+	}
+
+	// Optimizer
+	optimizer := p.optimizerSettings(domain, profile)
+	if optimizer.Enabled != zone.OptimizerEnabled {
+		d := Drift{Field: "optimizer", Want: onOff(optimizer.Enabled), Got: onOff(zone.OptimizerEnabled)}
+		if fix {
+			err := p.bunnyClient.SetOptimizer(ctx, provState.PullZoneID, optimizer)
+			d.Fixed = err == nil
+			p.logFix(domain, d.Field, err)
+		}
+		drifts = append(drifts, d)
+	}
+
+	// Token authentication
+	if profile.TokenAuth.Enabled != zone.ZoneSecurityEnabled {
+		d := Drift{Field: "token_auth", Want: onOff(profile.TokenAuth.Enabled), Got: onOff(zone.ZoneSecurityEnabled)}
+		if fix {
+			err := p.fixTokenAuth(ctx, provState, profile.TokenAuth.Enabled)
+			d.Fixed = err == nil
+			p.logFix(domain, d.Field, err)
+		}
+		drifts = append(drifts, d)
+	}
+
+	// Perma-Cache
+	if profile.PermaCache.Enabled && provState.StorageZoneID > 0 &&
+		zone.PermaCacheStorageZoneID != provState.StorageZoneID {
+		d := Drift{
+			Field: "perma_cache_storage_zone",
+			Want:  strconv.FormatInt(provState.StorageZoneID, 10),
+			Got:   strconv.FormatInt(zone.PermaCacheStorageZoneID, 10),
+		}
+		if fix {
+			err := p.bunnyClient.SetPermaCacheStorageZone(ctx, provState.PullZoneID, provState.StorageZoneID)
+			d.Fixed = err == nil
+			p.logFix(domain, d.Field, err)
+		}
+		drifts = append(drifts, d)
+	}
+
+	// CDN DNS record, left alone while the domain is bypassed
+	if provState.ZoneID > 0 && provState.CDNHostname != "" && !p.IsBypassed(domain) {
+		d, err := p.checkCDNRecord(ctx, provState, fix)
+		if err != nil {
+			return drifts, err
+		}
+		if d != nil {
+			drifts = append(drifts, *d)
+		}
+	}
+
+	return drifts, nil
+}
+
+// checkCDNRecord verifies the DNS record routing a domain through its pull
+// zone still points at the CDN hostname
+func (p *Provisioner) checkCDNRecord(ctx context.Context, provState *state.ProvisionState, fix bool) (*Drift, error) {
+	name, err := p.cdnRecordName(ctx, provState)
+	if err != nil {
+		return nil, err
+	}
+	records, err := p.bunnyClient.GetDNSRecords(ctx, provState.ZoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS records: %w", err)
+	}
+
+	var current *bunny.DNSRecord
+	for i, r := range records {
+		if r.Name != name || (r.Type != bunny.DNSRecordTypeCNAME && r.Type != bunny.DNSRecordTypeA) {
+			continue
+		}
+		if r.Type == bunny.DNSRecordTypeCNAME && sameHost(r.Value, provState.CDNHostname) {
+			// A record disabled on purpose is left alone
+			if r.Enabled || p.recordDisabled(provState.Domain, r.ID) {
+				return nil, nil
+			}
+		}
+		current = &records[i]
+	}
+
+	d := &Drift{Field: "cdn_record", Want: "CNAME " + provState.CDNHostname, Got: "(missing)"}
+	if current != nil {
+		d.Got = current.Type.String() + " " + current.Value
+		if !current.Enabled {
+			d.Got += " (disabled)"
+		}
+	}
+	if !fix {
+		return d, nil
+	}
+
+	if current != nil {
+		err = p.bunnyClient.UpdateDNSRecord(ctx, provState.ZoneID, current.ID, &bunny.UpdateDNSRecordRequest{
+			Type:    bunny.DNSRecordTypeCNAME,
+			Name:    name,
+			Value:   provState.CDNHostname,
+			TTL:     defaultDNSRecordTTL,
+			Enabled: true,
+		})
+	} else {
+		_, err = p.bunnyClient.AddDNSRecord(ctx, provState.ZoneID, &bunny.AddDNSRecordRequest{
+			Type:    bunny.DNSRecordTypeCNAME,
+			Name:    name,
+			Value:   provState.CDNHostname,
+			TTL:     defaultDNSRecordTTL,
+			Enabled: true,
+		})
+	}
+	d.Fixed = err == nil
+	p.logFix(provState.Domain, d.Field, err)
+	return d, nil
+}
+
+// fixTokenAuth turns token authentication back on (creating a key if none
+// is stored) or off
+func (p *Provisioner) fixTokenAuth(ctx context.Context, provState *state.ProvisionState, enabled bool) error {
+	if enabled && p.tokenKeys != nil {
+		if _, ok := p.tokenKeys.Get(provState.Domain); !ok {
+			return p.ensureTokenAuth(ctx, provState.Domain, provState)
+		}
+	}
+	return p.bunnyClient.SetTokenAuthentication(ctx, provState.PullZoneID, enabled)
+}
+
+// logFix logs the outcome of a drift correction
+func (p *Provisioner) logFix(domain, field string, err error) {
+	if err != nil {
+		p.logger.Warn("failed to correct drift",
+			zap.String("domain", domain),
+			zap.String("field", field),
+			zap.Error(err),
+		)
+		return
+	}
+	p.logger.Info("drift corrected",
+		zap.String("domain", domain),
+		zap.String("field", field),
+	)
+}
+
+// compareReferrers returns the referrer fields that differ
+func compareReferrers(want, got bunny.ReferrerSettings) []Drift {
+	var drifts []Drift
+	if !equalStrings(want.Allowed, got.Allowed) {
+		drifts = append(drifts, Drift{Field: "allowed_referrers", Want: listString(want.Allowed), Got: listString(got.Allowed)})
+	}
+	if !equalStrings(want.Blocked, got.Blocked) {
+		drifts = append(drifts, Drift{Field: "blocked_referrers", Want: listString(want.Blocked), Got: listString(got.Blocked)})
+	}
+	if want.BlockNoReferrer != got.BlockNoReferrer {
+		drifts = append(drifts, Drift{Field: "block_no_referrer", Want: onOff(want.BlockNoReferrer), Got: onOff(got.BlockNoReferrer)})
+	}
+	return drifts
+}
+
+// compareAccessRules returns the access rule fields that differ
+func compareAccessRules(want, got bunny.AccessRules) []Drift {
+	var drifts []Drift
+	if !equalStrings(want.BlockedCountries, got.BlockedCountries) {
+		drifts = append(drifts, Drift{Field: "blocked_countries", Want: listString(want.BlockedCountries), Got: listString(got.BlockedCountries)})
+	}
+	if !equalStrings(want.BlockedIPs, got.BlockedIPs) {
+		drifts = append(drifts, Drift{Field: "blocked_ips", Want: listString(want.BlockedIPs), Got: listString(got.BlockedIPs)})
+	}
+	return drifts
+}
+
+// compareOrigin returns the origin outage handling fields that differ
+func compareOrigin(want bunny.OriginSettings, zone *bunny.PullZone) []Drift {
+	var drifts []Drift
+	if want.StaleWhileOffline != zone.UseStaleWhileOffline {
+		drifts = append(drifts, Drift{Field: "stale_while_offline", Want: onOff(want.StaleWhileOffline), Got: onOff(zone.UseStaleWhileOffline)})
+	}
+	if want.StaleWhileUpdating != zone.UseStaleWhileUpdating {
+		drifts = append(drifts, Drift{Field: "stale_while_updating", Want: onOff(want.StaleWhileUpdating), Got: onOff(zone.UseStaleWhileUpdating)})
+	}
+	if want.ConnectTimeout != zone.OriginConnectTimeout {
+		drifts = append(drifts, Drift{Field: "origin_connect_timeout", Want: strconv.Itoa(want.ConnectTimeout), Got: strconv.Itoa(zone.OriginConnectTimeout)})
+	}
+	if want.ResponseTimeout != zone.OriginResponseTimeout {
+		drifts = append(drifts, Drift{Field: "origin_response_timeout", Want: strconv.Itoa(want.ResponseTimeout), Got: strconv.Itoa(zone.OriginResponseTimeout)})
+	}
+	if want.Retries != zone.OriginRetries {
+		drifts = append(drifts, Drift{Field: "origin_retries", Want: strconv.Itoa(want.Retries), Got: strconv.Itoa(zone.OriginRetries)})
+	}
+	return drifts
+}
+
+// compareLogging returns the request logging settings that differ. The
+// anonymization and forwarding only matter while logging is on, and the
+// forwarding token is not compared so it never shows in a report
+func compareLogging(want, got bunny.LoggingSettings) []Drift {
+	if want.Enabled != got.Enabled {
+		return []Drift{{Field: "logging", Want: onOff(want.Enabled), Got: onOff(got.Enabled)}}
+	}
+	if !want.Enabled {
+		return nil
+	}
+
+	var drifts []Drift
+	if want.Anonymization != got.Anonymization {
+		drifts = append(drifts, Drift{Field: "log_anonymization", Want: want.Anonymization, Got: got.Anonymization})
+	}
+	if want.Forwarding.String() != got.Forwarding.String() {
+		drifts = append(drifts, Drift{Field: "log_forwarding", Want: want.Forwarding.String(), Got: got.Forwarding.String()})
+	}
+	return drifts
+}
+
+// markFixed records the outcome of a correction covering several drifts
+func markFixed(drifts []Drift, err error) {
+	for i := range drifts {
+		drifts[i].Fixed = err == nil
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedCopy(values []string) []string {
+	result := append([]string{}, values...)
+	sort.Strings(result)
+	return result
+}
+
+func listString(values []string) string {
+	if len(values) == 0 {
+		return "(none)"
+	}
+	return strings.Join(values, ", ")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
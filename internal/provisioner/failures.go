@@ -0,0 +1,47 @@
+package provisioner
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/failures"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// SetFailures attaches the store failed runs are counted in
+func (p *Provisioner) SetFailures(s *failures.Store) {
+	p.failures = s
+}
+
+// classifyError returns the class of an error a provisioning run failed with
+func classifyError(err error) state.ErrorClass {
+	if errors.Is(err, ErrOriginCheck) {
+		return state.ErrorClassOrigin
+	}
+	return failures.Classify(err)
+}
+
+// recordFailure marks a state failed with the class of err and counts the
+// failure for the weekly breakdown
+func (p *Provisioner) recordFailure(id, domain string, err error) {
+	class := classifyError(err)
+	if setErr := p.stateManager.SetFailure(id, err.Error(), class); setErr != nil {
+		p.logger.Error("failed to set error state",
+			zap.String("domain", domain),
+			zap.Error(setErr),
+		)
+	}
+
+	if p.failures == nil {
+		return
+	}
+	if recErr := p.failures.Record(class, time.Time{}); recErr != nil {
+		p.logger.Warn("failed to record failure",
+			zap.String("domain", domain),
+			zap.String("error_class", string(class)),
+			zap.Error(recErr),
+		)
+	}
+}
@@ -0,0 +1,87 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+)
+
+var (
+	// ErrFrozen is returned for automation skipped because the domain is frozen
+	ErrFrozen = errors.New("domain is frozen")
+	// ErrNotFrozen is returned when unfreezing a domain that is not frozen
+	ErrNotFrozen = errors.New("domain is not frozen")
+)
+
+// SetFreeze attaches the store of frozen domains
+func (p *Provisioner) SetFreeze(s *freeze.Store) {
+	p.freeze = s
+}
+
+// IsFrozen reports whether automation is suspended for a domain, because
+// it or its parent domain is frozen
+func (p *Provisioner) IsFrozen(domain string) bool {
+	if p.freeze == nil {
+		return false
+	}
+	_, _, ok := p.freeze.Frozen(domain)
+	return ok
+}
+
+// Freeze suspends recovery, drift enforcement, subdomain discovery and
+// webhook events for a domain and its subdomains until Unfreeze, so
+// support can change its DNS by hand without automation reverting it
+func (p *Provisioner) Freeze(domain, reason string) error {
+	if p.freeze == nil {
+		return fmt.Errorf("freeze store not configured")
+	}
+	if _, err := p.stateManager.GetByDomain(domain); err != nil {
+		return fmt.Errorf("%s: %w", domain, err)
+	}
+	if err := p.freeze.Set(domain, freeze.Entry{Reason: reason}); err != nil {
+		return fmt.Errorf("failed to save freeze: %w", err)
+	}
+
+	p.logger.Info("domain frozen",
+		zap.String("domain", domain),
+		zap.String("reason", reason),
+	)
+	return nil
+}
+
+// Unfreeze resumes automation for a frozen domain
+func (p *Provisioner) Unfreeze(domain string) error {
+	if p.freeze == nil {
+		return fmt.Errorf("freeze store not configured")
+	}
+	if _, ok := p.freeze.Get(domain); !ok {
+		return fmt.Errorf("%s: %w", domain, ErrNotFrozen)
+	}
+	if err := p.freeze.Delete(domain); err != nil {
+		return fmt.Errorf("failed to save freeze: %w", err)
+	}
+
+	p.logger.Info("domain unfrozen", zap.String("domain", domain))
+	return nil
+}
+
+// skipFrozen logs and reports whether action on domain is skipped because
+// the domain is frozen
+func (p *Provisioner) skipFrozen(domain, action string) bool {
+	if p.freeze == nil {
+		return false
+	}
+	frozen, entry, ok := p.freeze.Frozen(domain)
+	if !ok {
+		return false
+	}
+	p.logger.Info("domain frozen, skipping "+action,
+		zap.String("domain", domain),
+		zap.String("frozen_domain", frozen),
+		zap.String("reason", entry.Reason),
+	)
+	return true
+}
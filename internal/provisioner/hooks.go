@@ -0,0 +1,60 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/hooks"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// SetHooks attaches the operator-configured provisioning hooks
+func (p *Provisioner) SetHooks(r *hooks.Runner) {
+	p.hooks = r
+}
+
+// runHooks runs the hooks for event with the domain's current state
+func (p *Provisioner) runHooks(ctx context.Context, event hooks.Event, provState *state.ProvisionState) error {
+	if p.hooks == nil {
+		return nil
+	}
+	return p.hooks.Run(ctx, hooks.Data{
+		Event:        event,
+		Domain:       provState.Domain,
+		ParentDomain: provState.ParentDomain,
+		User:         provState.User,
+		ZoneID:       provState.ZoneID,
+		PullZoneID:   provState.PullZoneID,
+		CDNHostname:  provState.CDNHostname,
+	})
+}
+
+// runSavedHooks runs the hooks for event with the saved state of id, which
+// holds everything the provisioning steps recorded
+func (p *Provisioner) runSavedHooks(ctx context.Context, event hooks.Event, id string) error {
+	if p.hooks == nil {
+		return nil
+	}
+	provState, err := p.stateManager.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := p.runHooks(ctx, event, provState); err != nil {
+		return fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return nil
+}
+
+// notifyHooks runs the hooks for an event that cannot fail the operation,
+// such as a completed deprovision
+func (p *Provisioner) notifyHooks(ctx context.Context, event hooks.Event, provState *state.ProvisionState) {
+	if err := p.runHooks(ctx, event, provState); err != nil {
+		p.logger.Warn("provisioning hooks failed",
+			zap.String("event", string(event)),
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+	}
+}
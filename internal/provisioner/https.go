@@ -0,0 +1,140 @@
+package provisioner
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// certificatePollInterval is how often provisioning checks whether Bunny
+// issued the free certificates it requested
+const certificatePollInterval = 5 * time.Second
+
+// cdnHostnames returns the hostnames of a domain the DNS record strategy
+// routes through the CDN, which are the ones a free certificate can be
+// issued for
+func (p *Provisioner) cdnHostnames(domain string) []string {
+	names := p.config.DNS.CDNRecordNames()
+	hostnames := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == "@" {
+			hostnames = append(hostnames, domain)
+			continue
+		}
+		hostnames = append(hostnames, name+"."+domain)
+	}
+	return hostnames
+}
+
+// secureHostnames loads a free certificate for each hostname of the pull
+// zone, waits up to cdn.https.timeout for Bunny to issue them and then
+// forces HTTPS on the ones that have one. Issuance needs the hostname to
+// resolve to the CDN, which may only happen once the customer's
+// nameservers point at Bunny, so a certificate that is not issued in time
+// is logged, not an error
+func (p *Provisioner) secureHostnames(ctx context.Context, provState *state.ProvisionState, hostnames []string) {
+	cfg := p.config.CDN.HTTPS
+	if !cfg.Enabled || provState.PullZoneID <= 0 || len(hostnames) == 0 {
+		return
+	}
+
+	pullZone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+	if err != nil {
+		p.logger.Warn("failed to get pull zone for free certificates",
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+		return
+	}
+
+	requested := make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		h := pullZone.FindHostname(hostname)
+		if h == nil {
+			p.logger.Warn("hostname is not on the pull zone, skipping free certificate",
+				zap.String("domain", provState.Domain),
+				zap.String("hostname", hostname),
+			)
+			continue
+		}
+		if !h.HasCertificate {
+			if err := p.bunnyClient.LoadFreeCertificate(ctx, hostname); err != nil {
+				p.logger.Warn("failed to request free certificate",
+					zap.String("domain", provState.Domain),
+					zap.String("hostname", hostname),
+					zap.Error(err),
+				)
+				continue
+			}
+		}
+		requested = append(requested, hostname)
+	}
+	if len(requested) == 0 {
+		return
+	}
+
+	pullZone = p.waitForCertificates(ctx, provState, requested, cfg.Timeout)
+	if pullZone == nil {
+		return
+	}
+	for _, hostname := range requested {
+		h := pullZone.FindHostname(hostname)
+		if h == nil || !h.HasCertificate {
+			p.logger.Warn("free certificate not issued yet",
+				zap.String("domain", provState.Domain),
+				zap.String("hostname", hostname),
+				zap.Duration("timeout", cfg.Timeout),
+			)
+			continue
+		}
+		if !cfg.ForceSSL || h.ForceSSL {
+			continue
+		}
+		if err := p.bunnyClient.SetHostnameForceSSL(ctx, provState.PullZoneID, hostname, true); err != nil {
+			p.logger.Warn("failed to force SSL on hostname",
+				zap.String("domain", provState.Domain),
+				zap.String("hostname", hostname),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// waitForCertificates polls the pull zone until every hostname has a
+// certificate or timeout passes, and returns the pull zone last read, or
+// nil when it could not be read
+func (p *Provisioner) waitForCertificates(ctx context.Context, provState *state.ProvisionState, hostnames []string, timeout time.Duration) *bunny.PullZone {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		pullZone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+		if err != nil {
+			p.logger.Warn("failed to check certificate issuance",
+				zap.String("domain", provState.Domain),
+				zap.Error(err),
+			)
+			return nil
+		}
+
+		issued := true
+		for _, hostname := range hostnames {
+			if h := pullZone.FindHostname(hostname); h == nil || !h.HasCertificate {
+				issued = false
+				break
+			}
+		}
+		if issued || !time.Now().Before(deadline) {
+			return pullZone
+		}
+
+		select {
+		case <-ctx.Done():
+			return pullZone
+		case <-time.After(certificatePollInterval):
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// ErrEmptyInventory is returned when orphans would be deprovisioned while
+// cPanel lists no domains, which would remove every provisioned domain
+var ErrEmptyInventory = errors.New("cPanel lists no domains; refusing to deprovision every domain")
+
+// InventoryEntry is a domain in one of the buckets of an Inventory
+type InventoryEntry struct {
+	Domain string `json:"domain"`
+	// Parent is set for subdomains
+	Parent string `json:"parent,omitempty"`
+	User   string `json:"user,omitempty"`
+	// Type is the cPanel domain type: main, addon, sub or parked
+	Type string `json:"type,omitempty"`
+	// Status is the provisioning status of domains with a state
+	Status state.Status `json:"status,omitempty"`
+	// Error is set when provisioning or deprovisioning the domain failed
+	Error string `json:"error,omitempty"`
+}
+
+// Inventory compares the domains on the cPanel server with the
+// provisioning states
+type Inventory struct {
+	// Present domains are provisioned and on the cPanel server
+	Present []InventoryEntry `json:"present"`
+	// Unprovisioned domains are on the cPanel server without a state
+	Unprovisioned []InventoryEntry `json:"unprovisioned"`
+	// Orphaned domains are provisioned but no longer on the cPanel server
+	Orphaned []InventoryEntry `json:"orphaned"`
+}
+
+// Inventory sorts the cPanel domains and the provisioning states into the
+// three buckets of an Inventory. Parked domains and the subdomains backing
+// addon domains are never listed as unprovisioned, as the webhook does not
+// provision them either. provisionMissing provisions the unprovisioned
+// domains and deprovisionOrphans removes the orphaned ones; frozen domains
+// are left alone
+func (p *Provisioner) Inventory(ctx context.Context, domains []CPanelDomain, provisionMissing, deprovisionOrphans bool) (*Inventory, error) {
+	if deprovisionOrphans && len(domains) == 0 {
+		return nil, ErrEmptyInventory
+	}
+
+	inv := p.inventory(domains)
+
+	if provisionMissing {
+		for i := range inv.Unprovisioned {
+			if ctx.Err() != nil {
+				break
+			}
+			p.provisionMissing(&inv.Unprovisioned[i])
+		}
+	}
+	if deprovisionOrphans {
+		for i := range inv.Orphaned {
+			if ctx.Err() != nil {
+				break
+			}
+			p.deprovisionOrphan(&inv.Orphaned[i])
+		}
+	}
+	return inv, nil
+}
+
+// inventory builds the buckets. Unprovisioned main and addon domains come
+// before subdomains, so subdomains are provisioned after their parent;
+// orphaned subdomains come first, so they are removed before their parent
+func (p *Provisioner) inventory(domains []CPanelDomain) *Inventory {
+	onServer := make(map[string]CPanelDomain, len(domains))
+	addonBacking := make(map[string]bool)
+	parents := make(map[string]bool)
+	for _, d := range domains {
+		onServer[d.Domain] = d
+		switch d.Type {
+		case "addon":
+			addonBacking[d.Target] = true
+			parents[d.Domain] = true
+		case "main":
+			parents[d.Domain] = true
+		}
+	}
+
+	inv := &Inventory{
+		Present:       []InventoryEntry{},
+		Unprovisioned: []InventoryEntry{},
+		Orphaned:      []InventoryEntry{},
+	}
+	provisioned := make(map[string]bool)
+	for _, st := range p.AllStates() {
+		provisioned[st.Domain] = true
+		entry := InventoryEntry{Domain: st.Domain, Parent: st.ParentDomain, User: st.User, Status: st.Status}
+		if d, ok := onServer[st.Domain]; ok {
+			entry.Type = d.Type
+			inv.Present = append(inv.Present, entry)
+		} else {
+			inv.Orphaned = append(inv.Orphaned, entry)
+		}
+	}
+
+	for _, d := range domains {
+		if provisioned[d.Domain] || d.Type == "parked" || (d.Type == "sub" && addonBacking[d.Domain]) {
+			continue
+		}
+		entry := InventoryEntry{Domain: d.Domain, User: d.User, Type: d.Type}
+		if d.Type == "sub" {
+			entry.Parent = parentDomain(d.Domain, parents)
+			if entry.Parent == "" {
+				continue
+			}
+		}
+		inv.Unprovisioned = append(inv.Unprovisioned, entry)
+	}
+
+	sort.Slice(inv.Present, func(i, j int) bool { return inv.Present[i].Domain < inv.Present[j].Domain })
+	sort.Slice(inv.Unprovisioned, func(i, j int) bool {
+		a, b := inv.Unprovisioned[i], inv.Unprovisioned[j]
+		if (a.Parent == "") != (b.Parent == "") {
+			return a.Parent == ""
+		}
+		return a.Domain < b.Domain
+	})
+	sort.Slice(inv.Orphaned, func(i, j int) bool {
+		a, b := inv.Orphaned[i], inv.Orphaned[j]
+		if (a.Parent == "") != (b.Parent == "") {
+			return a.Parent != ""
+		}
+		return a.Domain < b.Domain
+	})
+	return inv
+}
+
+// provisionMissing provisions an unprovisioned domain
+func (p *Provisioner) provisionMissing(entry *InventoryEntry) {
+	if p.skipFrozen(entry.Domain, "inventory provisioning") {
+		entry.Error = ErrFrozen.Error()
+		return
+	}
+
+	var err error
+	if entry.Parent == "" {
+		err = p.Provision(entry.Domain, entry.User)
+	} else {
+		err = p.ProvisionSubdomain(strings.TrimSuffix(entry.Domain, "."+entry.Parent), entry.Parent, entry.User)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		p.logger.Warn("failed to provision domain missing from inventory",
+			zap.String("domain", entry.Domain),
+			zap.Error(err),
+		)
+		return
+	}
+	if st, err := p.stateManager.GetByDomain(entry.Domain); err == nil {
+		entry.Status = st.Status
+	}
+}
+
+// deprovisionOrphan removes a domain no longer on the cPanel server
+func (p *Provisioner) deprovisionOrphan(entry *InventoryEntry) {
+	if p.skipFrozen(entry.Domain, "inventory deprovisioning") {
+		entry.Error = ErrFrozen.Error()
+		return
+	}
+
+	var err error
+	if entry.Parent == "" {
+		err = p.Deprovision(entry.Domain)
+	} else {
+		err = p.RemoveSubdomain(strings.TrimSuffix(entry.Domain, "."+entry.Parent), entry.Parent)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		p.logger.Warn("failed to deprovision orphaned domain",
+			zap.String("domain", entry.Domain),
+			zap.Error(err),
+		)
+	}
+}
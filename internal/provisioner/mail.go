@@ -0,0 +1,30 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+)
+
+// mailRecords returns the MX and SPF records of a new DNS zone for the
+// domain's mail preset; an empty or unknown preset is the cPanel one
+func mailRecords(domain, preset string) (mx, spf *bunny.AddDNSRecordRequest) {
+	switch preset {
+	case overrides.MailPresetGoogle:
+		mx = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeMX, Name: "@", Value: "smtp.google.com.", Priority: 1}
+		spf = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeTXT, Name: "@", Value: "v=spf1 include:_spf.google.com ~all"}
+	case overrides.MailPresetMicrosoft:
+		// Microsoft 365 receives mail for example.com at example-com.mail.protection.outlook.com
+		host := strings.ReplaceAll(domain, ".", "-") + ".mail.protection.outlook.com."
+		mx = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeMX, Name: "@", Value: host, Priority: 0}
+		spf = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeTXT, Name: "@", Value: "v=spf1 include:spf.protection.outlook.com -all"}
+	case overrides.MailPresetNone:
+		spf = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeTXT, Name: "@", Value: "v=spf1 -all"}
+	default:
+		mx = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeMX, Name: "@", Value: fmt.Sprintf("mail.%s.", domain), Priority: 10}
+		spf = &bunny.AddDNSRecordRequest{Type: bunny.DNSRecordTypeTXT, Name: "@", Value: "v=spf1 a mx -all"}
+	}
+	return mx, spf
+}
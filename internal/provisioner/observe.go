@@ -0,0 +1,179 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/observe"
+)
+
+// Observer stands in for the Provisioner while provisioning is disabled:
+// it records and notifies what events would provision or remove, with the
+// plan the Provisioner would follow, and never calls Bunny or changes the
+// provisioning state
+// It implements the webhook.Provisioner, PackageAssigner and
+// ResellerAssigner interfaces
+type Observer struct {
+	provisioner *Provisioner
+	store       *observe.Store
+
+	// primed is set once Discover recorded the domains already on the
+	// server, which are not notified
+	primed bool
+}
+
+// NewObserver creates an observer planning with p and recording to store
+func NewObserver(p *Provisioner, store *observe.Store) *Observer {
+	return &Observer{provisioner: p, store: store}
+}
+
+// Observations returns what was observed, first seen first
+func (o *Observer) Observations() []observe.Observation {
+	return o.store.List()
+}
+
+// Provision records that domain would be provisioned
+func (o *Observer) Provision(domain, user string) error {
+	return o.observe(domain, "", user, observe.ActionProvision, observe.SourceWebhook)
+}
+
+// ProvisionSubdomain records that a subdomain would be provisioned
+func (o *Observer) ProvisionSubdomain(subdomain, parentDomain, user string) error {
+	return o.observe(subdomain+"."+parentDomain, parentDomain, user, observe.ActionProvision, observe.SourceWebhook)
+}
+
+// Deprovision records that domain would be removed
+func (o *Observer) Deprovision(domain string) error {
+	return o.observe(domain, "", "", observe.ActionDeprovision, observe.SourceWebhook)
+}
+
+// RemoveSubdomain records that a subdomain would be removed
+func (o *Observer) RemoveSubdomain(subdomain, parentDomain string) error {
+	return o.observe(subdomain+"."+parentDomain, parentDomain, "", observe.ActionDeprovision, observe.SourceWebhook)
+}
+
+// AssignPackage records the WHM package, which selects the planned profile
+func (o *Observer) AssignPackage(domain, pkg string) error {
+	_, err := o.store.Update(domain, func(ob *observe.Observation) {
+		ob.Package = pkg
+	})
+	return err
+}
+
+// AssignReseller records the WHM reseller, which selects the planned SOA
+// email
+func (o *Observer) AssignReseller(domain, reseller string) error {
+	_, err := o.store.Update(domain, func(ob *observe.Observation) {
+		ob.Reseller = reseller
+	})
+	return err
+}
+
+// Discover records the cPanel domains without a provisioning state, which
+// inventory would provision, and returns how many were new. Domains
+// already observed are left alone. The first call records the domains on
+// the server without notifying them; later calls notify the domains that
+// appeared since
+func (o *Observer) Discover(ctx context.Context) (int, error) {
+	domains, err := o.provisioner.CPanelDomains()
+	if err != nil {
+		return 0, err
+	}
+	inv := o.provisioner.inventory(domains)
+
+	found := 0
+	for _, entry := range inv.Unprovisioned {
+		if ctx.Err() != nil {
+			break
+		}
+		if ob, ok := o.store.Get(entry.Domain); ok && ob.Events > 0 {
+			continue
+		}
+		if err := o.record(entry.Domain, entry.Parent, entry.User, observe.ActionProvision, observe.SourceDiscovery, o.primed); err != nil {
+			return found, err
+		}
+		found++
+	}
+	o.primed = true
+	return found, nil
+}
+
+// observe records an event for domain and notifies it, unless it repeats
+// the action last recorded
+func (o *Observer) observe(domain, parent, user string, action observe.Action, source string) error {
+	return o.record(domain, parent, user, action, source, true)
+}
+
+// record records an event for domain, and notifies it when notify is set
+// and it does not repeat the action last recorded
+func (o *Observer) record(domain, parent, user string, action observe.Action, source string, notify bool) error {
+	p := o.provisioner
+
+	prev, _ := o.store.Get(domain)
+	ob, err := o.store.Update(domain, func(ob *observe.Observation) {
+		ob.ParentDomain = parent
+		if user != "" {
+			ob.User = user
+		}
+		ob.Action = action
+		ob.Source = source
+		ob.Events++
+		o.plan(ob)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record observation for %s: %w", domain, err)
+	}
+
+	p.logger.Info("provisioning disabled, observed "+string(action),
+		zap.String("domain", ob.Domain),
+		zap.String("user", ob.User),
+		zap.String("source", source),
+		zap.String("pull_zone", ob.PullZone),
+	)
+
+	if !notify || (prev.Events > 0 && prev.Action == action) {
+		return nil
+	}
+	err = p.notifier.NotifyObserved(context.Background(), notifier.ObservedMessage{
+		Domain:    ob.Domain,
+		Parent:    ob.ParentDomain,
+		User:      ob.User,
+		Reseller:  ob.Reseller,
+		Package:   ob.Package,
+		Action:    string(ob.Action),
+		Source:    ob.Source,
+		Profile:   ob.Profile,
+		PullZone:  ob.PullZone,
+		Hostnames: ob.Hostnames,
+		SOAEmail:  ob.SOAEmail,
+	})
+	if err != nil {
+		p.logger.Warn("failed to send observed notification",
+			zap.String("domain", ob.Domain),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+// plan fills in what the Provisioner would create for an observation.
+// Reseller emails are not looked up, so the SOA email is the configured one
+func (o *Observer) plan(ob *observe.Observation) {
+	cfg := o.provisioner.config
+
+	ob.Profile, _ = cfg.Profiles.Resolve(ob.Package)
+	ob.PullZone = o.provisioner.names.PullZone(ob.Domain)
+	if ob.ParentDomain != "" {
+		ob.Hostnames = []string{ob.Domain}
+		ob.SOAEmail = ""
+		return
+	}
+	ob.Hostnames = o.provisioner.cdnHostnames(ob.Domain)
+	ob.SOAEmail = cfg.DNS.SOAEmail
+	if email, ok := cfg.DNS.ResellerSOAEmail(ob.Reseller); ok {
+		ob.SOAEmail = email
+	}
+}
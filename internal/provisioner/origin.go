@@ -0,0 +1,233 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// ErrOriginCheck is returned when the pre-flight request to the origin fails
+var ErrOriginCheck = errors.New("origin pre-flight check failed")
+
+// originEndpoint returns how a domain's pull zone reaches the origin: the
+// origin defaults, the profile's overrides, then the domain's overrides
+func (p *Provisioner) originEndpoint(domain string, profile config.ProfileConfig) bunny.OriginEndpoint {
+	return applyOriginOverride(p.config.Origin.Merge(profile.Origin), p.overrideFor(domain))
+}
+
+// applyOriginOverride applies a domain's origin overrides to the merged config
+func applyOriginOverride(cfg config.OriginConfig, o overrides.Override) bunny.OriginEndpoint {
+	endpoint := bunny.OriginEndpoint{
+		Protocol:   cfg.Protocol,
+		Port:       cfg.Port,
+		HostHeader: cfg.HostHeader,
+		VerifySSL:  cfg.VerifySSL,
+	}
+	if o.OriginProtocol != "" {
+		endpoint.Protocol = o.OriginProtocol
+	}
+	if o.OriginPort != nil {
+		endpoint.Port = *o.OriginPort
+	}
+	if o.OriginVerifySSL != nil {
+		endpoint.VerifySSL = *o.OriginVerifySSL
+	}
+	if o.OriginHostHeader != "" {
+		endpoint.HostHeader = o.OriginHostHeader
+	}
+	return endpoint
+}
+
+// isDefaultEndpoint reports whether an endpoint is plain http on port 80 with
+// the domain as host header, the setup every origin is assumed to serve
+func isDefaultEndpoint(e bunny.OriginEndpoint) bool {
+	return e.Scheme() == "http" && (e.Port == 0 || e.Port == 80) && e.HostHeader == ""
+}
+
+// OriginIP returns the IP of the server hosting a domain
+func (p *Provisioner) OriginIP(domain string) string {
+	return p.originIP(domain)
+}
+
+// originIP returns the IP of the server hosting a domain: the origin_ip
+// override of the domain, else of its parent domain, else origin.ip
+func (p *Provisioner) originIP(domain string) string {
+	if ip := p.overrideFor(domain).OriginIP; ip != "" {
+		return ip
+	}
+	if provState, err := p.stateManager.GetByDomain(domain); err == nil && provState.ParentDomain != "" {
+		if ip := p.overrideFor(provState.ParentDomain).OriginIP; ip != "" {
+			return ip
+		}
+	}
+	return p.config.Origin.IP
+}
+
+// OriginEndpoint returns the effective origin endpoint for a domain
+func (p *Provisioner) OriginEndpoint(domain string) bunny.OriginEndpoint {
+	return p.originEndpoint(domain, p.domainProfile(domain))
+}
+
+// domainProfile returns the profile of a domain, or the default profile when
+// it has no state yet
+func (p *Provisioner) domainProfile(domain string) config.ProfileConfig {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		provState = &state.ProvisionState{Domain: domain}
+	}
+	_, profile := p.profileFor(provState)
+	return profile
+}
+
+// CheckOrigin sends a pre-flight request to the origin the way the pull
+// zone would: same protocol, port, host header and certificate checks
+func (p *Provisioner) CheckOrigin(ctx context.Context, domain string, endpoint bunny.OriginEndpoint) error {
+	status, err := p.ProbeOrigin(ctx, domain, endpoint)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %s (Host: %s) returned %d", ErrOriginCheck,
+			endpoint.URL(p.originIP(domain))+"/", endpoint.Host(domain), status)
+	}
+	return nil
+}
+
+// ProbeOrigin sends a HEAD request to the origin the way the pull zone
+// would and returns the response status; an error means no response
+func (p *Provisioner) ProbeOrigin(ctx context.Context, domain string, endpoint bunny.OriginEndpoint) (int, error) {
+	resilience := p.originSettings(p.domainProfile(domain))
+	timeout := time.Duration(resilience.ConnectTimeout+resilience.ResponseTimeout) * time.Second
+
+	url := endpoint.URL(p.originIP(domain)) + "/"
+	host := endpoint.Host(domain)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrOriginCheck, err)
+	}
+	req.Host = host
+
+	resp, err := originClient(host, !endpoint.VerifySSL, timeout).Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s (Host: %s): %v", ErrOriginCheck, url, host, err)
+	}
+	resp.Body.Close()
+
+	p.logger.Debug("origin probed",
+		zap.String("domain", domain),
+		zap.String("url", url),
+		zap.String("host", host),
+		zap.Int("status", resp.StatusCode),
+	)
+	return resp.StatusCode, nil
+}
+
+// originClient returns a client requesting the origin the way the pull zone
+// does: host is the TLS server name and insecure mirrors its verify_ssl
+// setting. The pull zone passes redirects through, so they are not followed
+func originClient(host string, insecure bool, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         host,
+				InsecureSkipVerify: insecure,
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// verifyOrigin runs the pre-flight check before a pull zone is created;
+// default endpoints are not checked
+func (p *Provisioner) verifyOrigin(ctx context.Context, domain string, endpoint bunny.OriginEndpoint) error {
+	if isDefaultEndpoint(endpoint) {
+		return nil
+	}
+	return p.CheckOrigin(ctx, domain, endpoint)
+}
+
+// UpdateOrigin changes a domain's origin overrides and points its pull zone
+// at the new endpoint. Non-default endpoints are checked first unless
+// skipCheck is set; nothing is saved when the check fails
+// For domains without a pull zone the override is saved and
+// ErrNotProvisioned is returned
+func (p *Provisioner) UpdateOrigin(ctx context.Context, domain string, fn func(o *overrides.Override), skipCheck bool) (bunny.OriginEndpoint, error) {
+	if p.overrides == nil {
+		return bunny.OriginEndpoint{}, fmt.Errorf("override store not configured")
+	}
+
+	candidate := p.overrideFor(domain)
+	fn(&candidate)
+	endpoint := applyOriginOverride(p.config.Origin.Merge(p.domainProfile(domain).Origin), candidate)
+	if err := validateEndpoint(endpoint); err != nil {
+		return endpoint, err
+	}
+
+	if !skipCheck {
+		if err := p.verifyOrigin(ctx, domain, endpoint); err != nil {
+			return endpoint, err
+		}
+	}
+
+	if err := p.overrides.Update(domain, fn); err != nil {
+		return endpoint, fmt.Errorf("failed to save origin for %s: %w", domain, err)
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return endpoint, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	if err := p.bunnyClient.SetOriginEndpoint(ctx, provState.PullZoneID, domain, p.originIP(domain), endpoint); err != nil {
+		return endpoint, fmt.Errorf("failed to update origin for %s: %w", domain, err)
+	}
+
+	return endpoint, nil
+}
+
+// validateEndpoint checks per-domain origin settings
+func validateEndpoint(e bunny.OriginEndpoint) error {
+	switch e.Scheme() {
+	case "http", "https":
+	default:
+		return fmt.Errorf("invalid origin protocol %q (expected http or https)", e.Protocol)
+	}
+	if e.Port < 0 || e.Port > 65535 {
+		return fmt.Errorf("invalid origin port %d", e.Port)
+	}
+	if strings.ContainsAny(e.HostHeader, " /:\t") {
+		return fmt.Errorf("invalid origin host header %q", e.HostHeader)
+	}
+	return nil
+}
+
+// compareOriginEndpoint compares a pull zone's origin with the endpoint it should use
+func compareOriginEndpoint(want bunny.OriginEndpoint, domain, originIP string, zone *bunny.PullZone) []Drift {
+	var drifts []Drift
+	wantURL := want.URL(originIP)
+	if !strings.EqualFold(wantURL, strings.TrimSuffix(zone.OriginURL, "/")) {
+		drifts = append(drifts, Drift{Field: "origin_url", Want: wantURL, Got: zone.OriginURL})
+	}
+	if host := want.Host(domain); !strings.EqualFold(host, zone.OriginHostHeader) {
+		drifts = append(drifts, Drift{Field: "origin_host_header", Want: host, Got: zone.OriginHostHeader})
+	}
+	// Certificate verification only matters for https origins
+	if want.Scheme() == "https" && want.VerifySSL != zone.VerifyOriginSSL {
+		drifts = append(drifts, Drift{Field: "verify_origin_ssl", Want: onOff(want.VerifySSL), Got: onOff(zone.VerifyOriginSSL)})
+	}
+	return drifts
+}
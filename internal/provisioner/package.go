@@ -0,0 +1,216 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// SetAudit attaches the audit log package changes are recorded in
+func (p *Provisioner) SetAudit(a *audit.Log) {
+	p.audit = a
+}
+
+// PackageChange is the outcome of moving one domain to another WHM package
+type PackageChange struct {
+	Domain      string  `json:"domain"`
+	FromPackage string  `json:"from_package"`
+	ToPackage   string  `json:"to_package"`
+	FromProfile string  `json:"from_profile"`
+	ToProfile   string  `json:"to_profile"`
+	Applied     []Drift `json:"applied,omitempty"`
+	// Rank is the change in profile rank: positive for an upgrade,
+	// negative for a downgrade
+	Rank int `json:"rank"`
+}
+
+// ChangePackage records a WHM account's new package on each of its domains
+// and re-applies the matching CDN profile to their pull zones. Each changed
+// domain is audited, and moving to a profile of another rank is notified as
+// an upgrade or downgrade. domain is the account's main domain, included
+// even when it was provisioned before owners were recorded
+// This implements the webhook.PackageChanger interface
+func (p *Provisioner) ChangePackage(user, domain, pkg string) error {
+	ctx := context.Background()
+
+	states, err := p.stateManager.ListByUser(user)
+	if err != nil {
+		return fmt.Errorf("failed to list domains of %s: %w", user, err)
+	}
+	if domain != "" && !slices.ContainsFunc(states, func(st *state.ProvisionState) bool { return st.Domain == domain }) {
+		if st, err := p.stateManager.GetByDomain(domain); err == nil {
+			states = append(states, st)
+		}
+	}
+	if len(states) == 0 {
+		return fmt.Errorf("%s: %w", user, ErrNotProvisioned)
+	}
+
+	var (
+		changes []PackageChange
+		errs    []string
+	)
+	for _, st := range states {
+		if p.skipFrozen(st.Domain, "package change") {
+			continue
+		}
+		change, err := p.applyPackage(ctx, st, pkg)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if change != nil {
+			changes = append(changes, *change)
+			p.auditPackageChange(user, *change)
+		}
+	}
+
+	p.notifyPackageChange(ctx, user, changes)
+
+	p.logger.Info("package changed",
+		zap.String("user", user),
+		zap.String("package", pkg),
+		zap.Int("domains", len(changes)),
+		zap.Int("failed", len(errs)),
+	)
+	if len(errs) > 0 {
+		return fmt.Errorf("package change failed for %d domain(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SetPackage moves one domain to pkg and re-applies the matching CDN
+// profile to its pull zone. It returns nil when the domain already has pkg
+func (p *Provisioner) SetPackage(ctx context.Context, domain, pkg string) (*PackageChange, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	return p.applyPackage(ctx, provState, pkg)
+}
+
+// applyPackage moves one domain to pkg and brings its pull zone in line
+// with the new profile; it returns nil when the domain already has pkg
+func (p *Provisioner) applyPackage(ctx context.Context, provState *state.ProvisionState, pkg string) (*PackageChange, error) {
+	if strings.EqualFold(provState.Package, pkg) {
+		return nil, nil
+	}
+
+	fromProfile, from := p.profileFor(provState)
+	change := &PackageChange{
+		Domain:      provState.Domain,
+		FromPackage: provState.Package,
+		ToPackage:   pkg,
+		FromProfile: fromProfile,
+	}
+
+	provState.Package = pkg
+	if err := p.stateManager.Update(provState); err != nil {
+		return nil, fmt.Errorf("failed to record package for %s: %w", provState.Domain, err)
+	}
+
+	toProfile, to := p.profileFor(provState)
+	change.ToProfile = toProfile
+	change.Rank = to.Rank - from.Rank
+
+	// Domains still being provisioned get the profile when their pull zone
+	// is created
+	if provState.PullZoneID <= 0 || provState.Status != state.StatusSuccess {
+		return change, nil
+	}
+
+	if err := p.ensurePermaCache(ctx, provState.Domain, provState, to.PermaCache); err != nil {
+		p.logger.Warn("failed to attach Perma-Cache storage",
+			zap.String("domain", provState.Domain),
+			zap.String("profile", toProfile),
+			zap.Error(err),
+		)
+	}
+
+	applied, err := p.checkDrift(ctx, provState, true)
+	change.Applied = applied
+	if err != nil {
+		return change, fmt.Errorf("failed to apply profile %s to %s: %w", toProfile, provState.Domain, err)
+	}
+
+	p.logger.Info("profile re-applied for new package",
+		zap.String("domain", provState.Domain),
+		zap.String("package", pkg),
+		zap.String("from_profile", fromProfile),
+		zap.String("to_profile", toProfile),
+		zap.Int("settings_changed", len(applied)),
+	)
+	return change, nil
+}
+
+// auditPackageChange records a domain's package change in the audit log
+func (p *Provisioner) auditPackageChange(user string, change PackageChange) {
+	if p.audit == nil {
+		return
+	}
+
+	fields := make([]string, 0, len(change.Applied))
+	for _, d := range change.Applied {
+		if d.Fixed {
+			fields = append(fields, d.Field)
+		}
+	}
+	err := p.audit.Record(audit.Entry{
+		Actor:  "webhook",
+		Action: "package.changed",
+		Domain: change.Domain,
+		Details: map[string]string{
+			"user":         user,
+			"from_package": change.FromPackage,
+			"to_package":   change.ToPackage,
+			"from_profile": change.FromProfile,
+			"to_profile":   change.ToProfile,
+			"applied":      strings.Join(fields, ","),
+		},
+	})
+	if err != nil {
+		p.logger.Error("failed to write audit entry",
+			zap.String("domain", change.Domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// notifyPackageChange sends one notification for the domains of an account
+// whose profile moved to another rank
+func (p *Provisioner) notifyPackageChange(ctx context.Context, user string, changes []PackageChange) {
+	var msg *notifier.PackageChangedMessage
+	for _, change := range changes {
+		if change.Rank == 0 {
+			continue
+		}
+		if msg == nil {
+			msg = &notifier.PackageChangedMessage{
+				User:        user,
+				FromPackage: change.FromPackage,
+				ToPackage:   change.ToPackage,
+				FromProfile: change.FromProfile,
+				ToProfile:   change.ToProfile,
+				Upgrade:     change.Rank > 0,
+			}
+		}
+		msg.Domains = append(msg.Domains, change.Domain)
+	}
+	if msg == nil {
+		return
+	}
+
+	if err := p.notifier.NotifyPackageChanged(ctx, *msg); err != nil {
+		p.logger.Warn("failed to send package change notification",
+			zap.String("user", user),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,272 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// SetOverrides attaches the per-domain override store
+func (p *Provisioner) SetOverrides(o *overrides.Manager) {
+	p.overrides = o
+}
+
+// overrideFor returns the per-domain override for a domain, if any
+func (p *Provisioner) overrideFor(domain string) overrides.Override {
+	if p.overrides == nil {
+		return overrides.Override{}
+	}
+	o, _ := p.overrides.Get(domain)
+	return o
+}
+
+// DomainOverride returns the per-domain override for a domain
+func (p *Provisioner) DomainOverride(domain string) overrides.Override {
+	return p.overrideFor(domain)
+}
+
+// AssignPackage records the WHM package for a domain so the matching CDN
+// profile is applied when it is provisioned (and on recovery)
+// This implements the webhook.PackageAssigner interface
+func (p *Provisioner) AssignPackage(domain, pkg string) error {
+	if pkg == "" {
+		return nil
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		provState = p.stateManager.Create(domain)
+	}
+
+	if provState.Package == pkg {
+		return nil
+	}
+	provState.Package = pkg
+	if err := p.stateManager.Update(provState); err != nil {
+		return fmt.Errorf("failed to record package for %s: %w", domain, err)
+	}
+
+	profileName, _ := p.config.Profiles.Resolve(pkg)
+	p.logger.Info("assigned package",
+		zap.String("domain", domain),
+		zap.String("package", pkg),
+		zap.String("profile", profileName),
+	)
+	return nil
+}
+
+// profileFor returns the CDN profile for a provisioning state: the profile
+// override of the domain, else the profile of its package
+func (p *Provisioner) profileFor(provState *state.ProvisionState) (string, config.ProfileConfig) {
+	if name := p.overrideFor(provState.Domain).Profile; name != "" {
+		if k, v, ok := p.config.Profiles.Named(name); ok {
+			return k, v
+		}
+		p.logger.Warn("unknown profile override, using the profile of the package",
+			zap.String("domain", provState.Domain),
+			zap.String("profile", name),
+		)
+	}
+	return p.config.Profiles.Resolve(provState.Package)
+}
+
+// applyProfile applies the optional CDN features of the domain's profile
+// to its pull zone; failures are logged but do not fail provisioning
+func (p *Provisioner) applyProfile(ctx context.Context, domain string, provState *state.ProvisionState) {
+	if provState.PullZoneID <= 0 {
+		return
+	}
+
+	profileName, profile := p.profileFor(provState)
+
+	if err := p.ensurePermaCache(ctx, domain, provState, profile.PermaCache); err != nil {
+		p.logger.Warn("failed to attach Perma-Cache storage",
+			zap.String("domain", domain),
+			zap.String("profile", profileName),
+			zap.Error(err),
+		)
+	}
+
+	if profile.TokenAuth.Enabled {
+		if err := p.ensureTokenAuth(ctx, domain, provState); err != nil {
+			p.logger.Warn("failed to enable token authentication",
+				zap.String("domain", domain),
+				zap.String("profile", profileName),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// New pull zones have no referrer rules, so only non-empty rules need a call
+	if settings := p.referrerSettings(domain, profile); hasReferrerRules(settings) {
+		if err := p.bunnyClient.SetReferrers(ctx, provState.PullZoneID, settings); err != nil {
+			p.logger.Warn("failed to set referrer rules",
+				zap.String("domain", domain),
+				zap.String("profile", profileName),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if rules := p.AccessRules(domain); hasAccessRules(rules) {
+		if err := p.bunnyClient.SetAccessRules(ctx, provState.PullZoneID, rules); err != nil {
+			p.logger.Warn("failed to set access rules",
+				zap.String("domain", domain),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// New pull zones start with Optimizer off, so only enabling needs a call
+	if settings := p.optimizerSettings(domain, profile); settings.Enabled {
+		if err := p.bunnyClient.SetOptimizer(ctx, provState.PullZoneID, settings); err != nil {
+			p.logger.Warn("failed to enable Bunny Optimizer",
+				zap.String("domain", domain),
+				zap.String("profile", profileName),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// Bunny logs requests by default, so logging is only set when the
+	// profile configures it
+	if settings, ok := loggingSettings(profile); ok {
+		if err := p.bunnyClient.SetLogging(ctx, provState.PullZoneID, settings); err != nil {
+			p.logger.Warn("failed to set pull zone logging",
+				zap.String("domain", domain),
+				zap.String("profile", profileName),
+				zap.Error(err),
+			)
+		}
+	}
+
+	p.applyCanonicalHost(ctx, domain, provState)
+}
+
+// loggingSettings returns the request logging a profile configures, and
+// false when it leaves the pull zone's logging as it is
+func loggingSettings(profile config.ProfileConfig) (bunny.LoggingSettings, bool) {
+	cfg := profile.Logging
+	if cfg.Enabled == nil {
+		return bunny.LoggingSettings{}, false
+	}
+
+	settings := bunny.LoggingSettings{
+		Enabled:       *cfg.Enabled,
+		Anonymization: cfg.Anonymization,
+	}
+	if settings.Anonymization == "" {
+		settings.Anonymization = bunny.LogAnonymizationNone
+	}
+	if f := cfg.Forwarding; f.Hostname != "" {
+		settings.Forwarding = &bunny.LogForwarding{
+			Hostname: f.Hostname,
+			Port:     f.Port,
+			Token:    f.Token,
+			Protocol: f.Protocol,
+			Format:   f.Format,
+		}
+	}
+	return settings, true
+}
+
+// optimizerSettings returns the effective Optimizer settings for a domain:
+// a per-domain override of the on/off switch wins over the profile
+func (p *Provisioner) optimizerSettings(domain string, profile config.ProfileConfig) bunny.OptimizerSettings {
+	cfg := profile.Optimizer
+	enabled := cfg.Enabled
+	if o := p.overrideFor(domain); o.Optimizer != nil {
+		enabled = *o.Optimizer
+	}
+
+	return bunny.OptimizerSettings{
+		Enabled:      enabled,
+		AutoOptimize: cfg.AutomaticOptimization,
+		WebP:         cfg.WebP,
+		MinifyCSS:    cfg.MinifyCSS,
+		MinifyJS:     cfg.MinifyJavaScript,
+	}
+}
+
+// originSettings returns the origin outage handling for a profile: the
+// cdn.origin_resilience defaults with the profile's overrides applied
+func (p *Provisioner) originSettings(profile config.ProfileConfig) bunny.OriginSettings {
+	cfg := p.config.CDN.OriginResilience.Merge(profile.OriginResilience)
+	return bunny.OriginSettings{
+		StaleWhileOffline:  cfg.StaleWhileOffline,
+		StaleWhileUpdating: cfg.StaleWhileUpdating,
+		ConnectTimeout:     cfg.ConnectTimeout,
+		ResponseTimeout:    cfg.ResponseTimeout,
+		Retries:            cfg.Retries,
+	}
+}
+
+// ApplyOptimizer pushes the effective Optimizer settings of a provisioned
+// domain to its pull zone, returning the settings applied
+func (p *Provisioner) ApplyOptimizer(ctx context.Context, domain string) (bunny.OptimizerSettings, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return bunny.OptimizerSettings{}, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	_, profile := p.profileFor(provState)
+	settings := p.optimizerSettings(domain, profile)
+	if err := p.bunnyClient.SetOptimizer(ctx, provState.PullZoneID, settings); err != nil {
+		return settings, fmt.Errorf("failed to update optimizer for %s: %w", domain, err)
+	}
+
+	return settings, nil
+}
+
+// ensurePermaCache creates (or reuses) the domain's Perma-Cache storage zone
+// and links it to the pull zone
+func (p *Provisioner) ensurePermaCache(ctx context.Context, domain string, provState *state.ProvisionState, cfg config.PermaCacheConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Region == "" {
+		return fmt.Errorf("perma_cache.region is required")
+	}
+
+	if provState.StorageZoneID == 0 {
+		zone, err := p.bunnyClient.FindStorageZone(ctx, p.names.StorageZones(domain)...)
+		if err != nil {
+			zone, err = p.bunnyClient.CreateStorageZone(ctx, p.names.StorageZone(domain), cfg.Region, cfg.ReplicationRegions)
+			if err != nil {
+				return fmt.Errorf("failed to create storage zone: %w", err)
+			}
+		}
+
+		provState.StorageZoneID = zone.ID
+		if err := p.stateManager.Update(provState); err != nil {
+			return err
+		}
+	}
+
+	if err := p.bunnyClient.SetPermaCacheStorageZone(ctx, provState.PullZoneID, provState.StorageZoneID); err != nil {
+		return fmt.Errorf("failed to link storage zone: %w", err)
+	}
+
+	p.logger.Info("Perma-Cache attached",
+		zap.String("domain", domain),
+		zap.Int64("pull_zone_id", provState.PullZoneID),
+		zap.Int64("storage_zone_id", provState.StorageZoneID),
+	)
+	return nil
+}
+
+// permaCacheConfigured reports whether any profile enables Perma-Cache
+func (p *Provisioner) permaCacheConfigured() bool {
+	for _, profile := range p.config.Profiles.Definitions {
+		if profile.PermaCache.Enabled {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package provisioner
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/propagation"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// PropagationQueries returns the key records checked for a domain: the
+// nameservers, apex address and mail exchanger of the zone and the CNAME of
+// www and each hostname routed through the CDN. A subdomain only has its
+// CNAME checked
+func (p *Provisioner) PropagationQueries(domain string) []propagation.Query {
+	if provState, err := p.stateManager.GetByDomain(domain); err == nil && provState.ParentDomain != "" {
+		return []propagation.Query{{Type: propagation.TypeCNAME, Name: domain}}
+	}
+
+	queries := []propagation.Query{
+		{Type: propagation.TypeNS, Name: domain},
+		{Type: propagation.TypeA, Name: domain},
+		{Type: propagation.TypeMX, Name: domain},
+	}
+	names := []string{"www"}
+	for _, name := range p.config.DNS.CDNRecordNames() {
+		// The apex is flattened into addresses, already checked above
+		if name != "@" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		queries = append(queries, propagation.Query{Type: propagation.TypeCNAME, Name: name + "." + domain})
+	}
+	return queries
+}
+
+// CheckPropagation compares a domain's key records on Bunny's nameservers
+// and the public resolvers. With a positive wait the check is repeated every
+// interval (dns.propagation.interval when zero) until the answers converge
+// or wait passes
+func (p *Provisioner) CheckPropagation(ctx context.Context, domain string, wait, interval time.Duration) *propagation.Report {
+	queries := p.PropagationQueries(domain)
+	if wait <= 0 {
+		return p.propagation.Check(ctx, queries)
+	}
+	if interval <= 0 {
+		interval = p.config.DNS.Propagation.Interval
+	}
+	return p.propagation.Wait(ctx, queries, wait, interval)
+}
+
+// verifyPropagation waits up to dns.propagation.timeout for the public
+// resolvers to return the records just provisioned and records the outcome
+// in provState. Propagation depends on the customer's nameservers and
+// resolver caches, so records that have not propagated in time are
+// logged, not an error
+func (p *Provisioner) verifyPropagation(ctx context.Context, provState *state.ProvisionState) {
+	cfg := p.config.DNS.Propagation
+	if !cfg.Enabled {
+		return
+	}
+
+	report := p.propagation.Wait(ctx, p.PropagationQueries(provState.Domain), cfg.Timeout, cfg.Interval)
+	provState.DNSPropagated = report.Propagated()
+	if err := p.stateManager.Update(provState); err != nil {
+		p.logger.Warn("failed to record DNS propagation",
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+	}
+
+	if !provState.DNSPropagated {
+		pending := make([]string, 0, len(report.Results))
+		for _, q := range report.Pending() {
+			pending = append(pending, q.String())
+		}
+		p.logger.Warn("DNS records have not propagated yet",
+			zap.String("domain", provState.Domain),
+			zap.Strings("pending", pending),
+			zap.Int("attempts", report.Attempts),
+			zap.Duration("timeout", cfg.Timeout),
+		)
+		return
+	}
+	p.logger.Info("DNS records propagated",
+		zap.String("domain", provState.Domain),
+		zap.Int("attempts", report.Attempts),
+	)
+}
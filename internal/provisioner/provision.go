@@ -2,18 +2,44 @@ package provisioner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/balance"
 	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/failures"
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+	"github.com/mordenhost/whm2bunny/internal/hooks"
+	"github.com/mordenhost/whm2bunny/internal/maintenance"
 	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/propagation"
+	"github.com/mordenhost/whm2bunny/internal/slo"
 	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/status"
+	"github.com/mordenhost/whm2bunny/internal/tokenauth"
+	"github.com/mordenhost/whm2bunny/internal/zonename"
 )
 
+// ErrPullZonesPaused is returned when a provision stops before creating a
+// pull zone because the Bunny account balance is below the threshold
+var ErrPullZonesPaused = errors.New("pull zone creation paused: Bunny balance below threshold")
+
+// ErrNotProvisioned is returned by operations on domains that have no pull zone yet
+var ErrNotProvisioned = errors.New("domain has no pull zone yet")
+
 // Provisioner orchestrates the provisioning of BunnyDNS and BunnyCDN resources
 type Provisioner struct {
 	bunnyClient  *bunny.Client
@@ -22,6 +48,48 @@ type Provisioner struct {
 	config       *config.Config
 	logger       *zap.Logger
 
+	// maintenance pauses new provisioning while a window is active (optional)
+	maintenance *maintenance.Manager
+	// balance pauses pull zone creation while the Bunny balance is low (optional)
+	balance *balance.Guard
+	// overrides holds per-domain settings that take precedence over profiles (optional)
+	overrides *overrides.Manager
+	// tokenKeys stores token authentication keys for profiles that require it (optional)
+	tokenKeys *tokenauth.KeyStore
+	// bypass tracks domains switched to the origin during a CDN emergency (optional)
+	bypass *bypass.Store
+	// certs tracks uploaded custom certificates for expiry reminders (optional)
+	certs *certs.Store
+	// hooks runs operator-configured actions around provisioning (optional)
+	hooks *hooks.Runner
+	// slo records provisioning run times for percentiles (optional)
+	slo *slo.Store
+	// failures counts failed runs per error class and day (optional)
+	failures *failures.Store
+	// audit records package changes (optional)
+	audit *audit.Log
+	// status writes per-domain status files for WHM hook scripts (optional)
+	status *status.Writer
+	// freeze holds domains whose automation support suspended (optional)
+	freeze *freeze.Store
+	// disabledRecords is the manifest of DNS records disabled instead of
+	// deleted (optional)
+	disabledRecords *dnsrecords.Store
+
+	// names derives pull and storage zone names from cdn.zone_name_prefix
+	names *zonename.Namer
+	// propagation compares records on Bunny's nameservers and public resolvers
+	propagation *propagation.Checker
+	// resellerEmails looks up reseller contact emails for SOA emails (optional)
+	resellerEmails ResellerEmailFunc
+	// lookupHost resolves CDN hostnames to verify them
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	// stats tracks in-flight provisions and recovery progress for /health
+	stats stats
+	// zones tracks managed DNS zones Bunny disabled, for /health
+	zones zoneWatch
+
 	// Sub-provisioners for specific operations
 	domainProvisioner    *DomainProvisioner
 	subdomainProvisioner *SubdomainProvisioner
@@ -46,8 +114,19 @@ func NewProvisioner(
 		notifier:     telegramNotifier,
 		config:       cfg,
 		logger:       logger,
+		names:        cfg.ZoneNamer(),
+		lookupHost:   net.DefaultResolver.LookupHost,
 	}
 
+	// DNS propagation checks reach the resolvers through the DNS proxy
+	var checkerOpts []propagation.Option
+	if dnsProxy, err := cfg.Proxy.Proxy(config.ProxyDNS); err != nil {
+		logger.Warn("invalid DNS proxy, querying resolvers directly", zap.Error(err))
+	} else {
+		checkerOpts = append(checkerOpts, propagation.WithDialer(dnsProxy.DialContext))
+	}
+	p.propagation = propagation.NewChecker(checkerOpts...)
+
 	// Initialize sub-provisioners
 	p.domainProvisioner = &DomainProvisioner{provisioner: p}
 	p.subdomainProvisioner = &SubdomainProvisioner{provisioner: p}
@@ -56,9 +135,70 @@ func NewProvisioner(
 	return p
 }
 
+// SetMaintenance attaches a maintenance manager
+// While maintenance is active new provisions are queued as pending instead of executed
+func (p *Provisioner) SetMaintenance(m *maintenance.Manager) {
+	p.maintenance = m
+}
+
+// inMaintenance reports whether new provisioning is currently paused
+func (p *Provisioner) inMaintenance() (bool, string) {
+	if p.maintenance == nil {
+		return false, ""
+	}
+	status := p.maintenance.Status()
+	return status.Active, status.Reason
+}
+
+// provisionedStates returns every successfully provisioned domain with a
+// pull zone, including archived ones
+func (p *Provisioner) provisionedStates() []*state.ProvisionState {
+	states := p.AllStates()
+	result := make([]*state.ProvisionState, 0, len(states))
+	for _, provState := range states {
+		if provState.Status == state.StatusSuccess && provState.PullZoneID > 0 {
+			result = append(result, provState)
+		}
+	}
+	return result
+}
+
+// findPullZone looks a domain's pull zone up by name, under the current or
+// a previous zone name prefix
+func (p *Provisioner) findPullZone(ctx context.Context, domain string) (*bunny.PullZone, error) {
+	return p.bunnyClient.FindPullZone(ctx, p.names.PullZones(domain)...)
+}
+
+// AllStates returns the active and archived states of every known domain,
+// whatever their status
+func (p *Provisioner) AllStates() []*state.ProvisionState {
+	states := p.stateManager.ListAll()
+	archived, err := p.stateManager.ListArchived()
+	if err != nil {
+		p.logger.Warn("failed to read archived states, using active states only", zap.Error(err))
+	}
+	return append(states, archived...)
+}
+
+// SetBalanceGuard attaches a balance guard
+// While the balance is low, provisions complete their DNS steps and are
+// queued as pending until pull zone creation resumes
+func (p *Provisioner) SetBalanceGuard(g *balance.Guard) {
+	p.balance = g
+}
+
+// pullZonesPaused reports whether pull zone creation is currently paused
+func (p *Provisioner) pullZonesPaused(ctx context.Context) bool {
+	if p.balance == nil {
+		return false
+	}
+	return p.balance.PullZonesPaused(ctx)
+}
+
 // Provision provisions a new domain with DNS zone, records, and CDN pull zone
 // This implements the webhook.Provisioner interface
 func (p *Provisioner) Provision(domain, user string) error {
+	defer p.trackProvision()()
 	ctx := context.Background()
 	startTime := time.Now()
 
@@ -84,13 +224,25 @@ func (p *Provisioner) Provision(domain, user string) error {
 		provState = existingState
 		p.logger.Info("resuming provisioning from existing state",
 			zap.String("domain", domain),
-			zap.String("status", provState.Status),
-			zap.Int("current_step", provState.CurrentStep),
+			zap.String("status", string(provState.Status)),
+			zap.String("current_step", provState.StepName()),
 		)
 	} else {
 		// Create new provisioning state
 		provState = p.stateManager.Create(domain)
 	}
+	p.recordUser(provState, user)
+
+	// Queue instead of executing while maintenance is active
+	if active, reason := p.inMaintenance(); active {
+		p.logger.Info("maintenance active, provisioning queued",
+			zap.String("domain", domain),
+			zap.String("state_id", provState.ID),
+			zap.String("reason", reason),
+		)
+		p.writeStatus(provState, status.StateQueued, reason)
+		return nil
+	}
 
 	// Mark as provisioning
 	if err := p.stateManager.MarkProvisioning(provState.ID); err != nil {
@@ -100,26 +252,35 @@ func (p *Provisioner) Provision(domain, user string) error {
 		)
 		return fmt.Errorf("failed to mark state as provisioning: %w", err)
 	}
+	p.writeStatus(provState, status.StateInProgress, "")
 
-	// Execute provisioning steps
-	domainProv := &DomainProvisioner{provisioner: p}
-	err = domainProv.Provision(ctx, domain, user)
+	// Execute provisioning steps once the pre_provision hooks pass
+	err = p.runHooks(ctx, hooks.PreProvision, provState)
+	if err != nil {
+		err = fmt.Errorf("pre_provision hook failed: %w", err)
+	} else {
+		domainProv := &DomainProvisioner{provisioner: p}
+		err = domainProv.Provision(ctx, domain, user)
+	}
+	if err == nil {
+		err = p.runSavedHooks(ctx, hooks.PostProvision, provState.ID)
+	}
 
 	duration := time.Since(startTime)
 
+	if errors.Is(err, ErrPullZonesPaused) {
+		p.queuePaused(provState.ID, domain)
+		return nil
+	}
+
 	if err != nil {
 		// Update state with error
-		setErr := p.stateManager.SetError(provState.ID, err.Error())
-		if setErr != nil {
-			p.logger.Error("failed to set error state",
-				zap.String("domain", domain),
-				zap.Error(setErr),
-			)
-		}
+		p.recordFailure(provState.ID, domain, err)
+
+		p.writeSavedStatus(provState.ID, status.StateFailed, err.Error())
 
 		// Send failure notification
-		stepName := state.StepName(provState.CurrentStep)
-		notifErr := p.notifier.NotifyFailed(ctx, domain, stepName, err.Error())
+		notifErr := p.notifier.NotifyFailed(ctx, domain, provState.User, p.stepName(provState.ID), err.Error())
 		if notifErr != nil {
 			p.logger.Warn("failed to send failure notification",
 				zap.String("domain", domain),
@@ -147,14 +308,23 @@ func (p *Provisioner) Provision(domain, user string) error {
 		)
 	}
 
-	// Send success notification
+	p.writeSavedStatus(provState.ID, status.StateComplete, "")
+
+	// Send success notification, or tell a recovery apart from it
 	cdnHostname := ""
 	var zoneID int64
+	attempts, took := 0, time.Duration(0)
 	if finalState != nil {
 		cdnHostname = finalState.CDNHostname
 		zoneID = finalState.ZoneID
+		attempts, took = recovery(finalState)
+	}
+	var notifErr error
+	if attempts > 0 {
+		notifErr = p.notifier.NotifyRecovered(ctx, domain, provState.User, zoneID, cdnHostname, duration, attempts, took)
+	} else {
+		notifErr = p.notifier.NotifySuccess(ctx, domain, provState.User, zoneID, cdnHostname, duration)
 	}
-	notifErr := p.notifier.NotifySuccess(ctx, domain, zoneID, cdnHostname, duration)
 	if notifErr != nil {
 		p.logger.Warn("failed to send success notification",
 			zap.String("domain", domain),
@@ -162,6 +332,10 @@ func (p *Provisioner) Provision(domain, user string) error {
 		)
 	}
 
+	if finalState != nil {
+		p.recordRun(ctx, finalState, duration)
+	}
+
 	// Check SSL certificate status (after successful provisioning)
 	if finalState != nil && finalState.PullZoneID > 0 {
 		p.checkAndNotifySSL(ctx, domain, finalState.PullZoneID)
@@ -178,7 +352,9 @@ func (p *Provisioner) Provision(domain, user string) error {
 // ProvisionSubdomain provisions a subdomain under an existing parent domain
 // This implements the webhook.Provisioner interface
 func (p *Provisioner) ProvisionSubdomain(subdomain, parentDomain, user string) error {
+	defer p.trackProvision()()
 	ctx := context.Background()
+	startTime := time.Now()
 
 	p.logger.Info("starting subdomain provisioning",
 		zap.String("subdomain", subdomain),
@@ -201,29 +377,58 @@ func (p *Provisioner) ProvisionSubdomain(subdomain, parentDomain, user string) e
 	var provState *state.ProvisionState
 	if existingState != nil {
 		provState = existingState
+		if !provState.IsSubdomain() {
+			// Stored before subdomains had their own steps
+			if err := p.stateManager.AdoptSubdomain(provState.ID, parentDomain); err != nil {
+				return fmt.Errorf("failed to migrate subdomain state: %w", err)
+			}
+		}
 	} else {
-		provState = p.stateManager.Create(fullDomain)
+		provState = p.stateManager.CreateSubdomain(fullDomain, parentDomain)
+	}
+	p.recordUser(provState, user)
+
+	// Queue instead of executing while maintenance is active
+	if active, reason := p.inMaintenance(); active {
+		p.logger.Info("maintenance active, subdomain provisioning queued",
+			zap.String("subdomain", fullDomain),
+			zap.String("state_id", provState.ID),
+			zap.String("reason", reason),
+		)
+		p.writeStatus(provState, status.StateQueued, reason)
+		return nil
 	}
 
 	// Mark as provisioning
 	if err := p.stateManager.MarkProvisioning(provState.ID); err != nil {
 		return fmt.Errorf("failed to mark state as provisioning: %w", err)
 	}
+	p.writeStatus(provState, status.StateInProgress, "")
 
-	// Execute subdomain provisioning
-	subProv := &SubdomainProvisioner{provisioner: p}
-	err = subProv.Provision(ctx, subdomain, parentDomain, user)
+	// Execute subdomain provisioning once the pre_provision hooks pass
+	err = p.runHooks(ctx, hooks.PreProvision, provState)
+	if err != nil {
+		err = fmt.Errorf("pre_provision hook failed: %w", err)
+	} else {
+		subProv := &SubdomainProvisioner{provisioner: p}
+		err = subProv.Provision(ctx, subdomain, parentDomain, user)
+	}
+	if err == nil {
+		err = p.runSavedHooks(ctx, hooks.PostProvision, provState.ID)
+	}
+	duration := time.Since(startTime)
+
+	if errors.Is(err, ErrPullZonesPaused) {
+		p.queuePaused(provState.ID, fullDomain)
+		return nil
+	}
 
 	if err != nil {
-		setErr := p.stateManager.SetError(provState.ID, err.Error())
-		if setErr != nil {
-			p.logger.Error("failed to set error state",
-				zap.String("subdomain", fullDomain),
-				zap.Error(setErr),
-			)
-		}
+		p.recordFailure(provState.ID, fullDomain, err)
+
+		p.writeSavedStatus(provState.ID, status.StateFailed, err.Error())
 
-		notifErr := p.notifier.NotifyFailed(ctx, fullDomain, "subdomain_provisioning", err.Error())
+		notifErr := p.notifier.NotifyFailed(ctx, fullDomain, provState.User, p.stepName(provState.ID), err.Error())
 		if notifErr != nil {
 			p.logger.Warn("failed to send failure notification",
 				zap.String("subdomain", fullDomain),
@@ -251,12 +456,14 @@ func (p *Provisioner) ProvisionSubdomain(subdomain, parentDomain, user string) e
 		)
 	}
 
+	p.writeSavedStatus(provState.ID, status.StateComplete, "")
+
 	// Send success notification
 	cdnHostname := ""
 	if finalState != nil {
 		cdnHostname = finalState.CDNHostname
 	}
-	notifErr := p.notifier.NotifySubdomainProvisioned(ctx, fullDomain, parentDomain, cdnHostname)
+	notifErr := p.notifier.NotifySubdomainProvisioned(ctx, fullDomain, parentDomain, provState.User, cdnHostname)
 	if notifErr != nil {
 		p.logger.Warn("failed to send subdomain notification",
 			zap.String("subdomain", fullDomain),
@@ -264,6 +471,10 @@ func (p *Provisioner) ProvisionSubdomain(subdomain, parentDomain, user string) e
 		)
 	}
 
+	if finalState != nil {
+		p.recordRun(ctx, finalState, duration)
+	}
+
 	p.logger.Info("subdomain provisioning completed successfully",
 		zap.String("subdomain", fullDomain),
 	)
@@ -271,6 +482,57 @@ func (p *Provisioner) ProvisionSubdomain(subdomain, parentDomain, user string) e
 	return nil
 }
 
+// recordUser saves the WHM user owning a domain. Recoveries and retries
+// pass no user and keep the recorded one
+func (p *Provisioner) recordUser(provState *state.ProvisionState, user string) {
+	if user == "" || provState.User == user {
+		return
+	}
+
+	provState.User = user
+	if err := p.stateManager.Update(provState); err != nil {
+		p.logger.Warn("failed to record domain owner",
+			zap.String("domain", provState.Domain),
+			zap.String("user", user),
+			zap.Error(err),
+		)
+	}
+}
+
+// stepName returns the name of the step a state stopped at
+func (p *Provisioner) stepName(id string) string {
+	st, err := p.stateManager.Get(id)
+	if err != nil {
+		return state.StepName(state.StepNone)
+	}
+	return st.StepName()
+}
+
+// Reprovision retries a failed or pending provision from its saved state,
+// resuming subdomains under their parent domain
+func (p *Provisioner) Reprovision(st *state.ProvisionState) error {
+	if st.IsSubdomain() {
+		subdomain := strings.TrimSuffix(st.Domain, "."+st.ParentDomain)
+		return p.ProvisionSubdomain(subdomain, st.ParentDomain, "")
+	}
+	return p.Provision(st.Domain, "")
+}
+
+// queuePaused returns a provision stopped by the balance guard to the pending queue
+func (p *Provisioner) queuePaused(id, domain string) {
+	p.logger.Warn("pull zone creation paused, provisioning queued",
+		zap.String("domain", domain),
+		zap.String("state_id", id),
+	)
+	if err := p.stateManager.MarkPending(id, ErrPullZonesPaused.Error()); err != nil {
+		p.logger.Error("failed to queue paused provision",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+	p.writeSavedStatus(id, status.StateQueued, ErrPullZonesPaused.Error())
+}
+
 // Deprovision removes a domain's DNS zone and CDN pull zone
 // This implements the webhook.Provisioner interface
 func (p *Provisioner) Deprovision(domain string) error {
@@ -280,11 +542,71 @@ func (p *Provisioner) Deprovision(domain string) error {
 		zap.String("domain", domain),
 	)
 
-	// Execute deprovisioning
+	// Remember the owner for the notification, the state goes with the domain
+	user := ""
+	if existingState, err := p.stateManager.GetByDomain(domain); err == nil {
+		user = existingState.User
+	}
+
+	if err := p.removeDomain(ctx, domain); err != nil {
+		return err
+	}
+
+	// Send notification
+	notifErr := p.notifier.NotifyDeprovisioned(ctx, domain, user)
+	if notifErr != nil {
+		p.logger.Warn("failed to send deprovision notification",
+			zap.String("domain", domain),
+			zap.Error(notifErr),
+		)
+	}
+
+	p.logger.Info("domain deprovisioning completed successfully",
+		zap.String("domain", domain),
+	)
+
+	return nil
+}
+
+// RemoveSubdomain removes a subdomain's pull zone and CNAME
+// This implements the webhook.Provisioner interface
+func (p *Provisioner) RemoveSubdomain(subdomain, parentDomain string) error {
+	ctx := context.Background()
+	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
+
+	removed := &state.ProvisionState{Domain: fullDomain, ParentDomain: parentDomain}
+	if existingState, err := p.stateManager.GetByDomain(fullDomain); err == nil {
+		removed = existingState
+	}
+	user := removed.User
+
 	deprov := &Deprovisioner{provisioner: p}
-	err := deprov.Deprovision(ctx, domain)
+	if err := deprov.DeprovisionSubdomain(ctx, subdomain, parentDomain); err != nil {
+		return fmt.Errorf("deprovisioning failed for subdomain %s: %w", fullDomain, err)
+	}
+	p.removeStatus(removed)
+	p.notifyHooks(ctx, hooks.PostDeprovision, removed)
 
-	if err != nil {
+	notifErr := p.notifier.NotifyDeprovisioned(ctx, fullDomain, user)
+	if notifErr != nil {
+		p.logger.Warn("failed to send deprovision notification",
+			zap.String("subdomain", fullDomain),
+			zap.Error(notifErr),
+		)
+	}
+
+	return nil
+}
+
+// removeDomain deletes a domain's zones and state
+func (p *Provisioner) removeDomain(ctx context.Context, domain string) error {
+	removed := &state.ProvisionState{Domain: domain}
+	if existingState, err := p.stateManager.GetByDomain(domain); err == nil {
+		removed = existingState
+	}
+
+	deprov := &Deprovisioner{provisioner: p}
+	if err := deprov.Deprovision(ctx, domain); err != nil {
 		p.logger.Error("deprovisioning failed",
 			zap.String("domain", domain),
 			zap.Error(err),
@@ -292,31 +614,133 @@ func (p *Provisioner) Deprovision(domain string) error {
 		return fmt.Errorf("deprovisioning failed for domain %s: %w", domain, err)
 	}
 
-	// Remove state
-	existingState, stateErr := p.stateManager.GetByDomain(domain)
-	if stateErr == nil && existingState != nil {
-		if delErr := p.stateManager.Delete(existingState.ID); delErr != nil {
-			p.logger.Warn("failed to delete state after deprovisioning",
-				zap.String("domain", domain),
-				zap.Error(delErr),
-			)
-		}
+	p.forgetState(domain)
+	p.removeStatus(removed)
+	p.notifyHooks(ctx, hooks.PostDeprovision, removed)
+	return nil
+}
+
+// removeSubdomain deletes a subdomain's pull zone, CNAME and state
+func (p *Provisioner) removeSubdomain(ctx context.Context, st *state.ProvisionState) error {
+	subdomain := strings.TrimSuffix(st.Domain, "."+st.ParentDomain)
+
+	deprov := &Deprovisioner{provisioner: p}
+	if err := deprov.DeprovisionSubdomain(ctx, subdomain, st.ParentDomain); err != nil {
+		p.logger.Error("subdomain deprovisioning failed",
+			zap.String("subdomain", st.Domain),
+			zap.Error(err),
+		)
+		return fmt.Errorf("deprovisioning failed for subdomain %s: %w", st.Domain, err)
 	}
 
-	// Send notification
-	notifErr := p.notifier.NotifyDeprovisioned(ctx, domain)
-	if notifErr != nil {
-		p.logger.Warn("failed to send deprovision notification",
+	p.forgetState(st.Domain)
+	p.removeStatus(st)
+	p.notifyHooks(ctx, hooks.PostDeprovision, st)
+	return nil
+}
+
+// forgetState deletes the state of a deprovisioned domain
+func (p *Provisioner) forgetState(domain string) {
+	existingState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		return
+	}
+	if delErr := p.stateManager.Delete(existingState.ID); delErr != nil {
+		p.logger.Warn("failed to delete state after deprovisioning",
 			zap.String("domain", domain),
-			zap.Error(notifErr),
+			zap.Error(delErr),
 		)
 	}
+}
 
-	p.logger.Info("domain deprovisioning completed successfully",
+// DeprovisionUser removes every domain owned by a WHM user, for an account
+// deletion: subdomains first, while their parent zones still exist, then
+// addon domains and the account's domain. domain is removed even when it
+// was provisioned before owners were recorded. One notification lists the
+// outcome for each domain
+// This implements the webhook.UserDeprovisioner interface
+func (p *Provisioner) DeprovisionUser(user, domain string) error {
+	ctx := context.Background()
+
+	states, err := p.stateManager.ListByUser(user)
+	if err != nil {
+		return fmt.Errorf("failed to list domains of %s: %w", user, err)
+	}
+	if domain != "" && !slices.ContainsFunc(states, func(st *state.ProvisionState) bool { return st.Domain == domain }) {
+		states = append(states, &state.ProvisionState{Domain: domain, User: user})
+	}
+
+	p.logger.Info("deprovisioning account",
+		zap.String("user", user),
 		zap.String("domain", domain),
+		zap.Int("domains", len(states)),
 	)
 
-	return nil
+	sort.SliceStable(states, func(i, j int) bool {
+		return deprovisionOrder(states[i], domain) < deprovisionOrder(states[j], domain)
+	})
+
+	var errs []error
+	results := make([]notifier.DeprovisionedDomain, 0, len(states))
+	for _, st := range states {
+		if p.skipFrozen(st.Domain, "deprovision") {
+			results = append(results, notifier.DeprovisionedDomain{Domain: st.Domain, Subdomain: st.IsSubdomain(), Error: ErrFrozen.Error()})
+			continue
+		}
+
+		var removeErr error
+		if st.IsSubdomain() {
+			removeErr = p.removeSubdomain(ctx, st)
+		} else {
+			removeErr = p.removeDomain(ctx, st.Domain)
+		}
+
+		result := notifier.DeprovisionedDomain{Domain: st.Domain, Subdomain: st.IsSubdomain()}
+		if removeErr != nil {
+			result.Error = removeErr.Error()
+			errs = append(errs, removeErr)
+		}
+		results = append(results, result)
+	}
+
+	if notifErr := p.notifier.NotifyAccountDeprovisioned(ctx, user, results); notifErr != nil {
+		p.logger.Warn("failed to send account deprovision notification",
+			zap.String("user", user),
+			zap.Error(notifErr),
+		)
+	}
+
+	p.logger.Info("account deprovisioning completed",
+		zap.String("user", user),
+		zap.Int("domains", len(results)),
+		zap.Int("failed", len(errs)),
+	)
+
+	return errors.Join(errs...)
+}
+
+// deprovisionOrder ranks states for DeprovisionUser: subdomains, then other
+// domains, then the account's domain
+func deprovisionOrder(st *state.ProvisionState, accountDomain string) int {
+	switch {
+	case st.IsSubdomain():
+		return 0
+	case st.Domain != accountDomain:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// DomainState returns the provisioning state of a domain
+func (p *Provisioner) DomainState(domain string) (*state.ProvisionState, error) {
+	return p.stateManager.GetByDomain(domain)
+}
+
+// UserDomains returns the provisioning states of every domain owned by a
+// WHM user, archived ones included
+func (p *Provisioner) UserDomains(user string) ([]*state.ProvisionState, error) {
+	return p.stateManager.ListByUser(user)
 }
 
 // Recover attempts to recover failed or pending provisions with backoff delay
@@ -332,22 +756,50 @@ func (p *Provisioner) Recover(ctx context.Context) error {
 		return nil
 	}
 
+	if active, reason := p.inMaintenance(); active {
+		p.logger.Info("maintenance active, deferring recovery",
+			zap.Int("count", len(states)),
+			zap.String("reason", reason),
+		)
+		return nil
+	}
+
+	p.updateRecovery(func(r *RecoveryProgress) {
+		*r = RecoveryProgress{Running: true, Total: len(states), StartedAt: time.Now()}
+	})
+	defer p.updateRecovery(func(r *RecoveryProgress) {
+		r.Running = false
+		r.FinishedAt = time.Now()
+	})
+
 	// Recover with backoff: 2-5 seconds between each domain
 	for i, st := range states {
 		p.logger.Info("recovering provision",
 			zap.String("domain", st.Domain),
-			zap.String("status", st.Status),
+			zap.String("status", string(st.Status)),
 			zap.Int("retries", st.Retries),
 			zap.Int("index", i+1),
 			zap.Int("total", len(states)),
 		)
 
+		if p.skipFrozen(st.Domain, "recovery") {
+			p.updateRecovery(func(r *RecoveryProgress) {
+				r.Done++
+				r.Skipped++
+			})
+			continue
+		}
+
 		// Check if we've exceeded retry limit
 		if st.Retries >= 5 {
 			p.logger.Warn("skipping recovery, max retries exceeded",
 				zap.String("domain", st.Domain),
 				zap.Int("retries", st.Retries),
 			)
+			p.updateRecovery(func(r *RecoveryProgress) {
+				r.Done++
+				r.Skipped++
+			})
 			continue
 		}
 
@@ -366,7 +818,16 @@ func (p *Provisioner) Recover(ctx context.Context) error {
 		}
 
 		// Re-provision the domain
-		if err := p.Provision(st.Domain, ""); err != nil {
+		err := p.Reprovision(st)
+		p.updateRecovery(func(r *RecoveryProgress) {
+			r.Done++
+			if err != nil {
+				r.Failed++
+			} else {
+				r.Succeeded++
+			}
+		})
+		if err != nil {
 			p.logger.Error("recovery failed",
 				zap.String("domain", st.Domain),
 				zap.Error(err),
@@ -437,18 +898,3 @@ func (p *Provisioner) checkAndNotifySSL(ctx context.Context, domain string, pull
 		}
 	}()
 }
-
-// generatePullZoneName generates a pull zone name from a domain
-// e.g., "example.com" -> "morden-example-com"
-func generatePullZoneName(domain string) string {
-	name := strings.ToLower(domain)
-	name = strings.ReplaceAll(name, ".", "-")
-	return "morden-" + name
-}
-
-// generateSubdomainPullZoneName generates a pull zone name for a subdomain
-// e.g., "blog.example.com" -> "morden-blog-example-com"
-func generateSubdomainPullZoneName(subdomain, parentDomain string) string {
-	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
-	return generatePullZoneName(fullDomain)
-}
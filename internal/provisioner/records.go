@@ -0,0 +1,155 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// ErrRecordNotFound is returned when a DNS record is not in the domain's zone
+var ErrRecordNotFound = errors.New("DNS record not found")
+
+// ManagedRecord is a DNS record of a provisioned domain's zone; Disabled
+// says why and since when whm2bunny disabled it
+type ManagedRecord struct {
+	ID       int64                `json:"id"`
+	Type     string               `json:"type"`
+	Name     string               `json:"name"`
+	Value    string               `json:"value"`
+	TTL      int                  `json:"ttl"`
+	Enabled  bool                 `json:"enabled"`
+	Disabled *dnsrecords.Disabled `json:"disabled,omitempty"`
+}
+
+// SetDisabledRecords attaches the manifest of disabled DNS records
+func (p *Provisioner) SetDisabledRecords(s *dnsrecords.Store) {
+	p.disabledRecords = s
+}
+
+// DNSRecords returns the records of a provisioned domain's DNS zone,
+// sorted by name and type. For a subdomain these are the records of its
+// parent's zone
+func (p *Provisioner) DNSRecords(ctx context.Context, domain string) ([]ManagedRecord, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.ZoneID <= 0 {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	records, err := p.bunnyClient.GetDNSRecords(ctx, provState.ZoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS records: %w", err)
+	}
+
+	managed := make([]ManagedRecord, 0, len(records))
+	for _, r := range records {
+		managed = append(managed, p.managedRecord(domain, r))
+	}
+	sort.SliceStable(managed, func(i, j int) bool {
+		if managed[i].Name != managed[j].Name {
+			return managed[i].Name < managed[j].Name
+		}
+		return managed[i].Type < managed[j].Type
+	})
+	return managed, nil
+}
+
+// managedRecord converts a Bunny record, adding its manifest entry
+func (p *Provisioner) managedRecord(domain string, r bunny.DNSRecord) ManagedRecord {
+	m := ManagedRecord{
+		ID:      r.ID,
+		Type:    r.Type.String(),
+		Name:    r.Name,
+		Value:   r.Value,
+		TTL:     r.TTL,
+		Enabled: r.Enabled,
+	}
+	if p.disabledRecords != nil {
+		if d, ok := p.disabledRecords.Get(domain, r.ID); ok {
+			m.Disabled = &d
+		}
+	}
+	return m
+}
+
+// SetDNSRecordEnabled disables or enables one record of a provisioned
+// domain's zone without deleting it. Disabled records are listed in the
+// manifest with reason, so drift enforcement leaves them alone
+func (p *Provisioner) SetDNSRecordEnabled(ctx context.Context, domain string, recordID int64, enabled bool, reason string) (ManagedRecord, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.ZoneID <= 0 {
+		return ManagedRecord{}, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	record, err := p.zoneRecord(ctx, provState, recordID)
+	if err != nil {
+		return ManagedRecord{}, err
+	}
+	updated, err := p.setRecordEnabled(ctx, provState, *record, enabled, reason)
+	if err != nil {
+		return ManagedRecord{}, err
+	}
+	return p.managedRecord(domain, *updated), nil
+}
+
+// zoneRecord returns a record of the domain's zone
+func (p *Provisioner) zoneRecord(ctx context.Context, provState *state.ProvisionState, recordID int64) (*bunny.DNSRecord, error) {
+	record, err := p.bunnyClient.GetDNSRecord(ctx, provState.ZoneID, recordID)
+	var apiErr *bunny.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.IsNotFound():
+		return nil, fmt.Errorf("%s record %d: %w", provState.Domain, recordID, ErrRecordNotFound)
+	case err != nil:
+		return nil, fmt.Errorf("failed to get DNS record: %w", err)
+	}
+	return record, nil
+}
+
+// setRecordEnabled toggles a record on Bunny and keeps the manifest in step
+func (p *Provisioner) setRecordEnabled(ctx context.Context, provState *state.ProvisionState, record bunny.DNSRecord, enabled bool, reason string) (*bunny.DNSRecord, error) {
+	updated, err := p.bunnyClient.SetDNSRecordEnabled(ctx, provState.ZoneID, record.ID, enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %s %s enabled=%t: %w", record.Type, record.Name, enabled, err)
+	}
+
+	if p.disabledRecords != nil {
+		if enabled {
+			_, err = p.disabledRecords.Enable(provState.Domain, record.ID)
+		} else {
+			err = p.disabledRecords.Disable(provState.Domain, dnsrecords.Disabled{
+				ID:     record.ID,
+				Type:   record.Type.String(),
+				Name:   record.Name,
+				Value:  record.Value,
+				Reason: reason,
+			})
+		}
+		if err != nil {
+			return updated, fmt.Errorf("failed to update the disabled records manifest: %w", err)
+		}
+	}
+
+	p.logger.Info("DNS record toggled",
+		zap.String("domain", provState.Domain),
+		zap.String("record", record.Type.String()+" "+record.Name),
+		zap.Bool("enabled", enabled),
+		zap.String("reason", reason),
+	)
+	return updated, nil
+}
+
+// recordDisabled reports whether whm2bunny disabled a domain's record on
+// purpose
+func (p *Provisioner) recordDisabled(domain string, recordID int64) bool {
+	if p.disabledRecords == nil {
+		return false
+	}
+	_, ok := p.disabledRecords.Get(domain, recordID)
+	return ok
+}
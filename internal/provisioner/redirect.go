@@ -0,0 +1,159 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// canonicalHostRule is the description of the edge rule redirecting to a
+// domain's canonical host, by which it is found again on the pull zone
+const canonicalHostRule = "whm2bunny canonical host redirect"
+
+// canonicalHostFor returns the canonical host of a domain: the one
+// recorded in its state, else the one of its profile
+func (p *Provisioner) canonicalHostFor(provState *state.ProvisionState) string {
+	if provState.CanonicalHost != "" {
+		return provState.CanonicalHost
+	}
+	if _, profile := p.profileFor(provState); profile.CanonicalHost != "" {
+		return profile.CanonicalHost
+	}
+	return config.CanonicalHostNone
+}
+
+// canonicalHostRedirect returns the edge rule redirecting requests for the
+// other of domain and www.<domain> to host with a 301, keeping the path
+func (p *Provisioner) canonicalHostRedirect(domain, host string) bunny.EdgeRule {
+	from, to := "www."+domain, domain
+	if host == config.CanonicalHostWWW {
+		from, to = domain, "www."+domain
+	}
+	scheme := "http"
+	if p.config.CDN.HTTPS.Enabled {
+		scheme = "https"
+	}
+
+	return bunny.EdgeRule{
+		ActionType:       bunny.EdgeRuleActionRedirect,
+		ActionParameter1: scheme + "://" + to + "%{Url.Path}",
+		ActionParameter2: "301",
+		Triggers: []bunny.EdgeRuleTrigger{{
+			Type:                bunny.EdgeRuleTriggerURL,
+			PatternMatches:      []string{"*://" + from + "/*"},
+			PatternMatchingType: bunny.EdgeRuleMatchAny,
+		}},
+		TriggerMatchingType: bunny.EdgeRuleMatchAny,
+		Description:         canonicalHostRule,
+		Enabled:             true,
+	}
+}
+
+// syncCanonicalHost makes the pull zone's redirect edge rule match the
+// domain's canonical host, adding, replacing or deleting it
+func (p *Provisioner) syncCanonicalHost(ctx context.Context, provState *state.ProvisionState) error {
+	host := p.canonicalHostFor(provState)
+
+	pullZone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+	if err != nil {
+		return fmt.Errorf("failed to get pull zone: %w", err)
+	}
+	existing := pullZone.FindEdgeRule(canonicalHostRule)
+
+	if host == config.CanonicalHostNone {
+		if existing == nil {
+			return nil
+		}
+		return p.bunnyClient.DeleteEdgeRule(ctx, provState.PullZoneID, existing.GUID)
+	}
+
+	rule := p.canonicalHostRedirect(provState.Domain, host)
+	if existing != nil {
+		rule.GUID = existing.GUID
+	}
+	return p.bunnyClient.AddOrUpdateEdgeRule(ctx, provState.PullZoneID, rule)
+}
+
+// applyCanonicalHost adds the redirect edge rule of a newly provisioned
+// domain whose profile has a canonical host, and records the choice in its
+// state so later profile changes leave it alone
+func (p *Provisioner) applyCanonicalHost(ctx context.Context, domain string, provState *state.ProvisionState) {
+	if provState.IsSubdomain() {
+		return
+	}
+	host := p.canonicalHostFor(provState)
+	if host == config.CanonicalHostNone {
+		return
+	}
+
+	if err := p.syncCanonicalHost(ctx, provState); err != nil {
+		p.logger.Warn("failed to add canonical host redirect",
+			zap.String("domain", domain),
+			zap.String("canonical_host", host),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if provState.CanonicalHost != host {
+		provState.CanonicalHost = host
+		if err := p.stateManager.Update(provState); err != nil {
+			p.logger.Warn("failed to record canonical host",
+				zap.String("domain", domain),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// CanonicalHost returns the canonical host of a domain: apex, www or none
+func (p *Provisioner) CanonicalHost(domain string) (string, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", domain, err)
+	}
+	return p.canonicalHostFor(provState), nil
+}
+
+// SetCanonicalHost records the canonical host of a domain and updates the
+// redirect edge rule of its pull zone. A domain that is not provisioned
+// yet returns ErrNotProvisioned; the choice applies once it is
+func (p *Provisioner) SetCanonicalHost(ctx context.Context, domain, host string) error {
+	if err := p.config.DNS.ValidateCanonicalHost(host); err != nil {
+		return err
+	}
+	if host == "" {
+		host = config.CanonicalHostNone
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		return fmt.Errorf("%s: %w", domain, err)
+	}
+	if provState.IsSubdomain() {
+		return fmt.Errorf("%s is a subdomain, set the canonical host of %s", domain, provState.ParentDomain)
+	}
+
+	provState.CanonicalHost = host
+	if err := p.stateManager.Update(provState); err != nil {
+		return fmt.Errorf("failed to record canonical host for %s: %w", domain, err)
+	}
+
+	if provState.PullZoneID <= 0 {
+		return fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	if err := p.syncCanonicalHost(ctx, provState); err != nil {
+		return fmt.Errorf("failed to update canonical host redirect for %s: %w", domain, err)
+	}
+
+	p.logger.Info("canonical host updated",
+		zap.String("domain", domain),
+		zap.String("canonical_host", host),
+	)
+	return nil
+}
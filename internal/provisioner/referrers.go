@@ -0,0 +1,97 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+)
+
+// referrerSettings returns the effective referrer rules for a domain:
+// the profile's lists plus any per-domain extra referrers, with hotlink
+// protection restricting embedding to the domain itself
+func (p *Provisioner) referrerSettings(domain string, profile config.ProfileConfig) bunny.ReferrerSettings {
+	cfg := profile.Referrers
+	o := p.overrideFor(domain)
+
+	hotlink := cfg.HotlinkProtection
+	if o.HotlinkProtection != nil {
+		hotlink = *o.HotlinkProtection
+	}
+
+	var allowed []string
+	if hotlink {
+		allowed = append(allowed, domain, "*."+domain)
+	}
+	allowed = append(allowed, cfg.Allowed...)
+	allowed = append(allowed, o.AllowedReferrers...)
+
+	blocked := append(append([]string{}, cfg.Blocked...), o.BlockedReferrers...)
+
+	return bunny.ReferrerSettings{
+		Allowed:         normalizeReferrers(allowed),
+		Blocked:         normalizeReferrers(blocked),
+		BlockNoReferrer: hotlink && cfg.BlockNoReferrer,
+	}
+}
+
+// hasReferrerRules reports whether settings restrict referrers at all
+func hasReferrerRules(settings bunny.ReferrerSettings) bool {
+	return len(settings.Allowed) > 0 || len(settings.Blocked) > 0 || settings.BlockNoReferrer
+}
+
+// ReferrerSettings returns the effective referrer rules for a domain
+func (p *Provisioner) ReferrerSettings(domain string) bunny.ReferrerSettings {
+	var profile config.ProfileConfig
+	if provState, err := p.stateManager.GetByDomain(domain); err == nil {
+		_, profile = p.profileFor(provState)
+	} else {
+		_, profile = p.config.Profiles.Resolve("")
+	}
+	return p.referrerSettings(domain, profile)
+}
+
+// UpdateReferrers changes a domain's extra referrers and hotlink override,
+// then pushes the effective rules to its pull zone
+// The override is saved even when the domain has no pull zone yet, in which
+// case ErrNotProvisioned is returned and the rules apply on provisioning
+func (p *Provisioner) UpdateReferrers(ctx context.Context, domain string, fn func(o *overrides.Override)) (bunny.ReferrerSettings, error) {
+	if p.overrides == nil {
+		return bunny.ReferrerSettings{}, fmt.Errorf("override store not configured")
+	}
+	if err := p.overrides.Update(domain, fn); err != nil {
+		return bunny.ReferrerSettings{}, fmt.Errorf("failed to save referrers for %s: %w", domain, err)
+	}
+
+	settings := p.ReferrerSettings(domain)
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return settings, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+	if err := p.bunnyClient.SetReferrers(ctx, provState.PullZoneID, settings); err != nil {
+		return settings, fmt.Errorf("failed to update referrers for %s: %w", domain, err)
+	}
+
+	return settings, nil
+}
+
+// normalizeReferrers lowercases, de-duplicates and sorts referrer hostnames
+func normalizeReferrers(referrers []string) []string {
+	seen := make(map[string]bool, len(referrers))
+	result := make([]string, 0, len(referrers))
+	for _, r := range referrers {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r == "" || seen[r] {
+			continue
+		}
+		seen[r] = true
+		result = append(result, r)
+	}
+	sort.Strings(result)
+	return result
+}
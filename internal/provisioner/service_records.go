@@ -0,0 +1,53 @@
+package provisioner
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// serviceRecordType returns the record type for a service record target: A or
+// AAAA for an IP, CNAME for a hostname
+func serviceRecordType(target string) bunny.DNSRecordType {
+	ip := net.ParseIP(target)
+	switch {
+	case ip == nil:
+		return bunny.DNSRecordTypeCNAME
+	case ip.To4() == nil:
+		return bunny.DNSRecordTypeAAAA
+	default:
+		return bunny.DNSRecordTypeA
+	}
+}
+
+// queueServiceRecords queues the cPanel service records (mail, webmail,
+// cpanel, autodiscover, ...) pointing straight at the origin when
+// dns.service_records is enabled. Names that already have a record of any
+// type are left alone so records the customer set up themselves are never
+// overwritten
+func (d *DomainProvisioner) queueServiceRecords(ctx context.Context, q *recordQueue) {
+	cfg := d.provisioner.config.DNS.ServiceRecords
+	if !cfg.Enabled {
+		return
+	}
+
+	target := cfg.Target
+	if target == "" {
+		target = d.provisioner.originIP(q.domain)
+	}
+	recordType := serviceRecordType(target)
+	value := target
+	if recordType == bunny.DNSRecordTypeCNAME {
+		value = strings.TrimSuffix(target, ".") + "."
+	}
+
+	for _, name := range cfg.Names {
+		q.add(ctx, name+" service", &bunny.AddDNSRecordRequest{
+			Type:  recordType,
+			Name:  name,
+			Value: value,
+		}, sameName(name))
+	}
+}
@@ -0,0 +1,65 @@
+package provisioner
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/slo"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// SetSLO attaches the store provisioning run times are recorded in
+func (p *Provisioner) SetSLO(s *slo.Store) {
+	p.slo = s
+}
+
+// recordRun records how long a successful provisioning run took and alerts
+// when it exceeded the slo.target
+func (p *Provisioner) recordRun(ctx context.Context, provState *state.ProvisionState, duration time.Duration) {
+	if p.slo != nil {
+		attempts, took := recovery(provState)
+		err := p.slo.Record(slo.Run{
+			Domain:    provState.Domain,
+			Subdomain: provState.IsSubdomain(),
+			Duration:  duration,
+			Steps:     provState.StepDurations,
+			Attempts:  attempts,
+			Recovery:  took,
+		})
+		if err != nil {
+			p.logger.Warn("failed to record provisioning time",
+				zap.String("domain", provState.Domain),
+				zap.Error(err),
+			)
+		}
+	}
+
+	target := p.config.SLO.Target
+	if target <= 0 || duration <= target {
+		return
+	}
+
+	p.logger.Warn("provisioning exceeded target time",
+		zap.String("domain", provState.Domain),
+		zap.Duration("duration", duration),
+		zap.Duration("target", target),
+	)
+	if err := p.notifier.NotifySlowProvision(ctx, provState.Domain, provState.User, duration, target, provState.StepDurations); err != nil {
+		p.logger.Warn("failed to send slow provision notification",
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// recovery returns the runs a successful provision that failed before took,
+// this one included, and the time from its first run; 0 runs when it
+// succeeded at once
+func recovery(provState *state.ProvisionState) (attempts int, took time.Duration) {
+	if provState.Retries == 0 {
+		return 0, 0
+	}
+	return provState.Retries + 1, provState.UpdatedAt.Sub(provState.CreatedAt)
+}
@@ -0,0 +1,126 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// ResellerEmailFunc returns the contact email of a WHM reseller
+type ResellerEmailFunc func(ctx context.Context, reseller string) (string, error)
+
+// SetResellerEmails attaches the lookup of reseller contact emails, used as
+// SOA email for resellers not in dns.soa_emails when
+// dns.soa_email_from_reseller is set
+func (p *Provisioner) SetResellerEmails(fn ResellerEmailFunc) {
+	p.resellerEmails = fn
+}
+
+// AssignReseller records the WHM reseller owning a domain so its DNS zone
+// is created with the reseller's SOA email
+// This implements the webhook.ResellerAssigner interface
+func (p *Provisioner) AssignReseller(domain, reseller string) error {
+	if reseller == "" {
+		return nil
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil {
+		provState = p.stateManager.Create(domain)
+	}
+
+	if provState.Reseller == reseller {
+		return nil
+	}
+	provState.Reseller = reseller
+	if err := p.stateManager.Update(provState); err != nil {
+		return fmt.Errorf("failed to record reseller for %s: %w", domain, err)
+	}
+	return nil
+}
+
+// soaEmail returns the SOA email of the zones of a reseller's accounts: the
+// one mapped in dns.soa_emails, else the reseller's contact email when
+// dns.soa_email_from_reseller is set, else dns.soa_email
+func (p *Provisioner) soaEmail(ctx context.Context, reseller string) string {
+	if email, ok := p.config.DNS.ResellerSOAEmail(reseller); ok {
+		return email
+	}
+	if reseller != "" && p.config.DNS.SOAEmailFromReseller && p.resellerEmails != nil {
+		email, err := p.resellerEmails(ctx, reseller)
+		if err == nil {
+			return email
+		}
+		p.logger.Warn("failed to look up reseller email, using the default SOA email",
+			zap.String("reseller", reseller),
+			zap.Error(err),
+		)
+	}
+	return p.config.DNS.SOAEmail
+}
+
+// SOAUpdate is the outcome of setting the SOA email of one domain's zone
+type SOAUpdate struct {
+	Domain string `json:"domain"`
+	ZoneID int64  `json:"zone_id"`
+	Email  string `json:"email"`
+	Error  string `json:"error,omitempty"`
+}
+
+// UpdateResellerSOA sets the SOA email of reseller on the DNS zones of its
+// domains: those recorded with the reseller, and those owned by one of
+// users, the reseller's accounts as listed by WHM, which get the reseller
+// recorded. Subdomains share their parent's zone and are left out
+func (p *Provisioner) UpdateResellerSOA(ctx context.Context, reseller string, users []string) []SOAUpdate {
+	email := p.soaEmail(ctx, reseller)
+
+	var updates []SOAUpdate
+	for _, st := range p.stateManager.ListAll() {
+		if st.IsSubdomain() || st.ZoneID <= 0 {
+			continue
+		}
+		owned := slices.Contains(users, st.User)
+		if !owned && !strings.EqualFold(st.Reseller, reseller) {
+			continue
+		}
+		if p.skipFrozen(st.Domain, "SOA email update") {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		update := SOAUpdate{Domain: st.Domain, ZoneID: st.ZoneID, Email: email}
+		if err := p.bunnyClient.UpdateDNSZone(ctx, st.ZoneID, &bunny.UpdateDNSZoneRequest{SoaEmail: email}); err != nil {
+			update.Error = err.Error()
+		} else if owned && st.Reseller != reseller {
+			p.recordReseller(st, reseller)
+		}
+		updates = append(updates, update)
+	}
+
+	p.logger.Info("reseller SOA email updated",
+		zap.String("reseller", reseller),
+		zap.String("soa_email", email),
+		zap.Int("zones", len(updates)),
+	)
+	return updates
+}
+
+// recordReseller saves the reseller found owning a domain
+func (p *Provisioner) recordReseller(provState *state.ProvisionState, reseller string) {
+	provState.Reseller = reseller
+	if err := p.stateManager.Update(provState); err != nil {
+		p.logger.Warn("failed to record reseller",
+			zap.String("domain", provState.Domain),
+			zap.String("reseller", reseller),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,52 @@
+package provisioner
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RecoveryProgress describes the most recent recovery run
+type RecoveryProgress struct {
+	Running    bool      `json:"running"`
+	Total      int       `json:"total"`
+	Done       int       `json:"done"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// stats tracks provisioning activity for the health endpoint
+type stats struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	recovery RecoveryProgress
+}
+
+// InFlight returns the number of provisions currently running
+func (p *Provisioner) InFlight() int64 {
+	return p.stats.inFlight.Load()
+}
+
+// RecoveryProgress returns the progress of the current or last recovery run
+func (p *Provisioner) RecoveryProgress() RecoveryProgress {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	return p.stats.recovery
+}
+
+// trackProvision counts a provision as in flight until the returned func is called
+func (p *Provisioner) trackProvision() func() {
+	p.stats.inFlight.Add(1)
+	return func() { p.stats.inFlight.Add(-1) }
+}
+
+// updateRecovery applies fn to the recovery progress under the lock
+func (p *Provisioner) updateRecovery(fn func(*RecoveryProgress)) {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	fn(&p.stats.recovery)
+}
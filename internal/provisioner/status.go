@@ -0,0 +1,64 @@
+package provisioner
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/status"
+)
+
+// SetStatusWriter attaches the writer of the per-domain status files read
+// by WHM hook scripts
+func (p *Provisioner) SetStatusWriter(w *status.Writer) {
+	p.status = w
+}
+
+// writeStatus records the provisioning state of a domain in its status
+// file; domains without a known owner have none
+func (p *Provisioner) writeStatus(provState *state.ProvisionState, st status.State, errMsg string) {
+	if p.status == nil || provState.User == "" {
+		return
+	}
+
+	err := p.status.Write(status.Status{
+		Domain:       provState.Domain,
+		ParentDomain: provState.ParentDomain,
+		User:         provState.User,
+		State:        st,
+		CDNHostname:  provState.CDNHostname,
+		Error:        errMsg,
+	})
+	if err != nil {
+		p.logger.Warn("failed to write status file",
+			zap.String("domain", provState.Domain),
+			zap.String("state", string(st)),
+			zap.Error(err),
+		)
+	}
+}
+
+// writeSavedStatus records the state of a domain with its saved state,
+// which holds everything the provisioning steps recorded
+func (p *Provisioner) writeSavedStatus(id string, st status.State, errMsg string) {
+	if p.status == nil {
+		return
+	}
+	provState, err := p.stateManager.Get(id)
+	if err != nil {
+		return
+	}
+	p.writeStatus(provState, st, errMsg)
+}
+
+// removeStatus deletes the status file of a deprovisioned domain
+func (p *Provisioner) removeStatus(provState *state.ProvisionState) {
+	if p.status == nil || provState.User == "" {
+		return
+	}
+	if err := p.status.Remove(provState.User, provState.Domain); err != nil {
+		p.logger.Warn("failed to remove status file",
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+	}
+}
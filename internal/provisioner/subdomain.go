@@ -3,11 +3,11 @@ package provisioner
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"go.uber.org/zap"
 
 	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/hooks"
 	"github.com/mordenhost/whm2bunny/internal/state"
 )
 
@@ -21,9 +21,10 @@ type SubdomainProvisioner struct {
 }
 
 // Provision provisions a subdomain with CDN pull zone
-// This is a 2-step process:
-// Step 1: Find parent DNS zone and create pull zone for subdomain
-// Step 2: Add CNAME record in parent zone
+// This is a 3-step process:
+// Step 1: Find the parent DNS zone
+// Step 2: Create a pull zone for the subdomain
+// Step 3: Add CNAME record in parent zone
 func (s *SubdomainProvisioner) Provision(ctx context.Context, subdomain, parentDomain, user string) error {
 	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
 
@@ -35,18 +36,36 @@ func (s *SubdomainProvisioner) Provision(ctx context.Context, subdomain, parentD
 
 	// Resume from the last successful step
 	switch provState.CurrentStep {
-	case state.StepNone, state.StepDNSZone:
-		// For subdomains, StepDNSZone means finding parent and creating pull zone
-		if err := s.findParentAndCreatePullZone(ctx, subdomain, parentDomain, provState); err != nil {
-			return fmt.Errorf("failed to find parent zone and create pull zone: %w", err)
+	case state.StepNone, state.SubdomainStepFindParent:
+		if err := s.provisioner.stateManager.AdvanceStep(provState.ID, state.SubdomainStepFindParent); err != nil {
+			return err
+		}
+		if err := s.findParentZone(ctx, fullDomain, parentDomain, provState); err != nil {
+			return fmt.Errorf("failed to find parent zone: %w", err)
+		}
+		fallthrough
+
+	case state.SubdomainStepPullZone:
+		if s.provisioner.pullZonesPaused(ctx) {
+			return ErrPullZonesPaused
 		}
+		if err := s.createPullZone(ctx, subdomain, parentDomain, provState); err != nil {
+			return fmt.Errorf("failed to create pull zone: %w", err)
+		}
+		s.provisioner.applyProfile(ctx, fullDomain, provState)
 		fallthrough
 
-	case state.StepDNSRecords, state.StepPullZone:
-		// For subdomains, this step means adding the CNAME to parent zone
+	case state.SubdomainStepCNAME:
+		// Also runs on resume, so a provision failed by the hook retries it
+		if err := s.provisioner.runHooks(ctx, hooks.PostPullZoneCreate, provState); err != nil {
+			return fmt.Errorf("post_pull_zone_create hook failed: %w", err)
+		}
 		if err := s.addSubdomainCNAME(ctx, subdomain, parentDomain, provState); err != nil {
 			return fmt.Errorf("failed to add subdomain CNAME: %w", err)
 		}
+		s.provisioner.secureHostnames(ctx, provState, []string{fullDomain})
+		s.provisioner.verifyPropagation(ctx, provState)
+		s.provisioner.verifyVHost(ctx, provState)
 
 	default:
 		// Already completed
@@ -58,17 +77,14 @@ func (s *SubdomainProvisioner) Provision(ctx context.Context, subdomain, parentD
 	return nil
 }
 
-// findParentAndCreatePullZone finds the parent DNS zone and creates a pull zone for the subdomain
+// findParentZone finds the parent DNS zone, which holds the subdomain's CNAME
 // Step 1 of subdomain provisioning
-func (s *SubdomainProvisioner) findParentAndCreatePullZone(ctx context.Context, subdomain, parentDomain string, provState *state.ProvisionState) error {
-	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
-
+func (s *SubdomainProvisioner) findParentZone(ctx context.Context, fullDomain, parentDomain string, provState *state.ProvisionState) error {
 	s.provisioner.logger.Info("finding parent DNS zone",
 		zap.String("subdomain", fullDomain),
 		zap.String("parent_domain", parentDomain),
 	)
 
-	// Find parent DNS zone
 	parentZone, err := s.provisioner.bunnyClient.GetDNSZone(ctx, parentDomain)
 	if err != nil {
 		s.provisioner.logger.Error("parent DNS zone not found",
@@ -83,39 +99,48 @@ func (s *SubdomainProvisioner) findParentAndCreatePullZone(ctx context.Context,
 		zap.Int64("zone_id", parentZone.ID),
 	)
 
-	// Store parent zone ID in state (we use ZoneID field for parent)
+	// Subdomain states keep the parent's zone in ZoneID
 	provState.ZoneID = parentZone.ID
 	if err := s.provisioner.stateManager.Update(provState); err != nil {
 		return err
 	}
+	return s.provisioner.stateManager.AdvanceStep(provState.ID, state.SubdomainStepPullZone)
+}
 
-	// Create pull zone for subdomain
-	pullZoneName := generateSubdomainPullZoneName(subdomain, parentDomain)
+// createPullZone creates a pull zone for the subdomain, reusing one left by
+// an earlier attempt
+// Step 2 of subdomain provisioning
+func (s *SubdomainProvisioner) createPullZone(ctx context.Context, subdomain, parentDomain string, provState *state.ProvisionState) error {
+	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
 
 	// Check if pull zone already exists
-	existingZone, err := s.provisioner.bunnyClient.GetPullZoneByName(ctx, pullZoneName)
+	existingZone, err := s.provisioner.findPullZone(ctx, fullDomain)
 	if err == nil && existingZone != nil {
 		s.provisioner.logger.Info("subdomain pull zone already exists, reusing",
 			zap.String("subdomain", fullDomain),
-			zap.String("zone_name", pullZoneName),
+			zap.String("zone_name", existingZone.Name),
 			zap.Int64("zone_id", existingZone.ID),
 		)
 		provState.PullZoneID = existingZone.ID
-		provState.CDNHostname = s.extractCDNHostname(existingZone)
-		if updateErr := s.provisioner.stateManager.Update(provState); updateErr != nil {
-			return updateErr
-		}
-		// Skip to CNAME step
+		provState.CDNHostname = existingZone.SystemHostname()
 		if err := s.provisioner.stateManager.Update(provState); err != nil {
 			return err
 		}
-		provState.CurrentStep = state.StepPullZone
-		return nil
+		return s.provisioner.stateManager.AdvanceStep(provState.ID, state.SubdomainStepCNAME)
 	}
 
 	// Create the pull zone
-	originIP := s.provisioner.config.Origin.IP
-	pullZone, err := s.provisioner.bunnyClient.CreatePullZone(ctx, fullDomain, originIP)
+	originIP := s.provisioner.originIP(fullDomain)
+	_, profile := s.provisioner.profileFor(provState)
+	opts := s.provisioner.pullZoneOptions(fullDomain, profile)
+	if err := s.provisioner.verifyOrigin(ctx, fullDomain, opts.Endpoint); err != nil {
+		s.provisioner.logger.Error("origin pre-flight check failed",
+			zap.String("subdomain", fullDomain),
+			zap.Error(err),
+		)
+		return err
+	}
+	pullZone, err := s.provisioner.bunnyClient.CreatePullZone(ctx, fullDomain, originIP, opts)
 	if err != nil {
 		s.provisioner.logger.Error("failed to create pull zone for subdomain",
 			zap.String("subdomain", fullDomain),
@@ -135,19 +160,21 @@ func (s *SubdomainProvisioner) findParentAndCreatePullZone(ctx context.Context,
 	}
 
 	// Extract CDN hostname
-	cdnHostname := s.extractCDNHostname(pullZone)
+	cdnHostname := pullZone.SystemHostname()
 
 	// Update state
 	provState.PullZoneID = pullZone.ID
 	provState.CDNHostname = cdnHostname
-	provState.CurrentStep = state.StepPullZone
 	if err := s.provisioner.stateManager.Update(provState); err != nil {
 		return err
 	}
+	if err := s.provisioner.stateManager.AdvanceStep(provState.ID, state.SubdomainStepCNAME); err != nil {
+		return err
+	}
 
 	s.provisioner.logger.Info("subdomain pull zone created successfully",
 		zap.String("subdomain", fullDomain),
-		zap.String("zone_name", pullZoneName),
+		zap.String("zone_name", pullZone.Name),
 		zap.Int64("zone_id", pullZone.ID),
 		zap.String("cdn_hostname", cdnHostname),
 	)
@@ -156,7 +183,7 @@ func (s *SubdomainProvisioner) findParentAndCreatePullZone(ctx context.Context,
 }
 
 // addSubdomainCNAME adds a CNAME record in the parent zone pointing to the CDN
-// Step 2 of subdomain provisioning
+// Step 3 of subdomain provisioning
 func (s *SubdomainProvisioner) addSubdomainCNAME(ctx context.Context, subdomain, parentDomain string, provState *state.ProvisionState) error {
 	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
 
@@ -171,7 +198,7 @@ func (s *SubdomainProvisioner) addSubdomainCNAME(ctx context.Context, subdomain,
 		return fmt.Errorf("failed to get pull zone: %w", err)
 	}
 
-	cdnHostname := s.extractCDNHostname(pullZone)
+	cdnHostname := pullZone.SystemHostname()
 	if cdnHostname == "" {
 		return fmt.Errorf("could not extract CDN hostname from pull zone")
 	}
@@ -225,12 +252,18 @@ func (s *SubdomainProvisioner) addSubdomainCNAME(ctx context.Context, subdomain,
 		}
 	}
 
-	// Mark as completed
-	provState.CurrentStep = state.StepCNAMESync
+	// Update state with the CDN hostname, once checked to resolve
+	provState.CDNHostname = cdnHostname
+	s.provisioner.verifyCDNHostname(ctx, provState)
 	if err := s.provisioner.stateManager.Update(provState); err != nil {
 		return err
 	}
 
+	// Mark as completed
+	if err := s.provisioner.stateManager.AdvanceStep(provState.ID, state.SubdomainStepVerified); err != nil {
+		return err
+	}
+
 	s.provisioner.logger.Info("subdomain CNAME added successfully",
 		zap.String("subdomain", fullDomain),
 		zap.String("cdn_hostname", cdnHostname),
@@ -239,34 +272,6 @@ func (s *SubdomainProvisioner) addSubdomainCNAME(ctx context.Context, subdomain,
 	return nil
 }
 
-// extractCDNHostname extracts the CDN hostname from a pull zone
-func (s *SubdomainProvisioner) extractCDNHostname(pullZone *bunny.PullZone) string {
-	if pullZone == nil {
-		return ""
-	}
-
-	// Check if we have hostnames in the pull zone
-	if len(pullZone.Hostnames) > 0 {
-		// The first hostname is usually the CDN hostname
-		for _, h := range pullZone.Hostnames {
-			if strings.Contains(h.Hostname, ".bunnycdn.com") {
-				return h.Hostname
-			}
-		}
-		// If no bunnycdn.com hostname, return the first one
-		if pullZone.Hostnames[0].Hostname != "" {
-			return pullZone.Hostnames[0].Hostname
-		}
-	}
-
-	// Fallback: construct hostname from zone ID
-	if pullZone.ID > 0 {
-		return fmt.Sprintf("%d.bunnycdn.com", pullZone.ID)
-	}
-
-	return ""
-}
-
 // GetSubdomainStatus returns the current provisioning status for a subdomain
 func (s *SubdomainProvisioner) GetSubdomainStatus(ctx context.Context, subdomain, parentDomain string) (*state.ProvisionState, error) {
 	fullDomain := fmt.Sprintf("%s.%s", subdomain, parentDomain)
@@ -0,0 +1,103 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+)
+
+// pullZoneType returns the tier for a domain: a tier set by ConvertZone wins
+// over the profile
+func (p *Provisioner) pullZoneType(domain string, profile config.ProfileConfig) bunny.PullZoneType {
+	name := profile.Tier
+	if o := p.overrideFor(domain); o.Tier != "" {
+		name = o.Tier
+	}
+
+	zoneType, err := bunny.ParsePullZoneType(name)
+	if err != nil {
+		p.logger.Warn("invalid pull zone tier, using standard",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+		return bunny.PullZoneTypeStandard
+	}
+	return zoneType
+}
+
+// pullZoneOptions returns the settings used when creating a domain's pull zone
+func (p *Provisioner) pullZoneOptions(domain string, profile config.ProfileConfig) bunny.PullZoneOptions {
+	return bunny.PullZoneOptions{
+		Name:     p.names.PullZone(domain),
+		Type:     p.pullZoneType(domain, profile),
+		Origin:   p.originSettings(profile),
+		Endpoint: p.originEndpoint(domain, profile),
+		GeoZones: p.geoZones(domain),
+	}
+}
+
+// geoZones returns the geo zones set for a domain; nil leaves the default
+func (p *Provisioner) geoZones(domain string) []string {
+	zones, err := bunny.ParseGeoZones(p.overrideFor(domain).GeoZones)
+	if err != nil {
+		p.logger.Warn("invalid geo zones, using the default",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+		return nil
+	}
+	return zones
+}
+
+// ConvertZone moves a domain's pull zone to another tier and records the
+// tier per domain so drift enforcement keeps it
+// For domains without a pull zone the tier is saved and ErrNotProvisioned is
+// returned; it then applies on provisioning. A conversion Bunny rejects is
+// not saved
+func (p *Provisioner) ConvertZone(ctx context.Context, domain string, zoneType bunny.PullZoneType) error {
+	if p.overrides == nil {
+		return fmt.Errorf("override store not configured")
+	}
+	save := func() error {
+		if err := p.overrides.Update(domain, func(o *overrides.Override) {
+			o.Tier = zoneType.String()
+		}); err != nil {
+			return fmt.Errorf("failed to save tier for %s: %w", domain, err)
+		}
+		return nil
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		if err := save(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	zone, err := p.bunnyClient.GetPullZone(ctx, provState.PullZoneID)
+	if err != nil {
+		return fmt.Errorf("failed to get pull zone for %s: %w", domain, err)
+	}
+	if zone.Type != zoneType {
+		if err := p.bunnyClient.SetPullZoneType(ctx, provState.PullZoneID, zoneType); err != nil {
+			return fmt.Errorf("failed to convert %s to the %s tier: %w", domain, zoneType, err)
+		}
+	}
+
+	if err := save(); err != nil {
+		return err
+	}
+
+	p.logger.Info("pull zone tier converted",
+		zap.String("domain", domain),
+		zap.Stringer("from", zone.Type),
+		zap.Stringer("to", zoneType),
+	)
+	return nil
+}
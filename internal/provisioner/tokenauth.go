@@ -0,0 +1,104 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/tokenauth"
+)
+
+// SetTokenKeys attaches the store for pull zone token authentication keys
+func (p *Provisioner) SetTokenKeys(k *tokenauth.KeyStore) {
+	p.tokenKeys = k
+}
+
+// ensureTokenAuth enables token authentication on a pull zone with a
+// freshly generated key, unless a key is already stored for the domain
+func (p *Provisioner) ensureTokenAuth(ctx context.Context, domain string, provState *state.ProvisionState) error {
+	if p.tokenKeys == nil {
+		return fmt.Errorf("token key store not configured")
+	}
+	if _, ok := p.tokenKeys.Get(domain); ok {
+		return nil
+	}
+
+	if err := p.setTokenKey(ctx, domain, provState.PullZoneID); err != nil {
+		return err
+	}
+	if err := p.bunnyClient.SetTokenAuthentication(ctx, provState.PullZoneID, true); err != nil {
+		return fmt.Errorf("failed to enable token authentication: %w", err)
+	}
+
+	p.logger.Info("token authentication enabled",
+		zap.String("domain", domain),
+		zap.Int64("pull_zone_id", provState.PullZoneID),
+	)
+	return nil
+}
+
+// setTokenKey generates a key, sets it on the pull zone and stores it
+func (p *Provisioner) setTokenKey(ctx context.Context, domain string, pullZoneID int64) error {
+	key, err := tokenauth.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := p.bunnyClient.ResetSecurityKey(ctx, pullZoneID, key); err != nil {
+		return fmt.Errorf("failed to set security key: %w", err)
+	}
+	if err := p.tokenKeys.Set(domain, key); err != nil {
+		return fmt.Errorf("failed to store security key: %w", err)
+	}
+	return nil
+}
+
+// RotateTokenKey replaces the token authentication key of a provisioned
+// domain and sends a notification
+func (p *Provisioner) RotateTokenKey(ctx context.Context, domain string) error {
+	if p.tokenKeys == nil {
+		return fmt.Errorf("token key store not configured")
+	}
+
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	if err := p.setTokenKey(ctx, domain, provState.PullZoneID); err != nil {
+		return err
+	}
+
+	p.logger.Info("token key rotated",
+		zap.String("domain", domain),
+		zap.Int64("pull_zone_id", provState.PullZoneID),
+	)
+
+	if err := p.notifier.NotifyTokenRotated(ctx, domain); err != nil {
+		p.logger.Warn("failed to send token rotation notification",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+// SignURL returns a signed URL for path on domain, valid for ttl
+func (p *Provisioner) SignURL(domain, path string, ttl time.Duration) (string, error) {
+	if p.tokenKeys == nil {
+		return "", fmt.Errorf("token key store not configured")
+	}
+
+	key, ok := p.tokenKeys.Get(domain)
+	if !ok {
+		return "", fmt.Errorf("no token key stored for %s (token authentication not enabled?)", domain)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return tokenauth.SignURL("https://"+domain+path, key.Key, time.Now().Add(ttl))
+}
@@ -0,0 +1,24 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// GeoTraffic returns the bandwidth a domain's pull zone served per edge
+// location between from and to, largest first
+func (p *Provisioner) GeoTraffic(ctx context.Context, domain string, from, to time.Time) ([]bunny.GeoTraffic, error) {
+	provState, err := p.stateManager.GetByDomain(domain)
+	if err != nil || provState.PullZoneID <= 0 {
+		return nil, fmt.Errorf("%s: %w", domain, ErrNotProvisioned)
+	}
+
+	traffic, err := p.bunnyClient.GetPullZoneGeoTraffic(ctx, provState.PullZoneID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get geo traffic: %w", err)
+	}
+	return traffic, nil
+}
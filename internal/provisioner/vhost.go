@@ -0,0 +1,91 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/vhost"
+)
+
+// CheckVHost requests a domain's site through its pull zone hostname and at
+// the origin with the Host header the pull zone sends, and the origin's
+// answer for its bare IP to compare them with, each request bounded by
+// timeout. It tells whether the origin serves the site or its default
+// virtual host, such as when cPanel does not know the domain
+func (p *Provisioner) CheckVHost(ctx context.Context, domain, cdnHostname string, timeout time.Duration) *vhost.Report {
+	endpoint := p.OriginEndpoint(domain)
+	url := endpoint.URL(p.originIP(domain)) + "/"
+	host := endpoint.Host(domain)
+
+	r := &vhost.Report{}
+	r.Origin, r.OriginErr = vhost.Fetch(ctx, originClient(host, !endpoint.VerifySSL, timeout), url, host)
+	// No certificate matches the bare IP, and only the page matters
+	r.Default, r.DefaultErr = vhost.Fetch(ctx, originClient("", true, timeout), url, "")
+	if cdnHostname != "" {
+		r.CDN, r.CDNErr = vhost.Fetch(ctx, originClient(cdnHostname, false, timeout), "https://"+cdnHostname+"/", "")
+	} else {
+		r.CDNErr = fmt.Errorf("no pull zone hostname")
+	}
+	r.Evaluate()
+
+	p.logger.Debug("virtual host checked",
+		zap.String("domain", domain),
+		zap.String("result", string(r.Result)),
+	)
+	return r
+}
+
+// verifyVHost checks, once a domain is provisioned, that its origin serves
+// the site rather than its default virtual host, and records the outcome in
+// provState. The site may be set up on the origin later, so a problem is
+// logged, not an error
+func (p *Provisioner) verifyVHost(ctx context.Context, provState *state.ProvisionState) {
+	cfg := p.config.CDN.VHostCheck
+	if !cfg.Enabled {
+		return
+	}
+
+	report := p.CheckVHost(ctx, provState.Domain, provState.CDNHostname, cfg.Timeout)
+	check := &state.VHostCheck{Result: string(report.Result), CheckedAt: time.Now()}
+	if report.CDN != nil {
+		check.CDNStatus = report.CDN.Status
+	}
+	if report.Origin != nil {
+		check.OriginStatus = report.Origin.Status
+	}
+	switch {
+	case report.OriginErr != nil:
+		check.Error = report.OriginErr.Error()
+	case report.CDNErr != nil:
+		check.Error = report.CDNErr.Error()
+	}
+
+	provState.VHostCheck = check
+	if err := p.stateManager.Update(provState); err != nil {
+		p.logger.Warn("failed to record virtual host check",
+			zap.String("domain", provState.Domain),
+			zap.Error(err),
+		)
+	}
+
+	fields := []zap.Field{
+		zap.String("domain", provState.Domain),
+		zap.String("result", check.Result),
+		zap.Int("cdn_status", check.CDNStatus),
+		zap.Int("origin_status", check.OriginStatus),
+	}
+	switch report.Result {
+	case vhost.ResultOK:
+		p.logger.Info("origin serves the site", fields...)
+	case vhost.ResultDefaultVHost:
+		p.logger.Warn("origin serves its default virtual host for the domain; check that the domain is on the cPanel account and the origin host header", fields...)
+	case vhost.ResultMismatch:
+		p.logger.Warn("site answers differently through the CDN and at the origin", fields...)
+	default:
+		p.logger.Warn("virtual host check failed", append(fields, zap.String("error", check.Error))...)
+	}
+}
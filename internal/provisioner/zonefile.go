@@ -0,0 +1,274 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/zonefile"
+)
+
+// zoneFileTypes maps the record types a zone file holds to Bunny's
+var zoneFileTypes = map[string]bunny.DNSRecordType{
+	zonefile.TypeA:     bunny.DNSRecordTypeA,
+	zonefile.TypeAAAA:  bunny.DNSRecordTypeAAAA,
+	zonefile.TypeCNAME: bunny.DNSRecordTypeCNAME,
+	zonefile.TypeMX:    bunny.DNSRecordTypeMX,
+	zonefile.TypeNS:    bunny.DNSRecordTypeNS,
+	zonefile.TypeTXT:   bunny.DNSRecordTypeTXT,
+}
+
+// Zone import actions
+const (
+	ZoneAdd    = "add"
+	ZoneUpdate = "update"
+	ZoneDelete = "delete"
+)
+
+// ZoneChange is one record added, updated or deleted by a zone import
+type ZoneChange struct {
+	Action string `json:"action"`
+	// Record is the record as "name TYPE value"
+	Record string `json:"record"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ZoneImport is the outcome of importing a zone file
+type ZoneImport struct {
+	Domain string `json:"domain"`
+	ZoneID int64  `json:"zone_id,omitempty"`
+	// Created is set when the DNS zone did not exist and was created
+	Created   bool         `json:"created,omitempty"`
+	Changes   []ZoneChange `json:"changes"`
+	Unchanged int          `json:"unchanged"`
+}
+
+// Failed returns how many changes failed
+func (i *ZoneImport) Failed() int {
+	failed := 0
+	for _, c := range i.Changes {
+		if c.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ExportZone returns the records of a domain's Bunny DNS zone as a zone
+// file. Disabled records and record types a zone file cannot hold, such as
+// Bunny's pull zone records, are left out
+func (p *Provisioner) ExportZone(ctx context.Context, domain string) (*zonefile.Zone, error) {
+	zone, err := p.bunnyClient.GetDNSZone(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS zone of %s: %w", domain, err)
+	}
+	records, err := p.bunnyClient.GetDNSRecords(ctx, zone.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS records: %w", err)
+	}
+
+	z := &zonefile.Zone{Origin: domain, TTL: defaultDNSRecordTTL}
+	for _, r := range records {
+		if _, ok := zoneFileTypes[r.Type.String()]; !ok || !r.Enabled {
+			continue
+		}
+		z.Records = append(z.Records, zoneRecord(r))
+	}
+	return z, nil
+}
+
+// zoneRecord converts a Bunny record to a zone file record
+func zoneRecord(r bunny.DNSRecord) zonefile.Record {
+	name := r.Name
+	if name == "@" {
+		name = ""
+	}
+	value := r.Value
+	if r.Type == bunny.DNSRecordTypeCNAME || r.Type == bunny.DNSRecordTypeMX || r.Type == bunny.DNSRecordTypeNS {
+		value = strings.TrimSuffix(value, ".")
+	}
+	return zonefile.Record{
+		Name:     strings.ToLower(name),
+		TTL:      r.TTL,
+		Type:     r.Type.String(),
+		Priority: r.Priority,
+		Value:    value,
+	}
+}
+
+// ImportZone applies a zone file to a domain's Bunny DNS zone, creating
+// the zone when it does not exist. Records of the file missing from the
+// zone are added and those differing only in TTL or MX preference are
+// updated; a CNAME pointing elsewhere is repointed. With prune, records
+// of the zone not in the file are deleted, except those of types a zone
+// file cannot hold. With dryRun, nothing is changed and the changes are
+// only returned
+func (p *Provisioner) ImportZone(ctx context.Context, domain string, z *zonefile.Zone, prune, dryRun bool) (*ZoneImport, error) {
+	result := &ZoneImport{Domain: domain, Changes: []ZoneChange{}}
+
+	var existing []bunny.DNSRecord
+	zone, err := p.bunnyClient.GetDNSZone(ctx, domain)
+	var apiErr *bunny.APIError
+	switch {
+	case err == nil:
+		result.ZoneID = zone.ID
+		if existing, err = p.bunnyClient.GetDNSRecords(ctx, zone.ID); err != nil {
+			return nil, fmt.Errorf("failed to get DNS records: %w", err)
+		}
+	case errors.As(err, &apiErr) && apiErr.IsNotFound():
+		result.Created = true
+		if !dryRun {
+			reseller := ""
+			if provState, err := p.stateManager.GetByDomain(domain); err == nil {
+				reseller = provState.Reseller
+			}
+			zone, err := p.bunnyClient.CreateDNSZone(ctx, domain, p.soaEmail(ctx, reseller))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create DNS zone: %w", err)
+			}
+			result.ZoneID = zone.ID
+		}
+	default:
+		return nil, fmt.Errorf("failed to get DNS zone of %s: %w", domain, err)
+	}
+
+	// Records of the zone matched by a record of the file
+	matched := make(map[int64]bool)
+	apply := func(action string, r zonefile.Record, do func() error) {
+		change := ZoneChange{Action: action, Record: describeZoneRecord(r)}
+		if !dryRun {
+			if err := do(); err != nil {
+				change.Error = err.Error()
+			}
+		}
+		result.Changes = append(result.Changes, change)
+	}
+
+	for _, r := range z.Records {
+		if r.TTL <= 0 {
+			r.TTL = defaultDNSRecordTTL
+		}
+		name := r.Name
+		if name == "" {
+			// Bunny names the apex @, as provisioning writes it
+			name = "@"
+		}
+		req := &bunny.AddDNSRecordRequest{
+			Type:     zoneFileTypes[r.Type],
+			Name:     name,
+			Value:    bunnyRecordValue(r),
+			TTL:      r.TTL,
+			Priority: r.Priority,
+			Enabled:  true,
+		}
+
+		found := matchZoneRecord(existing, matched, r, false)
+		if found == nil && r.Type == zonefile.TypeCNAME {
+			// A name holds a single CNAME, which is repointed
+			found = matchZoneRecord(existing, matched, r, true)
+		}
+		if found == nil {
+			apply(ZoneAdd, r, func() error {
+				_, err := p.bunnyClient.AddDNSRecord(ctx, result.ZoneID, req)
+				return err
+			})
+			continue
+		}
+
+		matched[found.ID] = true
+		if got := zoneRecord(*found); found.Enabled && got.TTL == r.TTL && got.Priority == r.Priority && sameZoneValue(got, r) {
+			result.Unchanged++
+			continue
+		}
+		recordID := found.ID
+		apply(ZoneUpdate, r, func() error {
+			return p.bunnyClient.UpdateDNSRecord(ctx, result.ZoneID, recordID, &bunny.UpdateDNSRecordRequest{
+				Type:     req.Type,
+				Name:     req.Name,
+				Value:    req.Value,
+				TTL:      req.TTL,
+				Priority: req.Priority,
+				Enabled:  true,
+			})
+		})
+	}
+
+	if prune {
+		for _, r := range existing {
+			if _, ok := zoneFileTypes[r.Type.String()]; !ok || matched[r.ID] {
+				continue
+			}
+			recordID := r.ID
+			apply(ZoneDelete, zoneRecord(r), func() error {
+				return p.bunnyClient.DeleteDNSRecord(ctx, result.ZoneID, recordID)
+			})
+		}
+	}
+
+	if !dryRun {
+		p.logger.Info("zone file imported",
+			zap.String("domain", domain),
+			zap.Int64("zone_id", result.ZoneID),
+			zap.Bool("created", result.Created),
+			zap.Int("changes", len(result.Changes)),
+			zap.Int("failed", result.Failed()),
+		)
+	}
+	return result, nil
+}
+
+// matchZoneRecord returns the record of the zone not matched yet with the
+// name and type of r and, unless anyValue is set, its value
+func matchZoneRecord(existing []bunny.DNSRecord, matched map[int64]bool, r zonefile.Record, anyValue bool) *bunny.DNSRecord {
+	for i, e := range existing {
+		if matched[e.ID] {
+			continue
+		}
+		got := zoneRecord(e)
+		if got.Type != r.Type || got.Name != r.Name {
+			continue
+		}
+		if anyValue || sameZoneValue(got, r) {
+			return &existing[i]
+		}
+	}
+	return nil
+}
+
+// sameZoneValue reports whether two records of one type have the same
+// value; only TXT values are case sensitive
+func sameZoneValue(a, b zonefile.Record) bool {
+	if a.Type == zonefile.TypeTXT {
+		return a.Value == b.Value
+	}
+	return strings.EqualFold(a.Value, b.Value)
+}
+
+// bunnyRecordValue returns the value of a zone file record as Bunny stores
+// it; host names are written absolute, as provisioning writes them
+func bunnyRecordValue(r zonefile.Record) string {
+	switch r.Type {
+	case zonefile.TypeCNAME, zonefile.TypeMX, zonefile.TypeNS:
+		return r.Value + "."
+	default:
+		return r.Value
+	}
+}
+
+// describeZoneRecord formats a record as "name TYPE value", @ naming the
+// apex
+func describeZoneRecord(r zonefile.Record) string {
+	name := r.Name
+	if name == "" {
+		name = "@"
+	}
+	value := r.Value
+	if r.Type == zonefile.TypeMX {
+		value = fmt.Sprintf("%d %s", r.Priority, value)
+	}
+	return fmt.Sprintf("%s %s %s", name, r.Type, value)
+}
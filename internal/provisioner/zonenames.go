@@ -0,0 +1,62 @@
+package provisioner
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// ZoneName is a pull zone named with the current or a previous zone name
+// prefix
+type ZoneName struct {
+	Name       string `json:"name"`
+	PullZoneID int64  `json:"pull_zone_id"`
+	Prefix     string `json:"prefix"`
+	Current    bool   `json:"current"`
+	// Domain is the domain whose state references the zone, empty for
+	// zones no state knows
+	Domain string `json:"domain,omitempty"`
+}
+
+// ZoneNames lists the pull zones named with cdn.zone_name_prefix or a
+// previous prefix, those under a previous prefix first
+// Bunny cannot rename a pull zone, since its name is its b-cdn.net
+// hostname: zones under a previous prefix are found by name lookups as long
+// as the prefix is recognized, and move to the current prefix only when
+// their domain is deprovisioned and provisioned again
+func (p *Provisioner) ZoneNames(ctx context.Context) ([]ZoneName, error) {
+	domains := make(map[int64]string)
+	for _, provState := range p.AllStates() {
+		if provState.PullZoneID > 0 {
+			domains[provState.PullZoneID] = provState.Domain
+		}
+	}
+
+	var names []ZoneName
+	err := p.bunnyClient.ForEachPullZone(ctx, func(zone bunny.PullZone) error {
+		prefix, ok := p.names.Match(zone.Name)
+		if !ok {
+			return nil
+		}
+		names = append(names, ZoneName{
+			Name:       zone.Name,
+			PullZoneID: zone.ID,
+			Prefix:     prefix,
+			Current:    prefix == p.names.Prefix(),
+			Domain:     domains[zone.ID],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Current != names[j].Current {
+			return !names[i].Current
+		}
+		return names[i].Name < names[j].Name
+	})
+	return names, nil
+}
@@ -0,0 +1,122 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// Reasons a managed DNS zone is no longer served
+const (
+	// ZoneReasonDisabled is a zone disabled on the Bunny side, e.g. after an
+	// abuse report or a billing problem
+	ZoneReasonDisabled = "disabled"
+	// ZoneReasonMissing is a zone that no longer exists on Bunny
+	ZoneReasonMissing = "missing"
+)
+
+// DisabledZone is a managed DNS zone that Bunny stopped serving
+type DisabledZone struct {
+	Domain string    `json:"domain"`
+	ZoneID int64     `json:"zone_id"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// zoneWatch holds the managed zones found disabled by the last check
+type zoneWatch struct {
+	mu       sync.Mutex
+	disabled map[int64]DisabledZone
+}
+
+// DisabledZones returns the managed DNS zones found disabled by the last
+// check, by domain
+func (p *Provisioner) DisabledZones() []DisabledZone {
+	p.zones.mu.Lock()
+	defer p.zones.mu.Unlock()
+
+	zones := make([]DisabledZone, 0, len(p.zones.disabled))
+	for _, z := range p.zones.disabled {
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Domain < zones[j].Domain })
+	return zones
+}
+
+// CheckZones reads the status of the DNS zone of every provisioned domain
+// and returns the zones that were disabled and the ones that were enabled
+// again since the last check. A zone that cannot be read for another
+// reason than being gone keeps its last known status
+func (p *Provisioner) CheckZones(ctx context.Context) (disabled, restored []DisabledZone) {
+	// Subdomains share their parent's zone, so each zone is read once
+	domains := make(map[int64]string)
+	for _, provState := range p.provisionedStates() {
+		if provState.ZoneID <= 0 || provState.ParentDomain != "" {
+			continue
+		}
+		domains[provState.ZoneID] = provState.Domain
+	}
+
+	p.zones.mu.Lock()
+	defer p.zones.mu.Unlock()
+	if p.zones.disabled == nil {
+		p.zones.disabled = make(map[int64]DisabledZone)
+	}
+
+	for zoneID, domain := range domains {
+		if ctx.Err() != nil {
+			return disabled, restored
+		}
+
+		reason := ""
+		zone, err := p.bunnyClient.GetDNSZoneByID(ctx, zoneID)
+		var apiErr *bunny.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.IsNotFound():
+			reason = ZoneReasonMissing
+		case err != nil:
+			p.logger.Warn("failed to check DNS zone status",
+				zap.String("domain", domain),
+				zap.Int64("zone_id", zoneID),
+				zap.Error(err),
+			)
+			continue
+		case !zone.UserEnabled:
+			reason = ZoneReasonDisabled
+		}
+
+		prev, wasDisabled := p.zones.disabled[zoneID]
+		switch {
+		case reason != "" && !wasDisabled:
+			z := DisabledZone{Domain: domain, ZoneID: zoneID, Reason: reason, Since: time.Now()}
+			p.zones.disabled[zoneID] = z
+			disabled = append(disabled, z)
+			p.logger.Error("managed DNS zone disabled on Bunny",
+				zap.String("domain", domain),
+				zap.Int64("zone_id", zoneID),
+				zap.String("reason", reason),
+			)
+		case reason == "" && wasDisabled:
+			delete(p.zones.disabled, zoneID)
+			restored = append(restored, prev)
+			p.logger.Info("managed DNS zone enabled again",
+				zap.String("domain", domain),
+				zap.Int64("zone_id", zoneID),
+			)
+		}
+	}
+
+	// Forget zones of domains that were deprovisioned meanwhile
+	for zoneID := range p.zones.disabled {
+		if _, ok := domains[zoneID]; !ok {
+			delete(p.zones.disabled, zoneID)
+		}
+	}
+	return disabled, restored
+}
@@ -0,0 +1,265 @@
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// GlobalOwner is the counter key used for the server-wide cap
+const GlobalOwner = "*"
+
+const (
+	// ScopeDaily identifies a daily limit
+	ScopeDaily = "daily"
+	// ScopeMonthly identifies a monthly limit
+	ScopeMonthly = "monthly"
+)
+
+// ExceededError is returned when a provisioning request would exceed a quota
+type ExceededError struct {
+	Owner   string    `json:"owner"`
+	Scope   string    `json:"scope"`
+	Limit   int       `json:"limit"`
+	Used    int       `json:"used"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Error returns the error message
+func (e *ExceededError) Error() string {
+	who := e.Owner
+	if who == GlobalOwner {
+		who = "global"
+	}
+	return fmt.Sprintf("%s %s quota exceeded (%d/%d), resets at %s",
+		who, e.Scope, e.Used, e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+// Usage describes an owner's consumption for the current periods
+type Usage struct {
+	Owner        string `json:"owner"`
+	Daily        int    `json:"daily"`
+	DailyLimit   int    `json:"daily_limit"`
+	Monthly      int    `json:"monthly"`
+	MonthlyLimit int    `json:"monthly_limit"`
+}
+
+// counters maps owner -> period key -> count
+type counters map[string]map[string]int
+
+// Manager enforces and persists provisioning quotas
+type Manager struct {
+	file   *filestore.File[counters]
+	cfg    config.QuotaConfig
+	loc    *time.Location
+	counts counters
+	mu     sync.Mutex
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewManager creates a quota manager persisting counters to filePath
+func NewManager(filePath string, cfg config.QuotaConfig, logger *zap.Logger) (*Manager, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	tz := cfg.Timezone
+	if tz == "" {
+		tz = "Asia/Jakarta"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota timezone %q: %w", tz, err)
+	}
+
+	file, err := filestore.New(filePath, "quota", 0644, func() counters {
+		return make(counters)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		file:   file,
+		cfg:    cfg,
+		loc:    loc,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	if err := m.file.Reload(&m.counts); err != nil {
+		return nil, fmt.Errorf("failed to load quota counters: %w", err)
+	}
+
+	return m, nil
+}
+
+// Enabled returns whether quotas are enforced
+func (m *Manager) Enabled() bool {
+	return m.cfg.Enabled
+}
+
+// periodKeys returns the daily and monthly counter keys for t
+func (m *Manager) periodKeys(t time.Time) (string, string) {
+	t = t.In(m.loc)
+	return t.Format("2006-01-02"), t.Format("2006-01")
+}
+
+// limitsFor returns the limits that apply to an owner
+func (m *Manager) limitsFor(owner string) config.QuotaLimits {
+	if owner == GlobalOwner {
+		return m.cfg.Global
+	}
+	if l, ok := m.cfg.Owners[owner]; ok {
+		return l
+	}
+	return m.cfg.PerUser
+}
+
+// Consume records one provisioning request for owner, or returns an
+// *ExceededError without recording anything if any limit would be exceeded
+func (m *Manager) Consume(owner string) error {
+	if !m.cfg.Enabled {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	day, month := m.periodKeys(now)
+
+	for _, o := range []string{owner, GlobalOwner} {
+		if err := m.check(o, day, month, now); err != nil {
+			m.logger.Warn("Provisioning quota exceeded",
+				zap.String("owner", err.Owner),
+				zap.String("scope", err.Scope),
+				zap.Int("limit", err.Limit),
+			)
+			return err
+		}
+	}
+
+	for _, o := range []string{owner, GlobalOwner} {
+		if m.counts[o] == nil {
+			m.counts[o] = make(map[string]int)
+		}
+		m.counts[o][day]++
+		m.counts[o][month]++
+	}
+
+	m.prune(day, month)
+
+	if err := m.file.Save(m.counts); err != nil {
+		m.logger.Error("Failed to save quota counters", zap.Error(err))
+		return fmt.Errorf("failed to save quota counters: %w", err)
+	}
+
+	return nil
+}
+
+// check returns an *ExceededError if owner is at a limit
+// Caller must hold m.mu
+func (m *Manager) check(owner, day, month string, now time.Time) *ExceededError {
+	limits := m.limitsFor(owner)
+	local := now.In(m.loc)
+
+	if limits.Daily > 0 && m.counts[owner][day] >= limits.Daily {
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, m.loc)
+		return &ExceededError{
+			Owner:   owner,
+			Scope:   ScopeDaily,
+			Limit:   limits.Daily,
+			Used:    m.counts[owner][day],
+			ResetAt: midnight.AddDate(0, 0, 1),
+		}
+	}
+
+	if limits.Monthly > 0 && m.counts[owner][month] >= limits.Monthly {
+		firstOfMonth := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, m.loc)
+		return &ExceededError{
+			Owner:   owner,
+			Scope:   ScopeMonthly,
+			Limit:   limits.Monthly,
+			Used:    m.counts[owner][month],
+			ResetAt: firstOfMonth.AddDate(0, 1, 0),
+		}
+	}
+
+	return nil
+}
+
+// prune drops counters for periods other than the current ones
+// Caller must hold m.mu
+func (m *Manager) prune(day, month string) {
+	for owner, periods := range m.counts {
+		for key := range periods {
+			if key != day && key != month {
+				delete(periods, key)
+			}
+		}
+		if len(periods) == 0 {
+			delete(m.counts, owner)
+		}
+	}
+}
+
+// Usage returns current-period usage for every owner with activity,
+// sorted by monthly usage (descending); the global counter is excluded
+func (m *Manager) Usage() []Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	day, month := m.periodKeys(m.now())
+
+	result := make([]Usage, 0, len(m.counts))
+	for owner, periods := range m.counts {
+		if owner == GlobalOwner {
+			continue
+		}
+		if periods[day] == 0 && periods[month] == 0 {
+			continue
+		}
+		limits := m.limitsFor(owner)
+		result = append(result, Usage{
+			Owner:        owner,
+			Daily:        periods[day],
+			DailyLimit:   limits.Daily,
+			Monthly:      periods[month],
+			MonthlyLimit: limits.Monthly,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Monthly != result[j].Monthly {
+			return result[i].Monthly > result[j].Monthly
+		}
+		return result[i].Owner < result[j].Owner
+	})
+
+	return result
+}
+
+// GlobalUsage returns current-period usage for the global counter
+func (m *Manager) GlobalUsage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	day, month := m.periodKeys(m.now())
+	periods := m.counts[GlobalOwner]
+
+	return Usage{
+		Owner:        GlobalOwner,
+		Daily:        periods[day],
+		DailyLimit:   m.cfg.Global.Daily,
+		Monthly:      periods[month],
+		MonthlyLimit: m.cfg.Global.Monthly,
+	}
+}
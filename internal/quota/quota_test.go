@@ -0,0 +1,112 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+func newTestManager(t *testing.T, cfg config.QuotaConfig) *Manager {
+	t.Helper()
+	cfg.Enabled = true
+	cfg.Timezone = "UTC"
+	m, err := NewManager(filepath.Join(t.TempDir(), "quota.json"), cfg, zap.NewNop())
+	require.NoError(t, err)
+	m.now = func() time.Time { return time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC) }
+	return m
+}
+
+func TestManager_Disabled(t *testing.T) {
+	m, err := NewManager(filepath.Join(t.TempDir(), "quota.json"), config.QuotaConfig{
+		PerUser: config.QuotaLimits{Daily: 1},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, m.Consume("alice"))
+	}
+	assert.Empty(t, m.Usage())
+}
+
+func TestManager_DailyLimit(t *testing.T) {
+	m := newTestManager(t, config.QuotaConfig{PerUser: config.QuotaLimits{Daily: 2}})
+
+	require.NoError(t, m.Consume("alice"))
+	require.NoError(t, m.Consume("alice"))
+
+	err := m.Consume("alice")
+	var exceeded *ExceededError
+	require.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, "alice", exceeded.Owner)
+	assert.Equal(t, ScopeDaily, exceeded.Scope)
+	assert.Equal(t, 2, exceeded.Used)
+	assert.Equal(t, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), exceeded.ResetAt)
+
+	// Other owners are unaffected
+	assert.NoError(t, m.Consume("bob"))
+
+	// Next day the counter resets
+	m.now = func() time.Time { return time.Date(2026, 10, 16, 0, 1, 0, 0, time.UTC) }
+	assert.NoError(t, m.Consume("alice"))
+}
+
+func TestManager_MonthlyAndOwnerOverride(t *testing.T) {
+	m := newTestManager(t, config.QuotaConfig{
+		PerUser: config.QuotaLimits{Monthly: 1},
+		Owners:  map[string]config.QuotaLimits{"reseller": {Monthly: 3}},
+	})
+
+	require.NoError(t, m.Consume("alice"))
+	err := m.Consume("alice")
+	var exceeded *ExceededError
+	require.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, ScopeMonthly, exceeded.Scope)
+	assert.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), exceeded.ResetAt)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, m.Consume("reseller"))
+	}
+	assert.Error(t, m.Consume("reseller"))
+}
+
+func TestManager_GlobalLimit(t *testing.T) {
+	m := newTestManager(t, config.QuotaConfig{Global: config.QuotaLimits{Daily: 2}})
+
+	require.NoError(t, m.Consume("alice"))
+	require.NoError(t, m.Consume("bob"))
+
+	err := m.Consume("carol")
+	var exceeded *ExceededError
+	require.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, GlobalOwner, exceeded.Owner)
+	assert.Contains(t, exceeded.Error(), "global daily quota exceeded")
+
+	// A rejected request is not counted
+	assert.Equal(t, 2, m.GlobalUsage().Daily)
+}
+
+func TestManager_PersistsCounters(t *testing.T) {
+	m := newTestManager(t, config.QuotaConfig{PerUser: config.QuotaLimits{Daily: 5}})
+	require.NoError(t, m.Consume("alice"))
+	require.NoError(t, m.Consume("alice"))
+	require.NoError(t, m.Consume("bob"))
+
+	reloaded, err := NewManager(m.file.Path(), m.cfg, zap.NewNop())
+	require.NoError(t, err)
+	reloaded.now = m.now
+
+	usage := reloaded.Usage()
+	require.Len(t, usage, 2)
+	assert.Equal(t, "alice", usage[0].Owner)
+	assert.Equal(t, 2, usage[0].Daily)
+	assert.Equal(t, 5, usage[0].DailyLimit)
+	assert.Equal(t, 2, usage[0].Monthly)
+	assert.Equal(t, 3, reloaded.GlobalUsage().Monthly)
+}
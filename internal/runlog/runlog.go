@@ -0,0 +1,265 @@
+// Package runlog keeps the log entries of the recent provisioning runs of
+// each domain in memory, so support can read what happened through the API
+// without access to the server's logs
+package runlog
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+)
+
+// trackingField is the log field carrying the tracking ID of a run
+const trackingField = "tracking_id"
+
+// domainFields are the log fields naming the domain an entry is about; the
+// provisioner names subdomains by their full name
+var domainFields = []string{"domain", "subdomain", "full_domain"}
+
+// Entry is one log entry of a run
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// Run is the log of one provisioning run of a domain, oldest entry first.
+// Dropped counts the oldest entries overwritten once the run logged more
+// than the store keeps
+type Run struct {
+	ID         string     `json:"id"`
+	Domain     string     `json:"domain"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Entries    []Entry    `json:"entries"`
+	Dropped    int        `json:"dropped,omitempty"`
+}
+
+// run is a Run whose entries are a ring buffer starting at head
+type run struct {
+	Run
+	head int
+}
+
+// add appends an entry, overwriting the oldest once max are kept
+func (r *run) add(e Entry, max int) {
+	if len(r.Entries) < max {
+		r.Entries = append(r.Entries, e)
+		return
+	}
+	r.Entries[r.head] = e
+	r.head = (r.head + 1) % max
+	r.Dropped++
+}
+
+// snapshot returns a copy of the run with its entries in order
+func (r *run) snapshot() Run {
+	out := r.Run
+	out.Entries = append(slices.Clone(r.Entries[r.head:]), r.Entries[:r.head]...)
+	if r.FinishedAt != nil {
+		finished := *r.FinishedAt
+		out.FinishedAt = &finished
+	}
+	return out
+}
+
+// Store keeps the last runs of each domain. Its Core records the entries
+// of a logger while a run is in progress: those carrying the run's tracking
+// ID, and those naming its domain
+type Store struct {
+	runs    int
+	entries int
+	clock   clock.Clock
+
+	mu sync.Mutex
+	// domains holds the kept runs of each domain, oldest first
+	domains map[string][]*run
+	// active holds the runs in progress by tracking ID and by domain
+	byID     map[string]*run
+	byDomain map[string][]*run
+	// recording counts the runs in progress, so entries logged while there
+	// are none are dropped without taking the lock
+	recording atomic.Int32
+}
+
+// NewStore creates a store keeping the last runs of each domain, with up
+// to entries log entries each
+func NewStore(runs, entries int) *Store {
+	return &Store{
+		runs:     max(runs, 1),
+		entries:  max(entries, 1),
+		clock:    clock.Real{},
+		domains:  make(map[string][]*run),
+		byID:     make(map[string]*run),
+		byDomain: make(map[string][]*run),
+	}
+}
+
+// SetClock sets the clock runs are timed with
+func (s *Store) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Begin starts recording the run id of domain, dropping the oldest run
+// kept for it, and returns the function ending the run
+func (s *Store) Begin(domain, id string) (end func()) {
+	domain = domainname.Normalize(domain)
+	r := &run{Run: Run{ID: id, Domain: domain, StartedAt: s.clock.Now(), Entries: []Entry{}}}
+
+	s.mu.Lock()
+	runs := append(s.domains[domain], r)
+	if len(runs) > s.runs {
+		runs = slices.Delete(runs, 0, len(runs)-s.runs)
+	}
+	s.domains[domain] = runs
+	s.byID[id] = r
+	s.byDomain[domain] = append(s.byDomain[domain], r)
+	s.recording.Add(1)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.end(r) })
+	}
+}
+
+// end stops recording r
+func (s *Store) end(r *run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	finished := s.clock.Now()
+	r.FinishedAt = &finished
+	delete(s.byID, r.ID)
+	active := slices.DeleteFunc(s.byDomain[r.Domain], func(a *run) bool { return a == r })
+	if len(active) == 0 {
+		delete(s.byDomain, r.Domain)
+	} else {
+		s.byDomain[r.Domain] = active
+	}
+	s.recording.Add(-1)
+}
+
+// Runs returns the kept runs of domain, newest first
+func (s *Store) Runs(domain string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.domains[domainname.Normalize(domain)]
+	runs := make([]Run, 0, len(kept))
+	for i := len(kept) - 1; i >= 0; i-- {
+		runs = append(runs, kept[i].snapshot())
+	}
+	return runs
+}
+
+// Latest returns the last run of domain; false when none is kept
+func (s *Store) Latest(domain string) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.domains[domainname.Normalize(domain)]
+	if len(kept) == 0 {
+		return Run{}, false
+	}
+	return kept[len(kept)-1].snapshot(), true
+}
+
+// Get returns the run id of domain; false when it is not kept
+func (s *Store) Get(domain, id string) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.domains[domainname.Normalize(domain)] {
+		if r.ID == id {
+			return r.snapshot(), true
+		}
+	}
+	return Run{}, false
+}
+
+// record adds an entry to the runs in progress it belongs to: the run of
+// its tracking ID, or else those of the domain it names
+func (s *Store) record(ent zapcore.Entry, fields []zapcore.Field) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	entry := Entry{
+		Time:    ent.Time,
+		Level:   ent.Level.String(),
+		Message: ent.Message,
+		Fields:  enc.Fields,
+	}
+	if len(entry.Fields) == 0 {
+		entry.Fields = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := enc.Fields[trackingField].(string); ok {
+		if r, ok := s.byID[id]; ok {
+			r.add(entry, s.entries)
+			return
+		}
+	}
+	for _, key := range domainFields {
+		if domain, ok := enc.Fields[key].(string); ok {
+			if runs := s.byDomain[domainname.Normalize(domain)]; len(runs) > 0 {
+				for _, r := range runs {
+					r.add(entry, s.entries)
+				}
+				return
+			}
+		}
+	}
+}
+
+// Core returns a logger core recording the entries at level or above for
+// the runs in progress, to be teed with the core writing the log
+func (s *Store) Core(level zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: level, store: s}
+}
+
+// core is the zapcore.Core of a Store
+type core struct {
+	zapcore.LevelEnabler
+	store  *Store
+	fields []zapcore.Field
+}
+
+// With returns a core adding fields to every entry
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{
+		LevelEnabler: c.LevelEnabler,
+		store:        c.store,
+		fields:       append(slices.Clip(c.fields), fields...),
+	}
+}
+
+// Check adds the core to entries it records, while a run is in progress
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) && c.store.recording.Load() > 0 {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write records an entry
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.store.record(ent, append(slices.Clip(c.fields), fields...))
+	return nil
+}
+
+// Sync does nothing, as entries are kept in memory
+func (c *core) Sync() error {
+	return nil
+}
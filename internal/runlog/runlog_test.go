@@ -0,0 +1,86 @@
+package runlog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+)
+
+// newLogger returns a logger writing only to the run logs of store
+func newLogger(store *Store) *zap.Logger {
+	return zap.New(store.Core(zapcore.InfoLevel))
+}
+
+func TestStore_RecordsRunEntries(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	store := NewStore(5, 100)
+	store.SetClock(clock.NewFake(start))
+	logger := newLogger(store)
+
+	logger.Info("before the run", zap.String("domain", "example.com"))
+
+	end := store.Begin("Example.com", "run-1")
+	logger.Info("provisioning domain", zap.String("tracking_id", "run-1"), zap.String("user", "exampleu"))
+	logger.With(zap.String("domain", "example.com")).Warn("retrying", zap.Error(errors.New("rate limited")))
+	logger.Info("subdomain", zap.String("subdomain", "blog.example.com"))
+	logger.Info("other domain", zap.String("domain", "other.com"))
+	logger.Debug("below the level", zap.String("domain", "example.com"))
+	end()
+	end()
+
+	logger.Info("after the run", zap.String("domain", "example.com"))
+
+	run, ok := store.Latest("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "run-1", run.ID)
+	assert.Equal(t, "example.com", run.Domain)
+	assert.Equal(t, start, run.StartedAt)
+	require.NotNil(t, run.FinishedAt)
+
+	require.Len(t, run.Entries, 2)
+	assert.Equal(t, "provisioning domain", run.Entries[0].Message)
+	assert.Equal(t, "exampleu", run.Entries[0].Fields["user"])
+	assert.Equal(t, "warn", run.Entries[1].Level)
+	assert.Equal(t, "rate limited", run.Entries[1].Fields["error"])
+
+	_, ok = store.Latest("other.com")
+	assert.False(t, ok, "runs are only recorded for the domains they were begun for")
+}
+
+func TestStore_KeepsLastRuns(t *testing.T) {
+	store := NewStore(2, 3)
+	logger := newLogger(store)
+
+	for _, id := range []string{"run-1", "run-2", "run-3"} {
+		end := store.Begin("example.com", id)
+		for i := range 5 {
+			logger.Info("step", zap.String("tracking_id", id), zap.Int("n", i))
+		}
+		end()
+	}
+
+	runs := store.Runs("example.com")
+	require.Len(t, runs, 2)
+	assert.Equal(t, "run-3", runs[0].ID)
+	assert.Equal(t, "run-2", runs[1].ID)
+
+	// The oldest entries of a run are overwritten
+	entries := runs[0].Entries
+	require.Len(t, entries, 3)
+	assert.Equal(t, 2, runs[0].Dropped)
+	assert.EqualValues(t, 2, entries[0].Fields["n"])
+	assert.EqualValues(t, 4, entries[2].Fields["n"])
+
+	_, ok := store.Get("example.com", "run-1")
+	assert.False(t, ok)
+	run, ok := store.Get("example.com", "run-2")
+	require.True(t, ok)
+	assert.Equal(t, "run-2", run.ID)
+}
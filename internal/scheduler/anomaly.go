@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+const (
+	// anomalyBaselineDays is how many days before the checked hour its
+	// baseline reaches back; snapshots.raw_days usually keeps fewer
+	anomalyBaselineDays = 28
+
+	// madScale turns a median absolute deviation into an estimate of the
+	// standard deviation of normally distributed values
+	madScale = 1.4826
+
+	// minSpread is the smallest spread, as a share of the median, an hour
+	// is measured against; a zone with very steady traffic would otherwise
+	// alert on small changes
+	minSpread = 0.1
+)
+
+// anomaly is an hour's requests compared with the same hour of earlier days
+type anomaly struct {
+	Median float64
+	// Change is the hour's change against the median and Threshold the
+	// change that counts as anomalous, both in percent
+	Change    float64
+	Threshold float64
+	Anomalous bool
+}
+
+// detectAnomaly compares current with the baseline, using the median and
+// the median absolute deviation so that an earlier anomaly in the baseline
+// does not hide the next one. Both spikes and drops are anomalous; a drop
+// to zero usually means the site is broken
+func detectAnomaly(baseline []int64, current int64, k float64) anomaly {
+	values := make([]float64, len(baseline))
+	for i, v := range baseline {
+		values[i] = float64(v)
+	}
+	m := median(values)
+	if m <= 0 {
+		return anomaly{}
+	}
+
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - m)
+	}
+	spread := max(madScale*median(deviations), minSpread*m, 1)
+
+	deviation := float64(current) - m
+	return anomaly{
+		Median:    m,
+		Change:    deviation / m * 100,
+		Threshold: k * spread / m * 100,
+		Anomalous: math.Abs(deviation) >= k*spread,
+	}
+}
+
+// median returns the median of values, which it sorts
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// hourStart returns the start of the hour of t in loc
+func hourStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+}
+
+// checkAnomaly opens a traffic_anomaly incident when a zone's requests in
+// the last settled hour are far from the same hour of the days before, and
+// resolves it once an hour is back to normal. Hours are kept as snapshots;
+// a zone without enough of them is backfilled from Bunny's hourly stats
+func (s *Scheduler) checkAnomaly(ctx context.Context, zone bunny.PullZone, loc *time.Location) {
+	cfg := s.config.Incidents.Anomaly
+	if !cfg.Enabled || s.snapshotStore == nil {
+		return
+	}
+
+	now := s.now()
+	hour := hourStart(now.Add(-statsSettleDelay-time.Hour), loc)
+	hours := s.storedHours(zone.ID, hour)
+
+	current, ok := hours[hour.Unix()]
+	baseline := sameHour(hours, hour, loc)
+	if !ok || len(baseline) < cfg.MinSamples {
+		from := hour
+		if len(baseline) < cfg.MinSamples {
+			from = hour.AddDate(0, 0, -s.anomalyBackfillDays())
+		}
+		if err := s.fetchHours(ctx, zone, from, hour, loc, hours); err != nil {
+			s.logger.Warn("Failed to get hourly stats for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("zone_name", zone.Name),
+				zap.Error(err))
+			return
+		}
+		current, ok = hours[hour.Unix()]
+		baseline = sameHour(hours, hour, loc)
+	}
+	if !ok || len(baseline) < cfg.MinSamples {
+		return
+	}
+
+	a := detectAnomaly(baseline, current, cfg.Threshold)
+	if a.Median < float64(cfg.MinRequests) {
+		return
+	}
+	if !a.Anomalous {
+		s.resolveIncident(ctx, incident.KindTrafficAnomaly, zone, a.Change)
+		return
+	}
+
+	s.logger.Warn("Traffic anomaly detected",
+		zap.Int64("zone_id", zone.ID),
+		zap.String("zone_name", zone.Name),
+		zap.Time("hour", hour),
+		zap.Int64("requests", current),
+		zap.Float64("usual", a.Median),
+		zap.Float64("change", a.Change))
+
+	id, notify := s.fireIncident(incident.KindTrafficAnomaly, zone, a.Change, a.Threshold)
+	if !notify {
+		return
+	}
+	s.sendIncident(ctx, s.formatIncident(id, incident.KindTrafficAnomaly, zone.Name, a.Change, a.Threshold), id)
+}
+
+// anomalyBackfillDays is how many days of hourly stats are fetched for a
+// zone without a baseline: as many as snapshots.raw_days keeps
+func (s *Scheduler) anomalyBackfillDays() int {
+	days := s.config.Snapshots.RawDays
+	if days <= 0 {
+		days = 7
+	}
+	return min(days, anomalyBaselineDays)
+}
+
+// storedHours returns the requests of the zone's hourly snapshots of the
+// anomalyBaselineDays up to hour, keyed by the Unix time each hour starts
+func (s *Scheduler) storedHours(zoneID int64, hour time.Time) map[int64]int64 {
+	since := hour.AddDate(0, 0, -anomalyBaselineDays)
+	hours := make(map[int64]int64)
+	for _, snap := range s.snapshotStore.GetSnapshotsByZone(zoneID, time.Time{}) {
+		if snap.Hourly() && !snap.From.Before(since) && !snap.From.After(hour) {
+			hours[snap.From.Unix()] = snap.Requests
+		}
+	}
+	return hours
+}
+
+// fetchHours adds the settled hours of Bunny's hourly stats for the days
+// from..to to hours, keeping them as snapshots by the next saveSnapshots
+func (s *Scheduler) fetchHours(ctx context.Context, zone bunny.PullZone, from, to time.Time, loc *time.Location, hours map[int64]int64) error {
+	stats, err := bunny.GetPullZoneStatsHourly(ctx, s.bunnyClient, zone.ID, from, to)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	var snapshots []state.BandwidthSnapshot
+	for _, st := range stats {
+		start := hourStart(st.Timestamp, loc)
+		end := start.Add(time.Hour - time.Second)
+		if now.Sub(end) < statsSettleDelay {
+			continue
+		}
+		hours[start.Unix()] = st.TotalRequests
+		snapshots = append(snapshots, state.BandwidthSnapshot{
+			Timestamp:   now,
+			From:        start,
+			To:          end,
+			ZoneID:      zone.ID,
+			ZoneName:    zone.Name,
+			Bandwidth:   st.TotalBandwidth,
+			Requests:    st.TotalRequests,
+			CacheHits:   st.CacheHits,
+			CacheMisses: st.CacheMisses,
+		})
+	}
+
+	s.cache.mu.Lock()
+	s.cache.snapshots = append(s.cache.snapshots, snapshots...)
+	s.cache.mu.Unlock()
+	return nil
+}
+
+// sameHour returns the requests of the hours before hour that start at the
+// same time of day
+func sameHour(hours map[int64]int64, hour time.Time, loc *time.Location) []int64 {
+	var baseline []int64
+	for start, requests := range hours {
+		t := time.Unix(start, 0).In(loc)
+		if start < hour.Unix() && t.Hour() == hour.Hour() && t.Minute() == hour.Minute() {
+			baseline = append(baseline, requests)
+		}
+	}
+	return baseline
+}
@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+func TestDetectAnomaly(t *testing.T) {
+	baseline := []int64{1000, 1100, 950, 1050, 990, 1020, 980}
+	tests := []struct {
+		name      string
+		baseline  []int64
+		current   int64
+		anomalous bool
+	}{
+		{"usual", baseline, 1030, false},
+		{"spike", baseline, 5000, true},
+		{"drop to zero", baseline, 0, true},
+		{"earlier spike in baseline", []int64{1000, 1100, 950, 1050, 990, 20000, 980}, 1080, false},
+		{"no traffic", []int64{0, 0, 0, 0, 0}, 50, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := detectAnomaly(tt.baseline, tt.current, 4)
+			if a.Anomalous != tt.anomalous {
+				t.Errorf("Expected anomalous=%t, got %+v", tt.anomalous, a)
+			}
+		})
+	}
+
+	a := detectAnomaly(baseline, 0, 4)
+	if a.Median != 1000 || a.Change != -100 {
+		t.Errorf("Expected median 1000 and change -100%%, got %+v", a)
+	}
+}
+
+func TestCheckAnomaly(t *testing.T) {
+	// The fake Bunny API serves 1,000 requests an hour, except for the
+	// hour the check looks at
+	var current atomic.Int64
+	current.Store(1000)
+	var fetches atomic.Int32
+	checked := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/stats") || r.URL.Query().Get("Hourly") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		from, _ := time.Parse("2006-01-02", r.URL.Query().Get("DateStart"))
+		to, _ := time.Parse("2006-01-02", r.URL.Query().Get("DateEnd"))
+		var stats []bunny.TimestampedStats
+		for hour := from; hour.Before(to.AddDate(0, 0, 1)); hour = hour.Add(time.Hour) {
+			requests := int64(1000)
+			if hour.Equal(checked) {
+				requests = current.Load()
+			}
+			stats = append(stats, bunny.TimestampedStats{Timestamp: hour, TotalRequests: requests})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := zap.NewNop()
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(server.URL), bunny.WithLogger(logger))
+	snapshots, err := state.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), logger)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	store, err := incident.NewStore(filepath.Join(t.TempDir(), "incidents.json"), logger)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	cfg := &config.Config{Incidents: config.IncidentsConfig{Anomaly: config.AnomalyConfig{
+		Enabled: true, Threshold: 4, MinSamples: 5, MinRequests: 100,
+	}}}
+	s := NewScheduler(cfg, client, nil, snapshots, logger)
+	fake := clock.NewFake(checked.Add(2*time.Hour + 5*time.Minute))
+	s.SetClock(fake)
+	s.SetIncidents(store)
+	zone := bunny.PullZone{ID: 1, Name: "example"}
+
+	// A zone without snapshots is backfilled, and a spike opens an incident
+	current.Store(8000)
+	s.checkAnomaly(context.Background(), zone, time.UTC)
+	s.saveSnapshots()
+	active := store.Active()
+	if len(active) != 1 || active[0].Kind != incident.KindTrafficAnomaly {
+		t.Fatalf("Expected a traffic anomaly incident, got %+v", active)
+	}
+	if active[0].Value != 700 {
+		t.Errorf("Expected a change of 700%%, got %v", active[0].Value)
+	}
+
+	// The backfilled hours are kept, so the next hour is checked from
+	// snapshots with one fetch for the hour itself
+	before := fetches.Load()
+	current.Store(1000)
+	checked = checked.Add(time.Hour)
+	fake.Advance(time.Hour)
+	s.checkAnomaly(context.Background(), zone, time.UTC)
+	s.saveSnapshots()
+	if got := fetches.Load() - before; got != 1 {
+		t.Errorf("Expected 1 fetch with a stored baseline, got %d", got)
+	}
+	if active := store.Active(); len(active) != 0 {
+		t.Errorf("Expected the incident resolved at a usual hour, got %+v", active)
+	}
+
+	// A quiet zone is not checked
+	cfg.Incidents.Anomaly.MinRequests = 5000
+	current.Store(0)
+	checked = checked.Add(time.Hour)
+	fake.Advance(time.Hour)
+	s.checkAnomaly(context.Background(), zone, time.UTC)
+	if active := store.Active(); len(active) != 0 {
+		t.Errorf("Expected no incident below min_requests, got %+v", active)
+	}
+}
@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+const (
+	// statsCacheTTL is how long the zone list and zone stats fetched by one
+	// job are reused by the next
+	statsCacheTTL = 10 * time.Minute
+	// statsSettleDelay is how long after a period ends its stats are taken
+	// as final and kept as a snapshot, since Bunny reports traffic late
+	statsSettleDelay = time.Hour
+)
+
+// statsKey identifies the stats of a zone for a period
+type statsKey struct {
+	zoneID   int64
+	from, to int64
+}
+
+// cachedStats is zone stats with the time they were fetched
+type cachedStats struct {
+	stats     *bunny.PullZoneStats
+	fetchedAt time.Time
+}
+
+// statsCache shares the zone list and zone stats between the scheduler's
+// jobs, and gathers the snapshots of settled periods to be saved
+type statsCache struct {
+	mu        sync.Mutex
+	zones     []bunny.PullZone
+	zonesAt   time.Time
+	stats     map[statsKey]cachedStats
+	snapshots []state.BandwidthSnapshot
+	now       func() time.Time
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{
+		stats: make(map[statsKey]cachedStats),
+		now:   time.Now,
+	}
+}
+
+// listZones returns the pull zones, reusing a list fetched within
+// statsCacheTTL. The zones are streamed a page at a time and only their ID
+// and name are kept, which is all the jobs use, so the settings of every
+// zone of a large account are not held in memory
+func (s *Scheduler) listZones(ctx context.Context) ([]bunny.PullZone, error) {
+	c := s.cache
+	c.mu.Lock()
+	if c.zones != nil && c.now().Sub(c.zonesAt) < statsCacheTTL {
+		zones := c.zones
+		c.mu.Unlock()
+		return zones, nil
+	}
+	c.mu.Unlock()
+
+	zones := []bunny.PullZone{}
+	err := s.bunnyClient.ForEachPullZone(ctx, func(zone bunny.PullZone) error {
+		zones = append(zones, bunny.PullZone{ID: zone.ID, Name: zone.Name})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.zones, c.zonesAt = zones, c.now()
+	c.mu.Unlock()
+	return zones, nil
+}
+
+// zoneStats returns the stats of a zone for [from, to]. Stats fetched within
+// statsCacheTTL are reused, then the snapshot of a settled period, and only
+// then is the Bunny API called; the stats of a settled period are kept as a
+// snapshot by the next saveSnapshots
+func (s *Scheduler) zoneStats(ctx context.Context, limiter *rateLimiter, zone bunny.PullZone, from, to time.Time) (*bunny.PullZoneStats, error) {
+	c := s.cache
+	key := statsKey{zoneID: zone.ID, from: from.Unix(), to: to.Unix()}
+
+	c.mu.Lock()
+	now := c.now()
+	cached, ok := c.stats[key]
+	c.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < statsCacheTTL {
+		return cached.stats, nil
+	}
+
+	if s.snapshotStore != nil {
+		if snap := s.snapshotStore.GetSnapshotForPeriod(zone.ID, from, to); snap != nil {
+			return snapshotStats(snap), nil
+		}
+	}
+
+	if err := limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.stats {
+		if now.Sub(v.fetchedAt) >= statsCacheTTL {
+			delete(c.stats, k)
+		}
+	}
+	c.stats[key] = cachedStats{stats: stats, fetchedAt: now}
+	if now.Sub(to) >= statsSettleDelay {
+		c.snapshots = append(c.snapshots, state.BandwidthSnapshot{
+			Timestamp:   now,
+			From:        from,
+			To:          to,
+			ZoneID:      zone.ID,
+			ZoneName:    zone.Name,
+			Bandwidth:   stats.TotalBandwidth,
+			Requests:    stats.TotalRequests,
+			CacheHits:   stats.TotalCacheHits,
+			CacheMisses: stats.TotalCacheMisses,
+		})
+	}
+	return stats, nil
+}
+
+// saveSnapshots writes the snapshots gathered by zoneStats in one go
+func (s *Scheduler) saveSnapshots() {
+	c := s.cache
+	c.mu.Lock()
+	snapshots := c.snapshots
+	c.snapshots = nil
+	c.mu.Unlock()
+
+	if s.snapshotStore == nil || len(snapshots) == 0 {
+		return
+	}
+	if err := s.snapshotStore.AddSnapshots(snapshots); err != nil {
+		s.logger.Warn("Failed to save bandwidth snapshots", zap.Int("count", len(snapshots)), zap.Error(err))
+	}
+}
+
+// snapshotStats converts a snapshot back to zone stats
+func snapshotStats(snap *state.BandwidthSnapshot) *bunny.PullZoneStats {
+	stats := &bunny.PullZoneStats{
+		PullZoneID:       snap.ZoneID,
+		PullZoneName:     snap.ZoneName,
+		TotalRequests:    snap.Requests,
+		TotalBandwidth:   snap.Bandwidth,
+		TotalCacheHits:   snap.CacheHits,
+		TotalCacheMisses: snap.CacheMisses,
+		StartDate:        snap.From,
+		EndDate:          snap.To,
+		Status:           "completed",
+		Timestamp:        snap.Timestamp,
+	}
+	if total := snap.CacheHits + snap.CacheMisses; total > 0 {
+		stats.CacheHitRate = float64(snap.CacheHits) / float64(total) * 100
+	}
+	return stats
+}
@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// newCachingScheduler returns a scheduler talking to a fake Bunny API that
+// counts the stats requests it serves
+func newCachingScheduler(t *testing.T) (*Scheduler, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+
+	var listCalls, statsCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/pullzone":
+			listCalls.Add(1)
+			fmt.Fprint(w, `{"Items":[{"Id":1,"Name":"example"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			statsCalls.Add(1)
+			fmt.Fprint(w, `{"TotalBandwidth":1000,"TotalRequests":10,"CacheHits":8,"CacheMisses":2}`)
+		default:
+			fmt.Fprint(w, `{"Id":1,"Name":"example"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	logger := zap.NewNop()
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(server.URL), bunny.WithLogger(logger))
+	store, err := state.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), logger)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	return NewScheduler(&config.Config{}, client, nil, store, logger), &listCalls, &statsCalls
+}
+
+func TestListZones_Cached(t *testing.T) {
+	s, listCalls, _ := newCachingScheduler(t)
+	ctx := context.Background()
+
+	for range 2 {
+		zones, err := s.listZones(ctx)
+		if err != nil || len(zones) != 1 {
+			t.Fatalf("listZones = %v, %v", zones, err)
+		}
+	}
+	if got := listCalls.Load(); got != 1 {
+		t.Errorf("ListPullZones calls = %d, want 1", got)
+	}
+
+	s.cache.now = func() time.Time { return time.Now().Add(statsCacheTTL) }
+	if _, err := s.listZones(ctx); err != nil {
+		t.Fatalf("listZones: %v", err)
+	}
+	if got := listCalls.Load(); got != 2 {
+		t.Errorf("ListPullZones calls after TTL = %d, want 2", got)
+	}
+}
+
+func TestListZones_Paginated(t *testing.T) {
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		if page == "1" {
+			fmt.Fprint(w, `{"Items":[{"Id":1,"Name":"one","OriginUrl":"http://192.0.2.1"}],"HasMoreItems":true}`)
+			return
+		}
+		fmt.Fprint(w, `{"Items":[{"Id":2,"Name":"two"}],"HasMoreItems":false}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(server.URL))
+	s := NewScheduler(&config.Config{}, client, nil, nil, zap.NewNop())
+
+	zones, err := s.listZones(context.Background())
+	if err != nil {
+		t.Fatalf("listZones: %v", err)
+	}
+	want := []bunny.PullZone{{ID: 1, Name: "one"}, {ID: 2, Name: "two"}}
+	if len(zones) != len(want) || zones[0].ID != want[0].ID || zones[1].Name != want[1].Name {
+		t.Errorf("zones = %+v, want %+v", zones, want)
+	}
+	if zones[0].OriginURL != "" {
+		t.Errorf("zone settings kept: %+v", zones[0])
+	}
+	if strings.Join(pages, ",") != "1,2" {
+		t.Errorf("pages requested = %v, want 1,2", pages)
+	}
+}
+
+func TestZoneStats_CacheAndSnapshots(t *testing.T) {
+	s, _, statsCalls := newCachingScheduler(t)
+	ctx := context.Background()
+	zone := bunny.PullZone{ID: 1, Name: "example"}
+
+	from := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	to := from.Add(24*time.Hour - time.Second)
+
+	for range 2 {
+		stats, err := s.zoneStats(ctx, nil, zone, from, to)
+		if err != nil || stats.TotalBandwidth != 1000 {
+			t.Fatalf("zoneStats = %+v, %v", stats, err)
+		}
+	}
+	if got := statsCalls.Load(); got != 1 {
+		t.Errorf("stats calls = %d, want 1", got)
+	}
+
+	// A settled period outlives the cache as a snapshot
+	s.saveSnapshots()
+	s.cache = newStatsCache()
+	stats, err := s.zoneStats(ctx, nil, zone, from, to)
+	if err != nil || stats.TotalBandwidth != 1000 || stats.CacheHitRate != 80 {
+		t.Fatalf("zoneStats from snapshot = %+v, %v", stats, err)
+	}
+	if got := statsCalls.Load(); got != 1 {
+		t.Errorf("stats calls after snapshot = %d, want 1", got)
+	}
+
+	// Today has not settled, so it is only cached in memory
+	today := time.Now().Truncate(time.Second)
+	if _, err := s.zoneStats(ctx, nil, zone, today, today.Add(time.Hour)); err != nil {
+		t.Fatalf("zoneStats: %v", err)
+	}
+	s.saveSnapshots()
+	if snap := s.snapshotStore.GetSnapshotForPeriod(1, today, today.Add(time.Hour)); snap != nil {
+		t.Errorf("unsettled period was kept as a snapshot: %+v", snap)
+	}
+}
@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+// rateLimiter spaces calls evenly at a maximum rate; a nil limiter does not
+// limit
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter returns a limiter allowing perSecond calls a second, or nil
+// when perSecond is not positive
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+// wait blocks until the next call is allowed or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop releases the limiter
+func (l *rateLimiter) stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
+
+// forEachZone calls fetch for every zone on a pool of at most workers
+// goroutines, passing each the zone's index so results can be written to a
+// slot of their own. Zones not yet started when ctx is done are skipped;
+// it returns how many zones were fetched
+func forEachZone[Z any](ctx context.Context, zones []Z, workers int, fetch func(ctx context.Context, i int, zone Z)) int {
+	if workers <= 0 {
+		workers = 1
+	}
+	workers = min(workers, len(zones))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	fetched := 0
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fetch(ctx, i, zones[i])
+				mu.Lock()
+				fetched++
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i := range zones {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return fetched
+}
+
+// summaryLimits returns the concurrency, request rate and timeout of the
+// summary jobs, falling back to the defaults
+func (s *Scheduler) summaryLimits() (int, float64, time.Duration) {
+	cfg := s.config.Telegram.Summary
+	workers := cfg.Concurrency
+	if workers <= 0 {
+		workers = config.DefaultSummaryConcurrency
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultSummaryTimeout
+	}
+	return workers, cfg.RequestsPerSecond, timeout
+}
+
+// collectZones runs fetch for every pull zone within the configured
+// concurrency and request rate, returning an error when the job's deadline
+// passed before every zone was fetched
+func (s *Scheduler) collectZones(ctx context.Context, job string, zones []bunny.PullZone, fetch func(ctx context.Context, limiter *rateLimiter, i int, zone bunny.PullZone)) error {
+	return collectEach(ctx, s, job, zones, fetch)
+}
+
+// collectEach is collectZones for zones of any kind, such as DNS zones
+func collectEach[Z any](ctx context.Context, s *Scheduler, job string, zones []Z, fetch func(ctx context.Context, limiter *rateLimiter, i int, zone Z)) error {
+	workers, perSecond, _ := s.summaryLimits()
+	limiter := newRateLimiter(perSecond)
+	defer limiter.stop()
+
+	start := s.now()
+	fetched := forEachZone(ctx, zones, workers, func(ctx context.Context, i int, zone Z) {
+		fetch(ctx, limiter, i, zone)
+	})
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s stopped after %d of %d zones: %w", job, fetched, len(zones), err)
+	}
+
+	s.logger.Debug("Collected zone stats",
+		zap.String("job", job),
+		zap.Int("zones", len(zones)),
+		zap.Int("workers", workers),
+		zap.Duration("duration", s.now().Sub(start)))
+	return nil
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+)
+
+func testZones(n int) []bunny.PullZone {
+	zones := make([]bunny.PullZone, n)
+	for i := range zones {
+		zones[i] = bunny.PullZone{ID: int64(i + 1)}
+	}
+	return zones
+}
+
+func TestForEachZone(t *testing.T) {
+	zones := testZones(50)
+	results := make([]int64, len(zones))
+	var running, peak atomic.Int32
+
+	fetched := forEachZone(context.Background(), zones, 4, func(ctx context.Context, i int, zone bunny.PullZone) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		results[i] = zone.ID
+		running.Add(-1)
+	})
+
+	if fetched != len(zones) {
+		t.Errorf("fetched = %d, want %d", fetched, len(zones))
+	}
+	if peak.Load() > 4 {
+		t.Errorf("peak concurrency = %d, want at most 4", peak.Load())
+	}
+	for i, id := range results {
+		if id != int64(i+1) {
+			t.Fatalf("results[%d] = %d, want %d", i, id, i+1)
+		}
+	}
+}
+
+func TestForEachZone_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	fetched := forEachZone(ctx, testZones(100), 2, func(ctx context.Context, i int, zone bunny.PullZone) {
+		time.Sleep(5 * time.Millisecond)
+	})
+
+	if fetched == 0 || fetched >= 100 {
+		t.Errorf("fetched = %d, want some but not all zones", fetched)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	defer limiter.stop()
+
+	start := time.Now()
+	for range 5 {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 calls at 100/s took %v, want at least 40ms", elapsed)
+	}
+
+	var unlimited *rateLimiter
+	if err := unlimited.wait(context.Background()); err != nil {
+		t.Errorf("nil limiter wait: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newRateLimiter(0.001)
+	defer slow.stop()
+	if err := slow.wait(ctx); err == nil {
+		t.Error("wait should fail once ctx is done")
+	}
+}
@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/email"
+)
+
+const (
+	// emailChartDays is how many days the charts of the daily email cover;
+	// the weekly email covers twice as many, within the snapshot retention
+	emailChartDays = 14
+	// Sizes of the total and per-domain charts, in pixels
+	totalChartWidth, totalChartHeight = 592, 60
+	zoneChartWidth, zoneChartHeight   = 100, 24
+)
+
+// SetEmail sends the summaries by email on notifications.email's schedules
+func (s *Scheduler) SetEmail(sender *email.Sender) {
+	s.email = sender
+}
+
+// runEmailSummary builds the daily or weekly report and emails it
+func (s *Scheduler) runEmailSummary(ctx context.Context, weekly bool) {
+	if s.email == nil {
+		return
+	}
+	job := "daily"
+	if weekly {
+		job = "weekly"
+	}
+	s.logger.Info("Running email summary", zap.String("summary", job))
+
+	_, _, timeout := s.summaryLimits()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var report *summaryReport
+	var err error
+	if weekly {
+		report, err = s.buildWeeklyReport(ctx)
+	} else {
+		report, err = s.buildDailyReport(ctx)
+	}
+	if err != nil {
+		s.logger.Error("Failed to build email summary", zap.String("summary", job), zap.Error(err))
+		return
+	}
+
+	summary, images := s.emailSummary(report, weekly)
+	html, err := email.RenderSummary(summary)
+	if err != nil {
+		s.logger.Error("Failed to render email summary", zap.Error(err))
+		return
+	}
+
+	if err := s.email.Send(summary.Title+" - "+summary.Period, html, images); err != nil {
+		s.logger.Error("Failed to send email summary", zap.String("summary", job), zap.Error(err))
+		return
+	}
+	s.logger.Info("Email summary sent successfully", zap.String("summary", job))
+}
+
+// emailSummary converts a report to the email template data and renders
+// its charts
+func (s *Scheduler) emailSummary(report *summaryReport, weekly bool) (email.Summary, []email.Image) {
+	topN := s.config.Notifications.Email.TopZones
+	if topN <= 0 || topN > len(report.Zones) {
+		topN = len(report.Zones)
+	}
+
+	summary := email.Summary{
+		Title:           "Daily CDN Summary",
+		Period:          report.From.Format("Jan 2, 2006"),
+		Server:          s.serverName(),
+		Weekly:          weekly,
+		Bandwidth:       report.Bandwidth,
+		Requests:        report.Requests,
+		CacheHitRate:    report.CacheHitRate,
+		BandwidthChange: report.BandwidthChange,
+		ErrorZones:      errorZones(report.Failing, topN),
+		ErrorThreshold:  errorRateAlert,
+		ChartDays:       emailChartDays,
+	}
+	if weekly {
+		summary.Title = "Weekly CDN Summary"
+		summary.Period = fmt.Sprintf("Week %d, %d", report.Week, report.From.Year())
+		summary.ChartDays *= 2
+		summary.Regions, _ = regionUsage(report.Regions, topRegions)
+	}
+
+	top := report.Zones[:topN]
+	ids := make([]int64, 0, len(top))
+	for _, zone := range top {
+		ids = append(ids, zone.ZoneID)
+	}
+	total, perZone := s.bandwidthHistory(ids, report.To, summary.ChartDays)
+
+	var images []email.Image
+	chart := func(cid string, values []int64, width, height int) string {
+		if len(values) < 2 {
+			return ""
+		}
+		png, err := email.Sparkline(values, width, height)
+		if err != nil {
+			s.logger.Warn("Failed to render chart", zap.String("chart", cid), zap.Error(err))
+			return ""
+		}
+		images = append(images, email.Image{CID: cid, PNG: png})
+		return cid
+	}
+
+	summary.TotalChart = chart("total", total, totalChartWidth, totalChartHeight)
+	for _, usage := range zoneUsage(top, report.Bandwidth, s.zoneOwners()) {
+		summary.TopZones = append(summary.TopZones, email.Zone{ZoneUsage: usage})
+	}
+	for i, zone := range top {
+		summary.TopZones[i].Chart = chart(fmt.Sprintf("zone-%d", zone.ZoneID), perZone[zone.ZoneID], zoneChartWidth, zoneChartHeight)
+	}
+
+	return summary, images
+}
+
+// bandwidthHistory returns the daily bandwidth of all zones and of each of
+// zoneIDs over the days up to until, read from the daily snapshots. Days
+// without snapshots count as zero; no history is returned when there are
+// none at all
+func (s *Scheduler) bandwidthHistory(zoneIDs []int64, until time.Time, days int) ([]int64, map[int64][]int64) {
+	perZone := make(map[int64][]int64, len(zoneIDs))
+	if s.snapshotStore == nil {
+		return nil, perZone
+	}
+
+	until = until.Add(time.Second)
+	since := until.AddDate(0, 0, -days)
+	total := make([]int64, days)
+	for _, id := range zoneIDs {
+		perZone[id] = make([]int64, days)
+	}
+
+	found := false
+	for _, snap := range s.snapshotStore.GetAllSnapshots(since) {
+		// Only snapshots of a single day; weekly periods and hours would
+		// double count
+		if !snap.Daily() || snap.From.Before(since) || !snap.From.Before(until) {
+			continue
+		}
+		// Rounded, as days around a DST change are not 24 hours long
+		day := int(math.Round(snap.From.Sub(since).Hours() / 24))
+		if day < 0 || day >= days {
+			continue
+		}
+		found = true
+		total[day] += snap.Bandwidth
+		if values, ok := perZone[snap.ZoneID]; ok {
+			values[day] += snap.Bandwidth
+		}
+	}
+
+	if !found {
+		return nil, make(map[int64][]int64)
+	}
+	return total, perZone
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+func TestEmailSummary(t *testing.T) {
+	logger := zap.NewNop()
+	store, err := state.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), logger)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+
+	// Yesterday's report, with daily snapshots of the three days before it
+	// and a weekly snapshot that must not be counted
+	loc := time.UTC
+	yesterday := time.Now().In(loc).AddDate(0, 0, -1)
+	from := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, loc)
+	to := from.Add(24*time.Hour - time.Second)
+
+	var snaps []state.BandwidthSnapshot
+	for i := 1; i <= 3; i++ {
+		dayFrom := from.AddDate(0, 0, -i)
+		snaps = append(snaps,
+			state.BandwidthSnapshot{Timestamp: time.Now(), From: dayFrom, To: dayFrom.Add(24*time.Hour - time.Second), ZoneID: 1, Bandwidth: int64(100 * i)},
+			state.BandwidthSnapshot{Timestamp: time.Now(), From: dayFrom, To: dayFrom.Add(24*time.Hour - time.Second), ZoneID: 2, Bandwidth: 10},
+		)
+	}
+	snaps = append(snaps, state.BandwidthSnapshot{Timestamp: time.Now(), From: from.AddDate(0, 0, -7), To: to, ZoneID: 1, Bandwidth: 100000})
+	if err := store.AddSnapshots(snaps); err != nil {
+		t.Fatalf("AddSnapshots: %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Notifications.Email.TopZones = 1
+	s := NewScheduler(cfg, nil, nil, store, logger)
+
+	report := &summaryReport{
+		From:      from,
+		To:        to,
+		Bandwidth: 1000,
+		Requests:  50,
+		Zones: []bunny.BandwidthEntry{
+			{ZoneID: 1, ZoneName: "example.com", Bandwidth: 800},
+			{ZoneID: 2, ZoneName: "other.com", Bandwidth: 200},
+		},
+	}
+
+	summary, images := s.emailSummary(report, false)
+	if len(summary.TopZones) != 1 || summary.TopZones[0].Name != "example.com" || summary.TopZones[0].Share != 80 {
+		t.Fatalf("TopZones = %+v, want example.com at 80%%", summary.TopZones)
+	}
+	if summary.TotalChart != "total" || summary.TopZones[0].Chart != "zone-1" {
+		t.Errorf("charts = %q, %q, want total and zone-1", summary.TotalChart, summary.TopZones[0].Chart)
+	}
+	if len(images) != 2 {
+		t.Errorf("images = %d, want 2", len(images))
+	}
+
+	total, perZone := s.bandwidthHistory([]int64{1}, to, emailChartDays)
+	if len(total) != emailChartDays {
+		t.Fatalf("history days = %d, want %d", len(total), emailChartDays)
+	}
+	last := emailChartDays - 1
+	if total[last] != 0 || total[last-1] != 110 || total[last-3] != 310 {
+		t.Errorf("total history tail = %v", total[last-3:])
+	}
+	if perZone[1][last-2] != 200 {
+		t.Errorf("zone 1 history = %v", perZone[1])
+	}
+
+	empty, _ := NewScheduler(cfg, nil, nil, nil, logger).bandwidthHistory([]int64{1}, to, emailChartDays)
+	if empty != nil {
+		t.Errorf("history without snapshots = %v, want none", empty)
+	}
+}
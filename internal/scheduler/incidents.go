@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+)
+
+// SetIncidents tracks the hourly zone alerts as incidents: an alert opens
+// an incident and is notified once, and the incident resolves when the
+// metric recovers. It also enables the hit rate and origin health checks
+// and lists open incidents in the daily summary
+func (s *Scheduler) SetIncidents(store *incident.Store) {
+	s.incidents = store
+}
+
+// checkZoneAlerts checks every pull zone for a bandwidth spike against the
+// day before and, with incidents tracked, for a low cache hit rate and for
+// origin errors today, and for unusual traffic in the last settled hour
+func (s *Scheduler) checkZoneAlerts(ctx context.Context) {
+	s.logger.Debug("Checking zone alerts")
+
+	if s.snapshotStore == nil {
+		return
+	}
+
+	// Get alert threshold
+	threshold := float64(s.config.Telegram.Summary.BandwidthAlertThreshold)
+	if threshold <= 0 {
+		threshold = 50 // Default: 50%
+	}
+
+	// Get all pull zones
+	zones, err := s.listZones(ctx)
+	if err != nil {
+		s.logger.Error("Failed to list pull zones for zone alerts", zap.Error(err))
+		return
+	}
+
+	loc, _ := s.getTimezone()
+	nowInLoc := s.now().In(loc)
+
+	// Today so far; Bunny reports whole days, so the period is the day
+	currentFrom := time.Date(nowInLoc.Year(), nowInLoc.Month(), nowInLoc.Day(), 0, 0, 0, 0, loc)
+	currentTo := time.Date(nowInLoc.Year(), nowInLoc.Month(), nowInLoc.Day(), 23, 59, 59, 0, loc)
+
+	// Yesterday, the period of the daily summary, so its snapshot is reused
+	previousFrom := currentFrom.AddDate(0, 0, -1)
+	previousTo := currentTo.AddDate(0, 0, -1)
+
+	defer s.saveSnapshots()
+	for _, zone := range zones {
+		currentStats, err := s.zoneStats(ctx, nil, zone, currentFrom, currentTo)
+		if err != nil {
+			continue
+		}
+
+		// Get previous stats, from the snapshot store once the day settled
+		var previousBandwidth int64
+		if prevStats, errPrev := s.zoneStats(ctx, nil, zone, previousFrom, previousTo); errPrev == nil {
+			previousBandwidth = prevStats.TotalBandwidth
+		}
+		s.checkBandwidth(ctx, zone, currentStats.TotalBandwidth, previousBandwidth, threshold)
+
+		if s.incidents == nil {
+			continue
+		}
+		s.checkHitRate(ctx, zone, currentStats)
+		s.checkOriginHealth(ctx, zone, currentStats, currentFrom, currentTo)
+		s.checkAnomaly(ctx, zone, loc)
+	}
+}
+
+// checkBandwidth alerts when a zone's bandwidth today rose by threshold
+// percent or more over yesterday
+func (s *Scheduler) checkBandwidth(ctx context.Context, zone bunny.PullZone, current, previous int64, threshold float64) {
+	if previous <= 0 {
+		return
+	}
+
+	percentIncrease := float64(current-previous) / float64(previous) * 100
+	if percentIncrease < threshold {
+		s.resolveIncident(ctx, incident.KindBandwidth, zone, percentIncrease)
+		return
+	}
+
+	s.logger.Warn("Bandwidth spike detected",
+		zap.Int64("zone_id", zone.ID),
+		zap.String("zone_name", zone.Name),
+		zap.Float64("increase", percentIncrease))
+
+	id, notify := s.fireIncident(incident.KindBandwidth, zone, percentIncrease, threshold)
+	if !notify {
+		return
+	}
+	message := s.formatBandwidthAlert(zone.Name, current, previous, percentIncrease, id)
+	s.sendIncident(ctx, message, id)
+}
+
+// checkHitRate opens an incident when a zone's cache hit rate today is
+// below incidents.hit_rate_threshold
+func (s *Scheduler) checkHitRate(ctx context.Context, zone bunny.PullZone, stats *bunny.PullZoneStats) {
+	cfg := s.config.Incidents
+	if cfg.HitRateThreshold <= 0 || stats.TotalRequests < cfg.MinRequests {
+		return
+	}
+
+	if stats.CacheHitRate >= cfg.HitRateThreshold {
+		s.resolveIncident(ctx, incident.KindHitRate, zone, stats.CacheHitRate)
+		return
+	}
+
+	s.logger.Warn("Low cache hit rate detected",
+		zap.Int64("zone_id", zone.ID),
+		zap.String("zone_name", zone.Name),
+		zap.Float64("hit_rate", stats.CacheHitRate))
+
+	id, notify := s.fireIncident(incident.KindHitRate, zone, stats.CacheHitRate, cfg.HitRateThreshold)
+	if !notify {
+		return
+	}
+	s.sendIncident(ctx, s.formatIncident(id, incident.KindHitRate, zone.Name, stats.CacheHitRate, cfg.HitRateThreshold), id)
+}
+
+// checkOriginHealth opens an incident when the origin answered
+// incidents.origin_error_rate percent or more of a zone's requests today
+// with 5xx. Errors the CDN edge produced are not the origin's and are left
+// to the daily summary
+func (s *Scheduler) checkOriginHealth(ctx context.Context, zone bunny.PullZone, stats *bunny.PullZoneStats, from, to time.Time) {
+	cfg := s.config.Incidents
+	if cfg.OriginErrorRate <= 0 || stats.TotalRequests < cfg.MinRequests {
+		return
+	}
+
+	errStats, err := s.bunnyClient.GetPullZoneErrorStats(ctx, zone.ID, from, to)
+	if err != nil {
+		s.logger.Warn("Failed to get error stats for zone",
+			zap.Int64("zone_id", zone.ID),
+			zap.String("zone_name", zone.Name),
+			zap.Error(err))
+		return
+	}
+
+	rate := errStats.Rate5xx()
+	if rate < cfg.OriginErrorRate || !errStats.OriginSide() {
+		s.resolveIncident(ctx, incident.KindOriginHealth, zone, rate)
+		return
+	}
+
+	s.logger.Warn("Origin errors detected",
+		zap.Int64("zone_id", zone.ID),
+		zap.String("zone_name", zone.Name),
+		zap.Float64("rate_5xx", rate))
+
+	id, notify := s.fireIncident(incident.KindOriginHealth, zone, rate, cfg.OriginErrorRate)
+	if !notify {
+		return
+	}
+	s.sendIncident(ctx, s.formatIncident(id, incident.KindOriginHealth, zone.Name, rate, cfg.OriginErrorRate), id)
+}
+
+// fireIncident records an alert and returns the ID of its incident and
+// whether to notify it. Only the alert opening an incident is notified;
+// without incident tracking every alert is, without an ID
+func (s *Scheduler) fireIncident(kind incident.Kind, zone bunny.PullZone, value, threshold float64) (string, bool) {
+	if s.incidents == nil {
+		return "", true
+	}
+
+	inc, opened, err := s.incidents.Fire(kind, zone.ID, zone.Name, value, threshold)
+	if err != nil {
+		// Alerting matters more than deduplicating it
+		s.logger.Error("Failed to record incident", zap.String("kind", string(kind)), zap.String("zone_name", zone.Name), zap.Error(err))
+		return "", true
+	}
+	if opened {
+		s.logger.Info("Incident opened", zap.String("incident", inc.ID), zap.String("kind", string(kind)), zap.String("zone_name", zone.Name))
+	}
+	return inc.ID, opened
+}
+
+// resolveIncident resolves the incident of kind for a zone whose metric
+// recovered, and notifies it
+func (s *Scheduler) resolveIncident(ctx context.Context, kind incident.Kind, zone bunny.PullZone, value float64) {
+	if s.incidents == nil {
+		return
+	}
+
+	inc, resolved, err := s.incidents.Resolve(kind, zone.ID)
+	if err != nil {
+		s.logger.Error("Failed to resolve incident", zap.String("kind", string(kind)), zap.String("zone_name", zone.Name), zap.Error(err))
+		return
+	}
+	if !resolved {
+		return
+	}
+	s.logger.Info("Incident resolved", zap.String("incident", inc.ID), zap.String("kind", string(kind)), zap.String("zone_name", zone.Name))
+
+	message := s.render(notifier.TemplateIncident, notifier.IncidentMessage{
+		MessageBase: s.base(),
+		ID:          inc.ID,
+		Kind:        string(inc.Kind),
+		Zone:        inc.Zone,
+		Value:       value,
+		Threshold:   inc.Threshold,
+		Resolved:    true,
+		OpenedAt:    inc.OpenedAt,
+		Alerts:      inc.Alerts,
+		AckedBy:     inc.AckedBy,
+	})
+	if message != "" && s.notifier != nil && s.notifier.IsEnabled() {
+		if err := s.notifier.SendRaw(ctx, notifier.CategoryAlerts, message); err != nil {
+			s.logger.Error("Failed to send incident resolution", zap.String("incident", inc.ID), zap.Error(err))
+		}
+	}
+}
+
+// sendIncident sends the alert of incident id, with an acknowledge button
+// when the bot answers commands
+func (s *Scheduler) sendIncident(ctx context.Context, message, id string) {
+	if message == "" || s.notifier == nil || !s.notifier.IsEnabled() {
+		return
+	}
+	if err := s.notifier.SendIncident(ctx, notifier.CategoryAlerts, message, id); err != nil {
+		s.logger.Error("Failed to send alert", zap.String("incident", id), zap.Error(err))
+	}
+}
+
+// formatIncident formats the alert opening a hit rate or origin health
+// incident
+func (s *Scheduler) formatIncident(id string, kind incident.Kind, zone string, value, threshold float64) string {
+	return s.render(notifier.TemplateIncident, notifier.IncidentMessage{
+		MessageBase: s.base(),
+		ID:          id,
+		Kind:        string(kind),
+		Zone:        zone,
+		Value:       value,
+		Threshold:   threshold,
+	})
+}
+
+// openIncidents returns the unresolved incidents for the daily summary,
+// oldest first; nil when incidents are not tracked
+func (s *Scheduler) openIncidents() []notifier.OpenIncident {
+	if s.incidents == nil {
+		return nil
+	}
+
+	var open []notifier.OpenIncident
+	for _, inc := range s.incidents.Active() {
+		open = append(open, notifier.OpenIncident{
+			ID:       inc.ID,
+			Kind:     string(inc.Kind),
+			Zone:     inc.Zone,
+			OpenedAt: inc.OpenedAt,
+			AckedBy:  inc.AckedBy,
+		})
+	}
+	return open
+}
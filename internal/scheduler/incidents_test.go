@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+func TestCheckZoneAlerts_Incidents(t *testing.T) {
+	// The fake Bunny API serves 10,000 requests a day with the cache hits
+	// and origin 5xx responses set below
+	var cacheHits, errors5xx atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/pullzone":
+			fmt.Fprint(w, `{"Items":[{"Id":1,"Name":"example"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			fmt.Fprintf(w, `{"TotalBandwidth":1000,"TotalRequests":10000,"CacheHits":%d,"CacheMisses":%d}`, cacheHits.Load(), 10000-cacheHits.Load())
+		case r.URL.Path == "/statistics":
+			fmt.Fprintf(w, `{"TotalRequestsServed":10000,"PullRequestsPulledChart":{"2024-01-15":2000},"Error5xxChart":{"2024-01-15":%d}}`, errors5xx.Load())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	logger := zap.NewNop()
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(server.URL), bunny.WithLogger(logger))
+	snapshots, err := state.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), logger)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	store, err := incident.NewStore(filepath.Join(t.TempDir(), "incidents.json"), logger)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	cfg := &config.Config{Incidents: config.IncidentsConfig{HitRateThreshold: 50, OriginErrorRate: 5, MinRequests: 1000}}
+	s := NewScheduler(cfg, client, nil, snapshots, logger)
+	fake := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	s.SetClock(fake)
+	s.SetIncidents(store)
+
+	// A low hit rate and a failing origin open one incident each
+	cacheHits.Store(3000)
+	errors5xx.Store(900)
+	s.checkZoneAlerts(context.Background())
+
+	active := store.Active()
+	if len(active) != 2 {
+		t.Fatalf("Expected 2 open incidents, got %+v", active)
+	}
+	kinds := map[incident.Kind]bool{active[0].Kind: true, active[1].Kind: true}
+	if !kinds[incident.KindHitRate] || !kinds[incident.KindOriginHealth] {
+		t.Errorf("Expected hit rate and origin health incidents, got %+v", active)
+	}
+
+	// Repeated alerts are counted into the open incidents
+	fake.Advance(time.Hour)
+	s.checkZoneAlerts(context.Background())
+	active = store.Active()
+	if len(active) != 2 || active[0].Alerts != 2 || active[1].Alerts != 2 {
+		t.Errorf("Expected the second alerts deduplicated, got %+v", active)
+	}
+
+	if open := s.openIncidents(); len(open) != 2 {
+		t.Errorf("Expected 2 incidents in the daily summary, got %+v", open)
+	}
+
+	// The origin recovers, the hit rate does not
+	errors5xx.Store(10)
+	fake.Advance(time.Hour)
+	s.checkZoneAlerts(context.Background())
+	active = store.Active()
+	if len(active) != 1 || active[0].Kind != incident.KindHitRate {
+		t.Errorf("Expected only the hit rate incident open, got %+v", active)
+	}
+
+	// Edge errors are not blamed on the origin
+	errors5xx.Store(5000)
+	fake.Advance(time.Hour)
+	s.checkZoneAlerts(context.Background())
+	for _, inc := range store.Active() {
+		if inc.Kind == incident.KindOriginHealth {
+			t.Errorf("Expected no origin incident for edge errors, got %+v", inc)
+		}
+	}
+}
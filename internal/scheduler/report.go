@@ -0,0 +1,491 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/idle"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+)
+
+// summaryReport is the data behind a summary, whichever channel sends it
+type summaryReport struct {
+	// Name identifies the report, such as daily-summary-2024-01-14
+	Name string
+	From time.Time
+	To   time.Time
+	// Week is the ISO week of a weekly report
+	Week int
+
+	Bandwidth    int64
+	Requests     int64
+	CacheHitRate float64
+	// Zones holds every zone fetched, by bandwidth descending
+	Zones []bunny.BandwidthEntry
+
+	// Failing lists the zones with a high 5xx rate (daily reports)
+	Failing []zoneErrors
+
+	// PreviousFrom starts the period compared against (weekly reports)
+	PreviousFrom    time.Time
+	BandwidthChange float64
+	Regions         []bunny.RegionTraffic
+	// DNS holds the queries of the managed DNS zones (weekly reports); nil
+	// when they were not collected
+	DNS *dnsQueries
+	// Idle lists the provisioned domains without traffic over the last
+	// telegram.summary.idle_days days (weekly reports)
+	Idle []idle.Domain
+
+	cacheHits   int64
+	cacheMisses int64
+}
+
+// add accumulates the stats of a zone into the report
+func (r *summaryReport) add(zone bunny.PullZone, stats *bunny.PullZoneStats) {
+	r.Bandwidth += stats.TotalBandwidth
+	r.Requests += stats.TotalRequests
+	r.cacheHits += stats.TotalCacheHits
+	r.cacheMisses += stats.TotalCacheMisses
+
+	r.Zones = append(r.Zones, bunny.BandwidthEntry{
+		ZoneID:    zone.ID,
+		ZoneName:  zone.Name,
+		Hostname:  zone.Name,
+		Bandwidth: stats.TotalBandwidth,
+		Requests:  stats.TotalRequests,
+		Date:      r.From,
+	})
+}
+
+// finish sorts the zones and computes the cache hit rate
+func (r *summaryReport) finish() {
+	sort.Slice(r.Zones, func(i, j int) bool {
+		return r.Zones[i].Bandwidth > r.Zones[j].Bandwidth
+	})
+	if total := r.cacheHits + r.cacheMisses; total > 0 {
+		r.CacheHitRate = float64(r.cacheHits) / float64(total) * 100
+	}
+}
+
+// buildDailyReport collects yesterday's stats of every zone
+func (s *Scheduler) buildDailyReport(ctx context.Context) (*summaryReport, error) {
+	// Get yesterday's date range
+	loc, err := s.getTimezone()
+	if err != nil {
+		s.logger.Error("Failed to get timezone", zap.Error(err))
+		loc = time.UTC
+	}
+	yesterday := s.now().In(loc).AddDate(0, 0, -1)
+
+	from := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, loc)
+	to := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 23, 59, 59, 0, loc)
+
+	// Get all pull zones
+	zones, err := s.listZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull zones: %w", err)
+	}
+
+	// Fetch the stats of all zones in parallel; each worker writes only the
+	// slot of its zone
+	type dailyZone struct {
+		stats    *bunny.PullZoneStats
+		errStats *bunny.ErrorStats
+	}
+	results := make([]dailyZone, len(zones))
+	collectErr := s.collectZones(ctx, "daily summary", zones, func(ctx context.Context, limiter *rateLimiter, i int, zone bunny.PullZone) {
+		stats, err := s.zoneStats(ctx, limiter, zone, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get stats for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("zone_name", zone.Name),
+				zap.Error(err))
+			return
+		}
+		results[i].stats = stats
+
+		if limiter.wait(ctx) != nil {
+			return
+		}
+		errStats, err := s.bunnyClient.GetPullZoneErrorStats(ctx, zone.ID, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get error stats for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("zone_name", zone.Name),
+				zap.Error(err))
+			return
+		}
+		results[i].errStats = errStats
+	})
+
+	// The snapshots of the zones fetched are kept, but a summary missing
+	// zones would understate the totals
+	s.saveSnapshots()
+	if collectErr != nil {
+		return nil, collectErr
+	}
+
+	// Aggregate the stats in zone order
+	report := &summaryReport{
+		Name:  "daily-summary-" + from.Format("2006-01-02"),
+		From:  from,
+		To:    to,
+		Zones: make([]bunny.BandwidthEntry, 0, len(zones)),
+	}
+	for i, zone := range zones {
+		stats, errStats := results[i].stats, results[i].errStats
+		if stats == nil {
+			continue
+		}
+		if errStats != nil && errStats.Rate5xx() >= errorRateAlert {
+			report.Failing = append(report.Failing, zoneErrors{Name: zone.Name, Stats: *errStats})
+		}
+		report.add(zone, stats)
+	}
+	report.finish()
+
+	return report, nil
+}
+
+// buildWeeklyReport collects last week's stats of every zone, compared with
+// the week before
+func (s *Scheduler) buildWeeklyReport(ctx context.Context) (*summaryReport, error) {
+	// Get last week's date range
+	loc, err := s.getTimezone()
+	if err != nil {
+		s.logger.Error("Failed to get timezone", zap.Error(err))
+		loc = time.UTC
+	}
+	nowInLoc := s.now().In(loc)
+
+	// Find last Monday
+	weekday := nowInLoc.Weekday()
+	daysSinceMonday := (int(weekday) - 1 + 7) % 7
+	lastMonday := nowInLoc.AddDate(0, 0, -daysSinceMonday-7)
+	lastSunday := lastMonday.AddDate(0, 0, 6)
+
+	from := time.Date(lastMonday.Year(), lastMonday.Month(), lastMonday.Day(), 0, 0, 0, 0, loc)
+	to := time.Date(lastSunday.Year(), lastSunday.Month(), lastSunday.Day(), 23, 59, 59, 0, loc)
+
+	// Get previous week for comparison
+	prevMonday := lastMonday.AddDate(0, 0, -7)
+	prevSunday := lastMonday.AddDate(0, 0, -1)
+	prevFrom := time.Date(prevMonday.Year(), prevMonday.Month(), prevMonday.Day(), 0, 0, 0, 0, loc)
+	prevTo := time.Date(prevSunday.Year(), prevSunday.Month(), prevSunday.Day(), 23, 59, 59, 0, loc)
+
+	// Get all pull zones
+	zones, err := s.listZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull zones: %w", err)
+	}
+
+	// Fetch the stats of all zones in parallel; each worker writes only the
+	// slot of its zone
+	type weeklyZone struct {
+		stats     *bunny.PullZoneStats
+		prevStats *bunny.PullZoneStats
+		geo       []bunny.GeoTraffic
+	}
+	results := make([]weeklyZone, len(zones))
+	collectErr := s.collectZones(ctx, "weekly summary", zones, func(ctx context.Context, limiter *rateLimiter, i int, zone bunny.PullZone) {
+		stats, err := s.zoneStats(ctx, limiter, zone, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get stats for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("zone_name", zone.Name),
+				zap.Error(err))
+			return
+		}
+		results[i].stats = stats
+
+		// Get previous week stats for comparison
+		if prevStats, errPrev := s.zoneStats(ctx, limiter, zone, prevFrom, prevTo); errPrev == nil {
+			results[i].prevStats = prevStats
+		}
+
+		if limiter.wait(ctx) != nil {
+			return
+		}
+		geo, err := s.bunnyClient.GetPullZoneGeoTraffic(ctx, zone.ID, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get geo traffic for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("zone_name", zone.Name),
+				zap.Error(err))
+			return
+		}
+		results[i].geo = geo
+	})
+	s.saveSnapshots()
+	if collectErr != nil {
+		return nil, collectErr
+	}
+
+	// Aggregate the stats in zone order
+	_, week := from.ISOWeek()
+	report := &summaryReport{
+		Name:         fmt.Sprintf("weekly-summary-%d-W%02d", from.Year(), week),
+		From:         from,
+		To:           to,
+		Week:         week,
+		PreviousFrom: prevFrom,
+		Zones:        make([]bunny.BandwidthEntry, 0, len(zones)),
+	}
+	var prevBandwidth int64
+	var geoTraffic []bunny.GeoTraffic
+	for i, zone := range zones {
+		stats := results[i].stats
+		if stats == nil {
+			continue
+		}
+		report.add(zone, stats)
+
+		if prevStats := results[i].prevStats; prevStats != nil {
+			prevBandwidth += prevStats.TotalBandwidth
+		}
+		geoTraffic = append(geoTraffic, results[i].geo...)
+	}
+	report.finish()
+
+	// Calculate bandwidth change
+	if prevBandwidth > 0 {
+		report.BandwidthChange = float64(report.Bandwidth-prevBandwidth) / float64(prevBandwidth) * 100
+	}
+	report.Regions = bunny.GroupByRegion(geoTraffic)
+
+	// The summary is sent without DNS statistics rather than not at all
+	dns, err := s.collectDNSQueries(ctx, from, to)
+	if err != nil {
+		s.logger.Warn("Failed to collect DNS query statistics", zap.Error(err))
+	}
+	report.DNS = dns
+	report.Idle = s.idleDomains(ctx, loc)
+
+	return report, nil
+}
+
+// idleDomains returns the provisioned domains that served at most
+// telegram.summary.idle_max_requests requests over the last idle_days
+// days. Domains whose daily snapshots do not cover the days are read from
+// Bunny one at a time, within the summary's request rate
+func (s *Scheduler) idleDomains(ctx context.Context, loc *time.Location) []idle.Domain {
+	cfg := s.config.Telegram.Summary
+	if cfg.IdleDays <= 0 || s.states == nil {
+		return nil
+	}
+
+	_, perSecond, _ := s.summaryLimits()
+	limiter := newRateLimiter(perSecond)
+	defer limiter.stop()
+	stats := func(ctx context.Context, pullZoneID int64, from, to time.Time) (*bunny.PullZoneStats, error) {
+		if err := limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		return bunny.GetPullZoneStats(ctx, s.bunnyClient, pullZoneID, from, to)
+	}
+
+	var snapshots idle.Snapshots
+	if s.snapshotStore != nil {
+		snapshots = s.snapshotStore
+	}
+	from, to := idle.Window(s.now(), cfg.IdleDays, loc)
+	domains, err := idle.Find(ctx, s.states.ListAll(), snapshots, stats, from, to, cfg.IdleMaxRequests)
+	if err != nil {
+		s.logger.Warn("Failed to check domains for idle traffic", zap.Error(err))
+	}
+	return domains
+}
+
+// dnsZone is a managed DNS zone with the queries it answered
+type dnsZone struct {
+	ID      int64
+	Domain  string
+	User    string
+	Queries int64
+}
+
+// dnsQueries are the queries the managed DNS zones answered over a period
+type dnsQueries struct {
+	Total int64
+	// Zones are the zones fetched, most queried first
+	Zones []dnsZone
+}
+
+// limit returns the queries with only the n most queried zones; the total
+// still counts every zone
+func (q *dnsQueries) limit(n int) *dnsQueries {
+	if q == nil || len(q.Zones) <= n {
+		return q
+	}
+	return &dnsQueries{Total: q.Total, Zones: q.Zones[:n]}
+}
+
+// usage converts the zones to template data with each zone's share of the
+// total
+func (q *dnsQueries) usage() []notifier.DNSZoneUsage {
+	usage := make([]notifier.DNSZoneUsage, 0, len(q.Zones))
+	for _, zone := range q.Zones {
+		share := 0.0
+		if q.Total > 0 {
+			share = float64(zone.Queries) / float64(q.Total) * 100
+		}
+		usage = append(usage, notifier.DNSZoneUsage{
+			Name:    zone.Domain,
+			User:    zone.User,
+			Queries: zone.Queries,
+			Share:   share,
+		})
+	}
+	return usage
+}
+
+// managedDNSZones returns the DNS zones of the provisioned domains;
+// subdomains share their parent's zone and are skipped
+func (s *Scheduler) managedDNSZones() []dnsZone {
+	if s.states == nil {
+		return nil
+	}
+	seen := make(map[int64]bool)
+	var zones []dnsZone
+	for _, st := range s.states.ListAll() {
+		if st.ZoneID <= 0 || st.ParentDomain != "" || seen[st.ZoneID] {
+			continue
+		}
+		seen[st.ZoneID] = true
+		zones = append(zones, dnsZone{ID: st.ZoneID, Domain: st.Domain, User: st.User})
+	}
+	return zones
+}
+
+// collectDNSQueries fetches the queries each managed DNS zone answered
+// between from and to. It returns nil without provisioned domains, and the
+// zones fetched so far with an error when the job's deadline passed
+func (s *Scheduler) collectDNSQueries(ctx context.Context, from, to time.Time) (*dnsQueries, error) {
+	zones := s.managedDNSZones()
+	if len(zones) == 0 {
+		return nil, nil
+	}
+
+	fetched := make([]bool, len(zones))
+	collectErr := collectEach(ctx, s, "DNS statistics", zones, func(ctx context.Context, limiter *rateLimiter, i int, zone dnsZone) {
+		if limiter.wait(ctx) != nil {
+			return
+		}
+		stats, err := s.bunnyClient.GetDNSQueryStats(ctx, zone.ID, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get DNS query stats for zone",
+				zap.Int64("zone_id", zone.ID),
+				zap.String("domain", zone.Domain),
+				zap.Error(err))
+			return
+		}
+		zones[i].Queries = stats.TotalQueries
+		fetched[i] = true
+	})
+
+	q := &dnsQueries{Zones: make([]dnsZone, 0, len(zones))}
+	for i, zone := range zones {
+		if !fetched[i] {
+			continue
+		}
+		q.Total += zone.Queries
+		q.Zones = append(q.Zones, zone)
+	}
+	if len(q.Zones) == 0 {
+		return nil, collectErr
+	}
+	sort.SliceStable(q.Zones, func(i, j int) bool {
+		if q.Zones[i].Queries != q.Zones[j].Queries {
+			return q.Zones[i].Queries > q.Zones[j].Queries
+		}
+		return q.Zones[i].Domain < q.Zones[j].Domain
+	})
+	return q, collectErr
+}
+
+// reportRow is one zone of a summary's attached report
+type reportRow struct {
+	ZoneID    int64   `json:"zone_id"`
+	Zone      string  `json:"zone"`
+	User      string  `json:"user,omitempty"`
+	Bandwidth int64   `json:"bandwidth"`
+	Requests  int64   `json:"requests"`
+	Share     float64 `json:"share"`
+}
+
+// reportRows converts the bandwidth of every zone to report rows, keeping
+// their order
+func reportRows(zones []bunny.BandwidthEntry, total int64, owners map[int64]string) []reportRow {
+	rows := make([]reportRow, 0, len(zones))
+	for _, zone := range zones {
+		share := 0.0
+		if total > 0 {
+			share = float64(zone.Bandwidth) / float64(total) * 100
+		}
+		rows = append(rows, reportRow{
+			ZoneID:    zone.ZoneID,
+			Zone:      zone.ZoneName,
+			User:      owners[zone.ZoneID],
+			Bandwidth: zone.Bandwidth,
+			Requests:  zone.Requests,
+			Share:     share,
+		})
+	}
+	return rows
+}
+
+// encodeReport encodes report rows as csv or json
+func encodeReport(format string, rows []reportRow) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(rows, "", "  ")
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"zone_id", "zone", "user", "bandwidth", "requests", "share"})
+		for _, row := range rows {
+			_ = w.Write([]string{
+				strconv.FormatInt(row.ZoneID, 10),
+				row.Zone,
+				row.User,
+				strconv.FormatInt(row.Bandwidth, 10),
+				strconv.FormatInt(row.Requests, 10),
+				strconv.FormatFloat(row.Share, 'f', 2, 64),
+			})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// sendReport attaches the full zone report to a summary when
+// telegram.summary.attachment is set; name is the file name without extension
+func (s *Scheduler) sendReport(ctx context.Context, name string, zones []bunny.BandwidthEntry, total int64) {
+	format := s.config.Telegram.Summary.Attachment
+	if format == "" || s.notifier == nil || !s.notifier.IsEnabled() {
+		return
+	}
+
+	data, err := encodeReport(format, reportRows(zones, total, s.zoneOwners()))
+	if err != nil {
+		s.logger.Error("Failed to encode summary report", zap.String("report", name), zap.Error(err))
+		return
+	}
+
+	filename := name + "." + format
+	if err := s.notifier.SendDocument(ctx, notifier.CategorySummaries, filename, data, ""); err != nil {
+		s.logger.Error("Failed to send summary report", zap.String("report", filename), zap.Error(err))
+	}
+}
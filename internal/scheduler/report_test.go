@@ -0,0 +1,244 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bunny/bunnytest"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+func TestEncodeReport(t *testing.T) {
+	zones := []bunny.BandwidthEntry{
+		{ZoneID: 1, ZoneName: "example", Bandwidth: 750, Requests: 30},
+		{ZoneID: 2, ZoneName: "shop, inc", Bandwidth: 250, Requests: 10},
+	}
+	rows := reportRows(zones, 1000, map[int64]string{1: "exampleu"})
+
+	data, err := encodeReport("csv", rows)
+	if err != nil {
+		t.Fatalf("encodeReport csv: %v", err)
+	}
+	want := "zone_id,zone,user,bandwidth,requests,share\n1,example,exampleu,750,30,75.00\n2,\"shop, inc\",,250,10,25.00\n"
+	if string(data) != want {
+		t.Errorf("csv report =\n%s\nwant\n%s", data, want)
+	}
+
+	data, err = encodeReport("json", rows)
+	if err != nil {
+		t.Fatalf("encodeReport json: %v", err)
+	}
+	var got []reportRow
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal json report: %v", err)
+	}
+	if len(got) != 2 || got[0].User != "exampleu" || got[1].Share != 25 {
+		t.Errorf("json report = %+v", got)
+	}
+
+	if _, err := encodeReport("xlsx", rows); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestReportPeriods(t *testing.T) {
+	s, _, _ := newCachingScheduler(t)
+	s.config.Telegram.Summary.Timezone = "UTC"
+	// Wednesday of ISO week 11
+	s.SetClock(clock.NewFake(time.Date(2026, 3, 11, 10, 30, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	daily, err := s.buildDailyReport(ctx)
+	if err != nil {
+		t.Fatalf("buildDailyReport: %v", err)
+	}
+	if want := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC); !daily.From.Equal(want) {
+		t.Errorf("daily From = %v, want %v", daily.From, want)
+	}
+	if want := time.Date(2026, 3, 10, 23, 59, 59, 0, time.UTC); !daily.To.Equal(want) {
+		t.Errorf("daily To = %v, want %v", daily.To, want)
+	}
+
+	weekly, err := s.buildWeeklyReport(ctx)
+	if err != nil {
+		t.Fatalf("buildWeeklyReport: %v", err)
+	}
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !weekly.From.Equal(want) {
+		t.Errorf("weekly From = %v, want %v", weekly.From, want)
+	}
+	if want := time.Date(2026, 3, 8, 23, 59, 59, 0, time.UTC); !weekly.To.Equal(want) {
+		t.Errorf("weekly To = %v, want %v", weekly.To, want)
+	}
+	if want := time.Date(2026, 2, 23, 0, 0, 0, 0, time.UTC); !weekly.PreviousFrom.Equal(want) {
+		t.Errorf("weekly PreviousFrom = %v, want %v", weekly.PreviousFrom, want)
+	}
+	if weekly.Week != 10 {
+		t.Errorf("weekly Week = %d, want 10", weekly.Week)
+	}
+}
+
+func TestCollectDNSQueries(t *testing.T) {
+	fake := bunnytest.NewServer()
+	t.Cleanup(fake.Close)
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(fake.URL))
+
+	states, err := state.NewManager(filepath.Join(t.TempDir(), "state.json"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	s := NewScheduler(&config.Config{}, client, nil, nil, zap.NewNop())
+	ctx := context.Background()
+
+	if q, err := s.collectDNSQueries(ctx, time.Now().AddDate(0, 0, -7), time.Now()); q != nil || err != nil {
+		t.Errorf("collectDNSQueries without states = %+v, %v", q, err)
+	}
+	s.SetStates(states)
+
+	for _, d := range []struct {
+		domain, parent, user string
+		queries              int64
+	}{
+		{"quiet.com", "", "quietu", 100},
+		{"busy.com", "", "busyu", 900},
+		{"blog.busy.com", "busy.com", "busyu", 0},
+	} {
+		zoneDomain := d.domain
+		if d.parent != "" {
+			zoneDomain = d.parent
+		}
+		zone, ok := fake.DNSZone(zoneDomain)
+		if !ok {
+			created, err := client.CreateDNSZone(ctx, zoneDomain, "")
+			if err != nil {
+				t.Fatalf("CreateDNSZone: %v", err)
+			}
+			zone = *created
+		}
+		if d.parent == "" {
+			fake.SetDNSQueries(d.domain, d.queries)
+		}
+
+		st := states.Create(d.domain)
+		st.ParentDomain, st.User, st.ZoneID = d.parent, d.user, zone.ID
+		if err := states.Update(st); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	q, err := s.collectDNSQueries(ctx, time.Now().AddDate(0, 0, -7), time.Now())
+	if err != nil {
+		t.Fatalf("collectDNSQueries: %v", err)
+	}
+	if q.Total != 1000 {
+		t.Errorf("Total = %d, want 1000", q.Total)
+	}
+	if len(q.Zones) != 2 || q.Zones[0].Domain != "busy.com" || q.Zones[1].Domain != "quiet.com" {
+		t.Fatalf("Zones = %+v, want busy.com then quiet.com", q.Zones)
+	}
+
+	message := s.formatWeeklySummary(3, 2024, 0, 0, 0, 0, nil, nil, nil, nil, q.limit(1), nil)
+	if !contains(message, "DNS Queries:</b> 1.00K\n1. busy.com (busyu) - 900 (90%)") {
+		t.Errorf("Expected DNS queries in message, got %q", message)
+	}
+	if contains(message, "quiet.com") {
+		t.Error("Expected only the most queried zone in message")
+	}
+}
+
+func TestIdleDomains(t *testing.T) {
+	// Bunny is only asked about the domain without snapshots
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"TotalRequests":40,"TotalBandwidth":1000}`)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := zap.NewNop()
+	now := time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC)
+	states, err := state.NewManager(filepath.Join(t.TempDir(), "state.json"), logger)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	states.SetClock(clock.NewFake(now.AddDate(0, -2, 0)))
+	snapshots, err := state.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), logger)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	snapshots.SetClock(clock.NewFake(now))
+
+	for i, d := range []struct {
+		domain  string
+		daily   int64
+		created bool
+	}{
+		{"busy.com", 5000, true},
+		{"dead.com", 0, true},
+		{"unknown.com", 0, false},
+	} {
+		st := states.Create(d.domain)
+		st.PullZoneID, st.User = int64(i+1), "user"+d.domain
+		if err := states.Update(st); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		_ = states.MarkProvisioning(st.ID)
+		_ = states.AdvanceStep(st.ID, state.StepDone)
+		if err := states.MarkSuccess(st.ID); err != nil {
+			t.Fatalf("MarkSuccess: %v", err)
+		}
+		if !d.created {
+			continue
+		}
+		var snaps []state.BandwidthSnapshot
+		for day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); day.Before(now.Truncate(24 * time.Hour)); day = day.AddDate(0, 0, 1) {
+			snaps = append(snaps, state.BandwidthSnapshot{
+				Timestamp: now, From: day, To: day.AddDate(0, 0, 1).Add(-time.Second),
+				ZoneID: int64(i + 1), Requests: d.daily,
+			})
+		}
+		if err := snapshots.AddSnapshots(snaps); err != nil {
+			t.Fatalf("AddSnapshots: %v", err)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Telegram.Summary.IdleDays = 30
+	cfg.Telegram.Summary.IdleMaxRequests = 100
+	client := bunny.NewClient("test-key", bunny.WithBaseURL(server.URL), bunny.WithLogger(logger))
+	s := NewScheduler(cfg, client, nil, snapshots, logger)
+	s.SetClock(clock.NewFake(now))
+	s.SetStates(states)
+
+	domains := s.idleDomains(context.Background(), time.UTC)
+	if len(domains) != 2 || domains[0].Domain != "dead.com" || domains[1].Domain != "unknown.com" {
+		t.Fatalf("Expected dead.com and unknown.com idle, got %+v", domains)
+	}
+	for _, path := range fetched {
+		if !strings.HasPrefix(path, "/pullzone/3") {
+			t.Errorf("Expected only unknown.com's stats fetched, got %v", fetched)
+		}
+	}
+
+	message := s.formatWeeklySummary(3, 2024, 0, 0, 0, 0, nil, nil, nil, nil, nil, domains)
+	if !contains(message, "Idle Domains:</b> 2 with next to no traffic in 30 days\n• dead.com (userdead.com) - 0 requests\n• unknown.com (userunknown.com) - 40 requests") {
+		t.Errorf("Expected idle domains in message, got %q", message)
+	}
+
+	cfg.Telegram.Summary.IdleDays = 0
+	if domains := s.idleDomains(context.Background(), time.UTC); domains != nil {
+		t.Errorf("Expected no idle check with idle_days 0, got %+v", domains)
+	}
+}
@@ -3,7 +3,6 @@ package scheduler
 import (
 	"context"
 	"fmt"
-	"os"
 	"sort"
 	"time"
 
@@ -12,10 +11,27 @@ import (
 
 	"github.com/mordenhost/whm2bunny/config"
 	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/email"
+	"github.com/mordenhost/whm2bunny/internal/failures"
+	"github.com/mordenhost/whm2bunny/internal/idle"
+	"github.com/mordenhost/whm2bunny/internal/incident"
 	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/quota"
+	"github.com/mordenhost/whm2bunny/internal/slo"
 	"github.com/mordenhost/whm2bunny/internal/state"
 )
 
+// errorRateAlert is the 5xx share (percent) at which a pull zone is listed
+// in the daily summary
+const errorRateAlert = 1.0
+
+// topRegions is how many regions the weekly summary lists
+const topRegions = 5
+
+// topIdleDomains is how many idle domains the weekly summary lists
+const topIdleDomains = 10
+
 // Scheduler manages cron jobs for daily and weekly summaries
 type Scheduler struct {
 	cron          *cron.Cron
@@ -24,6 +40,15 @@ type Scheduler struct {
 	config        *config.Config
 	logger        *zap.Logger
 	snapshotStore *state.SnapshotStore
+	cache         *statsCache
+	quota         *quota.Manager
+	slo           *slo.Store
+	failures      *failures.Store
+	incidents     *incident.Store
+	states        *state.Manager
+	email         *email.Sender
+	clock         clock.Clock // nil uses the system clock
+	jobs          map[string]cron.EntryID
 	running       bool
 	mu            chan struct{}
 }
@@ -43,11 +68,41 @@ func NewScheduler(
 		config:        cfg,
 		logger:        logger,
 		snapshotStore: snapshotStore,
+		cache:         newStatsCache(),
+		jobs:          make(map[string]cron.EntryID),
 		running:       false,
 		mu:            make(chan struct{}, 1),
 	}
 }
 
+// SetClock replaces the clock that decides the report periods
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.clock = c
+	s.cache.now = c.Now
+}
+
+// SetQuota adds provisioning quota usage to the daily summary
+func (s *Scheduler) SetQuota(q *quota.Manager) {
+	s.quota = q
+}
+
+// SetSLO adds provisioning time percentiles to the weekly summary and
+// alerts when the p95 degrades week-over-week
+func (s *Scheduler) SetSLO(store *slo.Store) {
+	s.slo = store
+}
+
+// SetFailures adds a breakdown of failed runs by error class to the weekly
+// summary
+func (s *Scheduler) SetFailures(store *failures.Store) {
+	s.failures = store
+}
+
+// SetStates names the WHM user owning each zone in the summaries
+func (s *Scheduler) SetStates(m *state.Manager) {
+	s.states = m
+}
+
 // Start starts the scheduler cron jobs
 func (s *Scheduler) Start() error {
 	s.mu <- struct{}{}
@@ -59,8 +114,14 @@ func (s *Scheduler) Start() error {
 	}
 
 	// Check if summary is enabled
-	if !s.config.Telegram.Summary.Enabled {
-		s.logger.Info("Telegram summary is disabled, scheduler not starting")
+	telegramEnabled := s.config.Telegram.Summary.Enabled
+	emailEnabled := s.config.Notifications.Email.Enabled && s.email != nil
+	if emailEnabled && (s.notifier == nil || !s.notifier.IsEnabled()) {
+		// Only email is set up; the Telegram jobs would fetch stats for nothing
+		telegramEnabled = false
+	}
+	if !telegramEnabled && !emailEnabled {
+		s.logger.Info("Telegram and email summaries are disabled, scheduler not starting")
 		return nil
 	}
 
@@ -70,6 +131,33 @@ func (s *Scheduler) Start() error {
 		return fmt.Errorf("failed to get timezone: %w", err)
 	}
 
+	if telegramEnabled {
+		if err := s.addTelegramJobs(loc); err != nil {
+			return err
+		}
+	}
+	if emailEnabled {
+		if err := s.addEmailJobs(loc); err != nil {
+			return err
+		}
+	}
+
+	// Start the cron scheduler
+	s.cron.Start()
+	s.running = true
+
+	s.logger.Info("Scheduler started",
+		zap.String("timezone", loc.String()),
+		zap.Bool("telegram", telegramEnabled),
+		zap.Bool("email", emailEnabled))
+
+	return nil
+}
+
+// addTelegramJobs adds the Telegram summary and zone alert jobs
+func (s *Scheduler) addTelegramJobs(loc *time.Location) error {
+	var err error
+
 	// Parse daily schedule
 	dailySchedule := s.config.Telegram.Summary.Schedule
 	if dailySchedule == "" {
@@ -80,7 +168,7 @@ func (s *Scheduler) Start() error {
 	dailyScheduleWithSec := "0 " + dailySchedule
 
 	// Add daily summary job
-	_, err = s.cron.AddFunc(dailyScheduleWithSec, func() {
+	s.jobs["daily_summary"], err = s.cron.AddFunc(dailyScheduleWithSec, func() {
 		s.runDailySummary(context.Background())
 	})
 	if err != nil {
@@ -100,7 +188,7 @@ func (s *Scheduler) Start() error {
 	weeklyScheduleWithSec := "0 " + weeklySchedule
 
 	// Add weekly summary job
-	_, err = s.cron.AddFunc(weeklyScheduleWithSec, func() {
+	s.jobs["weekly_summary"], err = s.cron.AddFunc(weeklyScheduleWithSec, func() {
 		s.runWeeklySummary(context.Background())
 	})
 	if err != nil {
@@ -110,23 +198,47 @@ func (s *Scheduler) Start() error {
 		zap.String("schedule", weeklyScheduleWithSec),
 		zap.String("timezone", loc.String()))
 
-	// Add bandwidth alert check job - run every hour (at minute 0)
-	_, err = s.cron.AddFunc("0 0 * * * *", func() {
-		s.checkBandwidthAlerts(context.Background())
+	// Add zone alert check job - run every hour (at minute 0)
+	s.jobs["zone_alerts"], err = s.cron.AddFunc("0 0 * * * *", func() {
+		s.checkZoneAlerts(context.Background())
 	})
 	if err != nil {
-		return fmt.Errorf("failed to add bandwidth alert job: %w", err)
+		return fmt.Errorf("failed to add zone alert job: %w", err)
 	}
-	s.logger.Info("Added bandwidth alert check job", zap.String("schedule", "0 0 * * * *"))
+	s.logger.Info("Added zone alert check job", zap.String("schedule", "0 0 * * * *"))
 
-	// Start the cron scheduler
-	s.cron.Start()
-	s.running = true
+	return nil
+}
 
-	s.logger.Info("Scheduler started",
-		zap.String("timezone", loc.String()),
-		zap.String("daily_schedule", dailySchedule),
-		zap.String("weekly_schedule", weeklySchedule))
+// addEmailJobs adds the email summary jobs; an empty schedule skips its
+// summary
+func (s *Scheduler) addEmailJobs(loc *time.Location) error {
+	var err error
+	cfg := s.config.Notifications.Email
+
+	if cfg.Schedule != "" {
+		s.jobs["email_daily_summary"], err = s.cron.AddFunc("0 "+cfg.Schedule, func() {
+			s.runEmailSummary(context.Background(), false)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add daily email summary job: %w", err)
+		}
+		s.logger.Info("Added daily email summary job",
+			zap.String("schedule", cfg.Schedule),
+			zap.String("timezone", loc.String()))
+	}
+
+	if cfg.WeeklySchedule != "" {
+		s.jobs["email_weekly_summary"], err = s.cron.AddFunc("0 "+cfg.WeeklySchedule, func() {
+			s.runEmailSummary(context.Background(), true)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add weekly email summary job: %w", err)
+		}
+		s.logger.Info("Added weekly email summary job",
+			zap.String("schedule", cfg.WeeklySchedule),
+			zap.String("timezone", loc.String()))
+	}
 
 	return nil
 }
@@ -152,6 +264,21 @@ func (s *Scheduler) Stop() {
 	s.logger.Info("Scheduler stopped")
 }
 
+// NextRuns returns the next run time of each scheduled job
+func (s *Scheduler) NextRuns() map[string]time.Time {
+	s.mu <- struct{}{}
+	defer func() { <-s.mu }()
+
+	runs := make(map[string]time.Time, len(s.jobs))
+	if !s.running {
+		return runs
+	}
+	for name, id := range s.jobs {
+		runs[name] = s.cron.Entry(id).Next
+	}
+	return runs
+}
+
 // getTimezone returns the configured timezone or default to Asia/Jakarta
 func (s *Scheduler) getTimezone() (*time.Location, error) {
 	tz := s.config.Telegram.Summary.Timezone
@@ -165,102 +292,42 @@ func (s *Scheduler) getTimezone() (*time.Location, error) {
 func (s *Scheduler) runDailySummary(ctx context.Context) {
 	s.logger.Info("Running daily summary")
 
-	// Get yesterday's date range
-	now := time.Now()
-	loc, err := s.getTimezone()
-	if err != nil {
-		s.logger.Error("Failed to get timezone", zap.Error(err))
-		loc = time.UTC
-	}
-	nowInLoc := now.In(loc)
-	yesterday := nowInLoc.AddDate(0, 0, -1)
-
-	from := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, loc)
-	to := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 23, 59, 59, 0, loc)
+	_, _, timeout := s.summaryLimits()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	// Get all pull zones
-	zones, err := s.bunnyClient.ListPullZones(ctx)
+	report, err := s.buildDailyReport(ctx)
 	if err != nil {
-		s.logger.Error("Failed to list pull zones for daily summary", zap.Error(err))
+		s.logger.Error("Failed to build daily summary", zap.Error(err))
 		return
 	}
 
-	// Collect stats for all zones
-	var totalBandwidth int64
-	var totalRequestsVal int64
-	var totalCacheHits int64
-	var totalCacheMisses int64
-	zoneStats := make([]bunny.BandwidthEntry, 0, len(zones))
-
-	for _, zone := range zones {
-		stats, err := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, from, to)
-		if err != nil {
-			s.logger.Warn("Failed to get stats for zone",
-				zap.Int64("zone_id", zone.ID),
-				zap.String("zone_name", zone.Name),
-				zap.Error(err))
-			continue
-		}
-
-		totalBandwidth += stats.TotalBandwidth
-		totalRequestsVal += stats.TotalRequests
-		totalCacheHits += stats.TotalCacheHits
-		totalCacheMisses += stats.TotalCacheMisses
-
-		zoneStats = append(zoneStats, bunny.BandwidthEntry{
-			ZoneID:    zone.ID,
-			ZoneName:  zone.Name,
-			Hostname:  zone.Name,
-			Bandwidth: stats.TotalBandwidth,
-			Requests:  stats.TotalRequests,
-			Date:      from,
-		})
-
-		// Store snapshot for comparison
-		if s.snapshotStore != nil {
-			snapshot := state.BandwidthSnapshot{
-				Timestamp:   time.Now(),
-				ZoneID:      zone.ID,
-				ZoneName:    zone.Name,
-				Bandwidth:   stats.TotalBandwidth,
-				Requests:    stats.TotalRequests,
-				CacheHits:   stats.TotalCacheHits,
-				CacheMisses: stats.TotalCacheMisses,
-			}
-			_ = s.snapshotStore.AddSnapshot(snapshot)
-		}
-	}
-
-	// Sort by bandwidth (descending)
-	sort.Slice(zoneStats, func(i, j int) bool {
-		return zoneStats[i].Bandwidth > zoneStats[j].Bandwidth
-	})
-
-	// Calculate cache hit rate
-	cacheHitRate := 0.0
-	totalRequestsCalc := totalCacheHits + totalCacheMisses
-	if totalRequestsCalc > 0 {
-		cacheHitRate = float64(totalCacheHits) / float64(totalRequestsCalc) * 100
-	}
-
 	// Get top N zones
 	topN := s.config.Telegram.Summary.IncludeTopBandwidth
 	if topN <= 0 {
 		topN = 5
 	}
-	if topN > len(zoneStats) {
-		topN = len(zoneStats)
+	if topN > len(report.Zones) {
+		topN = len(report.Zones)
 	}
 
 	// Build summary message
-	message := s.formatDailySummary(yesterday, totalBandwidth, totalRequestsVal, cacheHitRate, zoneStats[:topN])
+	var quotaSummary *notifier.QuotaSummary
+	if s.quota != nil && s.quota.Enabled() {
+		quotaSummary = quotaUsage(s.quota.GlobalUsage(), s.quota.Usage(), topN)
+	}
+	message := s.formatDailySummary(report.From, report.Bandwidth, report.Requests, report.CacheHitRate, report.Zones[:topN], errorZones(report.Failing, topN), quotaSummary, s.openIncidents())
+	if message == "" {
+		return
+	}
 
 	// Send notification
 	if s.notifier != nil && s.notifier.IsEnabled() {
-		if err := s.notifier.SendRaw(ctx, message); err != nil {
+		if err := s.notifier.SendRaw(ctx, notifier.CategorySummaries, message); err != nil {
 			s.logger.Error("Failed to send daily summary", zap.Error(err))
 		} else {
 			s.logger.Info("Daily summary sent successfully")
+			s.sendReport(ctx, report.Name, report.Zones, report.Bandwidth)
 		}
 	}
 }
@@ -269,314 +336,338 @@ func (s *Scheduler) runDailySummary(ctx context.Context) {
 func (s *Scheduler) runWeeklySummary(ctx context.Context) {
 	s.logger.Info("Running weekly summary")
 
-	// Get last week's date range
-	now := time.Now()
-	loc, err := s.getTimezone()
-	if err != nil {
-		s.logger.Error("Failed to get timezone", zap.Error(err))
-		loc = time.UTC
-	}
-	nowInLoc := now.In(loc)
-
-	// Find last Monday
-	weekday := nowInLoc.Weekday()
-	daysSinceMonday := (int(weekday) - 1 + 7) % 7
-	lastMonday := nowInLoc.AddDate(0, 0, -daysSinceMonday-7)
-	lastSunday := lastMonday.AddDate(0, 0, 6)
-
-	from := time.Date(lastMonday.Year(), lastMonday.Month(), lastMonday.Day(), 0, 0, 0, 0, loc)
-	to := time.Date(lastSunday.Year(), lastSunday.Month(), lastSunday.Day(), 23, 59, 59, 0, loc)
+	_, _, timeout := s.summaryLimits()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	// Get previous week for comparison
-	prevMonday := lastMonday.AddDate(0, 0, -7)
-	prevSunday := lastMonday.AddDate(0, 0, -1)
-	prevFrom := time.Date(prevMonday.Year(), prevMonday.Month(), prevMonday.Day(), 0, 0, 0, 0, loc)
-	prevTo := time.Date(prevSunday.Year(), prevSunday.Month(), prevSunday.Day(), 23, 59, 59, 0, loc)
-
-	// Get all pull zones
-	zones, err := s.bunnyClient.ListPullZones(ctx)
+	report, err := s.buildWeeklyReport(ctx)
 	if err != nil {
-		s.logger.Error("Failed to list pull zones for weekly summary", zap.Error(err))
+		s.logger.Error("Failed to build weekly summary", zap.Error(err))
 		return
 	}
 
-	// Collect stats for all zones (current week)
-	var totalBandwidth int64
-	var totalRequestsVal int64
-	var totalCacheHits int64
-	var totalCacheMisses int64
-	zoneStats := make([]bunny.BandwidthEntry, 0, len(zones))
-
-	// Previous week totals for comparison
-	var prevTotalBandwidth int64
-
-	for _, zone := range zones {
-		stats, err := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, from, to)
-		if err != nil {
-			s.logger.Warn("Failed to get stats for zone",
-				zap.Int64("zone_id", zone.ID),
-				zap.String("zone_name", zone.Name),
-				zap.Error(err))
-			continue
-		}
-
-		totalBandwidth += stats.TotalBandwidth
-		totalRequestsVal += stats.TotalRequests
-		totalCacheHits += stats.TotalCacheHits
-		totalCacheMisses += stats.TotalCacheMisses
-
-		zoneStats = append(zoneStats, bunny.BandwidthEntry{
-			ZoneID:    zone.ID,
-			ZoneName:  zone.Name,
-			Hostname:  zone.Name,
-			Bandwidth: stats.TotalBandwidth,
-			Requests:  stats.TotalRequests,
-			Date:      from,
-		})
-
-		// Get previous week stats for comparison
-		prevStats, errPrev := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, prevFrom, prevTo)
-		if errPrev == nil {
-			prevTotalBandwidth += prevStats.TotalBandwidth
-		}
-	}
-
-	// Sort by bandwidth (descending)
-	sort.Slice(zoneStats, func(i, j int) bool {
-		return zoneStats[i].Bandwidth > zoneStats[j].Bandwidth
-	})
-
-	// Calculate cache hit rate
-	cacheHitRate := 0.0
-	totalRequestsCalc := totalCacheHits + totalCacheMisses
-	if totalRequestsCalc > 0 {
-		cacheHitRate = float64(totalCacheHits) / float64(totalRequestsCalc) * 100
-	}
-
-	// Calculate bandwidth change
-	bandwidthChange := 0.0
-	if prevTotalBandwidth > 0 {
-		bandwidthChange = float64(totalBandwidth-prevTotalBandwidth) / float64(prevTotalBandwidth) * 100
-	}
-
 	// Get top N zones
 	topN := s.config.Telegram.Summary.IncludeTopBandwidth
 	if topN <= 0 {
 		topN = 10
 	}
-	if topN > len(zoneStats) {
-		topN = len(zoneStats)
+	dns := report.DNS.limit(topN)
+	if topN > len(report.Zones) {
+		topN = len(report.Zones)
 	}
 
-	// Get week number
-	_, weekNum := from.ISOWeek()
+	// Provisioning times of the week against the week before
+	provisioning := s.provisioningTimes(report.PreviousFrom, report.From, report.From.AddDate(0, 0, 7))
+	s.checkProvisioningDegradation(ctx, report.Week, report.From.Year(), provisioning)
+
+	failed := s.failureBreakdown(report.PreviousFrom, report.From, report.From.AddDate(0, 0, 7))
 
 	// Build summary message
-	message := s.formatWeeklySummary(weekNum, from.Year(), totalBandwidth, totalRequestsVal, cacheHitRate, bandwidthChange, zoneStats[:topN])
+	message := s.formatWeeklySummary(report.Week, report.From.Year(), report.Bandwidth, report.Requests, report.CacheHitRate, report.BandwidthChange, report.Zones[:topN], report.Regions, provisioning, failed, dns, report.Idle)
+	if message == "" {
+		return
+	}
 
 	// Send notification
 	if s.notifier != nil && s.notifier.IsEnabled() {
-		if err := s.notifier.SendRaw(ctx, message); err != nil {
+		if err := s.notifier.SendRaw(ctx, notifier.CategorySummaries, message); err != nil {
 			s.logger.Error("Failed to send weekly summary", zap.Error(err))
 		} else {
 			s.logger.Info("Weekly summary sent successfully")
+			s.sendReport(ctx, report.Name, report.Zones, report.Bandwidth)
 		}
 	}
 }
 
-// checkBandwidthAlerts checks for bandwidth spikes and sends alerts
-func (s *Scheduler) checkBandwidthAlerts(ctx context.Context) {
-	s.logger.Debug("Checking bandwidth alerts")
-
-	if s.snapshotStore == nil {
-		return
-	}
-
-	// Get alert threshold
-	threshold := float64(s.config.Telegram.Summary.BandwidthAlertThreshold)
-	if threshold <= 0 {
-		threshold = 50 // Default: 50%
+// templates returns the notifier's message templates
+func (s *Scheduler) templates() *notifier.Templates {
+	if s.notifier == nil {
+		return notifier.DefaultTemplates()
 	}
+	return s.notifier.Templates()
+}
 
-	// Get all pull zones
-	zones, err := s.bunnyClient.ListPullZones(ctx)
+// render renders a message template, logging and returning "" on failure
+func (s *Scheduler) render(name string, data any) string {
+	message, err := s.templates().Render(name, data)
 	if err != nil {
-		s.logger.Error("Failed to list pull zones for bandwidth check", zap.Error(err))
-		return
+		if s.logger != nil {
+			s.logger.Error("Failed to render message", zap.String("template", name), zap.Error(err))
+		}
+		return ""
 	}
+	return message
+}
 
-	// Check each zone for bandwidth spikes
-	now := time.Now()
-	loc, _ := s.getTimezone()
-	nowInLoc := now.In(loc)
+// base returns the common template fields
+func (s *Scheduler) base() notifier.MessageBase {
+	return notifier.MessageBase{Server: s.serverName(), Time: s.now()}
+}
 
-	// Current 24 hours
-	currentFrom := time.Date(nowInLoc.Year(), nowInLoc.Month(), nowInLoc.Day(), 0, 0, 0, 0, loc)
-	currentTo := nowInLoc
+// now returns the current time from the clock
+func (s *Scheduler) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
 
-	// Previous 24 hours
-	previousFrom := currentFrom.AddDate(0, 0, -1)
-	previousTo := currentFrom
+// formatDailySummary formats the daily summary message; failing,
+// quotaSummary and incidents are optional sections
+func (s *Scheduler) formatDailySummary(date time.Time, bandwidth, requests int64, cacheHitRate float64, topZones []bunny.BandwidthEntry, failing []notifier.ZoneErrors, quotaSummary *notifier.QuotaSummary, incidents []notifier.OpenIncident) string {
+	return s.render(notifier.TemplateDailySummary, notifier.DailySummaryMessage{
+		MessageBase:    s.base(),
+		Date:           date,
+		Bandwidth:      bandwidth,
+		Requests:       requests,
+		CacheHitRate:   cacheHitRate,
+		TopZones:       zoneUsage(topZones, bandwidth, s.zoneOwners()),
+		ErrorThreshold: errorRateAlert,
+		ErrorZones:     failing,
+		Quota:          quotaSummary,
+		Incidents:      incidents,
+	})
+}
 
-	for _, zone := range zones {
-		// Get current stats
-		currentStats, err := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, currentFrom, currentTo)
-		if err != nil {
-			continue
+// zoneOwners maps pull zone IDs to the WHM users owning them
+func (s *Scheduler) zoneOwners() map[int64]string {
+	owners := make(map[int64]string)
+	if s.states == nil {
+		return owners
+	}
+	for _, st := range s.states.ListAll() {
+		if st.PullZoneID > 0 && st.User != "" {
+			owners[st.PullZoneID] = st.User
 		}
+	}
+	return owners
+}
 
-		// Get previous stats from snapshot store
-		snapshots := s.snapshotStore.GetSnapshotsByZone(zone.ID, previousFrom)
-		var previousBandwidth int64
-		for _, snap := range snapshots {
-			previousBandwidth += snap.Bandwidth
+// zoneUsage converts bandwidth entries to template data with each zone's
+// share of the total and owner
+func zoneUsage(zones []bunny.BandwidthEntry, total int64, owners map[int64]string) []notifier.ZoneUsage {
+	usage := make([]notifier.ZoneUsage, 0, len(zones))
+	for _, zone := range zones {
+		share := 0.0
+		if total > 0 {
+			share = float64(zone.Bandwidth) / float64(total) * 100
 		}
+		usage = append(usage, notifier.ZoneUsage{
+			Name:      zone.ZoneName,
+			User:      owners[zone.ZoneID],
+			Bandwidth: zone.Bandwidth,
+			Share:     share,
+		})
+	}
+	return usage
+}
 
-		// If no previous snapshot, try to get from API
-		if previousBandwidth == 0 {
-			prevStats, errPrev := bunny.GetPullZoneStats(ctx, s.bunnyClient, zone.ID, previousFrom, previousTo)
-			if errPrev == nil {
-				previousBandwidth = prevStats.TotalBandwidth
-			}
-		}
+// quotaUsage builds the provisioning quota section of the daily summary
+func quotaUsage(global quota.Usage, owners []quota.Usage, limit int) *notifier.QuotaSummary {
+	if len(owners) > limit {
+		owners = owners[:limit]
+	}
 
-		// Calculate percentage increase
-		if previousBandwidth > 0 {
-			percentIncrease := float64(currentStats.TotalBandwidth-previousBandwidth) / float64(previousBandwidth) * 100
-
-			if percentIncrease >= threshold {
-				s.logger.Warn("Bandwidth spike detected",
-					zap.Int64("zone_id", zone.ID),
-					zap.String("zone_name", zone.Name),
-					zap.Float64("increase", percentIncrease))
-
-				// Send alert
-				message := s.formatBandwidthAlert(zone.Name, currentStats.TotalBandwidth, previousBandwidth, percentIncrease)
-				if s.notifier != nil && s.notifier.IsEnabled() {
-					_ = s.notifier.SendRaw(ctx, message)
-				}
-			}
-		}
+	summary := &notifier.QuotaSummary{Global: quotaLine(global)}
+	for _, u := range owners {
+		summary.Owners = append(summary.Owners, quotaLine(u))
 	}
+	return summary
 }
 
-// formatDailySummary formats the daily summary message
-func (s *Scheduler) formatDailySummary(date time.Time, bandwidth, requests int64, cacheHitRate float64, topZones []bunny.BandwidthEntry) string {
-	hostname := s.getHostname()
-	dateStr := date.Format("Jan 2, 2006")
-	bandwidthGB := float64(bandwidth) / (1024 * 1024 * 1024)
+// quotaLine converts quota usage to template data
+func quotaLine(u quota.Usage) notifier.QuotaUsage {
+	return notifier.QuotaUsage{
+		Owner:        u.Owner,
+		Daily:        u.Daily,
+		DailyLimit:   u.DailyLimit,
+		Monthly:      u.Monthly,
+		MonthlyLimit: u.MonthlyLimit,
+	}
+}
 
-	message := fmt.Sprintf("📊 <b>Daily Summary</b> - %s\n\n📈 <b>Total Bandwidth:</b> %.2f GB\n📈 <b>Total Requests:</b> %s\n📈 <b>Cache Hit Rate:</b> %.1f%%\n\n🔝 <b>Top %d Domains:</b>",
-		dateStr,
-		bandwidthGB,
-		formatNumber(requests),
-		cacheHitRate,
-		len(topZones),
-	)
+// zoneErrors pairs a pull zone name with its error statistics
+type zoneErrors struct {
+	Name  string
+	Stats bunny.ErrorStats
+}
 
-	totalBandwidthFloat := float64(bandwidth)
-	for i, zone := range topZones {
-		percentage := 0.0
-		if totalBandwidthFloat > 0 {
-			percentage = float64(zone.Bandwidth) / totalBandwidthFloat * 100
-		}
-		message += fmt.Sprintf("\n%d. %s - %.2f GB (%.0f%%)",
-			i+1,
-			zone.ZoneName,
-			float64(zone.Bandwidth)/(1024*1024*1024),
-			percentage,
-		)
+// errorZones returns the pull zones with a high 5xx rate, worst first,
+// marking whether the errors point at the origin or at the CDN edge
+func errorZones(zones []zoneErrors, limit int) []notifier.ZoneErrors {
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].Stats.Rate5xx() > zones[j].Stats.Rate5xx()
+	})
+	if len(zones) > limit {
+		zones = zones[:limit]
+	}
+
+	result := make([]notifier.ZoneErrors, 0, len(zones))
+	for _, z := range zones {
+		result = append(result, notifier.ZoneErrors{
+			Name:               z.Name,
+			Rate:               z.Stats.Rate5xx(),
+			Errors:             z.Stats.Status5xx,
+			Requests:           z.Stats.TotalRequests,
+			OriginSide:         z.Stats.OriginSide(),
+			OriginResponseTime: z.Stats.AverageOriginResponseTime,
+		})
 	}
+	return result
+}
 
-	message += fmt.Sprintf("\n\n🖥️ <b>Server:</b> %s", hostname)
+// provisioningTimes returns the provisioning time percentiles of the week
+// starting at from, compared with the week starting at prevFrom; nil when
+// times are not recorded
+func (s *Scheduler) provisioningTimes(prevFrom, from, to time.Time) *notifier.ProvisioningTimes {
+	if s.slo == nil {
+		return nil
+	}
 
-	return message
+	target := s.config.SLO.Target
+	current := s.slo.Stats(from, to, target)
+	previous := s.slo.Stats(prevFrom, from, target)
+	return &notifier.ProvisioningTimes{
+		Runs:        current.Runs,
+		P50:         current.P50,
+		P95:         current.P95,
+		PreviousP95: previous.P95,
+		Change:      slo.Change(current.P95, previous.P95),
+		Slow:        current.Slow,
+		Target:      target,
+
+		Recovered:         current.Recovered,
+		PreviousRecovered: previous.Recovered,
+		AvgAttempts:       current.AvgAttempts,
+		MaxAttempts:       current.MaxAttempts,
+		RecoveryP50:       current.RecoveryP50,
+	}
 }
 
-// formatWeeklySummary formats the weekly summary message
-func (s *Scheduler) formatWeeklySummary(weekNum, year int, bandwidth, requests int64, cacheHitRate, bandwidthChange float64, topZones []bunny.BandwidthEntry) string {
-	hostname := s.getHostname()
-	bandwidthGB := float64(bandwidth) / (1024 * 1024 * 1024)
-
-	changeStr := ""
-	if bandwidthChange > 0 {
-		changeStr = fmt.Sprintf("+%.0f%%", bandwidthChange)
-	} else if bandwidthChange < 0 {
-		changeStr = fmt.Sprintf("%.0f%%", bandwidthChange)
-	} else {
-		changeStr = "0%"
-	}
-
-	message := fmt.Sprintf("📊 <b>Weekly Summary</b> - Week %d, %d\n\n📈 <b>Total Bandwidth:</b> %.2f GB\n📈 <b>Total Requests:</b> %s\n📈 <b>Avg Cache Hit Rate:</b> %.1f%%\n📈 <b>Bandwidth Change:</b> %s vs last week\n\n🔝 <b>Top %d Domains:</b>",
-		weekNum,
-		year,
-		bandwidthGB,
-		formatNumber(requests),
-		cacheHitRate,
-		changeStr,
-		len(topZones),
-	)
-
-	totalBandwidthFloat := float64(bandwidth)
-	for i, zone := range topZones {
-		percentage := 0.0
-		if totalBandwidthFloat > 0 {
-			percentage = float64(zone.Bandwidth) / totalBandwidthFloat * 100
-		}
-		message += fmt.Sprintf("\n%d. %s - %.2f GB (%.0f%%)",
-			i+1,
-			zone.ZoneName,
-			float64(zone.Bandwidth)/(1024*1024*1024),
-			percentage,
-		)
+// failureBreakdown returns the failed runs of the week starting at from by
+// error class, with the counts of the week starting at prevFrom; nil when
+// failures are not recorded or there were none
+func (s *Scheduler) failureBreakdown(prevFrom, from, to time.Time) []notifier.FailureCount {
+	if s.failures == nil {
+		return nil
 	}
 
-	message += fmt.Sprintf("\n\n🖥️ <b>Server:</b> %s", hostname)
+	previous := make(map[state.ErrorClass]int)
+	for _, c := range s.failures.Breakdown(prevFrom, from) {
+		previous[c.Class] = c.Failures
+	}
 
-	return message
+	var result []notifier.FailureCount
+	for _, c := range s.failures.Breakdown(from, to) {
+		result = append(result, notifier.FailureCount{
+			Class:    string(c.Class),
+			Failures: c.Failures,
+			Previous: previous[c.Class],
+		})
+	}
+	return result
 }
 
-// formatBandwidthAlert formats the bandwidth alert message
-func (s *Scheduler) formatBandwidthAlert(domain string, current, previous int64, percentIncrease float64) string {
-	hostname := s.getHostname()
-	currentGB := float64(current) / (1024 * 1024 * 1024)
-	previousGB := float64(previous) / (1024 * 1024 * 1024)
+// checkProvisioningDegradation alerts when the p95 provisioning time rose
+// by slo.degradation percent or more over the previous week
+func (s *Scheduler) checkProvisioningDegradation(ctx context.Context, weekNum, year int, times *notifier.ProvisioningTimes) {
+	threshold := s.config.SLO.Degradation
+	if times == nil || times.Runs == 0 || times.PreviousP95 == 0 || threshold <= 0 || times.Change < threshold {
+		return
+	}
 
-	return fmt.Sprintf(`⚠️ <b>Bandwidth Alert</b>
+	s.logger.Warn("Provisioning time degraded",
+		zap.Duration("p95", times.P95),
+		zap.Duration("previous_p95", times.PreviousP95),
+		zap.Float64("change", times.Change))
 
-🌐 <b>Domain:</b> %s
-📈 <b>Increase:</b> %.0f%% in last 24 hours
-📊 <b>Current:</b> %.2f GB/day
-📊 <b>Previous:</b> %.2f GB/day
+	if s.notifier != nil && s.notifier.IsEnabled() {
+		if err := s.notifier.NotifySLODegraded(ctx, weekNum, year, times.Runs, times.P95, times.PreviousP95, times.Change, threshold); err != nil {
+			s.logger.Error("Failed to send provisioning time alert", zap.Error(err))
+		}
+	}
+}
 
-🖥️ <b>Server:</b> %s`,
-		domain,
-		percentIncrease,
-		currentGB,
-		previousGB,
-		hostname,
-	)
+// formatWeeklySummary formats the weekly summary message; regions,
+// provisioning, failed, dns and idleDomains are optional
+func (s *Scheduler) formatWeeklySummary(weekNum, year int, bandwidth, requests int64, cacheHitRate, bandwidthChange float64, topZones []bunny.BandwidthEntry, regions []bunny.RegionTraffic, provisioning *notifier.ProvisioningTimes, failed []notifier.FailureCount, dns *dnsQueries, idleDomains []idle.Domain) string {
+	usage, outside := regionUsage(regions, topRegions)
+	msg := notifier.WeeklySummaryMessage{
+		MessageBase:     s.base(),
+		Week:            weekNum,
+		Year:            year,
+		Bandwidth:       bandwidth,
+		Requests:        requests,
+		CacheHitRate:    cacheHitRate,
+		BandwidthChange: bandwidthChange,
+		TopZones:        zoneUsage(topZones, bandwidth, s.zoneOwners()),
+		Regions:         usage,
+		OutsideAsia:     outside,
+		Provisioning:    provisioning,
+		Failures:        failed,
+	}
+	if dns != nil {
+		msg.DNSQueries = dns.Total
+		msg.TopDNSZones = dns.usage()
+	}
+	if len(idleDomains) > 0 {
+		msg.IdleDays = s.config.Telegram.Summary.IdleDays
+		msg.IdleCount = len(idleDomains)
+		msg.IdleDomains = idleUsage(idleDomains, topIdleDomains)
+	}
+	return s.render(notifier.TemplateWeeklySummary, msg)
 }
 
-// getHostname returns the server hostname
-func (s *Scheduler) getHostname() string {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return "unknown"
+// idleUsage converts the first limit idle domains to the summary's lines
+func idleUsage(domains []idle.Domain, limit int) []notifier.IdleDomain {
+	if len(domains) > limit {
+		domains = domains[:limit]
+	}
+	usage := make([]notifier.IdleDomain, 0, len(domains))
+	for _, d := range domains {
+		usage = append(usage, notifier.IdleDomain{Name: d.Domain, User: d.User, Requests: d.Requests})
 	}
-	return hostname
+	return usage
 }
 
-// formatNumber formats a large number with K/M/B suffixes
-func formatNumber(n int64) string {
-	if n >= 1_000_000_000 {
-		return fmt.Sprintf("%.2fB", float64(n)/1_000_000_000)
+// regionUsage returns the regions bandwidth was served from, largest first,
+// and the share served outside the Asia+Oceania geo zone
+func regionUsage(regions []bunny.RegionTraffic, limit int) ([]notifier.RegionUsage, float64) {
+	var outside float64
+	for _, r := range regions {
+		if !bunny.IsAsiaRegion(r.Region) {
+			outside += r.Share
+		}
 	}
-	if n >= 1_000_000 {
-		return fmt.Sprintf("%.2fM", float64(n)/1_000_000)
+	if len(regions) > limit {
+		regions = regions[:limit]
+	}
+
+	usage := make([]notifier.RegionUsage, 0, len(regions))
+	for _, r := range regions {
+		usage = append(usage, notifier.RegionUsage{Name: r.Region, Bandwidth: r.Bandwidth, Share: r.Share})
 	}
-	if n >= 1_000 {
-		return fmt.Sprintf("%.2fK", float64(n)/1_000)
+	return usage, outside
+}
+
+// formatBandwidthAlert formats the bandwidth alert message; incidentID is
+// empty when incidents are not tracked
+func (s *Scheduler) formatBandwidthAlert(domain string, current, previous int64, percentIncrease float64, incidentID string) string {
+	return s.render(notifier.TemplateBandwidth, notifier.BandwidthMessage{
+		MessageBase: s.base(),
+		Domain:      domain,
+		Increase:    percentIncrease,
+		Current:     current,
+		Previous:    previous,
+		Incident:    incidentID,
+	})
+}
+
+// serverName returns the configured name of this instance, or else the
+// hostname
+func (s *Scheduler) serverName() string {
+	if s.config == nil {
+		return (&config.Config{}).InstanceName()
 	}
-	return fmt.Sprintf("%d", n)
+	return s.config.InstanceName()
 }
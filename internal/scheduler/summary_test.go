@@ -1,6 +1,8 @@
 package scheduler
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -8,7 +10,10 @@ import (
 
 	"github.com/mordenhost/whm2bunny/config"
 	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/failures"
 	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/quota"
+	"github.com/mordenhost/whm2bunny/internal/slo"
 	"github.com/mordenhost/whm2bunny/internal/state"
 )
 
@@ -132,34 +137,56 @@ func TestScheduler_Stop(t *testing.T) {
 	}
 }
 
-func TestFormatNumber(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    int64
-		expected string
-	}{
-		{"billions", 1_500_000_000, "1.50B"},
-		{"millions", 2_500_000, "2.50M"},
-		{"thousands", 12_500, "12.50K"},
-		{"hundreds", 500, "500"},
-		{"zero", 0, "0"},
+func TestScheduler_NextRuns(t *testing.T) {
+	cfg := &config.Config{
+		Telegram: config.TelegramConfig{
+			Summary: config.TelegramSummaryConfig{
+				Enabled:        true,
+				Schedule:       "0 9 * * *",
+				WeeklySchedule: "0 9 * * 1",
+				Timezone:       "UTC",
+			},
+		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := formatNumber(tt.input)
-			if result != tt.expected {
-				t.Errorf("formatNumber(%d) = %s, want %s", tt.input, result, tt.expected)
-			}
-		})
+	logger := zap.NewNop()
+	bunnyClient := bunny.NewClient("test-key")
+	snapshotStore, _ := state.NewSnapshotStore("/tmp/test-snapshots.json", logger)
+	telegramNotifier, _ := notifier.NewTelegramNotifier("", "", false, nil, logger)
+
+	scheduler := NewScheduler(cfg, bunnyClient, telegramNotifier, snapshotStore, logger)
+
+	if runs := scheduler.NextRuns(); len(runs) != 0 {
+		t.Errorf("Expected no next runs before start, got %v", runs)
+	}
+
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("Expected no error when starting scheduler, got %v", err)
+	}
+	defer scheduler.Stop()
+
+	runs := scheduler.NextRuns()
+	next, ok := runs["daily_summary"]
+	if !ok {
+		t.Fatalf("Expected daily_summary in next runs, got %v", runs)
+	}
+	if next.Hour() != 9 || next.Minute() != 0 {
+		t.Errorf("Expected daily summary at 09:00, got %s", next)
+	}
+	if _, ok := runs["weekly_summary"]; !ok {
+		t.Errorf("Expected weekly_summary in next runs, got %v", runs)
 	}
 }
 
-func TestGetHostname(t *testing.T) {
-	s := &Scheduler{}
-	hostname := s.getHostname()
-	if hostname == "" {
-		t.Error("Expected non-empty hostname")
+func TestServerName(t *testing.T) {
+	s := &Scheduler{config: &config.Config{}}
+	if s.serverName() == "" {
+		t.Error("Expected the hostname without an instance name")
+	}
+
+	s.config.Instance = "cpanel-07"
+	if got := s.base().Server; got != "cpanel-07" {
+		t.Errorf("Expected server cpanel-07, got %q", got)
 	}
 }
 
@@ -208,7 +235,7 @@ func TestFormatDailySummary(t *testing.T) {
 		{ZoneName: "test.com", Bandwidth: 30 * 1024 * 1024 * 1024},
 	}
 
-	message := s.formatDailySummary(date, 75*1024*1024*1024, 1_200_000, 94.5, topZones)
+	message := s.formatDailySummary(date, 75*1024*1024*1024, 1_200_000, 94.5, topZones, nil, nil, nil)
 
 	if message == "" {
 		t.Error("Expected non-empty message")
@@ -234,7 +261,7 @@ func TestFormatWeeklySummary(t *testing.T) {
 		{ZoneName: "test.com", Bandwidth: 200 * 1024 * 1024 * 1024},
 	}
 
-	message := s.formatWeeklySummary(8, 2024, 875*1024*1024*1024, 8_400_000, 93.2, 15.0, topZones)
+	message := s.formatWeeklySummary(8, 2024, 875*1024*1024*1024, 8_400_000, 93.2, 15.0, topZones, nil, nil, nil, nil, nil)
 
 	if message == "" {
 		t.Error("Expected non-empty message")
@@ -255,7 +282,7 @@ func TestFormatWeeklySummary(t *testing.T) {
 func TestFormatBandwidthAlert(t *testing.T) {
 	s := &Scheduler{}
 
-	message := s.formatBandwidthAlert("example.com", 45*1024*1024*1024, 25*1024*1024*1024, 75.0)
+	message := s.formatBandwidthAlert("example.com", 45*1024*1024*1024, 25*1024*1024*1024, 75.0, "")
 
 	if message == "" {
 		t.Error("Expected non-empty message")
@@ -272,6 +299,173 @@ func TestFormatBandwidthAlert(t *testing.T) {
 	}
 }
 
+func TestFormatDailySummary_Quota(t *testing.T) {
+	global := quota.Usage{Owner: quota.GlobalOwner, Daily: 3, DailyLimit: 100, Monthly: 40}
+	owners := []quota.Usage{
+		{Owner: "reseller1", Daily: 2, DailyLimit: 20, Monthly: 30, MonthlyLimit: 200},
+		{Owner: "alice", Daily: 1, Monthly: 10},
+	}
+
+	s := &Scheduler{}
+	message := s.formatDailySummary(time.Now(), 0, 0, 0, nil, nil, quotaUsage(global, owners, 1), nil)
+
+	if !contains(message, "Provisioning Quota") {
+		t.Error("Expected 'Provisioning Quota' in message")
+	}
+	if !contains(message, "3/100 today, 40 this month") {
+		t.Error("Expected global usage in message")
+	}
+	if !contains(message, "reseller1 - 2/20 today, 30/200 this month") {
+		t.Error("Expected reseller usage in message")
+	}
+	if contains(message, "alice") {
+		t.Error("Expected owners beyond the limit to be omitted")
+	}
+}
+
+func TestFormatDailySummary_Errors(t *testing.T) {
+	zones := []zoneErrors{
+		{Name: "edge-zone", Stats: bunny.ErrorStats{TotalRequests: 1000, OriginRequests: 10, Status5xx: 50}},
+		{Name: "origin-zone", Stats: bunny.ErrorStats{TotalRequests: 1000, OriginRequests: 400, Status5xx: 100}},
+		{Name: "minor-zone", Stats: bunny.ErrorStats{TotalRequests: 1000, OriginRequests: 400, Status5xx: 20}},
+	}
+
+	s := &Scheduler{}
+	message := s.formatDailySummary(time.Now(), 0, 0, 0, nil, errorZones(zones, 2), nil, nil)
+
+	if !contains(message, "5xx Errors") {
+		t.Error("Expected '5xx Errors' in message")
+	}
+	if !contains(message, "origin-zone - 10.0%") || !contains(message, "likely origin") {
+		t.Error("Expected origin-side zone in message")
+	}
+	if !contains(message, "edge-zone - 5.0%") || !contains(message, "likely CDN edge") {
+		t.Error("Expected edge-side zone in message")
+	}
+	if contains(message, "minor-zone") {
+		t.Error("Expected zones beyond the limit to be omitted")
+	}
+	if strings.Index(message, "origin-zone") > strings.Index(message, "edge-zone") {
+		t.Error("Expected zones sorted by 5xx rate")
+	}
+}
+
+func TestFormatWeeklySummary_Regions(t *testing.T) {
+	gb := int64(1024 * 1024 * 1024)
+	regions := bunny.GroupByRegion([]bunny.GeoTraffic{
+		{Region: "ASIA", Location: "Singapore, SG", Bandwidth: 6 * gb},
+		{Region: "AS", Location: "Jakarta, ID", Bandwidth: 2 * gb},
+		{Region: "EU", Location: "Frankfurt, DE", Bandwidth: 2 * gb},
+	})
+
+	usage, outside := regionUsage(regions, 1)
+	if len(usage) != 1 {
+		t.Fatalf("Expected regions beyond the limit to be omitted, got %d", len(usage))
+	}
+	if outside != 20 {
+		t.Errorf("Expected 20%% outside Asia, got %.1f", outside)
+	}
+
+	s := &Scheduler{}
+	message := s.formatWeeklySummary(3, 2024, 10*gb, 0, 0, 0, nil, regions, nil, nil, nil, nil)
+
+	if !contains(message, "Top Regions") {
+		t.Error("Expected 'Top Regions' in message")
+	}
+	if !contains(message, "Asia & Oceania - 8.00 GB (80%)") {
+		t.Errorf("Expected merged Asia traffic in message, got %q", message)
+	}
+	if !contains(message, "Europe - 2.00 GB (20%)") {
+		t.Error("Expected Europe traffic in message")
+	}
+	if !contains(message, "20.0% served outside Asia+Oceania") {
+		t.Error("Expected share outside Asia in message")
+	}
+}
+
+func TestProvisioningTimes(t *testing.T) {
+	s := &Scheduler{config: &config.Config{SLO: config.SLOConfig{Target: 2 * time.Minute}}}
+	// Within the store's retention
+	from := time.Now().AddDate(0, 0, -8).Truncate(24 * time.Hour)
+	if s.provisioningTimes(from.AddDate(0, 0, -7), from, from.AddDate(0, 0, 7)) != nil {
+		t.Error("Expected no provisioning times without a store")
+	}
+
+	store, err := slo.NewStore(t.TempDir()+"/provision_times.json", nil)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	for _, run := range []slo.Run{
+		{Domain: "last-week.com", FinishedAt: from.AddDate(0, 0, -3), Duration: time.Minute},
+		{Domain: "a.com", FinishedAt: from.Add(time.Hour), Duration: 30 * time.Second},
+		{Domain: "b.com", FinishedAt: from.AddDate(0, 0, 6), Duration: 3 * time.Minute},
+		{Domain: "next-week.com", FinishedAt: from.AddDate(0, 0, 7), Duration: time.Hour},
+	} {
+		if err := store.Record(run); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	s.SetSLO(store)
+
+	times := s.provisioningTimes(from.AddDate(0, 0, -7), from, from.AddDate(0, 0, 7))
+	if times.Runs != 2 || times.P50 != 30*time.Second || times.P95 != 3*time.Minute {
+		t.Errorf("Expected 2 runs with p50 30s and p95 3m, got %+v", times)
+	}
+	if times.PreviousP95 != time.Minute || times.Change != 200 || times.Slow != 1 {
+		t.Errorf("Expected p95 up 200%% from 1m with 1 slow run, got %+v", times)
+	}
+
+	message := s.formatWeeklySummary(3, 2024, 0, 0, 0, 0, nil, nil, times, nil, nil, nil)
+	if !contains(message, "p50 30.0s, p95 3m0s (+200% vs last week)") {
+		t.Errorf("Expected provisioning percentiles in message, got %q", message)
+	}
+	if !contains(message, "1 slow (over 2m0s)") {
+		t.Error("Expected slow runs in message")
+	}
+}
+
+func TestFailureBreakdown(t *testing.T) {
+	s := &Scheduler{config: &config.Config{}}
+	from := time.Now().AddDate(0, 0, -8).Truncate(24 * time.Hour)
+	if s.failureBreakdown(from.AddDate(0, 0, -7), from, from.AddDate(0, 0, 7)) != nil {
+		t.Error("Expected no breakdown without a store")
+	}
+
+	store, err := failures.NewStore(t.TempDir()+"/failures.json", nil)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	for _, f := range []struct {
+		class state.ErrorClass
+		at    time.Time
+	}{
+		{state.ErrorClassRateLimit, from.AddDate(0, 0, -3)},
+		{state.ErrorClassRateLimit, from.Add(time.Hour)},
+		{state.ErrorClassRateLimit, from.AddDate(0, 0, 2)},
+		{state.ErrorClassAuth, from.AddDate(0, 0, 6)},
+		{state.ErrorClassAuth, from.AddDate(0, 0, 7)},
+	} {
+		if err := store.Record(f.class, f.at); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	s.SetFailures(store)
+
+	failed := s.failureBreakdown(from.AddDate(0, 0, -7), from, from.AddDate(0, 0, 7))
+	want := []notifier.FailureCount{
+		{Class: "rate_limit", Failures: 2, Previous: 1},
+		{Class: "auth", Failures: 1},
+	}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("Expected %+v, got %+v", want, failed)
+	}
+
+	message := s.formatWeeklySummary(3, 2024, 0, 0, 0, 0, nil, nil, nil, failed, nil, nil)
+	if !contains(message, "Failure Breakdown:</b>\n• rate_limit - 2 (1 last week)\n• auth - 1 (0 last week)") {
+		t.Errorf("Expected failure breakdown in message, got %q", message)
+	}
+}
+
 func contains(s, substr string) bool {
 	for i := 0; i <= len(s)-len(substr); i++ {
 		if s[i:i+len(substr)] == substr {
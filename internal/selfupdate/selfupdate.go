@@ -0,0 +1,396 @@
+// Package selfupdate replaces the whm2bunny binary with the one of a
+// release, after checking the archive against the release checksums and
+// the checksums against their signature
+package selfupdate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+const (
+	// ChecksumsFile lists the SHA-256 of each archive of a release, in
+	// sha256sum format
+	ChecksumsFile = "checksums.sha256"
+	// SignatureFile is the Ed25519 signature of ChecksumsFile, raw or
+	// base64 encoded
+	SignatureFile = ChecksumsFile + ".sig"
+
+	// binaryName is the name of the binary in the release archives
+	binaryName = "whm2bunny"
+	// maxAssetBytes bounds the downloads
+	maxAssetBytes = 100 << 20
+	// checkTimeout bounds the run of a new binary before it is installed
+	checkTimeout = 10 * time.Second
+)
+
+var (
+	// ErrChecksum is returned when an archive does not match its checksum
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrSignature is returned when the checksums are not signed with the
+	// configured key
+	ErrSignature = errors.New("invalid signature")
+	// ErrUnsigned is returned when there is no key to check the signature
+	// with and unsigned releases are not allowed
+	ErrUnsigned = errors.New("no public key to check the release signature with")
+)
+
+// Asset returns the name of the release archive of a platform
+func Asset(goos, goarch string) string {
+	return fmt.Sprintf("%s-%s-%s.tar.gz", binaryName, goos, goarch)
+}
+
+// ParsePublicKey parses an Ed25519 public key, base64 encoded or as a PEM
+// PUBLIC KEY block such as "openssl pkey -pubout" writes
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 key")
+		}
+		return edKey, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Release is the binary of a release, checked against its checksum
+type Release struct {
+	Asset    string
+	Checksum string
+	// Signed is set when the checksums were checked against their signature
+	Signed bool
+	Binary []byte
+}
+
+// Updater downloads releases from a URL holding the release archives and
+// their checksums, such as a GitHub releases/latest/download URL
+type Updater struct {
+	url           string
+	publicKey     ed25519.PublicKey
+	allowUnsigned bool
+	client        *http.Client
+}
+
+// New creates an updater for the releases at url whose checksums must be
+// signed with publicKey. The checksums come from the same place as the
+// archives, so without a key they prove nothing against a compromised
+// source and Fetch refuses the release, unless AllowUnsigned was called
+func New(url string, publicKey ed25519.PublicKey, client *http.Client) *Updater {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Updater{
+		url:       strings.TrimSuffix(url, "/"),
+		publicKey: publicKey,
+		client:    client,
+	}
+}
+
+// AllowUnsigned lets an updater without a public key fetch releases
+// checked against their checksums only
+func (u *Updater) AllowUnsigned() {
+	u.allowUnsigned = true
+}
+
+// Fetch downloads the release of a platform and checks it
+func (u *Updater) Fetch(ctx context.Context, goos, goarch string) (*Release, error) {
+	if u.publicKey == nil && !u.allowUnsigned {
+		return nil, ErrUnsigned
+	}
+
+	checksums, err := u.get(ctx, ChecksumsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := false
+	if u.publicKey != nil {
+		sig, err := u.get(ctx, SignatureFile)
+		if err != nil {
+			return nil, err
+		}
+		if !ed25519.Verify(u.publicKey, checksums, decodeSignature(sig)) {
+			return nil, fmt.Errorf("%s: %w", SignatureFile, ErrSignature)
+		}
+		signed = true
+	}
+
+	asset := Asset(goos, goarch)
+	want, ok := parseChecksums(checksums)[asset]
+	if !ok {
+		return nil, fmt.Errorf("%s does not list %s", ChecksumsFile, asset)
+	}
+
+	archive, err := u.get(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("%s: %w: expected %s, got %s", asset, ErrChecksum, want, got)
+	}
+
+	binary, err := extractBinary(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", asset, err)
+	}
+	return &Release{Asset: asset, Checksum: want, Signed: signed, Binary: binary}, nil
+}
+
+// get downloads a file of the release
+func (u *Updater) get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", name, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if len(data) > maxAssetBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxAssetBytes)
+	}
+	return data, nil
+}
+
+// decodeSignature returns a signature sent raw or base64 encoded
+func decodeSignature(sig []byte) []byte {
+	if len(sig) == ed25519.SignatureSize {
+		return sig
+	}
+	if raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		return raw
+	}
+	return sig
+}
+
+// parseChecksums maps file names to their checksum in sha256sum output
+func parseChecksums(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// Binary mode marks names with *
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums
+}
+
+// extractBinary returns the whm2bunny binary of a release archive
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s binary in the archive", binaryName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == binaryName {
+			return io.ReadAll(io.LimitReader(tr, maxAssetBytes))
+		}
+	}
+}
+
+// Installed reports whether the executable at path is binary
+func Installed(path string, binary []byte) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(current, binary), nil
+}
+
+// Version returns the version the binary reports, such as v1.4.0; it is
+// run from a temp file next to the executable at path, since the system
+// temp directory may not allow running files
+func Version(path string, binary []byte) (string, error) {
+	tmp, err := writeTemp(path, binary, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	out, err := runVersion(tmp)
+	if err != nil {
+		return "", err
+	}
+	// The first line is "whm2bunny <version>"
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != binaryName {
+		return "", fmt.Errorf("the new binary reports no version: %q", line)
+	}
+	return fields[1], nil
+}
+
+// Older reports whether version precedes than, both as vMAJOR.MINOR.PATCH
+// with an optional pre-release suffix. ok is false when either cannot be
+// compared, e.g. for a development build
+func Older(version, than string) (older, ok bool) {
+	a, aPre, aOK := parseVersion(version)
+	b, bPre, bOK := parseVersion(than)
+	if !aOK || !bOK {
+		return false, false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i], true
+		}
+	}
+	// A pre-release precedes its release
+	switch {
+	case aPre == bPre:
+		return false, true
+	case aPre == "":
+		return false, true
+	case bPre == "":
+		return true, true
+	}
+	return aPre < bPre, true
+}
+
+// parseVersion splits v1.4.0-rc1 into its numbers and pre-release suffix
+func parseVersion(v string) ([3]int, string, bool) {
+	var nums [3]int
+	v, pre, _ := strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	parts := strings.Split(v, ".")
+	if len(parts) != len(nums) {
+		return nums, "", false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
+
+// Install replaces the executable at path with binary. The binary is
+// written next to it and must run "version" before it is renamed over it,
+// so the swap is atomic and a binary for the wrong platform is never
+// installed. The previous binary is kept as path.old
+func Install(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := writeTemp(path, binary, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if _, err := runVersion(tmp); err != nil {
+		return err
+	}
+
+	old := path + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to keep the previous binary: %w", err)
+	}
+	if err := os.Link(path, old); err != nil {
+		return fmt.Errorf("failed to keep the previous binary: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace the binary: %w", err)
+	}
+	return filestore.SyncDir(filepath.Dir(path))
+}
+
+// writeTemp writes binary to a temp file next to path and returns its path
+func writeTemp(path string, binary []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write the new binary: %w", err)
+	}
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// runVersion runs "version" with the binary at path and returns its output
+func runVersion(path string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("the new binary does not run: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
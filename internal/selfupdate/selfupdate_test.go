@@ -0,0 +1,213 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// script is a stand-in binary that runs "version"
+const script = "#!/bin/sh\necho whm2bunny v2\n"
+
+// archive returns a release archive holding binary as the release
+// workflow packs it
+func archive(t *testing.T, binary string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := []struct{ name, body string }{
+		{"release-linux-amd64/config.yaml.example", "logging: {}\n"},
+		{"release-linux-amd64/whm2bunny", binary},
+	}
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0755, Size: int64(len(f.body)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(f.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// serveRelease serves the files of a release
+func serveRelease(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/releases/latest/download/"
+}
+
+func checksums(name string, data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return []byte(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+}
+
+func TestFetch(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	asset := Asset("linux", "amd64")
+	tarball := archive(t, script)
+	sums := append([]byte("0000  whm2bunny-darwin-arm64.tar.gz\n"), checksums(asset, tarball)...)
+	url := serveRelease(t, map[string][]byte{
+		asset:         tarball,
+		ChecksumsFile: sums,
+		SignatureFile: []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sums))),
+	})
+
+	release, err := New(url, pub, nil).Fetch(context.Background(), "linux", "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, asset, release.Asset)
+	assert.True(t, release.Signed)
+	assert.Equal(t, script, string(release.Binary))
+
+	// Without a key the checksums prove nothing, unless allowed
+	_, err = New(url, nil, nil).Fetch(context.Background(), "linux", "amd64")
+	assert.ErrorIs(t, err, ErrUnsigned)
+	unsigned := New(url, nil, nil)
+	unsigned.AllowUnsigned()
+	release, err = unsigned.Fetch(context.Background(), "linux", "amd64")
+	require.NoError(t, err)
+	assert.False(t, release.Signed)
+
+	// Checksums signed with another key are refused
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = New(url, other, nil).Fetch(context.Background(), "linux", "amd64")
+	assert.ErrorIs(t, err, ErrSignature)
+
+	_, err = unsigned.Fetch(context.Background(), "linux", "arm64")
+	assert.ErrorContains(t, err, "does not list whm2bunny-linux-arm64.tar.gz")
+}
+
+func TestFetch_ChecksumMismatch(t *testing.T) {
+	asset := Asset("linux", "amd64")
+	url := serveRelease(t, map[string][]byte{
+		asset:         archive(t, script),
+		ChecksumsFile: checksums(asset, archive(t, "tampered")),
+	})
+
+	unsigned := New(url, nil, nil)
+	unsigned.AllowUnsigned()
+	_, err := unsigned.Fetch(context.Background(), "linux", "amd64")
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	// A configured key requires a signature
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = New(url, pub, nil).Fetch(context.Background(), "linux", "amd64")
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub))
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	key, err = ParsePublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	_, err = ParsePublicKey("c2hvcnQ=")
+	assert.Error(t, err)
+}
+
+func TestInstall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whm2bunny")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho whm2bunny v1\n"), 0755))
+
+	installed, err := Installed(path, []byte(script))
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	require.NoError(t, Install(path, []byte(script)))
+
+	installed, err = Installed(path, []byte(script))
+	require.NoError(t, err)
+	assert.True(t, installed)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	old, err := os.ReadFile(path + ".old")
+	require.NoError(t, err)
+	assert.Contains(t, string(old), "v1")
+
+	// A binary that does not run is not installed
+	err = Install(path, []byte("#!/bin/sh\nexit 1\n"))
+	assert.ErrorContains(t, err, "does not run")
+	installed, err = Installed(path, []byte(script))
+	require.NoError(t, err)
+	assert.True(t, installed)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "temporary files are removed")
+}
+
+func TestVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whm2bunny")
+
+	version, err := Version(path, []byte("#!/bin/sh\necho whm2bunny v1.4.0\necho '  Commit:    abc123'\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.0", version)
+
+	_, err = Version(path, []byte("#!/bin/sh\necho hello\n"))
+	assert.ErrorContains(t, err, "no version")
+	_, err = Version(path, []byte("#!/bin/sh\nexit 1\n"))
+	assert.ErrorContains(t, err, "does not run")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "temporary files are removed")
+}
+
+func TestOlder(t *testing.T) {
+	tests := []struct {
+		version, than string
+		older, ok     bool
+	}{
+		{"v1.3.9", "v1.4.0", true, true},
+		{"v1.4.0", "v1.4.0", false, true},
+		{"v1.10.0", "v1.9.2", false, true},
+		{"1.4.1", "v1.4.0", false, true},
+		{"v1.4.0-rc1", "v1.4.0", true, true},
+		{"v1.4.0", "v1.4.0-rc1", false, true},
+		{"v1.4.0-rc1", "v1.4.0-rc2", true, true},
+		{"v1.4.0", "dev", false, false},
+		{"v1.4", "v1.4.0", false, false},
+	}
+	for _, tt := range tests {
+		older, ok := Older(tt.version, tt.than)
+		assert.Equal(t, tt.older, older, "%s older than %s", tt.version, tt.than)
+		assert.Equal(t, tt.ok, ok, "%s and %s comparable", tt.version, tt.than)
+	}
+}
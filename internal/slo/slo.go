@@ -0,0 +1,175 @@
+// Package slo records how long provisioning runs take and computes the
+// percentiles they are judged by
+package slo
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Retention is how long runs are kept: enough to compare two full weeks
+const Retention = 35 * 24 * time.Hour
+
+// Run is one successful provisioning run
+type Run struct {
+	Domain     string                   `json:"domain"`
+	Subdomain  bool                     `json:"subdomain,omitempty"`
+	FinishedAt time.Time                `json:"finished_at"`
+	Duration   time.Duration            `json:"duration"`
+	Steps      map[string]time.Duration `json:"steps,omitempty"`
+	// Attempts counts the runs of a provision that recovered after failing,
+	// this one included; 0 when it succeeded at the first run
+	Attempts int `json:"attempts,omitempty"`
+	// Recovery is how long a recovered provision took from its first run
+	Recovery time.Duration `json:"recovery,omitempty"`
+}
+
+// Stats summarizes the runs finished in a period
+type Stats struct {
+	Runs int
+	P50  time.Duration
+	P95  time.Duration
+	// Slow counts runs that took longer than the target
+	Slow int
+	// Steps holds the p95 of each step
+	Steps map[string]time.Duration
+	// Recovered counts the runs of provisions that had failed before, with
+	// their average and highest number of attempts and the p50 of the time
+	// they took to recover
+	Recovered   int
+	AvgAttempts float64
+	MaxAttempts int
+	RecoveryP50 time.Duration
+}
+
+// Store persists recent runs to a JSON file
+type Store struct {
+	file   *filestore.File[[]Run]
+	runs   []Run
+	mu     sync.Mutex
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewStore creates a store persisting runs to filePath
+func NewStore(filePath string, logger *zap.Logger) (*Store, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "provisioning time", 0644, func() []Run { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		file:   file,
+		logger: logger,
+		now:    time.Now,
+	}
+
+	if err := s.file.Reload(&s.runs); err != nil {
+		return nil, fmt.Errorf("failed to load provisioning times: %w", err)
+	}
+
+	return s, nil
+}
+
+// Record adds a run, dropping runs older than Retention
+func (s *Store) Record(run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if run.FinishedAt.IsZero() {
+		run.FinishedAt = s.now()
+	}
+
+	cutoff := s.now().Add(-Retention)
+	s.runs = slices.DeleteFunc(s.runs, func(r Run) bool {
+		return r.FinishedAt.Before(cutoff)
+	})
+	s.runs = append(s.runs, run)
+
+	return s.file.Save(s.runs)
+}
+
+// Stats summarizes the runs finished in [from, to); runs longer than
+// target are counted as slow unless target is 0
+func (s *Store) Stats(from, to time.Time, target time.Duration) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var durations, recoveries []time.Duration
+	steps := make(map[string][]time.Duration)
+	stats := Stats{}
+	attempts := 0
+	for _, r := range s.runs {
+		if r.FinishedAt.Before(from) || !r.FinishedAt.Before(to) {
+			continue
+		}
+		stats.Runs++
+		durations = append(durations, r.Duration)
+		if target > 0 && r.Duration > target {
+			stats.Slow++
+		}
+		for name, d := range r.Steps {
+			steps[name] = append(steps[name], d)
+		}
+		if r.Attempts > 0 {
+			stats.Recovered++
+			attempts += r.Attempts
+			stats.MaxAttempts = max(stats.MaxAttempts, r.Attempts)
+			recoveries = append(recoveries, r.Recovery)
+		}
+	}
+
+	stats.P50 = Percentile(durations, 50)
+	stats.P95 = Percentile(durations, 95)
+	if stats.Recovered > 0 {
+		stats.AvgAttempts = float64(attempts) / float64(stats.Recovered)
+		stats.RecoveryP50 = Percentile(recoveries, 50)
+	}
+	if len(steps) > 0 {
+		stats.Steps = make(map[string]time.Duration, len(steps))
+		for name, ds := range steps {
+			stats.Steps[name] = Percentile(ds, 95)
+		}
+	}
+	return stats
+}
+
+// Rolling summarizes the runs of the window up to now
+func (s *Store) Rolling(window, target time.Duration) Stats {
+	now := s.now()
+	return s.Stats(now.Add(-window), now.Add(time.Nanosecond), target)
+}
+
+// Percentile returns the nearest-rank pth percentile of durations, or 0
+// when there are none
+func Percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = max(1, min(rank, len(sorted)))
+	return sorted[rank-1]
+}
+
+// Change returns the change from previous to current in percent, or 0 when
+// previous is 0
+func Change(current, previous time.Duration) float64 {
+	if previous <= 0 {
+		return 0
+	}
+	return float64(current-previous) / float64(previous) * 100
+}
@@ -0,0 +1,98 @@
+package slo
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, now time.Time) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "provision_times.json"), nil)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestPercentile(t *testing.T) {
+	var ds []time.Duration
+	for i := 1; i <= 20; i++ {
+		ds = append(ds, time.Duration(21-i)*time.Second)
+	}
+
+	assert.Equal(t, 10*time.Second, Percentile(ds, 50))
+	assert.Equal(t, 19*time.Second, Percentile(ds, 95))
+	assert.Equal(t, 20*time.Second, Percentile(ds, 100))
+	assert.Equal(t, time.Second, Percentile(ds, 0))
+	assert.Equal(t, 20*time.Second, ds[0], "input must not be reordered")
+	assert.Zero(t, Percentile(nil, 95))
+}
+
+func TestChange(t *testing.T) {
+	assert.Equal(t, 50.0, Change(90*time.Second, time.Minute))
+	assert.Equal(t, -50.0, Change(30*time.Second, time.Minute))
+	assert.Zero(t, Change(time.Minute, 0))
+}
+
+func TestStore_Stats(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	for i, d := range []time.Duration{30 * time.Second, 45 * time.Second, 3 * time.Minute} {
+		require.NoError(t, s.Record(Run{
+			Domain:     "example.com",
+			FinishedAt: now.Add(-time.Duration(i+1) * time.Hour),
+			Duration:   d,
+			Steps:      map[string]time.Duration{"Create Pull Zone": d / 2},
+		}))
+	}
+	require.NoError(t, s.Record(Run{Domain: "old.com", FinishedAt: now.AddDate(0, 0, -8), Duration: time.Hour}))
+
+	stats := s.Rolling(7*24*time.Hour, 2*time.Minute)
+	assert.Equal(t, 3, stats.Runs)
+	assert.Equal(t, 45*time.Second, stats.P50)
+	assert.Equal(t, 3*time.Minute, stats.P95)
+	assert.Equal(t, 1, stats.Slow)
+	assert.Equal(t, 90*time.Second, stats.Steps["Create Pull Zone"])
+
+	assert.Zero(t, s.Rolling(7*24*time.Hour, 0).Slow, "no target, nothing is slow")
+
+	empty := s.Stats(now.AddDate(0, 0, -30), now.AddDate(0, 0, -20), time.Minute)
+	assert.Equal(t, Stats{}, empty)
+}
+
+func TestStore_StatsRecovered(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	require.NoError(t, s.Record(Run{Domain: "example.com", FinishedAt: now.Add(-time.Hour), Duration: time.Minute}))
+	require.NoError(t, s.Record(Run{Domain: "flaky.com", FinishedAt: now.Add(-2 * time.Hour), Duration: time.Minute, Attempts: 2, Recovery: time.Hour}))
+	require.NoError(t, s.Record(Run{Domain: "broken.com", FinishedAt: now.Add(-3 * time.Hour), Duration: time.Minute, Attempts: 5, Recovery: 6 * time.Hour}))
+
+	stats := s.Rolling(7*24*time.Hour, 0)
+	assert.Equal(t, 3, stats.Runs)
+	assert.Equal(t, 2, stats.Recovered)
+	assert.Equal(t, 3.5, stats.AvgAttempts)
+	assert.Equal(t, 5, stats.MaxAttempts)
+	assert.Equal(t, time.Hour, stats.RecoveryP50)
+}
+
+func TestStore_PersistsAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "provision_times.json")
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	s, err := NewStore(path, nil)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	require.NoError(t, s.Record(Run{Domain: "expired.com", FinishedAt: now.Add(-Retention - time.Hour), Duration: time.Minute}))
+	require.NoError(t, s.Record(Run{Domain: "example.com", Duration: time.Minute}))
+
+	reloaded, err := NewStore(path, nil)
+	require.NoError(t, err)
+	require.Len(t, reloaded.runs, 1)
+	assert.Equal(t, "example.com", reloaded.runs[0].Domain)
+	assert.Equal(t, now, reloaded.runs[0].FinishedAt)
+}
@@ -0,0 +1,52 @@
+package state
+
+import (
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// ActionDone reports whether action of the current step was completed by an
+// earlier attempt
+func (s *ProvisionState) ActionDone(action string) bool {
+	return slices.Contains(s.StepActions, action)
+}
+
+// CompleteActions records actions of the current step as done, so a step
+// resumed after a failure continues with the first action not done. The
+// actions are cleared when the step changes, and are also recorded in
+// state, the caller's copy
+func (m *Manager) CompleteActions(state *ProvisionState, actions ...string) (err error) {
+	defer m.commit(&err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, exists := m.states[state.ID]
+	if !exists {
+		return ErrStateNotFound
+	}
+	if stored.Status != StatusProvisioning {
+		return fmt.Errorf("%w: action completed while %s for %s", ErrInvalidTransition, stored.Status, stored.Domain)
+	}
+
+	next := *stored
+	next.StepActions = slices.Clone(stored.StepActions)
+	for _, action := range actions {
+		if !slices.Contains(next.StepActions, action) {
+			next.StepActions = append(next.StepActions, action)
+		}
+	}
+	next.UpdatedAt = m.clock.Now()
+
+	if err := m.persist(&next); err != nil {
+		m.logger.Error("Failed to save state after completed actions",
+			zap.String("id", state.ID),
+			zap.Error(err))
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	*stored = next
+	state.StepActions = slices.Clone(next.StepActions)
+
+	return nil
+}
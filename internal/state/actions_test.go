@@ -0,0 +1,65 @@
+package state
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestManager_CompleteActions(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+	st := mgr.Create("example.com")
+
+	if err := mgr.CompleteActions(st, "record:A"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Expected ErrInvalidTransition before provisioning, got %v", err)
+	}
+
+	if err := mgr.MarkProvisioning(st.ID); err != nil {
+		t.Fatalf("MarkProvisioning: %v", err)
+	}
+	if err := mgr.AdvanceStep(st.ID, StepDNSRecords); err != nil {
+		t.Fatalf("AdvanceStep: %v", err)
+	}
+
+	if err := mgr.CompleteActions(st, "record:A", "record:MX"); err != nil {
+		t.Fatalf("CompleteActions: %v", err)
+	}
+	if err := mgr.CompleteActions(st, "record:MX", "record:SPF TXT"); err != nil {
+		t.Fatalf("CompleteActions: %v", err)
+	}
+	want := []string{"record:A", "record:MX", "record:SPF TXT"}
+	if !slices.Equal(st.StepActions, want) {
+		t.Errorf("Expected caller's actions %v, got %v", want, st.StepActions)
+	}
+	if !st.ActionDone("record:MX") || st.ActionDone("record:www CNAME") {
+		t.Errorf("Unexpected ActionDone results for %v", st.StepActions)
+	}
+
+	// Update keeps the actions, which only the manager records
+	got, _ := mgr.Get(st.ID)
+	got.StepActions = nil
+	if err := mgr.Update(got); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// The actions survive a restart
+	reloaded, _ := NewManager(filePath, getTestLogger())
+	got, _ = reloaded.Get(st.ID)
+	if !slices.Equal(got.StepActions, want) {
+		t.Errorf("Expected persisted actions %v, got %v", want, got.StepActions)
+	}
+
+	// The next step starts without actions
+	if err := mgr.AdvanceStep(st.ID, StepPullZone); err != nil {
+		t.Fatalf("AdvanceStep: %v", err)
+	}
+	got, _ = mgr.Get(st.ID)
+	if len(got.StepActions) != 0 {
+		t.Errorf("Expected no actions after the step changed, got %v", got.StepActions)
+	}
+
+	if err := mgr.CompleteActions(&ProvisionState{ID: "missing"}, "record:A"); !errors.Is(err, ErrStateNotFound) {
+		t.Errorf("Expected ErrStateNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ArchivePath returns the archive file that belongs to a state file,
+// e.g. state.json -> state.archive.json
+func ArchivePath(stateFile string) string {
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + ".archive" + ext
+}
+
+// GetArchivePath returns the archive file path
+func (m *Manager) GetArchivePath() string {
+	return ArchivePath(m.filePath)
+}
+
+// Archive moves successful states not updated within olderThan from the
+// active records into the archive file and returns how many were moved
+// Archived states are kept out of the in-memory indexes but are still
+// returned by Get and GetByDomain, and become active again when updated
+func (m *Manager) Archive(olderThan time.Duration) (n int, err error) {
+	defer m.commit(&err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.clock.Now().Add(-olderThan)
+	var moved []*ProvisionState
+	for _, state := range m.states {
+		if state.Status == StatusSuccess && state.UpdatedAt.Before(cutoff) {
+			moved = append(moved, state)
+		}
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+
+	archived, err := m.readArchive()
+	if err != nil {
+		return 0, err
+	}
+	byID := make(map[string]*ProvisionState, len(archived)+len(moved))
+	for _, state := range archived {
+		byID[state.ID] = state
+	}
+	for _, state := range moved {
+		byID[state.ID] = state
+	}
+
+	// Write the archive before dropping active records so a failure in
+	// between leaves duplicates rather than losing states
+	if err := m.writeArchive(byID); err != nil {
+		return 0, err
+	}
+
+	for _, state := range moved {
+		if err := m.unpersist(state.ID); err != nil {
+			return 0, fmt.Errorf("failed to save state after archive: %w", err)
+		}
+		delete(m.states, state.ID)
+		m.unindexUser(state)
+		if m.domainIndex[state.Domain] == state.ID {
+			delete(m.domainIndex, state.Domain)
+		}
+	}
+
+	m.logger.Info("Archived provisioning states",
+		zap.Int("count", len(moved)),
+		zap.String("archive", m.GetArchivePath()))
+
+	return len(moved), nil
+}
+
+// ListArchived returns all archived states that are not active, sorted by domain
+func (m *Manager) ListArchived() ([]*ProvisionState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	archived, err := m.readArchive()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ProvisionState, 0, len(archived))
+	for _, state := range archived {
+		// A state present in both files was re-saved by another process
+		// after archiving; the active copy wins
+		if _, active := m.states[state.ID]; active {
+			continue
+		}
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Domain < result[j].Domain
+	})
+
+	return result, nil
+}
+
+// archivedByID looks up an archived state by ID
+// Caller must hold m.mu
+func (m *Manager) archivedByID(id string) (*ProvisionState, error) {
+	archived, err := m.readArchive()
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range archived {
+		if state.ID == id {
+			return state, nil
+		}
+	}
+	return nil, ErrStateNotFound
+}
+
+// archivedByDomain looks up the most recently updated archived state for a domain
+// Caller must hold m.mu
+func (m *Manager) archivedByDomain(domain string) (*ProvisionState, error) {
+	archived, err := m.readArchive()
+	if err != nil {
+		return nil, err
+	}
+	var found *ProvisionState
+	for _, state := range archived {
+		if state.Domain == domain && (found == nil || state.UpdatedAt.After(found.UpdatedAt)) {
+			found = state
+		}
+	}
+	if found == nil {
+		return nil, ErrStateNotFound
+	}
+	return found, nil
+}
+
+// removeArchived drops a state from the archive file, reporting whether it was there
+// Caller must hold m.mu
+func (m *Manager) removeArchived(id string) (bool, error) {
+	archived, err := m.readArchive()
+	if err != nil {
+		return false, err
+	}
+
+	byID := make(map[string]*ProvisionState, len(archived))
+	for _, state := range archived {
+		byID[state.ID] = state
+	}
+	if _, ok := byID[id]; !ok {
+		return false, nil
+	}
+	delete(byID, id)
+
+	return true, m.writeArchive(byID)
+}
+
+// readArchive reads the archive file; a missing file is an empty archive
+func (m *Manager) readArchive() ([]*ProvisionState, error) {
+	data, err := readSealed(m.GetArchivePath(), m.keyring)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read archive file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var states []*ProvisionState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal archive: %w", err)
+	}
+	return states, nil
+}
+
+// writeArchive writes the archive file atomically
+func (m *Manager) writeArchive(byID map[string]*ProvisionState) error {
+	states := make([]*ProvisionState, 0, len(byID))
+	for _, state := range byID {
+		states = append(states, state)
+	}
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal archive: %w", err)
+	}
+
+	if _, err := writeSealed(m.GetArchivePath(), data, m.keyring); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	return nil
+}
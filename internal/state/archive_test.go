@@ -0,0 +1,116 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// ageState backdates a state so it qualifies for archiving
+func ageState(t *testing.T, mgr *Manager, id string, age time.Duration) {
+	t.Helper()
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.states[id].UpdatedAt = time.Now().Add(-age)
+}
+
+func TestArchivePath(t *testing.T) {
+	if got := ArchivePath("/var/lib/whm2bunny/state.json"); got != "/var/lib/whm2bunny/state.archive.json" {
+		t.Errorf("Expected state.archive.json, got %s", got)
+	}
+}
+
+func TestManager_Archive(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	old := mgr.Create("old.com")
+	completeState(t, mgr, old.ID)
+	ageState(t, mgr, old.ID, 48*time.Hour)
+
+	recent := mgr.Create("recent.com")
+	completeState(t, mgr, recent.ID)
+
+	failed := mgr.Create("failed.com")
+	_ = mgr.MarkProvisioning(failed.ID)
+	_ = mgr.SetError(failed.ID, "boom")
+	ageState(t, mgr, failed.ID, 48*time.Hour)
+
+	n, err := mgr.Archive(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 archived state, got %d", n)
+	}
+	if mgr.GetCount() != 2 {
+		t.Errorf("Expected 2 active states, got %d", mgr.GetCount())
+	}
+
+	archived, err := mgr.ListArchived()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(archived) != 1 || archived[0].Domain != "old.com" {
+		t.Fatalf("Expected old.com in archive, got %v", archived)
+	}
+
+	// Lookups still find archived states
+	st, err := mgr.GetByDomain("old.com")
+	if err != nil || st.ID != old.ID {
+		t.Errorf("Expected archived state by domain, got %v, %v", st, err)
+	}
+	if _, err := mgr.Get(old.ID); err != nil {
+		t.Errorf("Expected archived state by ID, got %v", err)
+	}
+
+	// A reloaded manager keeps archived states out of memory
+	reloaded, _ := NewManager(filePath, getTestLogger())
+	if reloaded.GetCount() != 2 {
+		t.Errorf("Expected 2 active states after reload, got %d", reloaded.GetCount())
+	}
+
+	n, _ = mgr.Archive(24 * time.Hour)
+	if n != 0 {
+		t.Errorf("Expected nothing left to archive, got %d", n)
+	}
+}
+
+func TestManager_ArchiveUpdateAndDelete(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+
+	a := mgr.Create("a.com")
+	completeState(t, mgr, a.ID)
+	ageState(t, mgr, a.ID, 48*time.Hour)
+	b := mgr.Create("b.com")
+	completeState(t, mgr, b.ID)
+	ageState(t, mgr, b.ID, 48*time.Hour)
+
+	if _, err := mgr.Archive(24 * time.Hour); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Updating an archived state reactivates it
+	st, _ := mgr.GetByDomain("a.com")
+	st.Package = "pro"
+	if err := mgr.Update(st); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mgr.GetCount() != 1 {
+		t.Errorf("Expected reactivated state to be active, got %d active", mgr.GetCount())
+	}
+	archived, _ := mgr.ListArchived()
+	if len(archived) != 1 || archived[0].Domain != "b.com" {
+		t.Errorf("Expected only b.com archived, got %v", archived)
+	}
+
+	// Deleting an archived state removes it from the archive
+	if err := mgr.Delete(b.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := mgr.GetByDomain("b.com"); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound, got %v", err)
+	}
+	if err := mgr.Delete(b.ID); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound on second delete, got %v", err)
+	}
+}
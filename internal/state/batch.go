@@ -0,0 +1,222 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithBatching writes the changes of a Manager to the records in batches,
+// at most interval after they are made or once mutations changes are
+// waiting, rather than one record write per change while holding the lock.
+// Until a batch is written its changes are kept by a write-ahead log, which
+// is fsynced before the call that made them returns, so a crash loses
+// nothing. The Manager must be closed to write the last batch
+func WithBatching(interval time.Duration, mutations int) Option {
+	return func(o *options) {
+		o.flushInterval = interval
+		o.flushMutations = mutations
+	}
+}
+
+// batch holds the changes of a batching Manager not yet written to the
+// records
+type batch struct {
+	mutations int
+	wal       *wal
+	// dirty maps the IDs changed since the last flush to their record, nil
+	// when the state was deleted; pending counts the changes
+	// Guarded by the Manager's mu
+	dirty   map[string][]byte
+	pending int
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// startBatching starts the background flushes, once the state is loaded
+func (m *Manager) startBatching(interval time.Duration, mutations int) {
+	if mutations < 1 {
+		mutations = 1
+	}
+	m.batch = &batch{
+		mutations: mutations,
+		wal:       newWAL(m.GetRecordsDir(), m.keyring),
+		dirty:     make(map[string][]byte),
+		full:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go m.flushLoop(interval)
+}
+
+// flushLoop flushes every interval, and as soon as a batch is full
+func (m *Manager) flushLoop(interval time.Duration) {
+	defer close(m.batch.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.batch.stop:
+			return
+		case <-ticker.C:
+		case <-m.batch.full:
+		}
+		if err := m.Flush(); err != nil {
+			m.logger.Error("Failed to flush state changes", zap.Error(err))
+		}
+	}
+}
+
+// stage queues the record of a change for the next flush and appends it to
+// the write-ahead log; data is nil for a deletion
+// Caller must hold m.mu
+func (m *Manager) stage(id string, data []byte) error {
+	if err := m.batch.wal.append(walEntry{ID: id, State: data, Deleted: data == nil}); err != nil {
+		return err
+	}
+	m.batch.dirty[id] = data
+	m.batch.pending++
+	if m.batch.pending >= m.batch.mutations {
+		select {
+		case m.batch.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// sync waits for the changes staged so far to be in the write-ahead log. It
+// is called once m.mu is released, so readers are not held up by the fsync
+func (m *Manager) sync() error {
+	if m.batch == nil {
+		return nil
+	}
+	if err := m.batch.wal.sync(); err != nil {
+		m.logger.Error("Failed to save state changes", zap.Error(err))
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// commit is sync for a deferred call, setting *err when it fails and the
+// call did not; err may be nil for calls that return no error
+func (m *Manager) commit(err *error) {
+	if syncErr := m.sync(); syncErr != nil && err != nil && *err == nil {
+		*err = syncErr
+	}
+}
+
+// Dirty reports whether changes are waiting to be written to the records
+func (m *Manager) Dirty() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.batch != nil && len(m.batch.dirty) > 0
+}
+
+// Flush writes the changes batched since the last flush to the records and
+// removes them from the write-ahead log. Without batching every change is
+// written as it is made and Flush does nothing
+func (m *Manager) Flush() error {
+	if m.batch == nil {
+		return nil
+	}
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	dirty := m.batch.dirty
+	if len(dirty) == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	m.batch.dirty = make(map[string][]byte)
+	m.batch.pending = 0
+	gen := m.batch.wal.rotate()
+	m.mu.Unlock()
+
+	// Every entry of the batch's segments is written before they are
+	// removed, or a late one would bring a segment back. The records are
+	// written even when this fails, which makes the entries unneeded
+	if err := m.batch.wal.sync(); err != nil {
+		m.logger.Warn("Failed to write state changes to the write-ahead log before flushing", zap.Error(err))
+	}
+
+	ids := make([]string, 0, len(dirty))
+	for id := range dirty {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	failed := make(map[string][]byte)
+	for _, id := range ids {
+		var err error
+		if data := dirty[id]; data == nil {
+			err = m.removeRecord(id)
+		} else {
+			err = m.writeRecord(id, data)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			failed[id] = dirty[id]
+		}
+	}
+
+	if len(failed) > 0 {
+		// Retried by the next flush unless changed since; the segments are
+		// kept until then
+		m.mu.Lock()
+		for id, data := range failed {
+			if _, changed := m.batch.dirty[id]; !changed {
+				m.batch.dirty[id] = data
+				m.batch.pending++
+			}
+		}
+		m.mu.Unlock()
+		return fmt.Errorf("failed to flush %d state records: %w", len(failed), errors.Join(errs...))
+	}
+
+	m.writes.flush()
+	return m.batch.wal.retire(gen)
+}
+
+// Close stops the background flushes and writes the last batch. Changes
+// made after Close are still logged, and written when the state is loaded
+// again
+func (m *Manager) Close() error {
+	if m.batch == nil {
+		return nil
+	}
+	m.batch.once.Do(func() {
+		close(m.batch.stop)
+	})
+	<-m.batch.done
+
+	err := m.Flush()
+	m.batch.wal.close()
+	if m.walLock != nil {
+		m.walLock.Close()
+		m.walLock = nil
+	}
+	return err
+}
+
+// marshalRecord returns the record of a state
+func marshalRecord(state *ProvisionState) ([]byte, error) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return data, nil
+}
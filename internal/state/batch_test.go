@@ -0,0 +1,204 @@
+package state
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newBatchingManager returns a manager that only flushes when told to
+func newBatchingManager(t *testing.T, filePath string, opts ...Option) *Manager {
+	t.Helper()
+	mgr, err := NewManager(filePath, getTestLogger(), append(opts, WithBatching(time.Hour, 1000))...)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return mgr
+}
+
+// crash stops the background flushes of mgr without flushing and releases
+// its files, as a process killed mid-batch would
+func crash(mgr *Manager) {
+	mgr.batch.once.Do(func() { close(mgr.batch.stop) })
+	<-mgr.batch.done
+	mgr.batch.wal.close()
+	mgr.walLock.Close()
+}
+
+// walSegments returns the write-ahead log segments of a state file
+func walSegments(t *testing.T, filePath string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(RecordsDir(filePath), "*"+walExt))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	return segments
+}
+
+func TestManager_BatchesRecordWrites(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr := newBatchingManager(t, filePath)
+	defer mgr.Close()
+
+	st := mgr.Create("example.com")
+	if err := mgr.MarkProvisioning(st.ID); err != nil {
+		t.Fatalf("MarkProvisioning: %v", err)
+	}
+
+	record := filepath.Join(RecordsDir(filePath), st.ID+".json")
+	if _, err := os.Stat(record); !os.IsNotExist(err) {
+		t.Errorf("Expected no record before the flush, got %v", err)
+	}
+	if !mgr.Dirty() {
+		t.Error("Expected the manager to be dirty")
+	}
+	if got, _ := mgr.Get(st.ID); got.Status != StatusProvisioning {
+		t.Errorf("Expected readers to see the change, got %s", got.Status)
+	}
+
+	if err := mgr.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	stored, err := mgr.readRecord(record)
+	if err != nil {
+		t.Fatalf("Expected the record after the flush, got %v", err)
+	}
+	if stored.Status != StatusProvisioning {
+		t.Errorf("Expected the latest version to be written, got %s", stored.Status)
+	}
+	if mgr.Dirty() {
+		t.Error("Expected the manager to be clean after the flush")
+	}
+	if segments := walSegments(t, filePath); len(segments) != 0 {
+		t.Errorf("Expected the flushed segments to be removed, got %v", segments)
+	}
+	if stats := mgr.WriteStats(); stats.Writes != 1 || stats.Flushes != 1 {
+		t.Errorf("Expected one record write in one flush, got %+v", stats)
+	}
+}
+
+func TestManager_FlushesFullBatch(t *testing.T) {
+	mgr, err := NewManager(getTempDir(t), getTestLogger(), WithBatching(time.Hour, 2))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	defer mgr.Close()
+
+	mgr.Create("a.com")
+	mgr.Create("b.com")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.Dirty() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mgr.Dirty() {
+		t.Error("Expected a full batch to be flushed without waiting for the interval")
+	}
+}
+
+func TestManager_ReplaysWALAfterCrash(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr := newBatchingManager(t, filePath)
+
+	kept := mgr.Create("kept.com")
+	deleted := mgr.Create("deleted.com")
+	if err := mgr.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err := mgr.MarkProvisioning(kept.ID); err != nil {
+		t.Fatalf("MarkProvisioning: %v", err)
+	}
+	if err := mgr.Delete(deleted.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	added := mgr.Create("added.com")
+
+	// While mgr runs, a manager without batching reads the log and leaves
+	// it alone, and another batching one is refused
+	reader, err := NewManager(filePath, getTestLogger())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if reader.GetCount() != 2 {
+		t.Errorf("Expected 2 states, got %d", reader.GetCount())
+	}
+	if len(walSegments(t, filePath)) != 1 {
+		t.Error("Expected a manager without batching to keep the log")
+	}
+	if _, err := NewManager(filePath, getTestLogger(), WithBatching(time.Hour, 1000)); err == nil {
+		t.Error("Expected a second batching manager to be refused")
+	}
+
+	crash(mgr)
+
+	// A torn frame at the end of the log is a change whose call never
+	// returned, and is dropped
+	segments := walSegments(t, filePath)
+	if len(segments) != 1 {
+		t.Fatalf("Expected one segment, got %v", segments)
+	}
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 0xde, 0xad}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+
+	recovered := newBatchingManager(t, filePath)
+	defer recovered.Close()
+
+	if got, err := recovered.Get(kept.ID); err != nil || got.Status != StatusProvisioning {
+		t.Errorf("Expected the logged transition to be recovered, got %v, %v", got, err)
+	}
+	if _, err := recovered.Get(deleted.ID); err != ErrStateNotFound {
+		t.Errorf("Expected the logged deletion to be recovered, got %v", err)
+	}
+	if _, err := recovered.GetByDomain("added.com"); err != nil {
+		t.Errorf("Expected the logged creation to be recovered, got %v", err)
+	}
+	interrupted := false
+	for _, st := range recovered.Recover() {
+		interrupted = interrupted || st.ID == kept.ID
+	}
+	if !interrupted {
+		t.Error("Expected the logged provision to be recovered as interrupted")
+	}
+
+	// The log is written to the records and removed
+	if len(walSegments(t, filePath)) != 0 {
+		t.Error("Expected the replayed log to be removed")
+	}
+	if _, err := recovered.readRecord(filepath.Join(RecordsDir(filePath), added.ID+".json")); err != nil {
+		t.Errorf("Expected the replayed state to be written, got %v", err)
+	}
+}
+
+func TestManager_CloseFlushes(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr := newBatchingManager(t, filePath, WithKeyring(testKeyring(t, 1)))
+
+	st := mgr.Create("example.com")
+	segments := walSegments(t, filePath)
+	if len(segments) != 1 {
+		t.Fatalf("Expected the change to be logged, got %v", segments)
+	}
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(data, []byte("example.com")) {
+		t.Error("Expected the log to be encrypted")
+	}
+
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	assertSealed(t, filepath.Join(RecordsDir(filePath), st.ID+".json"))
+	if len(walSegments(t, filePath)) != 0 {
+		t.Error("Expected no log after Close")
+	}
+}
@@ -0,0 +1,125 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Option configures a Manager or a SnapshotStore
+type Option func(*options)
+
+// options holds what Option sets
+type options struct {
+	keyring *encryption.Keyring
+	server  string
+	// flushInterval and flushMutations are set by WithBatching
+	flushInterval  time.Duration
+	flushMutations int
+}
+
+// WithKeyring encrypts the files at rest with keyring, and reads files
+// sealed with any of its keys or not encrypted at all
+func WithKeyring(keyring *encryption.Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// applyOptions returns the options set by opts
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// readSealed reads a file, decrypting it when it is encrypted
+func readSealed(path string, keyring *encryption.Keyring) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Decrypt(data)
+}
+
+// writeSealed encrypts data when the keyring encrypts and writes it
+// atomically, returning the number of bytes written
+func writeSealed(path string, data []byte, keyring *encryption.Keyring) (int, error) {
+	sealed, err := keyring.Encrypt(data)
+	if err != nil {
+		return 0, err
+	}
+	if err := filestore.WriteFile(path, sealed, 0644); err != nil {
+		return 0, err
+	}
+	return len(sealed), nil
+}
+
+// keyMissing reports whether err is a file that cannot be decrypted with
+// the configured keys, rather than a corrupted one
+func keyMissing(err error) bool {
+	return errors.Is(err, encryption.ErrEncrypted) || errors.Is(err, encryption.ErrUnknownKey)
+}
+
+// Reencrypt rewrites every state record and the archive with the current
+// key of the manager's keyring, or in plain JSON when it does not encrypt,
+// and removes the record backups still sealed with the previous key. It
+// returns the number of records rewritten
+func (m *Manager) Reencrypt() (int, error) {
+	// Batched changes are written first; the records are then rewritten
+	// directly, so no flush brings back a backup with the previous key
+	if err := m.Flush(); err != nil {
+		return 0, err
+	}
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, state := range m.states {
+		data, err := marshalRecord(state)
+		if err == nil {
+			err = m.writeRecord(state.ID, data)
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to rewrite %s: %w", state.Domain, err)
+		}
+		path, err := m.recordPath(state.ID)
+		if err != nil {
+			return n, err
+		}
+		os.Remove(path + ".bak")
+		n++
+	}
+
+	archived, err := m.readArchive()
+	if err != nil {
+		return n, err
+	}
+	if archived != nil {
+		byID := make(map[string]*ProvisionState, len(archived))
+		for _, state := range archived {
+			byID[state.ID] = state
+		}
+		if err := m.writeArchive(byID); err != nil {
+			return n, err
+		}
+	}
+
+	return n, filestore.SyncDir(m.GetRecordsDir())
+}
+
+// Reencrypt rewrites the snapshot file with the current key of the
+// store's keyring, or in plain JSON when it does not encrypt
+func (s *SnapshotStore) Reencrypt() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
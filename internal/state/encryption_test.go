@@ -0,0 +1,143 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+)
+
+// testKeyring returns a keyring encrypting with a key filled with b and
+// decrypting with the keys filled with previous
+func testKeyring(t *testing.T, b byte, previous ...byte) *encryption.Keyring {
+	t.Helper()
+	var prev [][]byte
+	for _, p := range previous {
+		prev = append(prev, bytes.Repeat([]byte{p}, encryption.KeySize))
+	}
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{b}, encryption.KeySize), prev...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keyring
+}
+
+// assertSealed fails unless the file at path is encrypted
+func assertSealed(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !encryption.IsEncrypted(data) {
+		t.Errorf("Expected %s to be encrypted, got %q", filepath.Base(path), data)
+	}
+}
+
+func TestManager_Encryption(t *testing.T) {
+	filePath := getTempDir(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	mgr, err := NewManager(filePath, getTestLogger(), WithKeyring(testKeyring(t, 1)))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	mgr.SetClock(clk)
+	active := mgr.Create("active.com")
+	archived := mgr.Create("archived.com")
+	completeState(t, mgr, archived.ID)
+	clk.Advance(48 * time.Hour)
+	if n, err := mgr.Archive(24 * time.Hour); err != nil || n != 1 {
+		t.Fatalf("Expected 1 archived state, got %d (%v)", n, err)
+	}
+
+	record := filepath.Join(RecordsDir(filePath), active.ID+".json")
+	assertSealed(t, record)
+	assertSealed(t, mgr.GetArchivePath())
+
+	// Without the key the records are refused, not moved aside as corrupt
+	if _, err := NewManager(filePath, getTestLogger()); !errors.Is(err, encryption.ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without a key, got %v", err)
+	}
+	if _, err := NewManager(filePath, getTestLogger(), WithKeyring(testKeyring(t, 2))); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey with another key, got %v", err)
+	}
+	if _, err := os.Stat(record + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("Expected no corrupt copy, got %v", err)
+	}
+
+	// Rotation: the new key reads the old files and rewrites them
+	rotated, err := NewManager(filePath, getTestLogger(), WithKeyring(testKeyring(t, 2, 1)))
+	if err != nil {
+		t.Fatalf("NewManager with the previous key: %v", err)
+	}
+	if n, err := rotated.Reencrypt(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 re-encrypted state, got %d (%v)", n, err)
+	}
+	if _, err := os.Stat(record + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("Expected the backup sealed with the old key to be removed, got %v", err)
+	}
+
+	reopened, err := NewManager(filePath, getTestLogger(), WithKeyring(testKeyring(t, 2)))
+	if err != nil {
+		t.Fatalf("NewManager with the new key only: %v", err)
+	}
+	if _, err := reopened.GetByDomain("active.com"); err != nil {
+		t.Errorf("Expected active.com, got %v", err)
+	}
+	if list, err := reopened.ListArchived(); err != nil || len(list) != 1 {
+		t.Errorf("Expected 1 archived state, got %d (%v)", len(list), err)
+	}
+}
+
+func TestManager_EncryptionMigratesPlainFiles(t *testing.T) {
+	filePath := getTempDir(t)
+	plain, _ := NewManager(filePath, getTestLogger())
+	st := plain.Create("example.com")
+
+	mgr, err := NewManager(filePath, getTestLogger(), WithKeyring(testKeyring(t, 1)))
+	if err != nil {
+		t.Fatalf("Expected plain records to load with a key, got %v", err)
+	}
+	if n, err := mgr.Reencrypt(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 re-encrypted state, got %d (%v)", n, err)
+	}
+	assertSealed(t, filepath.Join(RecordsDir(filePath), st.ID+".json"))
+	if _, err := os.Stat(mgr.GetArchivePath()); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive to be created, got %v", err)
+	}
+}
+
+func TestSnapshotStore_Encryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots.json")
+	plain, _ := NewSnapshotStore(path, getTestLogger())
+	if err := plain.AddSnapshot(BandwidthSnapshot{Timestamp: time.Now(), ZoneID: 1, Bandwidth: 100}); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+
+	store, err := NewSnapshotStore(path, getTestLogger(), WithKeyring(testKeyring(t, 1)))
+	if err != nil {
+		t.Fatalf("Expected plain snapshots to load with a key, got %v", err)
+	}
+	if err := store.Reencrypt(); err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+	assertSealed(t, path)
+
+	if _, err := NewSnapshotStore(path, getTestLogger()); !errors.Is(err, encryption.ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without a key, got %v", err)
+	}
+	reopened, err := NewSnapshotStore(path, getTestLogger(), WithKeyring(testKeyring(t, 1)))
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	if s := reopened.GetLatestSnapshotByZone(1); s == nil || s.Bandwidth != 100 {
+		t.Errorf("Expected the snapshot to survive, got %+v", s)
+	}
+}
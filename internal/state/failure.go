@@ -0,0 +1,55 @@
+package state
+
+import "go.uber.org/zap"
+
+// ErrorClass is the kind of error a provisioning run failed with, so
+// systemic problems stand out from one-off failures
+type ErrorClass string
+
+const (
+	// ErrorClassAuth is a rejected API key or token
+	ErrorClassAuth ErrorClass = "auth"
+	// ErrorClassQuota is an exhausted quota, limit or account balance
+	ErrorClassQuota ErrorClass = "quota"
+	// ErrorClassRateLimit is a request throttled by the API
+	ErrorClassRateLimit ErrorClass = "rate_limit"
+	// ErrorClassValidation is a request the API refused as invalid
+	ErrorClassValidation ErrorClass = "validation"
+	// ErrorClassNetwork is a connection failure, timeout or server error
+	ErrorClassNetwork ErrorClass = "network"
+	// ErrorClassOrigin is an origin that failed the pre-flight check
+	ErrorClassOrigin ErrorClass = "origin"
+	// ErrorClassUnknown is any other error
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+// ErrorClasses lists every error class, in the order reports show them
+var ErrorClasses = []ErrorClass{
+	ErrorClassAuth,
+	ErrorClassQuota,
+	ErrorClassRateLimit,
+	ErrorClassValidation,
+	ErrorClassNetwork,
+	ErrorClassOrigin,
+	ErrorClassUnknown,
+}
+
+// SetFailure sets an error message and its class and marks the state as
+// failed
+func (m *Manager) SetFailure(id, errMsg string, class ErrorClass) error {
+	err := m.transition(id, StatusFailed, errMsg, func(state *ProvisionState) {
+		state.Error = errMsg
+		state.ErrorClass = class
+		state.Retries++
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Warn("Marked state as failed",
+		zap.String("id", id),
+		zap.String("error", errMsg),
+		zap.String("error_class", string(class)))
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Status is the provisioning status of a state
+type Status string
+
+// Step is a provisioning step. Domains use the Step* constants and
+// subdomains the SubdomainStep* constants
+type Step int
+
+// Transition is a saved status change of a provisioning state
+type Transition struct {
+	ID     string
+	Domain string
+	From   Status
+	To     Status
+	Step   Step
+	// Reason is the error or queue reason given with the change, if any
+	Reason string
+}
+
+// TransitionHook is called after a transition has been saved, e.g. to audit
+// or notify. Hooks run outside the manager's lock
+type TransitionHook func(state *ProvisionState, t Transition)
+
+// transitions lists the statuses each status may move to. Provisioning may
+// repeat so a provision interrupted by a restart can resume; success is
+// final until the state is deleted
+var transitions = map[Status][]Status{
+	StatusPending:      {StatusProvisioning},
+	StatusProvisioning: {StatusProvisioning, StatusSuccess, StatusFailed, StatusPending},
+	StatusFailed:       {StatusProvisioning, StatusPending},
+	StatusSuccess:      nil,
+}
+
+// guards check a state, with the transition applied, before it enters a status
+var guards = map[Status]func(state *ProvisionState) error{
+	StatusSuccess: func(state *ProvisionState) error {
+		if !state.Complete() {
+			return fmt.Errorf("step %s is not the final step", state.StepName())
+		}
+		return nil
+	},
+}
+
+// ErrInvalidTransition is returned for a status or step change the state
+// machine does not allow
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// CanTransition reports whether a state may move from one status to another
+func CanTransition(from, to Status) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// finalStep returns the step a state holds once every step is complete
+func (s *ProvisionState) finalStep() Step {
+	if s.IsSubdomain() {
+		return SubdomainStepVerified
+	}
+	return StepDone
+}
+
+// Complete reports whether every provisioning step is complete
+func (s *ProvisionState) Complete() bool {
+	return s.CurrentStep == s.finalStep()
+}
+
+// stopStepClock adds the time since the current step started to its
+// duration. The final step does no work and is not timed
+func (s *ProvisionState) stopStepClock(now time.Time) {
+	if !s.StepStartedAt.IsZero() && s.CurrentStep != StepNone && !s.Complete() {
+		durations := maps.Clone(s.StepDurations)
+		if durations == nil {
+			durations = make(map[string]time.Duration)
+		}
+		durations[s.StepName()] += now.Sub(s.StepStartedAt)
+		s.StepDurations = durations
+	}
+	s.StepStartedAt = time.Time{}
+}
+
+// ProvisionTime returns the time spent in provisioning steps over all attempts
+func (s *ProvisionState) ProvisionTime() time.Duration {
+	var total time.Duration
+	for _, d := range s.StepDurations {
+		total += d
+	}
+	return total
+}
+
+// OnTransition registers a hook called after every status change
+func (m *Manager) OnTransition(hook TransitionHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hook)
+}
+
+// transition moves a state to status to, applying change first. The move
+// is checked against transitions and the guard of to, and nothing is saved
+// when it is not allowed
+func (m *Manager) transition(id string, to Status, reason string, change func(state *ProvisionState)) error {
+	m.mu.Lock()
+
+	state, exists := m.states[id]
+	if !exists {
+		m.mu.Unlock()
+		return ErrStateNotFound
+	}
+
+	from := state.Status
+	if !CanTransition(from, to) {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s -> %s for %s", ErrInvalidTransition, from, to, state.Domain)
+	}
+
+	next := *state
+	if change != nil {
+		change(&next)
+	}
+	next.Status = to
+	if guard := guards[to]; guard != nil {
+		if err := guard(&next); err != nil {
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %s -> %s for %s: %v", ErrInvalidTransition, from, to, state.Domain, err)
+		}
+	}
+	next.UpdatedAt = m.clock.Now()
+
+	// Time steps only while provisioning. Provisioning again resumes after
+	// an interruption, whose downtime is not counted
+	if from == StatusProvisioning && to != StatusProvisioning {
+		next.stopStepClock(next.UpdatedAt)
+	}
+	if to == StatusProvisioning {
+		next.StepStartedAt = next.UpdatedAt
+	}
+
+	if err := m.persist(&next); err != nil {
+		m.mu.Unlock()
+		m.logger.Error("Failed to save state after transition",
+			zap.String("id", id),
+			zap.String("to", string(to)),
+			zap.Error(err))
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	*state = next
+	delete(m.interrupted, id)
+
+	snapshot := next
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	// Hooks act on the change once it is saved
+	if err := m.sync(); err != nil {
+		return err
+	}
+
+	m.logger.Debug("State transition",
+		zap.String("domain", snapshot.Domain),
+		zap.String("from", string(from)),
+		zap.String("to", string(to)),
+		zap.String("step", snapshot.StepName()))
+
+	t := Transition{
+		ID:     id,
+		Domain: snapshot.Domain,
+		From:   from,
+		To:     to,
+		Step:   snapshot.CurrentStep,
+		Reason: reason,
+	}
+	for _, hook := range hooks {
+		hook(&snapshot, t)
+	}
+
+	return nil
+}
+
+// AdvanceStep moves a provisioning state to step. Steps only advance while
+// the state is provisioning, never move backwards and stay within the steps
+// of the state's kind
+func (m *Manager) AdvanceStep(id string, step Step) (err error) {
+	defer m.commit(&err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.states[id]
+	if !exists {
+		return ErrStateNotFound
+	}
+
+	switch {
+	case state.Status != StatusProvisioning:
+		return fmt.Errorf("%w: step change while %s for %s", ErrInvalidTransition, state.Status, state.Domain)
+	case step < state.CurrentStep || step > state.finalStep():
+		return fmt.Errorf("%w: step %d -> %d for %s", ErrInvalidTransition, state.CurrentStep, step, state.Domain)
+	}
+
+	next := *state
+	next.UpdatedAt = m.clock.Now()
+	if step != state.CurrentStep {
+		next.stopStepClock(next.UpdatedAt)
+		next.StepStartedAt = next.UpdatedAt
+		next.StepActions = nil
+	}
+	next.CurrentStep = step
+
+	if err := m.persist(&next); err != nil {
+		m.logger.Error("Failed to save state after step change",
+			zap.String("id", id),
+			zap.Error(err))
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	*state = next
+
+	return nil
+}
+
+// completeSucceeded moves successful states saved before steps were
+// enforced, which recorded the CNAME step rather than completion, to their
+// final step
+// Caller must hold m.mu
+func (m *Manager) completeSucceeded() {
+	for _, state := range m.states {
+		if state.Status != StatusSuccess || state.Complete() {
+			continue
+		}
+
+		state.CurrentStep = state.finalStep()
+		if err := m.persist(state); err != nil {
+			m.logger.Error("Failed to save completed state",
+				zap.String("domain", state.Domain),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		allowed  bool
+	}{
+		{StatusPending, StatusProvisioning, true},
+		{StatusPending, StatusSuccess, false},
+		{StatusPending, StatusFailed, false},
+		{StatusProvisioning, StatusProvisioning, true},
+		{StatusProvisioning, StatusSuccess, true},
+		{StatusProvisioning, StatusFailed, true},
+		{StatusProvisioning, StatusPending, true},
+		{StatusFailed, StatusPending, true},
+		{StatusFailed, StatusProvisioning, true},
+		{StatusFailed, StatusSuccess, false},
+		{StatusSuccess, StatusPending, false},
+		{StatusSuccess, StatusFailed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.allowed {
+				t.Errorf("Expected %v, got %v", tt.allowed, got)
+			}
+		})
+	}
+}
+
+func TestManager_Transitions(t *testing.T) {
+	t.Run("rejects illegal status change", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		st := mgr.Create("illegal.com")
+
+		err := mgr.SetError(st.ID, "boom")
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("Expected ErrInvalidTransition, got %v", err)
+		}
+
+		retrieved, _ := mgr.Get(st.ID)
+		if retrieved.Status != StatusPending || retrieved.Retries != 0 {
+			t.Errorf("Expected state unchanged, got %s with %d retries", retrieved.Status, retrieved.Retries)
+		}
+	})
+
+	t.Run("success requires the final step", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		st := mgr.Create("early.com")
+		_ = mgr.MarkProvisioning(st.ID)
+		_ = mgr.AdvanceStep(st.ID, StepDNSZone)
+
+		if err := mgr.MarkSuccess(st.ID); !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("Expected ErrInvalidTransition, got %v", err)
+		}
+
+		retrieved, _ := mgr.Get(st.ID)
+		if retrieved.Status != StatusProvisioning {
+			t.Errorf("Expected status '%s', got '%s'", StatusProvisioning, retrieved.Status)
+		}
+	})
+
+	t.Run("subdomain success requires verification", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		st := mgr.CreateSubdomain("blog.example.com", "example.com")
+		_ = mgr.MarkProvisioning(st.ID)
+		_ = mgr.AdvanceStep(st.ID, SubdomainStepCNAME)
+
+		if err := mgr.MarkSuccess(st.ID); !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("Expected ErrInvalidTransition, got %v", err)
+		}
+		if err := mgr.AdvanceStep(st.ID, StepDone); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Expected domain step to be rejected, got %v", err)
+		}
+
+		_ = mgr.AdvanceStep(st.ID, SubdomainStepVerified)
+		if err := mgr.MarkSuccess(st.ID); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("calls hooks after saving", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		var seen []Transition
+		mgr.OnTransition(func(st *ProvisionState, tr Transition) {
+			// Hooks may use the manager
+			if _, err := mgr.Get(st.ID); err != nil {
+				t.Errorf("Expected state to be readable from hook, got %v", err)
+			}
+			seen = append(seen, tr)
+		})
+
+		st := mgr.Create("hooks.com")
+		_ = mgr.MarkProvisioning(st.ID)
+		_ = mgr.SetError(st.ID, "API error")
+		_ = mgr.MarkSuccess(st.ID)
+
+		if len(seen) != 2 {
+			t.Fatalf("Expected 2 transitions, got %d", len(seen))
+		}
+		if seen[1].From != StatusProvisioning || seen[1].To != StatusFailed || seen[1].Reason != "API error" {
+			t.Errorf("Unexpected transition %+v", seen[1])
+		}
+	})
+}
+
+func TestManager_AdvanceStep(t *testing.T) {
+	t.Run("requires provisioning", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		st := mgr.Create("idle.com")
+
+		if err := mgr.AdvanceStep(st.ID, StepDNSZone); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Expected ErrInvalidTransition, got %v", err)
+		}
+	})
+
+	t.Run("never moves backwards", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		st := mgr.Create("backwards.com")
+		_ = mgr.MarkProvisioning(st.ID)
+		_ = mgr.AdvanceStep(st.ID, StepPullZone)
+
+		if err := mgr.AdvanceStep(st.ID, StepDNSZone); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Expected ErrInvalidTransition, got %v", err)
+		}
+		if err := mgr.AdvanceStep(st.ID, StepPullZone); err != nil {
+			t.Errorf("Expected repeating a step to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("returns error for non-existent state", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+
+		if err := mgr.AdvanceStep("non-existent", StepDNSZone); err != ErrStateNotFound {
+			t.Errorf("Expected ErrStateNotFound, got %v", err)
+		}
+	})
+}
+
+func TestManager_CompletesLegacySuccessStates(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	st := mgr.Create("legacy.com")
+	setStatus(t, mgr, st.ID, StatusSuccess)
+	setStep(t, mgr, st.ID, StepCNAMESync)
+
+	reloaded, _ := NewManager(filePath, getTestLogger())
+	retrieved, _ := reloaded.Get(st.ID)
+	if retrieved.CurrentStep != StepDone {
+		t.Errorf("Expected step %d, got %d", StepDone, retrieved.CurrentStep)
+	}
+}
+
+func TestManager_StepDurations(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+	st := mgr.Create("timed.com")
+	_ = mgr.MarkProvisioning(st.ID)
+
+	_ = mgr.AdvanceStep(st.ID, StepDNSZone)
+	time.Sleep(5 * time.Millisecond)
+	_ = mgr.AdvanceStep(st.ID, StepDNSRecords)
+	_ = mgr.SetError(st.ID, "boom")
+	time.Sleep(50 * time.Millisecond) // failed, not timed
+
+	_ = mgr.MarkProvisioning(st.ID)
+	time.Sleep(5 * time.Millisecond)
+	_ = mgr.AdvanceStep(st.ID, StepPullZone)
+	_ = mgr.AdvanceStep(st.ID, StepCNAMESync)
+	_ = mgr.AdvanceStep(st.ID, StepDone)
+	if err := mgr.MarkSuccess(st.ID); err != nil {
+		t.Fatalf("MarkSuccess failed: %v", err)
+	}
+
+	// A stale copy written back keeps the recorded durations
+	stale := *st
+	if err := mgr.Update(&stale); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	got, _ := mgr.Get(st.ID)
+	if d := got.StepDurations[StepName(StepDNSZone)]; d < 5*time.Millisecond {
+		t.Errorf("Expected DNS zone step of at least 5ms, got %v", d)
+	}
+	if d := got.StepDurations[StepName(StepDNSRecords)]; d < 5*time.Millisecond || d >= 50*time.Millisecond {
+		t.Errorf("Expected DNS records step timed only while provisioning, got %v", d)
+	}
+	if _, ok := got.StepDurations[StepName(StepDone)]; ok {
+		t.Error("Expected the final step not to be timed")
+	}
+	if !got.StepStartedAt.IsZero() {
+		t.Errorf("Expected the step clock stopped, got %v", got.StepStartedAt)
+	}
+	if got.ProvisionTime() < 10*time.Millisecond {
+		t.Errorf("Expected total provision time of at least 10ms, got %v", got.ProvisionTime())
+	}
+}
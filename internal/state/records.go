@@ -0,0 +1,229 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// recordExt is the extension of per-state record files
+const recordExt = ".json"
+
+// RecordsDir returns the directory that holds one file per provisioning
+// state for a state file path, e.g. state.json -> state.d
+func RecordsDir(stateFile string) string {
+	return strings.TrimSuffix(stateFile, filepath.Ext(stateFile)) + ".d"
+}
+
+// GetRecordsDir returns the per-state records directory
+func (m *Manager) GetRecordsDir() string {
+	return RecordsDir(m.filePath)
+}
+
+// recordPath returns the file holding one state
+func (m *Manager) recordPath(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid state id %q", id)
+	}
+	return filepath.Join(m.GetRecordsDir(), id+recordExt), nil
+}
+
+// persist saves a single state: written to its record, or staged for the
+// next flush when the manager batches
+// Caller must hold m.mu
+func (m *Manager) persist(state *ProvisionState) error {
+	if _, err := m.recordPath(state.ID); err != nil {
+		return err
+	}
+	data, err := marshalRecord(state)
+	if err != nil {
+		return err
+	}
+	if m.batch != nil {
+		return m.stage(state.ID, data)
+	}
+	return m.writeRecord(state.ID, data)
+}
+
+// unpersist removes the record of a state, or stages its removal for the
+// next flush when the manager batches
+// Caller must hold m.mu
+func (m *Manager) unpersist(id string) error {
+	if _, err := m.recordPath(id); err != nil {
+		return err
+	}
+	if m.batch != nil {
+		return m.stage(id, nil)
+	}
+	return m.removeRecord(id)
+}
+
+// writeRecord writes the record of a state, keeping the previous version as
+// <id>.json.bak for corruption recovery
+func (m *Manager) writeRecord(id string, data []byte) error {
+	path, err := m.recordPath(id)
+	if err != nil {
+		return err
+	}
+
+	// A hard link keeps the old record without copying it; filesystems
+	// without link support simply go without a backup
+	os.Remove(path + ".bak")
+	if err := os.Link(path, path+".bak"); err != nil && !os.IsNotExist(err) {
+		m.logger.Debug("Failed to keep state record backup", zap.String("id", id), zap.Error(err))
+	}
+
+	n, err := writeSealed(path, data, m.keyring)
+	if err != nil {
+		return fmt.Errorf("failed to write state record: %w", err)
+	}
+	m.writes.record(n)
+	return nil
+}
+
+// removeRecord removes the record of a state and its backup
+func (m *Manager) removeRecord(id string) error {
+	path, err := m.recordPath(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove state record: %w", err)
+	}
+	os.Remove(path + ".bak")
+
+	return filestore.SyncDir(m.GetRecordsDir())
+}
+
+// loadRecords reads every state record from the records directory
+// A corrupted record falls back to its backup; if that fails too the record
+// is moved aside as .corrupt and skipped so one bad file cannot block startup
+func (m *Manager) loadRecords() ([]*ProvisionState, error) {
+	dir := m.GetRecordsDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	var states []*ProvisionState
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, recordExt) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+
+		state, err := m.readRecord(path)
+		if err == nil {
+			states = append(states, state)
+			continue
+		}
+		// Records sealed with a key that is not configured are not corrupt
+		if keyMissing(err) {
+			return nil, fmt.Errorf("failed to read state record %s: %w", name, err)
+		}
+
+		backup, backupErr := m.readRecord(path + ".bak")
+		if backupErr == nil {
+			m.logger.Error("STATE RECORD CORRUPTED, falling back to its previous version",
+				zap.String("path", path),
+				zap.String("domain", backup.Domain),
+				zap.Error(err))
+			if err := os.Rename(path, path+".corrupt"); err == nil {
+				if data, err := json.MarshalIndent(backup, "", "  "); err == nil {
+					_, _ = writeSealed(path, data, m.keyring)
+				}
+			}
+			states = append(states, backup)
+			continue
+		}
+
+		m.logger.Error("STATE RECORD CORRUPTED and no usable backup, skipping it",
+			zap.String("path", path),
+			zap.String("corrupt_copy", path+".corrupt"),
+			zap.Error(err))
+		_ = os.Rename(path, path+".corrupt")
+	}
+
+	return states, nil
+}
+
+// readRecord reads and decodes one state record
+func (m *Manager) readRecord(path string) (*ProvisionState, error) {
+	data, err := readSealed(path, m.keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	var state ProvisionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.ID == "" {
+		return nil, fmt.Errorf("state record has no id")
+	}
+	return &state, nil
+}
+
+// migrate converts the monolithic state file into the records directory
+// Records are written to a temporary directory that is renamed into place,
+// so an interrupted migration is simply repeated on the next start
+func (m *Manager) migrate(states []*ProvisionState) error {
+	dir := m.GetRecordsDir()
+	tmpDir := dir + ".tmp"
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return fmt.Errorf("failed to clear migration directory: %w", err)
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create migration directory: %w", err)
+	}
+
+	for _, state := range states {
+		path, err := m.recordPath(state.ID)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal state: %w", err)
+		}
+		if _, err := writeSealed(filepath.Join(tmpDir, filepath.Base(path)), data, m.keyring); err != nil {
+			return fmt.Errorf("failed to write state record: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("failed to move state directory into place: %w", err)
+	}
+	if err := filestore.SyncDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+
+	m.logger.Info("Migrated state file to per-record storage",
+		zap.Int("count", len(states)),
+		zap.String("dir", dir))
+
+	m.retireLegacy()
+	return nil
+}
+
+// retireLegacy renames a monolithic state file left next to the records
+// directory so it is not mistaken for current state
+func (m *Manager) retireLegacy() {
+	if _, err := os.Stat(m.filePath); err != nil {
+		return
+	}
+	if err := os.Rename(m.filePath, m.filePath+".migrated"); err != nil {
+		m.logger.Warn("Failed to rename migrated state file",
+			zap.String("path", m.filePath),
+			zap.Error(err))
+	}
+}
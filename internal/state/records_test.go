@@ -0,0 +1,176 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordsDir(t *testing.T) {
+	if got := RecordsDir("/var/lib/whm2bunny/state.json"); got != "/var/lib/whm2bunny/state.d" {
+		t.Errorf("Expected state.d, got %s", got)
+	}
+}
+
+func TestManager_PersistsOneFilePerState(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	a := mgr.Create("a.com")
+	b := mgr.Create("b.com")
+
+	for _, id := range []string{a.ID, b.ID} {
+		if _, err := os.Stat(filepath.Join(RecordsDir(filePath), id+".json")); err != nil {
+			t.Errorf("Expected record file for %s, got %v", id, err)
+		}
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no monolithic state file, got %v", err)
+	}
+
+	if err := mgr.Delete(a.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(RecordsDir(filePath), a.ID+".json")); !os.IsNotExist(err) {
+		t.Errorf("Expected record file to be removed, got %v", err)
+	}
+}
+
+func TestManager_RecordBackupOnUpdate(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	st := mgr.Create("example.com")
+	_ = mgr.MarkProvisioning(st.ID)
+
+	backup, err := mgr.readRecord(filepath.Join(RecordsDir(filePath), st.ID+".json.bak"))
+	if err != nil {
+		t.Fatalf("Expected record backup, got %v", err)
+	}
+	if backup.Status != StatusPending {
+		t.Errorf("Expected backup to hold the previous version, got %s", backup.Status)
+	}
+}
+
+func TestManager_CorruptedRecordFallsBackToBackup(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+	st := mgr.Create("example.com")
+	_ = mgr.MarkProvisioning(st.ID)
+
+	path := filepath.Join(RecordsDir(filePath), st.ID+".json")
+	if err := os.WriteFile(path, []byte(`{"id": "trunc`), 0644); err != nil {
+		t.Fatalf("Failed to corrupt record: %v", err)
+	}
+
+	recovered, err := NewManager(filePath, getTestLogger())
+	if err != nil {
+		t.Fatalf("Expected fallback to backup, got %v", err)
+	}
+	got, err := recovered.GetByDomain("example.com")
+	if err != nil {
+		t.Fatalf("Expected example.com from backup, got %v", err)
+	}
+	if got.Status != StatusPending {
+		t.Errorf("Expected previous version from backup, got %s", got.Status)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("Expected corrupted record to be kept aside, got %v", err)
+	}
+}
+
+func TestManager_CorruptedRecordWithoutBackupIsSkipped(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+	bad := mgr.Create("bad.com")
+	mgr.Create("good.com")
+
+	path := filepath.Join(RecordsDir(filePath), bad.ID+".json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt record: %v", err)
+	}
+
+	recovered, err := NewManager(filePath, getTestLogger())
+	if err != nil {
+		t.Fatalf("Expected startup despite a corrupted record, got %v", err)
+	}
+	if recovered.GetCount() != 1 {
+		t.Errorf("Expected 1 loadable state, got %d", recovered.GetCount())
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("Expected corrupted record to be kept aside, got %v", err)
+	}
+}
+
+func TestManager_MigratesMonolithicFile(t *testing.T) {
+	filePath := getTempDir(t)
+	legacy := `[
+  {"id": "1", "domain": "a.com", "status": "success", "current_step": 4, "retries": 0},
+  {"id": "2", "domain": "b.com", "status": "failed", "current_step": 2, "retries": 1}
+]`
+	if err := os.WriteFile(filePath, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write legacy state: %v", err)
+	}
+
+	mgr, err := NewManager(filePath, getTestLogger())
+	if err != nil {
+		t.Fatalf("Expected migration to succeed, got %v", err)
+	}
+	if mgr.GetCount() != 2 {
+		t.Errorf("Expected 2 migrated states, got %d", mgr.GetCount())
+	}
+	if _, err := os.Stat(filepath.Join(RecordsDir(filePath), "2.json")); err != nil {
+		t.Errorf("Expected migrated record file, got %v", err)
+	}
+	if _, err := os.Stat(filePath + ".migrated"); err != nil {
+		t.Errorf("Expected legacy file to be renamed, got %v", err)
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy file to be gone, got %v", err)
+	}
+
+	reloaded, err := NewManager(filePath, getTestLogger())
+	if err != nil || reloaded.GetCount() != 2 {
+		t.Errorf("Expected migrated states to reload, got %v", err)
+	}
+}
+
+func TestManager_MigratesFromLegacyBackup(t *testing.T) {
+	filePath := getTempDir(t)
+	if err := os.WriteFile(filePath, []byte(`[{"id": "trunc`), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+	backup := `[{"id": "1", "domain": "a.com", "status": "success", "current_step": 4, "retries": 0}]`
+	if err := os.WriteFile(filePath+".bak", []byte(backup), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+
+	mgr, err := NewManager(filePath, getTestLogger())
+	if err != nil {
+		t.Fatalf("Expected fallback to backup, got %v", err)
+	}
+	if _, err := mgr.GetByDomain("a.com"); err != nil {
+		t.Errorf("Expected a.com from backup, got %v", err)
+	}
+	if _, err := os.Stat(filePath + ".corrupt"); err != nil {
+		t.Errorf("Expected corrupted file to be kept aside, got %v", err)
+	}
+}
+
+func TestManager_CorruptedLegacyFileWithoutBackup(t *testing.T) {
+	filePath := getTempDir(t)
+	if err := os.WriteFile(filePath, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	if _, err := NewManager(filePath, getTestLogger()); err == nil {
+		t.Error("Expected error for corrupted state without backup")
+	}
+}
+
+func TestManager_RejectsUnsafeRecordIDs(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+	if err := mgr.persist(&ProvisionState{ID: "../escape", Domain: "x.com"}); err == nil {
+		t.Error("Expected error for an id containing a path separator")
+	}
+}
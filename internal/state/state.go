@@ -5,68 +5,155 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
 )
 
 const (
 	// StatusPending indicates the domain is waiting to be provisioned
-	StatusPending = "pending"
+	StatusPending Status = "pending"
 	// StatusProvisioning indicates the domain is currently being provisioned
-	StatusProvisioning = "provisioning"
+	StatusProvisioning Status = "provisioning"
 	// StatusSuccess indicates the domain was successfully provisioned
-	StatusSuccess = "success"
+	StatusSuccess Status = "success"
 	// StatusFailed indicates the provisioning failed
-	StatusFailed = "failed"
+	StatusFailed Status = "failed"
 )
 
-// Step constants representing each provisioning step
+// Step constants representing each domain provisioning step; CurrentStep
+// holds the step being worked on and StepDone once every step is complete
 const (
-	StepNone       = 0
-	StepDNSZone    = 1
-	StepDNSRecords = 2
-	StepPullZone   = 3
-	StepCNAMESync  = 4
+	StepNone       Step = 0
+	StepDNSZone    Step = 1
+	StepDNSRecords Step = 2
+	StepPullZone   Step = 3
+	StepCNAMESync  Step = 4
+	StepDone       Step = 5
 )
 
 // ProvisionState tracks the provisioning progress of a domain
 type ProvisionState struct {
-	ID          string    `json:"id"`           // UUID
-	Domain      string    `json:"domain"`       // Domain being provisioned
-	Status      string    `json:"status"`       // pending, provisioning, success, failed
-	CurrentStep int       `json:"current_step"` // 1-4 (DNS Zone, Records, Pull Zone, CNAME)
-	ZoneID      int64     `json:"zone_id,omitempty"`
-	PullZoneID  int64     `json:"pull_zone_id,omitempty"`
-	CDNHostname string    `json:"cdn_hostname,omitempty"`
-	Error       string    `json:"error,omitempty"`
-	Retries     int       `json:"retries"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID            string    `json:"id"`                      // UUID
+	Domain        string    `json:"domain"`                  // Domain being provisioned
+	User          string    `json:"user,omitempty"`          // WHM user owning the domain
+	Reseller      string    `json:"reseller,omitempty"`      // WHM reseller owning the user, selects the SOA email
+	Status        Status    `json:"status"`                  // pending, provisioning, success, failed
+	CurrentStep   Step      `json:"current_step"`            // 1-5 (DNS Zone, Records, Pull Zone, CNAME, Done), see SubdomainStep* for subdomains
+	ParentDomain  string    `json:"parent_domain,omitempty"` // Set for subdomains, whose ZoneID is the parent's zone
+	ZoneID        int64     `json:"zone_id,omitempty"`
+	PullZoneID    int64     `json:"pull_zone_id,omitempty"`
+	CDNHostname   string    `json:"cdn_hostname,omitempty"`
+	Package       string    `json:"package,omitempty"`         // WHM package, selects the CDN profile
+	StorageZoneID int64     `json:"storage_zone_id,omitempty"` // Perma-Cache storage zone
+	Error         string    `json:"error,omitempty"`
+	Retries       int       `json:"retries"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// ErrorClass is the kind of Error, set when it was classified
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
+	// StepDurations is the time spent in each step, keyed by step name and
+	// summed over attempts; StepStartedAt is when the current step started
+	StepDurations map[string]time.Duration `json:"step_durations,omitempty"`
+	StepStartedAt time.Time                `json:"step_started_at,omitempty"`
+	// StepActions lists the actions of the current step already done, such
+	// as the DNS records written; see CompleteActions
+	StepActions []string `json:"step_actions,omitempty"`
+	// CDNHostnameVerified is set once CDNHostname was seen to resolve
+	CDNHostnameVerified bool `json:"cdn_hostname_verified,omitempty"`
+	// DNSPropagated is set once public resolvers returned the records
+	// served by Bunny's nameservers after provisioning
+	DNSPropagated bool `json:"dns_propagated,omitempty"`
+	// VHostCheck is the outcome of the check, after provisioning, that the
+	// origin serves the site for the domain rather than its default
+	// virtual host
+	VHostCheck *VHostCheck `json:"vhost_check,omitempty"`
+	// CanonicalHost is the host www and apex requests redirect to: apex,
+	// www or none. Empty uses the canonical host of the domain's profile
+	CanonicalHost string `json:"canonical_host,omitempty"`
+	// Server names the whm2bunny instance that provisioned the domain
+	Server string `json:"server,omitempty"`
+}
+
+// VHostCheck records how the site answered through the CDN and at the
+// origin. Result is ok, default_vhost, mismatch or failed
+type VHostCheck struct {
+	Result       string    `json:"result"`
+	CDNStatus    int       `json:"cdn_status,omitempty"`
+	OriginStatus int       `json:"origin_status,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
 }
 
 // Manager handles state persistence and retrieval
 type Manager struct {
 	filePath    string
 	states      map[string]*ProvisionState
-	domainIndex map[string]string // domain -> id mapping
+	domainIndex map[string]string              // domain -> id mapping
+	userIndex   map[string]map[string]struct{} // user -> ids mapping
+	hooks       []TransitionHook
 	mu          sync.RWMutex
 	logger      *zap.Logger
+
+	// interrupted holds provisioning states loaded from disk, left by a
+	// process that stopped mid-provision; they are recovered until they move
+	interrupted map[string]struct{}
+	// newID generates state IDs
+	newID func() string
+	// clock tells the time of changes
+	clock clock.Clock
+	// writes counts the state records written
+	writes writeStats
+	// keyring encrypts the state files at rest; nil keeps them in plain JSON
+	keyring *encryption.Keyring
+	// server stamps the states created, see WithServer
+	server string
+
+	// flushInterval is set when the changes are written in batches, see
+	// WithBatching; batch then holds the changes not yet written
+	flushInterval time.Duration
+	batch         *batch
+	// walLock is held by a batching manager on its write-ahead log
+	walLock *os.File
+	// flushMu serializes the flushes, which write records without m.mu
+	flushMu sync.Mutex
+}
+
+// WithServer stamps the states a Manager creates with the name of the
+// instance; states loaded without one are attributed to it too
+func WithServer(name string) Option {
+	return func(o *options) {
+		o.server = name
+	}
 }
 
 // NewManager creates a new state manager with the specified state file path
-func NewManager(filePath string, logger *zap.Logger) (*Manager, error) {
+func NewManager(filePath string, logger *zap.Logger, opts ...Option) (*Manager, error) {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
+	o := applyOptions(opts)
 
 	m := &Manager{
 		filePath:    filePath,
 		states:      make(map[string]*ProvisionState),
 		domainIndex: make(map[string]string),
+		userIndex:   make(map[string]map[string]struct{}),
 		logger:      logger,
+		interrupted: make(map[string]struct{}),
+		newID:       uuid.NewString,
+		clock:       clock.Real{},
+		keyring:     o.keyring,
+		server:      o.server,
+
+		flushInterval: o.flushInterval,
 	}
 
 	// Ensure directory exists
@@ -79,96 +166,189 @@ func NewManager(filePath string, logger *zap.Logger) (*Manager, error) {
 		return nil, fmt.Errorf("failed to load state: %w", err)
 	}
 
+	if m.flushInterval > 0 {
+		m.startBatching(m.flushInterval, o.flushMutations)
+	}
+
 	return m, nil
 }
 
-// load reads the state from disk
+// load reads the state from the records directory, migrating a
+// monolithic state file on first start
 func (m *Manager) load() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	data, err := os.ReadFile(m.filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			m.logger.Info("State file does not exist, starting with empty state",
-				zap.String("path", m.filePath))
-			return nil
+	var states []*ProvisionState
+	_, err := os.Stat(m.GetRecordsDir())
+	switch {
+	case err == nil:
+		states, err = m.loadRecords()
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to read state file: %w", err)
-	}
-
-	if len(data) == 0 {
-		return nil
+		// A monolithic file next to the records means a migration was
+		// interrupted after the records were written
+		m.retireLegacy()
+	case os.IsNotExist(err):
+		legacy, found, err := m.loadLegacy()
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := m.migrate(legacy); err != nil {
+				return fmt.Errorf("failed to migrate state file: %w", err)
+			}
+		} else {
+			m.logger.Info("State directory does not exist, starting with empty state",
+				zap.String("path", m.GetRecordsDir()))
+			if err := os.MkdirAll(m.GetRecordsDir(), 0755); err != nil {
+				return fmt.Errorf("failed to create state directory: %w", err)
+			}
+		}
+		states = legacy
+	default:
+		return fmt.Errorf("failed to stat state directory: %w", err)
 	}
 
-	var states []*ProvisionState
-	if err := json.Unmarshal(data, &states); err != nil {
-		return fmt.Errorf("failed to unmarshal state: %w", err)
+	// Changes logged but not yet flushed when the process stopped
+	states, err = m.replayWAL(states)
+	if err != nil {
+		return err
 	}
 
 	m.states = make(map[string]*ProvisionState)
 	m.domainIndex = make(map[string]string)
+	m.userIndex = make(map[string]map[string]struct{})
+
+	m.interrupted = make(map[string]struct{})
 
 	for _, state := range states {
+		// States written before the instance was named were made here
+		if state.Server == "" {
+			state.Server = m.server
+		}
 		m.states[state.ID] = state
 		m.domainIndex[state.Domain] = state.ID
+		m.indexUser(state)
+		if state.Status == StatusProvisioning {
+			m.interrupted[state.ID] = struct{}{}
+		}
 	}
+	m.migrateSubdomains()
+	m.completeSucceeded()
 
 	m.logger.Info("Loaded state from disk",
 		zap.Int("count", len(states)),
-		zap.String("path", m.filePath))
+		zap.String("path", m.GetRecordsDir()))
 
 	return nil
 }
 
-// save writes the state to disk
-func (m *Manager) save() error {
-	states := make([]*ProvisionState, 0, len(m.states))
-	for _, state := range m.states {
-		states = append(states, state)
+// loadLegacy reads a monolithic state file, falling back to its backup
+// when it is corrupted; found is false when there is no such file
+func (m *Manager) loadLegacy() (states []*ProvisionState, found bool, err error) {
+	data, err := readSealed(m.filePath, m.keyring)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read state file: %w", err)
 	}
 
-	data, err := json.MarshalIndent(states, "", "  ")
+	if len(data) == 0 {
+		return nil, true, nil
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		states, err = m.restoreBackup(err)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return states, true, nil
+}
+
+// backupPath returns the path of the last good monolithic state backup
+func (m *Manager) backupPath() string {
+	return m.filePath + ".bak"
+}
+
+// restoreBackup recovers from a corrupted state file by loading the backup
+// The corrupted file is kept as <state>.corrupt and replaced by the backup
+func (m *Manager) restoreBackup(cause error) ([]*ProvisionState, error) {
+	sealed, err := os.ReadFile(m.backupPath())
 	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal state (no usable backup: %v): %w", err, cause)
+	}
+	data, err := m.keyring.Decrypt(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state (no usable backup: %v): %w", err, cause)
 	}
 
-	// Write to temp file first for atomicity
-	tmpPath := m.filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write temp state file: %w", err)
+	var states []*ProvisionState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state (backup also corrupted: %v): %w", err, cause)
 	}
 
-	// Rename for atomic update
-	if err := os.Rename(tmpPath, m.filePath); err != nil {
-		os.Remove(tmpPath) // Clean up temp file
-		return fmt.Errorf("failed to rename state file: %w", err)
+	m.logger.Error("STATE FILE CORRUPTED, falling back to last good backup; changes since the backup are lost",
+		zap.String("path", m.filePath),
+		zap.String("backup", m.backupPath()),
+		zap.String("corrupt_copy", m.filePath+".corrupt"),
+		zap.Int("restored", len(states)),
+		zap.Error(cause))
+
+	if err := os.Rename(m.filePath, m.filePath+".corrupt"); err != nil {
+		return nil, fmt.Errorf("failed to move corrupted state file aside: %w", err)
+	}
+	if err := filestore.WriteFile(m.filePath, sealed, 0644); err != nil {
+		return nil, fmt.Errorf("failed to restore state from backup: %w", err)
 	}
 
-	return nil
+	return states, nil
+}
+
+// SetIDGenerator replaces the generator of state IDs, which are random
+// UUIDs by default; tests use it for predictable IDs
+func (m *Manager) SetIDGenerator(fn func() string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.newID = fn
+}
+
+// SetClock replaces the clock that times state changes
+func (m *Manager) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = c
 }
 
 // Create creates a new provisioning state for a domain
 func (m *Manager) Create(domain string) *ProvisionState {
+	defer m.commit(nil)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
+	now := m.clock.Now()
 
 	state := &ProvisionState{
-		ID:          uuid.New().String(),
+		ID:          m.newID(),
 		Domain:      domain,
 		Status:      StatusPending,
 		CurrentStep: StepNone,
 		Retries:     0,
 		CreatedAt:   now,
 		UpdatedAt:   now,
+		Server:      m.server,
 	}
 
 	m.states[state.ID] = state
 	m.domainIndex[domain] = state.ID
 
-	if err := m.save(); err != nil {
+	if err := m.persist(state); err != nil {
 		m.logger.Error("Failed to save state after create",
 			zap.String("domain", domain),
 			zap.Error(err))
@@ -178,7 +358,9 @@ func (m *Manager) Create(domain string) *ProvisionState {
 		zap.String("id", state.ID),
 		zap.String("domain", domain))
 
-	return state
+	// Return a copy to prevent concurrent modification
+	stateCopy := *state
+	return &stateCopy
 }
 
 // Get retrieves a state by ID
@@ -188,7 +370,7 @@ func (m *Manager) Get(id string) (*ProvisionState, error) {
 
 	state, exists := m.states[id]
 	if !exists {
-		return nil, ErrStateNotFound
+		return m.archivedByID(id)
 	}
 
 	// Return a copy to prevent concurrent modification
@@ -203,7 +385,7 @@ func (m *Manager) GetByDomain(domain string) (*ProvisionState, error) {
 
 	id, exists := m.domainIndex[domain]
 	if !exists {
-		return nil, ErrStateNotFound
+		return m.archivedByDomain(domain)
 	}
 
 	state, exists := m.states[id]
@@ -216,52 +398,93 @@ func (m *Manager) GetByDomain(domain string) (*ProvisionState, error) {
 	return &stateCopy, nil
 }
 
-// Update updates an existing provisioning state
-func (m *Manager) Update(state *ProvisionState) error {
+// Update saves the fields of an existing provisioning state. Status,
+// CurrentStep and StepActions only change through transitions
+// (MarkProvisioning, AdvanceStep, CompleteActions, ...), so Update keeps
+// their stored values and copies them back into state
+func (m *Manager) Update(state *ProvisionState) (err error) {
+	defer m.commit(&err)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	existing, exists := m.states[state.ID]
 	if !exists {
-		return ErrStateNotFound
+		// Updating an archived state makes it active again
+		archived, err := m.archivedByID(state.ID)
+		if err != nil {
+			return err
+		}
+		existing = archived
 	}
 
-	// Preserve creation time
+	// Preserve creation time and the state machine's fields
 	state.CreatedAt = existing.CreatedAt
-	state.UpdatedAt = time.Now()
+	state.Status = existing.Status
+	state.CurrentStep = existing.CurrentStep
+	state.StepDurations = existing.StepDurations
+	state.StepStartedAt = existing.StepStartedAt
+	state.StepActions = existing.StepActions
+	if state.Server == "" {
+		state.Server = existing.Server
+	}
+	state.UpdatedAt = m.clock.Now()
 
-	m.states[state.ID] = state
+	stored := *state
+	m.unindexUser(existing)
+	m.states[state.ID] = &stored
 	m.domainIndex[state.Domain] = state.ID
+	m.indexUser(&stored)
 
-	if err := m.save(); err != nil {
+	if err := m.persist(&stored); err != nil {
 		m.logger.Error("Failed to save state after update",
 			zap.String("id", state.ID),
 			zap.Error(err))
 		return fmt.Errorf("failed to save state: %w", err)
 	}
+	if !exists {
+		// Saved before it leaves the archive, so a crash in between leaves
+		// a duplicate rather than losing the state
+		if err := m.sync(); err != nil {
+			return err
+		}
+		if _, err := m.removeArchived(state.ID); err != nil {
+			return fmt.Errorf("failed to unarchive state: %w", err)
+		}
+	}
 
 	m.logger.Debug("Updated provisioning state",
 		zap.String("id", state.ID),
-		zap.String("status", state.Status),
-		zap.Int("step", state.CurrentStep))
+		zap.String("status", string(state.Status)),
+		zap.String("step", state.StepName()))
 
 	return nil
 }
 
 // Delete removes a state by ID
-func (m *Manager) Delete(id string) error {
+func (m *Manager) Delete(id string) (err error) {
+	defer m.commit(&err)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	state, exists := m.states[id]
 	if !exists {
-		return ErrStateNotFound
+		removed, err := m.removeArchived(id)
+		if err != nil {
+			return fmt.Errorf("failed to delete archived state: %w", err)
+		}
+		if !removed {
+			return ErrStateNotFound
+		}
+		m.logger.Info("Deleted archived provisioning state", zap.String("id", id))
+		return nil
 	}
 
 	delete(m.states, id)
 	delete(m.domainIndex, state.Domain)
+	delete(m.interrupted, id)
+	m.unindexUser(state)
 
-	if err := m.save(); err != nil {
+	if err := m.unpersist(id); err != nil {
 		m.logger.Error("Failed to save state after delete",
 			zap.String("id", id),
 			zap.Error(err))
@@ -321,7 +544,25 @@ func (m *Manager) ListAll() []*ProvisionState {
 	return result
 }
 
-// Recover returns states that need recovery (pending or failed with retries remaining)
+// CountByStatus returns the number of states in each status
+func (m *Manager) CountByStatus() map[Status]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[Status]int{
+		StatusPending:      0,
+		StatusProvisioning: 0,
+		StatusSuccess:      0,
+		StatusFailed:       0,
+	}
+	for _, state := range m.states {
+		counts[state.Status]++
+	}
+	return counts
+}
+
+// Recover returns states that need recovery (pending, failed with retries
+// remaining, or interrupted mid-provision by a stop)
 func (m *Manager) Recover() []*ProvisionState {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -339,114 +580,66 @@ func (m *Manager) Recover() []*ProvisionState {
 			stateCopy := *state
 			result = append(result, &stateCopy)
 		}
+		// Include provisions interrupted by a crash or restart; live
+		// provisions are never included
+		if _, ok := m.interrupted[state.ID]; ok && state.Status == StatusProvisioning {
+			stateCopy := *state
+			result = append(result, &stateCopy)
+		}
 	}
 
 	return result
 }
 
-// IncrementStep advances the current step
+// IncrementStep advances a provisioning state to the next step
 func (m *Manager) IncrementStep(id string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	m.mu.RLock()
 	state, exists := m.states[id]
-	if !exists {
-		return ErrStateNotFound
+	var next Step
+	if exists {
+		next = state.CurrentStep + 1
 	}
+	m.mu.RUnlock()
 
-	state.CurrentStep++
-	state.UpdatedAt = time.Now()
-
-	if err := m.save(); err != nil {
-		m.logger.Error("Failed to save state after increment",
-			zap.String("id", id),
-			zap.Error(err))
-		return fmt.Errorf("failed to save state: %w", err)
-	}
-
-	return nil
-}
-
-// SetError sets an error message and marks the state as failed
-func (m *Manager) SetError(id, errMsg string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	state, exists := m.states[id]
 	if !exists {
 		return ErrStateNotFound
 	}
+	return m.AdvanceStep(id, next)
+}
 
-	state.Status = StatusFailed
-	state.Error = errMsg
-	state.Retries++
-	state.UpdatedAt = time.Now()
-
-	if err := m.save(); err != nil {
-		m.logger.Error("Failed to save state after error",
-			zap.String("id", id),
-			zap.Error(err))
-		return fmt.Errorf("failed to save state: %w", err)
-	}
-
-	m.logger.Warn("Marked state as failed",
-		zap.String("id", id),
-		zap.String("error", errMsg),
-		zap.Int("retry", state.Retries))
-
-	return nil
+// SetError sets an error message and marks the state as failed, leaving
+// the error unclassified
+func (m *Manager) SetError(id, errMsg string) error {
+	return m.SetFailure(id, errMsg, "")
 }
 
-// MarkSuccess marks the state as successfully provisioned
+// MarkSuccess marks the state as successfully provisioned; every step must
+// be complete
 func (m *Manager) MarkSuccess(id string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	state, exists := m.states[id]
-	if !exists {
-		return ErrStateNotFound
-	}
-
-	state.Status = StatusSuccess
-	state.CurrentStep = StepCNAMESync
-	state.Error = ""
-	state.UpdatedAt = time.Now()
-
-	if err := m.save(); err != nil {
-		m.logger.Error("Failed to save state after success",
-			zap.String("id", id),
-			zap.Error(err))
-		return fmt.Errorf("failed to save state: %w", err)
+	err := m.transition(id, StatusSuccess, "", func(state *ProvisionState) {
+		state.Error = ""
+		state.ErrorClass = ""
+	})
+	if err != nil {
+		return err
 	}
 
 	m.logger.Info("Marked state as success",
-		zap.String("id", id),
-		zap.String("domain", state.Domain))
+		zap.String("id", id))
 
 	return nil
 }
 
 // MarkProvisioning marks the state as currently being provisioned
 func (m *Manager) MarkProvisioning(id string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	state, exists := m.states[id]
-	if !exists {
-		return ErrStateNotFound
-	}
-
-	state.Status = StatusProvisioning
-	state.UpdatedAt = time.Now()
-
-	if err := m.save(); err != nil {
-		m.logger.Error("Failed to save state after marking provisioning",
-			zap.String("id", id),
-			zap.Error(err))
-		return fmt.Errorf("failed to save state: %w", err)
-	}
+	return m.transition(id, StatusProvisioning, "", nil)
+}
 
-	return nil
+// MarkPending puts a state back in the queue with a reason, without counting a retry
+func (m *Manager) MarkPending(id, reason string) error {
+	return m.transition(id, StatusPending, reason, func(state *ProvisionState) {
+		state.Error = reason
+	})
 }
 
 // GetStateFilePath returns the current state file path
@@ -463,15 +656,18 @@ func (m *Manager) GetCount() int {
 }
 
 // Clear removes all states (use with caution)
-func (m *Manager) Clear() error {
+func (m *Manager) Clear() (err error) {
+	defer m.commit(&err)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.states = make(map[string]*ProvisionState)
-	m.domainIndex = make(map[string]string)
-
-	if err := m.save(); err != nil {
-		return fmt.Errorf("failed to save state after clear: %w", err)
+	for id := range m.states {
+		if err := m.unpersist(id); err != nil {
+			return fmt.Errorf("failed to save state after clear: %w", err)
+		}
+		delete(m.domainIndex, m.states[id].Domain)
+		m.unindexUser(m.states[id])
+		delete(m.states, id)
 	}
 
 	m.logger.Info("Cleared all states")
@@ -479,8 +675,9 @@ func (m *Manager) Clear() error {
 	return nil
 }
 
-// StepName returns the human-readable name for a step number
-func StepName(step int) string {
+// StepName returns the human-readable name for a domain step number; use
+// ProvisionState.StepName for a name that also covers subdomains
+func StepName(step Step) string {
 	switch step {
 	case StepNone:
 		return "none"
@@ -492,6 +689,8 @@ func StepName(step int) string {
 		return "pull_zone"
 	case StepCNAMESync:
 		return "cname_sync"
+	case StepDone:
+		return "done"
 	default:
 		return "unknown"
 	}
@@ -508,12 +707,76 @@ var (
 // BandwidthSnapshot stores bandwidth statistics for historical comparison
 type BandwidthSnapshot struct {
 	Timestamp   time.Time `json:"timestamp"`
+	From        time.Time `json:"from,omitempty"` // Start of the period the totals cover
+	To          time.Time `json:"to,omitempty"`   // End of the period the totals cover
 	ZoneID      int64     `json:"zone_id"`
 	ZoneName    string    `json:"zone_name"`
 	Bandwidth   int64     `json:"bandwidth"`
 	Requests    int64     `json:"requests"`
 	CacheHits   int64     `json:"cache_hits"`
 	CacheMisses int64     `json:"cache_misses"`
+	// Rollup marks a daily snapshot summed from snapshots of parts of the day
+	Rollup bool `json:"rollup,omitempty"`
+}
+
+// end returns when the period of the snapshot ended, or when it was taken
+// for snapshots without a period
+func (b BandwidthSnapshot) end() time.Time {
+	if b.To.IsZero() {
+		return b.Timestamp
+	}
+	return b.To
+}
+
+// day returns the bounds of the day the snapshot's period starts in, ending
+// at 23:59:59 like the periods of the daily summary
+func (b BandwidthSnapshot) day() (from, to time.Time) {
+	from = time.Date(b.From.Year(), b.From.Month(), b.From.Day(), 0, 0, 0, 0, b.From.Location())
+	return from, from.AddDate(0, 0, 1).Add(-time.Second)
+}
+
+// Daily reports whether the snapshot covers exactly one day, like the
+// snapshots of the daily summary and their rollups
+func (b BandwidthSnapshot) Daily() bool {
+	if b.From.IsZero() {
+		return false
+	}
+	from, to := b.day()
+	return b.From.Equal(from) && b.To.Equal(to)
+}
+
+// Hourly reports whether the snapshot covers one hour, like the snapshots
+// the traffic anomaly check keeps
+func (b BandwidthSnapshot) Hourly() bool {
+	return !b.From.IsZero() && b.To.Sub(b.From) == time.Hour-time.Second
+}
+
+// partOfDay reports whether the snapshot covers part of a single day, such
+// as an hour
+func (b BandwidthSnapshot) partOfDay() bool {
+	if b.From.IsZero() {
+		return false
+	}
+	from, to := b.day()
+	return !b.To.After(to) && !(b.From.Equal(from) && b.To.Equal(to))
+}
+
+// SnapshotRetention is how long bandwidth snapshots are kept
+type SnapshotRetention struct {
+	// Raw is how long snapshots of part of a day, or without a period, are
+	// kept; older ones are rolled up into daily snapshots
+	Raw time.Duration
+	// Daily is how long daily and longer snapshots are kept
+	Daily time.Duration
+}
+
+// defaultSnapshotRetention keeps every snapshot for 30 days
+var defaultSnapshotRetention = SnapshotRetention{Raw: 30 * 24 * time.Hour, Daily: 30 * 24 * time.Hour}
+
+// CompactResult reports the changes made by SnapshotStore.Compact
+type CompactResult struct {
+	RolledUp int `json:"rolled_up"`
+	Removed  int `json:"removed"`
 }
 
 // SnapshotStore manages bandwidth snapshots
@@ -522,10 +785,14 @@ type SnapshotStore struct {
 	snapshots []BandwidthSnapshot
 	mu        sync.RWMutex
 	logger    *zap.Logger
+	clock     clock.Clock
+	retention SnapshotRetention
+	// keyring encrypts the snapshot file at rest (optional)
+	keyring *encryption.Keyring
 }
 
 // NewSnapshotStore creates a new snapshot store
-func NewSnapshotStore(filePath string, logger *zap.Logger) (*SnapshotStore, error) {
+func NewSnapshotStore(filePath string, logger *zap.Logger, opts ...Option) (*SnapshotStore, error) {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
@@ -534,6 +801,9 @@ func NewSnapshotStore(filePath string, logger *zap.Logger) (*SnapshotStore, erro
 		filePath:  filePath,
 		snapshots: make([]BandwidthSnapshot, 0),
 		logger:    logger,
+		clock:     clock.Real{},
+		retention: defaultSnapshotRetention,
+		keyring:   applyOptions(opts).keyring,
 	}
 
 	// Ensure directory exists
@@ -549,12 +819,29 @@ func NewSnapshotStore(filePath string, logger *zap.Logger) (*SnapshotStore, erro
 	return s, nil
 }
 
+// SetClock replaces the clock that decides which snapshots are old
+func (s *SnapshotStore) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = c
+}
+
+// SetRetention sets how long snapshots are kept; by default every snapshot
+// is kept for 30 days
+func (s *SnapshotStore) SetRetention(r SnapshotRetention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retention = r
+}
+
 // load reads snapshots from disk
 func (s *SnapshotStore) load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := os.ReadFile(s.filePath)
+	data, err := readSealed(s.filePath, s.keyring)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -580,14 +867,8 @@ func (s *SnapshotStore) save() error {
 		return fmt.Errorf("failed to marshal snapshots: %w", err)
 	}
 
-	tmpPath := s.filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write temp snapshot file: %w", err)
-	}
-
-	if err := os.Rename(tmpPath, s.filePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename snapshot file: %w", err)
+	if _, err := writeSealed(s.filePath, data, s.keyring); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
 	}
 
 	return nil
@@ -595,20 +876,34 @@ func (s *SnapshotStore) save() error {
 
 // AddSnapshot adds a new bandwidth snapshot
 func (s *SnapshotStore) AddSnapshot(snapshot BandwidthSnapshot) error {
+	return s.AddSnapshots([]BandwidthSnapshot{snapshot})
+}
+
+// AddSnapshots adds bandwidth snapshots with a single write; a snapshot
+// replaces an earlier one of the same zone and period
+func (s *SnapshotStore) AddSnapshots(snapshots []BandwidthSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.snapshots = append(s.snapshots, snapshot)
-
-	// Clean up old snapshots (keep last 30 days)
-	cutoff := time.Now().AddDate(0, 0, -30)
-	filtered := make([]BandwidthSnapshot, 0, len(s.snapshots))
-	for _, snap := range s.snapshots {
-		if snap.Timestamp.After(cutoff) {
-			filtered = append(filtered, snap)
+	for _, snapshot := range snapshots {
+		if !snapshot.From.IsZero() {
+			s.snapshots = slices.DeleteFunc(s.snapshots, func(snap BandwidthSnapshot) bool {
+				return snap.ZoneID == snapshot.ZoneID && snap.From.Equal(snapshot.From) && snap.To.Equal(snapshot.To)
+			})
 		}
+		s.snapshots = append(s.snapshots, snapshot)
 	}
-	s.snapshots = filtered
+
+	// Clean up snapshots taken before the retention; Compact removes them
+	// by the end of their period
+	cutoff := s.clock.Now().Add(-s.retention.Daily)
+	s.snapshots = slices.DeleteFunc(s.snapshots, func(snap BandwidthSnapshot) bool {
+		return !snap.Timestamp.After(cutoff)
+	})
 
 	if err := s.save(); err != nil {
 		s.logger.Error("Failed to save snapshots",
@@ -634,6 +929,22 @@ func (s *SnapshotStore) GetSnapshotsByZone(zoneID int64, since time.Time) []Band
 	return result
 }
 
+// GetSnapshotForPeriod retrieves the snapshot of a zone covering exactly
+// [from, to], or nil when there is none
+func (s *SnapshotStore) GetSnapshotForPeriod(zoneID int64, from, to time.Time) *BandwidthSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.snapshots {
+		snap := s.snapshots[i]
+		if snap.ZoneID == zoneID && snap.From.Equal(from) && snap.To.Equal(to) {
+			return &snap
+		}
+	}
+
+	return nil
+}
+
 // GetAllSnapshots retrieves all snapshots since a given time
 func (s *SnapshotStore) GetAllSnapshots(since time.Time) []BandwidthSnapshot {
 	s.mu.RLock()
@@ -672,7 +983,7 @@ func (s *SnapshotStore) Cleanup(olderThan time.Duration) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	cutoff := time.Now().Add(-olderThan)
+	cutoff := s.clock.Now().Add(-olderThan)
 	filtered := make([]BandwidthSnapshot, 0, len(s.snapshots))
 	for _, snap := range s.snapshots {
 		if snap.Timestamp.After(cutoff) {
@@ -688,3 +999,85 @@ func (s *SnapshotStore) Cleanup(olderThan time.Duration) error {
 
 	return nil
 }
+
+// Compact applies the retention: snapshots of part of a day older than the
+// raw retention are summed into a daily rollup per zone and day, snapshots
+// without a period older than the raw retention are removed, and all
+// snapshots older than the daily retention are removed. A day that already
+// has a daily snapshot from Bunny keeps it and drops the parts
+func (s *SnapshotStore) Compact() (CompactResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	rawCutoff := now.Add(-s.retention.Raw)
+	dailyCutoff := now.Add(-s.retention.Daily)
+
+	type dayKey struct {
+		zoneID int64
+		from   int64
+	}
+	days := make(map[dayKey]int)
+
+	var result CompactResult
+	kept := make([]BandwidthSnapshot, 0, len(s.snapshots))
+	var raw []BandwidthSnapshot
+	for _, snap := range s.snapshots {
+		switch {
+		case !snap.end().After(dailyCutoff):
+			result.Removed++
+		case snap.From.IsZero() && !snap.Timestamp.After(rawCutoff):
+			result.Removed++
+		case snap.partOfDay() && !snap.To.After(rawCutoff):
+			raw = append(raw, snap)
+		default:
+			if from, to := snap.day(); !snap.From.IsZero() && snap.From.Equal(from) && snap.To.Equal(to) {
+				days[dayKey{snap.ZoneID, from.Unix()}] = len(kept)
+			}
+			kept = append(kept, snap)
+		}
+	}
+
+	for _, snap := range raw {
+		from, to := snap.day()
+		if !to.After(dailyCutoff) {
+			result.Removed++
+			continue
+		}
+
+		key := dayKey{snap.ZoneID, from.Unix()}
+		i, ok := days[key]
+		if !ok {
+			i = len(kept)
+			days[key] = i
+			kept = append(kept, BandwidthSnapshot{
+				Timestamp: now,
+				From:      from,
+				To:        to,
+				ZoneID:    snap.ZoneID,
+				ZoneName:  snap.ZoneName,
+				Rollup:    true,
+			})
+		} else if !kept[i].Rollup {
+			result.Removed++
+			continue
+		}
+
+		kept[i].Bandwidth += snap.Bandwidth
+		kept[i].Requests += snap.Requests
+		kept[i].CacheHits += snap.CacheHits
+		kept[i].CacheMisses += snap.CacheMisses
+		result.RolledUp++
+	}
+
+	if result == (CompactResult{}) {
+		return result, nil
+	}
+
+	s.snapshots = kept
+	if err := s.save(); err != nil {
+		return result, fmt.Errorf("failed to save snapshots after compaction: %w", err)
+	}
+
+	return result, nil
+}
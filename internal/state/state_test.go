@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
 )
 
 // getTestLogger returns a test logger
@@ -17,6 +19,61 @@ func getTestLogger() *zap.Logger {
 	return zap.NewNop()
 }
 
+// setStatus puts a state in a status directly, bypassing the state machine,
+// to set up fixtures
+func setStatus(t *testing.T, mgr *Manager, id string, status Status) {
+	t.Helper()
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	st, ok := mgr.states[id]
+	if !ok {
+		t.Fatalf("State %s not found", id)
+	}
+	st.Status = status
+	if status == StatusSuccess {
+		st.CurrentStep = st.finalStep()
+	}
+	if err := mgr.persist(st); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+}
+
+// setStep puts a state at a step directly, bypassing the state machine,
+// to set up fixtures
+func setStep(t *testing.T, mgr *Manager, id string, step Step) {
+	t.Helper()
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	st, ok := mgr.states[id]
+	if !ok {
+		t.Fatalf("State %s not found", id)
+	}
+	st.CurrentStep = step
+	if err := mgr.persist(st); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+}
+
+// completeState provisions a state through every step to success
+func completeState(t *testing.T, mgr *Manager, id string) {
+	t.Helper()
+	st, err := mgr.Get(id)
+	if err != nil {
+		t.Fatalf("State %s not found", id)
+	}
+	if err := mgr.MarkProvisioning(id); err != nil {
+		t.Fatalf("Failed to mark provisioning: %v", err)
+	}
+	if err := mgr.AdvanceStep(id, st.finalStep()); err != nil {
+		t.Fatalf("Failed to advance step: %v", err)
+	}
+	if err := mgr.MarkSuccess(id); err != nil {
+		t.Fatalf("Failed to mark success: %v", err)
+	}
+}
+
 // getTempDir returns a temporary directory for tests
 func getTempDir(t *testing.T) string {
 	t.Helper()
@@ -263,8 +320,6 @@ func TestManager_Update(t *testing.T) {
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		state := mgr.Create("update.com")
-		state.Status = StatusProvisioning
-		state.CurrentStep = StepDNSZone
 		state.ZoneID = 12345
 
 		err := mgr.Update(state)
@@ -275,14 +330,6 @@ func TestManager_Update(t *testing.T) {
 
 		// Verify update
 		retrieved, _ := mgr.Get(state.ID)
-		if retrieved.Status != StatusProvisioning {
-			t.Errorf("Expected status '%s', got '%s'", StatusProvisioning, retrieved.Status)
-		}
-
-		if retrieved.CurrentStep != StepDNSZone {
-			t.Errorf("Expected step %d, got %d", StepDNSZone, retrieved.CurrentStep)
-		}
-
 		if retrieved.ZoneID != 12345 {
 			t.Errorf("Expected ZoneID 12345, got %d", retrieved.ZoneID)
 		}
@@ -298,7 +345,7 @@ func TestManager_Update(t *testing.T) {
 		// Wait a bit to ensure time difference
 		time.Sleep(10 * time.Millisecond)
 
-		state.Status = StatusProvisioning
+		state.ZoneID = 12345
 		mgr.Update(state)
 
 		retrieved, _ := mgr.Get(state.ID)
@@ -311,6 +358,27 @@ func TestManager_Update(t *testing.T) {
 		}
 	})
 
+	t.Run("keeps status and step", func(t *testing.T) {
+		filePath := getTempDir(t)
+		mgr, _ := NewManager(filePath, getTestLogger())
+
+		state := mgr.Create("machine.com")
+		state.Status = StatusSuccess
+		state.CurrentStep = StepDNSZone
+
+		if err := mgr.Update(state); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		retrieved, _ := mgr.Get(state.ID)
+		if retrieved.Status != StatusPending || retrieved.CurrentStep != StepNone {
+			t.Errorf("Expected pending at step none, got %s at %s", retrieved.Status, retrieved.StepName())
+		}
+		if state.Status != StatusPending || state.CurrentStep != StepNone {
+			t.Error("Expected stored status and step to be copied back")
+		}
+	})
+
 	t.Run("returns error for non-existent state", func(t *testing.T) {
 		filePath := getTempDir(t)
 		mgr, _ := NewManager(filePath, getTestLogger())
@@ -332,14 +400,14 @@ func TestManager_Update(t *testing.T) {
 		mgr1, _ := NewManager(filePath, getTestLogger())
 
 		state := mgr1.Create("persist-update.com")
-		state.Status = StatusSuccess
+		state.Package = "pro"
 		mgr1.Update(state)
 
 		// Load in new manager
 		mgr2, _ := NewManager(filePath, getTestLogger())
 		retrieved, _ := mgr2.Get(state.ID)
 
-		if retrieved.Status != StatusSuccess {
+		if retrieved.Package != "pro" {
 			t.Error("Update should be persisted")
 		}
 	})
@@ -393,15 +461,15 @@ func TestManager_ListPending(t *testing.T) {
 
 		mgr.Create("pending1.com")
 		s2 := mgr.Create("pending2.com")
-		s2.Status = StatusProvisioning
+		setStatus(t, mgr, s2.ID, StatusProvisioning)
 		mgr.Update(s2)
 
 		s3 := mgr.Create("success.com")
-		s3.Status = StatusSuccess
+		setStatus(t, mgr, s3.ID, StatusSuccess)
 		mgr.Update(s3)
 
 		s4 := mgr.Create("failed.com")
-		s4.Status = StatusFailed
+		setStatus(t, mgr, s4.ID, StatusFailed)
 		mgr.Update(s4)
 
 		pending := mgr.ListPending()
@@ -416,7 +484,7 @@ func TestManager_ListPending(t *testing.T) {
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		s := mgr.Create("success.com")
-		s.Status = StatusSuccess
+		setStatus(t, mgr, s.ID, StatusSuccess)
 		mgr.Update(s)
 
 		pending := mgr.ListPending()
@@ -433,15 +501,15 @@ func TestManager_ListFailed(t *testing.T) {
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		s1 := mgr.Create("failed1.com")
-		s1.Status = StatusFailed
+		setStatus(t, mgr, s1.ID, StatusFailed)
 		mgr.Update(s1)
 
 		s2 := mgr.Create("failed2.com")
-		s2.Status = StatusFailed
+		setStatus(t, mgr, s2.ID, StatusFailed)
 		mgr.Update(s2)
 
 		s3 := mgr.Create("success.com")
-		s3.Status = StatusSuccess
+		setStatus(t, mgr, s3.ID, StatusSuccess)
 		mgr.Update(s3)
 
 		failed := mgr.ListFailed()
@@ -452,6 +520,35 @@ func TestManager_ListFailed(t *testing.T) {
 	})
 }
 
+func TestManager_CountByStatus(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	mgr.Create("pending.com")
+
+	s1 := mgr.Create("failed.com")
+	setStatus(t, mgr, s1.ID, StatusFailed)
+	mgr.Update(s1)
+
+	s2 := mgr.Create("success.com")
+	setStatus(t, mgr, s2.ID, StatusSuccess)
+	mgr.Update(s2)
+
+	counts := mgr.CountByStatus()
+
+	expected := map[Status]int{
+		StatusPending:      1,
+		StatusProvisioning: 0,
+		StatusSuccess:      1,
+		StatusFailed:       1,
+	}
+	for status, want := range expected {
+		if counts[status] != want {
+			t.Errorf("Expected %d %s states, got %d", want, status, counts[status])
+		}
+	}
+}
+
 func TestManager_Recover(t *testing.T) {
 	t.Run("returns pending and failed states with retries remaining", func(t *testing.T) {
 		filePath := getTempDir(t)
@@ -462,19 +559,19 @@ func TestManager_Recover(t *testing.T) {
 
 		// Failed with retries remaining
 		s1 := mgr.Create("failed-low-retries.com")
-		s1.Status = StatusFailed
+		setStatus(t, mgr, s1.ID, StatusFailed)
 		s1.Retries = 2
 		mgr.Update(s1)
 
 		// Failed with max retries
 		s2 := mgr.Create("failed-max-retries.com")
-		s2.Status = StatusFailed
+		setStatus(t, mgr, s2.ID, StatusFailed)
 		s2.Retries = 5
 		mgr.Update(s2)
 
 		// Success
 		s3 := mgr.Create("success.com")
-		s3.Status = StatusSuccess
+		setStatus(t, mgr, s3.ID, StatusSuccess)
 		mgr.Update(s3)
 
 		recoverable := mgr.Recover()
@@ -483,6 +580,93 @@ func TestManager_Recover(t *testing.T) {
 			t.Errorf("Expected 2 recoverable states, got %d", len(recoverable))
 		}
 	})
+
+	t.Run("returns provisions interrupted by a restart", func(t *testing.T) {
+		filePath := getTempDir(t)
+		mgr, _ := NewManager(filePath, getTestLogger())
+
+		st := mgr.Create("interrupted.com")
+		if err := mgr.MarkProvisioning(st.ID); err != nil {
+			t.Fatalf("Failed to mark provisioning: %v", err)
+		}
+		if n := len(mgr.Recover()); n != 0 {
+			t.Errorf("Expected a live provision not to be recovered, got %d", n)
+		}
+
+		// A new process finds the provision stopped mid-way
+		restarted, err := NewManager(filePath, getTestLogger())
+		if err != nil {
+			t.Fatalf("Failed to reload state: %v", err)
+		}
+		recoverable := restarted.Recover()
+		if len(recoverable) != 1 || recoverable[0].Domain != "interrupted.com" {
+			t.Fatalf("Expected interrupted.com to be recovered, got %v", recoverable)
+		}
+
+		// Once resumed it is live again
+		if err := restarted.MarkProvisioning(st.ID); err != nil {
+			t.Fatalf("Failed to resume provisioning: %v", err)
+		}
+		if n := len(restarted.Recover()); n != 0 {
+			t.Errorf("Expected a resumed provision not to be recovered, got %d", n)
+		}
+	})
+}
+
+func TestManager_SetIDGenerator(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+
+	n := 0
+	mgr.SetIDGenerator(func() string {
+		n++
+		return fmt.Sprintf("state-%d", n)
+	})
+
+	if st := mgr.Create("example.com"); st.ID != "state-1" {
+		t.Errorf("Expected ID state-1, got %s", st.ID)
+	}
+	if st := mgr.CreateSubdomain("blog.example.com", "example.com"); st.ID != "state-2" {
+		t.Errorf("Expected ID state-2, got %s", st.ID)
+	}
+	if _, err := mgr.Get("state-2"); err != nil {
+		t.Errorf("Expected state-2 to be stored: %v", err)
+	}
+}
+
+func TestManager_WithServer(t *testing.T) {
+	filePath := getTempDir(t)
+	unnamed, _ := NewManager(filePath, getTestLogger())
+	old := unnamed.Create("old.com")
+	if old.Server != "" {
+		t.Errorf("Expected no server without WithServer, got %q", old.Server)
+	}
+
+	mgr, err := NewManager(filePath, getTestLogger(), WithServer("cpanel-07"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	st := mgr.Create("example.com")
+	if st.Server != "cpanel-07" {
+		t.Errorf("Expected server cpanel-07, got %q", st.Server)
+	}
+
+	// Updates keep the server
+	st.Server = ""
+	st.User = "alice"
+	if err := mgr.Update(st); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+	got, _ := mgr.GetByDomain("example.com")
+	if got.Server != "cpanel-07" {
+		t.Errorf("Expected the update to keep server cpanel-07, got %q", got.Server)
+	}
+
+	// States loaded without a server are attributed to this one
+	got, _ = mgr.GetByDomain("old.com")
+	if got.Server != "cpanel-07" {
+		t.Errorf("Expected a loaded state without server to get cpanel-07, got %q", got.Server)
+	}
 }
 
 func TestManager_IncrementStep(t *testing.T) {
@@ -491,6 +675,7 @@ func TestManager_IncrementStep(t *testing.T) {
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		state := mgr.Create("step.com")
+		_ = mgr.MarkProvisioning(state.ID)
 		err := mgr.IncrementStep(state.ID)
 
 		if err != nil {
@@ -521,6 +706,7 @@ func TestManager_SetError(t *testing.T) {
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		state := mgr.Create("error.com")
+		_ = mgr.MarkProvisioning(state.ID)
 		err := mgr.SetError(state.ID, "API error")
 
 		if err != nil {
@@ -542,12 +728,40 @@ func TestManager_SetError(t *testing.T) {
 	})
 }
 
+func TestManager_SetFailure(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+
+	state := mgr.Create("classified.com")
+	_ = mgr.MarkProvisioning(state.ID)
+	if err := mgr.SetFailure(state.ID, "API error (status 429)", ErrorClassRateLimit); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	retrieved, _ := mgr.Get(state.ID)
+	if retrieved.Status != StatusFailed || retrieved.ErrorClass != ErrorClassRateLimit {
+		t.Errorf("Expected failed with class rate_limit, got %s with %q", retrieved.Status, retrieved.ErrorClass)
+	}
+
+	// The class is cleared once the domain is provisioned
+	_ = mgr.MarkProvisioning(state.ID)
+	_ = mgr.AdvanceStep(state.ID, StepDone)
+	if err := mgr.MarkSuccess(state.ID); err != nil {
+		t.Fatalf("MarkSuccess failed: %v", err)
+	}
+	retrieved, _ = mgr.Get(state.ID)
+	if retrieved.Error != "" || retrieved.ErrorClass != "" {
+		t.Errorf("Expected error and class cleared, got %q and %q", retrieved.Error, retrieved.ErrorClass)
+	}
+}
+
 func TestManager_MarkSuccess(t *testing.T) {
 	t.Run("marks state as success", func(t *testing.T) {
 		filePath := getTempDir(t)
 		mgr, _ := NewManager(filePath, getTestLogger())
 
 		state := mgr.Create("mark-success.com")
+		_ = mgr.MarkProvisioning(state.ID)
+		_ = mgr.AdvanceStep(state.ID, StepDone)
 		err := mgr.MarkSuccess(state.ID)
 
 		if err != nil {
@@ -584,6 +798,34 @@ func TestManager_MarkProvisioning(t *testing.T) {
 	})
 }
 
+func TestManager_MarkPending(t *testing.T) {
+	t.Run("requeues state without counting a retry", func(t *testing.T) {
+		filePath := getTempDir(t)
+		mgr, _ := NewManager(filePath, getTestLogger())
+
+		state := mgr.Create("mark-pending.com")
+		_ = mgr.MarkProvisioning(state.ID)
+		err := mgr.MarkPending(state.ID, "paused")
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		retrieved, _ := mgr.Get(state.ID)
+		if retrieved.Status != StatusPending {
+			t.Errorf("Expected status '%s', got '%s'", StatusPending, retrieved.Status)
+		}
+
+		if retrieved.Error != "paused" {
+			t.Errorf("Expected error 'paused', got '%s'", retrieved.Error)
+		}
+
+		if retrieved.Retries != 0 {
+			t.Errorf("Expected 0 retries, got %d", retrieved.Retries)
+		}
+	})
+}
+
 func TestManager_Clear(t *testing.T) {
 	t.Run("clears all states", func(t *testing.T) {
 		filePath := getTempDir(t)
@@ -635,7 +877,7 @@ func TestManager_ConcurrentAccess(t *testing.T) {
 
 func TestStepName(t *testing.T) {
 	tests := []struct {
-		step   int
+		step   Step
 		noname string
 	}{
 		{StepNone, "none"},
@@ -643,6 +885,7 @@ func TestStepName(t *testing.T) {
 		{StepDNSRecords, "dns_records"},
 		{StepPullZone, "pull_zone"},
 		{StepCNAMESync, "cname_sync"},
+		{StepDone, "done"},
 		{999, "unknown"},
 	}
 
@@ -719,3 +962,196 @@ func TestProvisionState_JSON(t *testing.T) {
 		}
 	})
 }
+
+func TestSnapshotStore_Period(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots.json")
+	store, err := NewSnapshotStore(path, getTestLogger())
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+
+	from := time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24*time.Hour - time.Second)
+	now := time.Now()
+
+	if err := store.AddSnapshots([]BandwidthSnapshot{
+		{Timestamp: now, From: from, To: to, ZoneID: 1, Bandwidth: 100},
+		{Timestamp: now, From: from, To: to, ZoneID: 2, Bandwidth: 200},
+		{Timestamp: now, ZoneID: 1, Bandwidth: 50},
+	}); err != nil {
+		t.Fatalf("AddSnapshots: %v", err)
+	}
+	if err := store.AddSnapshot(BandwidthSnapshot{Timestamp: now, From: from, To: to, ZoneID: 1, Bandwidth: 150}); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+
+	reloaded, err := NewSnapshotStore(path, getTestLogger())
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	if got := len(reloaded.GetAllSnapshots(time.Time{})); got != 3 {
+		t.Errorf("snapshots = %d, want 3 after replacing zone 1's period", got)
+	}
+
+	snap := reloaded.GetSnapshotForPeriod(1, from, to)
+	if snap == nil || snap.Bandwidth != 150 {
+		t.Errorf("GetSnapshotForPeriod(1) = %+v, want bandwidth 150", snap)
+	}
+	if snap := reloaded.GetSnapshotForPeriod(1, from, to.Add(time.Second)); snap != nil {
+		t.Errorf("GetSnapshotForPeriod with another period = %+v, want nil", snap)
+	}
+}
+
+func TestSnapshotStore_Cleanup(t *testing.T) {
+	store, err := NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots.json"), getTestLogger())
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store.SetClock(clk)
+
+	if err := store.AddSnapshot(BandwidthSnapshot{Timestamp: start, ZoneID: 1}); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+
+	// Snapshots are kept for 30 days
+	clk.Advance(29 * 24 * time.Hour)
+	if err := store.AddSnapshot(BandwidthSnapshot{Timestamp: clk.Now(), ZoneID: 2}); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+	if got := len(store.GetAllSnapshots(time.Time{})); got != 2 {
+		t.Errorf("snapshots after 29 days = %d, want 2", got)
+	}
+
+	clk.Advance(2 * 24 * time.Hour)
+	if err := store.AddSnapshot(BandwidthSnapshot{Timestamp: clk.Now(), ZoneID: 3}); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+	if got := len(store.GetAllSnapshots(time.Time{})); got != 2 {
+		t.Errorf("snapshots after 31 days = %d, want 2", got)
+	}
+
+	if err := store.Cleanup(time.Hour); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if got := store.GetAllSnapshots(time.Time{}); len(got) != 1 || got[0].ZoneID != 3 {
+		t.Errorf("snapshots after Cleanup = %+v, want zone 3 only", got)
+	}
+}
+
+func TestSnapshotStore_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots.json")
+	store, err := NewSnapshotStore(path, getTestLogger())
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	store.SetClock(clock.NewFake(now))
+	store.SetRetention(SnapshotRetention{Raw: 7 * 24 * time.Hour, Daily: 20 * 24 * time.Hour})
+
+	hour := func(zoneID int64, day, h int, bandwidth int64) BandwidthSnapshot {
+		from := time.Date(2026, 3, day, h, 0, 0, 0, time.UTC)
+		return BandwidthSnapshot{Timestamp: from.Add(time.Hour), From: from, To: from.Add(time.Hour - time.Second), ZoneID: zoneID, Bandwidth: bandwidth, Requests: 1}
+	}
+	dayFrom := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	dayTo := dayFrom.Add(24*time.Hour - time.Second)
+
+	if err := store.AddSnapshots([]BandwidthSnapshot{
+		// Rolled up into one snapshot for zone 1 on the 15th
+		hour(1, 15, 1, 100),
+		hour(1, 15, 2, 200),
+		// Another zone gets its own rollup
+		hour(2, 15, 1, 50),
+		// Dropped, Bunny's daily snapshot already covers the 20th
+		hour(1, 20, 1, 999),
+		{Timestamp: now, From: dayFrom, To: dayTo, ZoneID: 1, Bandwidth: 500},
+		// Within the raw retention
+		hour(1, 30, 1, 10),
+		// Fetched recently, but its period is past the daily retention
+		{Timestamp: now, From: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 3, 5, 0, 59, 59, 0, time.UTC), ZoneID: 1, Bandwidth: 10},
+		// Without a period, past the raw retention
+		{Timestamp: now.Add(-8 * 24 * time.Hour), ZoneID: 1, Bandwidth: 10},
+	}); err != nil {
+		t.Fatalf("AddSnapshots: %v", err)
+	}
+
+	result, err := store.Compact()
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if want := (CompactResult{RolledUp: 3, Removed: 3}); result != want {
+		t.Errorf("Compact() = %+v, want %+v", result, want)
+	}
+
+	reloaded, err := NewSnapshotStore(path, getTestLogger())
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	if got := len(reloaded.GetAllSnapshots(time.Time{})); got != 4 {
+		t.Errorf("snapshots = %d, want 4", got)
+	}
+
+	from := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24*time.Hour - time.Second)
+	rollup := reloaded.GetSnapshotForPeriod(1, from, to)
+	if rollup == nil || !rollup.Rollup || rollup.Bandwidth != 300 || rollup.Requests != 2 {
+		t.Errorf("zone 1 rollup = %+v, want bandwidth 300 from 2 requests", rollup)
+	}
+	if snap := reloaded.GetSnapshotForPeriod(2, from, to); snap == nil || snap.Bandwidth != 50 {
+		t.Errorf("zone 2 rollup = %+v, want bandwidth 50", snap)
+	}
+	if snap := reloaded.GetSnapshotForPeriod(1, dayFrom, dayTo); snap == nil || snap.Rollup || snap.Bandwidth != 500 {
+		t.Errorf("daily snapshot = %+v, want Bunny's bandwidth 500", snap)
+	}
+
+	// Raw snapshots of a day with a rollup are added to it
+	if err := store.AddSnapshot(hour(1, 15, 3, 25)); err != nil {
+		t.Fatalf("AddSnapshot: %v", err)
+	}
+	if _, err := store.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if snap := store.GetSnapshotForPeriod(1, from, to); snap == nil || snap.Bandwidth != 325 {
+		t.Errorf("zone 1 rollup = %+v, want bandwidth 325", snap)
+	}
+}
+
+func TestManager_SetClock(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	mgr.SetClock(clk)
+
+	st := mgr.Create("example.com")
+	if !st.CreatedAt.Equal(now) {
+		t.Errorf("Expected CreatedAt %v, got %v", now, st.CreatedAt)
+	}
+
+	clk.Advance(time.Minute)
+	if err := mgr.MarkProvisioning(st.ID); err != nil {
+		t.Fatalf("MarkProvisioning: %v", err)
+	}
+	if err := mgr.AdvanceStep(st.ID, StepDNSZone); err != nil {
+		t.Fatalf("AdvanceStep: %v", err)
+	}
+	clk.Advance(time.Minute)
+	if err := mgr.AdvanceStep(st.ID, StepDNSRecords); err != nil {
+		t.Fatalf("AdvanceStep: %v", err)
+	}
+
+	got, _ := mgr.Get(st.ID)
+	if want := now.Add(2 * time.Minute); !got.UpdatedAt.Equal(want) {
+		t.Errorf("Expected UpdatedAt %v, got %v", want, got.UpdatedAt)
+	}
+	if d := got.StepDurations[StepName(StepDNSZone)]; d != time.Minute {
+		t.Errorf("Expected the DNS zone step to take 1m, got %v", d)
+	}
+
+	// Archiving measures age on the clock too
+	completeState(t, mgr, st.ID)
+	clk.Advance(48 * time.Hour)
+	if n, err := mgr.Archive(24 * time.Hour); err != nil || n != 1 {
+		t.Errorf("Expected 1 archived state, got %d (%v)", n, err)
+	}
+}
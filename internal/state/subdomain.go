@@ -0,0 +1,149 @@
+package state
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Subdomain step constants. Subdomains do not get a DNS zone of their own,
+// so they follow a separate state machine from domains; CurrentStep holds
+// the step being worked on and SubdomainStepVerified once provisioned
+const (
+	SubdomainStepFindParent Step = 1 // locate the parent domain's DNS zone
+	SubdomainStepPullZone   Step = 2 // create the subdomain's pull zone
+	SubdomainStepCNAME      Step = 3 // point the subdomain at the CDN in the parent zone
+	SubdomainStepVerified   Step = 4 // CNAME in place, provisioning complete
+)
+
+// SubdomainStepName returns the human-readable name for a subdomain step
+func SubdomainStepName(step Step) string {
+	switch step {
+	case StepNone:
+		return "none"
+	case SubdomainStepFindParent:
+		return "find_parent"
+	case SubdomainStepPullZone:
+		return "pull_zone"
+	case SubdomainStepCNAME:
+		return "cname"
+	case SubdomainStepVerified:
+		return "verified"
+	default:
+		return "unknown"
+	}
+}
+
+// IsSubdomain reports whether the state tracks a subdomain
+func (s *ProvisionState) IsSubdomain() bool {
+	return s.ParentDomain != ""
+}
+
+// StepName returns the human-readable name of the state's current step
+func (s *ProvisionState) StepName() string {
+	if s.IsSubdomain() {
+		return SubdomainStepName(s.CurrentStep)
+	}
+	return StepName(s.CurrentStep)
+}
+
+// legacySubdomainStep maps the domain step a subdomain state was stored with
+// before subdomains had their own steps. The old flow stored StepPullZone
+// once the pull zone existed and StepCNAMESync once the CNAME was added
+func legacySubdomainStep(step Step) Step {
+	switch step {
+	case StepNone:
+		return StepNone
+	case StepDNSZone:
+		return SubdomainStepFindParent
+	case StepDNSRecords, StepPullZone:
+		return SubdomainStepCNAME
+	default:
+		return SubdomainStepVerified
+	}
+}
+
+// CreateSubdomain creates a new provisioning state for a subdomain of parent
+func (m *Manager) CreateSubdomain(domain, parent string) *ProvisionState {
+	state := m.Create(domain)
+	state.ParentDomain = parent
+
+	if err := m.Update(state); err != nil {
+		m.logger.Error("Failed to save subdomain state after create",
+			zap.String("domain", domain),
+			zap.Error(err))
+	}
+
+	return state
+}
+
+// AdoptSubdomain marks a state stored before subdomains had their own steps
+// as a subdomain of parent, translating its step. States that are already
+// subdomain states are left alone
+func (m *Manager) AdoptSubdomain(id, parent string) (err error) {
+	defer m.commit(&err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.states[id]
+	if !exists {
+		return ErrStateNotFound
+	}
+	if state.IsSubdomain() {
+		return nil
+	}
+
+	m.adoptSubdomain(state, parent)
+	return m.persist(state)
+}
+
+// adoptSubdomain converts a legacy subdomain state in place
+// Caller must hold m.mu
+func (m *Manager) adoptSubdomain(state *ProvisionState, parent string) {
+	step := legacySubdomainStep(state.CurrentStep)
+	m.logger.Info("Migrated subdomain state",
+		zap.String("domain", state.Domain),
+		zap.String("parent", parent),
+		zap.String("step", SubdomainStepName(step)))
+
+	state.ParentDomain = parent
+	state.CurrentStep = step
+}
+
+// migrateSubdomains finds subdomain states stored before subdomains had
+// their own steps. Such a state shares its ZoneID with the state of the
+// parent domain, because the subdomain's CNAME lives in the parent's zone
+// Caller must hold m.mu
+func (m *Manager) migrateSubdomains() {
+	parents := make(map[int64]string)
+	for _, state := range m.states {
+		if state.ZoneID > 0 && !state.IsSubdomain() {
+			parents[state.ZoneID] = shortestDomain(parents[state.ZoneID], state.Domain)
+		}
+	}
+
+	for _, state := range m.states {
+		if state.IsSubdomain() || state.ZoneID == 0 {
+			continue
+		}
+		parent := parents[state.ZoneID]
+		if parent == "" || !strings.HasSuffix(state.Domain, "."+parent) {
+			continue
+		}
+
+		m.adoptSubdomain(state, parent)
+		if err := m.persist(state); err != nil {
+			m.logger.Error("Failed to save migrated subdomain state",
+				zap.String("domain", state.Domain),
+				zap.Error(err))
+		}
+	}
+}
+
+// shortestDomain returns the shorter of two domain names, ignoring empty ones
+func shortestDomain(a, b string) string {
+	if a == "" || (b != "" && len(b) < len(a)) {
+		return b
+	}
+	return a
+}
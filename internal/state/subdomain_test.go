@@ -0,0 +1,153 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestSubdomainStepName(t *testing.T) {
+	tests := []struct {
+		step Step
+		name string
+	}{
+		{StepNone, "none"},
+		{SubdomainStepFindParent, "find_parent"},
+		{SubdomainStepPullZone, "pull_zone"},
+		{SubdomainStepCNAME, "cname"},
+		{SubdomainStepVerified, "verified"},
+		{999, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name := SubdomainStepName(tt.step); name != tt.name {
+				t.Errorf("Expected name '%s', got '%s'", tt.name, name)
+			}
+		})
+	}
+}
+
+func TestProvisionState_StepName(t *testing.T) {
+	domain := &ProvisionState{Domain: "example.com", CurrentStep: StepCNAMESync}
+	if name := domain.StepName(); name != "cname_sync" {
+		t.Errorf("Expected domain step 'cname_sync', got '%s'", name)
+	}
+
+	sub := &ProvisionState{Domain: "blog.example.com", ParentDomain: "example.com", CurrentStep: SubdomainStepCNAME}
+	if name := sub.StepName(); name != "cname" {
+		t.Errorf("Expected subdomain step 'cname', got '%s'", name)
+	}
+}
+
+func TestManager_CreateSubdomain(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	st := mgr.CreateSubdomain("blog.example.com", "example.com")
+	if !st.IsSubdomain() {
+		t.Fatal("Expected a subdomain state")
+	}
+
+	completeState(t, mgr, st.ID)
+
+	// Reload from disk
+	mgr2, _ := NewManager(filePath, getTestLogger())
+	retrieved, err := mgr2.GetByDomain("blog.example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if retrieved.ParentDomain != "example.com" {
+		t.Errorf("Expected parent 'example.com', got '%s'", retrieved.ParentDomain)
+	}
+	if retrieved.CurrentStep != SubdomainStepVerified {
+		t.Errorf("Expected step %d, got %d", SubdomainStepVerified, retrieved.CurrentStep)
+	}
+}
+
+func TestManager_AdoptSubdomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		legacy   Step
+		expected Step
+	}{
+		{"not started", StepNone, StepNone},
+		{"finding parent", StepDNSZone, SubdomainStepFindParent},
+		{"pull zone created", StepPullZone, SubdomainStepCNAME},
+		{"completed", StepCNAMESync, SubdomainStepVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, _ := NewManager(getTempDir(t), getTestLogger())
+			st := mgr.Create("blog.example.com")
+			setStep(t, mgr, st.ID, tt.legacy)
+
+			if err := mgr.AdoptSubdomain(st.ID, "example.com"); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			retrieved, _ := mgr.Get(st.ID)
+			if retrieved.ParentDomain != "example.com" {
+				t.Errorf("Expected parent 'example.com', got '%s'", retrieved.ParentDomain)
+			}
+			if retrieved.CurrentStep != tt.expected {
+				t.Errorf("Expected step %d, got %d", tt.expected, retrieved.CurrentStep)
+			}
+
+			// Adopting again leaves the step alone
+			if err := mgr.AdoptSubdomain(st.ID, "example.com"); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			retrieved, _ = mgr.Get(st.ID)
+			if retrieved.CurrentStep != tt.expected {
+				t.Errorf("Expected step %d after second adopt, got %d", tt.expected, retrieved.CurrentStep)
+			}
+		})
+	}
+
+	t.Run("unknown state", func(t *testing.T) {
+		mgr, _ := NewManager(getTempDir(t), getTestLogger())
+		if err := mgr.AdoptSubdomain("missing", "example.com"); err != ErrStateNotFound {
+			t.Errorf("Expected ErrStateNotFound, got %v", err)
+		}
+	})
+}
+
+func TestManager_MigratesLegacySubdomainStates(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	parent := mgr.Create("example.com")
+	parent.ZoneID = 100
+	mgr.Update(parent)
+	setStep(t, mgr, parent.ID, StepCNAMESync)
+
+	sub := mgr.Create("blog.example.com")
+	sub.ZoneID = 100
+	mgr.Update(sub)
+	setStep(t, mgr, sub.ID, StepPullZone)
+
+	other := mgr.Create("blog.other.com")
+	other.ZoneID = 200
+	mgr.Update(other)
+	setStep(t, mgr, other.ID, StepPullZone)
+
+	mgr2, _ := NewManager(filePath, getTestLogger())
+
+	retrieved, _ := mgr2.Get(sub.ID)
+	if retrieved.ParentDomain != "example.com" {
+		t.Errorf("Expected parent 'example.com', got '%s'", retrieved.ParentDomain)
+	}
+	if retrieved.CurrentStep != SubdomainStepCNAME {
+		t.Errorf("Expected step %d, got %d", SubdomainStepCNAME, retrieved.CurrentStep)
+	}
+
+	retrieved, _ = mgr2.Get(parent.ID)
+	if retrieved.IsSubdomain() {
+		t.Error("Expected parent domain to stay a domain state")
+	}
+
+	retrieved, _ = mgr2.Get(other.ID)
+	if retrieved.IsSubdomain() || retrieved.CurrentStep != StepPullZone {
+		t.Error("Expected state without a parent state to be left alone")
+	}
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"sort"
+)
+
+// indexUser adds a state to the user index
+// Caller must hold m.mu
+func (m *Manager) indexUser(state *ProvisionState) {
+	if state.User == "" {
+		return
+	}
+	ids, ok := m.userIndex[state.User]
+	if !ok {
+		ids = make(map[string]struct{})
+		m.userIndex[state.User] = ids
+	}
+	ids[state.ID] = struct{}{}
+}
+
+// unindexUser removes a state from the user index
+// Caller must hold m.mu
+func (m *Manager) unindexUser(state *ProvisionState) {
+	ids, ok := m.userIndex[state.User]
+	if !ok {
+		return
+	}
+	delete(ids, state.ID)
+	if len(ids) == 0 {
+		delete(m.userIndex, state.User)
+	}
+}
+
+// ListByUser returns every state owned by a WHM user, archived ones
+// included, sorted by domain
+func (m *Manager) ListByUser(user string) ([]*ProvisionState, error) {
+	if user == "" {
+		return nil, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []*ProvisionState
+	for id := range m.userIndex[user] {
+		stateCopy := *m.states[id]
+		result = append(result, &stateCopy)
+	}
+
+	archived, err := m.readArchive()
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range archived {
+		if _, active := m.states[state.ID]; state.User == user && !active {
+			result = append(result, state)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Domain < result[j].Domain
+	})
+	return result, nil
+}
+
+// GetByUser returns the state of a WHM user's primary domain: the earliest
+// created of the user's domains, not counting subdomains
+func (m *Manager) GetByUser(user string) (*ProvisionState, error) {
+	states, err := m.ListByUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var primary *ProvisionState
+	for _, state := range states {
+		if state.IsSubdomain() {
+			continue
+		}
+		if primary == nil || state.CreatedAt.Before(primary.CreatedAt) {
+			primary = state
+		}
+	}
+	if primary == nil {
+		return nil, ErrStateNotFound
+	}
+	return primary, nil
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+// setUser records the owner of a state
+func setUser(t *testing.T, mgr *Manager, id, user string) {
+	t.Helper()
+	st, err := mgr.Get(id)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	st.User = user
+	if err := mgr.Update(st); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func domainsOf(states []*ProvisionState) []string {
+	domains := make([]string, 0, len(states))
+	for _, st := range states {
+		domains = append(domains, st.Domain)
+	}
+	return domains
+}
+
+func TestManager_ListByUser(t *testing.T) {
+	filePath := getTempDir(t)
+	mgr, _ := NewManager(filePath, getTestLogger())
+
+	primary := mgr.Create("example.com")
+	setUser(t, mgr, primary.ID, "exampleu")
+	addon := mgr.Create("addon.com")
+	setUser(t, mgr, addon.ID, "exampleu")
+	sub := mgr.CreateSubdomain("blog.example.com", "example.com")
+	setUser(t, mgr, sub.ID, "exampleu")
+	other := mgr.Create("other.com")
+	setUser(t, mgr, other.ID, "otheru")
+	mgr.Create("unowned.com")
+
+	states, err := mgr.ListByUser("exampleu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []string{"addon.com", "blog.example.com", "example.com"}
+	if got := domainsOf(states); len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] || got[2] != expected[2] {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	t.Run("index survives a restart", func(t *testing.T) {
+		reloaded, err := NewManager(filePath, getTestLogger())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		states, _ := reloaded.ListByUser("exampleu")
+		if len(states) != 3 {
+			t.Errorf("Expected 3 states, got %v", domainsOf(states))
+		}
+	})
+
+	t.Run("includes archived states", func(t *testing.T) {
+		completeState(t, mgr, addon.ID)
+		ageState(t, mgr, addon.ID, 48*time.Hour)
+		if n, err := mgr.Archive(24 * time.Hour); err != nil || n != 1 {
+			t.Fatalf("Expected 1 archived state, got %d (%v)", n, err)
+		}
+
+		states, _ := mgr.ListByUser("exampleu")
+		if len(states) != 3 || states[0].Domain != "addon.com" {
+			t.Errorf("Expected the archived domain to be listed, got %v", domainsOf(states))
+		}
+	})
+
+	t.Run("follows owner changes and deletes", func(t *testing.T) {
+		setUser(t, mgr, other.ID, "exampleu")
+		states, _ := mgr.ListByUser("otheru")
+		if len(states) != 0 {
+			t.Errorf("Expected no states for previous owner, got %v", domainsOf(states))
+		}
+
+		if err := mgr.Delete(other.ID); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		states, _ = mgr.ListByUser("exampleu")
+		if len(states) != 3 {
+			t.Errorf("Expected 3 states after delete, got %v", domainsOf(states))
+		}
+	})
+
+	t.Run("empty user", func(t *testing.T) {
+		states, err := mgr.ListByUser("")
+		if err != nil || len(states) != 0 {
+			t.Errorf("Expected no states, got %v (%v)", domainsOf(states), err)
+		}
+	})
+}
+
+func TestManager_GetByUser(t *testing.T) {
+	mgr, _ := NewManager(getTempDir(t), getTestLogger())
+
+	sub := mgr.CreateSubdomain("blog.example.com", "example.com")
+	setUser(t, mgr, sub.ID, "exampleu")
+	primary := mgr.Create("example.com")
+	setUser(t, mgr, primary.ID, "exampleu")
+	time.Sleep(time.Millisecond)
+	addon := mgr.Create("addon.com")
+	setUser(t, mgr, addon.ID, "exampleu")
+
+	st, err := mgr.GetByUser("exampleu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if st.Domain != "example.com" {
+		t.Errorf("Expected primary domain 'example.com', got '%s'", st.Domain)
+	}
+
+	if _, err := mgr.GetByUser("nobody"); err != ErrStateNotFound {
+		t.Errorf("Expected ErrStateNotFound, got %v", err)
+	}
+}
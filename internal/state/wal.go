@@ -0,0 +1,380 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// walExt is the extension of write-ahead log segments, kept in the records
+// directory next to the records they are flushed to
+const walExt = ".wal"
+
+// walLockFile is the file in the records directory that the process owning
+// the write-ahead log holds a lock on
+const walLockFile = "wal.lock"
+
+// walHeader is the size of the frame header: the payload length and its
+// CRC-32, both big-endian
+const walHeader = 8
+
+// errTornFrame is a frame cut short or garbled by a crash while it was
+// being written
+var errTornFrame = errors.New("torn write-ahead log frame")
+
+// walEntry is one change in the write-ahead log: the new record of a state,
+// or its deletion
+type walEntry struct {
+	ID      string          `json:"id"`
+	State   json.RawMessage `json:"state,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+}
+
+// walFrame is an encoded entry waiting to be written to segment gen
+type walFrame struct {
+	gen  uint64
+	data []byte
+}
+
+// wal is the write-ahead log of a batching Manager. Changes are appended to
+// the current segment and fsynced before the call that made them returns;
+// a flush moves on to a new segment and removes the older ones once their
+// changes are in the records
+type wal struct {
+	dir     string
+	keyring *encryption.Keyring
+
+	// mu serializes the writes, so the changes queued by concurrent calls
+	// are written with one fsync
+	mu    sync.Mutex
+	files map[uint64]*os.File
+	// err is the last failed write; a segment with a partial frame is not
+	// appended to until it is removed
+	err    error
+	errGen uint64
+
+	// queueMu guards queue and gen, which are changed under the Manager's
+	// lock while a write may be in progress
+	queueMu sync.Mutex
+	queue   []walFrame
+	gen     uint64
+}
+
+// newWAL creates the write-ahead log of the records directory dir, starting
+// with segment 1; the segments left by a previous process must have been
+// replayed and removed
+func newWAL(dir string, keyring *encryption.Keyring) *wal {
+	return &wal{
+		dir:     dir,
+		keyring: keyring,
+		files:   make(map[uint64]*os.File),
+		gen:     1,
+	}
+}
+
+// segmentPath returns the file of segment gen; the number is padded so
+// segments sort in order
+func (w *wal) segmentPath(gen uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016d%s", gen, walExt))
+}
+
+// append queues entry for the current segment; sync writes it
+func (w *wal) append(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal write-ahead log entry: %w", err)
+	}
+	sealed, err := w.keyring.Encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, walHeader+len(sealed))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(sealed)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(sealed))
+	copy(frame[walHeader:], sealed)
+
+	w.queueMu.Lock()
+	w.queue = append(w.queue, walFrame{gen: w.gen, data: frame})
+	w.queueMu.Unlock()
+	return nil
+}
+
+// rotate starts a new segment for the entries appended from now on and
+// returns the previous one
+func (w *wal) rotate() uint64 {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	gen := w.gen
+	w.gen++
+	return gen
+}
+
+// sync writes the queued entries and fsyncs their segments. An entry
+// queued by another call may already have been written by a concurrent
+// sync, which this one waits for
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.queueMu.Lock()
+	frames := w.queue
+	w.queue = nil
+	w.queueMu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	touched := make(map[uint64]*os.File)
+	for _, frame := range frames {
+		f, err := w.segment(frame.gen)
+		if err == nil {
+			_, err = f.Write(frame.data)
+		}
+		if err != nil {
+			w.err, w.errGen = fmt.Errorf("failed to write write-ahead log: %w", err), frame.gen
+			return w.err
+		}
+		touched[frame.gen] = f
+	}
+	for gen, f := range touched {
+		if err := f.Sync(); err != nil {
+			w.err, w.errGen = fmt.Errorf("failed to sync write-ahead log: %w", err), gen
+			return w.err
+		}
+	}
+	return nil
+}
+
+// segment returns the open file of segment gen, creating it
+// Caller must hold w.mu
+func (w *wal) segment(gen uint64) (*os.File, error) {
+	if f, ok := w.files[gen]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(w.segmentPath(gen), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// The new file must survive a crash along with what is written to it
+	if err := filestore.SyncDir(w.dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.files[gen] = f
+	return f, nil
+}
+
+// retire removes the segments up to gen, whose changes are in the records
+func (w *wal) retire(gen uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for g, f := range w.files {
+		if g > gen {
+			continue
+		}
+		f.Close()
+		delete(w.files, g)
+		if err := os.Remove(w.segmentPath(g)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove write-ahead log segment: %w", err)
+		}
+	}
+	if w.err != nil && w.errGen <= gen {
+		w.err = nil
+	}
+	return filestore.SyncDir(w.dir)
+}
+
+// close closes the open segments, leaving them on disk
+func (w *wal) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for g, f := range w.files {
+		f.Close()
+		delete(w.files, g)
+	}
+}
+
+// lockWAL takes the lock on the write-ahead log of the records directory
+// dir without waiting; ok is false while another process holds it. The lock
+// is released when the file is closed or the process exits
+func lockWAL(dir string) (lock *os.File, ok bool, err error) {
+	f, err := os.OpenFile(filepath.Join(dir, walLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open write-ahead log lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to lock write-ahead log: %w", err)
+	}
+	return f, true, nil
+}
+
+// readWAL reads the entries of the write-ahead log segments in the records
+// directory, oldest first, and returns them with the segment files. A torn
+// frame ends its segment: it was being written when the process stopped, so
+// the call that made the change never returned
+func (m *Manager) readWAL() ([]walEntry, []string, error) {
+	dir := m.GetRecordsDir()
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	var segments []string
+	for _, entry := range dirEntries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), walExt) {
+			segments = append(segments, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(segments)
+
+	var entries []walEntry
+	for _, path := range segments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read write-ahead log: %w", err)
+		}
+		for len(data) > 0 {
+			payload, rest, err := nextFrame(data)
+			if err != nil {
+				m.logger.Warn("Write-ahead log ends in a torn write, ignoring the rest of the segment",
+					zap.String("path", path),
+					zap.Int("bytes", len(data)))
+				break
+			}
+			data = rest
+
+			plain, err := m.keyring.Decrypt(payload)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read write-ahead log %s: %w", filepath.Base(path), err)
+			}
+			var entry walEntry
+			if err := json.Unmarshal(plain, &entry); err != nil || entry.ID == "" {
+				return nil, nil, fmt.Errorf("failed to read write-ahead log %s: invalid entry", filepath.Base(path))
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, segments, nil
+}
+
+// nextFrame splits the first frame off data and returns its payload
+func nextFrame(data []byte) (payload, rest []byte, err error) {
+	if len(data) < walHeader {
+		return nil, nil, errTornFrame
+	}
+	n := int(binary.BigEndian.Uint32(data[0:4]))
+	if len(data)-walHeader < n {
+		return nil, nil, errTornFrame
+	}
+	payload = data[walHeader : walHeader+n]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, nil, errTornFrame
+	}
+	return payload, data[walHeader+n:], nil
+}
+
+// replayWAL applies the changes of the write-ahead log to the states read
+// from the records. The log belongs to the process holding its lock: a
+// batching manager takes it for as long as it runs, and any manager finding
+// it free writes the changes of a stopped process to the records and removes
+// the log. Otherwise, such as for a CLI command next to a running server,
+// the changes are only applied in memory, unless a record was written after
+// them
+// Caller must hold m.mu
+func (m *Manager) replayWAL(states []*ProvisionState) ([]*ProvisionState, error) {
+	lock, owner, err := lockWAL(m.GetRecordsDir())
+	if err != nil {
+		return nil, err
+	}
+	if m.flushInterval > 0 {
+		if !owner {
+			return nil, fmt.Errorf("state is in use by another process writing in batches")
+		}
+		m.walLock = lock
+	} else if owner {
+		defer lock.Close()
+	}
+
+	entries, segments, err := m.readWAL()
+	if err != nil || len(segments) == 0 {
+		return states, err
+	}
+
+	byID := make(map[string]*ProvisionState, len(states))
+	for _, state := range states {
+		byID[state.ID] = state
+	}
+	latest := make(map[string]walEntry)
+	for _, entry := range entries {
+		if entry.Deleted {
+			delete(byID, entry.ID)
+			latest[entry.ID] = entry
+			continue
+		}
+		var state ProvisionState
+		if err := json.Unmarshal(entry.State, &state); err != nil {
+			return nil, fmt.Errorf("failed to replay write-ahead log: %w", err)
+		}
+		if stored, ok := byID[entry.ID]; ok && stored.UpdatedAt.After(state.UpdatedAt) {
+			continue
+		}
+		byID[entry.ID] = &state
+		latest[entry.ID] = entry
+	}
+
+	replayed := make([]*ProvisionState, 0, len(byID))
+	for _, state := range byID {
+		replayed = append(replayed, state)
+	}
+	m.logger.Info("Replayed state write-ahead log",
+		zap.Int("changes", len(entries)),
+		zap.Int("segments", len(segments)),
+		zap.Bool("owner", owner))
+
+	if !owner {
+		return replayed, nil
+	}
+
+	for id, entry := range latest {
+		if entry.Deleted {
+			err = m.removeRecord(id)
+		} else {
+			err = m.writeRecord(id, entry.State)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write replayed state: %w", err)
+		}
+	}
+	for _, path := range segments {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove write-ahead log segment: %w", err)
+		}
+	}
+	return replayed, filestore.SyncDir(m.GetRecordsDir())
+}
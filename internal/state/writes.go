@@ -0,0 +1,37 @@
+package state
+
+import "sync/atomic"
+
+// WriteStats counts the state records written since the manager was
+// created, to measure how many writes a provision costs
+type WriteStats struct {
+	Writes int64 `json:"writes"`
+	Bytes  int64 `json:"bytes"`
+	// Flushes counts the batches written by a batching manager
+	Flushes int64 `json:"flushes"`
+}
+
+// writeStats holds the counters behind WriteStats
+type writeStats struct {
+	writes  atomic.Int64
+	bytes   atomic.Int64
+	flushes atomic.Int64
+}
+
+func (w *writeStats) record(n int) {
+	w.writes.Add(1)
+	w.bytes.Add(int64(n))
+}
+
+func (w *writeStats) flush() {
+	w.flushes.Add(1)
+}
+
+// WriteStats returns the state record write counters
+func (m *Manager) WriteStats() WriteStats {
+	return WriteStats{
+		Writes:  m.writes.writes.Load(),
+		Bytes:   m.writes.bytes.Load(),
+		Flushes: m.writes.flushes.Load(),
+	}
+}
@@ -0,0 +1,176 @@
+// Package status writes the provisioning status of each domain to a file
+// the owning cPanel user can read, so WHM hook scripts and cPanel plugins
+// can show "CDN setup in progress" or "complete" without polling the API
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// State is the provisioning state shown to the user
+type State string
+
+const (
+	// StateQueued is a provision waiting for maintenance or the Bunny balance
+	StateQueued State = "queued"
+	// StateInProgress is a provision running its steps
+	StateInProgress State = "in_progress"
+	// StateComplete is a domain served through the CDN
+	StateComplete State = "complete"
+	// StateFailed is a provision that failed and will be retried
+	StateFailed State = "failed"
+)
+
+// Status is the content of a domain's status file
+type Status struct {
+	Domain       string    `json:"domain"`
+	ParentDomain string    `json:"parent_domain,omitempty"`
+	User         string    `json:"user"`
+	State        State     `json:"state"`
+	CDNHostname  string    `json:"cdn_hostname,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Writer keeps one status file per domain at <dir>/<user>/<domain>.json.
+// When whm2bunny runs as root, each user directory belongs to the user's
+// group, so only that user can read it
+type Writer struct {
+	dir    string
+	logger *zap.Logger
+}
+
+// NewWriter creates a writer for the status files under dir
+func NewWriter(dir string, logger *zap.Logger) (*Writer, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create status directory: %w", err)
+	}
+	return &Writer{dir: dir, logger: logger}, nil
+}
+
+// Path returns the status file of a user's domain
+func (w *Writer) Path(userName, domain string) (string, error) {
+	if !validName(userName) {
+		return "", fmt.Errorf("invalid user name %q", userName)
+	}
+	domain = domainname.Normalize(domain)
+	if !validName(domain) {
+		return "", fmt.Errorf("invalid domain %q", domain)
+	}
+	return filepath.Join(w.dir, userName, domain+".json"), nil
+}
+
+// Write replaces the status file of st.Domain atomically
+func (w *Writer) Write(st Status) error {
+	path, err := w.Path(st.User, st.Domain)
+	if err != nil {
+		return err
+	}
+	st.Domain = domainname.Normalize(st.Domain)
+	if st.UpdatedAt.IsZero() {
+		st.UpdatedAt = time.Now()
+	}
+
+	gid := w.userGroup(st.User)
+	userDir := filepath.Dir(path)
+	if err := os.MkdirAll(userDir, 0750); err != nil {
+		return fmt.Errorf("failed to create status directory: %w", err)
+	}
+	if err := w.shareWith(userDir, gid); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	// The file gets its group before it is renamed, so the user never
+	// sees it unreadable
+	err = filestore.WriteFileFunc(path, data, 0640, func(tmpPath string) error {
+		return w.shareWith(tmpPath, gid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	return nil
+}
+
+// Read returns the status of a user's domain
+func (w *Writer) Read(userName, domain string) (*Status, error) {
+	path, err := w.Path(userName, domain)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st Status
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal status file: %w", err)
+	}
+	return &st, nil
+}
+
+// Remove deletes the status file of a user's domain, for a domain that
+// was deprovisioned
+func (w *Writer) Remove(userName, domain string) error {
+	path, err := w.Path(userName, domain)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove status file: %w", err)
+	}
+	return nil
+}
+
+// userGroup returns the primary group of a system user, or -1 when
+// whm2bunny is not root or the user does not exist on this host
+func (w *Writer) userGroup(userName string) int {
+	if os.Geteuid() != 0 {
+		return -1
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		w.logger.Debug("status file owner not found", zap.String("user", userName), zap.Error(err))
+		return -1
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return -1
+	}
+	return gid
+}
+
+// shareWith gives group gid read access to path; -1 leaves it as is
+func (w *Writer) shareWith(path string, gid int) error {
+	if gid < 0 {
+		return nil
+	}
+	if err := os.Chown(path, 0, gid); err != nil {
+		return fmt.Errorf("failed to set status file owner: %w", err)
+	}
+	return nil
+}
+
+// validName reports whether s can be used as a single path element
+func validName(s string) bool {
+	return s != "" && !strings.HasPrefix(s, ".") && !strings.ContainsAny(s, `/\`)
+}
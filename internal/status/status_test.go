@@ -0,0 +1,50 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWriter_WriteReadRemove(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(Status{Domain: "Example.com.", User: "alice", State: StateInProgress}))
+	require.NoError(t, w.Write(Status{Domain: "example.com", User: "alice", State: StateComplete, CDNHostname: "morden-example-com.b-cdn.net"}))
+
+	path := filepath.Join(dir, "alice", "example.com.json")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	st, err := w.Read("alice", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, StateComplete, st.State)
+	assert.Equal(t, "morden-example-com.b-cdn.net", st.CDNHostname)
+	assert.False(t, st.UpdatedAt.IsZero())
+
+	require.NoError(t, w.Remove("alice", "example.com"))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, w.Remove("alice", "example.com"))
+}
+
+func TestWriter_RejectsUnsafeNames(t *testing.T) {
+	w, err := NewWriter(t.TempDir(), zap.NewNop())
+	require.NoError(t, err)
+
+	for _, st := range []Status{
+		{Domain: "example.com", User: ""},
+		{Domain: "example.com", User: "../root"},
+		{Domain: "../../etc/passwd", User: "alice"},
+		{Domain: ".hidden", User: "alice"},
+	} {
+		assert.Error(t, w.Write(st), "%+v", st)
+	}
+}
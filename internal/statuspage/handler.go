@@ -0,0 +1,264 @@
+package statuspage
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/status"
+)
+
+// StatsDays is the period the CDN statistics of a page cover
+const StatsDays = 7
+
+// statsTTL is how long the statistics of a domain are reused, so that
+// reloading a page does not call the Bunny API each time
+const statsTTL = 5 * time.Minute
+
+//go:embed templates/status.html.tmpl
+var pageFS embed.FS
+
+var pageTemplate = template.Must(template.New("status.html.tmpl").Funcs(notifier.TemplateFuncs()).
+	ParseFS(pageFS, "templates/status.html.tmpl"))
+
+// Service reads the state and traffic of a domain
+type Service interface {
+	GetStatus(domain string) (*app.Status, error)
+	Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error)
+}
+
+// Page is what a status link shows. It leaves out the user, the Bunny IDs
+// and error messages, which are not the customer's concern
+type Page struct {
+	Domain string       `json:"domain"`
+	State  status.State `json:"state"`
+	// StepsDone of StepsTotal provisioning steps are complete
+	StepsDone   int       `json:"steps_done"`
+	StepsTotal  int       `json:"steps_total"`
+	CDNHostname string    `json:"cdn_hostname,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Stats are only set once the domain is served through the CDN
+	Stats *Stats `json:"stats,omitempty"`
+}
+
+// Stats is the CDN traffic of a domain over the last Days days
+type Stats struct {
+	Days         int     `json:"days"`
+	Bandwidth    int64   `json:"bandwidth"`
+	Requests     int64   `json:"requests"`
+	CacheHitRate float64 `json:"cache_hit_rate"`
+}
+
+// errorBody is the JSON body of a rejected request
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// cachedStats are the statistics of a domain and when they were fetched
+type cachedStats struct {
+	stats   *Stats
+	fetched time.Time
+}
+
+// Handler serves the status pages
+type Handler struct {
+	signer  *Signer
+	service Service
+	clock   clock.Clock
+	stats   map[string]cachedStats
+	mu      sync.Mutex
+	logger  *zap.Logger
+}
+
+// NewHandler creates a handler serving the pages of links signed by signer
+func NewHandler(signer *Signer, service Service, logger *zap.Logger) *Handler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Handler{
+		signer:  signer,
+		service: service,
+		clock:   clock.Real{},
+		stats:   make(map[string]cachedStats),
+		logger:  logger,
+	}
+}
+
+// SetClock replaces the clock link expiries are checked with
+func (h *Handler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// Routes returns the router of the pages, to be mounted under /status
+func (h *Handler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{domain}", h.getPage)
+	return r
+}
+
+// getPage handles GET /{domain}?expires=<unix>&sig=<signature>. The page
+// is HTML, or JSON with ?format=json or an Accept header asking for it
+func (h *Handler) getPage(w http.ResponseWriter, r *http.Request) {
+	// The links are secrets: keep them out of caches, search engines and
+	// the Referer header of links followed from the page
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	asJSON := r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+	domain := domainname.Normalize(chi.URLParam(r, "domain"))
+	now := h.clock.Now()
+
+	expires, err := h.signer.Verify(domain, r.URL.Query().Get("expires"), r.URL.Query().Get("sig"), now)
+	if err != nil {
+		h.logger.Info("rejected status link",
+			zap.String("domain", domain),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.Error(err),
+		)
+		message := "This link is not valid."
+		if errors.Is(err, ErrExpired) {
+			message = "This link has expired. Ask support for a new one."
+		}
+		h.writeError(w, asJSON, http.StatusForbidden, message)
+		return
+	}
+
+	st, err := h.service.GetStatus(domain)
+	if err != nil {
+		if !errors.Is(err, state.ErrStateNotFound) {
+			h.logger.Error("failed to read domain state for status page", zap.String("domain", domain), zap.Error(err))
+			h.writeError(w, asJSON, http.StatusInternalServerError, "The status is not available right now.")
+			return
+		}
+		h.writeError(w, asJSON, http.StatusNotFound, "This domain is not served through the CDN.")
+		return
+	}
+
+	page := newPage(st.ProvisionState, expires)
+	if page.State == status.StateComplete {
+		page.Stats = h.domainStats(r.Context(), domain, now)
+	}
+
+	if asJSON {
+		writeJSON(w, http.StatusOK, page)
+		return
+	}
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, page); err != nil {
+		h.logger.Error("failed to render status page", zap.String("domain", domain), zap.Error(err))
+		http.Error(w, "Failed to render the status page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Write(buf.Bytes())
+}
+
+// newPage returns the page of a provisioning state
+func newPage(st *state.ProvisionState, expires time.Time) Page {
+	page := Page{
+		Domain:      st.Domain,
+		State:       pageState(st.Status),
+		StepsTotal:  int(state.StepDone) - 1,
+		CDNHostname: st.CDNHostname,
+		UpdatedAt:   st.UpdatedAt,
+		ExpiresAt:   expires,
+	}
+	if st.IsSubdomain() {
+		page.StepsTotal = int(state.SubdomainStepVerified) - 1
+	}
+	switch {
+	case st.Complete():
+		page.StepsDone = page.StepsTotal
+	case st.CurrentStep > state.StepNone:
+		page.StepsDone = int(st.CurrentStep) - 1
+	}
+	return page
+}
+
+// pageState returns the state shown for a provisioning status, the same
+// the status files show
+func pageState(s state.Status) status.State {
+	switch s {
+	case state.StatusSuccess:
+		return status.StateComplete
+	case state.StatusFailed:
+		return status.StateFailed
+	case state.StatusProvisioning:
+		return status.StateInProgress
+	default:
+		return status.StateQueued
+	}
+}
+
+// domainStats returns the statistics of domain over the last StatsDays
+// days, or nil when they cannot be fetched
+func (h *Handler) domainStats(ctx context.Context, domain string, now time.Time) *Stats {
+	h.mu.Lock()
+	cached, ok := h.stats[domain]
+	h.mu.Unlock()
+	if ok && now.Sub(cached.fetched) < statsTTL {
+		return cached.stats
+	}
+
+	report, err := h.service.Report(ctx, domain, now.AddDate(0, 0, -StatsDays), now)
+	if err != nil {
+		// The page stands without statistics
+		h.logger.Warn("failed to get statistics for status page", zap.String("domain", domain), zap.Error(err))
+		return nil
+	}
+	stats := &Stats{
+		Days:         StatsDays,
+		Bandwidth:    report.Bandwidth,
+		Requests:     report.Requests,
+		CacheHitRate: report.CacheHitRate,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for d, c := range h.stats {
+		if now.Sub(c.fetched) >= statsTTL {
+			delete(h.stats, d)
+		}
+	}
+	h.stats[domain] = cachedStats{stats: stats, fetched: now}
+	return stats
+}
+
+// writeError writes a rejected request as JSON or as a short HTML page
+func (h *Handler) writeError(w http.ResponseWriter, asJSON bool, statusCode int, message string) {
+	if asJSON {
+		writeJSON(w, statusCode, errorBody{Error: message})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"UTF-8\"><title>CDN status</title></head><body><p>%s</p></body></html>\n", template.HTMLEscapeString(message))
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
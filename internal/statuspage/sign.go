@@ -0,0 +1,86 @@
+// Package statuspage serves a public status page of a single domain at
+// links signed with an HMAC over the domain and the link's expiry, so
+// support can show a customer their domain's provisioning progress and CDN
+// statistics without giving them API credentials
+package statuspage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+)
+
+// Link verification errors
+var (
+	// ErrInvalidSignature is returned for links not signed with the secret
+	ErrInvalidSignature = errors.New("invalid status link signature")
+	// ErrExpired is returned for links past their expiry
+	ErrExpired = errors.New("status link has expired")
+)
+
+// Signer signs and verifies status page links
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a signer keyed with secret
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns the signature of a link to domain's page expiring at
+// expires: base64url(HMAC-SHA256(secret, domain + "\n" + unix expiry))
+func (s *Signer) Sign(domain string, expires time.Time) string {
+	return s.sign(domainname.Normalize(domain), strconv.FormatInt(expires.Unix(), 10))
+}
+
+func (s *Signer) sign(domain, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(domain + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// URL returns the link to domain's page served under baseURL
+func (s *Signer) URL(baseURL, domain string, expires time.Time) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("base URL must be absolute: %s", baseURL)
+	}
+
+	domain = domainname.Normalize(domain)
+	u = u.JoinPath("status", domain)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", s.Sign(domain, expires))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Verify checks the expires and sig values of a link to domain and returns
+// when it expires
+func (s *Signer) Verify(domain, expires, sig string, now time.Time) (time.Time, error) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+	want := s.sign(domainname.Normalize(domain), expires)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	at := time.Unix(unix, 0)
+	if !now.Before(at) {
+		return at, ErrExpired
+	}
+	return at, nil
+}
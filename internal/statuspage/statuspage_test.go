@@ -0,0 +1,134 @@
+package statuspage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/status"
+)
+
+type fakeService struct {
+	states  map[string]*state.ProvisionState
+	reports int
+}
+
+func (f *fakeService) GetStatus(domain string) (*app.Status, error) {
+	st, ok := f.states[domain]
+	if !ok {
+		return nil, state.ErrStateNotFound
+	}
+	return &app.Status{ProvisionState: st}, nil
+}
+
+func (f *fakeService) Report(ctx context.Context, domain string, from, to time.Time) (*app.Report, error) {
+	f.reports++
+	return &app.Report{Domain: domain, Bandwidth: 3 << 30, Requests: 120000, CacheHitRate: 92.5}, nil
+}
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner("secret")
+	now := time.Unix(1760000000, 0)
+	expires := now.Add(time.Hour)
+
+	link, err := signer.URL("https://status.example.net/", "Example.com.", expires)
+	require.NoError(t, err)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	assert.Equal(t, "/status/example.com", u.Path)
+	assert.Equal(t, "1760003600", u.Query().Get("expires"))
+
+	at, err := signer.Verify("example.com", u.Query().Get("expires"), u.Query().Get("sig"), now)
+	require.NoError(t, err)
+	assert.True(t, at.Equal(expires))
+
+	_, err = signer.Verify("other.com", u.Query().Get("expires"), u.Query().Get("sig"), now)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "the signature covers the domain")
+	_, err = signer.Verify("example.com", "1760007200", u.Query().Get("sig"), now)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "the signature covers the expiry")
+	_, err = NewSigner("other").Verify("example.com", u.Query().Get("expires"), u.Query().Get("sig"), now)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = signer.Verify("example.com", u.Query().Get("expires"), u.Query().Get("sig"), expires)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	_, err = signer.URL("/relative", "example.com", expires)
+	assert.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	service := &fakeService{states: map[string]*state.ProvisionState{
+		"example.com": {
+			ID: "3f6c1a9e", Domain: "example.com", User: "alice", Status: state.StatusSuccess,
+			CurrentStep: state.StepDone, ZoneID: 7, PullZoneID: 9, CDNHostname: "morden-example-com.b-cdn.net",
+		},
+		"new.example.org": {Domain: "new.example.org", Status: state.StatusFailed, CurrentStep: state.StepPullZone, Error: "pull zone quota exceeded"},
+	}}
+	signer := NewSigner("secret")
+	h := NewHandler(signer, service, nil)
+	h.SetClock(clock.NewFake(now))
+	router := h.Routes()
+
+	get := func(domain string, expires time.Time, accept string) *httptest.ResponseRecorder {
+		link, err := signer.URL("https://status.example.net", domain, expires)
+		require.NoError(t, err)
+		u, _ := url.Parse(link)
+		req := httptest.NewRequest(http.MethodGet, "/"+domain+"?"+u.RawQuery, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("example.com", now.Add(time.Hour), "application/json")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var page Page
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, status.StateComplete, page.State)
+	assert.Equal(t, 4, page.StepsDone)
+	assert.Equal(t, 4, page.StepsTotal)
+	require.NotNil(t, page.Stats)
+	assert.Equal(t, int64(120000), page.Stats.Requests)
+	assert.NotContains(t, w.Body.String(), "alice", "the owner is not shown")
+	assert.NotContains(t, w.Body.String(), "3f6c1a9e", "state IDs are not shown")
+
+	w = get("example.com", now.Add(time.Hour), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "served through the CDN")
+	assert.Contains(t, w.Body.String(), "3.00 GB")
+	assert.Equal(t, 1, service.reports, "statistics are cached")
+
+	w = get("new.example.org", now.Add(time.Hour), "application/json")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = Page{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, status.StateFailed, page.State)
+	assert.Equal(t, 2, page.StepsDone)
+	assert.Nil(t, page.Stats)
+	assert.NotContains(t, w.Body.String(), "quota", "errors are not shown")
+
+	w = get("example.com", now.Add(-time.Minute), "application/json")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "expired")
+
+	w = get("missing.example.com", now.Add(time.Hour), "application/json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/example.com?expires=1900000000&sig=forged", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
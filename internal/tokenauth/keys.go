@@ -0,0 +1,96 @@
+package tokenauth
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/filestore"
+)
+
+// Key is a pull zone security key
+type Key struct {
+	Key       string    `json:"key"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// KeyStore persists token authentication keys per domain
+// The file is written with 0600 permissions
+type KeyStore struct {
+	file   *filestore.File[map[string]Key]
+	keys   map[string]Key
+	mu     sync.Mutex
+	logger *zap.Logger
+}
+
+// NewKeyStore creates a key store backed by filePath
+func NewKeyStore(filePath string, logger *zap.Logger) (*KeyStore, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	file, err := filestore.New(filePath, "key", 0600, func() map[string]Key {
+		return make(map[string]Key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &KeyStore{
+		file:   file,
+		logger: logger,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Reload(&s.keys); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns the key for a domain
+func (s *KeyStore) Get(domain string) (Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.keys); err != nil {
+		s.logger.Warn("Failed to reload token keys, using cached copy", zap.Error(err))
+	}
+
+	k, ok := s.keys[domainname.Normalize(domain)]
+	return k, ok
+}
+
+// Set stores a new key for a domain
+func (s *KeyStore) Set(domain, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.keys); err != nil {
+		return err
+	}
+
+	s.keys[domainname.Normalize(domain)] = Key{Key: key, RotatedAt: time.Now()}
+	return s.file.Save(s.keys)
+}
+
+// Delete removes a domain's key
+func (s *KeyStore) Delete(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(&s.keys); err != nil {
+		return err
+	}
+
+	key := domainname.Normalize(domain)
+	if _, ok := s.keys[key]; !ok {
+		return nil
+	}
+	delete(s.keys, key)
+	return s.file.Save(s.keys)
+}
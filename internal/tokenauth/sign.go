@@ -0,0 +1,57 @@
+package tokenauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyBytes is the length of generated security keys before hex encoding
+const keyBytes = 32
+
+// GenerateKey returns a new random security key
+func GenerateKey() (string, error) {
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate security key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignURL signs rawURL with Bunny's basic token authentication scheme:
+// token = base64url(sha256(key + path + expires)) without padding
+func SignURL(rawURL, key string, expires time.Time) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("security key is required")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL must be absolute: %s", rawURL)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sum := sha256.Sum256([]byte(key + path + exp))
+	token := strings.TrimRight(base64.URLEncoding.EncodeToString(sum[:]), "=")
+
+	q := u.Query()
+	q.Set("token", token)
+	q.Set("expires", exp)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,71 @@
+package tokenauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGenerateKey(t *testing.T) {
+	a, err := GenerateKey()
+	require.NoError(t, err)
+	b, err := GenerateKey()
+	require.NoError(t, err)
+
+	assert.Len(t, a, keyBytes*2)
+	assert.NotEqual(t, a, b)
+}
+
+func TestSignURL(t *testing.T) {
+	expires := time.Unix(1760000000, 0)
+
+	signed, err := SignURL("https://cdn.example.com/images/logo.png?v=2", "secret", expires)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "1760000000", u.Query().Get("expires"))
+	assert.Equal(t, "2", u.Query().Get("v"))
+
+	sum := sha256.Sum256([]byte("secret/images/logo.png1760000000"))
+	want := strings.TrimRight(base64.URLEncoding.EncodeToString(sum[:]), "=")
+	assert.Equal(t, want, u.Query().Get("token"))
+
+	_, err = SignURL("/relative/path", "secret", expires)
+	assert.Error(t, err)
+
+	_, err = SignURL("https://cdn.example.com/", "", expires)
+	assert.Error(t, err)
+}
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_keys.json")
+	store, err := NewKeyStore(path, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Set("Example.com", "k1"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A second store on the same file sees the key (CLI -> daemon)
+	other, err := NewKeyStore(path, zap.NewNop())
+	require.NoError(t, err)
+	k, ok := other.Get("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "k1", k.Key)
+
+	require.NoError(t, other.Delete("example.com"))
+	_, ok = store.Get("example.com")
+	assert.False(t, ok)
+}
@@ -194,6 +194,8 @@ func (v *Validator) ValidateWebhookPayload(payload *webhook.WebhookPayload) erro
 		"addon_created":     true,
 		"subdomain_created": true,
 		"account_deleted":   true,
+		"addon_deleted":     true,
+		"subdomain_deleted": true,
 	}
 
 	if !validEvents[payload.Event] {
@@ -207,7 +209,7 @@ func (v *Validator) ValidateWebhookPayload(payload *webhook.WebhookPayload) erro
 
 	// Event-specific validation
 	switch payload.Event {
-	case "account_created", "addon_created", "account_deleted":
+	case "account_created", "addon_created", "account_deleted", "addon_deleted":
 		if payload.Domain == "" {
 			return fmt.Errorf("domain is required for event '%s'", payload.Event)
 		}
@@ -216,7 +218,7 @@ func (v *Validator) ValidateWebhookPayload(payload *webhook.WebhookPayload) erro
 			return fmt.Errorf("invalid domain: %w", err)
 		}
 
-	case "subdomain_created":
+	case "subdomain_created", "subdomain_deleted":
 		if payload.Subdomain == "" {
 			return fmt.Errorf("subdomain is required for event '%s'", payload.Event)
 		}
@@ -233,7 +235,8 @@ func (v *Validator) ValidateWebhookPayload(payload *webhook.WebhookPayload) erro
 		}
 	}
 
-	return nil
+	// Rules of the payload's schema version
+	return payload.ValidateVersion()
 }
 
 // validateDomainDNS performs DNS validation for a domain
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mordenhost/whm2bunny/internal/overrides"
 	"github.com/mordenhost/whm2bunny/internal/webhook"
 )
 
@@ -299,11 +300,38 @@ func TestValidateWebhookPayload_SubdomainEvent(t *testing.T) {
 	}
 }
 
+// TestValidateWebhookPayload_SchemaVersion tests version-specific rules
+func TestValidateWebhookPayload_SchemaVersion(t *testing.T) {
+	v := NewValidator()
+
+	payload := &webhook.WebhookPayload{
+		SchemaVersion:  webhook.SchemaV2,
+		Event:          "account_created",
+		Domain:         "example.com",
+		User:           "testuser",
+		IdempotencyKey: "req-1",
+	}
+	if err := v.ValidateWebhookPayload(payload); err != nil {
+		t.Errorf("ValidateWebhookPayload(v2) returned error: %v", err)
+	}
+
+	payload.IdempotencyKey = ""
+	if err := v.ValidateWebhookPayload(payload); err == nil {
+		t.Error("ValidateWebhookPayload(v2 without idempotency key) should return error")
+	}
+
+	payload.SchemaVersion = webhook.SchemaV1
+	payload.Options = &overrides.Override{}
+	if err := v.ValidateWebhookPayload(payload); err == nil {
+		t.Error("ValidateWebhookPayload(v1 with options) should return error")
+	}
+}
+
 // TestValidateWebhookPayload_AllEventTypes tests all valid event types
 func TestValidateWebhookPayload_AllEventTypes(t *testing.T) {
 	v := NewValidator()
 
-	domainEvents := []string{"account_created", "addon_created", "account_deleted"}
+	domainEvents := []string{"account_created", "addon_created", "account_deleted", "addon_deleted"}
 	for _, event := range domainEvents {
 		t.Run(event, func(t *testing.T) {
 			payload := &webhook.WebhookPayload{
@@ -318,19 +346,21 @@ func TestValidateWebhookPayload_AllEventTypes(t *testing.T) {
 		})
 	}
 
-	// Subdomain event
-	t.Run("subdomain_created", func(t *testing.T) {
-		payload := &webhook.WebhookPayload{
-			Event:        "subdomain_created",
-			Subdomain:    "www",
-			ParentDomain: "example.com",
-			User:         "testuser",
-		}
-		err := v.ValidateWebhookPayload(payload)
-		if err != nil {
-			t.Errorf("ValidateWebhookPayload(subdomain_created) returned error: %v", err)
-		}
-	})
+	// Subdomain events
+	for _, event := range []string{"subdomain_created", "subdomain_deleted"} {
+		t.Run(event, func(t *testing.T) {
+			payload := &webhook.WebhookPayload{
+				Event:        event,
+				Subdomain:    "www",
+				ParentDomain: "example.com",
+				User:         "testuser",
+			}
+			err := v.ValidateWebhookPayload(payload)
+			if err != nil {
+				t.Errorf("ValidateWebhookPayload(event=%s) returned error: %v", event, err)
+			}
+		})
+	}
 }
 
 // TestValidateOriginIP tests origin IP validation
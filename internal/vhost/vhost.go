@@ -0,0 +1,154 @@
+// Package vhost checks that an origin serves a site for its Host header
+// rather than its default virtual host, a common cPanel misconfiguration
+// when the domain is missing from the account or the pull zone sends the
+// wrong Host header. The site is requested through the CDN and at the
+// origin, and compared with the page the origin serves for its bare IP
+package vhost
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Result is the outcome of a check
+type Result string
+
+const (
+	// ResultOK means the CDN and the origin serve the same site
+	ResultOK Result = "ok"
+	// ResultDefaultVHost means the origin serves its default virtual host
+	// for the domain
+	ResultDefaultVHost Result = "default_vhost"
+	// ResultMismatch means the CDN and the origin answer differently
+	ResultMismatch Result = "mismatch"
+	// ResultFailed means the site could not be requested
+	ResultFailed Result = "failed"
+)
+
+// maxBody bounds how much of a page is read to fingerprint it
+const maxBody = 64 << 10
+
+// userAgent identifies the check's requests in the origin's logs
+const userAgent = "whm2bunny-vhost-check"
+
+// defaultPageMarkers are found on cPanel's default page, or in the redirect
+// to it
+var defaultPageMarkers = []string{"defaultwebpage.cgi", "Default Web Site Page"}
+
+// titlePattern matches the title of an HTML page
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Response is what a request of the site returned
+type Response struct {
+	Status      int    `json:"status"`
+	Fingerprint string `json:"fingerprint"`
+	// DefaultPage is set for cPanel's default page
+	DefaultPage bool `json:"default_page,omitempty"`
+}
+
+// same reports whether two responses show the same page
+func (r *Response) same(other *Response) bool {
+	return r.Status == other.Status && r.Fingerprint == other.Fingerprint
+}
+
+// Read fingerprints a response. A page is known by its title, which
+// survives the timestamps and tokens of dynamic pages, else by a hash of its
+// body; a redirect by its target
+func Read(status int, header http.Header, body []byte) *Response {
+	r := &Response{Status: status}
+	location := header.Get("Location")
+	switch {
+	case status >= 300 && status < 400:
+		r.Fingerprint = "location:" + location
+	case titlePattern.Match(body):
+		title := titlePattern.FindSubmatch(body)[1]
+		r.Fingerprint = "title:" + strings.Join(strings.Fields(strings.ToLower(html.UnescapeString(string(title)))), " ")
+	default:
+		sum := sha256.Sum256(body)
+		r.Fingerprint = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	for _, marker := range defaultPageMarkers {
+		if strings.Contains(location, marker) || bytes.Contains(body, []byte(marker)) {
+			r.DefaultPage = true
+		}
+	}
+	return r
+}
+
+// Fetch requests url with the Host header host, empty keeping the URL's,
+// and reads the response without following redirects
+func Fetch(ctx context.Context, client *http.Client, url, host string) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		req.Host = host
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s (Host: %s): %w", url, req.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("%s (Host: %s): %w", url, req.Host, err)
+	}
+	return Read(resp.StatusCode, resp.Header, body), nil
+}
+
+// Report holds the responses of a check and its result. Default is the
+// origin's answer for its bare IP, its default virtual host
+type Report struct {
+	Result Result
+
+	CDN    *Response
+	CDNErr error
+
+	Origin    *Response
+	OriginErr error
+
+	Default    *Response
+	DefaultErr error
+}
+
+// Evaluate sets the result of the report from its responses. The origin
+// serves its default virtual host when its answer for the domain is cPanel's
+// default page or the same as for its bare IP; a default virtual host
+// answering an error is not compared, as any missing page would match it
+func (r *Report) Evaluate() Result {
+	isDefault := func(resp *Response) bool {
+		if resp == nil {
+			return false
+		}
+		if resp.DefaultPage {
+			return true
+		}
+		return r.Default != nil && r.Default.Status < http.StatusBadRequest && resp.same(r.Default)
+	}
+
+	switch {
+	case r.Origin == nil:
+		r.Result = ResultFailed
+	case isDefault(r.Origin), isDefault(r.CDN):
+		r.Result = ResultDefaultVHost
+	case r.CDN == nil:
+		r.Result = ResultFailed
+	case !r.CDN.same(r.Origin):
+		r.Result = ResultMismatch
+	default:
+		r.Result = ResultOK
+	}
+	return r.Result
+}
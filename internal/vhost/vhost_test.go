@@ -0,0 +1,79 @@
+package vhost
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	// Dynamic pages are known by their title
+	a := Read(http.StatusOK, http.Header{}, []byte("<html><head><title> Acme &amp; Co\n</title></head><body>token=1</body></html>"))
+	b := Read(http.StatusOK, http.Header{}, []byte("<html><head><TITLE>acme &amp; co</TITLE></head><body>token=2</body></html>"))
+	assert.Equal(t, "title:acme & co", a.Fingerprint)
+	assert.True(t, a.same(b))
+	assert.False(t, a.DefaultPage)
+
+	untitled := Read(http.StatusOK, http.Header{}, []byte("plain"))
+	assert.Contains(t, untitled.Fingerprint, "sha256:")
+	assert.False(t, untitled.same(Read(http.StatusOK, http.Header{}, []byte("other"))))
+
+	redirect := Read(http.StatusFound, http.Header{"Location": {"/cgi-sys/defaultwebpage.cgi"}}, nil)
+	assert.Equal(t, "location:/cgi-sys/defaultwebpage.cgi", redirect.Fingerprint)
+	assert.True(t, redirect.DefaultPage)
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, userAgent, r.UserAgent())
+		if r.Host == "example.com" {
+			w.Write([]byte("<title>Example</title>"))
+			return
+		}
+		http.Redirect(w, r, "/cgi-sys/defaultwebpage.cgi", http.StatusFound)
+	}))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	site, err := Fetch(context.Background(), client, srv.URL+"/", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, site.Status)
+	assert.Equal(t, "title:example", site.Fingerprint)
+
+	fallback, err := Fetch(context.Background(), client, srv.URL+"/", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, fallback.Status)
+	assert.True(t, fallback.DefaultPage)
+}
+
+func TestEvaluate(t *testing.T) {
+	site := &Response{Status: http.StatusOK, Fingerprint: "title:example"}
+	fallback := &Response{Status: http.StatusOK, Fingerprint: "title:apache2 default page"}
+	notFound := &Response{Status: http.StatusNotFound, Fingerprint: "title:404 not found"}
+
+	tests := []struct {
+		name   string
+		report Report
+		want   Result
+	}{
+		{"same site", Report{CDN: site, Origin: site, Default: fallback}, ResultOK},
+		{"origin serves default", Report{CDN: fallback, Origin: fallback, Default: fallback}, ResultDefaultVHost},
+		{"cPanel default page", Report{CDN: site, Origin: &Response{Status: http.StatusOK, Fingerprint: "x", DefaultPage: true}}, ResultDefaultVHost},
+		{"CDN serves default", Report{CDN: fallback, Origin: site, Default: fallback}, ResultDefaultVHost},
+		{"error pages are not compared", Report{CDN: notFound, Origin: notFound, Default: notFound}, ResultOK},
+		{"status differs", Report{CDN: &Response{Status: http.StatusBadGateway, Fingerprint: "title:example"}, Origin: site}, ResultMismatch},
+		{"content differs", Report{CDN: &Response{Status: http.StatusOK, Fingerprint: "title:other"}, Origin: site}, ResultMismatch},
+		{"origin unreachable", Report{CDN: site, OriginErr: errors.New("refused")}, ResultFailed},
+		{"CDN unreachable", Report{Origin: site, CDNErr: errors.New("refused"), Default: fallback}, ResultFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.report.Evaluate())
+		})
+	}
+}
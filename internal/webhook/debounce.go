@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+)
+
+// debounceKey identifies events that repeat one another. The package is
+// part of it so a quick second package change still applies
+type debounceKey struct {
+	event  string
+	domain string
+	pkg    string
+	// idempotencyKey is set instead of the domain and package for the
+	// idempotency claims of v2 payloads
+	idempotencyKey string
+}
+
+// debounced is an event accepted within the debounce window
+type debounced struct {
+	trackingID string
+	at         time.Time
+}
+
+// debouncer collapses events with the same type and domain that arrive
+// within a window of the first one, as cPanel may fire a hook several
+// times while restoring an account. The window starts at the accepted
+// event, so a steady stream of repeats still gets through once per window
+type debouncer struct {
+	mu       sync.Mutex
+	window   time.Duration
+	clock    clock.Clock
+	accepted map[debounceKey]debounced
+}
+
+// newDebouncer creates a debouncer; a zero window accepts every event
+func newDebouncer(window time.Duration, c clock.Clock) *debouncer {
+	return &debouncer{
+		window:   window,
+		clock:    c,
+		accepted: make(map[debounceKey]debounced),
+	}
+}
+
+// claim accepts an event under trackingID unless one with the same key
+// was accepted within the window, in which case it returns that event's
+// tracking ID and true
+func (d *debouncer) claim(key debounceKey, trackingID string) (string, bool) {
+	if d.window <= 0 {
+		return "", false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	for k, prev := range d.accepted {
+		if now.Sub(prev.at) >= d.window {
+			delete(d.accepted, k)
+		}
+	}
+
+	if prev, ok := d.accepted[key]; ok {
+		return prev.trackingID, true
+	}
+	d.accepted[key] = debounced{trackingID: trackingID, at: now}
+	return "", false
+}
+
+// release forgets a claimed event that was rejected after all, so a
+// retry is not taken for a repeat
+func (d *debouncer) release(key debounceKey, trackingID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if prev, ok := d.accepted[key]; ok && prev.trackingID == trackingID {
+		delete(d.accepted, key)
+	}
+}
+
+// releaseOpposing forgets the claims on key's domain of the events key's
+// event undoes. A create, delete and create within the window would
+// otherwise take the second create for a repeat of the first, leaving the
+// domain deleted
+func (d *debouncer) releaseOpposing(key debounceKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k := range d.accepted {
+		if k.domain == key.domain && eventsOppose(key.event, k.event) {
+			delete(d.accepted, k)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+)
+
+// Content types accepted for webhook bodies. Requests without a content
+// type are read as JSON, as the first hook scripts sent none
+const (
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// errUnsupportedContentType is returned for bodies that are neither JSON
+// nor form-encoded
+var errUnsupportedContentType = errors.New("unsupported content type")
+
+// decodePayload parses a webhook body according to its content type. The
+// signature is verified over the raw body beforehand, whatever its encoding
+func decodePayload(contentType string, body []byte) (WebhookPayload, error) {
+	var payload WebhookPayload
+
+	mediaType := contentTypeJSON
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return payload, fmt.Errorf("%w: %s", errUnsupportedContentType, contentType)
+		}
+	}
+
+	switch mediaType {
+	case contentTypeJSON:
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return payload, err
+		}
+		return payload, nil
+	case contentTypeForm:
+		return decodeForm(body)
+	default:
+		return payload, fmt.Errorf("%w: %s", errUnsupportedContentType, mediaType)
+	}
+}
+
+// decodeForm parses a form-encoded body, as sent by legacy hook scripts.
+// Fields carry the JSON names; options, when present, is a JSON object
+func decodeForm(body []byte) (WebhookPayload, error) {
+	var payload WebhookPayload
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return payload, fmt.Errorf("invalid form body: %w", err)
+	}
+
+	if v := strings.TrimSpace(values.Get("schema_version")); v != "" {
+		payload.SchemaVersion, err = strconv.Atoi(v)
+		if err != nil {
+			return payload, fmt.Errorf("schema_version must be a number, got %q", v)
+		}
+	}
+	payload.Event = values.Get("event")
+	payload.Domain = values.Get("domain")
+	payload.Subdomain = values.Get("subdomain")
+	payload.ParentDomain = values.Get("parent_domain")
+	payload.User = values.Get("user")
+	payload.Reseller = values.Get("reseller")
+	payload.Package = values.Get("package")
+	payload.IdempotencyKey = values.Get("idempotency_key")
+
+	if v := values.Get("options"); v != "" {
+		var options overrides.Override
+		if err := json.Unmarshal([]byte(v), &options); err != nil {
+			return payload, fmt.Errorf("options must be a JSON object: %w", err)
+		}
+		payload.Options = &options
+	}
+	return payload, nil
+}
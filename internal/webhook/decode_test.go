@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDecodePayload(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		for _, contentType := range []string{"", "application/json", "application/json; charset=utf-8"} {
+			payload, err := decodePayload(contentType, []byte(`{"event":"account_created","domain":"example.com","user":"alice"}`))
+			require.NoError(t, err, contentType)
+			assert.Equal(t, WebhookPayload{Event: "account_created", Domain: "example.com", User: "alice"}, payload)
+		}
+	})
+
+	t.Run("form", func(t *testing.T) {
+		body := url.Values{
+			"schema_version":  {"2"},
+			"event":           {"subdomain_created"},
+			"subdomain":       {"blog"},
+			"parent_domain":   {"example.com"},
+			"user":            {"alice"},
+			"reseller":        {"res"},
+			"package":         {"gold"},
+			"idempotency_key": {"req-1"},
+			"options":         {`{"origin_protocol":"https"}`},
+		}.Encode()
+
+		payload, err := decodePayload("application/x-www-form-urlencoded", []byte(body))
+		require.NoError(t, err)
+		assert.Equal(t, SchemaV2, payload.SchemaVersion)
+		assert.Equal(t, "subdomain_created", payload.Event)
+		assert.Equal(t, "blog.example.com", payload.FullDomain())
+		assert.Equal(t, "alice", payload.User)
+		assert.Equal(t, "res", payload.Reseller)
+		assert.Equal(t, "gold", payload.Package)
+		assert.Equal(t, "req-1", payload.IdempotencyKey)
+		require.NotNil(t, payload.Options)
+		assert.Equal(t, "https", payload.Options.OriginProtocol)
+	})
+
+	t.Run("invalid form fields", func(t *testing.T) {
+		_, err := decodePayload("application/x-www-form-urlencoded", []byte("event=account_created&schema_version=two"))
+		assert.ErrorContains(t, err, "schema_version")
+
+		_, err = decodePayload("application/x-www-form-urlencoded", []byte("event=account_created&options=nope"))
+		assert.ErrorContains(t, err, "options")
+
+		_, err = decodePayload("application/x-www-form-urlencoded", []byte("event=%zz"))
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		_, err := decodePayload("text/plain", []byte("event=account_created"))
+		assert.ErrorIs(t, err, errUnsupportedContentType)
+
+		_, err = decodePayload("not a type;;", []byte("{}"))
+		assert.ErrorIs(t, err, errUnsupportedContentType)
+	})
+}
+
+func TestServeHTTP_ContentTypes(t *testing.T) {
+	secret := "test-secret"
+	prov := &countingProvisioner{calls: make(chan string, 10)}
+	handler := NewHandler(prov, secret, zap.NewNop())
+
+	send := func(contentType, body string) *httptest.ResponseRecorder {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("application/json", `{"event":"addon_created","domain":"json.com","user":"alice"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "json.com", <-prov.calls)
+
+	// The signature covers the raw form body
+	w = send("application/x-www-form-urlencoded", "event=addon_created&domain=form.com&user=alice")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Success)
+	assert.Equal(t, "form.com", <-prov.calls)
+
+	// The signature of the same fields in another order does not match
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("event=addon_created&domain=form.com&user=alice"))
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("user=alice&event=addon_created&domain=form.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Form payloads are validated like JSON ones
+	w = send("application/x-www-form-urlencoded", "event=addon_created&domain=nouser.com")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("text/plain", "event=addon_created&domain=text.com&user=alice")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	select {
+	case domain := <-prov.calls:
+		t.Fatalf("unexpected provision of %s", domain)
+	default:
+	}
+}
@@ -5,20 +5,38 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/quota"
 )
 
 const (
 	signatureHeader       = "X-Whm2bunny-Signature"
+	estimatedWaitHeader   = "X-Whm2bunny-Estimated-Wait"
 	eventAccountCreated   = "account_created"
 	eventAddonCreated     = "addon_created"
 	eventSubdomainCreated = "subdomain_created"
 	eventAccountDeleted   = "account_deleted"
+	eventAddonDeleted     = "addon_deleted"
+	eventSubdomainDeleted = "subdomain_deleted"
+	eventPackageChanged   = "package_changed"
+
+	// idempotencyWindow is how long the idempotency key of an accepted
+	// event is remembered
+	idempotencyWindow = 24 * time.Hour
 )
 
 // Provisioner interface defines the operations for provisioning and deprovisioning
@@ -26,15 +44,100 @@ type Provisioner interface {
 	Provision(domain, user string) error
 	ProvisionSubdomain(subdomain, parentDomain, user string) error
 	Deprovision(domain string) error
+	RemoveSubdomain(subdomain, parentDomain string) error
+}
+
+// PackageAssigner is optionally implemented by a Provisioner to record the
+// WHM package of a domain, which selects its CDN profile
+type PackageAssigner interface {
+	AssignPackage(domain, pkg string) error
+}
+
+// ResellerAssigner is optionally implemented by a Provisioner to record the
+// WHM reseller of a domain, which selects its SOA email
+type ResellerAssigner interface {
+	AssignReseller(domain, reseller string) error
+}
+
+// UserDeprovisioner is optionally implemented by a Provisioner to remove
+// every domain a WHM user owns when the account is deleted
+type UserDeprovisioner interface {
+	DeprovisionUser(user, domain string) error
+}
+
+// PackageChanger is optionally implemented by a Provisioner to re-apply the
+// CDN profile to every domain of a WHM user when its package changes
+type PackageChanger interface {
+	ChangePackage(user, domain, pkg string) error
+}
+
+// FreezeChecker is optionally implemented by a Provisioner to suspend
+// webhook events for domains frozen by an administrator
+type FreezeChecker interface {
+	IsFrozen(domain string) bool
+}
+
+// Auditor records events dropped from the queue
+type Auditor interface {
+	Record(entry audit.Entry) error
+}
+
+// QuotaEnforcer limits how many provisioning requests an owner may submit
+type QuotaEnforcer interface {
+	Consume(owner string) error
+}
+
+// OverrideStore records the per-domain overrides sent as v2 options
+type OverrideStore interface {
+	Update(domain string, fn func(o *overrides.Override)) error
+}
+
+// Pauser reports whether an administrator paused the processing of
+// queued events
+type Pauser interface {
+	Paused() bool
+}
+
+// RunLog records the log entries of each run of an event, under its
+// tracking ID
+type RunLog interface {
+	Begin(domain, id string) (end func())
 }
 
 // WebhookPayload represents the incoming webhook payload from WHM/cPanel
 type WebhookPayload struct {
-	Event        string `json:"event"`
-	Domain       string `json:"domain"`
-	Subdomain    string `json:"subdomain,omitempty"`
-	ParentDomain string `json:"parent_domain,omitempty"`
-	User         string `json:"user"`
+	// SchemaVersion is the payload format; see Version
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	Event         string `json:"event"`
+	Domain        string `json:"domain"`
+	Subdomain     string `json:"subdomain,omitempty"`
+	ParentDomain  string `json:"parent_domain,omitempty"`
+	User          string `json:"user"`
+	Reseller      string `json:"reseller,omitempty"`
+	Package       string `json:"package,omitempty"`
+
+	// IdempotencyKey identifies the request across a hook's retries (v2)
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Options replace the domain's overrides before it is provisioned (v2)
+	Options *overrides.Override `json:"options,omitempty"`
+}
+
+// QuotaOwner returns the key quotas are counted against:
+// the owning reseller when known, otherwise the cPanel user
+func (p *WebhookPayload) QuotaOwner() string {
+	if p.Reseller != "" {
+		return p.Reseller
+	}
+	return p.User
+}
+
+// FullDomain returns the domain being acted on, joining subdomain and
+// parent domain for subdomain events
+func (p *WebhookPayload) FullDomain() string {
+	if p.Event == eventSubdomainCreated || p.Event == eventSubdomainDeleted {
+		return fmt.Sprintf("%s.%s", p.Subdomain, p.ParentDomain)
+	}
+	return p.Domain
 }
 
 // Response represents a successful webhook response
@@ -42,6 +145,26 @@ type Response struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	ID      string `json:"id,omitempty"`
+	// SchemaVersion is the version the payload was handled as, so hooks
+	// sending a newer version can tell what the server understood
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// Queue is set when the event waits in a backlog deeper than the
+	// backlog threshold
+	Queue *QueuePosition `json:"queue,omitempty"`
+}
+
+// QueuePosition tells a hook how long an accepted event will likely wait
+type QueuePosition struct {
+	// Position is the event's place in the backlog, 1 being next
+	Position int `json:"position"`
+	// Depth is the number of events waiting, this one included
+	Depth int `json:"depth"`
+	// Paused is set while an administrator holds the pipeline
+	Paused bool `json:"paused,omitempty"`
+	// EstimatedStart and EstimatedWaitSeconds are left out while paused
+	// or until enough events finished to measure the throughput
+	EstimatedStart       *time.Time `json:"estimated_start,omitempty"`
+	EstimatedWaitSeconds int        `json:"estimated_wait_seconds,omitempty"`
 }
 
 // ErrorResponse represents an error response
@@ -50,11 +173,36 @@ type ErrorResponse struct {
 	Details string `json:"details,omitempty"`
 }
 
+// QuotaErrorResponse is returned with 429 when a provisioning quota is exhausted
+type QuotaErrorResponse struct {
+	Error   string    `json:"error"`
+	Details string    `json:"details"`
+	Owner   string    `json:"owner"`
+	Scope   string    `json:"scope"`
+	Limit   int       `json:"limit"`
+	Used    int       `json:"used"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
 // Handler handles incoming webhooks from WHM/cPanel
 type Handler struct {
 	provisioner Provisioner
 	secret      string
 	logger      *zap.Logger
+	quota       QuotaEnforcer
+	audit       Auditor
+	overrides   OverrideStore
+	runLog      RunLog
+	queue       *domainQueue
+	debounce    *debouncer
+	idempotency *debouncer
+
+	// maxBodyBytes is the largest body read; see SetMaxBodyBytes
+	maxBodyBytes int64
+	// backlogThreshold is the backlog depth past which accepted events
+	// are answered with their queue position; see SetBacklogThreshold
+	backlogThreshold int
+	stats            stats
 }
 
 // NewHandler creates a new webhook handler
@@ -62,11 +210,93 @@ func NewHandler(provisioner Provisioner, secret string, logger *zap.Logger) *Han
 	if logger == nil {
 		logger = zap.NewNop()
 	}
-	return &Handler{
-		provisioner: provisioner,
-		secret:      secret,
-		logger:      logger,
+	h := &Handler{
+		provisioner:  provisioner,
+		secret:       secret,
+		logger:       logger,
+		maxBodyBytes: config.DefaultWebhookMaxBodyBytes,
 	}
+	h.queue = newDomainQueue(h.recordCancelled)
+	h.debounce = newDebouncer(0, clock.Real{})
+	h.idempotency = newDebouncer(idempotencyWindow, clock.Real{})
+	return h
+}
+
+// SetDebounce drops events repeating the type and domain of an event
+// accepted within window; 0 accepts every event
+func (h *Handler) SetDebounce(window time.Duration) {
+	h.debounce.window = window
+}
+
+// SetMaxBodyBytes sets the largest body accepted; larger requests are
+// rejected with 413
+func (h *Handler) SetMaxBodyBytes(n int64) {
+	h.maxBodyBytes = n
+}
+
+// SetBacklogThreshold answers events accepted while more than n events
+// wait with their queue position and estimated start; 0 never does
+func (h *Handler) SetBacklogThreshold(n int) {
+	h.backlogThreshold = n
+}
+
+// SetClock replaces the clock the debounce and idempotency windows and the
+// queue throughput are measured with
+func (h *Handler) SetClock(c clock.Clock) {
+	h.debounce.clock = c
+	h.idempotency.clock = c
+	h.queue.clock = c
+}
+
+// SetAudit enables audit entries for events cancelled by a newer event
+func (h *Handler) SetAudit(a Auditor) {
+	h.audit = a
+}
+
+// SetQuota enables quota enforcement for provisioning events
+func (h *Handler) SetQuota(q QuotaEnforcer) {
+	h.quota = q
+}
+
+// SetPause holds queued events back while p reports the pipeline paused;
+// call ResumeQueue once it is resumed
+func (h *Handler) SetPause(p Pauser) {
+	h.queue.paused = p.Paused
+}
+
+// ResumeQueue starts the events queued while the pipeline was paused
+func (h *Handler) ResumeQueue() {
+	h.queue.resume()
+}
+
+// Queued returns the number of accepted events not yet started
+func (h *Handler) Queued() int {
+	return h.queue.queued()
+}
+
+// SetOverrides enables recording the options of v2 provisioning events as
+// the domain's overrides
+func (h *Handler) SetOverrides(o OverrideStore) {
+	h.overrides = o
+}
+
+// SetRunLog records the log entries of each event's run for its domain
+func (h *Handler) SetRunLog(r RunLog) {
+	h.runLog = r
+}
+
+// rejectTooLarge answers a request whose body exceeds the limit
+func (h *Handler) rejectTooLarge(w http.ResponseWriter, r *http.Request) {
+	h.stats.tooLarge.Add(1)
+	h.logger.Warn("webhook body too large",
+		zap.Int64("content_length", r.ContentLength),
+		zap.Int64("limit", h.maxBodyBytes),
+		zap.String("remote_addr", r.RemoteAddr),
+	)
+	writeJSONResponse(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+		Error:   "request too large",
+		Details: fmt.Sprintf("body exceeds %d bytes", h.maxBodyBytes),
+	})
 }
 
 // ServeHTTP implements the http.Handler interface
@@ -80,8 +310,20 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read request body
-	body, err := io.ReadAll(r.Body)
+	h.stats.received.Add(1)
+
+	// Refuse oversized bodies before reading them; chunked bodies of unknown
+	// length are cut off at the limit while being read
+	if r.ContentLength > h.maxBodyBytes {
+		h.rejectTooLarge(w, r)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.rejectTooLarge(w, r)
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to read request body", zap.Error(err))
 		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{
@@ -95,6 +337,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Verify HMAC signature
 	signature := r.Header.Get(signatureHeader)
 	if !h.verifySignature(body, signature) {
+		h.stats.unauthorized.Add(1)
 		h.logger.Warn("invalid signature",
 			zap.String("signature", signature),
 			zap.String("remote_addr", r.RemoteAddr),
@@ -106,10 +349,20 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse JSON payload
-	var payload WebhookPayload
-	if err := json.Unmarshal(body, &payload); err != nil {
-		h.logger.Error("failed to parse JSON payload", zap.Error(err))
+	// Parse the JSON or form-encoded payload
+	payload, err := decodePayload(r.Header.Get("Content-Type"), body)
+	if errors.Is(err, errUnsupportedContentType) {
+		h.stats.invalid.Add(1)
+		h.logger.Warn("unsupported webhook content type", zap.Error(err))
+		writeJSONResponse(w, http.StatusUnsupportedMediaType, ErrorResponse{
+			Error:   "unsupported media type",
+			Details: "send application/json or application/x-www-form-urlencoded",
+		})
+		return
+	}
+	if err != nil {
+		h.stats.invalid.Add(1)
+		h.logger.Error("failed to parse payload", zap.Error(err))
 		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{
 			Error:   "invalid payload",
 			Details: err.Error(),
@@ -117,8 +370,18 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Newer hooks may talk to an older server; handle what it understands
+	if !payload.KnownVersion() {
+		h.logger.Warn("unknown webhook schema version, handling as latest",
+			zap.Int("schema_version", payload.SchemaVersion),
+			zap.Int("latest", LatestSchemaVersion),
+		)
+	}
+	version := min(payload.Version(), LatestSchemaVersion)
+
 	// Validate payload
 	if err := validatePayload(&payload); err != nil {
+		h.stats.invalid.Add(1)
 		h.logger.Warn("payload validation failed", zap.Error(err))
 		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{
 			Error:   "validation failed",
@@ -127,18 +390,153 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Leave frozen domains to the administrator debugging them
+	if checker, ok := h.provisioner.(FreezeChecker); ok && checker.IsFrozen(payload.FullDomain()) {
+		h.recordFrozen(payload)
+		writeJSONResponse(w, http.StatusAccepted, Response{
+			Success:       true,
+			Message:       "Domain frozen, event ignored",
+			SchemaVersion: version,
+		})
+		return
+	}
+
 	// Generate tracking ID
 	trackingID := uuid.New().String()
 
+	// A retried request is answered with the event it repeats
+	if payload.IdempotencyKey != "" {
+		if prevID, dup := h.idempotency.claim(idempotencyKey(payload), trackingID); dup {
+			h.logger.Info("repeated webhook ignored",
+				zap.String("event", payload.Event),
+				zap.String("idempotency_key", payload.IdempotencyKey),
+				zap.String("tracking_id", prevID),
+			)
+			writeJSONResponse(w, http.StatusAccepted, Response{
+				Success:       true,
+				Message:       "Duplicate event ignored",
+				ID:            prevID,
+				SchemaVersion: version,
+			})
+			return
+		}
+	}
+
+	// Collapse a repeat of a recent event into it, before it uses quota
+	key := debounceKey{event: payload.Event, domain: payload.FullDomain(), pkg: payload.Package}
+	if prevID, dup := h.debounce.claim(key, trackingID); dup {
+		h.releaseIdempotency(payload, trackingID)
+		h.logger.Info("duplicate webhook ignored",
+			zap.String("event", payload.Event),
+			zap.String("domain", payload.FullDomain()),
+			zap.String("tracking_id", prevID),
+		)
+		writeJSONResponse(w, http.StatusAccepted, Response{
+			Success:       true,
+			Message:       "Duplicate event ignored",
+			ID:            prevID,
+			SchemaVersion: version,
+		})
+		return
+	}
+
+	// Enforce provisioning quotas before accepting the job
+	if h.quota != nil && isProvisioningEvent(payload.Event) {
+		if err := h.quota.Consume(payload.QuotaOwner()); err != nil {
+			var exceeded *quota.ExceededError
+			if errors.As(err, &exceeded) {
+				h.release(key, payload, trackingID)
+				h.logger.Warn("webhook rejected, quota exceeded",
+					zap.String("event", payload.Event),
+					zap.String("owner", payload.QuotaOwner()),
+					zap.String("scope", exceeded.Scope),
+				)
+				retryAfter := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))
+				if retryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				}
+				writeJSONResponse(w, http.StatusTooManyRequests, QuotaErrorResponse{
+					Error:   "quota exceeded",
+					Details: exceeded.Error(),
+					Owner:   exceeded.Owner,
+					Scope:   exceeded.Scope,
+					Limit:   exceeded.Limit,
+					Used:    exceeded.Used,
+					ResetAt: exceeded.ResetAt,
+				})
+				return
+			}
+			// Failing to persist counters should not block provisioning
+			h.logger.Warn("quota check failed, accepting webhook", zap.Error(err))
+		}
+	}
+
+	// Record the package before provisioning starts so the profile applies
+	if payload.Package != "" && isProvisioningEvent(payload.Event) {
+		if assigner, ok := h.provisioner.(PackageAssigner); ok {
+			if err := assigner.AssignPackage(payload.FullDomain(), payload.Package); err != nil {
+				h.logger.Warn("failed to record package",
+					zap.String("domain", payload.FullDomain()),
+					zap.String("package", payload.Package),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+
+	// Record the reseller before provisioning starts so the zone gets its
+	// SOA email
+	if payload.Reseller != "" && isProvisioningEvent(payload.Event) {
+		if assigner, ok := h.provisioner.(ResellerAssigner); ok {
+			if err := assigner.AssignReseller(payload.FullDomain(), payload.Reseller); err != nil {
+				h.logger.Warn("failed to record reseller",
+					zap.String("domain", payload.FullDomain()),
+					zap.String("reseller", payload.Reseller),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+
+	// Options become the overrides the pull zone is created with
+	if payload.Options != nil && h.overrides != nil && isProvisioningEvent(payload.Event) {
+		options := *payload.Options
+		err := h.overrides.Update(payload.FullDomain(), func(o *overrides.Override) {
+			*o = options
+		})
+		if err != nil {
+			h.logger.Warn("failed to record options",
+				zap.String("domain", payload.FullDomain()),
+				zap.Error(err),
+			)
+		}
+	}
+
 	// Route to appropriate handler based on event type
+	var handle func(WebhookPayload, string)
 	switch payload.Event {
 	case eventAccountCreated, eventAddonCreated:
-		go h.handleProvision(payload, trackingID)
+		handle = h.handleProvision
 	case eventSubdomainCreated:
-		go h.handleSubdomainProvision(payload, trackingID)
+		handle = h.handleSubdomainProvision
 	case eventAccountDeleted:
-		go h.handleDeprovision(payload, trackingID)
+		handle = h.handleDeprovision
+	case eventAddonDeleted:
+		handle = h.handleAddonDeprovision
+	case eventSubdomainDeleted:
+		handle = h.handleSubdomainDeprovision
+	case eventPackageChanged:
+		if _, ok := h.provisioner.(PackageChanger); !ok {
+			h.release(key, payload, trackingID)
+			writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "unsupported event",
+				Details: fmt.Sprintf("event type '%s' is not supported by this provisioner", payload.Event),
+			})
+			return
+		}
+		handle = h.handlePackageChange
 	default:
+		h.release(key, payload, trackingID)
 		h.logger.Warn("unknown event type", zap.String("event", payload.Event))
 		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{
 			Error:   "unknown event",
@@ -147,18 +545,144 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The event supersedes the opposite events still debounced, as the
+	// queue supersedes the ones still waiting
+	h.debounce.releaseOpposing(key)
+
+	// Events for the same domain run in arrival order
+	position := h.queue.submit(payload.FullDomain(), &queuedEvent{
+		payload:    payload,
+		trackingID: trackingID,
+		run: func() {
+			if h.runLog != nil {
+				defer h.runLog.Begin(payload.FullDomain(), trackingID)()
+			}
+			handle(payload, trackingID)
+		},
+	})
+	queue := h.queuePosition(position)
+	if queue != nil && queue.EstimatedStart != nil {
+		w.Header().Set(estimatedWaitHeader, strconv.Itoa(queue.EstimatedWaitSeconds))
+	}
+
 	// Return 202 Accepted for async processing
 	h.logger.Info("webhook accepted",
 		zap.String("event", payload.Event),
 		zap.String("tracking_id", trackingID),
 		zap.String("domain", payload.Domain),
+		zap.Int("schema_version", version),
+		zap.Int("queue_position", position),
 	)
 
+	message := "Processing started"
+	if position > 0 {
+		message = "Queued"
+	}
 	writeJSONResponse(w, http.StatusAccepted, Response{
-		Success: true,
-		Message: "Processing started",
-		ID:      trackingID,
+		Success:       true,
+		Message:       message,
+		ID:            trackingID,
+		SchemaVersion: version,
+		Queue:         queue,
+	})
+}
+
+// queuePosition describes the wait of an event at position in the
+// backlog, or returns nil while the backlog is within the threshold
+func (h *Handler) queuePosition(position int) *QueuePosition {
+	if h.backlogThreshold <= 0 || position <= h.backlogThreshold {
+		return nil
+	}
+
+	queue := &QueuePosition{
+		Position: position,
+		Depth:    h.queue.queued(),
+		Paused:   h.queue.isPaused(),
+	}
+	if wait, ok := h.queue.estimateWait(position); ok {
+		start := h.queue.clock.Now().Add(wait).UTC().Truncate(time.Second)
+		queue.EstimatedStart = &start
+		queue.EstimatedWaitSeconds = int(math.Ceil(wait.Seconds()))
+	}
+	return queue
+}
+
+// release forgets the debounce and idempotency claims of an event rejected
+// after all, so a retry is not taken for a repeat
+func (h *Handler) release(key debounceKey, payload WebhookPayload, trackingID string) {
+	h.debounce.release(key, trackingID)
+	h.releaseIdempotency(payload, trackingID)
+}
+
+// releaseIdempotency forgets the idempotency claim of an event
+func (h *Handler) releaseIdempotency(payload WebhookPayload, trackingID string) {
+	if payload.IdempotencyKey != "" {
+		h.idempotency.release(idempotencyKey(payload), trackingID)
+	}
+}
+
+// idempotencyKey is the claim of a payload's idempotency key. The event is
+// part of it, so a hook reusing a key for another event is not ignored
+func idempotencyKey(payload WebhookPayload) debounceKey {
+	return debounceKey{event: payload.Event, idempotencyKey: payload.IdempotencyKey}
+}
+
+// recordCancelled logs and audits a queued event dropped because a newer
+// event for the same domain does the opposite
+func (h *Handler) recordCancelled(dropped, by *queuedEvent) {
+	domain := dropped.payload.FullDomain()
+	h.logger.Info("queued event cancelled by newer event",
+		zap.String("domain", domain),
+		zap.String("event", dropped.payload.Event),
+		zap.String("tracking_id", dropped.trackingID),
+		zap.String("cancelled_by", by.trackingID),
+	)
+
+	if h.audit == nil {
+		return
+	}
+	err := h.audit.Record(audit.Entry{
+		Actor:  "webhook",
+		Action: "webhook.cancelled",
+		Domain: domain,
+		Details: map[string]string{
+			"event":              dropped.payload.Event,
+			"tracking_id":        dropped.trackingID,
+			"cancelled_by":       by.trackingID,
+			"cancelled_by_event": by.payload.Event,
+		},
 	})
+	if err != nil {
+		h.logger.Error("failed to write audit entry",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordFrozen logs and audits an event ignored because its domain is frozen
+func (h *Handler) recordFrozen(payload WebhookPayload) {
+	domain := payload.FullDomain()
+	h.logger.Warn("webhook ignored, domain frozen",
+		zap.String("event", payload.Event),
+		zap.String("domain", domain),
+	)
+
+	if h.audit == nil {
+		return
+	}
+	err := h.audit.Record(audit.Entry{
+		Actor:   "webhook",
+		Action:  "webhook.frozen",
+		Domain:  domain,
+		Details: map[string]string{"event": payload.Event, "user": payload.User},
+	})
+	if err != nil {
+		h.logger.Error("failed to write audit entry",
+			zap.String("domain", domain),
+			zap.Error(err),
+		)
+	}
 }
 
 // verifySignature computes HMAC-SHA256 of payload and compares with provided signature
@@ -231,9 +755,17 @@ func (h *Handler) handleDeprovision(payload WebhookPayload, trackingID string) {
 	h.logger.Info("deprovisioning domain",
 		zap.String("tracking_id", trackingID),
 		zap.String("domain", payload.Domain),
+		zap.String("user", payload.User),
 	)
 
-	if err := h.provisioner.Deprovision(payload.Domain); err != nil {
+	var err error
+	if remover, ok := h.provisioner.(UserDeprovisioner); ok && payload.User != "" {
+		// Addon domains and subdomains go with the account
+		err = remover.DeprovisionUser(payload.User, payload.Domain)
+	} else {
+		err = h.provisioner.Deprovision(payload.Domain)
+	}
+	if err != nil {
 		h.logger.Error("deprovisioning failed",
 			zap.String("tracking_id", trackingID),
 			zap.String("domain", payload.Domain),
@@ -248,6 +780,103 @@ func (h *Handler) handleDeprovision(payload WebhookPayload, trackingID string) {
 	)
 }
 
+// handleAddonDeprovision removes a single addon domain asynchronously
+func (h *Handler) handleAddonDeprovision(payload WebhookPayload, trackingID string) {
+	h.logger.Info("deprovisioning addon domain",
+		zap.String("tracking_id", trackingID),
+		zap.String("domain", payload.Domain),
+		zap.String("user", payload.User),
+	)
+
+	if err := h.provisioner.Deprovision(payload.Domain); err != nil {
+		h.logger.Error("addon domain deprovisioning failed",
+			zap.String("tracking_id", trackingID),
+			zap.String("domain", payload.Domain),
+			zap.Error(err),
+		)
+		return
+	}
+
+	h.logger.Info("addon domain deprovisioning completed",
+		zap.String("tracking_id", trackingID),
+		zap.String("domain", payload.Domain),
+	)
+}
+
+// handleSubdomainDeprovision removes a subdomain asynchronously
+func (h *Handler) handleSubdomainDeprovision(payload WebhookPayload, trackingID string) {
+	h.logger.Info("deprovisioning subdomain",
+		zap.String("tracking_id", trackingID),
+		zap.String("subdomain", payload.Subdomain),
+		zap.String("parent_domain", payload.ParentDomain),
+		zap.String("user", payload.User),
+	)
+
+	if err := h.provisioner.RemoveSubdomain(payload.Subdomain, payload.ParentDomain); err != nil {
+		h.logger.Error("subdomain deprovisioning failed",
+			zap.String("tracking_id", trackingID),
+			zap.String("subdomain", payload.Subdomain),
+			zap.String("parent_domain", payload.ParentDomain),
+			zap.Error(err),
+		)
+		return
+	}
+
+	h.logger.Info("subdomain deprovisioning completed",
+		zap.String("tracking_id", trackingID),
+		zap.String("subdomain", payload.Subdomain),
+		zap.String("parent_domain", payload.ParentDomain),
+	)
+}
+
+// handlePackageChange re-applies the CDN profile of a user's domains
+// asynchronously
+func (h *Handler) handlePackageChange(payload WebhookPayload, trackingID string) {
+	h.logger.Info("changing package",
+		zap.String("tracking_id", trackingID),
+		zap.String("domain", payload.Domain),
+		zap.String("user", payload.User),
+		zap.String("package", payload.Package),
+	)
+
+	changer := h.provisioner.(PackageChanger)
+	if err := changer.ChangePackage(payload.User, payload.Domain, payload.Package); err != nil {
+		h.logger.Error("package change failed",
+			zap.String("tracking_id", trackingID),
+			zap.String("domain", payload.Domain),
+			zap.String("package", payload.Package),
+			zap.Error(err),
+		)
+		return
+	}
+
+	h.logger.Info("package change completed",
+		zap.String("tracking_id", trackingID),
+		zap.String("domain", payload.Domain),
+		zap.String("package", payload.Package),
+	)
+}
+
+// isProvisioningEvent reports whether an event creates new Bunny resources
+func isProvisioningEvent(event string) bool {
+	switch event {
+	case eventAccountCreated, eventAddonCreated, eventSubdomainCreated:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDeprovisioningEvent reports whether an event removes Bunny resources
+func isDeprovisioningEvent(event string) bool {
+	switch event {
+	case eventAccountDeleted, eventAddonDeleted, eventSubdomainDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
 // validatePayload validates the webhook payload based on event type
 func validatePayload(payload *WebhookPayload) error {
 	// User is always required
@@ -256,22 +885,29 @@ func validatePayload(payload *WebhookPayload) error {
 	}
 
 	switch payload.Event {
-	case eventAccountCreated, eventAddonCreated, eventAccountDeleted:
+	case eventAccountCreated, eventAddonCreated, eventAccountDeleted, eventAddonDeleted:
 		if payload.Domain == "" {
 			return fmt.Errorf("domain is required for event '%s'", payload.Event)
 		}
-	case eventSubdomainCreated:
+	case eventSubdomainCreated, eventSubdomainDeleted:
 		if payload.Subdomain == "" {
 			return fmt.Errorf("subdomain is required for event '%s'", payload.Event)
 		}
 		if payload.ParentDomain == "" {
 			return fmt.Errorf("parent_domain is required for event '%s'", payload.Event)
 		}
+	case eventPackageChanged:
+		if payload.Domain == "" {
+			return fmt.Errorf("domain is required for event '%s'", payload.Event)
+		}
+		if payload.Package == "" {
+			return fmt.Errorf("package is required for event '%s'", payload.Event)
+		}
 	default:
 		return fmt.Errorf("unknown event type: '%s'", payload.Event)
 	}
 
-	return nil
+	return payload.ValidateVersion()
 }
 
 // writeJSONResponse writes a JSON response with the given status code
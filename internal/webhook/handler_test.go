@@ -6,13 +6,22 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/quota"
 )
 
 func TestVerifySignature(t *testing.T) {
@@ -213,6 +222,65 @@ func TestServeHTTP(t *testing.T) {
 		assert.Equal(t, "example.com", mockProv.LastDeprovisionDomain)
 	})
 
+	t.Run("valid addon_deleted request", func(t *testing.T) {
+		mockProv := &MockProvisioner{done: make(chan struct{})}
+		handler := NewHandler(mockProv, secret, logger)
+		payload := WebhookPayload{Event: "addon_deleted", Domain: "addon.com", User: "testuser"}
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+
+		// Calculate valid signature
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(body)
+		signature := hex.EncodeToString(h.Sum(nil))
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Whm2bunny-Signature", signature)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		// Wait for async deprovisioning to complete
+		<-mockProv.done
+		assert.True(t, mockProv.DeprovisionCalled)
+		assert.Equal(t, "addon.com", mockProv.LastDeprovisionDomain)
+	})
+
+	t.Run("valid subdomain_deleted request", func(t *testing.T) {
+		mockProv := &MockProvisioner{done: make(chan struct{})}
+		handler := NewHandler(mockProv, secret, logger)
+		payload := WebhookPayload{
+			Event:        "subdomain_deleted",
+			Subdomain:    "blog",
+			ParentDomain: "example.com",
+			User:         "testuser",
+		}
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+
+		// Calculate valid signature
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(body)
+		signature := hex.EncodeToString(h.Sum(nil))
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Whm2bunny-Signature", signature)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		// Wait for async deprovisioning to complete
+		<-mockProv.done
+		assert.True(t, mockProv.RemoveSubdomainCalled)
+		assert.False(t, mockProv.DeprovisionCalled)
+		assert.Equal(t, "blog", mockProv.LastSubdomain)
+		assert.Equal(t, "example.com", mockProv.LastParentDomain)
+	})
+
 	t.Run("unknown event should return 400", func(t *testing.T) {
 		handler := NewHandler(nil, secret, logger)
 		payload := WebhookPayload{Event: "unknown_event", Domain: "example.com", User: "testuser"}
@@ -275,6 +343,53 @@ func TestValidatePayload(t *testing.T) {
 		err := validatePayload(&payload)
 		assert.Error(t, err)
 	})
+
+	t.Run("missing domain for addon_deleted", func(t *testing.T) {
+		payload := WebhookPayload{Event: "addon_deleted", User: "testuser"}
+		err := validatePayload(&payload)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing subdomain for subdomain_deleted", func(t *testing.T) {
+		payload := WebhookPayload{Event: "subdomain_deleted", ParentDomain: "example.com", User: "testuser"}
+		err := validatePayload(&payload)
+		assert.Error(t, err)
+	})
+
+	t.Run("schema versions", func(t *testing.T) {
+		v2 := func(modify func(p *WebhookPayload)) error {
+			payload := WebhookPayload{
+				SchemaVersion:  SchemaV2,
+				Event:          "account_created",
+				Domain:         "example.com",
+				User:           "testuser",
+				IdempotencyKey: "6f1c2a9e-1b2c-4d5e-8f90-a1b2c3d4e5f6",
+			}
+			if modify != nil {
+				modify(&payload)
+			}
+			return validatePayload(&payload)
+		}
+
+		assert.NoError(t, v2(nil))
+		assert.NoError(t, v2(func(p *WebhookPayload) { p.Options = &overrides.Override{OriginProtocol: "https"} }))
+		assert.NoError(t, v2(func(p *WebhookPayload) { p.SchemaVersion = 3 }), "newer versions follow v2")
+		assert.Error(t, v2(func(p *WebhookPayload) { p.IdempotencyKey = "" }))
+		assert.Error(t, v2(func(p *WebhookPayload) { p.IdempotencyKey = "not a key" }))
+		assert.Error(t, v2(func(p *WebhookPayload) { p.Options = &overrides.Override{OriginProtocol: "ftp"} }))
+		assert.Error(t, v2(func(p *WebhookPayload) {
+			p.Event = "account_deleted"
+			p.Options = &overrides.Override{Optimizer: overrides.Bool(true)}
+		}))
+		assert.Error(t, v2(func(p *WebhookPayload) { p.SchemaVersion = -1 }))
+
+		// v1 payloads cannot carry the v2 fields
+		assert.Error(t, v2(func(p *WebhookPayload) { p.SchemaVersion = 0 }))
+		assert.Error(t, v2(func(p *WebhookPayload) {
+			p.SchemaVersion, p.IdempotencyKey = SchemaV1, ""
+			p.Options = &overrides.Override{}
+		}))
+	})
 }
 
 // MockProvisioner is a mock implementation for testing
@@ -282,6 +397,7 @@ type MockProvisioner struct {
 	ProvisionCalled          bool
 	ProvisionSubdomainCalled bool
 	DeprovisionCalled        bool
+	RemoveSubdomainCalled    bool
 	LastDomain               string
 	LastSubdomain            string
 	LastParentDomain         string
@@ -320,6 +436,16 @@ func (m *MockProvisioner) Deprovision(domain string) error {
 	return nil
 }
 
+func (m *MockProvisioner) RemoveSubdomain(subdomain, parentDomain string) error {
+	m.RemoveSubdomainCalled = true
+	m.LastSubdomain = subdomain
+	m.LastParentDomain = parentDomain
+	if m.done != nil {
+		close(m.done)
+	}
+	return nil
+}
+
 func TestHandlerWriteResponse(t *testing.T) {
 	t.Run("success response", func(t *testing.T) {
 		w := httptest.NewRecorder()
@@ -368,3 +494,593 @@ func TestHMACIntegration(t *testing.T) {
 	result := handler.verifySignature(payload, expectedSig)
 	assert.True(t, result, "HMAC signature should verify correctly")
 }
+
+func TestServeHTTP_Quota(t *testing.T) {
+	secret := "test-secret"
+
+	sign := func(body []byte) string {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(body)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	newQuota := func(t *testing.T) *quota.Manager {
+		q, err := quota.NewManager(filepath.Join(t.TempDir(), "quota.json"), config.QuotaConfig{
+			Enabled:  true,
+			Timezone: "UTC",
+			PerUser:  config.QuotaLimits{Daily: 1},
+		}, zap.NewNop())
+		require.NoError(t, err)
+		return q
+	}
+
+	t.Run("rejects provisioning over quota with 429", func(t *testing.T) {
+		mockProv := &MockProvisioner{done: make(chan struct{})}
+		handler := NewHandler(mockProv, secret, zap.NewNop())
+		handler.SetQuota(newQuota(t))
+
+		send := func(domain string) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(WebhookPayload{Event: "account_created", Domain: domain, User: "alice", Reseller: "res1"})
+			req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+			req.Header.Set("X-Whm2bunny-Signature", sign(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		assert.Equal(t, http.StatusAccepted, send("one.com").Code)
+		<-mockProv.done
+
+		w := send("two.com")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		var resp QuotaErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "quota exceeded", resp.Error)
+		assert.Equal(t, "res1", resp.Owner)
+		assert.Equal(t, quota.ScopeDaily, resp.Scope)
+		assert.Equal(t, 1, resp.Limit)
+		assert.Equal(t, 1, resp.Used)
+	})
+
+	t.Run("deprovisioning is not counted", func(t *testing.T) {
+		q := newQuota(t)
+		require.NoError(t, q.Consume("alice"))
+
+		mockProv := &MockProvisioner{done: make(chan struct{})}
+		handler := NewHandler(mockProv, secret, zap.NewNop())
+		handler.SetQuota(q)
+
+		body, _ := json.Marshal(WebhookPayload{Event: "account_deleted", Domain: "one.com", User: "alice"})
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", sign(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		<-mockProv.done
+	})
+}
+
+// packageProvisioner records package and reseller assignments in addition
+// to MockProvisioner
+type packageProvisioner struct {
+	MockProvisioner
+	assigned  map[string]string
+	resellers map[string]string
+}
+
+func (p *packageProvisioner) AssignPackage(domain, pkg string) error {
+	p.assigned[domain] = pkg
+	return nil
+}
+
+func (p *packageProvisioner) AssignReseller(domain, reseller string) error {
+	p.resellers[domain] = reseller
+	return nil
+}
+
+func TestServeHTTP_AssignsPackage(t *testing.T) {
+	secret := "test-secret"
+	prov := &packageProvisioner{
+		MockProvisioner: MockProvisioner{done: make(chan struct{})},
+		assigned:        make(map[string]string),
+		resellers:       make(map[string]string),
+	}
+	handler := NewHandler(prov, secret, zap.NewNop())
+
+	body, _ := json.Marshal(WebhookPayload{
+		Event:        "subdomain_created",
+		Subdomain:    "blog",
+		ParentDomain: "example.com",
+		User:         "testuser",
+		Reseller:     "acme",
+		Package:      "gold",
+	})
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	<-prov.done
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "gold", prov.assigned["blog.example.com"])
+	assert.Equal(t, "acme", prov.resellers["blog.example.com"])
+}
+
+// userProvisioner removes whole accounts in addition to MockProvisioner
+type userProvisioner struct {
+	MockProvisioner
+	removedUser string
+}
+
+func (p *userProvisioner) DeprovisionUser(user, domain string) error {
+	p.removedUser = user
+	return p.Deprovision(domain)
+}
+
+func TestServeHTTP_DeprovisionsUser(t *testing.T) {
+	secret := "test-secret"
+	prov := &userProvisioner{MockProvisioner: MockProvisioner{done: make(chan struct{})}}
+	handler := NewHandler(prov, secret, zap.NewNop())
+
+	body, _ := json.Marshal(WebhookPayload{Event: "account_deleted", Domain: "example.com", User: "alice"})
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	<-prov.done
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "alice", prov.removedUser)
+	assert.Equal(t, "example.com", prov.LastDeprovisionDomain)
+}
+
+// packageChanger changes packages in addition to MockProvisioner
+type packageChanger struct {
+	MockProvisioner
+	user, domain, pkg string
+}
+
+func (p *packageChanger) ChangePackage(user, domain, pkg string) error {
+	p.user, p.domain, p.pkg = user, domain, pkg
+	close(p.done)
+	return nil
+}
+
+func TestServeHTTP_ChangesPackage(t *testing.T) {
+	secret := "test-secret"
+	send := func(handler *Handler, payload WebhookPayload) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	payload := WebhookPayload{Event: "package_changed", Domain: "example.com", User: "alice", Package: "gold"}
+
+	prov := &packageChanger{MockProvisioner: MockProvisioner{done: make(chan struct{})}}
+	w := send(NewHandler(prov, secret, zap.NewNop()), payload)
+	<-prov.done
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "alice", prov.user)
+	assert.Equal(t, "example.com", prov.domain)
+	assert.Equal(t, "gold", prov.pkg)
+
+	// A provisioner that cannot change packages rejects the event
+	w = send(NewHandler(&MockProvisioner{}, secret, zap.NewNop()), payload)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	payload.Package = ""
+	w = send(NewHandler(prov, secret, zap.NewNop()), payload)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// countingProvisioner reports each provisioned domain on calls
+type countingProvisioner struct {
+	MockProvisioner
+	calls chan string
+}
+
+func (p *countingProvisioner) Provision(domain, user string) error {
+	p.calls <- domain
+	return nil
+}
+
+func TestServeHTTP_Debounce(t *testing.T) {
+	secret := "test-secret"
+	prov := &countingProvisioner{calls: make(chan string, 10)}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	handler := NewHandler(prov, secret, zap.NewNop())
+	handler.SetDebounce(10 * time.Second)
+	handler.SetClock(clk)
+
+	send := func(payload WebhookPayload) Response {
+		body, _ := json.Marshal(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	payload := WebhookPayload{Event: "addon_created", Domain: "example.com", User: "alice"}
+
+	first := send(payload)
+	assert.Equal(t, "example.com", <-prov.calls)
+
+	// A repeat within the window is collapsed into the first event
+	clk.Advance(5 * time.Second)
+	dup := send(payload)
+	assert.Equal(t, first.ID, dup.ID)
+	assert.Equal(t, "Duplicate event ignored", dup.Message)
+
+	// Another domain is not a repeat
+	send(WebhookPayload{Event: "addon_created", Domain: "other.com", User: "alice"})
+	assert.Equal(t, "other.com", <-prov.calls)
+
+	// Once the window has passed the event runs again
+	clk.Advance(5 * time.Second)
+	again := send(payload)
+	assert.NotEqual(t, first.ID, again.ID)
+	assert.Equal(t, "example.com", <-prov.calls)
+
+	select {
+	case domain := <-prov.calls:
+		t.Fatalf("unexpected provision of %s", domain)
+	default:
+	}
+}
+
+// sequenceProvisioner is a countingProvisioner that also reports each
+// deprovisioned domain on calls, prefixed with "-"
+type sequenceProvisioner struct {
+	countingProvisioner
+}
+
+func (p *sequenceProvisioner) Deprovision(domain string) error {
+	p.calls <- "-" + domain
+	return nil
+}
+
+func TestServeHTTP_DebounceSupersede(t *testing.T) {
+	secret := "test-secret"
+	prov := &sequenceProvisioner{countingProvisioner{calls: make(chan string, 10)}}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	handler := NewHandler(prov, secret, zap.NewNop())
+	handler.SetDebounce(10 * time.Second)
+	handler.SetClock(clk)
+
+	send := func(payload WebhookPayload) Response {
+		body, _ := json.Marshal(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	next := func() string {
+		select {
+		case call := <-prov.calls:
+			return call
+		case <-time.After(time.Second):
+			t.Fatal("expected a call")
+			return ""
+		}
+	}
+	created := WebhookPayload{Event: "addon_created", Domain: "example.com", User: "alice"}
+	deleted := WebhookPayload{Event: "addon_deleted", Domain: "example.com", User: "alice"}
+
+	first := send(created)
+	assert.Equal(t, "example.com", next())
+
+	clk.Advance(2 * time.Second)
+	send(deleted)
+	assert.Equal(t, "-example.com", next())
+
+	// The create after the delete is not a repeat of the first create
+	clk.Advance(2 * time.Second)
+	last := send(created)
+	assert.NotEqual(t, "Duplicate event ignored", last.Message)
+	assert.NotEqual(t, first.ID, last.ID)
+	assert.Equal(t, "example.com", next())
+
+	// A repeat of the last create is still collapsed into it
+	dup := send(created)
+	assert.Equal(t, last.ID, dup.ID)
+	assert.Equal(t, "Duplicate event ignored", dup.Message)
+
+	select {
+	case call := <-prov.calls:
+		t.Fatalf("unexpected call %s", call)
+	default:
+	}
+}
+
+// freezingProvisioner is a countingProvisioner with frozen domains
+type freezingProvisioner struct {
+	countingProvisioner
+	frozen map[string]bool
+}
+
+func (p *freezingProvisioner) IsFrozen(domain string) bool {
+	return p.frozen[domain]
+}
+
+func TestServeHTTP_FrozenDomain(t *testing.T) {
+	secret := "test-secret"
+	prov := &freezingProvisioner{
+		countingProvisioner: countingProvisioner{calls: make(chan string, 10)},
+		frozen:              map[string]bool{"frozen.com": true},
+	}
+	handler := NewHandler(prov, secret, zap.NewNop())
+
+	send := func(payload WebhookPayload) Response {
+		body, _ := json.Marshal(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := send(WebhookPayload{Event: "addon_created", Domain: "frozen.com", User: "alice"})
+	assert.Equal(t, "Domain frozen, event ignored", resp.Message)
+	assert.Empty(t, resp.ID)
+
+	send(WebhookPayload{Event: "addon_created", Domain: "other.com", User: "alice"})
+	assert.Equal(t, "other.com", <-prov.calls)
+
+	select {
+	case domain := <-prov.calls:
+		t.Fatalf("unexpected provision of %s", domain)
+	default:
+	}
+}
+
+// fakeOverrides records overrides in memory
+type fakeOverrides map[string]overrides.Override
+
+func (f fakeOverrides) Update(domain string, fn func(o *overrides.Override)) error {
+	o := f[domain]
+	fn(&o)
+	f[domain] = o
+	return nil
+}
+
+func TestServeHTTP_SchemaV2(t *testing.T) {
+	secret := "test-secret"
+	prov := &countingProvisioner{calls: make(chan string, 10)}
+	stored := fakeOverrides{}
+	handler := NewHandler(prov, secret, zap.NewNop())
+	handler.SetOverrides(stored)
+
+	send := func(payload WebhookPayload) Response {
+		body, _ := json.Marshal(payload)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	payload := WebhookPayload{
+		SchemaVersion:  SchemaV2,
+		Event:          "addon_created",
+		Domain:         "example.com",
+		User:           "alice",
+		IdempotencyKey: "req-1",
+		Options:        &overrides.Override{OriginProtocol: "https", Optimizer: overrides.Bool(true)},
+	}
+
+	first := send(payload)
+	assert.Equal(t, SchemaV2, first.SchemaVersion)
+	assert.Equal(t, "example.com", <-prov.calls)
+	assert.Equal(t, "https", stored["example.com"].OriginProtocol)
+
+	// A retry with the same key is answered with the first event
+	retried := send(payload)
+	assert.Equal(t, first.ID, retried.ID)
+	assert.Equal(t, "Duplicate event ignored", retried.Message)
+
+	// An unknown newer version is handled as the latest one
+	payload.SchemaVersion = 9
+	payload.IdempotencyKey = "req-2"
+	newer := send(payload)
+	assert.Equal(t, LatestSchemaVersion, newer.SchemaVersion)
+	assert.Equal(t, "example.com", <-prov.calls)
+
+	// v1 payloads are still accepted
+	v1 := send(WebhookPayload{Event: "addon_created", Domain: "other.com", User: "alice"})
+	assert.Equal(t, SchemaV1, v1.SchemaVersion)
+	assert.Equal(t, "other.com", <-prov.calls)
+
+	select {
+	case domain := <-prov.calls:
+		t.Fatalf("unexpected provision of %s", domain)
+	default:
+	}
+}
+
+func TestServeHTTP_BodyLimit(t *testing.T) {
+	secret := "test-secret"
+	prov := &countingProvisioner{calls: make(chan string, 10)}
+	handler := NewHandler(prov, secret, zap.NewNop())
+	handler.SetMaxBodyBytes(128)
+
+	sign := func(req *http.Request, body []byte) {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	body, _ := json.Marshal(WebhookPayload{Event: "addon_created", Domain: "example.com", User: "alice"})
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	sign(req, body)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "example.com", <-prov.calls)
+
+	// A declared length over the limit is refused without reading the body
+	large, _ := json.Marshal(WebhookPayload{Event: "addon_created", Domain: "example.com", User: strings.Repeat("a", 200)})
+	req = httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(large))
+	sign(req, large)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// A chunked body of unknown length is cut off at the limit
+	req = httptest.NewRequest(http.MethodPost, "/hook", io.MultiReader(bytes.NewReader(large)))
+	req.ContentLength = -1
+	sign(req, large)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// An unsigned request is counted as unauthorized
+	req = httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.Equal(t, Stats{Received: 4, TooLarge: 2, Unauthorized: 1}, handler.Stats())
+
+	select {
+	case domain := <-prov.calls:
+		t.Fatalf("unexpected provision of %s", domain)
+	default:
+	}
+}
+
+// blockingProvisioner provisions once release is closed
+type blockingProvisioner struct {
+	MockProvisioner
+	started chan string
+	release chan struct{}
+}
+
+func (p *blockingProvisioner) Provision(domain, user string) error {
+	p.started <- domain
+	<-p.release
+	return nil
+}
+
+func TestServeHTTP_QueuePosition(t *testing.T) {
+	secret := "test-secret"
+	send := func(handler *Handler, user string) (Response, http.Header) {
+		body, _ := json.Marshal(WebhookPayload{Event: "addon_created", Domain: "example.com", User: user})
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp, w.Header()
+	}
+
+	t.Run("estimated from throughput", func(t *testing.T) {
+		prov := &blockingProvisioner{started: make(chan string, 10), release: make(chan struct{})}
+		defer close(prov.release)
+		clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+		handler := NewHandler(prov, secret, zap.NewNop())
+		handler.SetClock(clk)
+		handler.SetBacklogThreshold(2)
+
+		// Events finished a minute apart before the backlog formed
+		for range 3 {
+			handler.queue.finish()
+			clk.Advance(time.Minute)
+		}
+
+		resp, _ := send(handler, "alice")
+		assert.Equal(t, "Processing started", resp.Message)
+		assert.Nil(t, resp.Queue)
+		<-prov.started
+
+		for _, user := range []string{"bob", "carol"} {
+			resp, header := send(handler, user)
+			assert.Equal(t, "Queued", resp.Message)
+			assert.Nil(t, resp.Queue, "within the threshold")
+			assert.Empty(t, header.Get("X-Whm2bunny-Estimated-Wait"))
+		}
+
+		resp, header := send(handler, "dave")
+		require.NotNil(t, resp.Queue)
+		assert.Equal(t, 3, resp.Queue.Position)
+		assert.Equal(t, 3, resp.Queue.Depth)
+		assert.False(t, resp.Queue.Paused)
+		assert.Equal(t, 180, resp.Queue.EstimatedWaitSeconds)
+		require.NotNil(t, resp.Queue.EstimatedStart)
+		assert.Equal(t, clk.Now().Add(3*time.Minute), *resp.Queue.EstimatedStart)
+		assert.Equal(t, "180", header.Get("X-Whm2bunny-Estimated-Wait"))
+	})
+
+	t.Run("paused", func(t *testing.T) {
+		handler := NewHandler(&countingProvisioner{calls: make(chan string, 10)}, secret, zap.NewNop())
+		handler.SetBacklogThreshold(1)
+		handler.SetPause(fakePauser(true))
+
+		send(handler, "alice")
+		resp, header := send(handler, "bob")
+		require.NotNil(t, resp.Queue)
+		assert.Equal(t, 2, resp.Queue.Position)
+		assert.True(t, resp.Queue.Paused)
+		assert.Nil(t, resp.Queue.EstimatedStart)
+		assert.Empty(t, header.Get("X-Whm2bunny-Estimated-Wait"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		handler := NewHandler(&countingProvisioner{calls: make(chan string, 10)}, secret, zap.NewNop())
+		handler.SetPause(fakePauser(true))
+
+		for _, user := range []string{"alice", "bob", "carol"} {
+			resp, _ := send(handler, user)
+			assert.Nil(t, resp.Queue)
+		}
+	})
+}
+
+// fakePauser is a Pauser with a fixed answer
+type fakePauser bool
+
+func (p fakePauser) Paused() bool {
+	return bool(p)
+}
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mordenhost/whm2bunny/internal/clock"
+)
+
+// throughputWindow is how far back finished events count towards the
+// throughput start times are estimated from
+const throughputWindow = 15 * time.Minute
+
+// queuedEvent is an accepted webhook event waiting for its domain
+type queuedEvent struct {
+	payload    WebhookPayload
+	trackingID string
+	run        func()
+}
+
+// opposes reports whether e undoes other, see eventsOppose
+func (e *queuedEvent) opposes(other *queuedEvent) bool {
+	return eventsOppose(e.payload.Event, other.payload.Event)
+}
+
+// eventsOppose reports whether event undoes other, a create undoing a
+// delete or the reverse; other events, such as package changes, oppose
+// nothing
+func eventsOppose(event, other string) bool {
+	return (isProvisioningEvent(event) && isDeprovisioningEvent(other)) ||
+		(isDeprovisioningEvent(event) && isProvisioningEvent(other))
+}
+
+// domainQueue runs events for the same domain one at a time in arrival
+// order, while events for different domains run concurrently. An event
+// cancels the older queued events for its domain that do the opposite, so
+// a subdomain deleted and recreated in quick succession ends up created.
+// While paused reports true, events are queued but none is started; the
+// ones running finish, and resume starts the rest
+type domainQueue struct {
+	mu      sync.Mutex
+	waiting map[string][]*queuedEvent // domain -> events not yet started
+	busy    map[string]bool           // domains with an event running
+	// cancelled is called for each event dropped in favor of a newer one
+	cancelled func(dropped, by *queuedEvent)
+	// paused, when set, holds back the events not yet started
+	paused func() bool
+	// finished holds when each event of the last throughputWindow
+	// finished, oldest first
+	finished []time.Time
+	clock    clock.Clock
+}
+
+// newDomainQueue creates an empty queue
+func newDomainQueue(cancelled func(dropped, by *queuedEvent)) *domainQueue {
+	return &domainQueue{
+		waiting:   make(map[string][]*queuedEvent),
+		busy:      make(map[string]bool),
+		cancelled: cancelled,
+		clock:     clock.Real{},
+	}
+}
+
+// submit queues an event for domain, starting it right away when nothing
+// else for the domain is running. It returns the event's position in the
+// backlog: 0 when it started, otherwise the number of events waiting,
+// itself included
+func (q *domainQueue) submit(domain string, ev *queuedEvent) int {
+	q.mu.Lock()
+
+	var dropped []*queuedEvent
+	kept := q.waiting[domain][:0]
+	for _, older := range q.waiting[domain] {
+		if ev.opposes(older) {
+			dropped = append(dropped, older)
+			continue
+		}
+		kept = append(kept, older)
+	}
+	q.waiting[domain] = append(kept, ev)
+
+	start := !q.busy[domain] && !q.isPaused()
+	position := 0
+	if start {
+		q.busy[domain] = true
+	} else {
+		position = q.waitingLocked()
+	}
+	q.mu.Unlock()
+
+	if q.cancelled != nil {
+		for _, older := range dropped {
+			q.cancelled(older, ev)
+		}
+	}
+	if start {
+		go q.drain(domain)
+	}
+	return position
+}
+
+// isPaused reports whether events must wait for resume
+// Events are started or held back with it read under q.mu, so a resume
+// cannot slip in between reading it and acting on it
+func (q *domainQueue) isPaused() bool {
+	return q.paused != nil && q.paused()
+}
+
+// drain runs the events queued for domain until none are left, or the
+// queue is paused
+func (q *domainQueue) drain(domain string) {
+	for {
+		q.mu.Lock()
+		events := q.waiting[domain]
+		if len(events) == 0 || q.isPaused() {
+			if len(events) == 0 {
+				delete(q.waiting, domain)
+			}
+			delete(q.busy, domain)
+			q.mu.Unlock()
+			return
+		}
+		ev := events[0]
+		q.waiting[domain] = events[1:]
+		q.mu.Unlock()
+
+		ev.run()
+		q.finish()
+	}
+}
+
+// finish records that an event finished, for the throughput
+func (q *domainQueue) finish() {
+	now := q.clock.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finished = append(q.pruneLocked(now), now)
+}
+
+// pruneLocked returns the finish times within throughputWindow of now
+// Caller must hold q.mu
+func (q *domainQueue) pruneLocked(now time.Time) []time.Time {
+	cutoff := now.Add(-throughputWindow)
+	i := 0
+	for i < len(q.finished) && q.finished[i].Before(cutoff) {
+		i++
+	}
+	return q.finished[i:]
+}
+
+// estimateWait returns how long the event at position in the backlog
+// will likely wait, from the rate events finished at over the last
+// throughputWindow. It reports false while paused, or when too few events
+// finished recently to tell
+func (q *domainQueue) estimateWait(position int) (time.Duration, bool) {
+	if position <= 0 || q.isPaused() {
+		return 0, false
+	}
+
+	now := q.clock.Now()
+	q.mu.Lock()
+	q.finished = q.pruneLocked(now)
+	finished := q.finished
+	q.mu.Unlock()
+
+	if len(finished) < 2 {
+		return 0, false
+	}
+	span := finished[len(finished)-1].Sub(finished[0])
+	if span <= 0 {
+		return 0, false
+	}
+	perEvent := span / time.Duration(len(finished)-1)
+	return perEvent * time.Duration(position), true
+}
+
+// resume starts the events held back while the queue was paused
+func (q *domainQueue) resume() {
+	q.mu.Lock()
+	if q.isPaused() {
+		q.mu.Unlock()
+		return
+	}
+	var start []string
+	for domain, events := range q.waiting {
+		if len(events) > 0 && !q.busy[domain] {
+			q.busy[domain] = true
+			start = append(start, domain)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, domain := range start {
+		go q.drain(domain)
+	}
+}
+
+// queued returns the number of events not yet started
+func (q *domainQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waitingLocked()
+}
+
+// waitingLocked returns the number of events not yet started
+// Caller must hold q.mu
+func (q *domainQueue) waitingLocked() int {
+	n := 0
+	for _, events := range q.waiting {
+		n += len(events)
+	}
+	return n
+}
@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+)
+
+// runLog records the order queued events ran in
+type runLog struct {
+	mu      sync.Mutex
+	ran     []string
+	started chan string
+	done    chan string
+}
+
+func newRunLog() *runLog {
+	return &runLog{started: make(chan string, 16), done: make(chan string, 16)}
+}
+
+// event returns a queued event that records id when run, first waiting for
+// release when it is not nil
+func (l *runLog) event(id, event string, release chan struct{}) *queuedEvent {
+	return &queuedEvent{
+		payload:    WebhookPayload{Event: event, Domain: "example.com"},
+		trackingID: id,
+		run: func() {
+			l.started <- id
+			if release != nil {
+				<-release
+			}
+			l.mu.Lock()
+			l.ran = append(l.ran, id)
+			l.mu.Unlock()
+			l.done <- id
+		},
+	}
+}
+
+// running waits until the event id has started
+func (l *runLog) running(t *testing.T, id string) {
+	t.Helper()
+	select {
+	case started := <-l.started:
+		require.Equal(t, id, started)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s to start", id)
+	}
+}
+
+func (l *runLog) wait(t *testing.T, n int) []string {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-l.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i+1)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.ran...)
+}
+
+func TestDomainQueue_SameDomainRunsInOrder(t *testing.T) {
+	log := newRunLog()
+	q := newDomainQueue(nil)
+	release := make(chan struct{})
+
+	q.submit("example.com", log.event("first", eventAccountCreated, release))
+	q.submit("example.com", log.event("second", eventAccountCreated, nil))
+	q.submit("example.com", log.event("third", eventAccountCreated, nil))
+	close(release)
+
+	assert.Equal(t, []string{"first", "second", "third"}, log.wait(t, 3))
+}
+
+func TestDomainQueue_DomainsRunConcurrently(t *testing.T) {
+	log := newRunLog()
+	q := newDomainQueue(nil)
+	release := make(chan struct{})
+	defer close(release)
+
+	q.submit("slow.com", log.event("slow", eventAccountCreated, release))
+	q.submit("fast.com", log.event("fast", eventAccountCreated, nil))
+
+	assert.Equal(t, []string{"fast"}, log.wait(t, 1))
+}
+
+func TestDomainQueue_Pause(t *testing.T) {
+	log := newRunLog()
+	var paused atomic.Bool
+	q := newDomainQueue(nil)
+	q.paused = paused.Load
+	release := make(chan struct{})
+
+	q.submit("example.com", log.event("running", eventAccountCreated, release))
+	log.running(t, "running")
+	paused.Store(true)
+	q.submit("example.com", log.event("held", eventAccountCreated, nil))
+	q.submit("other.com", log.event("other", eventAccountCreated, nil))
+	close(release)
+
+	// The running event finishes, the queued ones wait
+	assert.Equal(t, []string{"running"}, log.wait(t, 1))
+	assert.Equal(t, 2, q.queued())
+	select {
+	case id := <-log.started:
+		t.Fatalf("%s started while paused", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	q.resume()
+	assert.Equal(t, 2, q.queued(), "resume does nothing while still paused")
+
+	paused.Store(false)
+	q.resume()
+	assert.ElementsMatch(t, []string{"running", "held", "other"}, log.wait(t, 2))
+	assert.Equal(t, 0, q.queued())
+}
+
+func TestDomainQueue_PauseResumeRace(t *testing.T) {
+	var paused atomic.Bool
+	checked := make(chan struct{}, 1)
+	q := newDomainQueue(nil)
+	// A pause seen by the queue is reported, and held for a moment so a
+	// resume can slip in before the queue acts on it
+	q.paused = func() bool {
+		if !paused.Load() {
+			return false
+		}
+		select {
+		case checked <- struct{}{}:
+		default:
+		}
+		time.Sleep(time.Millisecond)
+		return true
+	}
+
+	// Each round pauses while an event runs and resumes while the queue
+	// decides whether to start the next one, which must not be left
+	// waiting
+	for i := 0; i < 100; i++ {
+		release := make(chan struct{})
+		done := make(chan struct{})
+		q.submit("example.com", &queuedEvent{run: func() { <-release }})
+		q.submit("example.com", &queuedEvent{run: func() { close(done) }})
+
+		paused.Store(true)
+		close(release)
+		<-checked
+		paused.Store(false)
+		q.resume()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("round %d: the queued event was left waiting after resume", i)
+		}
+	}
+}
+
+func TestDomainQueue_CancelsOppositeQueuedEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued string
+		newer  string
+	}{
+		{"create cancels delete", eventSubdomainDeleted, eventSubdomainCreated},
+		{"delete cancels create", eventSubdomainCreated, eventSubdomainDeleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := newRunLog()
+			var dropped, by []string
+			q := newDomainQueue(func(d, b *queuedEvent) {
+				dropped = append(dropped, d.trackingID)
+				by = append(by, b.trackingID)
+			})
+			release := make(chan struct{})
+
+			q.submit("blog.example.com", log.event("running", eventSubdomainCreated, release))
+			log.running(t, "running")
+			q.submit("blog.example.com", log.event("queued", tt.queued, nil))
+			q.submit("blog.example.com", log.event("newer", tt.newer, nil))
+			close(release)
+
+			assert.Equal(t, []string{"running", "newer"}, log.wait(t, 2))
+			assert.Equal(t, []string{"queued"}, dropped)
+			assert.Equal(t, []string{"newer"}, by)
+		})
+	}
+
+	t.Run("same kind is kept", func(t *testing.T) {
+		log := newRunLog()
+		cancelled := 0
+		q := newDomainQueue(func(d, b *queuedEvent) { cancelled++ })
+		release := make(chan struct{})
+
+		q.submit("example.com", log.event("running", eventAccountCreated, release))
+		q.submit("example.com", log.event("queued", eventAddonCreated, nil))
+		q.submit("example.com", log.event("newer", eventAccountCreated, nil))
+		close(release)
+
+		assert.Equal(t, []string{"running", "queued", "newer"}, log.wait(t, 3))
+		assert.Zero(t, cancelled)
+	})
+
+	t.Run("package changes cancel nothing", func(t *testing.T) {
+		log := newRunLog()
+		cancelled := 0
+		q := newDomainQueue(func(d, b *queuedEvent) { cancelled++ })
+		release := make(chan struct{})
+
+		q.submit("example.com", log.event("running", eventAccountCreated, release))
+		q.submit("example.com", log.event("queued", eventAccountCreated, nil))
+		q.submit("example.com", log.event("package", eventPackageChanged, nil))
+		q.submit("example.com", log.event("newer", eventAccountCreated, nil))
+		close(release)
+
+		assert.Equal(t, []string{"running", "queued", "package", "newer"}, log.wait(t, 4))
+		assert.Zero(t, cancelled)
+	})
+}
+
+// recordingAuditor keeps audit entries in memory
+type recordingAuditor struct {
+	entries []audit.Entry
+}
+
+func (a *recordingAuditor) Record(entry audit.Entry) error {
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+func TestHandler_AuditsCancelledEvents(t *testing.T) {
+	auditor := &recordingAuditor{}
+	h := NewHandler(&MockProvisioner{}, "secret", zap.NewNop())
+	h.SetAudit(auditor)
+
+	h.recordCancelled(
+		&queuedEvent{payload: WebhookPayload{Event: eventSubdomainDeleted, Subdomain: "blog", ParentDomain: "example.com"}, trackingID: "old"},
+		&queuedEvent{payload: WebhookPayload{Event: eventSubdomainCreated, Subdomain: "blog", ParentDomain: "example.com"}, trackingID: "new"},
+	)
+
+	require.Len(t, auditor.entries, 1)
+	entry := auditor.entries[0]
+	assert.Equal(t, "webhook.cancelled", entry.Action)
+	assert.Equal(t, "blog.example.com", entry.Domain)
+	assert.Equal(t, map[string]string{
+		"event":              eventSubdomainDeleted,
+		"tracking_id":        "old",
+		"cancelled_by":       "new",
+		"cancelled_by_event": eventSubdomainCreated,
+	}, entry.Details)
+}
+
+func TestDomainQueue_EstimateWait(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	q := newDomainQueue(nil)
+	q.clock = clk
+
+	_, ok := q.estimateWait(3)
+	assert.False(t, ok, "no throughput yet")
+
+	// Three events finished 30s apart
+	for range 3 {
+		q.finish()
+		clk.Advance(30 * time.Second)
+	}
+	wait, ok := q.estimateWait(3)
+	require.True(t, ok)
+	assert.Equal(t, 90*time.Second, wait)
+
+	_, ok = q.estimateWait(0)
+	assert.False(t, ok, "started events do not wait")
+
+	var paused atomic.Bool
+	q.paused = paused.Load
+	paused.Store(true)
+	_, ok = q.estimateWait(3)
+	assert.False(t, ok, "no estimate while paused")
+	paused.Store(false)
+
+	// Events finished longer ago than the window no longer count
+	clk.Advance(throughputWindow)
+	_, ok = q.estimateWait(3)
+	assert.False(t, ok)
+}
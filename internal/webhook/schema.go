@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Payload schema versions. v1 payloads carry no schema_version; v2 adds
+// the idempotency key and provisioning options, so hooks retrying a
+// request cannot provision twice and can set a domain's overrides
+const (
+	SchemaV1 = 1
+	SchemaV2 = 2
+	// LatestSchemaVersion is the newest version the handler understands;
+	// newer payloads are handled as this version
+	LatestSchemaVersion = SchemaV2
+)
+
+// maxIdempotencyKeyLength bounds the idempotency keys kept in memory
+const maxIdempotencyKeyLength = 128
+
+// idempotencyKeyRegex matches the keys hooks generate, such as UUIDs
+var idempotencyKeyRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// Version returns the schema version of the payload, 1 when it has none
+func (p *WebhookPayload) Version() int {
+	if p.SchemaVersion == 0 {
+		return SchemaV1
+	}
+	return p.SchemaVersion
+}
+
+// KnownVersion reports whether the handler understands the payload's
+// schema version
+func (p *WebhookPayload) KnownVersion() bool {
+	return p.Version() <= LatestSchemaVersion
+}
+
+// ValidateVersion applies the rules of the payload's schema version on
+// top of the rules of its event. Unknown newer versions are held to the
+// rules of the latest version
+func (p *WebhookPayload) ValidateVersion() error {
+	if p.SchemaVersion < 0 {
+		return fmt.Errorf("schema_version must be positive, got %d", p.SchemaVersion)
+	}
+
+	if p.Version() == SchemaV1 {
+		if p.IdempotencyKey != "" {
+			return fmt.Errorf("idempotency_key requires schema_version %d", SchemaV2)
+		}
+		if p.Options != nil {
+			return fmt.Errorf("options require schema_version %d", SchemaV2)
+		}
+		return nil
+	}
+
+	if p.IdempotencyKey == "" {
+		return fmt.Errorf("idempotency_key is required for schema_version %d", p.Version())
+	}
+	if len(p.IdempotencyKey) > maxIdempotencyKeyLength || !idempotencyKeyRegex.MatchString(p.IdempotencyKey) {
+		return fmt.Errorf("idempotency_key must be at most %d letters, digits, '.', '_', ':' or '-'", maxIdempotencyKeyLength)
+	}
+	if p.Options != nil {
+		if !isProvisioningEvent(p.Event) {
+			return fmt.Errorf("options are only accepted for provisioning events, not '%s'", p.Event)
+		}
+		if err := p.Options.Validate(); err != nil {
+			return fmt.Errorf("options.%w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package webhook
+
+import "sync/atomic"
+
+// Stats counts webhook requests for the health endpoint
+type Stats struct {
+	// Received is every POST to the webhook
+	Received int64 `json:"received"`
+	// TooLarge were rejected with 413 for exceeding the body limit
+	TooLarge int64 `json:"too_large"`
+	// Unauthorized carried no valid signature
+	Unauthorized int64 `json:"unauthorized"`
+	// Invalid could not be parsed or failed validation
+	Invalid int64 `json:"invalid"`
+}
+
+// stats holds the counters behind Stats
+type stats struct {
+	received     atomic.Int64
+	tooLarge     atomic.Int64
+	unauthorized atomic.Int64
+	invalid      atomic.Int64
+}
+
+// Stats returns the webhook request counters
+func (h *Handler) Stats() Stats {
+	return Stats{
+		Received:     h.stats.received.Load(),
+		TooLarge:     h.stats.tooLarge.Load(),
+		Unauthorized: h.stats.unauthorized.Load(),
+		Invalid:      h.stats.invalid.Load(),
+	}
+}
@@ -0,0 +1,176 @@
+package whm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Flag is a WHM boolean, sent as 0 or 1 as a number or a string
+type Flag bool
+
+// UnmarshalJSON accepts 0, 1, "0", "1", true and false
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	s := string(bytes.Trim(data, `"`))
+	switch s {
+	case "", "0", "false", "null":
+		*f = false
+	case "1", "true":
+		*f = true
+	default:
+		return fmt.Errorf("invalid WHM flag %s", data)
+	}
+	return nil
+}
+
+// ErrAccountNotFound is returned by GetAccount for an unknown user
+var ErrAccountNotFound = errors.New("WHM account not found")
+
+// Account is a cPanel account, as listed by listaccts
+type Account struct {
+	User      string `json:"user"`
+	Domain    string `json:"domain"`
+	Email     string `json:"email"`
+	Plan      string `json:"plan"`
+	IP        string `json:"ip"`
+	Owner     string `json:"owner"`
+	Partition string `json:"partition"`
+	Suspended Flag   `json:"suspended"`
+	// SuspendReason is set for suspended accounts
+	SuspendReason string `json:"suspendreason"`
+	// StartDate is the Unix time the account was created
+	StartDate int64 `json:"unix_startdate"`
+}
+
+// ListAccounts returns every account on the server
+func (c *Client) ListAccounts(ctx context.Context) ([]Account, error) {
+	var data struct {
+		Accounts []Account `json:"acct"`
+	}
+	if err := c.call(ctx, "listaccts", url.Values{"want": {"user,domain,email,plan,ip,owner,partition,suspended,suspendreason,unix_startdate"}}, &data); err != nil {
+		return nil, err
+	}
+	return data.Accounts, nil
+}
+
+// GetAccount returns the account of user
+func (c *Client) GetAccount(ctx context.Context, user string) (*Account, error) {
+	var data struct {
+		Accounts []Account `json:"acct"`
+	}
+	params := url.Values{
+		"search":     {"^" + user + "$"},
+		"searchtype": {"user"},
+	}
+	if err := c.call(ctx, "listaccts", params, &data); err != nil {
+		return nil, err
+	}
+	for _, acct := range data.Accounts {
+		if acct.User == user {
+			return &acct, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, user)
+}
+
+// AccountEmail returns the contact email of user's account, such as the
+// account of a reseller
+func (c *Client) AccountEmail(ctx context.Context, user string) (string, error) {
+	acct, err := c.GetAccount(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	if acct.Email == "" {
+		return "", fmt.Errorf("WHM account %s has no contact email", user)
+	}
+	return acct.Email, nil
+}
+
+// DomainUserData is the web server configuration of a domain, as returned
+// by domainuserdata
+type DomainUserData struct {
+	ServerName   string `json:"servername"`
+	ServerAlias  string `json:"serveralias"`
+	User         string `json:"user"`
+	Group        string `json:"group"`
+	IP           string `json:"ip"`
+	Port         string `json:"port"`
+	DocumentRoot string `json:"documentroot"`
+	HomeDir      string `json:"homedir"`
+}
+
+// UnmarshalJSON accepts the port as a number or a string
+func (d *DomainUserData) UnmarshalJSON(data []byte) error {
+	type plain DomainUserData
+	var raw struct {
+		plain
+		Port json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*d = DomainUserData(raw.plain)
+	if len(raw.Port) > 0 {
+		d.Port = string(bytes.Trim(raw.Port, `"`))
+		if d.Port == "null" {
+			d.Port = ""
+		}
+	}
+	return nil
+}
+
+// GetDomainUserData returns the web server configuration of domain
+func (c *Client) GetDomainUserData(ctx context.Context, domain string) (*DomainUserData, error) {
+	var data struct {
+		UserData DomainUserData `json:"userdata"`
+	}
+	if err := c.call(ctx, "domainuserdata", url.Values{"domain": {domain}}, &data); err != nil {
+		return nil, err
+	}
+	return &data.UserData, nil
+}
+
+// Subdomain is a subdomain of a cPanel account, as listed by
+// SubDomain::listsubdomains
+type Subdomain struct {
+	Domain     string `json:"domain"`
+	Subdomain  string `json:"subdomain"`
+	RootDomain string `json:"rootdomain"`
+	Dir        string `json:"dir"`
+	Status     string `json:"status"`
+}
+
+// ListSubdomains returns the subdomains of the account of user
+func (c *Client) ListSubdomains(ctx context.Context, user string) ([]Subdomain, error) {
+	var subs []Subdomain
+	if err := c.callCPanel(ctx, user, "SubDomain", "listsubdomains", &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// DomainInfo is a domain on the server, as listed by get_domain_info
+type DomainInfo struct {
+	Domain string `json:"domain"`
+	// Type is main, addon, sub or parked
+	Type string `json:"domain_type"`
+	User string `json:"user"`
+	// ParentDomain is the main domain of the account owning the domain
+	ParentDomain string `json:"parent_domain"`
+	DocRoot      string `json:"docroot"`
+}
+
+// ListDomains returns every domain on the server, as /etc/userdatadomains
+// lists them
+func (c *Client) ListDomains(ctx context.Context) ([]DomainInfo, error) {
+	var data struct {
+		Domains []DomainInfo `json:"domains"`
+	}
+	if err := c.call(ctx, "get_domain_info", nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Domains, nil
+}
@@ -0,0 +1,255 @@
+// Package whm is a client for the WHM JSON API of the cPanel server, used
+// to read accounts and their domains
+package whm
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	goRetry "github.com/sethvargo/go-retry"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/retry"
+)
+
+const (
+	// DefaultUsername is the WHM user API tokens are created for
+	DefaultUsername = "root"
+	// DefaultTimeout is the default HTTP timeout
+	DefaultTimeout = 30 * time.Second
+)
+
+// APIError is a failed WHM API call, either an HTTP error or a call WHM
+// answered with result 0
+type APIError struct {
+	StatusCode int
+	Command    string
+	Reason     string
+}
+
+// Error returns the error message
+func (e *APIError) Error() string {
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("WHM API error (%s, status %d): %s", e.Command, e.StatusCode, e.Reason)
+	}
+	return fmt.Sprintf("WHM API error (%s): %s", e.Command, e.Reason)
+}
+
+// Client is a WHM JSON API client authenticated with an API token
+type Client struct {
+	baseURL    string
+	username   string
+	token      string
+	httpClient *http.Client
+	logger     *zap.Logger
+	backoff    goRetry.Backoff
+}
+
+// ClientOption is a function that configures a Client
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the HTTP client for the Client
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithInsecureSkipVerify accepts any certificate, for WHM still serving
+// its self-signed one
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) {
+		// Keep the proxy of a transport set by WithHTTPClient
+		transport, ok := c.httpClient.Transport.(*http.Transport)
+		if !ok {
+			transport = http.DefaultTransport.(*http.Transport)
+		}
+		transport = transport.Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // Opt-in for a self-signed WHM certificate
+		c.httpClient = &http.Client{
+			Timeout:   c.httpClient.Timeout,
+			Transport: transport,
+		}
+	}
+}
+
+// WithTimeout sets the HTTP timeout
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// WithLogger sets the logger for the client
+func WithLogger(logger *zap.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithRetryConfig sets the retry configuration for the client
+func WithRetryConfig(retryCfg *retry.Config) ClientOption {
+	return func(c *Client) {
+		c.backoff = retry.WithBackoff(retryCfg)
+	}
+}
+
+// NewClient creates a WHM API client for the server at baseURL, such as
+// https://server.example.com:2087, using an API token of username
+func NewClient(baseURL, username, token string, opts ...ClientOption) *Client {
+	if username == "" {
+		username = DefaultUsername
+	}
+	c := &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		username: username,
+		token:    token,
+		httpClient: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+		logger:  zap.NewNop(),
+		backoff: retry.WithBackoff(retry.DefaultConfig()),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// metadata is the status WHM API 1 returns with every call
+type metadata struct {
+	Result  int    `json:"result"`
+	Reason  string `json:"reason"`
+	Command string `json:"command"`
+}
+
+// call runs a WHM API 1 function and decodes its data into result
+func (c *Client) call(ctx context.Context, function string, params url.Values, result interface{}) error {
+	var resp struct {
+		Metadata metadata        `json:"metadata"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api.version", "1")
+
+	if err := c.do(ctx, function, params, &resp); err != nil {
+		return err
+	}
+	if resp.Metadata.Result != 1 {
+		return &APIError{StatusCode: http.StatusOK, Command: function, Reason: resp.Metadata.Reason}
+	}
+
+	if result != nil && len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, result); err != nil {
+			return fmt.Errorf("failed to unmarshal %s data: %w", function, err)
+		}
+	}
+	return nil
+}
+
+// callCPanel runs a cPanel API 2 function as user through WHM and decodes
+// its data into result
+func (c *Client) callCPanel(ctx context.Context, user, module, function string, result interface{}) error {
+	params := url.Values{
+		"cpanel_jsonapi_user":       {user},
+		"cpanel_jsonapi_apiversion": {"2"},
+		"cpanel_jsonapi_module":     {module},
+		"cpanel_jsonapi_func":       {function},
+	}
+
+	var resp struct {
+		Result struct {
+			Data  json.RawMessage `json:"data"`
+			Error string          `json:"error"`
+			Event struct {
+				Result int `json:"result"`
+			} `json:"event"`
+		} `json:"cpanelresult"`
+	}
+	if err := c.do(ctx, "cpanel", params, &resp); err != nil {
+		return err
+	}
+
+	command := module + "::" + function
+	if resp.Result.Error != "" || resp.Result.Event.Result != 1 {
+		reason := resp.Result.Error
+		if reason == "" {
+			reason = "call failed"
+		}
+		return &APIError{StatusCode: http.StatusOK, Command: command, Reason: reason}
+	}
+
+	if result != nil && len(resp.Result.Data) > 0 {
+		if err := json.Unmarshal(resp.Result.Data, result); err != nil {
+			return fmt.Errorf("failed to unmarshal %s data: %w", command, err)
+		}
+	}
+	return nil
+}
+
+// do performs a GET of /json-api/function with retries on network errors,
+// rate limits and server errors
+func (c *Client) do(ctx context.Context, function string, params url.Values, result interface{}) error {
+	endpoint := c.baseURL + "/json-api/" + function + "?" + params.Encode()
+
+	retryFunc := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Authorization", "whm "+c.username+":"+c.token)
+		req.Header.Set("Accept", "application/json")
+
+		c.logger.Debug("making WHM API request", zap.String("function", function))
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.logger.Warn("WHM API request failed, will retry",
+				zap.String("function", function),
+				zap.Error(err),
+			)
+			return goRetry.RetryableError(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return goRetry.RetryableError(fmt.Errorf("failed to read response body: %w", err))
+		}
+
+		if resp.StatusCode >= 400 {
+			apiErr := &APIError{
+				StatusCode: resp.StatusCode,
+				Command:    function,
+				Reason:     http.StatusText(resp.StatusCode),
+			}
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return apiErr
+			}
+
+			c.logger.Warn("WHM API request returned error, will retry",
+				zap.String("function", function),
+				zap.Int("status", resp.StatusCode),
+			)
+			return goRetry.RetryableError(apiErr)
+		}
+
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("failed to unmarshal %s response: %w", function, err)
+		}
+		return nil
+	}
+
+	return goRetry.Do(ctx, c.backoff, retryFunc)
+}
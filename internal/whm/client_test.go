@@ -0,0 +1,202 @@
+package whm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/retry"
+)
+
+// newTestClient serves handler and returns a client for it that retries
+// without waiting
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL, "", "secret-token", WithRetryConfig(&retry.Config{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}))
+}
+
+func TestClient_ListAccounts(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/json-api/listaccts", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("api.version"))
+		assert.Equal(t, "whm root:secret-token", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{
+			"metadata": {"result": 1, "reason": "OK", "command": "listaccts"},
+			"data": {"acct": [
+				{"user": "alice", "domain": "alice.example", "plan": "gold", "suspended": 0, "unix_startdate": 1700000000},
+				{"user": "bob", "domain": "bob.example", "plan": "default", "suspended": "1", "suspendreason": "unpaid"}
+			]}
+		}`)
+	})
+
+	accounts, err := client.ListAccounts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, "alice.example", accounts[0].Domain)
+	assert.Equal(t, "gold", accounts[0].Plan)
+	assert.False(t, bool(accounts[0].Suspended))
+	assert.Equal(t, int64(1700000000), accounts[0].StartDate)
+	assert.True(t, bool(accounts[1].Suspended))
+	assert.Equal(t, "unpaid", accounts[1].SuspendReason)
+}
+
+func TestClient_GetAccount(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user", r.URL.Query().Get("searchtype"))
+		if r.URL.Query().Get("search") == "^alice$" {
+			fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": [{"user": "alice", "domain": "alice.example"}]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": []}}`)
+	})
+
+	acct, err := client.GetAccount(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice.example", acct.Domain)
+
+	_, err = client.GetAccount(context.Background(), "mallory")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+}
+
+func TestClient_AccountEmail(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("search") {
+		case "^acme$":
+			fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": [{"user": "acme", "email": "admin@acme.example"}]}}`)
+		case "^bare$":
+			fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": [{"user": "bare"}]}}`)
+		default:
+			fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": []}}`)
+		}
+	})
+
+	email, err := client.AccountEmail(context.Background(), "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "admin@acme.example", email)
+
+	_, err = client.AccountEmail(context.Background(), "bare")
+	assert.Error(t, err)
+
+	_, err = client.AccountEmail(context.Background(), "mallory")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+}
+
+func TestClient_GetDomainUserData(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/json-api/domainuserdata", r.URL.Path)
+		assert.Equal(t, "alice.example", r.URL.Query().Get("domain"))
+		fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"userdata": {
+			"servername": "alice.example", "serveralias": "www.alice.example",
+			"user": "alice", "ip": "192.0.2.10", "port": 80,
+			"documentroot": "/home/alice/public_html", "homedir": "/home/alice"
+		}}}`)
+	})
+
+	data, err := client.GetDomainUserData(context.Background(), "alice.example")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", data.User)
+	assert.Equal(t, "192.0.2.10", data.IP)
+	assert.Equal(t, "80", data.Port)
+	assert.Equal(t, "/home/alice/public_html", data.DocumentRoot)
+}
+
+func TestClient_ListSubdomains(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/json-api/cpanel", r.URL.Path)
+		q := r.URL.Query()
+		assert.Equal(t, "alice", q.Get("cpanel_jsonapi_user"))
+		assert.Equal(t, "SubDomain", q.Get("cpanel_jsonapi_module"))
+		assert.Equal(t, "listsubdomains", q.Get("cpanel_jsonapi_func"))
+		fmt.Fprint(w, `{"cpanelresult": {"event": {"result": 1}, "data": [
+			{"domain": "blog.alice.example", "subdomain": "blog", "rootdomain": "alice.example", "dir": "/home/alice/blog"}
+		]}}`)
+	})
+
+	subs, err := client.ListSubdomains(context.Background(), "alice")
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	assert.Equal(t, "blog.alice.example", subs[0].Domain)
+	assert.Equal(t, "alice.example", subs[0].RootDomain)
+}
+
+func TestClient_ListDomains(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/json-api/get_domain_info", r.URL.Path)
+		fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"domains": [
+			{"domain": "alice.example", "domain_type": "main", "user": "alice", "parent_domain": "alice.example"},
+			{"domain": "shop.example", "domain_type": "addon", "user": "alice", "parent_domain": "alice.example"}
+		]}}`)
+	})
+
+	domains, err := client.ListDomains(context.Background())
+	require.NoError(t, err)
+	require.Len(t, domains, 2)
+	assert.Equal(t, "addon", domains[1].Type)
+	assert.Equal(t, "alice.example", domains[1].ParentDomain)
+}
+
+func TestClient_Errors(t *testing.T) {
+	t.Run("call failed", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"metadata": {"result": 0, "reason": "Access denied"}}`)
+		})
+
+		_, err := client.ListAccounts(context.Background())
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, "Access denied", apiErr.Reason)
+	})
+
+	t.Run("cPanel call failed", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"cpanelresult": {"event": {"result": 0}, "error": "User does not exist"}}`)
+		})
+
+		_, err := client.ListSubdomains(context.Background(), "nobody")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "User does not exist")
+	})
+
+	t.Run("server errors are retried", func(t *testing.T) {
+		var calls atomic.Int32
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"metadata": {"result": 1}, "data": {"acct": []}}`)
+		})
+
+		_, err := client.ListAccounts(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		_, err := client.ListAccounts(context.Background())
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
@@ -0,0 +1,154 @@
+package zonefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// field is one token of an entry; quoted fields are the character-strings
+// of TXT records and are never taken for names or directives
+type field struct {
+	text   string
+	quoted bool
+}
+
+// entry is one logical line of a zone file: a control entry or a record,
+// whose parentheses may span several lines
+type entry struct {
+	// line is the line the entry starts on
+	line int
+	// blankOwner is set for records starting with white space, which
+	// belong to the previous record's owner
+	blankOwner bool
+	fields     []field
+}
+
+// lex splits a zone file into entries, dropping comments and blank lines
+func lex(r io.Reader) ([]entry, error) {
+	br := bufio.NewReader(r)
+
+	var (
+		entries []entry
+		current entry
+		token   strings.Builder
+		inToken bool
+		quoted  bool
+		escaped bool
+		comment bool
+		parens  int
+		line    = 1
+		start   = true
+	)
+	flushToken := func(wasQuoted bool) {
+		if inToken || wasQuoted {
+			current.fields = append(current.fields, field{text: token.String(), quoted: wasQuoted})
+		}
+		token.Reset()
+		inToken = false
+	}
+
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+			if c >= '0' && c <= '9' {
+				// \DDD is the byte with decimal value DDD
+				digits := []byte{c, 0, 0}
+				if _, err := io.ReadFull(br, digits[1:]); err != nil {
+					return nil, fmt.Errorf("line %d: invalid escape", line)
+				}
+				n, err := strconv.Atoi(string(digits))
+				if err != nil || n > 255 {
+					return nil, fmt.Errorf("line %d: invalid escape \\%s", line, digits)
+				}
+				c = byte(n)
+			} else if c == '\n' {
+				line++
+			}
+			token.WriteByte(c)
+			continue
+		case quoted:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				quoted = false
+				flushToken(true)
+			case '\n':
+				return nil, fmt.Errorf("line %d: unterminated quoted string", line)
+			default:
+				token.WriteByte(c)
+			}
+			continue
+		case comment:
+			if c != '\n' {
+				continue
+			}
+			comment = false
+		}
+
+		if start {
+			current = entry{line: line, blankOwner: c == ' ' || c == '\t'}
+			start = false
+		}
+
+		switch c {
+		case '\n':
+			flushToken(false)
+			line++
+			if parens > 0 {
+				continue
+			}
+			if len(current.fields) > 0 {
+				entries = append(entries, current)
+			}
+			start = true
+		case ' ', '\t', '\r':
+			flushToken(false)
+		case ';':
+			flushToken(false)
+			comment = true
+		case '(':
+			flushToken(false)
+			parens++
+		case ')':
+			flushToken(false)
+			if parens == 0 {
+				return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+			}
+			parens--
+		case '"':
+			flushToken(false)
+			quoted = true
+		case '\\':
+			inToken = true
+			escaped = true
+		default:
+			inToken = true
+			token.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("line %d: unterminated quoted string", line)
+	}
+	if parens > 0 {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+	}
+	flushToken(false)
+	if !start && len(current.fields) > 0 {
+		entries = append(entries, current)
+	}
+	return entries, nil
+}
@@ -0,0 +1,364 @@
+// Package zonefile reads and writes DNS zones in the master file format of
+// RFC 1035, the format BIND, cPanel and most DNS providers import and
+// export zones in
+package zonefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Record types a zone can hold
+const (
+	TypeA     = "A"
+	TypeAAAA  = "AAAA"
+	TypeCNAME = "CNAME"
+	TypeMX    = "MX"
+	TypeNS    = "NS"
+	TypeTXT   = "TXT"
+)
+
+// typeOrder is the order records of one name are written in
+var typeOrder = map[string]int{
+	TypeNS:    0,
+	TypeA:     1,
+	TypeAAAA:  2,
+	TypeCNAME: 3,
+	TypeMX:    4,
+	TypeTXT:   5,
+}
+
+// maxTXTString is the longest character-string of a TXT record; longer
+// values are split into several strings
+const maxTXTString = 255
+
+// Record is one resource record of a zone
+type Record struct {
+	// Name is the owner relative to the zone's origin, "" for the apex
+	Name string
+	// TTL is in seconds; 0 uses the zone's default
+	TTL  int
+	Type string
+	// Priority is the preference of an MX record
+	Priority int
+	// Value is the address of A and AAAA records, the text of TXT records,
+	// and the absolute host name, without the trailing dot, of CNAME, MX
+	// and NS records
+	Value string
+}
+
+// Zone is the set of records of one domain
+type Zone struct {
+	// Origin is the domain of the zone, without the trailing dot
+	Origin string
+	// TTL is the default TTL of the records, in seconds; 0 when not set
+	TTL     int
+	Records []Record
+	// Skipped lists the records of a parsed file whose type is not
+	// supported, as "line N: TYPE name". SOA records are left out without
+	// being listed, as the DNS provider manages them
+	Skipped []string
+}
+
+// Write writes z as a zone file, its records sorted by name and type so
+// exports of the same zone compare equal
+func Write(w io.Writer, z *Zone) error {
+	origin := strings.TrimSuffix(strings.ToLower(z.Origin), ".")
+	if origin == "" {
+		return fmt.Errorf("zone origin is required")
+	}
+
+	records := append([]Record(nil), z.Records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Name != b.Name {
+			// The apex sorts first
+			return a.Name < b.Name
+		}
+		if typeOrder[a.Type] != typeOrder[b.Type] {
+			return typeOrder[a.Type] < typeOrder[b.Type]
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Value < b.Value
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %s.\n", origin)
+	if z.TTL > 0 {
+		fmt.Fprintf(bw, "$TTL %d\n", z.TTL)
+	}
+	fmt.Fprintln(bw)
+
+	tw := tabwriter.NewWriter(bw, 0, 8, 1, ' ', 0)
+	for _, r := range records {
+		data, err := rdata(r)
+		if err != nil {
+			return err
+		}
+		name := r.Name
+		if name == "" {
+			name = "@"
+		}
+		ttl := ""
+		if r.TTL > 0 {
+			ttl = strconv.Itoa(r.TTL)
+		}
+		fmt.Fprintf(tw, "%s\t%s\tIN\t%s\t%s\n", name, ttl, r.Type, data)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// rdata formats the data of a record
+func rdata(r Record) (string, error) {
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		return r.Value, nil
+	case TypeCNAME, TypeNS:
+		return fqdn(r.Value), nil
+	case TypeMX:
+		return fmt.Sprintf("%d %s", r.Priority, fqdn(r.Value)), nil
+	case TypeTXT:
+		return quoteTXT(r.Value), nil
+	default:
+		return "", fmt.Errorf("unsupported record type %s for %q", r.Type, r.Name)
+	}
+}
+
+// fqdn returns name with the trailing dot of an absolute name
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// quoteTXT quotes text as the character-strings of a TXT record
+func quoteTXT(text string) string {
+	var parts []string
+	for {
+		chunk := text
+		if len(chunk) > maxTXTString {
+			chunk = chunk[:maxTXTString]
+		}
+		text = text[len(chunk):]
+
+		var b strings.Builder
+		b.WriteByte('"')
+		for i := 0; i < len(chunk); i++ {
+			if chunk[i] == '"' || chunk[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(chunk[i])
+		}
+		b.WriteByte('"')
+		parts = append(parts, b.String())
+
+		if text == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// Parse reads a zone file for the domain origin. Owner names outside the
+// domain are rejected; records of types other than A, AAAA, CNAME, MX, NS
+// and TXT are listed in Skipped
+func Parse(r io.Reader, origin string) (*Zone, error) {
+	origin = strings.TrimSuffix(strings.ToLower(origin), ".")
+	if origin == "" {
+		return nil, fmt.Errorf("zone origin is required")
+	}
+
+	entries, err := lex(r)
+	if err != nil {
+		return nil, err
+	}
+
+	z := &Zone{Origin: origin}
+	current := origin
+	owner := ""
+	lastTTL := 0
+	for _, e := range entries {
+		fields := e.fields
+		if !e.blankOwner && strings.HasPrefix(fields[0].text, "$") && !fields[0].quoted {
+			if err := z.directive(e, &current); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !e.blankOwner {
+			owner = absolute(fields[0].text, current)
+			fields = fields[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: record without an owner name", e.line)
+		}
+
+		// The TTL and class may come in either order before the type
+		ttl := 0
+		for i := 0; i < 2 && len(fields) > 0; i++ {
+			token := fields[0].text
+			if strings.EqualFold(token, "IN") {
+				fields = fields[1:]
+				continue
+			}
+			if token == "" || token[0] < '0' || token[0] > '9' {
+				break
+			}
+			if ttl, err = parseTTL(token); err != nil {
+				return nil, fmt.Errorf("line %d: %w", e.line, err)
+			}
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: record type missing", e.line)
+		}
+		if ttl == 0 {
+			// Without $TTL a record without TTL keeps the previous one's
+			ttl = z.TTL
+			if ttl == 0 {
+				ttl = lastTTL
+			}
+		}
+		lastTTL = ttl
+
+		name, ok := relative(owner, origin)
+		if !ok {
+			return nil, fmt.Errorf("line %d: %s is outside the zone %s", e.line, owner, origin)
+		}
+
+		typ := strings.ToUpper(fields[0].text)
+		if typ == "SOA" {
+			continue
+		}
+		if _, ok := typeOrder[typ]; !ok {
+			z.Skipped = append(z.Skipped, fmt.Sprintf("line %d: %s %s", e.line, typ, owner))
+			continue
+		}
+
+		record, err := parseRecord(typ, fields[1:], current)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", e.line, err)
+		}
+		record.Name = name
+		record.TTL = ttl
+		z.Records = append(z.Records, record)
+	}
+	return z, nil
+}
+
+// directive applies a $ORIGIN or $TTL control entry
+func (z *Zone) directive(e entry, current *string) error {
+	if len(e.fields) != 2 {
+		return fmt.Errorf("line %d: %s takes one argument", e.line, e.fields[0].text)
+	}
+	arg := e.fields[1].text
+	switch strings.ToUpper(e.fields[0].text) {
+	case "$ORIGIN":
+		*current = absolute(arg, *current)
+	case "$TTL":
+		ttl, err := parseTTL(arg)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", e.line, err)
+		}
+		z.TTL = ttl
+	default:
+		return fmt.Errorf("line %d: unsupported directive %s", e.line, e.fields[0].text)
+	}
+	return nil
+}
+
+// parseRecord parses the data of a record of type typ, relative names
+// being relative to origin
+func parseRecord(typ string, fields []field, origin string) (Record, error) {
+	record := Record{Type: typ}
+	want := 1
+	switch typ {
+	case TypeTXT:
+		if len(fields) == 0 {
+			return record, fmt.Errorf("TXT record without text")
+		}
+		var b strings.Builder
+		for _, f := range fields {
+			b.WriteString(f.text)
+		}
+		record.Value = b.String()
+		return record, nil
+	case TypeMX:
+		want = 2
+	}
+	if len(fields) != want {
+		return record, fmt.Errorf("%s record takes %d value(s), got %d", typ, want, len(fields))
+	}
+
+	switch typ {
+	case TypeA, TypeAAAA:
+		record.Value = fields[0].text
+	case TypeMX:
+		priority, err := strconv.Atoi(fields[0].text)
+		if err != nil || priority < 0 || priority > 65535 {
+			return record, fmt.Errorf("invalid MX preference %q", fields[0].text)
+		}
+		record.Priority = priority
+		record.Value = absolute(fields[1].text, origin)
+	default:
+		record.Value = absolute(fields[0].text, origin)
+	}
+	return record, nil
+}
+
+// absolute returns name as an absolute name without the trailing dot,
+// resolving relative names and @ against origin
+func absolute(name, origin string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	default:
+		return name + "." + origin
+	}
+}
+
+// relative returns the absolute name relative to origin, "" for origin
+// itself, and whether name is within origin
+func relative(name, origin string) (string, bool) {
+	if name == origin {
+		return "", true
+	}
+	rel, ok := strings.CutSuffix(name, "."+origin)
+	return rel, ok
+}
+
+// parseTTL parses a TTL in seconds, or in the units of BIND such as 1h30m
+func parseTTL(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n, nil
+	}
+
+	total, n, digits := 0, 0, false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + int(c-'0')
+			digits = true
+			continue
+		}
+		unit, ok := map[rune]int{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}[c]
+		if !ok || !digits {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		total += n * unit
+		n, digits = 0, false
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return total, nil
+}
@@ -0,0 +1,110 @@
+package zonefile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	file := `; exported by cPanel
+$TTL 14400
+@	86400	IN	SOA	ns1.example.com. hostmaster.example.com. (
+		2024011501 ; serial
+		3600 1800 1209600 86400 )
+
+example.com.	IN	NS	ns1.example.com.
+@		A	192.0.2.10
+		AAAA	2001:db8::10
+www	300	IN	CNAME	@
+mail	IN	1h	A	192.0.2.20
+@		MX	10 mail
+_dmarc		TXT	"v=DMARC1; p=none"
+@		TXT	( "v=spf1 a mx "
+		  "include:_spf.example.net -all" )
+quote		TXT	"say \"hi\"\059"
+_sip._tcp	SRV	10 5 5060 sip.example.com.
+
+$ORIGIN shop.example.com.
+api		CNAME	edge.example.net.
+`
+	z, err := Parse(strings.NewReader(file), "Example.com.")
+	require.NoError(t, err)
+
+	assert.Equal(t, "example.com", z.Origin)
+	assert.Equal(t, 14400, z.TTL)
+	assert.Equal(t, []Record{
+		{Name: "", TTL: 14400, Type: TypeNS, Value: "ns1.example.com"},
+		{Name: "", TTL: 14400, Type: TypeA, Value: "192.0.2.10"},
+		{Name: "", TTL: 14400, Type: TypeAAAA, Value: "2001:db8::10"},
+		{Name: "www", TTL: 300, Type: TypeCNAME, Value: "example.com"},
+		{Name: "mail", TTL: 3600, Type: TypeA, Value: "192.0.2.20"},
+		{Name: "", TTL: 14400, Type: TypeMX, Priority: 10, Value: "mail.example.com"},
+		{Name: "_dmarc", TTL: 14400, Type: TypeTXT, Value: "v=DMARC1; p=none"},
+		{Name: "", TTL: 14400, Type: TypeTXT, Value: "v=spf1 a mx include:_spf.example.net -all"},
+		{Name: "quote", TTL: 14400, Type: TypeTXT, Value: `say "hi";`},
+		{Name: "api.shop", TTL: 14400, Type: TypeCNAME, Value: "edge.example.net"},
+	}, z.Records)
+	assert.Equal(t, []string{"line 17: SRV _sip._tcp.example.com"}, z.Skipped)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := map[string]string{
+		"outside the zone":  "other.org. A 192.0.2.1\n",
+		"no owner":          "  A 192.0.2.1\n",
+		"unterminated":      "@ TXT \"open\n",
+		"unbalanced":        "@ MX ( 10 mail\n",
+		"bad preference":    "@ MX high mail\n",
+		"missing value":     "@ CNAME\n",
+		"bad ttl":           "@ 1x A 192.0.2.1\n",
+		"unknown directive": "$INCLUDE other.zone\n",
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(file), "example.com")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	long := strings.Repeat("k", 300)
+	z := &Zone{
+		Origin: "example.com",
+		TTL:    3600,
+		Records: []Record{
+			{Name: "www", TTL: 300, Type: TypeCNAME, Value: "example-com.b-cdn.net"},
+			{Name: "", TTL: 3600, Type: TypeTXT, Value: `v=spf1 "quoted" a mx -all`},
+			{Name: "", TTL: 3600, Type: TypeMX, Priority: 10, Value: "mail.example.com."},
+			{Name: "", TTL: 3600, Type: TypeA, Value: "192.0.2.10"},
+			{Name: "dkim._domainkey", TTL: 3600, Type: TypeTXT, Value: long},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, z))
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "$ORIGIN example.com.\n$TTL 3600\n"), out)
+	assert.Contains(t, out, "example-com.b-cdn.net.")
+	assert.Contains(t, out, `"v=spf1 \"quoted\" a mx -all"`)
+	assert.Less(t, strings.Index(out, " A "), strings.Index(out, " MX "), "apex records are sorted by type")
+
+	parsed, err := Parse(&buf, "example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Record{
+		{Name: "www", TTL: 300, Type: TypeCNAME, Value: "example-com.b-cdn.net"},
+		{Name: "", TTL: 3600, Type: TypeTXT, Value: `v=spf1 "quoted" a mx -all`},
+		{Name: "", TTL: 3600, Type: TypeMX, Priority: 10, Value: "mail.example.com"},
+		{Name: "", TTL: 3600, Type: TypeA, Value: "192.0.2.10"},
+		{Name: "dkim._domainkey", TTL: 3600, Type: TypeTXT, Value: long},
+	}, parsed.Records)
+}
+
+func TestWrite_UnsupportedType(t *testing.T) {
+	z := &Zone{Origin: "example.com", Records: []Record{{Type: "SRV", Value: "10 5 5060 sip"}}}
+	assert.Error(t, Write(&bytes.Buffer{}, z))
+}
@@ -0,0 +1,115 @@
+// Package zonename derives the names of the pull and storage zones a domain
+// gets on Bunny from the configured prefix, and recognizes the names zones
+// were given under earlier prefixes
+package zonename
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// LegacyPrefix is the prefix every zone was named with before the
+	// prefix became configurable; it is always recognized
+	LegacyPrefix = "morden-"
+
+	// InstancePlaceholder is replaced by the instance name, so several
+	// servers sharing a Bunny account each get their own zone names
+	InstancePlaceholder = "{instance}"
+
+	// storageSuffix ends the name of a domain's Perma-Cache storage zone
+	storageSuffix = "-cache"
+)
+
+// Namer names the zones of a domain
+type Namer struct {
+	prefix   string
+	previous []string
+}
+
+// New returns a Namer naming zones with prefix and recognizing zones named
+// with the previous prefixes or LegacyPrefix
+func New(prefix string, previous ...string) *Namer {
+	n := &Namer{prefix: prefix}
+	seen := map[string]bool{prefix: true}
+	for _, p := range append(previous, LegacyPrefix) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			n.previous = append(n.previous, p)
+		}
+	}
+	return n
+}
+
+// Expand replaces InstancePlaceholder in prefix with the instance name,
+// lowercased and with dots and underscores turned into dashes
+func Expand(prefix, instance string) string {
+	if !strings.Contains(prefix, InstancePlaceholder) {
+		return prefix
+	}
+	instance = strings.ReplaceAll(slug(instance), "_", "-")
+	return strings.ReplaceAll(prefix, InstancePlaceholder, instance)
+}
+
+// slug lowercases s and turns dots into dashes
+// e.g., "Example.com" -> "example-com"
+func slug(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), ".", "-")
+}
+
+// Prefix returns the prefix new zones are named with
+func (n *Namer) Prefix() string {
+	return n.prefix
+}
+
+// Prefixes returns the current prefix followed by the previous ones
+func (n *Namer) Prefixes() []string {
+	return append([]string{n.prefix}, n.previous...)
+}
+
+// PullZone returns the name of a new pull zone for domain
+// e.g., "example.com" -> "morden-example-com"
+func (n *Namer) PullZone(domain string) string {
+	return n.prefix + slug(domain)
+}
+
+// StorageZone returns the name of a new Perma-Cache storage zone for domain
+// e.g., "example.com" -> "morden-example-com-cache"
+func (n *Namer) StorageZone(domain string) string {
+	return n.PullZone(domain) + storageSuffix
+}
+
+// PullZones returns the names domain's pull zone may have, the current
+// name first
+func (n *Namer) PullZones(domain string) []string {
+	names := make([]string, 0, len(n.previous)+1)
+	for _, prefix := range n.Prefixes() {
+		names = append(names, prefix+slug(domain))
+	}
+	return names
+}
+
+// StorageZones returns the names domain's storage zone may have, the
+// current name first
+func (n *Namer) StorageZones(domain string) []string {
+	names := n.PullZones(domain)
+	for i := range names {
+		names[i] += storageSuffix
+	}
+	return names
+}
+
+// Match returns the prefix a zone name starts with, the longest when
+// several do; ok is false for zones named otherwise
+func (n *Namer) Match(name string) (prefix string, ok bool) {
+	prefixes := n.Prefixes()
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) && len(name) > len(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,46 @@
+package zonename
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	assert.Equal(t, "acme-", Expand("acme-", "web01"))
+	assert.Equal(t, "acme-web-01-eu-", Expand("acme-{instance}-", "Web_01.EU"))
+}
+
+func TestNamer(t *testing.T) {
+	n := New("acme-", "old-", "", "acme-")
+
+	assert.Equal(t, "acme-", n.Prefix())
+	assert.Equal(t, []string{"acme-", "old-", LegacyPrefix}, n.Prefixes(), "the legacy prefix is always recognized")
+	assert.Equal(t, "acme-blog-example-com", n.PullZone("Blog.Example.com"))
+	assert.Equal(t, "acme-example-com-cache", n.StorageZone("example.com"))
+	assert.Equal(t, []string{"acme-example-com", "old-example-com", "morden-example-com"}, n.PullZones("example.com"))
+	assert.Equal(t, []string{"acme-example-com-cache", "old-example-com-cache", "morden-example-com-cache"}, n.StorageZones("example.com"))
+
+	legacy := New(LegacyPrefix)
+	assert.Equal(t, []string{"morden-example-com"}, legacy.PullZones("example.com"))
+}
+
+func TestMatch(t *testing.T) {
+	n := New("morden-eu-", "morden-")
+
+	tests := []struct {
+		name   string
+		prefix string
+		ok     bool
+	}{
+		{"morden-eu-example-com", "morden-eu-", true},
+		{"morden-example-com", "morden-", true},
+		{"morden-", "", false},
+		{"other-example-com", "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := n.Match(tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		assert.Equal(t, tt.prefix, prefix, tt.name)
+	}
+}
@@ -89,11 +89,16 @@ sub handle_account_create {
     my $domain = $data->{'domain'} || return;
     my $user = $data->{'user'} || return;
 
+    # Accounts owned by root have no reseller
+    my $owner = $data->{'owner'} || '';
+    $owner = '' if $owner eq 'root';
+
     send_to_whm2bunny({
-        event  => 'account_created',
-        domain => $domain,
-        user   => $user,
-        plan   => $data->{'plan'} || '',
+        event    => 'account_created',
+        domain   => $domain,
+        user     => $user,
+        package  => $data->{'plan'} || '',
+        reseller => $owner,
     });
 }
 
@@ -15,7 +15,10 @@ Events supported:
 - createacct (account created)
 - addaddondomain (addon domain added)
 - parksubdomain (subdomain created)
+- deladdondomain (addon domain removed)
+- delsubdomain (subdomain removed)
 - killacct (account terminated)
+- changepackage (account moved to another package)
 """
 
 import json
@@ -24,6 +27,7 @@ import hashlib
 import time
 import sys
 import os
+import uuid
 import logging
 from urllib.request import Request, urlopen
 from urllib.error import URLError, HTTPError
@@ -136,8 +140,30 @@ class WebhookClient:
             hashlib.sha256
         ).hexdigest()
 
+    def _log_queue(self, response_data):
+        """Log where a backlogged event stands, so users can be told"""
+        try:
+            queue = json.loads(response_data).get("queue")
+        except (ValueError, AttributeError):
+            return
+        if not queue:
+            return
+        if queue.get("paused"):
+            self.logger.info(f"Queued at position {queue.get('position')}, provisioning is paused")
+        elif queue.get("estimated_start"):
+            self.logger.info(
+                f"Queued at position {queue.get('position')}, "
+                f"estimated start {queue.get('estimated_start')}"
+            )
+        else:
+            self.logger.info(f"Queued at position {queue.get('position')}")
+
     def send(self, payload):
         """Send webhook with retry logic"""
+        # Schema version 2: retries share the idempotency key, so the
+        # server runs the event once
+        payload.setdefault("schema_version", 2)
+        payload.setdefault("idempotency_key", str(uuid.uuid4()))
         signature = self._generate_signature(payload)
 
         self.logger.debug(f"Sending webhook: {json.dumps(payload)}")
@@ -167,6 +193,7 @@ class WebhookClient:
                     response_data = response.read().decode('utf-8')
                     self.logger.info(f"Webhook sent successfully: {response.status}")
                     self.logger.debug(f"Response: {response_data}")
+                    self._log_queue(response_data)
                     return True
 
             except HTTPError as e:
@@ -204,6 +231,11 @@ def handle_createacct(config, logger, client, data):
         "domain": domain,
         "user": user
     }
+    # Package selects the CDN profile; owner is the reseller for quotas
+    if data.get('plan'):
+        payload["package"] = data.get('plan')
+    if data.get('owner') and data.get('owner') != 'root':
+        payload["reseller"] = data.get('owner')
 
     logger.info(f"Account created: {domain} (user: {user})")
 
@@ -258,6 +290,52 @@ def handle_parksubdomain(config, logger, client, data):
     return 1
 
 
+def handle_deladdondomain(config, logger, client, data):
+    """Handle addon domain removal event"""
+    domain = data.get('domain')
+    user = data.get('user')
+
+    if not domain:
+        logger.error("No domain in deladdondomain data")
+        return 1
+
+    payload = {
+        "event": "addon_deleted",
+        "domain": domain,
+        "user": user
+    }
+
+    logger.info(f"Addon domain removed: {domain} (user: {user})")
+
+    if client.send(payload):
+        return 0
+    return 1
+
+
+def handle_delsubdomain(config, logger, client, data):
+    """Handle subdomain removal event"""
+    subdomain = data.get('subdomain')
+    parentdomain = data.get('rootdomain')
+    user = data.get('user')
+
+    if not subdomain:
+        logger.error("No subdomain in delsubdomain data")
+        return 1
+
+    payload = {
+        "event": "subdomain_deleted",
+        "subdomain": subdomain,
+        "parent_domain": parentdomain,
+        "user": user
+    }
+
+    logger.info(f"Subdomain removed: {subdomain}.{parentdomain} (user: {user})")
+
+    if client.send(payload):
+        return 0
+    return 1
+
+
 def handle_killacct(config, logger, client, data):
     """Handle account termination event"""
     domain = data.get('domain')
@@ -285,6 +363,45 @@ def handle_killacct(config, logger, client, data):
     return 1
 
 
+def account_domain(user):
+    """Read the main domain of an account from its cPanel user file"""
+    try:
+        with open(f"/var/cpanel/users/{user}", 'r') as f:
+            for line in f:
+                if line.startswith("DNS="):
+                    return line.strip().split("=", 1)[1]
+    except IOError:
+        pass
+    return None
+
+
+def handle_changepackage(config, logger, client, data):
+    """Handle account package change event"""
+    user = data.get('user')
+    package = data.get('new_pkg')
+    domain = data.get('domain') or account_domain(user)
+
+    if not user or not package:
+        logger.error("No user or new_pkg in changepackage data")
+        return 1
+    if not domain:
+        logger.error(f"No domain found for user {user}")
+        return 1
+
+    payload = {
+        "event": "package_changed",
+        "domain": domain,
+        "user": user,
+        "package": package
+    }
+
+    logger.info(f"Package changed: {user} {data.get('cur_pkg', '')} -> {package}")
+
+    if client.send(payload):
+        return 0
+    return 1
+
+
 def main():
     """Main entry point"""
     # Load configuration
@@ -296,7 +413,7 @@ def main():
     if len(sys.argv) < 2:
         logger.error("Usage: whm_hook.py <event_type> [data_json]")
         print("Usage: whm_hook.py <event_type> [data_json]")
-        print("Event types: createacct, addaddondomain, parksubdomain, killacct")
+        print("Event types: createacct, addaddondomain, parksubdomain, deladdondomain, delsubdomain, killacct, changepackage")
         return 1
 
     event_type = sys.argv[1]
@@ -321,7 +438,10 @@ def main():
         'createacct': handle_createacct,
         'addaddondomain': handle_addaddondomain,
         'parksubdomain': handle_parksubdomain,
+        'deladdondomain': handle_deladdondomain,
+        'delsubdomain': handle_delsubdomain,
         'killacct': handle_killacct,
+        'changepackage': handle_changepackage,
     }
 
     handler = handlers.get(event_type)
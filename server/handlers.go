@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/internal/api"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/statuspage"
+)
+
+// contextKey is a custom type for context keys to avoid collisions
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
+// Handler returns the router serving the webhook, health, API, status page
+// and debug endpoints
+func (s *Server) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.Heartbeat("/ping"))
+
+	// Custom middleware for request context
+	r.Use(requestContextMiddleware(s.logger))
+	r.Use(serverHeaderMiddleware(s.config.InstanceName()))
+
+	// Routes
+	r.Post("/hook", s.webhook.ServeHTTP)
+	r.Get("/health", s.healthHandler)
+	r.Get("/ready", s.readyHandler)
+
+	// Management API
+	if s.config.API.Enabled {
+		apiHandler := api.NewHandler(s.provisioner, s.config.APIToken(), s.logger)
+		apiHandler.SetTokens(s.apiTokens)
+		apiHandler.SetAudit(s.audit)
+		apiHandler.SetService(s.service)
+		apiHandler.SetConfig(s.config)
+		apiHandler.SetFlags(s.flags)
+		apiHandler.SetIncidents(s.incidents)
+		apiHandler.SetPause(s.pause, s.webhook.ResumeQueue, s.webhook.Queued)
+		apiHandler.SetServer(s.config.InstanceName())
+		if s.balance != nil {
+			apiHandler.SetBalance(s.balance)
+		}
+		if s.runLogs != nil {
+			apiHandler.SetRunLogs(s.runLogs)
+		}
+		if s.config.StatusLinks.Enabled {
+			apiHandler.SetStatusLinks(s.config.StatusLinks)
+		}
+		r.Mount("/api/v1", apiHandler.Routes())
+		r.Get("/api/docs", api.DocsHandler().ServeHTTP)
+	}
+
+	// Public status pages of signed links
+	if s.config.StatusLinks.Enabled {
+		pages := statuspage.NewHandler(statuspage.NewSigner(s.config.StatusLinks.Secret), s.service, s.logger)
+		pages.SetClock(s.clock)
+		r.Mount("/status", pages.Routes())
+	}
+
+	// Debug routes
+	if s.debug {
+		r.Route("/debug", func(r chi.Router) {
+			r.Get("/pending", s.debugPendingHandler)
+			r.Get("/last-error", s.debugLastErrorHandler)
+			r.Post("/retry/{id}", s.debugRetryHandler)
+			r.Get("/state", s.debugStateHandler)
+			r.Get("/bunny", s.debugBunnyHandler)
+		})
+	}
+
+	return r
+}
+
+// requestContextMiddleware adds request context to each request
+func requestContextMiddleware(l *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), loggerKey, l)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// serverHeader names the instance that answered, for clients of a fleet
+const serverHeader = "X-Whm2bunny-Server"
+
+// serverHeaderMiddleware sets the serverHeader of every response to name
+func serverHeaderMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(serverHeader, name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// healthHandler returns the health status of the service
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":  "healthy",
+		"server":  s.config.InstanceName(),
+		"uptime":  time.Since(s.started).String(),
+		"version": s.version,
+	}
+
+	// The reason is free text; "whm2bunny maintenance status" shows it
+	if s.maintenance.Status().Active {
+		response["status"] = "maintenance"
+	}
+
+	// Who paused the pipeline and why is shown by the API only
+	if s.pause.Status().Paused {
+		response["status"] = "paused"
+	}
+
+	// The balance itself is account data, shown by the API only
+	if s.balance != nil {
+		status := s.balance.Status()
+		if status.Low {
+			response["status"] = "degraded"
+		}
+		response["balance"] = map[string]interface{}{
+			"low":               status.Low,
+			"pull_zones_paused": status.Paused,
+		}
+	}
+
+	// The zones name customer domains, so they are listed by the API only
+	if zones := s.provisioner.DisabledZones(); len(zones) > 0 {
+		response["status"] = "degraded"
+		response["dns_zones"] = map[string]interface{}{
+			"disabled": len(zones),
+		}
+	}
+
+	if queue := s.telegram.Queue(); queue != nil {
+		status := queue.Status()
+		if status.FailingSince != nil {
+			response["status"] = "degraded"
+		}
+		response["telegram"] = status
+	}
+
+	if s.quota.Enabled() {
+		response["quota"] = s.quota.GlobalUsage()
+	}
+
+	// Open incidents are alerts waiting for recovery, not a fault of the
+	// server, so they leave the status alone
+	active := s.incidents.Active()
+	unacked := 0
+	for _, inc := range active {
+		if inc.Status == incident.StatusOpen {
+			unacked++
+		}
+	}
+	response["incidents"] = map[string]interface{}{
+		"open":           len(active),
+		"unacknowledged": unacked,
+	}
+
+	// Pending provisions form the queue; provisions are run by webhooks and recovery
+	counts := s.states.CountByStatus()
+	response["queue"] = map[string]interface{}{
+		"depth":     counts[state.StatusPending],
+		"by_status": counts,
+		"in_flight": s.provisioner.InFlight(),
+		"webhooks":  s.webhook.Queued(),
+	}
+
+	response["recovery"] = s.provisioner.RecoveryProgress()
+	response["webhook"] = s.webhook.Stats()
+
+	if s.observer != nil {
+		response["provisioning"] = map[string]interface{}{
+			"enabled":  false,
+			"observed": len(s.observer.Observations()),
+		}
+	}
+
+	bunnyStatus := map[string]interface{}{}
+	if last := s.bunny.LastSuccess(); !last.IsZero() {
+		bunnyStatus["last_success"] = last
+	}
+	if rate := s.bunny.FaultRate(); rate > 0 {
+		bunnyStatus["fault_rate"] = rate
+	}
+	if families := s.bunny.Health(); len(families) > 0 {
+		bunnyStatus["families"] = families
+	}
+	response["bunny"] = bunnyStatus
+
+	if s.scheduler != nil {
+		response["scheduler"] = map[string]interface{}{
+			"next_runs": s.scheduler.NextRuns(),
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// readyHandler checks if the service is ready to accept requests
+// A degraded Bunny API family leaves the service ready, since webhooks are
+// still accepted and provisions retried, but is reported with its health
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"bunny": "ok",
+		"state": "ok",
+	}
+
+	var degraded []bunny.FamilyHealth
+	for _, h := range s.bunny.Health() {
+		if h.Degraded {
+			degraded = append(degraded, h)
+		}
+	}
+	if len(degraded) > 0 {
+		checks["bunny"] = "degraded"
+	}
+
+	// Check Telegram connectivity
+	if s.telegram.IsEnabled() {
+		checks["telegram"] = "ok"
+	} else {
+		checks["telegram"] = "disabled"
+	}
+
+	response := map[string]interface{}{
+		"ready":  true,
+		"checks": checks,
+	}
+	if len(degraded) > 0 {
+		response["degraded"] = degraded
+	}
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// debugBunnyHandler lists the calls of each Bunny API endpoint since start
+// and the availability of each endpoint family
+func (s *Server) debugBunnyHandler(w http.ResponseWriter, r *http.Request) {
+	objective := s.bunny.Objective()
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"objective": map[string]interface{}{
+			"window":       objective.Window.String(),
+			"availability": objective.Availability,
+			"min_requests": objective.MinRequests,
+		},
+		"families":  s.bunny.Health(),
+		"endpoints": s.bunny.Endpoints(),
+	})
+}
+
+// debugPendingHandler lists pending provisioning operations
+func (s *Server) debugPendingHandler(w http.ResponseWriter, r *http.Request) {
+	pending := s.states.ListPending()
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  len(pending),
+		"states": pending,
+	})
+}
+
+// debugLastErrorHandler returns the last 10 errors with details
+func (s *Server) debugLastErrorHandler(w http.ResponseWriter, r *http.Request) {
+	failed := s.states.ListFailed()
+
+	// Limit to last 10 errors
+	count := len(failed)
+	if count > 10 {
+		failed = failed[len(failed)-10:]
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"total_failed": count,
+		"showing":      len(failed),
+		"errors":       failed,
+	})
+}
+
+// debugRetryHandler retries a failed provisioning operation
+func (s *Server) debugRetryHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		s.respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "id parameter is required",
+		})
+		return
+	}
+
+	// Get the state
+	st, err := s.states.Get(id)
+	if err != nil {
+		s.respondJSON(w, http.StatusNotFound, map[string]string{
+			"error": "state not found",
+		})
+		return
+	}
+
+	// Reset to pending and retry in background
+	if _, err := s.service.Retry(st.Domain); err != nil {
+		if errors.Is(err, app.ErrNotFailed) {
+			s.respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "state is not in failed status",
+			})
+			return
+		}
+		s.respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to update state",
+		})
+		return
+	}
+
+	s.respondJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "retry scheduled",
+		"id":      id,
+		"domain":  st.Domain,
+	})
+}
+
+// debugStateHandler returns all states
+func (s *Server) debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	states := s.states.ListAll()
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"total":  len(states),
+		"states": states,
+	})
+}
+
+// respondJSON writes a JSON response
+func (s *Server) respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if data != nil {
+		buf, err := json.Marshal(data)
+		if err != nil {
+			s.logger.Error("Failed to marshal JSON response", zap.Error(err))
+			return
+		}
+		if _, err := w.Write(buf); err != nil {
+			s.logger.Error("Failed to write response", zap.Error(err))
+		}
+	}
+}
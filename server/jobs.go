@@ -0,0 +1,527 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/balance"
+	"github.com/mordenhost/whm2bunny/internal/bot"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/maintenance"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/pause"
+)
+
+// startJobs starts the background jobs; they end when Stop cancels s.ctx
+func (s *Server) startJobs() {
+	cfg := s.config
+
+	s.run(func(ctx context.Context) {
+		s.maintenance.Watch(ctx, maintenance.DefaultPollInterval, s.onMaintenanceChange)
+	})
+	s.run(func(ctx context.Context) {
+		s.pause.Watch(ctx, pause.DefaultPollInterval, s.onPauseChange)
+	})
+
+	// Observe-only servers never call Bunny: they only record the cPanel
+	// domains that would be provisioned
+	if s.observer != nil {
+		s.logger.Warn("Provisioning disabled, recording what would be provisioned")
+		if cfg.Discovery.Enabled {
+			s.run(func(ctx context.Context) { s.runObservedDiscovery(ctx, cfg.Discovery) })
+		}
+		if s.telegram.Queue() != nil {
+			s.run(s.telegram.RunQueue)
+		}
+		return
+	}
+
+	if s.balance != nil {
+		s.run(func(ctx context.Context) {
+			s.balance.Watch(ctx, s.onBalanceChange)
+		})
+	}
+
+	// Open an incident while a part of the Bunny API keeps failing
+	if cfg.Bunny.Health.Enabled {
+		s.run(func(ctx context.Context) { s.runBunnyHealth(ctx, cfg.Bunny.Health) })
+	}
+
+	// Enforce pull zone settings against profiles
+	if cfg.Drift.Enabled {
+		s.run(func(ctx context.Context) { s.runDriftEnforcement(ctx, cfg.Drift) })
+	}
+
+	// Alert when Bunny disables a managed DNS zone
+	if cfg.ZoneMonitor.Enabled {
+		s.run(func(ctx context.Context) { s.runZoneMonitor(ctx, cfg.ZoneMonitor) })
+	}
+
+	// Provision cPanel subdomains missed by the webhook
+	if cfg.Discovery.Enabled {
+		s.run(func(ctx context.Context) { s.runSubdomainDiscovery(ctx, cfg.Discovery) })
+	}
+
+	// Archive old successful provisions out of the active state
+	if cfg.Archive.Enabled {
+		s.run(func(ctx context.Context) { s.runStateCompaction(ctx, cfg.Archive) })
+	}
+
+	// Roll up and expire bandwidth snapshots
+	if s.snapshots != nil {
+		s.run(func(ctx context.Context) { s.runSnapshotCompaction(ctx, cfg.Snapshots) })
+	}
+
+	// Monitor certificate expiry and send reminders
+	s.run(func(ctx context.Context) { s.runCertificateMonitor(ctx, cfg.Certificates) })
+
+	// Retry the notifications Telegram did not accept
+	if s.telegram.Queue() != nil {
+		s.run(s.telegram.RunQueue)
+	}
+
+	// Answer operator commands in the Telegram chat
+	if cfg.Telegram.Commands && s.telegram.IsEnabled() {
+		commands := bot.NewCommands(s.service)
+		commands.SetIncidents(s.incidents)
+		s.telegram.EnableAckButtons()
+		s.run(func(ctx context.Context) {
+			if err := s.telegram.ListenCommands(ctx, commands.Handle); err != nil {
+				s.logger.Warn("Telegram commands unavailable", zap.Error(err))
+			}
+		})
+		s.logger.Info("Telegram commands enabled")
+	}
+
+	if s.scheduler != nil {
+		if err := s.scheduler.Start(); err != nil {
+			s.logger.Warn("Failed to start scheduler", zap.Error(err))
+		} else {
+			s.logger.Info("Scheduler started",
+				zap.String("daily_schedule", cfg.Telegram.Summary.Schedule),
+				zap.String("weekly_schedule", cfg.Telegram.Summary.WeeklySchedule),
+				zap.Bool("email", cfg.Notifications.Email.Enabled),
+			)
+		}
+	}
+
+	// Upload encrypted backups of the state, audit log and DNS zones
+	if s.backups != nil {
+		s.run(func(ctx context.Context) { s.runBackups(ctx, cfg.Backup) })
+	}
+
+	// Recover pending/failed provisions
+	s.run(s.recoverPendingProvisions)
+}
+
+// run runs a background job until s.ctx is cancelled
+func (s *Server) run(job func(ctx context.Context)) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		job(s.ctx)
+	}()
+}
+
+// resumeQueued resumes the pending provisions in the background
+func (s *Server) resumeQueued() {
+	s.run(func(ctx context.Context) {
+		if err := s.provisioner.Recover(ctx); err != nil {
+			s.logger.Error("Resuming queued provisions failed", zap.Error(err))
+		}
+	})
+}
+
+// recoverPendingProvisions recovers pending/failed provisions on startup
+// Runs in background with backoff delay between each domain
+func (s *Server) recoverPendingProvisions(ctx context.Context) {
+	// Wait a few seconds after server starts before recovery
+	select {
+	case <-time.After(recoveryDelay):
+	case <-ctx.Done():
+		return
+	}
+
+	// A paused pipeline recovers once it is resumed
+	if s.pause.Paused() {
+		s.logger.Info("Provisioning paused, deferring recovery")
+		return
+	}
+
+	pendingCount := len(s.states.Recover())
+	if pendingCount == 0 {
+		s.logger.Info("No pending/failed provisions to recover")
+		return
+	}
+
+	s.logger.Info("Starting recovery of pending/failed provisions",
+		zap.Int("count", pendingCount),
+	)
+
+	if err := s.provisioner.Recover(ctx); err != nil {
+		s.logger.Error("Recovery failed", zap.Error(err))
+	}
+}
+
+// onMaintenanceChange notifies about maintenance transitions and resumes
+// queued provisions when maintenance ends
+func (s *Server) onMaintenanceChange(prev, cur maintenance.Status) {
+	ctx := context.Background()
+
+	if cur.Active {
+		if err := s.telegram.NotifyMaintenance(ctx, true, cur.Reason, 0); err != nil {
+			s.logger.Warn("Failed to send maintenance notification", zap.Error(err))
+		}
+		return
+	}
+
+	queued := len(s.states.ListPending())
+	if err := s.telegram.NotifyMaintenance(ctx, false, prev.Reason, queued); err != nil {
+		s.logger.Warn("Failed to send maintenance notification", zap.Error(err))
+	}
+
+	if queued > 0 {
+		s.logger.Info("Maintenance ended, resuming queued provisions", zap.Int("count", queued))
+		s.resumeQueued()
+	}
+}
+
+// onPauseChange starts the webhook events queued while the pipeline was
+// paused, and the pending provisions whose recovery was deferred
+func (s *Server) onPauseChange(prev, cur pause.Status) {
+	if cur.Paused {
+		s.logger.Warn("Provisioning paused, queueing webhook events",
+			zap.String("reason", cur.Reason),
+			zap.String("by", cur.By),
+		)
+		return
+	}
+
+	s.logger.Info("Provisioning resumed, starting queued webhook events",
+		zap.Int("queued", s.webhook.Queued()),
+	)
+	s.webhook.ResumeQueue()
+	if len(s.states.Recover()) > 0 {
+		s.resumeQueued()
+	}
+}
+
+// onBalanceChange alerts when the Bunny balance crosses the threshold and
+// resumes queued provisions once it recovers
+func (s *Server) onBalanceChange(prev, cur balance.Status) {
+	ctx := context.Background()
+
+	if err := s.telegram.NotifyBalance(ctx, cur.Low, cur.Balance, cur.Threshold, cur.Paused); err != nil {
+		s.logger.Warn("Failed to send balance notification", zap.Error(err))
+	}
+
+	if cur.Low || !prev.Paused {
+		return
+	}
+
+	if queued := len(s.states.ListPending()); queued > 0 {
+		s.logger.Info("Bunny balance recovered, resuming queued provisions", zap.Int("count", queued))
+		s.resumeQueued()
+	}
+}
+
+// every calls fn at once, then every interval until ctx is done
+func every(ctx context.Context, interval time.Duration, fn func()) {
+	fn()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+// runBunnyHealth periodically checks the availability of the Bunny API
+// endpoint families, opening a bunny_api incident for each degraded family
+// and resolving it once the family recovers
+func (s *Server) runBunnyHealth(ctx context.Context, cfg config.BunnyHealthConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultBunnyHealthInterval
+	}
+
+	every(ctx, interval, func() { s.checkBunnyHealth(ctx) })
+}
+
+// checkBunnyHealth fires or resolves the incident of each endpoint family.
+// A family without calls in the window has recovered as far as we know
+func (s *Server) checkBunnyHealth(ctx context.Context) {
+	degraded := make(map[bunny.Family]bunny.FamilyHealth)
+	for _, h := range s.bunny.Health() {
+		if h.Degraded {
+			degraded[h.Family] = h
+		}
+	}
+	objective := s.bunny.Objective()
+
+	for _, family := range bunny.Families {
+		api := family.Name()
+		if h, ok := degraded[family]; ok {
+			inc, opened, err := s.incidents.FireAPI(api, h.Availability, objective.Availability)
+			if err != nil {
+				s.logger.Error("Failed to record incident", zap.String("api", api), zap.Error(err))
+				continue
+			}
+			if !opened {
+				continue
+			}
+			s.logger.Warn("Bunny API degraded",
+				zap.String("incident", inc.ID),
+				zap.String("family", string(family)),
+				zap.Int("requests", h.Requests),
+				zap.Int("errors", h.Errors),
+				zap.Float64("availability", h.Availability),
+			)
+			if err := s.telegram.NotifyIncident(ctx, notifier.IncidentMessage{
+				ID:        inc.ID,
+				Kind:      string(inc.Kind),
+				Zone:      api,
+				Value:     h.Availability,
+				Threshold: objective.Availability,
+			}); err != nil {
+				s.logger.Warn("Failed to send incident notification", zap.String("incident", inc.ID), zap.Error(err))
+			}
+			continue
+		}
+
+		inc, resolved, err := s.incidents.ResolveAPI(api)
+		if err != nil {
+			s.logger.Error("Failed to resolve incident", zap.String("api", api), zap.Error(err))
+			continue
+		}
+		if !resolved {
+			continue
+		}
+		s.logger.Info("Bunny API recovered", zap.String("incident", inc.ID), zap.String("family", string(family)))
+		if err := s.telegram.NotifyIncident(ctx, notifier.IncidentMessage{
+			ID:        inc.ID,
+			Kind:      string(inc.Kind),
+			Zone:      api,
+			Threshold: inc.Threshold,
+			Resolved:  true,
+			OpenedAt:  inc.OpenedAt,
+			Alerts:    inc.Alerts,
+			AckedBy:   inc.AckedBy,
+		}); err != nil {
+			s.logger.Warn("Failed to send incident notification", zap.String("incident", inc.ID), zap.Error(err))
+		}
+	}
+}
+
+// runDriftEnforcement periodically compares pull zones with their profiles
+// and reports (and optionally corrects) any drift
+func (s *Server) runDriftEnforcement(ctx context.Context, cfg config.DriftConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultDriftInterval
+	}
+
+	// Unlike the other jobs, drift is first checked after one interval
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(interval):
+	}
+
+	every(ctx, interval, func() {
+		report := s.provisioner.EnforceDrift(ctx, cfg.Fix)
+		if len(report) == 0 {
+			return
+		}
+
+		drifted := make(map[string][]string, len(report))
+		for domain, drifts := range report {
+			for _, d := range drifts {
+				drifted[domain] = append(drifted[domain], d.Field)
+			}
+		}
+		s.logger.Info("Pull zone drift detected",
+			zap.Int("domains", len(report)),
+			zap.Bool("fix", cfg.Fix),
+		)
+		if err := s.telegram.NotifyDrift(ctx, drifted, cfg.Fix); err != nil {
+			s.logger.Warn("Failed to send drift notification", zap.Error(err))
+		}
+	})
+}
+
+// runZoneMonitor periodically checks the DNS zones of provisioned domains
+// and alerts as soon as Bunny disables one, and again once it is back
+func (s *Server) runZoneMonitor(ctx context.Context, cfg config.ZoneMonitorConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultZoneMonitorInterval
+	}
+
+	every(ctx, interval, func() {
+		disabled, restored := s.provisioner.CheckZones(ctx)
+		for _, z := range disabled {
+			if err := s.telegram.NotifyZoneDisabled(ctx, z.Domain, z.ZoneID, true, z.Reason); err != nil {
+				s.logger.Warn("Failed to send zone disabled notification", zap.Error(err))
+			}
+		}
+		for _, z := range restored {
+			if err := s.telegram.NotifyZoneDisabled(ctx, z.Domain, z.ZoneID, false, ""); err != nil {
+				s.logger.Warn("Failed to send zone enabled notification", zap.Error(err))
+			}
+		}
+	})
+}
+
+// runSubdomainDiscovery periodically provisions cPanel subdomains of
+// provisioned domains that have no provisioning state
+func (s *Server) runSubdomainDiscovery(ctx context.Context, cfg config.DiscoveryConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultDiscoveryInterval
+	}
+
+	every(ctx, interval, func() {
+		found, err := s.provisioner.DiscoverSubdomains(ctx, true)
+		if err != nil {
+			s.logger.Warn("Subdomain discovery failed", zap.Error(err))
+			return
+		}
+		if len(found) > 0 {
+			s.logger.Info("Provisioned discovered subdomains", zap.Int("subdomains", len(found)))
+		}
+	})
+}
+
+// runObservedDiscovery periodically records the cPanel domains without a
+// provisioning state while provisioning is disabled
+func (s *Server) runObservedDiscovery(ctx context.Context, cfg config.DiscoveryConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultDiscoveryInterval
+	}
+
+	every(ctx, interval, func() {
+		found, err := s.observer.Discover(ctx)
+		if err != nil {
+			s.logger.Warn("Domain discovery failed", zap.Error(err))
+			return
+		}
+		if found > 0 {
+			s.logger.Info("Observed domains that would be provisioned", zap.Int("domains", found))
+		}
+	})
+}
+
+// runStateCompaction periodically moves old successful provisions into the
+// archive file so the active state stays small
+func (s *Server) runStateCompaction(ctx context.Context, cfg config.ArchiveConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultArchiveInterval
+	}
+	olderThan := time.Duration(cfg.AfterDays) * 24 * time.Hour
+
+	every(ctx, interval, func() {
+		n, err := s.states.Archive(olderThan)
+		if err != nil {
+			s.logger.Warn("State compaction failed", zap.Error(err))
+			return
+		}
+		if n > 0 {
+			s.logger.Info("State compacted", zap.Int("archived", n))
+		}
+	})
+}
+
+// runSnapshotCompaction periodically rolls old bandwidth snapshots up into
+// daily ones and removes those past the retention
+func (s *Server) runSnapshotCompaction(ctx context.Context, cfg config.SnapshotsConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultSnapshotInterval
+	}
+
+	every(ctx, interval, func() {
+		result, err := s.snapshots.Compact()
+		if err != nil {
+			s.logger.Warn("Snapshot compaction failed", zap.Error(err))
+			return
+		}
+		if result.RolledUp > 0 || result.Removed > 0 {
+			s.logger.Info("Snapshots compacted",
+				zap.Int("rolled_up", result.RolledUp),
+				zap.Int("removed", result.Removed),
+			)
+		}
+	})
+}
+
+// runCertificateMonitor periodically records the expiry of managed
+// certificates and sends reminders for certificates approaching expiry
+func (s *Server) runCertificateMonitor(ctx context.Context, cfg config.CertificatesConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = config.DefaultCertificateCheckInterval
+	}
+
+	every(ctx, interval, func() {
+		if cfg.Monitor {
+			n := s.provisioner.MonitorCertificates(ctx)
+			s.logger.Debug("Checked pull zone certificates", zap.Int("pull_zones", n))
+		}
+		if n := s.provisioner.RemindCertificateExpiry(ctx); n > 0 {
+			s.logger.Info("Sent certificate expiry reminders", zap.Int("count", n))
+		}
+	})
+}
+
+// runBackups makes a backup at each time of the backup schedule
+func (s *Server) runBackups(ctx context.Context, cfg config.BackupConfig) {
+	// The schedule was validated with the configuration
+	schedule, err := cron.ParseStandard(cfg.Schedule)
+	if err != nil {
+		s.logger.Error("Invalid backup schedule", zap.Error(err))
+		return
+	}
+	s.logger.Info("Backups scheduled", zap.String("schedule", cfg.Schedule), zap.String("storage", cfg.Storage))
+
+	for {
+		next := schedule.Next(s.clock.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		result, err := s.backups.Run(ctx, s.clock.Now())
+		if err != nil {
+			s.logger.Error("Backup failed", zap.Error(err))
+			continue
+		}
+		if err := s.audit.Record(audit.Entry{
+			Actor:  "backup",
+			Action: "backup.run",
+			Details: map[string]string{
+				"name":    result.Name,
+				"domains": strconv.Itoa(result.Manifest.Domains),
+				"zones":   strconv.Itoa(len(result.Manifest.Zones)),
+				"pruned":  strconv.Itoa(len(result.Pruned)),
+			},
+		}); err != nil {
+			s.logger.Warn("Failed to audit backup", zap.Error(err))
+		}
+	}
+}
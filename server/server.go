@@ -0,0 +1,799 @@
+// Package server runs whm2bunny: the webhook receiver, the management API,
+// provisioning recovery and the background jobs. It is what "whm2bunny
+// serve" runs, and can be embedded in other programs; each Server keeps its
+// own state, so several can run in one process.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/apitoken"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/audit"
+	"github.com/mordenhost/whm2bunny/internal/backup"
+	"github.com/mordenhost/whm2bunny/internal/balance"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/clock"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/email"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/failures"
+	"github.com/mordenhost/whm2bunny/internal/flags"
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+	"github.com/mordenhost/whm2bunny/internal/hooks"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/maintenance"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/observe"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/pause"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/proxy"
+	"github.com/mordenhost/whm2bunny/internal/quota"
+	"github.com/mordenhost/whm2bunny/internal/runlog"
+	"github.com/mordenhost/whm2bunny/internal/scheduler"
+	"github.com/mordenhost/whm2bunny/internal/slo"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/status"
+	"github.com/mordenhost/whm2bunny/internal/tokenauth"
+	"github.com/mordenhost/whm2bunny/internal/webhook"
+	"github.com/mordenhost/whm2bunny/internal/whm"
+)
+
+const (
+	// DefaultStateFile is the state file used when none is configured
+	DefaultStateFile = "/var/lib/whm2bunny/state.json"
+	// defaultSnapshotFile is used when the state file is not named state.json
+	defaultSnapshotFile = "/var/lib/whm2bunny/snapshots.json"
+
+	// recoveryDelay is how long after starting pending provisions are resumed
+	recoveryDelay = 5 * time.Second
+	// shutdownTimeout bounds the graceful shutdown of the HTTP server when
+	// Stop is given a context without deadline
+	shutdownTimeout = 30 * time.Second
+)
+
+// Server runs whm2bunny
+type Server struct {
+	config    *config.Config
+	logger    *zap.Logger
+	stateFile string
+	version   string
+	debug     bool
+	clock     clock.Clock
+
+	bunny       *bunny.Client
+	states      *state.Manager
+	telegram    *notifier.TelegramNotifier
+	provisioner *provisioner.Provisioner
+	// observer receives the webhook events while provisioning is disabled
+	observer    *provisioner.Observer
+	service     *app.Service
+	scheduler   *scheduler.Scheduler
+	snapshots   *state.SnapshotStore
+	maintenance *maintenance.Manager
+	pause       *pause.Store
+	balance     *balance.Guard
+	quota       *quota.Manager
+	incidents   *incident.Store
+	audit       *audit.Log
+	webhook     *webhook.Handler
+	apiTokens   *apitoken.Store
+	backups     *backup.Manager
+	runLogs     *runlog.Store
+	flags       *flags.Set
+
+	http     *http.Server
+	listener net.Listener
+	started  time.Time
+
+	// ctx is cancelled by Stop to end the background jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithLogger sets the logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithStateFile sets the state file; the other data files are kept next to it
+func WithStateFile(path string) Option {
+	return func(s *Server) {
+		s.stateFile = path
+	}
+}
+
+// WithVersion sets the version reported by /health
+func WithVersion(version string) Option {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+// WithDebug enables the /debug endpoints
+func WithDebug(debug bool) Option {
+	return func(s *Server) {
+		s.debug = debug
+	}
+}
+
+// WithClock sets the clock of the state, snapshots, notifications,
+// summaries and webhook debouncing, for tests of time-based behaviour
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
+}
+
+// DataFile returns the path of an auxiliary data file stored next to the
+// state file
+func DataFile(stateFile, name string) string {
+	return filepath.Join(filepath.Dir(stateFile), name)
+}
+
+// SnapshotFile returns the bandwidth snapshot file, next to a state file
+// named state.json
+func SnapshotFile(stateFile string) string {
+	if strings.HasSuffix(stateFile, "state.json") {
+		return stateFile[:len(stateFile)-len("state.json")] + "snapshots.json"
+	}
+	return defaultSnapshotFile
+}
+
+// New creates a server from the configuration, opening its state and data
+// files. Nothing runs until Start
+func New(cfg *config.Config, opts ...Option) (*Server, error) {
+	s := &Server{
+		config:    cfg,
+		stateFile: DefaultStateFile,
+		clock:     clock.Real{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger == nil {
+		s.logger = zap.NewNop()
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	if err := s.build(); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// build creates the components of the server
+func (s *Server) build() error {
+	cfg := s.config
+	var err error
+
+	// Every component logs through the run logs, which keep the entries
+	// of the runs in progress at the level of the log
+	if cfg.Logging.RunLogs > 0 {
+		s.runLogs = runlog.NewStore(cfg.Logging.RunLogs, cfg.Logging.RunLogEntries)
+		s.runLogs.SetClock(s.clock)
+		s.logger = s.logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(c, s.runLogs.Core(c))
+		}))
+	}
+	logger := s.logger
+
+	s.flags, err = cfg.FeatureFlags()
+	if err != nil {
+		return fmt.Errorf("invalid feature flags: %w", err)
+	}
+	for _, flag := range s.flags.States() {
+		if flag.Reserved && flag.Enabled {
+			logger.Warn("feature flag is reserved and has no effect yet",
+				zap.String("flag", flag.Name),
+				zap.String("source", string(flag.Source)),
+			)
+			continue
+		}
+		if flag.Source != flags.SourceDefault {
+			logger.Info("feature flag set",
+				zap.String("flag", flag.Name),
+				zap.Bool("enabled", flag.Enabled),
+				zap.String("source", string(flag.Source)),
+			)
+		}
+	}
+
+	bunnyProxy, err := cfg.Proxy.Proxy(config.ProxyBunny)
+	if err != nil {
+		return fmt.Errorf("invalid Bunny proxy: %w", err)
+	}
+	telegramProxy, err := cfg.Proxy.Proxy(config.ProxyTelegram)
+	if err != nil {
+		return fmt.Errorf("invalid Telegram proxy: %w", err)
+	}
+	if cfg.Proxy.Enabled() {
+		logger.Info("outbound proxy enabled",
+			zap.Stringer("bunny", bunnyProxy),
+			zap.Stringer("telegram", telegramProxy),
+			zap.Strings("no_proxy", cfg.Proxy.NoProxy),
+		)
+	}
+
+	bunnyOpts := []bunny.ClientOption{
+		bunny.WithBaseURL(cfg.Bunny.BaseURL),
+		bunny.WithLogger(logger),
+		bunny.WithHTTPClient(bunnyProxy.HTTPClient(bunny.DefaultTimeout)),
+	}
+	if health := cfg.Bunny.Health; health.Enabled {
+		bunnyOpts = append(bunnyOpts, bunny.WithObjective(bunny.Objective{
+			Window:       health.Window,
+			Availability: health.Availability,
+			MinRequests:  health.MinRequests,
+		}))
+	}
+	if chaos := cfg.Bunny.Chaos; chaos.Enabled {
+		faults := bunny.Faults{Rate: chaos.Rate, Delay: chaos.Delay}
+		for _, fault := range chaos.Faults {
+			faults.Kinds = append(faults.Kinds, bunny.Fault(fault))
+		}
+		bunnyOpts = append(bunnyOpts, bunny.WithFaults(faults))
+		logger.Warn("Bunny API fault injection enabled, do not use in production",
+			zap.Float64("rate", chaos.Rate),
+			zap.Strings("faults", chaos.Faults),
+		)
+	}
+	s.bunny = bunny.NewClient(cfg.Bunny.APIKey, bunnyOpts...)
+
+	// The state, snapshot and notification queue files share one keyring
+	keyring, err := encryption.Load(cfg.Encryption)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	if keyring.Encrypting() {
+		logger.Info("state files are encrypted at rest")
+	}
+
+	stateOpts := []state.Option{state.WithKeyring(keyring), state.WithServer(cfg.InstanceName())}
+	if cfg.State.Batching {
+		stateOpts = append(stateOpts, state.WithBatching(cfg.State.FlushInterval, cfg.State.FlushMutations))
+	}
+	s.states, err = state.NewManager(s.stateFile, logger, stateOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create state manager: %w", err)
+	}
+	s.states.SetClock(s.clock)
+
+	// Audit every status change of a provision
+	s.audit, err = audit.NewLog(s.dataFile("audit.log"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+	s.states.OnTransition(s.auditTransition)
+
+	if cfg.API.Enabled {
+		s.apiTokens, err = apitoken.NewStore(s.dataFile("api_tokens.json"), cfg.API.NamedTokens(), logger)
+		if err != nil {
+			return fmt.Errorf("failed to load API tokens: %w", err)
+		}
+	}
+
+	templates, err := notifier.LoadTemplates(cfg.Locale, cfg.Telegram.TemplatesDir)
+	if err != nil {
+		return fmt.Errorf("invalid notification templates: %w", err)
+	}
+
+	s.telegram, err = notifier.NewTelegramNotifier(
+		cfg.Telegram.BotToken,
+		cfg.Telegram.ChatID,
+		cfg.Telegram.Enabled,
+		cfg.Telegram.Events,
+		logger,
+		notifier.WithHTTPClient(telegramProxy.HTTPClient(0)),
+	)
+	switch {
+	case errors.Is(err, notifier.ErrUnreachable):
+		// Keep the notifier; messages are queued until Telegram answers
+		logger.Warn("Telegram unreachable, notifications will be queued", zap.Error(err))
+	case err != nil:
+		logger.Warn("Failed to initialize Telegram notifier", zap.Error(err))
+		// Continue without Telegram
+		s.telegram = &notifier.TelegramNotifier{}
+	}
+	s.telegram.SetTemplates(templates)
+	s.telegram.SetClock(s.clock)
+	s.telegram.SetServer(cfg.InstanceName())
+	routes, err := telegramRoutes(cfg.Telegram.Routes)
+	if err != nil {
+		return err
+	}
+	s.telegram.SetRoutes(routes)
+	if s.telegram.IsEnabled() {
+		queue, err := s.notificationQueue(cfg, keyring)
+		if err != nil {
+			return err
+		}
+		s.telegram.SetQueue(queue)
+	}
+
+	s.provisioner = provisioner.NewProvisioner(cfg, s.bunny, s.states, s.telegram, logger)
+
+	// Per-domain overrides (shared with CLI commands)
+	overrideManager, err := overrides.NewManager(s.dataFile("overrides.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create overrides manager: %w", err)
+	}
+	s.provisioner.SetOverrides(overrideManager)
+
+	tokenKeys, err := tokenauth.NewKeyStore(s.dataFile("token_keys.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create token key store: %w", err)
+	}
+	s.provisioner.SetTokenKeys(tokenKeys)
+
+	bypassStore, err := bypass.NewStore(s.dataFile("bypass.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create bypass store: %w", err)
+	}
+	s.provisioner.SetBypass(bypassStore)
+
+	freezeStore, err := freeze.NewStore(s.dataFile("freeze.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create freeze store: %w", err)
+	}
+	s.provisioner.SetFreeze(freezeStore)
+
+	// Manifest of DNS records disabled instead of deleted (shared with CLI commands)
+	disabledRecords, err := dnsrecords.NewStore(s.dataFile("dns_records.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS records manifest: %w", err)
+	}
+	s.provisioner.SetDisabledRecords(disabledRecords)
+
+	certStore, err := certs.NewStore(s.dataFile("certificates.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate store: %w", err)
+	}
+	s.provisioner.SetCertificates(certStore)
+
+	if len(cfg.Hooks) > 0 {
+		hookRunner := hooks.NewRunner(cfg.Hooks, logger)
+		hookRunner.SetAudit(s.audit)
+		s.provisioner.SetHooks(hookRunner)
+		logger.Info("provisioning hooks enabled", zap.Int("count", len(cfg.Hooks)))
+	}
+
+	sloStore, err := slo.NewStore(s.dataFile("provision_times.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create provisioning time store: %w", err)
+	}
+	s.provisioner.SetSLO(sloStore)
+
+	failureStore, err := failures.NewStore(s.dataFile("failures.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create failure count store: %w", err)
+	}
+	s.provisioner.SetFailures(failureStore)
+	s.provisioner.SetAudit(s.audit)
+
+	s.incidents, err = incident.NewStore(s.dataFile("incidents.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create incident store: %w", err)
+	}
+
+	if cfg.StatusFiles.Enabled {
+		statusWriter, err := status.NewWriter(cfg.StatusFiles.Dir, logger)
+		if err != nil {
+			return fmt.Errorf("failed to create status file writer: %w", err)
+		}
+		s.provisioner.SetStatusWriter(statusWriter)
+	}
+
+	s.maintenance, err = maintenance.NewManager(s.dataFile("maintenance.json"), cfg.Maintenance, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create maintenance manager: %w", err)
+	}
+	s.provisioner.SetMaintenance(s.maintenance)
+
+	s.pause, err = pause.NewStore(s.dataFile("pause.json"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create pause store: %w", err)
+	}
+
+	if cfg.Balance.Enabled && cfg.Provisioning.Enabled {
+		s.balance = balance.NewGuard(s.bunny, cfg.Balance, logger)
+		s.provisioner.SetBalanceGuard(s.balance)
+	}
+
+	if cfg.DNS.SOAEmailFromReseller {
+		whmClient, err := s.whmClient(cfg)
+		if err != nil {
+			return err
+		}
+		s.provisioner.SetResellerEmails(whmClient.AccountEmail)
+	}
+
+	// Observe-only servers record webhook events instead of acting on them
+	var target webhook.Provisioner = s.provisioner
+	if !cfg.Provisioning.Enabled {
+		observed, err := observe.NewStore(s.dataFile("observed.json"), logger)
+		if err != nil {
+			return fmt.Errorf("failed to create observation store: %w", err)
+		}
+		s.observer = provisioner.NewObserver(s.provisioner, observed)
+		target = s.observer
+	}
+
+	s.webhook = webhook.NewHandler(target, cfg.Webhook.Secret, logger)
+	s.webhook.SetDebounce(cfg.Webhook.Debounce)
+	s.webhook.SetMaxBodyBytes(cfg.Webhook.MaxBodyBytes)
+	s.webhook.SetBacklogThreshold(cfg.Webhook.BacklogThreshold)
+	s.webhook.SetClock(s.clock)
+	s.webhook.SetPause(s.pause)
+
+	s.quota, err = quota.NewManager(s.dataFile("quota.json"), cfg.Quota, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create quota manager: %w", err)
+	}
+	if s.quota.Enabled() {
+		s.webhook.SetQuota(s.quota)
+	}
+	s.webhook.SetAudit(s.audit)
+	s.webhook.SetOverrides(overrideManager)
+	if s.runLogs != nil {
+		s.webhook.SetRunLog(s.runLogs)
+	}
+
+	s.snapshots, err = state.NewSnapshotStore(SnapshotFile(s.stateFile), logger, state.WithKeyring(keyring))
+	if err != nil {
+		logger.Warn("Failed to create snapshot store", zap.Error(err))
+		// Continue without snapshot store
+		s.snapshots = nil
+	} else {
+		s.snapshots.SetClock(s.clock)
+		s.snapshots.SetRetention(state.SnapshotRetention{
+			Raw:   time.Duration(cfg.Snapshots.RawDays) * 24 * time.Hour,
+			Daily: time.Duration(cfg.Snapshots.DailyDays) * 24 * time.Hour,
+		})
+	}
+
+	s.service = app.NewService(s.provisioner, s.states, s.bunny, logger)
+	if s.snapshots != nil {
+		s.service.SetSnapshots(s.snapshots)
+	}
+	if s.runLogs != nil {
+		s.service.SetRunLog(s.runLogs)
+	}
+
+	// Summaries run if Telegram or email summaries are enabled
+	var emailSender *email.Sender
+	if cfg.Notifications.Email.Enabled {
+		emailSender = email.NewSender(cfg.Notifications.Email)
+	}
+	// Summaries read Bunny statistics, which observe-only servers never do
+	if (s.telegram.IsEnabled() || emailSender != nil) && cfg.Provisioning.Enabled {
+		s.scheduler = scheduler.NewScheduler(cfg, s.bunny, s.telegram, s.snapshots, logger)
+		s.scheduler.SetClock(s.clock)
+		s.scheduler.SetQuota(s.quota)
+		s.scheduler.SetStates(s.states)
+		s.scheduler.SetSLO(sloStore)
+		s.scheduler.SetFailures(failureStore)
+		s.scheduler.SetIncidents(s.incidents)
+		if emailSender != nil {
+			s.scheduler.SetEmail(emailSender)
+		}
+	}
+
+	// Backups of observe-only servers leave the DNS zones out, as they
+	// never call Bunny
+	if cfg.Backup.Enabled {
+		remote, err := backup.NewRemote(cfg.Backup, bunnyProxy.HTTPClient(backup.DefaultTimeout))
+		if err != nil {
+			return err
+		}
+		var zones backup.ZoneExporter
+		if cfg.Backup.Zones && cfg.Provisioning.Enabled {
+			zones = s.provisioner.ExportZone
+		}
+		archiver := backup.NewArchiver(s.states, s.stateFile, zones, logger)
+		s.backups = backup.NewManager(archiver, remote, keyring, cfg.Backup.Prefix, cfg.Backup.Retention, logger)
+	}
+
+	return nil
+}
+
+// auditTransition records a status change of a provision
+func (s *Server) auditTransition(st *state.ProvisionState, t state.Transition) {
+	err := s.audit.Record(audit.Entry{
+		Actor:  "provisioner",
+		Action: "state." + string(t.To),
+		Domain: t.Domain,
+		Details: map[string]string{
+			"from":   string(t.From),
+			"step":   st.StepName(),
+			"reason": t.Reason,
+		},
+	})
+	if err != nil {
+		s.logger.Error("Failed to audit state transition",
+			zap.String("domain", t.Domain),
+			zap.Error(err))
+	}
+}
+
+// notificationQueue creates the queue retrying the notifications Telegram
+// did not accept, with the configured fallback channel
+func (s *Server) notificationQueue(cfg *config.Config, keyring *encryption.Keyring) (*notifier.Queue, error) {
+	delivery := cfg.Telegram.Delivery
+	queue, err := notifier.NewQueue(s.dataFile("telegram_queue.json"), notifier.QueueConfig{
+		Backoff:       delivery.Backoff,
+		MaxBackoff:    delivery.MaxBackoff,
+		MaxAge:        delivery.MaxAge,
+		FallbackAfter: delivery.FallbackAfter,
+		Keyring:       keyring,
+	}, s.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notification queue: %w", err)
+	}
+	queue.SetClock(s.clock)
+
+	switch delivery.Fallback {
+	case config.FallbackEmail:
+		queue.SetFallback(email.NewFallback(email.NewSender(cfg.Notifications.Email), cfg.InstanceName()))
+	case config.FallbackWebhook:
+		p, err := proxy.New(cfg.Proxy.URL, cfg.Proxy.NoProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		queue.SetFallback(notifier.NewWebhookFallback(delivery.FallbackURL, cfg.InstanceName(), p.HTTPClient(30*time.Second)))
+	}
+	return queue, nil
+}
+
+// telegramRoutes converts telegram.routes to the notifier's routes
+func telegramRoutes(cfg map[string]config.TelegramRoute) (map[notifier.Category]notifier.Route, error) {
+	routes := make(map[notifier.Category]notifier.Route, len(cfg))
+	for category, r := range cfg {
+		route, err := notifier.ParseRoute(r.ChatID, r.ThreadID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid telegram.routes.%s: %w", category, err)
+		}
+		routes[notifier.Category(category)] = route
+	}
+	return routes, nil
+}
+
+// whmClient builds a WHM API client for the features that read accounts
+func (s *Server) whmClient(cfg *config.Config) (*whm.Client, error) {
+	whmProxy, err := cfg.Proxy.Proxy(config.ProxyWHM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WHM proxy: %w", err)
+	}
+
+	opts := []whm.ClientOption{
+		whm.WithHTTPClient(whmProxy.HTTPClient(cfg.WHM.Timeout)),
+		whm.WithLogger(s.logger),
+	}
+	if cfg.WHM.InsecureSkipVerify {
+		opts = append(opts, whm.WithInsecureSkipVerify())
+	}
+	return whm.NewClient(cfg.WHM.URL, cfg.WHM.Username, cfg.WHM.Token, opts...), nil
+}
+
+// dataFile returns the path of a data file of this server
+func (s *Server) dataFile(name string) string {
+	return DataFile(s.stateFile, name)
+}
+
+// logConfig logs the effective configuration with secrets redacted, the
+// enabled features and the defaults that were applied
+func (s *Server) logConfig() {
+	summary, err := s.config.Summary()
+	if err != nil {
+		s.logger.Warn("Failed to summarize configuration", zap.Error(err))
+		return
+	}
+	s.logger.Info("Effective configuration",
+		zap.Strings("sources", summary.Sources),
+		zap.Any("features", summary.Features),
+		zap.Strings("defaults", summary.Defaults),
+		zap.Any("config", summary.Config),
+	)
+}
+
+// Start listens on server.listen or server.host:server.port and starts the background jobs
+// and the recovery of pending provisions
+func (s *Server) Start() error {
+	cfg := s.config
+	s.started = time.Now()
+
+	ln, err := listen(cfg.Server)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+
+	s.logConfig()
+
+	s.http = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	s.startJobs()
+
+	go func() {
+		s.logger.Info("HTTP server started", zap.String("addr", ln.Addr().String()))
+		if err := s.http.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// socketProbeTimeout bounds the dial telling a live socket from a stale one
+const socketProbeTimeout = time.Second
+
+// listen opens the listener of the HTTP server: a Unix socket, replacing a
+// stale one left by a crash, or a TCP port
+func listen(cfg config.ServerConfig) (net.Listener, error) {
+	path, ok := cfg.UnixSocket()
+	if !ok {
+		addr := net.JoinHostPort(cfg.Host, fmt.Sprint(cfg.Port))
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return ln, nil
+	}
+
+	perm, err := cfg.SocketPerm()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only ever remove a socket, never a file the path was mistaken for,
+	// and only a stale one: a socket that answers is another daemon's
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, socketProbeTimeout); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// The socket is bound in a directory only the owner can enter and
+	// moved into place once it has perm, so it is never reachable with the
+	// default permissions. The umask would do, but is shared by every
+	// server of the process
+	dir, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bound := filepath.Join(dir, filepath.Base(path))
+	ln, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(bound, perm); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if err := os.Rename(bound, path); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return &socketListener{Listener: ln, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// socketListener is a Unix socket listener bound elsewhere and moved to
+// addr, which is removed when the listener is closed
+type socketListener struct {
+	net.Listener
+	addr *net.UnixAddr
+}
+
+// Addr returns the path the socket was moved to
+func (l *socketListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close closes the listener and removes its socket
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.addr.Name); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// Addr returns the address the server listens on, once started
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop shuts the server down gracefully: the HTTP server finishes its
+// requests, then the background jobs and the scheduler are stopped
+func (s *Server) Stop(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, shutdownTimeout)
+		defer cancel()
+	}
+
+	// Stop background watchers
+	s.cancel()
+
+	var err error
+	if s.http != nil {
+		s.logger.Info("Shutting down HTTP server...")
+		if err = s.http.Shutdown(ctx); err != nil {
+			s.logger.Error("HTTP server shutdown error", zap.Error(err))
+		}
+	}
+
+	// A provision being recovered finishes its current domain first
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("Background jobs still running at shutdown")
+	}
+
+	if s.scheduler != nil {
+		s.logger.Info("Stopping scheduler...")
+		s.scheduler.Stop()
+	}
+
+	// Write the last batch of state changes
+	if s.states != nil {
+		if closeErr := s.states.Close(); closeErr != nil {
+			s.logger.Error("Failed to flush state changes", zap.Error(closeErr))
+		}
+	}
+
+	s.logger.Info("Shutting down Telegram notifier...")
+	if shutdownErr := s.telegram.Shutdown(); shutdownErr != nil {
+		s.logger.Error("Telegram notifier shutdown error", zap.Error(shutdownErr))
+	}
+
+	s.logger.Info("Shutdown complete")
+	return err
+}
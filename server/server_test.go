@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/config"
+)
+
+// newTestServer starts a server on a free port with its state in a
+// temporary directory and a fake Bunny API
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+
+	bunnyAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"Items":[]}`))
+	}))
+	t.Cleanup(bunnyAPI.Close)
+
+	cfg := config.Defaults()
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = 0
+	cfg.Bunny.APIKey = "test-key"
+	cfg.Bunny.BaseURL = bunnyAPI.URL
+	cfg.Webhook.Secret = "test-secret"
+	cfg.API.Enabled = true
+	cfg.Certificates.Monitor = false
+
+	opts = append([]Option{WithStateFile(filepath.Join(t.TempDir(), "state.json")), WithVersion("test")}, opts...)
+	srv, err := New(&cfg, opts...)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, srv.Stop(ctx))
+	})
+	return srv
+}
+
+func get(t *testing.T, srv *Server, path string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://"+srv.Addr().String()+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestServer_MultipleInstances(t *testing.T) {
+	first := newTestServer(t, WithDebug(true))
+	second := newTestServer(t)
+	require.NotEqual(t, first.Addr().String(), second.Addr().String())
+
+	// Each server has its own state
+	first.states.Create("example.com")
+
+	resp := get(t, first, "/api/v1/domains/example.com/status")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = get(t, second, "/api/v1/domains/example.com/status")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	for _, srv := range []*Server{first, second} {
+		resp := get(t, srv, "/health")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var health map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+		assert.Equal(t, "healthy", health["status"])
+		assert.Equal(t, "test", health["version"])
+	}
+
+	// Debug endpoints are per server
+	assert.Equal(t, http.StatusOK, get(t, first, "/debug/state").StatusCode)
+	assert.Equal(t, http.StatusNotFound, get(t, second, "/debug/state").StatusCode)
+}
+
+func TestServer_HealthInMaintenance(t *testing.T) {
+	srv := newTestServer(t)
+	require.NoError(t, srv.maintenance.Enable("migrating customer shop.example.com"))
+
+	resp, err := http.Get("http://" + srv.Addr().String() + "/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var health map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, "maintenance", health["status"])
+	assert.NotContains(t, health, "maintenance", "the reason is not shown without a token")
+}
+
+func TestServer_StopEndsJobs(t *testing.T) {
+	srv := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Stop(ctx))
+	assert.ErrorIs(t, srv.ctx.Err(), context.Canceled)
+
+	_, err := http.Get("http://" + srv.Addr().String() + "/health")
+	assert.Error(t, err, "the listener is closed")
+}
+
+func TestDataFiles(t *testing.T) {
+	assert.Equal(t, "/data/quota.json", DataFile("/data/state.json", "quota.json"))
+	assert.Equal(t, "/data/snapshots.json", SnapshotFile("/data/state.json"))
+	assert.Equal(t, defaultSnapshotFile, SnapshotFile("/data/custom.json"))
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "w2b")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "whm2bunny.sock")
+
+	// A stale socket left by a crash is replaced
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	cfg := config.Defaults()
+	cfg.Server.Listen = "unix://" + socket
+	cfg.Server.SocketMode = "0600"
+	cfg.Certificates.Monitor = false
+	srv, err := New(&cfg, WithStateFile(filepath.Join(dir, "state.json")))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(t, socket, srv.Addr().String())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), ".whm2bunny.sock."), "the directory the socket was bound in is removed")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://whm2bunny/health")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Stop(ctx))
+
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "the socket is removed on shutdown")
+}
+
+func TestListen_RefusesLiveSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "w2b")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "whm2bunny.sock")
+
+	// Another daemon serves the socket
+	live, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer live.Close()
+
+	_, err = listen(config.ServerConfig{Listen: "unix://" + socket})
+	assert.ErrorContains(t, err, "in use by another process")
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err, "the live socket is left alone")
+	_ = conn.Close()
+}
+
+func TestListen_RefusesNonSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{}"), 0644))
+
+	_, err := listen(config.ServerConfig{Listen: "unix://" + path})
+	assert.ErrorContains(t, err, "not a socket")
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "the file is left alone")
+}
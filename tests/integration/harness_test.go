@@ -0,0 +1,303 @@
+//go:build integration
+
+// Package integration runs the whm2bunny binary end to end against a fake
+// Bunny API. Run with:
+//
+//	go test -tags integration ./tests/integration/
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/bunny/bunnytest"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+const secret = "integration-secret"
+
+// binary is the whm2bunny binary built by TestMain
+var binary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "whm2bunny-bin")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	binary = filepath.Join(dir, "whm2bunny")
+
+	build := exec.Command("go", "build", "-o", binary, "github.com/mordenhost/whm2bunny/cmd/whm2bunny")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to build whm2bunny:", err)
+		_ = os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// env is one whm2bunny installation: a config, a state directory and a
+// fake Bunny API, served by daemons started with start
+type env struct {
+	t      *testing.T
+	dir    string
+	config string
+	socket string
+	bunny  *bunnytest.Server
+	client *http.Client
+}
+
+// newEnv writes a config for a daemon listening on a Unix socket in a
+// temporary directory and using a fresh fake Bunny API
+func newEnv(t *testing.T) *env {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir
+	dir, err := os.MkdirTemp("", "w2b")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fake := bunnytest.NewServer()
+	t.Cleanup(fake.Close)
+
+	e := &env{
+		t:      t,
+		dir:    dir,
+		config: filepath.Join(dir, "config.yaml"),
+		socket: filepath.Join(dir, "whm2bunny.sock"),
+		bunny:  fake,
+	}
+	e.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", e.socket)
+			},
+		},
+	}
+
+	config := fmt.Sprintf(`server:
+  listen: "unix://%s"
+bunny:
+  api_key: "integration-key"
+  base_url: "%s"
+origin:
+  ip: "192.0.2.10"
+webhook:
+  secret: "%s"
+api:
+  enabled: true
+certificates:
+  monitor: false
+`, e.socket, fake.URL, secret)
+	require.NoError(t, os.WriteFile(e.config, []byte(config), 0600))
+
+	return e
+}
+
+// configure appends top-level YAML sections to the configuration; call it
+// before start
+func (e *env) configure(yaml string) {
+	e.t.Helper()
+
+	f, err := os.OpenFile(e.config, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(e.t, err)
+	defer f.Close()
+	_, err = f.WriteString(yaml)
+	require.NoError(e.t, err)
+}
+
+// daemon is a running whm2bunny process
+type daemon struct {
+	cmd  *exec.Cmd
+	out  *syncBuffer
+	done chan struct{}
+}
+
+// start runs `whm2bunny serve` and waits until it answers /health. The
+// daemon is killed when the test ends, and its log is shown if it failed
+func (e *env) start() *daemon {
+	e.t.Helper()
+
+	d := &daemon{out: &syncBuffer{}, done: make(chan struct{})}
+	d.cmd = exec.Command(binary, "serve", "--config", e.config)
+	d.cmd.Env = e.environ()
+	d.cmd.Stdout, d.cmd.Stderr = d.out, d.out
+	require.NoError(e.t, d.cmd.Start())
+	go func() {
+		_ = d.cmd.Wait()
+		close(d.done)
+	}()
+
+	e.t.Cleanup(func() {
+		d.kill()
+		if e.t.Failed() {
+			e.t.Logf("whm2bunny log:\n%s", d.out.String())
+		}
+	})
+
+	e.waitFor("daemon to answer /health", 15*time.Second, func() bool {
+		resp, err := e.client.Get("http://whm2bunny/health")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+	return d
+}
+
+// environ returns the environment of whm2bunny processes, which read the
+// configuration only from the config file
+func (e *env) environ() []string {
+	return append(os.Environ(),
+		"STATE_FILE="+filepath.Join(e.dir, "state.json"),
+		"BUNNY_API_KEY=",
+		"ORIGIN_IP=",
+		"WHM_HOOK_SECRET=",
+	)
+}
+
+// cli runs a whm2bunny command against the installation and returns its
+// output, failing the test if it fails
+func (e *env) cli(args ...string) string {
+	e.t.Helper()
+
+	cmd := exec.Command(binary, append(args, "--config", e.config)...)
+	cmd.Env = e.environ()
+	out, err := cmd.CombinedOutput()
+	require.NoError(e.t, err, "whm2bunny %s: %s", strings.Join(args, " "), out)
+	return string(out)
+}
+
+// kill stops the daemon with SIGKILL, as a crash or OOM kill would
+func (d *daemon) kill() {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	_ = d.cmd.Process.Kill()
+	<-d.done
+}
+
+// sendWebhook posts a signed WHM hook event
+func (e *env) sendWebhook(payload map[string]string) {
+	e.t.Helper()
+
+	body, err := json.Marshal(payload)
+	require.NoError(e.t, err)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, "http://whm2bunny/hook", bytes.NewReader(body))
+	require.NoError(e.t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Whm2bunny-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := e.client.Do(req)
+	require.NoError(e.t, err)
+	defer resp.Body.Close()
+	require.Less(e.t, resp.StatusCode, 300, "webhook rejected")
+}
+
+// status returns the provisioning state of domain from the management API,
+// or nil if the domain is unknown
+func (e *env) status(domain string) *state.ProvisionState {
+	e.t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "http://whm2bunny/api/v1/domains/"+domain+"/status", nil)
+	require.NoError(e.t, err)
+	req.Header.Set("Authorization", "Bearer "+secret)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var st state.ProvisionState
+	require.NoError(e.t, json.NewDecoder(resp.Body).Decode(&st))
+	return &st
+}
+
+// apiGet decodes the management API response to GET path into v
+func (e *env) apiGet(path string, v any) {
+	e.t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "http://whm2bunny"+path, nil)
+	require.NoError(e.t, err)
+	req.Header.Set("Authorization", "Bearer "+secret)
+
+	resp, err := e.client.Do(req)
+	require.NoError(e.t, err)
+	defer resp.Body.Close()
+	require.Equal(e.t, http.StatusOK, resp.StatusCode, "GET %s", path)
+	require.NoError(e.t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// waitForStatus waits until domain reaches status
+func (e *env) waitForStatus(domain string, status state.Status, timeout time.Duration) *state.ProvisionState {
+	e.t.Helper()
+
+	var st *state.ProvisionState
+	e.waitFor(fmt.Sprintf("%s to be %s", domain, status), timeout, func() bool {
+		st = e.status(domain)
+		return st != nil && st.Status == status
+	})
+	return st
+}
+
+// waitFor polls cond until it holds or timeout passes
+func (e *env) waitFor(what string, timeout time.Duration, cond func() bool) {
+	e.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			e.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// syncBuffer collects the daemon's output from its stdout and stderr
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
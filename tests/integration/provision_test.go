@@ -0,0 +1,606 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny/internal/api"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/incident"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/state"
+)
+
+// records indexes DNS records by type and name
+func records(list []bunny.DNSRecord) map[string]bunny.DNSRecord {
+	byKey := make(map[string]bunny.DNSRecord, len(list))
+	for _, r := range list {
+		byKey[r.Type.String()+" "+r.Name] = r
+	}
+	return byKey
+}
+
+func TestGoldenPath(t *testing.T) {
+	e := newEnv(t)
+	e.start()
+
+	fixture, err := os.ReadFile(filepath.Join("..", "fixtures", "webhooks", "account_created.json"))
+	require.NoError(t, err)
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(fixture, &payload))
+	domain := payload["domain"]
+
+	e.sendWebhook(payload)
+	st := e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+
+	zone, ok := e.bunny.DNSZone(domain)
+	require.True(t, ok, "DNS zone created")
+	assert.Equal(t, zone.ID, st.ZoneID)
+
+	pullZone, ok := e.bunny.PullZone("morden-example-com")
+	require.True(t, ok, "pull zone created")
+	assert.Equal(t, pullZone.ID, st.PullZoneID)
+	assert.Equal(t, "http://192.0.2.10", pullZone.OriginURL)
+	assert.True(t, pullZone.EnableGeoZoneASIA)
+	assert.Equal(t, "morden-example-com.b-cdn.net", st.CDNHostname)
+
+	got := records(e.bunny.DNSRecords(zone.ID))
+	assert.Equal(t, "192.0.2.10", got["A @"].Value)
+	assert.Equal(t, domain+".", got["CNAME www"].Value)
+	assert.Equal(t, "mail."+domain+".", got["MX @"].Value)
+	assert.Equal(t, "v=spf1 a mx -all", got["TXT @"].Value)
+	assert.Equal(t, st.CDNHostname, got["CNAME cdn"].Value)
+
+	// The same event again changes nothing
+	calls := e.bunny.Calls(http.MethodPost, "/pullzone")
+	e.sendWebhook(payload)
+	time.Sleep(time.Second)
+	assert.Equal(t, calls, e.bunny.Calls(http.MethodPost, "/pullzone"))
+	assert.Equal(t, state.StatusSuccess, e.status(domain).Status)
+}
+
+func TestApexViaCDN(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`dns:
+  record_strategy: apex_via_cdn
+`)
+	e.start()
+
+	const domain = "apex.example"
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": domain, "user": "apex"})
+	st := e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+
+	got := records(e.bunny.DNSRecords(st.ZoneID))
+	_, hasA := got["A @"]
+	assert.False(t, hasA, "apex A record replaced")
+	assert.Equal(t, st.CDNHostname, got["CNAME @"].Value)
+	assert.Equal(t, st.CDNHostname, got["CNAME www"].Value)
+	assert.Equal(t, st.CDNHostname, got["CNAME cdn"].Value)
+	assert.Equal(t, "mail."+domain+".", got["MX @"].Value)
+
+	pullZone, ok := e.bunny.PullZone("morden-apex-example")
+	require.True(t, ok, "pull zone created")
+	var hostnames, secured []string
+	for _, h := range pullZone.Hostnames {
+		hostnames = append(hostnames, h.Hostname)
+		if h.HasCertificate && h.ForceSSL {
+			secured = append(secured, h.Hostname)
+		}
+	}
+	assert.Subset(t, hostnames, []string{domain, "www." + domain, "cdn." + domain})
+	assert.ElementsMatch(t, []string{domain, "www." + domain, "cdn." + domain}, secured)
+}
+
+func TestZoneDisabled(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`zone_monitor:
+  interval: 200ms
+`)
+	e.start()
+
+	const domain = "suspended.example"
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": domain, "user": "suspended"})
+	st := e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+
+	health := func() map[string]interface{} {
+		resp, err := e.client.Get("http://whm2bunny/health")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	e.bunny.SetDNSZoneEnabled(domain, false)
+	e.waitFor("zone to be reported disabled", 10*time.Second, func() bool {
+		return health()["status"] == "degraded"
+	})
+	assert.Equal(t, float64(1), health()["dns_zones"].(map[string]interface{})["disabled"],
+		"the unauthenticated health check only counts the zones")
+
+	var list api.DisabledZonesResponse
+	e.apiGet("/api/v1/dns-zones/disabled", &list)
+	require.Len(t, list.Zones, 1)
+	zone := list.Zones[0]
+	assert.Equal(t, domain, zone.Domain)
+	assert.Equal(t, st.ZoneID, zone.ZoneID)
+	assert.Equal(t, provisioner.ZoneReasonDisabled, zone.Reason)
+
+	e.bunny.SetDNSZoneEnabled(domain, true)
+	e.waitFor("zone to be reported enabled", 10*time.Second, func() bool {
+		return health()["status"] == "healthy"
+	})
+}
+
+func TestFrozenDomain(t *testing.T) {
+	e := newEnv(t)
+	e.start()
+
+	const domain = "frozen.example"
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": domain, "user": "frozen"})
+	e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+
+	out := e.cli("freeze", domain, "--reason", "ticket 4711")
+	assert.Contains(t, out, domain)
+	assert.Contains(t, e.cli("freeze"), "ticket 4711")
+
+	// Events for the domain and its subdomains are ignored while frozen
+	subdomain := map[string]string{"event": "subdomain_created", "subdomain": "blog", "parent_domain": domain, "user": "frozen"}
+	e.sendWebhook(subdomain)
+	time.Sleep(time.Second)
+	assert.Nil(t, e.status("blog."+domain))
+
+	e.cli("unfreeze", domain)
+	e.sendWebhook(subdomain)
+	e.waitForStatus("blog."+domain, state.StatusSuccess, 30*time.Second)
+}
+
+func TestRecoveryAfterKill(t *testing.T) {
+	e := newEnv(t)
+	const domain = "crash.example"
+
+	// Stop Bunny answering the pull zone creation, then kill the daemon
+	// while it waits
+	reached, release := e.bunny.Hold(http.MethodPost, "/pullzone")
+	d := e.start()
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": domain, "user": "crash"})
+
+	select {
+	case <-reached:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the pull zone request")
+	}
+	st := e.status(domain)
+	require.NotNil(t, st)
+	assert.Equal(t, state.StatusProvisioning, st.Status)
+	assert.Equal(t, state.StepPullZone, st.CurrentStep)
+
+	d.kill()
+	release()
+
+	// The restarted daemon resumes at the pull zone step
+	e.start()
+	st = e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+
+	assert.Equal(t, 1, e.bunny.Calls(http.MethodPost, "/dns"), "DNS zone created once")
+	assert.Equal(t, 1, e.bunny.Calls(http.MethodPost, "/pullzone"), "pull zone created once")
+
+	zone, ok := e.bunny.DNSZone(domain)
+	require.True(t, ok)
+	assert.Equal(t, zone.ID, st.ZoneID)
+
+	// Records added before the kill are not added again
+	seen := make(map[string]int)
+	for _, r := range e.bunny.DNSRecords(zone.ID) {
+		seen[r.Type.String()+" "+r.Name]++
+	}
+	for key, n := range seen {
+		assert.Equal(t, 1, n, "record %s added once", key)
+	}
+	assert.Equal(t, 1, seen["CNAME cdn"])
+}
+
+func TestInventory(t *testing.T) {
+	e := newEnv(t)
+	userdata := filepath.Join(e.dir, "userdatadomains")
+	e.configure(fmt.Sprintf(`discovery:
+  userdata_domains: "%s"
+`, userdata))
+	d := e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "kept.example", "user": "kept"})
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "gone.example", "user": "gone"})
+	e.waitForStatus("kept.example", state.StatusSuccess, 30*time.Second)
+	e.waitForStatus("gone.example", state.StatusSuccess, 30*time.Second)
+	d.kill()
+
+	require.NoError(t, os.WriteFile(userdata, []byte(`kept.example: kept==root==main==kept.example==/home/kept/public_html==192.0.2.10:80==
+new.example: fresh==root==main==new.example==/home/fresh/public_html==192.0.2.10:80==
+shop.example: kept==root==addon==shop.kept.example==/home/kept/shop==192.0.2.10:80==
+shop.kept.example: kept==root==sub==kept.example==/home/kept/shop==192.0.2.10:80==
+alias.example: kept==root==parked==kept.example==/home/kept/public_html==192.0.2.10:80==
+`), 0600))
+
+	out := e.cli("inventory")
+	assert.Contains(t, out, "kept.example")
+	assert.Contains(t, out, "new.example")
+	assert.Contains(t, out, "shop.example")
+	assert.Contains(t, out, "gone.example")
+	assert.NotContains(t, out, "shop.kept.example", "addon backing subdomain is not listed")
+	assert.NotContains(t, out, "alias.example", "parked domain is not listed")
+
+	e.cli("inventory", "--provision-missing", "--deprovision-orphans")
+
+	e.start()
+	e.waitForStatus("new.example", state.StatusSuccess, 30*time.Second)
+	e.waitForStatus("shop.example", state.StatusSuccess, 30*time.Second)
+	assert.Nil(t, e.status("gone.example"))
+	_, ok := e.bunny.DNSZone("gone.example")
+	assert.False(t, ok, "orphaned zone removed")
+}
+
+func TestResellerSOAEmail(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`dns:
+  soa_emails:
+    acme: "hostmaster@acme.example"
+`)
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "brand.example", "user": "brand", "reseller": "acme"})
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "plain.example", "user": "plain", "reseller": "other"})
+	e.waitForStatus("brand.example", state.StatusSuccess, 30*time.Second)
+	e.waitForStatus("plain.example", state.StatusSuccess, 30*time.Second)
+
+	brand, ok := e.bunny.DNSZone("brand.example")
+	require.True(t, ok, "zone created")
+	assert.Equal(t, "hostmaster@acme.example", brand.SoaEmail)
+	plain, ok := e.bunny.DNSZone("plain.example")
+	require.True(t, ok, "zone created")
+	assert.Equal(t, "hostmaster@mordenhost.com", plain.SoaEmail)
+
+	// Zones changed outside whm2bunny are brought back in bulk
+	client := bunny.NewClient("integration-key", bunny.WithBaseURL(e.bunny.URL))
+	require.NoError(t, client.UpdateDNSZone(context.Background(), brand.ID, &bunny.UpdateDNSZoneRequest{SoaEmail: "old@acme.example"}))
+
+	out := e.cli("dns", "update-soa", "--reseller", "acme")
+	assert.Contains(t, out, "brand.example")
+	assert.NotContains(t, out, "plain.example")
+
+	brand, _ = e.bunny.DNSZone("brand.example")
+	assert.Equal(t, "hostmaster@acme.example", brand.SoaEmail)
+	plain, _ = e.bunny.DNSZone("plain.example")
+	assert.Equal(t, "hostmaster@mordenhost.com", plain.SoaEmail)
+}
+
+func TestObserveOnly(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`provisioning:
+  enabled: false
+`)
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "trial.example", "user": "trial"})
+	e.sendWebhook(map[string]string{"event": "subdomain_created", "subdomain": "blog", "parent_domain": "trial.example", "user": "trial"})
+
+	var out string
+	e.waitFor("domains to be observed", 10*time.Second, func() bool {
+		out = e.cli("observed")
+		return strings.Contains(out, "blog.trial.example")
+	})
+	assert.Contains(t, out, "trial.example")
+	assert.Contains(t, out, "morden-trial-example")
+
+	assert.Zero(t, e.bunny.TotalCalls(), "Bunny is never called")
+	assert.Nil(t, e.status("trial.example"))
+
+	assert.Contains(t, e.cli("observed", "--clear"), "2")
+	assert.NotContains(t, e.cli("observed"), "trial.example")
+}
+
+func TestIncidentAck(t *testing.T) {
+	e := newEnv(t)
+
+	// An incident the hourly zone check opened before the restart
+	store, err := incident.NewStore(filepath.Join(e.dir, "incidents.json"), nil)
+	require.NoError(t, err)
+	_, _, err = store.Fire(incident.KindOriginHealth, 7, "morden-shop-example", 12, 5)
+	require.NoError(t, err)
+
+	e.start()
+
+	health := func() map[string]interface{} {
+		resp, err := e.client.Get("http://whm2bunny/health")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body["incidents"].(map[string]interface{})
+	}
+	incidents := health()
+	assert.Equal(t, float64(1), incidents["open"])
+	assert.Equal(t, float64(1), incidents["unacknowledged"])
+	assert.NotContains(t, incidents, "active", "the unauthenticated health check only counts incidents")
+
+	assert.Contains(t, e.cli("incident", "list"), "INC-1")
+	assert.Contains(t, e.cli("incident", "ack", "inc-1", "--by", "alice"), "INC-1 acknowledged")
+
+	incidents = health()
+	assert.Equal(t, float64(1), incidents["open"])
+	assert.Equal(t, float64(0), incidents["unacknowledged"], "the server sees the acknowledgement of the CLI")
+	assert.Contains(t, e.cli("incident", "list"), "alice")
+}
+
+func TestZoneExportImport(t *testing.T) {
+	e := newEnv(t)
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "backup.example", "user": "backup"})
+	e.waitForStatus("backup.example", state.StatusSuccess, 30*time.Second)
+	zone, ok := e.bunny.DNSZone("backup.example")
+	require.True(t, ok, "zone created")
+	want := len(e.bunny.DNSRecords(zone.ID))
+
+	file := filepath.Join(e.dir, "backup.example.zone")
+	assert.Contains(t, e.cli("dns", "export", "backup.example", "--out", file), fmt.Sprintf("%d record(s) exported", want))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "$ORIGIN backup.example.")
+
+	// The zone is lost, then restored from the backup
+	client := bunny.NewClient("integration-key", bunny.WithBaseURL(e.bunny.URL))
+	require.NoError(t, client.DeleteDNSZone(context.Background(), zone.ID))
+
+	out := e.cli("dns", "import", "backup.example", file, "--dry-run")
+	assert.Contains(t, out, "dry run")
+	_, ok = e.bunny.DNSZone("backup.example")
+	assert.False(t, ok, "a dry run changes nothing")
+
+	out = e.cli("dns", "import", "backup.example", file)
+	assert.Contains(t, out, "DNS zone created")
+	restored, ok := e.bunny.DNSZone("backup.example")
+	require.True(t, ok, "zone recreated")
+	assert.Len(t, e.bunny.DNSRecords(restored.ID), want)
+
+	// Importing the same file again changes nothing
+	out = e.cli("dns", "import", "backup.example", file)
+	assert.Contains(t, out, fmt.Sprintf("0 record(s) changed, %d unchanged", want))
+}
+
+func TestBackupRestore(t *testing.T) {
+	// Bunny Storage, in memory
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/backups/")
+		switch {
+		case r.Method == http.MethodPut:
+			stored[path], _ = io.ReadAll(r.Body)
+		case r.Method == http.MethodGet && strings.HasSuffix(path, "/"):
+			var listed []map[string]any
+			for name, data := range stored {
+				listed = append(listed, map[string]any{"ObjectName": strings.TrimPrefix(name, path), "Length": len(data)})
+			}
+			_ = json.NewEncoder(w).Encode(listed)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write(stored[path])
+		}
+	}))
+	t.Cleanup(storage.Close)
+
+	e := newEnv(t)
+	keyFile := filepath.Join(e.dir, "state.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600))
+	e.configure(fmt.Sprintf(`encryption:
+  enabled: true
+  key_file: %s
+backup:
+  enabled: true
+  storage: bunny
+  bunny_storage:
+    zone: backups
+    password: secret
+    endpoint: %s
+`, keyFile, storage.URL))
+	d := e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "saved.example", "user": "saved"})
+	e.waitForStatus("saved.example", state.StatusSuccess, 30*time.Second)
+	zone, ok := e.bunny.DNSZone("saved.example")
+	require.True(t, ok, "zone created")
+	want := len(e.bunny.DNSRecords(zone.ID))
+
+	out := e.cli("backup", "run")
+	assert.Contains(t, out, "uploaded")
+	assert.Contains(t, e.cli("backup", "list"), "whm2bunny-")
+
+	// The server and its zone are lost, then restored from the backup
+	d.kill()
+	require.NoError(t, os.RemoveAll(filepath.Join(e.dir, "state.d")))
+	client := bunny.NewClient("integration-key", bunny.WithBaseURL(e.bunny.URL))
+	require.NoError(t, client.DeleteDNSZone(context.Background(), zone.ID))
+
+	out = e.cli("restore", "--from-remote", "--zones")
+	assert.Contains(t, out, "Restore complete")
+	restored, ok := e.bunny.DNSZone("saved.example")
+	require.True(t, ok, "zone recreated")
+	assert.Len(t, e.bunny.DNSRecords(restored.ID), want)
+
+	e.start()
+	assert.Equal(t, state.StatusSuccess, e.status("saved.example").Status)
+}
+
+func TestStatusLink(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`status_links:
+  enabled: true
+  secret: "integration-status-link-secret-0123456789"
+  base_url: "https://cdn-status.example.net"
+`)
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "linked.example", "user": "linked"})
+	e.waitForStatus("linked.example", state.StatusSuccess, 30*time.Second)
+
+	out := e.cli("status-link", "linked.example", "--ttl", "1h")
+	link, _, _ := strings.Cut(out, "\n")
+	path, ok := strings.CutPrefix(link, "https://cdn-status.example.net")
+	require.True(t, ok, out)
+
+	get := func(path string) (int, map[string]any) {
+		resp, err := e.client.Get("http://whm2bunny" + path + "&format=json")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	code, page := get(path)
+	require.Equal(t, http.StatusOK, code, page)
+	assert.Equal(t, "complete", page["state"])
+	assert.NotContains(t, page, "user")
+
+	// The signature does not carry over to another domain
+	code, _ = get(strings.Replace(path, "linked.example", "other.example", 1))
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestCanonicalHost(t *testing.T) {
+	e := newEnv(t)
+	e.configure(`dns:
+  record_strategy: apex_via_cdn
+profiles:
+  default: site
+  definitions:
+    site:
+      canonical_host: apex
+`)
+	e.start()
+
+	const domain = "canonical.example"
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": domain, "user": "canonical"})
+	st := e.waitForStatus(domain, state.StatusSuccess, 30*time.Second)
+	assert.Equal(t, "apex", st.CanonicalHost)
+
+	rule := func() *bunny.EdgeRule {
+		pullZone, ok := e.bunny.PullZone("morden-canonical-example")
+		require.True(t, ok, "pull zone created")
+		return pullZone.FindEdgeRule("whm2bunny canonical host redirect")
+	}
+	r := rule()
+	require.NotNil(t, r, "redirect edge rule added")
+	assert.Equal(t, "https://canonical.example%{Url.Path}", r.ActionParameter1)
+	assert.Equal(t, "301", r.ActionParameter2)
+	assert.Equal(t, []string{"*://www.canonical.example/*"}, r.Triggers[0].PatternMatches)
+
+	assert.Contains(t, e.cli("canonical-host", domain, "www"), "now www")
+	r = rule()
+	require.NotNil(t, r)
+	assert.Equal(t, "https://www.canonical.example%{Url.Path}", r.ActionParameter1)
+	assert.Equal(t, []string{"*://canonical.example/*"}, r.Triggers[0].PatternMatches)
+	assert.Contains(t, e.cli("canonical-host", domain), "www")
+
+	e.cli("canonical-host", domain, "none")
+	assert.Nil(t, rule(), "redirect edge rule removed")
+	assert.Contains(t, e.cli("canonical-host", domain), "none")
+}
+
+func TestPauseResume(t *testing.T) {
+	e := newEnv(t)
+	e.start()
+
+	health := func() map[string]interface{} {
+		resp, err := e.client.Get("http://whm2bunny/health")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	assert.Contains(t, e.cli("pause", "--reason", "bad template"), "PAUSED")
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "paused.example", "user": "paused"})
+
+	time.Sleep(time.Second)
+	assert.Nil(t, e.status("paused.example"), "queued events wait")
+	body := health()
+	assert.Equal(t, "paused", body["status"])
+	assert.NotContains(t, body, "pause", "the reason and actor are shown by the API only")
+	assert.Equal(t, float64(1), body["queue"].(map[string]interface{})["webhooks"])
+	assert.Zero(t, e.bunny.Calls(http.MethodPost, "/dnszone"))
+
+	assert.Contains(t, e.cli("resume"), "resumed")
+	e.waitForStatus("paused.example", state.StatusSuccess, 30*time.Second)
+	assert.Equal(t, "healthy", health()["status"])
+}
+
+func TestInstanceName(t *testing.T) {
+	e := newEnv(t)
+	e.configure("instance: cpanel-07\n")
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "fleet.example", "user": "fleet"})
+	st := e.waitForStatus("fleet.example", state.StatusSuccess, 30*time.Second)
+	assert.Equal(t, "cpanel-07", st.Server)
+
+	resp, err := e.client.Get("http://whm2bunny/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "cpanel-07", resp.Header.Get("X-Whm2bunny-Server"))
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "cpanel-07", body["server"])
+
+	assert.Contains(t, e.cli("state", "show", "--server", "cpanel-07"), "fleet.example")
+	assert.NotContains(t, e.cli("state", "show", "--server", "cpanel-03"), "fleet.example")
+}
+
+func TestDNSRecordToggle(t *testing.T) {
+	e := newEnv(t)
+	e.start()
+
+	e.sendWebhook(map[string]string{"event": "account_created", "domain": "toggle.example", "user": "toggle"})
+	st := e.waitForStatus("toggle.example", state.StatusSuccess, 30*time.Second)
+
+	assert.Contains(t, e.cli("dns", "disable", "toggle.example", "@", "--type", "MX", "--reason", "mail migration"), "Disabled MX")
+	mx := records(e.bunny.DNSRecords(st.ZoneID))["MX @"]
+	assert.False(t, mx.Enabled, "the record is kept, disabled")
+	assert.Contains(t, e.cli("dns", "records", "toggle.example"), "mail migration")
+
+	assert.Contains(t, e.cli("dns", "enable", "toggle.example", fmt.Sprint(mx.ID)), "Enabled MX")
+	assert.True(t, records(e.bunny.DNSRecords(st.ZoneID))["MX @"].Enabled)
+
+	// A bypass disables the CDN CNAME and restore enables it again
+	e.cli("emergency", "bypass", "toggle.example", "--reason", "CDN outage")
+	got := records(e.bunny.DNSRecords(st.ZoneID))
+	assert.False(t, got["CNAME cdn"].Enabled)
+	assert.Equal(t, "192.0.2.10", got["A cdn"].Value)
+	assert.Contains(t, e.cli("dns", "records", "toggle.example"), "emergency bypass: CDN outage")
+
+	e.cli("emergency", "restore", "toggle.example")
+	got = records(e.bunny.DNSRecords(st.ZoneID))
+	assert.True(t, got["CNAME cdn"].Enabled)
+	assert.Equal(t, st.CDNHostname, got["CNAME cdn"].Value)
+	assert.NotContains(t, got, "A cdn")
+	assert.NotContains(t, e.cli("dns", "records", "toggle.example"), "DISABLED")
+}
@@ -0,0 +1,326 @@
+// Package whm2bunny provisions domains on Bunny.net from other Go programs,
+// the way the WHM hook and the CLI do, without shelling out to the CLI or
+// running the server. It is a thin facade over the internal packages: the
+// types here are stable while the internals change.
+//
+// A Client shares the state file and data files with "whm2bunny serve" and
+// the CLI. The running server keeps active states in memory, so use its
+// management API instead for domains it may be provisioning at the same
+// time.
+package whm2bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/app"
+	"github.com/mordenhost/whm2bunny/internal/bunny"
+	"github.com/mordenhost/whm2bunny/internal/bypass"
+	"github.com/mordenhost/whm2bunny/internal/certs"
+	"github.com/mordenhost/whm2bunny/internal/dnsrecords"
+	"github.com/mordenhost/whm2bunny/internal/domainname"
+	"github.com/mordenhost/whm2bunny/internal/encryption"
+	"github.com/mordenhost/whm2bunny/internal/freeze"
+	"github.com/mordenhost/whm2bunny/internal/notifier"
+	"github.com/mordenhost/whm2bunny/internal/overrides"
+	"github.com/mordenhost/whm2bunny/internal/provisioner"
+	"github.com/mordenhost/whm2bunny/internal/state"
+	"github.com/mordenhost/whm2bunny/internal/tokenauth"
+	"github.com/mordenhost/whm2bunny/server"
+)
+
+var (
+	// ErrNotFound is returned for domains that were never provisioned
+	ErrNotFound = errors.New("domain not found")
+	// ErrNoPullZone is returned for operations on a domain without a pull zone
+	ErrNoPullZone = app.ErrNoPullZone
+)
+
+// Options configures a Client
+type Options struct {
+	// StateFile is the state file; the other data files are kept next to
+	// it. Defaults to the state file of "whm2bunny serve"
+	StateFile string
+	// Logger receives the provisioning logs; nil discards them
+	Logger *zap.Logger
+	// HTTPClient makes the Bunny API calls; nil uses one through the
+	// configured egress proxy
+	HTTPClient *http.Client
+}
+
+// Status is the provisioning status of a domain
+type Status string
+
+// Provisioning statuses
+const (
+	StatusPending      Status = Status(state.StatusPending)
+	StatusProvisioning Status = Status(state.StatusProvisioning)
+	StatusSuccess      Status = Status(state.StatusSuccess)
+	StatusFailed       Status = Status(state.StatusFailed)
+)
+
+// Domain is a provisioned domain or subdomain
+type Domain struct {
+	Name string `json:"name"`
+	// Parent is set for subdomains
+	Parent string `json:"parent,omitempty"`
+	User   string `json:"user,omitempty"`
+	// Package is the WHM package selecting the domain's CDN profile
+	Package string `json:"package,omitempty"`
+	Status  Status `json:"status"`
+	// Error is the reason of the last failure
+	Error       string    `json:"error,omitempty"`
+	ZoneID      int64     `json:"zone_id,omitempty"`
+	PullZoneID  int64     `json:"pull_zone_id,omitempty"`
+	CDNHostname string    `json:"cdn_hostname,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProvisionRequest describes a domain to provision
+type ProvisionRequest struct {
+	// Domain is the full domain name
+	Domain string
+	// Parent is set for subdomains of a provisioned domain
+	Parent string
+	// User is the WHM user owning the domain
+	User string
+	// Package is the WHM package selecting the domain's CDN profile
+	Package string
+}
+
+// Usage is a domain's traffic over a period
+type Usage struct {
+	Domain string    `json:"domain"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Bandwidth is in bytes
+	Bandwidth   int64 `json:"bandwidth"`
+	Requests    int64 `json:"requests"`
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+	// CacheHitRate is a percentage
+	CacheHitRate float64 `json:"cache_hit_rate"`
+}
+
+// Provisioner is implemented by Client, so programs can substitute it in
+// their tests
+type Provisioner interface {
+	Provision(req ProvisionRequest) (*Domain, error)
+	Domain(name string) (*Domain, error)
+	Domains() []Domain
+	SetPackage(ctx context.Context, name, pkg string) error
+	Usage(ctx context.Context, name string, from, to time.Time) (*Usage, error)
+	Purge(ctx context.Context, name string, urls []string) error
+	Deprovision(name string) error
+}
+
+// Client provisions and manages domains
+type Client struct {
+	provisioner *provisioner.Provisioner
+	states      *state.Manager
+	service     *app.Service
+}
+
+var _ Provisioner = (*Client)(nil)
+
+// New creates a client for a validated configuration, such as one returned
+// by config.Load
+func New(cfg *config.Config, opts Options) (*Client, error) {
+	if opts.StateFile == "" {
+		opts.StateFile = server.DefaultStateFile
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.HTTPClient == nil {
+		bunnyProxy, err := cfg.Proxy.Proxy(config.ProxyBunny)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Bunny proxy: %w", err)
+		}
+		opts.HTTPClient = bunnyProxy.HTTPClient(bunny.DefaultTimeout)
+	}
+	dataFile := func(name string) string {
+		return server.DataFile(opts.StateFile, name)
+	}
+
+	client := bunny.NewClient(cfg.Bunny.APIKey,
+		bunny.WithBaseURL(cfg.Bunny.BaseURL),
+		bunny.WithHTTPClient(opts.HTTPClient),
+		bunny.WithLogger(opts.Logger),
+	)
+
+	keyring, err := encryption.Load(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+
+	stateMgr, err := state.NewManager(opts.StateFile, opts.Logger, state.WithKeyring(keyring), state.WithServer(cfg.InstanceName()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	overrideMgr, err := overrides.NewManager(dataFile("overrides.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load overrides: %w", err)
+	}
+
+	tokenKeys, err := tokenauth.NewKeyStore(dataFile("token_keys.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token keys: %w", err)
+	}
+
+	bypassStore, err := bypass.NewStore(dataFile("bypass.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bypass state: %w", err)
+	}
+
+	certStore, err := certs.NewStore(dataFile("certificates.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificates: %w", err)
+	}
+
+	freezeStore, err := freeze.NewStore(dataFile("freeze.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load frozen domains: %w", err)
+	}
+
+	disabledRecords, err := dnsrecords.NewStore(dataFile("dns_records.json"), opts.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load disabled DNS records: %w", err)
+	}
+
+	prov := provisioner.NewProvisioner(cfg, client, stateMgr, &notifier.TelegramNotifier{}, opts.Logger)
+	prov.SetOverrides(overrideMgr)
+	prov.SetTokenKeys(tokenKeys)
+	prov.SetBypass(bypassStore)
+	prov.SetCertificates(certStore)
+	prov.SetFreeze(freezeStore)
+	prov.SetDisabledRecords(disabledRecords)
+
+	service := app.NewService(prov, stateMgr, client, opts.Logger)
+	if snapshots, err := state.NewSnapshotStore(server.SnapshotFile(opts.StateFile), opts.Logger, state.WithKeyring(keyring)); err == nil {
+		service.SetSnapshots(snapshots)
+	}
+
+	return &Client{
+		provisioner: prov,
+		states:      stateMgr,
+		service:     service,
+	}, nil
+}
+
+// Provision provisions a domain or subdomain and waits for the result. A
+// domain already provisioned is returned as it is
+func (c *Client) Provision(req ProvisionRequest) (*Domain, error) {
+	err := c.service.ProvisionDomain(app.ProvisionRequest{
+		Domain:       req.Domain,
+		ParentDomain: req.Parent,
+		User:         req.User,
+		Package:      req.Package,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Domain(req.Domain)
+}
+
+// Domain returns a domain's provisioning state, including archived domains
+func (c *Client) Domain(name string) (*Domain, error) {
+	st, err := c.provisioner.DomainState(domainname.Normalize(name))
+	if err != nil {
+		return nil, wrapError(name, err)
+	}
+	d := domainFromState(st)
+	return &d, nil
+}
+
+// Domains returns every domain with an active state
+func (c *Client) Domains() []Domain {
+	states := c.states.ListAll()
+	domains := make([]Domain, 0, len(states))
+	for _, st := range states {
+		domains = append(domains, domainFromState(st))
+	}
+	return domains
+}
+
+// SetPackage changes a provisioned domain's package and applies the
+// settings of its new CDN profile
+func (c *Client) SetPackage(ctx context.Context, name, pkg string) error {
+	_, err := c.provisioner.SetPackage(ctx, domainname.Normalize(name), pkg)
+	return wrapError(name, err)
+}
+
+// Usage returns a domain's traffic between from and to, as reported by Bunny
+func (c *Client) Usage(ctx context.Context, name string, from, to time.Time) (*Usage, error) {
+	report, err := c.service.Report(ctx, domainname.Normalize(name), from, to)
+	if err != nil {
+		return nil, wrapError(name, err)
+	}
+	return &Usage{
+		Domain:       report.Domain,
+		From:         report.From,
+		To:           report.To,
+		Bandwidth:    report.Bandwidth,
+		Requests:     report.Requests,
+		CacheHits:    report.CacheHits,
+		CacheMisses:  report.CacheMisses,
+		CacheHitRate: report.CacheHitRate,
+	}, nil
+}
+
+// Purge purges a domain's CDN cache, or only the given URLs. URLs starting
+// with / are taken relative to https://<domain>
+func (c *Client) Purge(ctx context.Context, name string, urls []string) error {
+	return wrapError(name, c.service.Purge(ctx, domainname.Normalize(name), urls))
+}
+
+// Deprovision removes a domain's Bunny resources, or a subdomain's records
+// and pull zone
+func (c *Client) Deprovision(name string) error {
+	d, err := c.Domain(name)
+	if err != nil {
+		return err
+	}
+	if d.Parent == "" {
+		return c.provisioner.Deprovision(d.Name)
+	}
+	return c.provisioner.RemoveSubdomain(strings.TrimSuffix(d.Name, "."+d.Parent), d.Parent)
+}
+
+// wrapError returns the errors of the internal packages for unknown domains
+// and domains without a pull zone as ErrNotFound and ErrNoPullZone
+func wrapError(name string, err error) error {
+	switch {
+	case errors.Is(err, state.ErrStateNotFound):
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	case errors.Is(err, provisioner.ErrNotProvisioned):
+		return fmt.Errorf("%s: %w", name, ErrNoPullZone)
+	}
+	return err
+}
+
+// domainFromState converts a provisioning state
+func domainFromState(st *state.ProvisionState) Domain {
+	return Domain{
+		Name:        st.Domain,
+		Parent:      st.ParentDomain,
+		User:        st.User,
+		Package:     st.Package,
+		Status:      Status(st.Status),
+		Error:       st.Error,
+		ZoneID:      st.ZoneID,
+		PullZoneID:  st.PullZoneID,
+		CDNHostname: st.CDNHostname,
+		CreatedAt:   st.CreatedAt,
+		UpdatedAt:   st.UpdatedAt,
+	}
+}
@@ -0,0 +1,72 @@
+package whm2bunny_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mordenhost/whm2bunny"
+	"github.com/mordenhost/whm2bunny/config"
+	"github.com/mordenhost/whm2bunny/internal/bunny/bunnytest"
+)
+
+// newTestClient creates a client with its state in a temporary directory
+// and a fake Bunny API
+func newTestClient(t *testing.T) (*whm2bunny.Client, *bunnytest.Server) {
+	t.Helper()
+
+	fake := bunnytest.NewServer()
+	t.Cleanup(fake.Close)
+
+	cfg := config.Defaults()
+	cfg.Bunny.APIKey = "test-key"
+	cfg.Bunny.BaseURL = fake.URL
+	cfg.Origin.IP = "192.0.2.10"
+
+	client, err := whm2bunny.New(&cfg, whm2bunny.Options{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	})
+	require.NoError(t, err)
+	return client, fake
+}
+
+func TestClient_Lifecycle(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	d, err := client.Provision(whm2bunny.ProvisionRequest{Domain: "Example.com", User: "exampleu"})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", d.Name)
+	assert.Equal(t, whm2bunny.StatusSuccess, d.Status)
+	assert.Equal(t, "exampleu", d.User)
+	assert.NotZero(t, d.PullZoneID)
+
+	sub, err := client.Provision(whm2bunny.ProvisionRequest{Domain: "blog.example.com", Parent: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", sub.Parent)
+	assert.Len(t, client.Domains(), 2)
+
+	require.NoError(t, client.Deprovision("blog.example.com"))
+	require.NoError(t, client.Deprovision("example.com"))
+	_, ok := fake.DNSZone("example.com")
+	assert.False(t, ok)
+}
+
+func TestClient_Errors(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	_, err := client.Domain("missing.com")
+	assert.ErrorIs(t, err, whm2bunny.ErrNotFound)
+	assert.ErrorIs(t, client.Deprovision("missing.com"), whm2bunny.ErrNotFound)
+
+	_, err = client.Usage(context.Background(), "missing.com", time.Now().Add(-time.Hour), time.Now())
+	assert.ErrorIs(t, err, whm2bunny.ErrNotFound)
+
+	assert.ErrorIs(t, client.Purge(context.Background(), "missing.com", nil), whm2bunny.ErrNotFound)
+
+	_, err = client.Provision(whm2bunny.ProvisionRequest{Domain: "blog.other.com", Parent: "example.com"})
+	assert.Error(t, err)
+}